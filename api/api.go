@@ -0,0 +1,102 @@
+// Package api holds the request and response types of the GoFirestoreApp
+// HTTP API. The server encodes these types and the client package decodes
+// them, so both ends agree on the wire format.
+package api
+
+// User is a user document: the body of creates and updates, and the
+// Firestore document the server stores
+type User struct {
+	Name       string                 `json:"name" firestore:"name"`
+	Email      string                 `json:"email" firestore:"email"`
+	Plan       Plan                   `json:"plan,omitempty" firestore:"plan,omitempty"`
+	AvatarURL  string                 `json:"avatarUrl,omitempty" firestore:"-"`                     // computed, never stored
+	Attributes map[string]interface{} `json:"attributes,omitempty" firestore:"attributes,omitempty"` // free-form profile data
+}
+
+// Plan is a user's billing tier
+type Plan string
+
+const (
+	PlanFree       Plan = "free"
+	PlanPro        Plan = "pro"
+	PlanEnterprise Plan = "enterprise"
+)
+
+// UserEnvelope is the legacy single-user body: {"id", "message", "user", "links"}
+type UserEnvelope struct {
+	ID       string            `json:"id"`
+	Message  string            `json:"message,omitempty"`
+	User     interface{}       `json:"user,omitempty"` // a User, or its ?fields= projection
+	Links    map[string]string `json:"links,omitempty"`
+	Archived bool              `json:"archived,omitempty"`
+}
+
+// UserListEntry is one element of the legacy /listUsers array. A malformed
+// document under ?onMalformed=include carries its raw data instead of a user.
+type UserListEntry struct {
+	ID              string                 `json:"id"`
+	User            interface{}            `json:"user,omitempty"`
+	Malformed       bool                   `json:"_malformed,omitempty"`
+	MalformedFields []string               `json:"malformedFields,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+}
+
+// MessageResponse acknowledges an action on a resource that has no body of its own
+type MessageResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id,omitempty"`
+}
+
+// UserResponse is the flat user representation of the v1 API. The
+// unversioned routes keep the legacy {"id", "user": {...}} nesting.
+type UserResponse struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Email      string                 `json:"email"`
+	Plan       Plan                   `json:"plan,omitempty"`
+	AvatarURL  string                 `json:"avatarUrl,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	CreatedAt  Timestamp              `json:"createdAt"`
+	UpdatedAt  Timestamp              `json:"updatedAt"`
+	Links      map[string]string      `json:"links,omitempty"`
+
+	// pending, succeeded or failed when an enrichment hook is configured
+	EnrichmentStatus string `json:"enrichmentStatus,omitempty"`
+
+	// Set instead of the user fields for a malformed document under
+	// ?onMalformed=include
+	Malformed       bool                   `json:"_malformed,omitempty"`
+	MalformedFields []string               `json:"malformedFields,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+}
+
+// UserListResponse is the v1 pagination envelope for users
+type UserListResponse struct {
+	Users         []UserResponse    `json:"users"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+	PrevPageToken string            `json:"prevPageToken,omitempty"`
+	Links         map[string]string `json:"links,omitempty"`
+	Warning       string            `json:"warning,omitempty"` // e.g. users left out of the ordering
+}
+
+// UserSyncResponse is one page of GET /users/sync. The last page carries
+// SyncToken, the since to send next time; earlier pages carry
+// NextPageToken instead.
+type UserSyncResponse struct {
+	Changes       []UserChange      `json:"changes"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+	SyncToken     string            `json:"syncToken,omitempty"`
+	Links         map[string]string `json:"links,omitempty"`
+	Warning       string            `json:"warning,omitempty"`
+}
+
+// UserChange is a user written or deleted since the last sync. Changes
+// come oldest first and a user can appear more than once, so apply them
+// in order; a deleted user has Deleted set and no User.
+type UserChange struct {
+	ID      string        `json:"id"`
+	At      Timestamp     `json:"at"` // stored updatedAt, or when the user was deleted
+	Deleted bool          `json:"deleted,omitempty"`
+	Reason  string        `json:"reason,omitempty"` // for a deletion: deleted, archived or merged
+	User    *UserResponse `json:"user,omitempty"`
+}
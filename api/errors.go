@@ -0,0 +1,100 @@
+package api
+
+import "encoding/json"
+
+// Machine-readable error codes, sent in X-Error-Code and a problem
+// document's "code". They are the keys of the server's i18n catalog and
+// never change meaning.
+const (
+	CodeAdminDisabled           = "admin_disabled"
+	CodeBigqueryNotConfigured   = "bigquery_not_configured"
+	CodeBodyTooLarge            = "body_too_large"
+	CodeBudgetExhausted         = "budget_exhausted"
+	CodeChangedSinceLastEdit    = "changed_since_last_edit"
+	CodeCollectionNotAllowed    = "collection_not_allowed"
+	CodeConflict                = "conflict"
+	CodeCsrfFailed              = "csrf_failed"
+	CodeEmailTaken              = "email_taken"
+	CodeEnrichmentNotConfigured = "enrichment_not_configured"
+	CodeEventNotFound           = "event_not_found"
+	CodeGeneratorDisabled       = "generator_disabled"
+	CodeInternal                = "internal"
+	CodeInvalidArgument         = "invalid_argument"
+	CodeInvalidBody             = "invalid_body"
+	CodeInvalidField            = "invalid_field"
+	CodeInvalidPageToken        = "invalid_page_token"
+	CodeInvalidPatch            = "invalid_patch"
+	CodeJobNotFound             = "job_not_found"
+	CodeMalformedDocument       = "malformed_document"
+	CodeMethodNotAllowed        = "method_not_allowed"
+	CodeMigrationRunning        = "migration_running"
+	CodeMissingParameter        = "missing_parameter"
+	CodeNothingToUndo           = "nothing_to_undo"
+	CodeNotificationNotFound    = "notification_not_found"
+	CodeOverloaded              = "overloaded"
+	CodePatchTestFailed         = "patch_test_failed"
+	CodePreconditionFailed      = "precondition_failed"
+	CodeProductionUnconfirmed   = "production_unconfirmed"
+	CodeQuotaExceeded           = "quota_exceeded"
+	CodeRecordingNotFound       = "recording_not_found"
+	CodeSearchNotConfigured     = "search_not_configured"
+	CodeSearchUnavailable       = "search_unavailable"
+	CodeSheetsAccess            = "sheets_access"
+	CodeSheetsUnavailable       = "sheets_unavailable"
+	CodeSpreadsheetNotFound     = "spreadsheet_not_found"
+	CodeSyncExpired             = "sync_expired"
+	CodeTooManyMatches          = "too_many_matches"
+	CodeTTLAccess               = "ttl_access"
+	CodeUnauthenticated         = "unauthenticated"
+	CodeUnknownField            = "unknown_field"
+	CodeUnsupportedMediaType    = "unsupported_media_type"
+	CodeUserLimitReached        = "user_limit_reached"
+	CodeUserNotFound            = "user_not_found"
+	CodeUserProtected           = "user_protected"
+	CodeVersionNotFound         = "version_not_found"
+	CodeWebhookNotFound         = "webhook_not_found"
+	CodeWorkspaceNotConfigured  = "workspace_not_configured"
+	CodeWriteThrottled          = "write_throttled"
+)
+
+// FieldError is a field-level validation problem attached to an error response
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the RFC 7807 problem document. Extra holds the
+// members particular to one error (such as a conflict's current
+// version); the standard members win over them.
+type ErrorResponse struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+func (e ErrorResponse) MarshalJSON() ([]byte, error) {
+	type plain ErrorResponse
+	raw, err := json.Marshal(plain(e))
+	if err != nil || len(e.Extra) == 0 {
+		return raw, err
+	}
+	var standard map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &standard); err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(e.Extra)+len(standard))
+	for k, v := range e.Extra {
+		merged[k] = v
+	}
+	for k, v := range standard {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
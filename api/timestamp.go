@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timestamp is a time.Time for request and response bodies that aren't
+// stored directly: it only accepts RFC 3339 ("Z" or an offset, any
+// sub-second precision) and always emits UTC. Documents keep plain
+// time.Time fields, which the Firestore client stores as timestamps.
+type Timestamp struct {
+	time.Time
+}
+
+// TimestampError is a timestamp that isn't RFC 3339
+type TimestampError struct {
+	value string
+	err   error
+}
+
+func (e *TimestampError) Error() string {
+	return fmt.Sprintf("%q is not an RFC 3339 timestamp: %v", e.value, e.err)
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+func (t *Timestamp) UnmarshalJSON(raw []byte) error {
+	if bytes.Equal(raw, []byte("null")) {
+		t.Time = time.Time{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return &TimestampError{value: string(raw), err: err}
+	}
+	parsed, err := ParseTimestamp(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// Parse an RFC 3339 timestamp. Lowercase t/z are allowed as RFC 3339
+// permits; leap seconds (:60) are refused since Firestore can't store them,
+// and digits past nanoseconds are dropped. time.Parse also takes a comma
+// before the fraction and offsets of 24 hours or more, which RFC 3339
+// doesn't, so those are refused here.
+func ParseTimestamp(s string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, strings.ToUpper(s))
+	if err == nil && strings.Contains(s, ",") {
+		err = fmt.Errorf("fraction must follow a period")
+	}
+	if _, offset := parsed.Zone(); err == nil && (offset >= 24*3600 || offset <= -24*3600) {
+		err = fmt.Errorf("offset out of range")
+	}
+	if err != nil {
+		return time.Time{}, &TimestampError{value: s, err: err}
+	}
+	return parsed, nil
+}
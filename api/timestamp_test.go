@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	utc := func(nsec int) time.Time { return time.Date(2024, 1, 2, 3, 4, 5, nsec, time.UTC) }
+	tests := []struct {
+		in      string
+		want    time.Time // the instant; zero for an error
+		wantOff int       // seconds east of UTC
+	}{
+		{"2024-01-02T03:04:05Z", utc(0), 0},
+		{"2024-01-02t03:04:05z", utc(0), 0},
+		// Sub-second precision
+		{"2024-01-02T03:04:05.1Z", utc(100000000), 0},
+		{"2024-01-02T03:04:05.000001Z", utc(1000), 0},
+		{"2024-01-02T03:04:05.123456789Z", utc(123456789), 0},
+		{"2024-01-02T03:04:05.1234567891Z", utc(123456789), 0}, // past nanoseconds: dropped
+		{"2024-01-02T03:04:05.Z", time.Time{}, 0},
+		{"2024-01-02T03:04:05,5Z", time.Time{}, 0},
+		// Z and numeric offsets name the same instant
+		{"2024-01-02T05:04:05+02:00", utc(0), 2 * 3600},
+		{"2024-01-02T08:34:05.5+05:30", utc(500000000), 5*3600 + 30*60},
+		{"2024-01-01T22:04:05-05:00", utc(0), -5 * 3600},
+		{"2024-01-02T03:04:05+00:00", utc(0), 0},
+		{"2024-01-02T03:04:05-00:00", utc(0), 0},
+		{"2024-01-02T03:04:05+0200", time.Time{}, 0},
+		{"2024-01-02T03:04:05+24:00", time.Time{}, 0},
+		{"2024-01-02T03:04:05", time.Time{}, 0},
+		// Leap seconds
+		{"2016-12-31T23:59:60Z", time.Time{}, 0},
+		{"2016-12-31T23:59:60.5Z", time.Time{}, 0},
+		{"2017-01-01T00:59:60+01:00", time.Time{}, 0},
+		{"2016-12-31T23:59:59.999999999Z", time.Date(2016, 12, 31, 23, 59, 59, 999999999, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if tt.want.IsZero() {
+			if _, ok := err.(*TimestampError); !ok {
+				t.Errorf("ParseTimestamp(%q) = %v, %v; want a TimestampError", tt.in, got, err)
+			}
+			continue
+		}
+		if _, off := got.Zone(); err != nil || !got.Equal(tt.want) || off != tt.wantOff {
+			t.Errorf("ParseTimestamp(%q) = %v (offset %d), %v; want %v (offset %d)", tt.in, got, off, err, tt.want, tt.wantOff)
+		}
+	}
+}
+
+// Whatever the offset in, out is UTC with only the digits needed
+func TestTimestampJSON(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{`"2024-01-02T05:04:05+02:00"`, `"2024-01-02T03:04:05Z"`},
+		{`"2024-01-02T03:04:05.120000Z"`, `"2024-01-02T03:04:05.12Z"`},
+		{`"2024-01-01T22:04:05.000000001-05:00"`, `"2024-01-02T03:04:05.000000001Z"`},
+		{`null`, `null`},
+	}
+	for _, tt := range tests {
+		var ts Timestamp
+		if err := json.Unmarshal([]byte(tt.in), &ts); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.in, err)
+			continue
+		}
+		if out, err := json.Marshal(ts); err != nil || string(out) != tt.out {
+			t.Errorf("%s round trip = %s, %v; want %s", tt.in, out, err, tt.out)
+		}
+	}
+	for _, in := range []string{`"2016-12-31T23:59:60Z"`, `1704164645`, `"yesterday"`} {
+		var ts Timestamp
+		if _, ok := json.Unmarshal([]byte(in), &ts).(*TimestampError); !ok {
+			t.Errorf("unmarshal %s: want a TimestampError", in)
+		}
+	}
+}
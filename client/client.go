@@ -0,0 +1,330 @@
+// Package client is a typed Go client for the GoFirestoreApp HTTP API.
+//
+//	c, err := client.New("https://users.internal", client.WithAPIKey(key))
+//	id, err := c.CreateUser(ctx, api.User{Name: "Ada", Email: "ada@example.com"})
+//	it := c.ListUsers(ctx, nil)
+//	for {
+//		u, err := it.Next()
+//		if err == iterator.Done {
+//			break
+//		}
+//		...
+//	}
+//
+// Requests answered with 429 or 503 are retried, waiting as long as the
+// server's Retry-After asks. Errors from the API are *Error values
+// carrying its machine-readable code.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iterator"
+)
+
+// Users is the user API of a Client. Code that depends on it rather than
+// on *Client can be tested against the in-memory store/memstore.
+type Users interface {
+	CreateUser(ctx context.Context, user api.User) (string, error)
+	GetUser(ctx context.Context, id string) (*api.UserResponse, error)
+	UpdateUser(ctx context.Context, id string, user api.User) error
+	DeleteUser(ctx context.Context, id string) error
+	ListUsers(ctx context.Context, opts *ListUsersOptions) *UserIterator
+}
+
+var _ Users = (*Client)(nil)
+
+// Client calls one GoFirestoreApp server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	auth       func(*http.Request) error
+	maxRetries int
+	maxWait    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithAPIKey sends key in X-API-Key, which the server meters quotas by
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) error {
+			req.Header.Set("X-API-Key", key)
+			return nil
+		}
+	}
+}
+
+// WithTokenSource sends a bearer token from ts on every request, such as
+// the server's ADMIN_TOKEN through oauth2.StaticTokenSource
+func WithTokenSource(ts oauth2.TokenSource) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) error {
+			tok, err := ts.Token()
+			if err != nil {
+				return err
+			}
+			tok.SetAuthHeader(req)
+			return nil
+		}
+	}
+}
+
+// WithRetries sets how many times a 429 or 503 is retried (3 by default)
+// and the longest Retry-After honored before giving up (a minute)
+func WithRetries(n int, maxWait time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.maxWait = n, maxWait }
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q is not absolute", baseURL)
+	}
+	c := &Client{baseURL: u, httpClient: http.DefaultClient, maxRetries: 3, maxWait: time.Minute}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Code       string // e.g. api.CodeUserNotFound
+	Detail     string
+	RequestID  string
+	Fields     []api.FieldError
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s): %s", e.Code, e.StatusCode, http.StatusText(e.StatusCode), e.Detail)
+}
+
+// IsNotFound reports whether err is the API saying a user doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == api.CodeUserNotFound
+}
+
+// CreateUser adds a user (POST /addUser) and returns its ID. An email
+// already in use fails with api.CodeEmailTaken.
+func (c *Client) CreateUser(ctx context.Context, user api.User) (string, error) {
+	var resp api.UserEnvelope
+	if err := c.do(ctx, http.MethodPost, "/addUser", nil, user, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// GetUser reads a user (GET /v1/users/{id})
+func (c *Client) GetUser(ctx context.Context, id string) (*api.UserResponse, error) {
+	var resp api.UserResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/users/"+url.PathEscape(id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateUser replaces a user's fields (PUT /updateUser)
+func (c *Client) UpdateUser(ctx context.Context, id string, user api.User) error {
+	return c.do(ctx, http.MethodPut, "/updateUser", url.Values{"id": {id}}, user, nil)
+}
+
+// DeleteUser deletes a user (DELETE /deleteUser)
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/deleteUser", url.Values{"id": {id}}, nil, nil)
+}
+
+// ListUsersOptions narrows and orders ListUsers; the zero value lists
+// every user by ID
+type ListUsersOptions struct {
+	PageSize      int    // users per request; the server's default when 0
+	OrderBy       string // "id", "createdAt" or "name"
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ListUsers iterates over users (GET /v1/users), fetching the next page
+// as each one runs out
+func (c *Client) ListUsers(ctx context.Context, opts *ListUsersOptions) *UserIterator {
+	query := url.Values{}
+	if opts != nil {
+		if opts.PageSize > 0 {
+			query.Set("pageSize", strconv.Itoa(opts.PageSize))
+		}
+		if opts.OrderBy != "" {
+			query.Set("orderBy", opts.OrderBy)
+		}
+		if !opts.CreatedAfter.IsZero() {
+			query.Set("createdAfter", opts.CreatedAfter.UTC().Format(time.RFC3339Nano))
+		}
+		if !opts.CreatedBefore.IsZero() {
+			query.Set("createdBefore", opts.CreatedBefore.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	return NewUserIterator(func(pageToken string) ([]api.UserResponse, string, error) {
+		page := url.Values{}
+		for k, v := range query {
+			page[k] = v
+		}
+		if pageToken != "" {
+			page.Set("pageToken", pageToken)
+		}
+		var resp api.UserListResponse
+		if err := c.do(ctx, http.MethodGet, "/v1/users", page, nil, &resp); err != nil {
+			return nil, "", err
+		}
+		return resp.Users, resp.NextPageToken, nil
+	})
+}
+
+// UserIterator walks a user listing page by page
+type UserIterator struct {
+	fetch func(pageToken string) ([]api.UserResponse, string, error)
+	page  []api.UserResponse
+	token string
+	done  bool
+	err   error
+}
+
+// NewUserIterator returns an iterator over the pages fetch returns, for
+// implementations of Users other than Client. fetch gets "" for the first
+// page and returns each page's users and the next token ("" after the
+// last page).
+func NewUserIterator(fetch func(pageToken string) (users []api.UserResponse, nextPageToken string, err error)) *UserIterator {
+	return &UserIterator{fetch: fetch}
+}
+
+// Next returns the next user, or iterator.Done after the last one
+func (it *UserIterator) Next() (*api.UserResponse, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if it.done {
+			return nil, iterator.Done
+		}
+		it.page, it.token, it.err = it.fetch(it.token)
+		it.done = it.err == nil && it.token == ""
+	}
+	user := it.page[0]
+	it.page = it.page[1:]
+	return &user, nil
+}
+
+// Send one API call, retrying 429s and 503s, and decode its JSON reply
+// into out (when not nil)
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json, application/problem+json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.auth != nil {
+			if err := c.auth(req); err != nil {
+				return err
+			}
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		wait, retry := retryAfter(resp)
+		if retry && attempt < c.maxRetries && wait <= c.maxWait {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return decodeError(resp)
+		}
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// Whether resp should be retried, and after how long: the Retry-After
+// seconds or date when sent, else a second
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	header := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return time.Second, true
+}
+
+// Turn an error response into *Error, from its problem document when it
+// has one and its X-Error-Code otherwise
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Code:       resp.Header.Get("X-Error-Code"),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var problem api.ErrorResponse
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") && json.Unmarshal(raw, &problem) == nil {
+		apiErr.Detail, apiErr.Fields = problem.Detail, problem.Errors
+		if problem.Code != "" {
+			apiErr.Code = problem.Code
+		}
+		if problem.RequestID != "" {
+			apiErr.RequestID = problem.RequestID
+		}
+	} else {
+		apiErr.Detail = strings.TrimSpace(string(raw))
+	}
+	return apiErr
+}
@@ -0,0 +1,52 @@
+// Command server serves the user API, or runs one of its subcommands
+// (bootstrap, doctor, seed, ...) when given one.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	userhttp "github.com/Altair-05/GoFirestoreApp/internal/http"
+)
+
+func main() {
+	userhttp.InitLogging()
+	s := userhttp.NewServer(userhttp.DialFirestore)
+	if len(os.Args) > 1 {
+		os.Exit(s.RunCommand(os.Args[1:]))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.LoadApp()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	app := userhttp.NewApp(cfg, s)
+	if err := app.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	// On SIGINT/SIGTERM let in-flight requests finish, then stop the rest
+	code := 0
+	select {
+	case <-ctx.Done():
+	case err := <-app.Failed():
+		log.Printf("❌ Server stopped: %v", err)
+		code = 1
+	}
+	fmt.Println("👋 Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := app.Stop(shutdownCtx); err != nil {
+		log.Printf("⚠️ Shutdown didn't finish cleanly: %v", err)
+		code = 1
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
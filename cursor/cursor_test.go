@@ -0,0 +1,163 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// A codec whose clock the test sets
+func testCodec(secret string, ttl time.Duration) (*Codec, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New([]byte(secret), ttl)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestEncodeDecode(t *testing.T) {
+	c, now := testCodec("secret", 24*time.Hour)
+	cur := Cursor{LastID: "abc", Values: []string{"Ada", "2024-01-01T00:00:00Z"}, Backward: true, FilterHash: "f"}
+	token := c.Encode(cur)
+	if strings.Contains(token, "abc") {
+		t.Errorf("token %s shows the document ID in the clear", token)
+	}
+	got, err := c.Decode(token, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur.Expires = now.Add(24 * time.Hour).Unix()
+	if !reflect.DeepEqual(got, cur) {
+		t.Errorf("Decode = %+v, want %+v", got, cur)
+	}
+}
+
+func TestDecodeRejectsTampering(t *testing.T) {
+	c, _ := testCodec("secret", time.Hour)
+	token := c.Encode(Cursor{LastID: "abc", FilterHash: "f"})
+	payload, sig, _ := strings.Cut(token, ".")
+	enc := base64.RawURLEncoding
+
+	// The same cursor moved to another document, keeping the signature
+	raw, _ := enc.DecodeString(payload)
+	forged := strings.Replace(string(raw), `"abc"`, `"abd"`, 1)
+	flipped := []byte(sig)
+	flipped[0] ^= 1
+	other, _ := testCodec("other-secret", time.Hour)
+
+	for name, token := range map[string]string{
+		"moved":          enc.EncodeToString([]byte(forged)) + "." + sig,
+		"bad signature":  payload + "." + string(flipped),
+		"no signature":   payload,
+		"empty":          "",
+		"other secret":   other.Encode(Cursor{LastID: "abc", FilterHash: "f"}),
+		"unsigned json":  enc.EncodeToString([]byte(`{"i":"abc","f":"f","e":9999999999}`)) + ".",
+		"not base64":     "!!!." + sig,
+		"signed garbage": enc.EncodeToString([]byte("garbage")) + "." + enc.EncodeToString(c.sign([]byte("garbage"))),
+	} {
+		if cur, err := c.Decode(token, "f"); err != ErrInvalid {
+			t.Errorf("%s: Decode = %+v, %v, want ErrInvalid", name, cur, err)
+		}
+	}
+}
+
+func TestDecodeExpired(t *testing.T) {
+	c, now := testCodec("secret", 24*time.Hour)
+	token := c.Encode(Cursor{LastID: "abc", FilterHash: "f"})
+
+	*now = now.Add(24 * time.Hour)
+	if _, err := c.Decode(token, "f"); err != nil {
+		t.Errorf("at its expiry: %v, want it still valid", err)
+	}
+	*now = now.Add(time.Second)
+	if _, err := c.Decode(token, "f"); err != ErrExpired {
+		t.Errorf("past its expiry: %v, want ErrExpired", err)
+	}
+	// Expiry is checked before filters: an old token gets the clearer error
+	if _, err := c.Decode(token, "other"); err != ErrExpired {
+		t.Errorf("past its expiry with other filters: %v, want ErrExpired", err)
+	}
+}
+
+func TestDecodeFilterMismatch(t *testing.T) {
+	c, _ := testCodec("secret", time.Hour)
+	byName := FilterHash("/v1/users", map[string]string{"orderBy": "name", "collation": "en"})
+	token := c.Encode(Cursor{LastID: "abc", FilterHash: byName})
+	for name, hash := range map[string]string{
+		"other orderBy":   FilterHash("/v1/users", map[string]string{"orderBy": "createdAt", "collation": "en"}),
+		"other collation": FilterHash("/v1/users", map[string]string{"orderBy": "name", "collation": "de"}),
+		"fewer filters":   FilterHash("/v1/users", map[string]string{"orderBy": "name"}),
+		"other path":      FilterHash("/users/abc/history", map[string]string{"orderBy": "name", "collation": "en"}),
+	} {
+		if _, err := c.Decode(token, hash); err != ErrFilterMismatch {
+			t.Errorf("%s: %v, want ErrFilterMismatch", name, err)
+		}
+	}
+	if _, err := c.Decode(token, FilterHash("/v1/users", map[string]string{"collation": "en", "orderBy": "name"})); err != nil {
+		t.Errorf("same filters: %v", err)
+	}
+}
+
+func TestFilterHash(t *testing.T) {
+	if a, b := FilterHash("/v1/users", nil), FilterHash("/v1/users", map[string]string{}); a != b {
+		t.Errorf("nil and empty filters hash differently: %s, %s", a, b)
+	}
+	if a, b := FilterHash("/v1/users", map[string]string{"a": "1"}), FilterHash("/v1/users", map[string]string{"a": "1", "b": ""}); a == b {
+		t.Errorf("an extra empty filter doesn't change the hash %s", a)
+	}
+	if h := FilterHash("/v1/users", nil); len(h) != 16 {
+		t.Errorf("hash %q, want 16 hex digits", h)
+	}
+}
+
+func FuzzCursorDecode(f *testing.F) {
+	c := New([]byte("fuzz-secret"), time.Hour)
+	valid := c.Encode(Cursor{LastID: "abc", Values: []string{"2024-01-01T00:00:00Z"}, FilterHash: "f"})
+	payload, sig, _ := strings.Cut(valid, ".")
+	for _, token := range []string{
+		valid,
+		valid[:len(valid)-3],                 // truncated signature
+		payload[:len(payload)/2] + "." + sig, // truncated payload
+		payload,                              // no signature
+		payload + "=." + sig,                 // padded
+		strings.Replace(valid, ".", "", 1),
+		"", ".", "a.b", "!!!.???",
+	} {
+		f.Add(token, "abc", "2024-01-01T00:00:00Z", false)
+	}
+	f.Fuzz(func(t *testing.T, token, lastID, value string, backward bool) {
+		if len(token)+len(lastID)+len(value) > 64<<10 {
+			return
+		}
+		// Anything decodes without panicking; what decodes encodes to the same position
+		if cur, err := c.Decode(token, "f"); err == nil {
+			again, err := c.Decode(c.Encode(cur), "f")
+			if err != nil {
+				t.Fatalf("re-encoded %+v: %v", cur, err)
+			}
+			cur.Expires, again.Expires = 0, 0
+			if !reflect.DeepEqual(cur, again) {
+				t.Fatalf("round trip changed %+v to %+v", cur, again)
+			}
+		}
+
+		// A cursor built from values JSON carries exactly (Firestore strings are UTF-8) round-trips
+		if !utf8.ValidString(lastID) || !utf8.ValidString(value) {
+			return
+		}
+		cur := Cursor{LastID: lastID, Values: []string{value}, Backward: backward, FilterHash: "f"}
+		got, err := c.Decode(c.Encode(cur), "f")
+		if err != nil {
+			t.Fatalf("Decode(Encode(%+v)): %v", cur, err)
+		}
+		got.Expires = 0
+		if !reflect.DeepEqual(got, cur) {
+			t.Fatalf("round trip changed %+v to %+v", cur, got)
+		}
+		if _, err := c.Decode(c.Encode(cur), "other"); err != ErrFilterMismatch {
+			t.Fatalf("Decode with another filter hash: %v, want ErrFilterMismatch", err)
+		}
+	})
+}
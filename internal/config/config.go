@@ -0,0 +1,76 @@
+// Package config reads settings from the environment. Unset, empty and
+// unparsable values all fall back to the default given.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String reads a string setting
+func String(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Int reads an integer setting
+func Int(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// Float reads a float setting
+func Float(key string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
+// Bool reads a boolean setting
+func Bool(key string, fallback bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return fallback
+}
+
+// Duration reads a duration setting (e.g. "30s", "5m")
+func Duration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return fallback
+}
+
+// DefaultQuotaShards is QUOTA_SHARDS when it is unset
+const DefaultQuotaShards = 10
+
+// App is what the server's App needs beyond the settings its packages
+// read for themselves
+type App struct {
+	Addr             string        // listen address; ":0" picks a free port
+	ComponentTimeout time.Duration // for each component to start or stop
+	ShutdownTimeout  time.Duration // for Stop as a whole, used by main
+	QuotaShards      int           // counters per principal and day; 0 keeps the default
+}
+
+// LoadApp reads the App settings, failing on ones that can't be used
+func LoadApp() (App, error) {
+	cfg := App{
+		Addr:             ":8000",
+		ComponentTimeout: Duration("COMPONENT_TIMEOUT", 30*time.Second),
+		ShutdownTimeout:  Duration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		QuotaShards:      Int("QUOTA_SHARDS", DefaultQuotaShards),
+	}
+	if cfg.QuotaShards < 1 {
+		return cfg, fmt.Errorf("QUOTA_SHARDS must be at least 1, not %d", cfg.QuotaShards)
+	}
+	return cfg, nil
+}
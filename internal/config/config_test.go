@@ -0,0 +1,16 @@
+package config
+
+import "testing"
+
+// A setting that can't be used fails loading the config instead of the process
+func TestLoadAppChecksQuotaShards(t *testing.T) {
+	t.Setenv("QUOTA_SHARDS", "0")
+	if _, err := LoadApp(); err == nil {
+		t.Error("QUOTA_SHARDS=0 accepted")
+	}
+	t.Setenv("QUOTA_SHARDS", "4")
+	cfg, err := LoadApp()
+	if err != nil || cfg.QuotaShards != 4 {
+		t.Errorf("QUOTA_SHARDS=4: %+v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,113 @@
+package firestoretest
+
+import (
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Listen serves one target per stream, as the Go client asks for: its
+// documents, CURRENT, then after every commit whatever changed in it
+func (s *Server) Listen(stream pb.Firestore_ListenServer) error {
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	target := req.GetAddTarget()
+	if target == nil {
+		return status.Error(codes.InvalidArgument, "the first listen request must add a target")
+	}
+	go func() {
+		// Nothing else the client sends matters; its leaving ends ctx
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	ids := []int32{target.TargetId}
+	send := func(r *pb.ListenResponse) error { return stream.Send(r) }
+	targetChange := func(tc *pb.TargetChange) error {
+		return send(&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: tc}})
+	}
+	if err := targetChange(&pb.TargetChange{TargetChangeType: pb.TargetChange_ADD, TargetIds: ids}); err != nil {
+		return err
+	}
+
+	var prev map[string]*pb.Document
+	for first := true; ; first = false {
+		s.mu.Lock()
+		cur, err := s.targetDocs(target)
+		changed := s.changed
+		readTime := s.readTime()
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		token := []byte(readTime.AsTime().String())
+		var n int
+		for name, d := range cur {
+			if p, ok := prev[name]; ok && compareTimestamps(p.UpdateTime, d.UpdateTime) == 0 {
+				continue
+			}
+			n++
+			if err := send(&pb.ListenResponse{ResponseType: &pb.ListenResponse_DocumentChange{
+				DocumentChange: &pb.DocumentChange{Document: d, TargetIds: ids},
+			}}); err != nil {
+				return err
+			}
+		}
+		for name := range prev {
+			if _, ok := cur[name]; ok {
+				continue
+			}
+			n++
+			if err := send(&pb.ListenResponse{ResponseType: &pb.ListenResponse_DocumentRemove{
+				DocumentRemove: &pb.DocumentRemove{Document: name, RemovedTargetIds: ids, ReadTime: readTime},
+			}}); err != nil {
+				return err
+			}
+		}
+		if first {
+			if err := targetChange(&pb.TargetChange{TargetChangeType: pb.TargetChange_CURRENT, TargetIds: ids, ResumeToken: token}); err != nil {
+				return err
+			}
+		}
+		if first || n > 0 {
+			if err := targetChange(&pb.TargetChange{TargetChangeType: pb.TargetChange_NO_CHANGE, ReadTime: readTime, ResumeToken: token}); err != nil {
+				return err
+			}
+		}
+		prev = cur
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// The documents a listen target covers, by name
+func (s *Server) targetDocs(t *pb.Target) (map[string]*pb.Document, error) {
+	docs := map[string]*pb.Document{}
+	switch tt := t.TargetType.(type) {
+	case *pb.Target_Documents:
+		for _, name := range tt.Documents.GetDocuments() {
+			if d := s.get(name); d != nil {
+				docs[name] = d
+			}
+		}
+	case *pb.Target_Query:
+		res, err := s.query(tt.Query.GetParent(), tt.Query.GetStructuredQuery())
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range res {
+			docs[d.Name] = d
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported listen target")
+	}
+	return docs, nil
+}
@@ -0,0 +1,358 @@
+package firestoretest
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const nameField = "__name__"
+
+// An ordering of query results, with the path split once
+type orderKey struct {
+	path []string // nil for __name__
+	desc bool
+}
+
+// A candidate result and its values for the ordering
+type row struct {
+	doc  *pb.Document
+	vals []*pb.Value
+}
+
+// The documents q matches under parent, in order and projected
+func (s *Server) query(parent string, q *pb.StructuredQuery) ([]*pb.Document, error) {
+	if len(q.GetFrom()) != 1 {
+		return nil, status.Error(codes.InvalidArgument, "a query reads exactly one collection")
+	}
+	from := q.From[0]
+	var docs []*pb.Document
+	if from.AllDescendants {
+		prefix := parent + "/"
+		for col, byName := range s.cols {
+			if strings.HasPrefix(col, prefix) && col[strings.LastIndexByte(col, '/')+1:] == from.CollectionId {
+				for _, d := range byName {
+					docs = append(docs, d)
+				}
+			}
+		}
+	} else {
+		for _, d := range s.cols[parent+"/"+from.CollectionId] {
+			docs = append(docs, d)
+		}
+	}
+
+	keys := orderKeys(q)
+	rows := make([]row, 0, len(docs))
+candidates:
+	for _, d := range docs {
+		if q.Where != nil && !matches(d, q.Where) {
+			continue
+		}
+		r := row{doc: d, vals: make([]*pb.Value, len(keys))}
+		for i, k := range keys {
+			v, ok := keyValue(d, k)
+			if !ok {
+				// Firestore leaves out documents without an ordered field
+				continue candidates
+			}
+			r.vals[i] = v
+		}
+		rows = append(rows, r)
+	}
+	if c := q.StartAt; c != nil {
+		rows = slices.DeleteFunc(rows, func(r row) bool {
+			cmp := compareRow(r.vals, c.Values, keys)
+			return cmp < 0 || cmp == 0 && !c.Before
+		})
+	}
+	if c := q.EndAt; c != nil {
+		rows = slices.DeleteFunc(rows, func(r row) bool {
+			cmp := compareRow(r.vals, c.Values, keys)
+			return cmp > 0 || cmp == 0 && c.Before
+		})
+	}
+	less := func(a, b row) int { return compareRow(a.vals, b.vals, keys) }
+	if q.Limit != nil && int(q.Offset)+int(q.Limit.Value) < len(rows) {
+		rows = smallest(rows, int(q.Offset)+int(q.Limit.Value), less)
+	} else {
+		slices.SortFunc(rows, less)
+	}
+	if off := int(q.Offset); off > 0 {
+		rows = rows[min(off, len(rows)):]
+	}
+	if q.Limit != nil && int(q.Limit.Value) < len(rows) {
+		rows = rows[:q.Limit.Value]
+	}
+
+	out := make([]*pb.Document, len(rows))
+	for i, r := range rows {
+		out[i] = project(r.doc, q.Select)
+	}
+	return out, nil
+}
+
+// The k smallest rows in order, without sorting them all: a page of a
+// large collection costs a scan rather than a sort
+func smallest(rows []row, k int, cmp func(a, b row) int) []row {
+	h := &rowHeap{cmp: cmp}
+	for _, r := range rows {
+		switch {
+		case len(h.rows) < k:
+			heap.Push(h, r)
+		case cmp(r, h.rows[0]) < 0:
+			h.rows[0] = r
+			heap.Fix(h, 0)
+		}
+	}
+	slices.SortFunc(h.rows, cmp)
+	return h.rows
+}
+
+// A max-heap of rows
+type rowHeap struct {
+	rows []row
+	cmp  func(a, b row) int
+}
+
+func (h *rowHeap) Len() int           { return len(h.rows) }
+func (h *rowHeap) Less(i, j int) bool { return h.cmp(h.rows[i], h.rows[j]) > 0 }
+func (h *rowHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *rowHeap) Push(x any)         { h.rows = append(h.rows, x.(row)) }
+func (h *rowHeap) Pop() any {
+	r := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return r
+}
+
+// The query's orders as Firestore runs them: the explicit ones, then any
+// inequality fields they leave out, then the document name
+func orderKeys(q *pb.StructuredQuery) []orderKey {
+	var (
+		keys    []orderKey
+		ordered = map[string]bool{}
+		desc    bool
+	)
+	for _, o := range q.OrderBy {
+		p := o.GetField().GetFieldPath()
+		desc = o.Direction == pb.StructuredQuery_DESCENDING
+		ordered[p] = true
+		keys = append(keys, newOrderKey(p, desc))
+	}
+	var ineq []string
+	inequalityFields(q.Where, &ineq)
+	sort.Strings(ineq)
+	for _, p := range ineq {
+		if !ordered[p] {
+			ordered[p] = true
+			keys = append(keys, newOrderKey(p, desc))
+		}
+	}
+	if !ordered[nameField] {
+		keys = append(keys, newOrderKey(nameField, desc))
+	}
+	return keys
+}
+
+func newOrderKey(path string, desc bool) orderKey {
+	k := orderKey{desc: desc}
+	if path != nameField {
+		k.path = splitFieldPath(path)
+	}
+	return k
+}
+
+func inequalityFields(f *pb.StructuredQuery_Filter, out *[]string) {
+	switch ft := f.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range ft.CompositeFilter.Filters {
+			inequalityFields(sub, out)
+		}
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		switch ft.FieldFilter.Op {
+		case pb.StructuredQuery_FieldFilter_LESS_THAN, pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_GREATER_THAN, pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_NOT_EQUAL, pb.StructuredQuery_FieldFilter_NOT_IN:
+			p := ft.FieldFilter.GetField().GetFieldPath()
+			for _, seen := range *out {
+				if seen == p {
+					return
+				}
+			}
+			*out = append(*out, p)
+		}
+	}
+}
+
+func keyValue(d *pb.Document, k orderKey) (*pb.Value, bool) {
+	if k.path == nil {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: d.Name}}, true
+	}
+	return getField(d.Fields, k.path)
+}
+
+// Compare a row's values with another row's or a cursor's, which may be
+// shorter
+func compareRow(a, b []*pb.Value, keys []orderKey) int {
+	for i := 0; i < len(a) && i < len(b) && i < len(keys); i++ {
+		c := compareValues(a[i], b[i])
+		if keys[i].desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func fieldValue(d *pb.Document, path string) (*pb.Value, bool) {
+	if path == nameField {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: d.Name}}, true
+	}
+	return getField(d.Fields, splitFieldPath(path))
+}
+
+func matches(d *pb.Document, f *pb.StructuredQuery_Filter) bool {
+	switch ft := f.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		or := ft.CompositeFilter.Op == pb.StructuredQuery_CompositeFilter_OR
+		for _, sub := range ft.CompositeFilter.Filters {
+			if matches(d, sub) == or {
+				return or
+			}
+		}
+		return !or
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		v, ok := fieldValue(d, ft.FieldFilter.GetField().GetFieldPath())
+		return ok && matchesField(v, ft.FieldFilter.Op, ft.FieldFilter.Value)
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		v, ok := fieldValue(d, ft.UnaryFilter.GetField().GetFieldPath())
+		if !ok {
+			return false
+		}
+		switch ft.UnaryFilter.Op {
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return isNull(v)
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return !isNull(v)
+		case pb.StructuredQuery_UnaryFilter_IS_NAN:
+			return isNaN(v)
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NAN:
+			return !isNaN(v) && !isNull(v)
+		}
+	}
+	return true
+}
+
+func matchesField(v *pb.Value, op pb.StructuredQuery_FieldFilter_Operator, want *pb.Value) bool {
+	switch op {
+	case pb.StructuredQuery_FieldFilter_EQUAL:
+		return equalValues(v, want)
+	case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+		return !isNull(v) && !equalValues(v, want)
+	case pb.StructuredQuery_FieldFilter_IN:
+		return containsValue(want.GetArrayValue().GetValues(), v)
+	case pb.StructuredQuery_FieldFilter_NOT_IN:
+		return !isNull(v) && !containsValue(want.GetArrayValue().GetValues(), v)
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+		return containsValue(v.GetArrayValue().GetValues(), want)
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+		for _, e := range want.GetArrayValue().GetValues() {
+			if containsValue(v.GetArrayValue().GetValues(), e) {
+				return true
+			}
+		}
+		return false
+	}
+	// Range filters only match values of the same type
+	if rank(v) != rank(want) || isNaN(v) || isNaN(want) {
+		return false
+	}
+	c := compareValues(v, want)
+	switch op {
+	case pb.StructuredQuery_FieldFilter_LESS_THAN:
+		return c < 0
+	case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+		return c <= 0
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+		return c > 0
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+		return c >= 0
+	}
+	return false
+}
+
+// d with only the selected fields; selecting just __name__ keeps none
+func project(d *pb.Document, sel *pb.StructuredQuery_Projection) *pb.Document {
+	if sel == nil {
+		return d
+	}
+	var paths []string
+	for _, f := range sel.Fields {
+		if p := f.GetFieldPath(); p != nameField {
+			paths = append(paths, p)
+		}
+	}
+	return withFields(d, maskFields(d.Fields, paths))
+}
+
+// The result of one aggregation over docs
+func aggregate(docs []*pb.Document, a *pb.StructuredAggregationQuery_Aggregation) *pb.Value {
+	switch op := a.Operator.(type) {
+	case *pb.StructuredAggregationQuery_Aggregation_Count_:
+		n := int64(len(docs))
+		if upTo := op.Count.GetUpTo(); upTo != nil && upTo.Value < n {
+			n = upTo.Value
+		}
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: n}}
+	case *pb.StructuredAggregationQuery_Aggregation_Sum_:
+		nums := numbersAt(docs, op.Sum.GetField().GetFieldPath())
+		var (
+			isum  int64
+			fsum  float64
+			float bool
+		)
+		for _, v := range nums {
+			fsum += number(v)
+			i, ok := v.GetValueType().(*pb.Value_IntegerValue)
+			if !ok || (i.IntegerValue > 0 && isum > math.MaxInt64-i.IntegerValue) || (i.IntegerValue < 0 && isum < math.MinInt64-i.IntegerValue) {
+				float = true
+				continue
+			}
+			isum += i.IntegerValue
+		}
+		if float {
+			return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: fsum}}
+		}
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: isum}}
+	case *pb.StructuredAggregationQuery_Aggregation_Avg_:
+		nums := numbersAt(docs, op.Avg.GetField().GetFieldPath())
+		if len(nums) == 0 {
+			return &pb.Value{ValueType: &pb.Value_NullValue{}}
+		}
+		var sum float64
+		for _, v := range nums {
+			sum += number(v)
+		}
+		return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: sum / float64(len(nums))}}
+	}
+	return &pb.Value{ValueType: &pb.Value_NullValue{}}
+}
+
+// The numbers at path in docs, skipping documents where it holds anything else
+func numbersAt(docs []*pb.Document, path string) []*pb.Value {
+	var nums []*pb.Value
+	for _, d := range docs {
+		if v, ok := fieldValue(d, path); ok && rank(v) == rankNumber {
+			nums = append(nums, v)
+		}
+	}
+	return nums
+}
@@ -0,0 +1,653 @@
+// Package firestoretest is an in-memory Firestore backend for tests and
+// benchmarks, served over gRPC on a loopback port so the real
+// cloud.google.com/go/firestore client talks to it as it would to the
+// emulator:
+//
+//	srv, err := firestoretest.New()
+//	c, err := firestore.NewClient(ctx, "demo-test", srv.ClientOptions()...)
+//
+// It covers what the server uses: gets, queries with filters, orders,
+// cursors and projections, collection groups, aggregations, commits with
+// preconditions and field transforms, transactions, BulkWriter's batch
+// writes, document and collection listings, and snapshot listeners.
+// Every project on one Server is kept apart by its document names.
+//
+// Gaps, where it is simpler than Firestore or the emulator:
+//   - no indexes: every query runs, whatever its filters and orders
+//   - no read times: reads always see the latest data
+//   - read-write transactions take a lock per database, like the
+//     emulator, but give up waiting after txLockWait and fall back to
+//     checking their reads at commit, which aborts on a conflict
+//   - listings and aggregations aren't paged; everything comes back at once
+//   - listeners resend everything when they reconnect
+package firestoretest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/option"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// How long a read-write transaction waits for another on the same
+// database before going ahead optimistically, and how long an abandoned
+// one keeps its lock
+const (
+	txLockWait = 2 * time.Second
+	txTimeout  = time.Minute
+)
+
+// Server is a running in-memory Firestore
+type Server struct {
+	pb.UnimplementedFirestoreServer
+
+	addr string
+	srv  *grpc.Server
+
+	mu      sync.Mutex
+	cols    map[string]map[string]*pb.Document // collection path -> document name -> document
+	txs     map[string]*transaction
+	locks   map[string]chan struct{} // database -> read-write transaction lock
+	nextTx  int
+	last    time.Time     // latest commit time
+	changed chan struct{} // closed on every commit, for listeners
+}
+
+// A transaction and the reads it must see unchanged at commit
+type transaction struct {
+	readOnly bool
+	lock     chan struct{}
+	docs     map[string]*timestamppb.Timestamp // name -> update time, nil when missing
+	queries  []readQuery
+}
+
+type readQuery struct {
+	parent string
+	query  *pb.StructuredQuery
+	seen   string
+}
+
+// New starts a Server on a loopback port
+func New() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		addr:    lis.Addr().String(),
+		srv:     grpc.NewServer(),
+		cols:    map[string]map[string]*pb.Document{},
+		txs:     map[string]*transaction{},
+		locks:   map[string]chan struct{}{},
+		changed: make(chan struct{}),
+	}
+	pb.RegisterFirestoreServer(s.srv, s)
+	go s.srv.Serve(lis)
+	return s, nil
+}
+
+// Addr is the host:port the server listens on
+func (s *Server) Addr() string { return s.addr }
+
+// ClientOptions connect a firestore.Client to the server
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+// Close stops the server, ending open streams
+func (s *Server) Close() {
+	s.srv.Stop()
+}
+
+// A commit time: now to the microsecond, as Firestore keeps it, and
+// always after the last one
+func (s *Server) tick() time.Time {
+	t := time.Now().Truncate(time.Microsecond)
+	if !t.After(s.last) {
+		t = s.last.Add(time.Microsecond)
+	}
+	s.last = t
+	return t
+}
+
+func (s *Server) readTime() *timestamppb.Timestamp {
+	t := time.Now().Truncate(time.Microsecond)
+	if t.Before(s.last) {
+		t = s.last
+	}
+	return timestamppb.New(t)
+}
+
+// Reset deletes every document in project, for a test that reuses one
+func (s *Server) Reset(project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := "projects/" + project + "/"
+	for col := range s.cols {
+		if strings.HasPrefix(col, prefix) {
+			delete(s.cols, col)
+		}
+	}
+	s.notify()
+}
+
+// The collection path of a document name
+func collectionOf(name string) string {
+	return name[:strings.LastIndexByte(name, '/')]
+}
+
+func (s *Server) get(name string) *pb.Document {
+	return s.cols[collectionOf(name)][name]
+}
+
+// Store doc under name, or remove name when doc is nil
+func (s *Server) put(name string, doc *pb.Document) {
+	col := collectionOf(name)
+	if doc == nil {
+		delete(s.cols[col], name)
+		if len(s.cols[col]) == 0 {
+			delete(s.cols, col)
+		}
+		return
+	}
+	if s.cols[col] == nil {
+		s.cols[col] = map[string]*pb.Document{}
+	}
+	s.cols[col][name] = doc
+}
+
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// The open transaction id names, or nil for none
+func (s *Server) transaction(id []byte) (*transaction, error) {
+	if len(id) == 0 {
+		return nil, nil
+	}
+	tx := s.txs[string(id)]
+	if tx == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "transaction %q is not open", id)
+	}
+	return tx, nil
+}
+
+func (tx *transaction) readDoc(name string, doc *pb.Document) {
+	if _, ok := tx.docs[name]; ok {
+		return
+	}
+	tx.docs[name] = doc.GetUpdateTime()
+}
+
+func (tx *transaction) release() {
+	if tx.lock != nil {
+		<-tx.lock
+		tx.lock = nil
+	}
+}
+
+// Whether everything tx read is as it was
+func (s *Server) unchanged(tx *transaction) bool {
+	for name, ts := range tx.docs {
+		cur := s.get(name)
+		if (cur == nil) != (ts == nil) || cur != nil && compareTimestamps(cur.UpdateTime, ts) != 0 {
+			return false
+		}
+	}
+	for _, q := range tx.queries {
+		docs, err := s.query(q.parent, q.query)
+		if err != nil || seenDocs(docs) != q.seen {
+			return false
+		}
+	}
+	return true
+}
+
+// The names and versions of docs, to spot a query whose results changed
+func seenDocs(docs []*pb.Document) string {
+	var b strings.Builder
+	for _, d := range docs {
+		fmt.Fprintf(&b, "%s@%d.%d\n", d.Name, d.GetUpdateTime().GetSeconds(), d.GetUpdateTime().GetNanos())
+	}
+	return b.String()
+}
+
+func (s *Server) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	tx := &transaction{
+		readOnly: req.GetOptions().GetReadOnly() != nil,
+		docs:     map[string]*timestamppb.Timestamp{},
+	}
+	if !tx.readOnly {
+		s.mu.Lock()
+		lock := s.locks[req.Database]
+		if lock == nil {
+			lock = make(chan struct{}, 1)
+			s.locks[req.Database] = lock
+		}
+		s.mu.Unlock()
+		select {
+		case lock <- struct{}{}:
+			tx.lock = lock
+		case <-time.After(txLockWait):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextTx++
+	id := fmt.Sprintf("tx-%d", s.nextTx)
+	s.txs[id] = tx
+	time.AfterFunc(txTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.txs[id] == tx {
+			delete(s.txs, id)
+			tx.release()
+		}
+	})
+	return &pb.BeginTransactionResponse{Transaction: []byte(id)}, nil
+}
+
+func (s *Server) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.transaction(req.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		delete(s.txs, string(req.Transaction))
+		tx.release()
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.transaction(req.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		delete(s.txs, string(req.Transaction))
+		defer tx.release()
+		if tx.readOnly && len(req.Writes) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "cannot write in a read-only transaction")
+		}
+		if !s.unchanged(tx) {
+			return nil, status.Error(codes.Aborted, "transaction aborted: its reads changed")
+		}
+	}
+	now := s.tick()
+	staged := map[string]*pb.Document{}
+	var order []string
+	res := &pb.CommitResponse{CommitTime: timestamppb.New(now)}
+	for _, w := range req.Writes {
+		name := writeName(w)
+		cur, ok := staged[name]
+		if !ok {
+			cur = s.get(name)
+			order = append(order, name)
+		}
+		doc, wr, err := applyWrite(cur, w, now)
+		if err != nil {
+			return nil, err
+		}
+		staged[name] = doc
+		res.WriteResults = append(res.WriteResults, wr)
+	}
+	for _, name := range order {
+		s.put(name, staged[name])
+	}
+	if len(order) > 0 {
+		s.notify()
+	}
+	return res, nil
+}
+
+func (s *Server) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &pb.BatchWriteResponse{}
+	for _, w := range req.Writes {
+		name := writeName(w)
+		doc, wr, err := applyWrite(s.get(name), w, s.tick())
+		if err != nil {
+			st := status.Convert(err)
+			res.WriteResults = append(res.WriteResults, &pb.WriteResult{})
+			res.Status = append(res.Status, &rpcstatus.Status{Code: int32(st.Code()), Message: st.Message()})
+			continue
+		}
+		s.put(name, doc)
+		res.WriteResults = append(res.WriteResults, wr)
+		res.Status = append(res.Status, &rpcstatus.Status{})
+	}
+	if len(req.Writes) > 0 {
+		s.notify()
+	}
+	return res, nil
+}
+
+func writeName(w *pb.Write) string {
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		return op.Update.GetName()
+	case *pb.Write_Delete:
+		return op.Delete
+	case *pb.Write_Transform:
+		return op.Transform.GetDocument()
+	}
+	return ""
+}
+
+// Apply w to cur, nil when the document doesn't exist, returning the
+// document it leaves, nil when deleted
+func applyWrite(cur *pb.Document, w *pb.Write, now time.Time) (*pb.Document, *pb.WriteResult, error) {
+	name := writeName(w)
+	if name == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "write names no document")
+	}
+	switch c := w.GetCurrentDocument().GetConditionType().(type) {
+	case *pb.Precondition_Exists:
+		if c.Exists && cur == nil {
+			return nil, nil, status.Errorf(codes.NotFound, "no document to update: %s", name)
+		}
+		if !c.Exists && cur != nil {
+			return nil, nil, status.Errorf(codes.AlreadyExists, "document already exists: %s", name)
+		}
+	case *pb.Precondition_UpdateTime:
+		if cur == nil || compareTimestamps(cur.UpdateTime, c.UpdateTime) != 0 {
+			return nil, nil, status.Errorf(codes.FailedPrecondition, "document %s was updated since %v", name, c.UpdateTime.AsTime())
+		}
+	}
+	var (
+		fields     map[string]*pb.Value
+		transforms = w.UpdateTransforms
+	)
+	switch op := w.Operation.(type) {
+	case *pb.Write_Delete:
+		return nil, &pb.WriteResult{UpdateTime: timestamppb.New(now)}, nil
+	case *pb.Write_Update:
+		if w.UpdateMask == nil {
+			fields = cloneFields(op.Update.Fields)
+			break
+		}
+		fields = cloneFields(cur.GetFields())
+		for _, p := range w.UpdateMask.FieldPaths {
+			path := splitFieldPath(p)
+			if v, ok := getField(op.Update.Fields, path); ok {
+				setField(fields, path, proto.Clone(v).(*pb.Value))
+			} else {
+				deleteField(fields, path)
+			}
+		}
+	case *pb.Write_Transform:
+		fields = cloneFields(cur.GetFields())
+		transforms = append(op.Transform.FieldTransforms, transforms...)
+	}
+	wr := &pb.WriteResult{}
+	for _, t := range transforms {
+		v, err := applyTransform(fields, t, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		wr.TransformResults = append(wr.TransformResults, v)
+	}
+	doc := &pb.Document{Name: name, Fields: fields, CreateTime: timestamppb.New(now), UpdateTime: timestamppb.New(now)}
+	if cur != nil {
+		doc.CreateTime = cur.CreateTime
+		if proto.Equal(&pb.MapValue{Fields: cur.Fields}, &pb.MapValue{Fields: fields}) {
+			doc.UpdateTime = cur.UpdateTime
+		}
+	}
+	wr.UpdateTime = doc.UpdateTime
+	return doc, wr, nil
+}
+
+func cloneFields(fields map[string]*pb.Value) map[string]*pb.Value {
+	clone := make(map[string]*pb.Value, len(fields))
+	for k, v := range fields {
+		clone[k] = proto.Clone(v).(*pb.Value)
+	}
+	return clone
+}
+
+// Apply a field transform to fields, returning the value it leaves
+func applyTransform(fields map[string]*pb.Value, t *pb.DocumentTransform_FieldTransform, now time.Time) (*pb.Value, error) {
+	path := splitFieldPath(t.FieldPath)
+	cur, _ := getField(fields, path)
+	var v *pb.Value
+	switch tt := t.TransformType.(type) {
+	case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+		v = timestampValue(now)
+	case *pb.DocumentTransform_FieldTransform_Increment:
+		v = increment(cur, tt.Increment)
+	case *pb.DocumentTransform_FieldTransform_Maximum:
+		v = cur
+		if rank(cur) != rankNumber || compareNumbers(tt.Maximum, cur) > 0 {
+			v = tt.Maximum
+		}
+	case *pb.DocumentTransform_FieldTransform_Minimum:
+		v = cur
+		if rank(cur) != rankNumber || compareNumbers(tt.Minimum, cur) < 0 {
+			v = tt.Minimum
+		}
+	case *pb.DocumentTransform_FieldTransform_AppendMissingElements:
+		elems := append([]*pb.Value(nil), cur.GetArrayValue().GetValues()...)
+		for _, e := range tt.AppendMissingElements.GetValues() {
+			if !containsValue(elems, e) {
+				elems = append(elems, e)
+			}
+		}
+		v = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: elems}}}
+	case *pb.DocumentTransform_FieldTransform_RemoveAllFromArray:
+		var elems []*pb.Value
+		for _, e := range cur.GetArrayValue().GetValues() {
+			if !containsValue(tt.RemoveAllFromArray.GetValues(), e) {
+				elems = append(elems, e)
+			}
+		}
+		v = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: elems}}}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported transform of %s", t.FieldPath)
+	}
+	v = proto.Clone(v).(*pb.Value)
+	setField(fields, path, v)
+	return v, nil
+}
+
+// cur + by, saturating for integers; a non-number cur counts as absent
+func increment(cur, by *pb.Value) *pb.Value {
+	if rank(cur) != rankNumber {
+		return by
+	}
+	a, aInt := cur.GetValueType().(*pb.Value_IntegerValue)
+	b, bInt := by.GetValueType().(*pb.Value_IntegerValue)
+	if !aInt || !bInt {
+		return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: number(cur) + number(by)}}
+	}
+	sum := a.IntegerValue + b.IntegerValue
+	switch {
+	case b.IntegerValue > 0 && sum < a.IntegerValue:
+		sum = math.MaxInt64
+	case b.IntegerValue < 0 && sum > a.IntegerValue:
+		sum = math.MinInt64
+	}
+	return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: sum}}
+}
+
+func containsValue(vs []*pb.Value, v *pb.Value) bool {
+	for _, e := range vs {
+		if equalValues(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	s.mu.Lock()
+	tx, err := s.transaction(req.GetTransaction())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	var res []*pb.BatchGetDocumentsResponse
+	readTime := s.readTime()
+	for _, name := range req.Documents {
+		doc := s.get(name)
+		if tx != nil {
+			tx.readDoc(name, doc)
+		}
+		r := &pb.BatchGetDocumentsResponse{ReadTime: readTime}
+		switch {
+		case doc == nil:
+			r.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		case req.Mask != nil:
+			r.Result = &pb.BatchGetDocumentsResponse_Found{Found: withFields(doc, maskFields(doc.Fields, req.Mask.FieldPaths))}
+		default:
+			r.Result = &pb.BatchGetDocumentsResponse_Found{Found: doc}
+		}
+		res = append(res, r)
+	}
+	s.mu.Unlock()
+	for _, r := range res {
+		if err := stream.Send(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doc with its fields replaced, leaving the stored one alone
+func withFields(doc *pb.Document, fields map[string]*pb.Value) *pb.Document {
+	return &pb.Document{Name: doc.Name, Fields: fields, CreateTime: doc.CreateTime, UpdateTime: doc.UpdateTime}
+}
+
+func (s *Server) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	s.mu.Lock()
+	docs, readTime, err := s.runQuery(req.Parent, req.GetStructuredQuery(), req.GetTransaction())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime})
+	}
+	for _, d := range docs {
+		if err := stream.Send(&pb.RunQueryResponse{Document: d, ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run q under parent, recording it in the transaction txID names
+func (s *Server) runQuery(parent string, q *pb.StructuredQuery, txID []byte) ([]*pb.Document, *timestamppb.Timestamp, error) {
+	tx, err := s.transaction(txID)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := s.query(parent, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tx != nil {
+		tx.queries = append(tx.queries, readQuery{parent: parent, query: q, seen: seenDocs(docs)})
+	}
+	return docs, s.readTime(), nil
+}
+
+func (s *Server) RunAggregationQuery(req *pb.RunAggregationQueryRequest, stream pb.Firestore_RunAggregationQueryServer) error {
+	agg := req.GetStructuredAggregationQuery()
+	s.mu.Lock()
+	docs, readTime, err := s.runQuery(req.Parent, agg.GetStructuredQuery(), req.GetTransaction())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	fields := map[string]*pb.Value{}
+	for _, a := range agg.GetAggregations() {
+		fields[a.Alias] = aggregate(docs, a)
+	}
+	return stream.Send(&pb.RunAggregationQueryResponse{
+		Result:   &pb.AggregationResult{AggregateFields: fields},
+		ReadTime: readTime,
+	})
+}
+
+func (s *Server) ListCollectionIds(ctx context.Context, req *pb.ListCollectionIdsRequest) (*pb.ListCollectionIdsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := req.Parent + "/"
+	seen := map[string]bool{}
+	for col := range s.cols {
+		if rest, ok := strings.CutPrefix(col, prefix); ok {
+			id, _, _ := strings.Cut(rest, "/")
+			seen[id] = true
+		}
+	}
+	res := &pb.ListCollectionIdsResponse{}
+	for id := range seen {
+		res.CollectionIds = append(res.CollectionIds, id)
+	}
+	sort.Strings(res.CollectionIds)
+	return res, nil
+}
+
+func (s *Server) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	col := req.Parent + "/" + req.CollectionId
+	byName := map[string]*pb.Document{}
+	for name, doc := range s.cols[col] {
+		if req.Mask != nil {
+			doc = withFields(doc, maskFields(doc.Fields, req.Mask.FieldPaths))
+		}
+		byName[name] = doc
+	}
+	if req.ShowMissing {
+		// Documents that don't exist but have subcollections
+		prefix := col + "/"
+		for sub := range s.cols {
+			if rest, ok := strings.CutPrefix(sub, prefix); ok {
+				id, _, _ := strings.Cut(rest, "/")
+				if name := prefix + id; byName[name] == nil {
+					byName[name] = &pb.Document{Name: name}
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return compareNames(names[i], names[j]) < 0 })
+	res := &pb.ListDocumentsResponse{}
+	for _, name := range names {
+		res.Documents = append(res.Documents, byName[name])
+	}
+	return res, nil
+}
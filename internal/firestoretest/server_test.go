@@ -0,0 +1,228 @@
+package firestoretest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newClient(t *testing.T) (context.Context, *firestore.Client) {
+	t.Helper()
+	srv, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	c, err := firestore.NewClient(ctx, "demo-test", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return ctx, c
+}
+
+func ids(t *testing.T, it *firestore.DocumentIterator) []string {
+	t.Helper()
+	docs, err := it.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, d := range docs {
+		out = append(out, d.Ref.ID)
+	}
+	return out
+}
+
+func TestWritesAndReads(t *testing.T) {
+	ctx, c := newClient(t)
+	ref := c.Collection("users").Doc("ada")
+	if _, err := ref.Create(ctx, map[string]interface{}{"name": "Ada", "n": 1, "tags": []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Create(ctx, map[string]interface{}{"name": "Ada"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("second Create = %v, want AlreadyExists", err)
+	}
+	if _, err := c.Collection("users").Doc("nobody").Update(ctx, []firestore.Update{{Path: "n", Value: 1}}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Update of a missing document = %v, want NotFound", err)
+	}
+	_, err := ref.Update(ctx, []firestore.Update{
+		{Path: "n", Value: firestore.Increment(2)},
+		{Path: "tags", Value: firestore.ArrayUnion("a", "b")},
+		{Path: "at", Value: firestore.ServerTimestamp},
+		{Path: "profile.city", Value: "London"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := snap.Data()
+	if got["n"] != int64(3) || !reflect.DeepEqual(got["tags"], []interface{}{"a", "b"}) || got["profile"].(map[string]interface{})["city"] != "London" {
+		t.Errorf("after update: %v", got)
+	}
+	if at, ok := got["at"].(time.Time); !ok || !at.Equal(snap.UpdateTime) {
+		t.Errorf("server timestamp %v, want the update time %v", got["at"], snap.UpdateTime)
+	}
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "n", Value: 3}}, firestore.LastUpdateTime(snap.CreateTime)); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Update with a stale time = %v, want FailedPrecondition", err)
+	}
+	if _, err := ref.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Fatalf("Get after Delete = %v, want NotFound", err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	ctx, c := newClient(t)
+	users := c.Collection("users")
+	for id, age := range map[string]interface{}{"a": 30, "b": 20, "c": 20, "d": 40.5, "e": "old"} {
+		if _, err := users.Doc(id).Set(ctx, map[string]interface{}{"age": age}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := users.Doc("f").Set(ctx, map[string]interface{}{"name": "no age"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Doc("a").Collection("posts").Doc("p").Set(ctx, map[string]interface{}{"age": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		q    firestore.Query
+		want []string
+	}{
+		{"all", users.Query, []string{"a", "b", "c", "d", "e", "f"}},
+		{"ordered", users.OrderBy("age", firestore.Asc), []string{"b", "c", "a", "d", "e"}},
+		{"desc", users.OrderBy("age", firestore.Desc), []string{"e", "d", "a", "c", "b"}},
+		{"range", users.Where("age", ">=", 20).Where("age", "<", 35), []string{"b", "c", "a"}},
+		{"in", users.Where("age", "in", []interface{}{20, 40.5}), []string{"b", "c", "d"}},
+		{"start after", users.OrderBy("age", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).StartAfter(20, "b").Limit(2), []string{"c", "a"}},
+		{"end before", users.OrderBy("age", firestore.Asc).EndBefore(30), []string{"b", "c"}},
+		{"limit to last", users.OrderBy("age", firestore.Asc).LimitToLast(2), []string{"d", "e"}},
+		{"offset", users.OrderBy(firestore.DocumentID, firestore.Asc).Offset(4), []string{"e", "f"}},
+		{"group", c.CollectionGroup("posts").Query, []string{"p"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(t, tt.q.Documents(ctx)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	twenty := users.Where("age", "==", 20)
+	res, err := twenty.NewAggregationQuery().WithCount("n").WithSum("age", "sum").Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := res["n"].(*pb.Value)
+	sum, _ := res["sum"].(*pb.Value)
+	if n.GetIntegerValue() != 2 || sum.GetIntegerValue() != 40 {
+		t.Errorf("aggregation = %v", res)
+	}
+	cols, err := users.Doc("a").Collections(ctx).GetAll()
+	if err != nil || len(cols) != 1 || cols[0].ID != "posts" {
+		t.Errorf("Collections = %v, %v", cols, err)
+	}
+}
+
+func TestTransactionsSerialize(t *testing.T) {
+	ctx, c := newClient(t)
+	ref := c.Collection("counters").Doc("n")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+				snap, err := tx.Get(ref)
+				var n int64
+				if err == nil {
+					n = snap.Data()["n"].(int64)
+				} else if status.Code(err) != codes.NotFound {
+					return err
+				}
+				return tx.Set(ref, map[string]interface{}{"n": n + 1})
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := snap.Data()["n"]; n != int64(10) {
+		t.Errorf("n = %v, want 10", n)
+	}
+}
+
+func TestBulkWriterAndListen(t *testing.T) {
+	ctx, c := newClient(t)
+	col := c.Collection("items")
+	it := col.OrderBy("n", firestore.Asc).Snapshots(ctx)
+	defer it.Stop()
+	if snap, err := it.Next(); err != nil || snap.Size != 0 {
+		t.Fatalf("first snapshot: %v, %v", snap, err)
+	}
+
+	bw := c.BulkWriter(ctx)
+	ok, _ := bw.Create(col.Doc("x"), map[string]interface{}{"n": 1})
+	bad, _ := bw.Update(col.Doc("missing"), []firestore.Update{{Path: "n", Value: 2}})
+	bw.End()
+	if _, err := ok.Results(); err != nil {
+		t.Errorf("create: %v", err)
+	}
+	if _, err := bad.Results(); status.Code(err) != codes.NotFound {
+		t.Errorf("update of a missing document = %v, want NotFound", err)
+	}
+
+	snap, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Changes) != 1 || snap.Changes[0].Kind != firestore.DocumentAdded || snap.Changes[0].Doc.Ref.ID != "x" {
+		t.Errorf("changes = %+v, want x added", snap.Changes)
+	}
+	if _, err := col.Doc("x").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if snap, err = it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Changes) != 1 || snap.Changes[0].Kind != firestore.DocumentRemoved {
+		t.Errorf("changes = %+v, want x removed", snap.Changes)
+	}
+
+	refs, err := col.DocumentRefs(ctx).GetAll()
+	if err != nil || len(refs) != 0 {
+		t.Errorf("DocumentRefs = %v, %v", refs, err)
+	}
+	if _, err := col.Doc("gone").Collection("sub").Doc("s").Set(ctx, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := col.DocumentRefs(ctx).Next()
+	if err != nil || ref.ID != "gone" {
+		t.Errorf("DocumentRefs with a missing parent = %v, %v", ref, err)
+	}
+	if _, err := col.DocumentRefs(ctx).Next(); errors.Is(err, iterator.Done) {
+		t.Error("DocumentRefs ended early")
+	}
+}
@@ -0,0 +1,303 @@
+package firestoretest
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Type order of Firestore values, lowest first. Integers and doubles
+// share a rank and compare by value.
+const (
+	rankNull = iota
+	rankBool
+	rankNumber
+	rankTimestamp
+	rankString
+	rankBytes
+	rankReference
+	rankGeoPoint
+	rankArray
+	rankMap
+)
+
+func rank(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		return rankBool
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return rankNumber
+	case *pb.Value_TimestampValue:
+		return rankTimestamp
+	case *pb.Value_StringValue:
+		return rankString
+	case *pb.Value_BytesValue:
+		return rankBytes
+	case *pb.Value_ReferenceValue:
+		return rankReference
+	case *pb.Value_GeoPointValue:
+		return rankGeoPoint
+	case *pb.Value_ArrayValue:
+		return rankArray
+	case *pb.Value_MapValue:
+		return rankMap
+	}
+	return rankNull
+}
+
+// Compare a and b in Firestore's total order: by type, then by value,
+// with NaN below every other number
+func compareValues(a, b *pb.Value) int {
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return cmpInt(ra, rb)
+	}
+	switch a.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		return cmpBool(a.GetBooleanValue(), b.GetBooleanValue())
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return compareNumbers(a, b)
+	case *pb.Value_TimestampValue:
+		return compareTimestamps(a.GetTimestampValue(), b.GetTimestampValue())
+	case *pb.Value_StringValue:
+		return strings.Compare(a.GetStringValue(), b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(a.GetBytesValue(), b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return compareNames(a.GetReferenceValue(), b.GetReferenceValue())
+	case *pb.Value_GeoPointValue:
+		ga, gb := a.GetGeoPointValue(), b.GetGeoPointValue()
+		if c := cmpFloat(ga.GetLatitude(), gb.GetLatitude()); c != 0 {
+			return c
+		}
+		return cmpFloat(ga.GetLongitude(), gb.GetLongitude())
+	case *pb.Value_ArrayValue:
+		va, vb := a.GetArrayValue().GetValues(), b.GetArrayValue().GetValues()
+		for i := 0; i < len(va) && i < len(vb); i++ {
+			if c := compareValues(va[i], vb[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(va), len(vb))
+	case *pb.Value_MapValue:
+		return compareMaps(a.GetMapValue().GetFields(), b.GetMapValue().GetFields())
+	}
+	return 0
+}
+
+func compareNumbers(a, b *pb.Value) int {
+	ai, aInt := a.GetValueType().(*pb.Value_IntegerValue)
+	bi, bInt := b.GetValueType().(*pb.Value_IntegerValue)
+	if aInt && bInt {
+		return cmpInt64(ai.IntegerValue, bi.IntegerValue)
+	}
+	return cmpFloat(number(a), number(b))
+}
+
+func number(v *pb.Value) float64 {
+	if i, ok := v.GetValueType().(*pb.Value_IntegerValue); ok {
+		return float64(i.IntegerValue)
+	}
+	return v.GetDoubleValue()
+}
+
+func compareMaps(a, b map[string]*pb.Value) int {
+	ka, kb := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if c := strings.Compare(ka[i], kb[i]); c != 0 {
+			return c
+		}
+		if c := compareValues(a[ka[i]], b[kb[i]]); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(len(ka), len(kb))
+}
+
+// Compare document names segment by segment, so "a/b" sorts before "a-c"
+func compareNames(a, b string) int {
+	for {
+		ia, ib := strings.IndexByte(a, '/'), strings.IndexByte(b, '/')
+		sa, sb := a, b
+		if ia >= 0 {
+			sa = a[:ia]
+		}
+		if ib >= 0 {
+			sb = b[:ib]
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+		if ia < 0 || ib < 0 {
+			return cmpBool(ia >= 0, ib >= 0)
+		}
+		a, b = a[ia+1:], b[ib+1:]
+	}
+}
+
+func compareTimestamps(a, b *timestamppb.Timestamp) int {
+	if c := cmpInt64(a.GetSeconds(), b.GetSeconds()); c != 0 {
+		return c
+	}
+	return cmpInt64(int64(a.GetNanos()), int64(b.GetNanos()))
+}
+
+// Whether a and b are equal for a filter: numbers by value whatever
+// their type, NaN equal to nothing
+func equalValues(a, b *pb.Value) bool {
+	if rank(a) == rankNumber && rank(b) == rankNumber {
+		if math.IsNaN(number(a)) || math.IsNaN(number(b)) {
+			return false
+		}
+	}
+	return compareValues(a, b) == 0
+}
+
+func isNaN(v *pb.Value) bool {
+	d, ok := v.GetValueType().(*pb.Value_DoubleValue)
+	return ok && math.IsNaN(d.DoubleValue)
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.GetValueType().(*pb.Value_NullValue)
+	return ok
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int { return cmpInt64(int64(a), int64(b)) }
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	}
+	return 1
+}
+
+func sortedKeys(m map[string]*pb.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Split a field path like a.`b.c`.d into its segments
+func splitFieldPath(path string) []string {
+	var (
+		segs   []string
+		cur    strings.Builder
+		quoted bool
+	)
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '`':
+			quoted = !quoted
+		case c == '\\' && quoted && i+1 < len(path):
+			i++
+			cur.WriteByte(path[i])
+		case c == '.' && !quoted:
+			segs = append(segs, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(segs, cur.String())
+}
+
+// The value at path in fields, if there is one
+func getField(fields map[string]*pb.Value, path []string) (*pb.Value, bool) {
+	for i, seg := range path {
+		v, ok := fields[seg]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		m, ok := v.GetValueType().(*pb.Value_MapValue)
+		if !ok {
+			return nil, false
+		}
+		fields = m.MapValue.GetFields()
+	}
+	return nil, false
+}
+
+// Set path in fields to v, creating or replacing the maps on the way
+func setField(fields map[string]*pb.Value, path []string, v *pb.Value) {
+	for _, seg := range path[:len(path)-1] {
+		next, ok := fields[seg].GetValueType().(*pb.Value_MapValue)
+		if !ok {
+			next = &pb.Value_MapValue{MapValue: &pb.MapValue{}}
+			fields[seg] = &pb.Value{ValueType: next}
+		}
+		if next.MapValue.Fields == nil {
+			next.MapValue.Fields = map[string]*pb.Value{}
+		}
+		fields = next.MapValue.Fields
+	}
+	fields[path[len(path)-1]] = v
+}
+
+// Remove path from fields, if it is there
+func deleteField(fields map[string]*pb.Value, path []string) {
+	for _, seg := range path[:len(path)-1] {
+		next, ok := fields[seg].GetValueType().(*pb.Value_MapValue)
+		if !ok {
+			return
+		}
+		fields = next.MapValue.GetFields()
+	}
+	delete(fields, path[len(path)-1])
+}
+
+// Keep only the fields under paths, as a read mask or a projection does
+func maskFields(fields map[string]*pb.Value, paths []string) map[string]*pb.Value {
+	masked := map[string]*pb.Value{}
+	for _, p := range paths {
+		path := splitFieldPath(p)
+		if v, ok := getField(fields, path); ok {
+			setField(masked, path, proto.Clone(v).(*pb.Value))
+		}
+	}
+	return masked
+}
+
+func timestampValue(t time.Time) *pb.Value {
+	return &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: timestamppb.New(t)}}
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Access log: one entry per request, in the LOG_FORMAT of the other logs
+// (a Cloud Logging httpRequest under json). While the accessLogSampling
+// flag is on, fast successful requests are logged at
+// ACCESS_LOG_SAMPLE_RATE; errors (4xx/5xx) and requests slower than
+// ACCESS_LOG_SLOW always are. Every entry carries its sampleRate, so
+// counting each entry as 1/sampleRate requests recovers the totals. The
+// decision hashes the request ID, so it is the same wherever it is made
+// for one request.
+var (
+	accessLogSampleRate = config.Float("ACCESS_LOG_SAMPLE_RATE", 0.01)
+	accessLogSlow       = config.Duration("ACCESS_LOG_SLOW", time.Second)
+)
+
+// Why a request was logged
+const (
+	accessLogError   = "error"
+	accessLogSlowReq = "slow"
+	accessLogSampled = "sampled"
+	accessLogAll     = "unsampled" // sampling is off
+)
+
+var (
+	accessLogLogged  = map[string]*atomic.Int64{accessLogError: {}, accessLogSlowReq: {}, accessLogSampled: {}, accessLogAll: {}}
+	accessLogDropped atomic.Int64
+)
+
+// logHTTPRequest is Cloud Logging's httpRequest
+type logHTTPRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	ResponseSize  int64  `json:"responseSize,string"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Latency       string `json:"latency"`
+	Protocol      string `json:"protocol"`
+}
+
+// Whether the request with this ID falls in a sample of the given rate
+func sampledRequestID(id string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// Why to log a finished request, and the rate it was sampled at; "" to skip it
+func accessLogDecision(r *http.Request, status int, latency time.Duration) (string, float64) {
+	switch {
+	case !flags.enabled(flagAccessLogSampling):
+		return accessLogAll, 1
+	case status >= 400:
+		return accessLogError, 1
+	case latency >= accessLogSlow:
+		return accessLogSlowReq, 1
+	case sampledRequestID(requestID(r), accessLogSampleRate):
+		return accessLogSampled, accessLogSampleRate
+	}
+	return "", 0
+}
+
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !flags.enabled(flagAccessLog) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		latency := time.Since(start)
+		reason, rate := accessLogDecision(r, rec.status, latency)
+		if reason == "" {
+			accessLogDropped.Add(1)
+			return
+		}
+		accessLogLogged[reason].Add(1)
+		writeAccessLog(r, rec, latency, reason, rate)
+	})
+}
+
+func writeAccessLog(r *http.Request, rec *statusRecorder, latency time.Duration, reason string, rate float64) {
+	if logFormat != "json" {
+		logCtx(r.Context(), "📝 %s %s %d %dB %v rid=%s sample=%s/%g", r.Method, r.URL.RequestURI(), rec.status,
+			rec.bytes, latency.Round(time.Microsecond), requestID(r), reason, rate)
+		return
+	}
+	e := logEntry{
+		Message:      fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.status),
+		RequestID:    requestID(r),
+		SampleRate:   rate,
+		SampleReason: reason,
+		HTTPRequest: &logHTTPRequest{
+			RequestMethod: r.Method,
+			RequestURL:    r.URL.RequestURI(),
+			Status:        rec.status,
+			ResponseSize:  rec.bytes,
+			UserAgent:     r.UserAgent(),
+			RemoteIP:      clientIP(r),
+			Latency:       strconv.FormatFloat(latency.Seconds(), 'f', 6, 64) + "s",
+			Protocol:      r.Proto,
+		},
+	}
+	switch {
+	case rec.status >= 500:
+		e.Severity = "ERROR"
+	case rec.status >= 400:
+		e.Severity = "WARNING"
+	default:
+		e.Severity = "INFO"
+	}
+	if tc, ok := traceFromContext(r.Context()); ok {
+		if e.Trace = cloudTraceName(tc.traceID); e.Trace != "" {
+			e.SpanID, e.TraceSampled = tc.spanID, tc.sampled
+		}
+	}
+	writeLogEntry(e)
+}
+
+// Prometheus series for the access log, for metricsHandler
+func writeAccessLogMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP access_log_entries_total Requests written to the access log, by why they were logged.")
+	fmt.Fprintln(w, "# TYPE access_log_entries_total counter")
+	for _, reason := range []string{accessLogError, accessLogSlowReq, accessLogSampled, accessLogAll} {
+		fmt.Fprintf(w, "access_log_entries_total{reason=%q} %d\n", reason, accessLogLogged[reason].Load())
+	}
+	fmt.Fprintln(w, "# HELP access_log_dropped_total Fast successful requests left out of the access log by sampling.")
+	fmt.Fprintln(w, "# TYPE access_log_dropped_total counter")
+	fmt.Fprintf(w, "access_log_dropped_total %d\n", accessLogDropped.Load())
+}
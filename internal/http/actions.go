@@ -0,0 +1,33 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Custom methods invoked as POST /users/{id}:<action>
+func (s *Server) userActions() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"anonymize":  s.anonymizeUserHandler,
+		"archive":    s.archiveUserHandler,
+		"changePlan": s.changePlanHandler,
+		"clone":      s.cloneUserHandler,
+		"protect":    requireAdmin(s.protectUserHandler),
+		"reenrich":   s.reenrichUserHandler,
+		"unarchive":  s.unarchiveUserHandler,
+		"undo":       s.undoUserHandler,
+		"unprotect":  requireAdmin(s.unprotectUserHandler),
+	}
+}
+
+// Dispatch POST /users/{id}:<action> to the matching custom method
+func (s *Server) userActionHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(r.PathValue("id"), ":")
+	handler, known := s.userActions()[action]
+	if !ok || !known || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	r.SetPathValue("id", id)
+	handler(w, r)
+}
@@ -0,0 +1,291 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Anonymization replaces a user's personal data in place instead of
+// deleting the user, so createdAt, history and counters still add up.
+// ANONYMIZE_SALT keys the email hash in the placeholder address; set it
+// to keep placeholders stable across restarts and replicas.
+var anonymizeSalt = anonymizeSaltValue()
+
+const anonymizedName = "Deleted User"
+
+func anonymizeSaltValue() []byte {
+	if s := config.String("ANONYMIZE_SALT", ""); s != "" {
+		return []byte(s)
+	}
+	log.Printf("⚠️ ANONYMIZE_SALT not set; anonymized email placeholders will differ across restarts")
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+}
+
+// Placeholder for an anonymized email: a salted hash, never deliverable
+func anonymizedEmail(email string) string {
+	mac := hmac.New(sha256.New, anonymizeSalt)
+	mac.Write([]byte(model.NormalizeEmail(email)))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:24] + "@anonymized.invalid"
+}
+
+func isAnonymized(doc *firestore.DocumentSnapshot) bool {
+	v, ok := doc.Data()["anonymizedAt"]
+	return ok && v != nil
+}
+
+// Anonymize a user, reporting whether anything changed: a user already
+// anonymized is left as is. The email index entry is released. History
+// versions keep their op, actor and time but have the same fields
+// replaced, so undo can't bring the data back (and refuses anyway, as the
+// anonymization isn't a recorded version).
+func (s *Server) anonymizeUser(ctx context.Context, id, actor string, details map[string]interface{}, dryRun bool) (bool, error) {
+	ref := s.users.Users().Doc(id)
+	changed := false
+	var placeholder string
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		changed = false
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if isAnonymized(doc) {
+			return nil
+		}
+		if err := s.checkProtectionTx(ctx, tx, doc, "anonymize", actor); err != nil {
+			return err
+		}
+		user := userFromDoc(doc)
+		if err := s.releaseEmailTx(tx, user.Email, id); err != nil {
+			return err
+		}
+		placeholder = anonymizedEmail(user.Email)
+		err = tx.Update(ref, withUpdatedAt(withDerivedUpdates(doc.Data(), []firestore.Update{
+			{Path: "name", Value: anonymizedName},
+			{Path: "email", Value: placeholder},
+			{Path: "attributes", Value: firestore.Delete},
+			{Path: "anonymizedAt", Value: time.Now().UTC()},
+			{Path: historyVersionField, Value: firestore.Delete},
+		})))
+		if err != nil {
+			return err
+		}
+		if err := s.recordOutboxTx(tx, "user.anonymized", id, actor, nil); err != nil {
+			return err
+		}
+		changed = true
+		return s.recordAuditTx(tx, newAuditEntry("user.anonymize", id, actor, details))
+	})
+	if err != nil || dryRun || !changed {
+		return changed, err
+	}
+	s.enqueueSearchDelete(id)
+	if err := s.scrubHistory(ctx, ref, placeholder); err != nil {
+		logCtx(ctx, "⚠️ User %s anonymized but its history wasn't fully scrubbed: %v", id, err)
+	}
+	return true, nil
+}
+
+// Replace the personal fields in every history version's snapshots
+func (s *Server) scrubHistory(ctx context.Context, userRef *firestore.DocumentRef, email string) error {
+	iter := trackIterator("anonymize", historyCollection(userRef).Documents(ctx))
+	defer iter.Stop()
+	bw := s.client.BulkWriter(ctx)
+	defer bw.End()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var updates []firestore.Update
+		for _, snapshot := range []string{"data", "previous"} {
+			v, _ := doc.Data()[snapshot].(map[string]interface{})
+			if v == nil {
+				continue
+			}
+			scrubbed := withDerivedUpdates(v, []firestore.Update{
+				{Path: "name", Value: anonymizedName},
+				{Path: "email", Value: email},
+				{Path: "attributes", Value: firestore.Delete},
+			})
+			for _, u := range scrubbed {
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{snapshot, u.Path}, Value: u.Value})
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if _, err := bw.Update(doc.Ref, updates); err != nil {
+			return err
+		}
+	}
+}
+
+// Anonymize a user (POST /users/{id}:anonymize); repeating it is a no-op
+func (s *Server) anonymizeUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	changed, err := s.anonymizeUser(protectionContext(r), id, actorFromRequest(r, "anonymous"), nil, dryRunRequested(r))
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err == errUserProtected {
+		writeUserProtected(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error anonymizing user")
+		return
+	}
+	message := "User anonymized successfully"
+	if !changed {
+		message = "User was already anonymized"
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"id": id, "message": message, "anonymized": true})
+}
+
+// Anonymize every user matching a filter (POST /users:anonymizeWhere?confirm=true,
+// admin) as an anonymize_users job; the body is {"filter": [...]} as for
+// /users:updateWhere. ?dryRun=true only reports how many users match.
+func (s *Server) anonymizeWhereHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Filter userFilter `json:"filter"`
+	}
+	if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if err := req.Filter.validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	dryRun := dryRunRequested(r)
+	if !dryRun && r.URL.Query().Get("confirm") != "true" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Bulk anonymization requires confirm=true")
+		return
+	}
+	if !dryRun && !confirmProduction(w, r, "bulk anonymize users") {
+		return
+	}
+
+	ctx := requestContext(r)
+	matched, err := s.countFilterMatches(ctx, req.Filter, bulkUpdateMax)
+	if status.Code(err) == codes.FailedPrecondition {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "This filter needs a Firestore index that doesn't exist: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error counting matching users")
+		return
+	}
+	if matched > bulkUpdateMax {
+		writeError(w, r, http.StatusUnprocessableEntity, "too_many_matches",
+			fmt.Sprintf("Filter matches more than %d users; narrow it down", bulkUpdateMax))
+		return
+	}
+	if dryRun {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"matched": matched})
+		return
+	}
+
+	raw, _ := json.Marshal(req.Filter)
+	id, err := s.startJob(ctx, "anonymize_users", map[string]interface{}{
+		"filter": string(raw),
+		"actor":  actorFromRequest(r, "admin"),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting anonymization")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// Anonymized users keep matching most filters, so pages simply follow
+// the last document read
+func (s *Server) runAnonymizeUsersJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	var filter userFilter
+	raw, _ := run.job.Params["filter"].(string)
+	if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+		return nil, err
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	queries, err := filter.queries(s.users.Users())
+	if err != nil {
+		return nil, err
+	}
+	actor, _ := run.job.Params["actor"].(string)
+	details := map[string]interface{}{"jobId": run.job.ID}
+	matched, anonymized, failed, protected := 0, 0, 0, 0
+	result := func() map[string]interface{} {
+		return map[string]interface{}{"dryRun": run.dryRun(), "matched": matched, "anonymized": anonymized, "failed": failed, "protected": protected}
+	}
+	for _, base := range queries {
+		var last *firestore.DocumentSnapshot
+		for {
+			query := base.Limit(bulkUpdateBatchSize)
+			if last != nil {
+				query = query.StartAfter(last)
+			}
+			docs, err := query.Documents(ctx).GetAll()
+			if err != nil {
+				return result(), err
+			}
+			if len(docs) == 0 {
+				break
+			}
+			last = docs[len(docs)-1]
+			for _, doc := range docs {
+				if err := ctx.Err(); err != nil {
+					return result(), err
+				}
+				if !filter.matches(doc) {
+					continue
+				}
+				matched++
+				changed, err := s.anonymizeUser(ctx, doc.Ref.ID, actor, details, run.dryRun())
+				switch {
+				case err == nil && changed:
+					anonymized++
+				case err == errUserProtected:
+					protected++
+				case err != nil && err != store.ErrUserNotFound:
+					run.noteError(err)
+					failed++
+				}
+				run.progress(matched, 0, "")
+			}
+			if len(docs) < bulkUpdateBatchSize {
+				break
+			}
+		}
+	}
+	return result(), nil
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// App owns the server's long-lived components. Start brings them up in
+// dependency order, Firestore first and the listener last, so no request
+// or background loop sees state that isn't set up yet; Stop tears them
+// down in reverse, draining requests before the loops that serve them
+// and closing Firestore once nothing uses it. An App starts once.
+type App struct {
+	cfg        config.App
+	srv        *Server
+	server     *http.Server
+	components []component
+	serveErr   chan error
+
+	mu      sync.Mutex
+	started []startedComponent
+	addr    string
+}
+
+// component is one part of the App. start must not block for long; work
+// that outlives it runs on bg, which is stopped (after stop, if any) when
+// the App stops.
+type component struct {
+	name  string
+	start func(ctx context.Context, bg *background) error
+	stop  func(ctx context.Context) error
+}
+
+type startedComponent struct {
+	component
+	bg *background
+}
+
+// background runs a component's goroutines until it stops
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{ctx: ctx, cancel: cancel}
+}
+
+// Run f in a goroutine; its ctx is done when the component stops
+func (b *background) Go(f func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		f(b.ctx)
+	}()
+}
+
+// Cancel the goroutines and wait for them, or until ctx is done
+func (b *background) stop(ctx context.Context) error {
+	b.cancel()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func NewApp(cfg config.App, s *Server) *App {
+	if cfg.QuotaShards > 0 {
+		quotaShards = cfg.QuotaShards
+	}
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	a := &App{
+		cfg:      cfg,
+		srv:      s,
+		server:   &http.Server{Handler: s.newHandler(mux)},
+		serveErr: make(chan error, 1),
+	}
+	a.components = []component{
+		{name: "templates", start: func(context.Context, *background) error { return loadTemplates() }},
+		{name: "firestore", start: a.startFirestore, stop: func(context.Context) error { return s.client.Close() }},
+		{name: "seed", start: func(ctx context.Context, _ *background) error {
+			s.seedAtStartup(ctx)
+			return nil
+		}},
+		{name: "flags", start: func(_ context.Context, bg *background) error {
+			s.initFlags(bg)
+			return nil
+		}},
+		{name: "search", start: func(_ context.Context, bg *background) error {
+			s.initSearchIndexer(bg)
+			return nil
+		}},
+		{name: "enrichment", start: func(_ context.Context, bg *background) error {
+			s.initEnrichment(bg)
+			return nil
+		}},
+		{name: "outbox", start: func(_ context.Context, bg *background) error {
+			s.initOutbox(bg)
+			return nil
+		}},
+		{name: "invalidation", start: func(_ context.Context, bg *background) error {
+			s.initCacheInvalidation(bg)
+			return nil
+		}},
+		{name: "jobs", start: func(_ context.Context, bg *background) error {
+			s.startJobWorkers(bg)
+			return nil
+		}},
+		{name: "scheduler", start: func(_ context.Context, bg *background) error {
+			s.startScheduler(bg)
+			return nil
+		}},
+		{name: "usage", start: func(_ context.Context, bg *background) error {
+			s.startUsageRollup(bg)
+			return nil
+		}},
+		{name: "indexes", start: func(_ context.Context, bg *background) error {
+			s.startIndexCheck(bg)
+			return nil
+		}},
+		{name: "debug", start: func(_ context.Context, bg *background) error {
+			startDebugListener(bg)
+			return nil
+		}},
+		{name: "http", start: a.startServer, stop: a.server.Shutdown},
+	}
+	return a
+}
+
+// Connect, ignoring the start context (see connectFirestore), and warm up
+func (a *App) startFirestore(context.Context, *background) error {
+	if lazyInit {
+		log.Printf("⚠️ LAZY_INIT only applies to subcommands; the server connects at startup")
+	}
+	if err := a.srv.connectFirestore(); err != nil {
+		return err
+	}
+	a.srv.warmUpFirestore()
+	return nil
+}
+
+// Dial the Server's client, for the server at startup and for
+// subcommands through ensureFirestore. It connects with a background context: the
+// client keeps the one it was created with for refreshing credentials.
+func (s *Server) connectFirestore() error {
+	c, err := s.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	s.useClient(c)
+	fmt.Println("✅ Connected to Firestore!")
+	s.setEnvironment()
+	return nil
+}
+
+// Listen before returning, so a taken port fails Start
+func (a *App) startServer(_ context.Context, bg *background) error {
+	ln, err := net.Listen("tcp", a.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.addr = ln.Addr().String()
+	a.mu.Unlock()
+	bg.Go(func(context.Context) {
+		if err := a.server.Serve(ln); err != http.ErrServerClosed {
+			a.serveErr <- err
+		}
+	})
+	fmt.Printf("🚀 Server started on http://%s/\n", ln.Addr())
+	return nil
+}
+
+// Start the components in order, each within ComponentTimeout. If one
+// fails, those already started are stopped again.
+func (a *App) Start(ctx context.Context) error {
+	for _, c := range a.components {
+		bg := newBackground()
+		startCtx, cancel := context.WithTimeout(ctx, a.cfg.ComponentTimeout)
+		err := c.start(startCtx, bg)
+		cancel()
+		if err != nil {
+			bg.cancel()
+			stopCtx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+			defer cancel()
+			return errors.Join(fmt.Errorf("starting %s: %w", c.name, err), a.Stop(stopCtx))
+		}
+		a.mu.Lock()
+		a.started = append(a.started, startedComponent{c, bg})
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop the started components in reverse order, each within
+// ComponentTimeout and all within ctx; the errors of every component that
+// didn't stop cleanly are returned together
+func (a *App) Stop(ctx context.Context) error {
+	a.mu.Lock()
+	started := a.started
+	a.started = nil
+	a.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		stopCtx, cancel := context.WithTimeout(ctx, a.cfg.ComponentTimeout)
+		if c.stop != nil {
+			if err := c.stop(stopCtx); err != nil {
+				errs = append(errs, fmt.Errorf("stopping %s: %w", c.name, err))
+			}
+		}
+		if err := c.bg.stop(stopCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %w", c.name, err))
+		}
+		cancel()
+	}
+	return errors.Join(errs...)
+}
+
+// The address the server listens on, once started
+func (a *App) Addr() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addr
+}
+
+// Delivers the error that stopped the server, if it stops on its own
+func (a *App) Failed() <-chan error {
+	return a.serveErr
+}
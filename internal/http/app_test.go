@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+func testAppConfig() config.App {
+	return config.App{Addr: "127.0.0.1:0", ComponentTimeout: 5 * time.Second, ShutdownTimeout: 10 * time.Second}
+}
+
+// GET path on a until stop is closed, counting the 200s; requests before
+// the listener is up or after it is gone are expected to fail
+func hammer(a *App, path string, stop <-chan struct{}, ok *atomic.Int32) {
+	hc := &http.Client{Timeout: 5 * time.Second}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		addr := a.Addr()
+		if addr == "" {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		resp, err := hc.Get("http://" + addr + path)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			ok.Add(1)
+		}
+		resp.Body.Close()
+	}
+}
+
+// Requests arrive while the App starts and stops, and Stop is called from
+// several goroutines at once. Components start in order and stop in
+// reverse; requests only see what started before the listener, and the
+// listener has drained before the components behind it stop.
+func TestAppStartStopConcurrently(t *testing.T) {
+	s := newTestServer(t)
+	a := NewApp(testAppConfig(), s)
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+
+	var store map[string]string // set by its component, unsynchronized on purpose
+	var inFlight, ticks atomic.Int32
+	a.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		time.Sleep(time.Millisecond)
+		w.Write([]byte(store["greeting"]))
+	})
+	a.components = []component{
+		{name: "store", start: func(context.Context, *background) error {
+			record("start store")
+			store = map[string]string{"greeting": "hello"}
+			return nil
+		}, stop: func(context.Context) error {
+			if n := inFlight.Load(); n != 0 {
+				t.Errorf("store stopped with %d requests in flight", n)
+			}
+			record("stop store")
+			return nil
+		}},
+		{name: "loop", start: func(_ context.Context, bg *background) error {
+			record("start loop")
+			bg.Go(func(ctx context.Context) {
+				ticker := time.NewTicker(time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						ticks.Add(1)
+					}
+				}
+			})
+			return nil
+		}, stop: func(context.Context) error {
+			record("stop loop")
+			return nil
+		}},
+		{name: "http", start: func(ctx context.Context, bg *background) error {
+			record("start http")
+			return a.startServer(ctx, bg)
+		}, stop: func(ctx context.Context) error {
+			record("stop http")
+			return a.server.Shutdown(ctx)
+		}},
+	}
+
+	stopHammer := make(chan struct{})
+	var served atomic.Int32
+	var clients sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			hammer(a, "/", stopHammer, &served)
+		}()
+	}
+
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for served.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var stoppers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		stoppers.Add(1)
+		go func() {
+			defer stoppers.Done()
+			if err := a.Stop(context.Background()); err != nil {
+				t.Errorf("Stop: %v", err)
+			}
+		}()
+	}
+	stoppers.Wait()
+	close(stopHammer)
+	clients.Wait()
+
+	want := []string{"start store", "start loop", "start http", "stop http", "stop loop", "stop store"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	after := ticks.Load()
+	time.Sleep(10 * time.Millisecond)
+	if ticks.Load() != after {
+		t.Error("the loop kept running after Stop")
+	}
+}
+
+// A component failing to start stops those started before it, in
+// reverse, and both errors are reported
+func TestAppStartFailureStopsStartedComponents(t *testing.T) {
+	s := newTestServer(t)
+	a := NewApp(testAppConfig(), s)
+	var events []string
+	fake := func(name string, startErr, stopErr error) component {
+		return component{
+			name: name,
+			start: func(context.Context, *background) error {
+				events = append(events, "start "+name)
+				return startErr
+			},
+			stop: func(context.Context) error {
+				events = append(events, "stop "+name)
+				return stopErr
+			},
+		}
+	}
+	a.components = []component{
+		fake("a", nil, nil),
+		fake("b", nil, errors.New("b won't stop")),
+		fake("c", errors.New("c won't start"), nil),
+		fake("d", nil, nil),
+	}
+	err := a.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "starting c: c won't start") || !strings.Contains(err.Error(), "stopping b: b won't stop") {
+		t.Errorf("Start = %v, want both the start and stop errors", err)
+	}
+	if want := []string{"start a", "start b", "start c", "stop b", "stop a"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if err := a.Stop(context.Background()); err != nil {
+		t.Errorf("Stop after a failed Start = %v, want nothing left to stop", err)
+	}
+}
+
+// The real App against the emulator, serving requests while it starts
+// and stops
+func TestAppAgainstEmulator(t *testing.T) {
+	s, _ := useEmulator(t)
+	a := NewApp(testAppConfig(), s)
+	for i, c := range a.components {
+		if c.name == "firestore" {
+			// useEmulator connected, and closes the client after the test
+			a.components[i] = component{name: c.name, start: func(context.Context, *background) error { return nil }}
+		}
+	}
+
+	stopHammer := make(chan struct{})
+	var served atomic.Int32
+	var clients sync.WaitGroup
+	for _, path := range []string{"/healthz", "/listUsers", "/v1/users", "/version"} {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			hammer(a, path, stopHammer, &served)
+		}()
+	}
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for served.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.Stop(ctx); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	close(stopHammer)
+	clients.Wait()
+}
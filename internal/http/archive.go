@@ -0,0 +1,377 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Inactive users move to users_archive/{id}, subcollections included, so
+// the hot collection stays small. ARCHIVE_KEEPS_EMAIL (default true) keeps
+// an archived user's email_index entry: the email stays taken and
+// unarchiving can't conflict. With false the email is released on archive
+// and reclaimed on unarchive, which fails if someone took it meanwhile.
+var archiveKeepsEmail = config.Bool("ARCHIVE_KEEPS_EMAIL", true)
+
+var errAlreadyLive = errors.New("a live user with this ID exists")
+
+func (s *Server) archiveCollection() *firestore.CollectionRef {
+	return s.client.Collection("users_archive")
+}
+
+// Move a user into the archive. Once the user is known to be movable,
+// subcollections are copied and the originals deleted only after the user
+// document itself has moved, so a failure part-way never loses data;
+// retrying completes the move. The transaction checks again in case the
+// user changed meanwhile.
+func (s *Server) archiveUser(ctx context.Context, id, actor string, dryRun bool) error {
+	src, dst := s.users.Users().Doc(id), s.archiveCollection().Doc(id)
+	doc, err := src.Get(ctx)
+	if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+		return store.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if isProtected(doc) && protectionOverride(ctx) == "" {
+		return errUserProtected
+	}
+	if err := s.copySubcollections(ctx, src, dst, dryRun); err != nil {
+		return err
+	}
+	var user model.User
+	err = s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(src)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.checkProtectionTx(ctx, tx, doc, "archive", actor); err != nil {
+			return err
+		}
+		user = userFromDoc(doc)
+		if !archiveKeepsEmail {
+			if err := s.releaseEmailTx(tx, user.Email, id); err != nil {
+				return err
+			}
+		}
+		data := doc.Data()
+		data["archivedAt"] = time.Now().UTC()
+		if err := tx.Set(dst, data); err != nil {
+			return err
+		}
+		if err := tx.Delete(src); err != nil {
+			return err
+		}
+		if err := s.recordTombstoneTx(tx, id, "archived"); err != nil {
+			return err
+		}
+		if err := s.recordOutboxTx(tx, "user.archived", id, actor, nil); err != nil {
+			return err
+		}
+		return s.recordAuditTx(tx, newAuditEntry("user.archive", id, actor, nil))
+	})
+	if err != nil || dryRun {
+		return err
+	}
+	s.enqueueSearchDelete(id)
+	if err := s.deleteSubcollections(ctx, src); err != nil {
+		logCtx(ctx, "⚠️ User %s archived but its live subcollections weren't all deleted: %v", id, err)
+	}
+	return nil
+}
+
+// Move an archived user back; the reverse of archiveUser
+func (s *Server) unarchiveUser(ctx context.Context, id, actor string, dryRun bool) (model.User, error) {
+	src, dst := s.archiveCollection().Doc(id), s.users.Users().Doc(id)
+	if _, err := src.Get(ctx); status.Code(err) == codes.NotFound {
+		return model.User{}, store.ErrUserNotFound
+	} else if err != nil {
+		return model.User{}, err
+	}
+	// Copying into a live user would mix the two users' subcollections
+	if _, err := dst.Get(ctx); err == nil {
+		return model.User{}, errAlreadyLive
+	} else if status.Code(err) != codes.NotFound {
+		return model.User{}, err
+	}
+	if err := s.copySubcollections(ctx, src, dst, dryRun); err != nil {
+		return model.User{}, err
+	}
+	var user model.User
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(src)
+		if status.Code(err) == codes.NotFound {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Get(dst); err == nil {
+			return errAlreadyLive
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		user = userFromDoc(doc)
+		// A kept entry still points at this user, so the claim is a no-op
+		if model.NormalizeEmail(user.Email) != "" {
+			if err := s.users.ClaimEmail(tx, user.Email, id); err != nil {
+				return err
+			}
+		}
+		data := doc.Data()
+		delete(data, "archivedAt")
+		deriveUserFields(data)
+		stampUpdatedAt(data)
+		if err := tx.Create(dst, data); err != nil {
+			return err
+		}
+		if err := tx.Delete(src); err != nil {
+			return err
+		}
+		if err := s.recordOutboxTx(tx, "user.unarchived", id, actor, nil); err != nil {
+			return err
+		}
+		return s.recordAuditTx(tx, newAuditEntry("user.unarchive", id, actor, nil))
+	})
+	if err != nil || dryRun {
+		return user, err
+	}
+	s.enqueueSearchUpsert(id, user)
+	if err := s.deleteSubcollections(ctx, src); err != nil {
+		logCtx(ctx, "⚠️ User %s unarchived but its archived subcollections weren't all deleted: %v", id, err)
+	}
+	return user, nil
+}
+
+// Delete the email index entry for email if userID owns it
+func (s *Server) releaseEmailTx(tx *firestore.Transaction, email, userID string) error {
+	if model.NormalizeEmail(email) == "" {
+		return nil
+	}
+	idx, err := tx.Get(s.users.EmailIndex(email))
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner, _ := idx.Data()["userId"].(string); owner != userID {
+		return nil
+	}
+	return tx.Delete(idx.Ref)
+}
+
+// Copy every document of every subcollection of src under dst. Writes
+// are Sets, so a retried move overwrites its earlier partial copy.
+func (s *Server) copySubcollections(ctx context.Context, src, dst *firestore.DocumentRef, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	bw := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	err := eachSubcollectionDoc(ctx, src, func(col string, doc *firestore.DocumentSnapshot) error {
+		job, err := bw.Set(dst.Collection(col).Doc(doc.Ref.ID), doc.Data())
+		jobs = append(jobs, job)
+		return err
+	})
+	bw.End()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) deleteSubcollections(ctx context.Context, ref *firestore.DocumentRef) error {
+	bw := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	err := eachSubcollectionDoc(ctx, ref, func(_ string, doc *firestore.DocumentSnapshot) error {
+		job, err := bw.Delete(doc.Ref)
+		jobs = append(jobs, job)
+		return err
+	})
+	bw.End()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachSubcollectionDoc(ctx context.Context, ref *firestore.DocumentRef, f func(col string, doc *firestore.DocumentSnapshot) error) error {
+	cols := ref.Collections(ctx)
+	for {
+		col, err := cols.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		iter := trackIterator("archive", col.Documents(ctx))
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err == nil {
+				err = f(col.ID, doc)
+			}
+			if err != nil {
+				iter.Stop()
+				return err
+			}
+		}
+		iter.Stop()
+	}
+}
+
+// Archive a user (POST /users/{id}:archive)
+func (s *Server) archiveUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := s.archiveUser(protectionContext(r), id, actorFromRequest(r, "anonymous"), dryRunRequested(r))
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err == errUserProtected {
+		writeUserProtected(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error archiving user")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, UserEnvelope{ID: id, Message: "User archived successfully", Archived: true})
+}
+
+// Restore an archived user (POST /users/{id}:unarchive)
+func (s *Server) unarchiveUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	user, err := s.unarchiveUser(requestContext(r), id, actorFromRequest(r, "anonymous"), dryRunRequested(r))
+	switch {
+	case err == store.ErrUserNotFound:
+		writeError(w, r, http.StatusNotFound, "user_not_found", "Archived user not found")
+		return
+	case err == errAlreadyLive:
+		writeError(w, r, http.StatusConflict, "conflict", "A live user with this ID already exists")
+		return
+	case err == store.ErrEmailTaken:
+		writeError(w, r, http.StatusConflict, "email_taken", "The archived user's email is now used by another user")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error unarchiving user")
+		return
+	}
+	user.AvatarURL = avatarURL(id, user)
+	writeUserResponse(w, r, "User unarchived successfully", id, &user)
+}
+
+// Start an archive_inactive job for users not seen since lastSeenBefore
+// (POST /users:archiveWhere, admin). lastSeenAt is the activity timestamp
+// on user documents; users without one are never archived by this.
+func (s *Server) archiveWhereHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LastSeenBefore *Timestamp `json:"lastSeenBefore"`
+	}
+	err := decodeJSON(r, &req)
+	var tsErr *timestampError
+	switch {
+	case err == errUnsupportedMediaType:
+		unsupportedMediaType(w, r, "application/json")
+		return
+	case errors.As(err, &tsErr):
+		invalidTimestamp(w, r, "lastSeenBefore")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	case req.LastSeenBefore == nil:
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "lastSeenBefore required")
+		return
+	}
+	id, err := s.startJob(requestContext(r), "archive_inactive", map[string]interface{}{
+		"lastSeenBefore": req.LastSeenBefore.Time,
+		"actor":          actorFromRequest(r, "admin"),
+		"dryRun":         dryRunRequested(r),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting archive job")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// Archived users drop out of the query, so each page is read from the
+// start again, skipping past soft-deleted users (which are never
+// archived), failures (which don't stop the job) and, in a dry run,
+// everything seen since nothing actually moves
+func (s *Server) runArchiveInactiveJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	before, _ := run.job.Params["lastSeenBefore"].(time.Time)
+	actor, _ := run.job.Params["actor"].(string)
+	base := s.users.Users().Where("lastSeenAt", "<", before).OrderBy("lastSeenAt", firestore.Asc).Limit(100)
+	archived, failed, protected := 0, 0, 0
+	var skip *firestore.DocumentSnapshot
+	for {
+		query := base
+		if skip != nil {
+			query = query.StartAfter(skip)
+		}
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return map[string]interface{}{"archived": archived, "failed": failed, "protected": protected}, err
+		}
+		if len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			if err := ctx.Err(); err != nil {
+				return map[string]interface{}{"archived": archived, "failed": failed, "protected": protected}, err
+			}
+			if isSoftDeleted(doc) {
+				skip = doc
+				continue
+			}
+			err := s.archiveUser(ctx, doc.Ref.ID, actor, run.dryRun())
+			switch {
+			case err == nil:
+				archived++
+			case err == errUserProtected:
+				protected++
+				skip = doc
+			case err == store.ErrUserNotFound:
+				// Deleted since the page was read
+				skip = doc
+			default:
+				run.noteError(err)
+				failed++
+				skip = doc
+			}
+			if run.dryRun() {
+				skip = doc
+			}
+			run.progress(archived+failed+protected, 0, "")
+		}
+	}
+	return map[string]interface{}{"dryRun": run.dryRun(), "archived": archived, "failed": failed, "protected": protected}, nil
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestArchiveKeepsEmailTaken(t *testing.T) {
+	s, ctx := useEmulator(t)
+	saved := archiveKeepsEmail
+	t.Cleanup(func() { archiveKeepsEmail = saved })
+	archiveKeepsEmail = true
+
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if err := s.archiveUser(ctx, id, "test", false); err != nil {
+		t.Fatalf("archiveUser: %v", err)
+	}
+	if _, err := s.createUser(ctx, model.User{Name: "Other Ada", Email: "ADA@example.com"}, "", "test", false); err != store.ErrEmailTaken {
+		t.Fatalf("creating a user with an archived email: err = %v, want store.ErrEmailTaken", err)
+	}
+	if _, err := s.unarchiveUser(ctx, id, "test", false); err != nil {
+		t.Fatalf("unarchiveUser: %v", err)
+	}
+	if doc, err := s.users.UserByEmail(ctx, "ada@example.com"); err != nil || doc.Ref.ID != id {
+		t.Fatalf("email index after unarchive: %v, %v; want user %s", doc, err, id)
+	}
+}
+
+func TestArchiveReleasesEmail(t *testing.T) {
+	s, ctx := useEmulator(t)
+	saved := archiveKeepsEmail
+	t.Cleanup(func() { archiveKeepsEmail = saved })
+	archiveKeepsEmail = false
+
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if err := s.archiveUser(ctx, id, "test", false); err != nil {
+		t.Fatalf("archiveUser: %v", err)
+	}
+	mustCreateUser(t, s, ctx, model.User{Name: "Other Ada", Email: "ada@example.com"})
+	if _, err := s.unarchiveUser(ctx, id, "test", false); err != store.ErrEmailTaken {
+		t.Fatalf("unarchiving after the email was reused: err = %v, want store.ErrEmailTaken", err)
+	}
+	if _, err := s.archiveCollection().Doc(id).Get(ctx); err != nil {
+		t.Fatalf("the failed unarchive lost the archived user: %v", err)
+	}
+}
+
+func TestArchiveLeavesSoftDeletedUsersAlone(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Gone", Email: "gone@example.com"})
+	ref := s.users.Users().Doc(id)
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "deletedAt", Value: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.archiveUser(ctx, id, "test", false); err != store.ErrUserNotFound {
+		t.Fatalf("archiving a soft-deleted user: err = %v, want store.ErrUserNotFound", err)
+	}
+	// Nothing was copied ahead of the refusal
+	copied, err := s.archiveCollection().Doc(id).Collection("history").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 0 {
+		t.Errorf("%d history entries copied into the archive of a soft-deleted user", len(copied))
+	}
+	if _, err := ref.Get(ctx); err != nil {
+		t.Errorf("soft-deleted user went missing: %v", err)
+	}
+	if _, err := s.archiveCollection().Doc(id).Get(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("archive document exists: %v", err)
+	}
+}
+
+func TestArchiveRefusesProtectedUserBeforeCopying(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Kept", Email: "kept@example.com"})
+	if _, err := s.users.Users().Doc(id).Update(ctx, []firestore.Update{{Path: "protected", Value: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.archiveUser(ctx, id, "test", false); err != errUserProtected {
+		t.Fatalf("archiving a protected user: err = %v, want errUserProtected", err)
+	}
+	copied, err := s.archiveCollection().Doc(id).Collection("history").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 0 {
+		t.Errorf("%d history entries copied into the archive of a protected user", len(copied))
+	}
+}
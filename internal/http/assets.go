@@ -0,0 +1,120 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Templates (assets/templates) and static files (assets/static, served at
+// /static/) are embedded in the binary. DEV=true reads them from disk on
+// every request instead, so edits show up without a rebuild.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+var devMode = config.Bool("DEV", false)
+
+var (
+	templates    *template.Template
+	assetHashes  sync.Map // static file name -> content hash, for cache busting
+	templateFunc = template.FuncMap{
+		"asset": assetURL,
+	}
+)
+
+func init() {
+	mime.AddExtensionType(".ico", "image/x-icon")
+}
+
+func assetsFS() fs.FS {
+	if devMode {
+		return os.DirFS("assets")
+	}
+	sub, _ := fs.Sub(embeddedAssets, "assets")
+	return sub
+}
+
+func parseTemplates() (*template.Template, error) {
+	return template.New("").Funcs(templateFunc).ParseFS(assetsFS(), "templates/*.html")
+}
+
+// Parse every template once at startup; a broken template stops the server
+func loadTemplates() error {
+	t, err := parseTemplates()
+	if err != nil {
+		return fmt.Errorf("failed to parse templates: %w", err)
+	}
+	templates = t
+	return nil
+}
+
+// Render a template in full before sending it, so an execution error is
+// a logged 500 rather than half a page
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	t := templates
+	if devMode {
+		var err error
+		if t, err = parseTemplates(); err != nil {
+			log.Printf("⚠️ Failed to parse templates: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error rendering page")
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("⚠️ Failed to render %s: %v", name, err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error rendering page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// URL of a static file with its content hash, e.g. /static/home.css?v=1a2b3c4d
+func assetURL(name string) string {
+	if devMode {
+		return "/static/" + name
+	}
+	if v, ok := assetHashes.Load(name); ok {
+		return "/static/" + name + "?v=" + v.(string)
+	}
+	raw, err := fs.ReadFile(assetsFS(), "static/"+name)
+	if err != nil {
+		log.Printf("⚠️ Unknown asset %q: %v", name, err)
+		return "/static/" + name
+	}
+	sum := sha256.Sum256(raw)
+	v := hex.EncodeToString(sum[:4])
+	assetHashes.Store(name, v)
+	return "/static/" + name + "?v=" + v
+}
+
+// Serve assets/static at /static/. A URL carrying the current hash never
+// changes, so it is cached for a year; others for an hour, nothing in dev mode.
+func staticHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		static, _ := fs.Sub(assetsFS(), "static")
+		switch {
+		case devMode:
+			w.Header().Set("Cache-Control", "no-cache")
+		case r.URL.Query().Get("v") != "" && r.URL.RequestURI() == assetURL(strings.TrimPrefix(r.URL.Path, "/static/")):
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		default:
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		}
+		http.StripPrefix("/static/", http.FileServerFS(static)).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+// Calls for /console: each form sends its endpoint's request with the
+// credentials entered on the page and shows what came back.
+(function () {
+	"use strict";
+
+	var stored = {"admin-token": "console.adminToken", "api-key": "console.apiKey"};
+	Object.keys(stored).forEach(function (id) {
+		var input = document.getElementById(id);
+		input.value = sessionStorage.getItem(stored[id]) || "";
+		input.addEventListener("change", function () {
+			sessionStorage.setItem(stored[id], input.value);
+		});
+	});
+
+	function request(form) {
+		var path = form.dataset.path;
+		var query = new URLSearchParams();
+		form.querySelectorAll("input[data-in]").forEach(function (input) {
+			if (input.value === "") {
+				return;
+			}
+			if (input.dataset.in === "path") {
+				path = path.replace("{" + input.name + "}", encodeURIComponent(input.value));
+			} else {
+				query.set(input.name, input.value);
+			}
+		});
+		var headers = {"Accept": "application/json"};
+		var token = document.getElementById("admin-token").value;
+		var key = document.getElementById("api-key").value;
+		if (token) {
+			headers["Authorization"] = "Bearer " + token;
+		}
+		if (key) {
+			headers["X-API-Key"] = key;
+		}
+		var init = {method: form.dataset.method, headers: headers, credentials: "same-origin"};
+		var body = form.querySelector("textarea[name=body]");
+		if (body && body.value.trim() !== "") {
+			headers["Content-Type"] = "application/json";
+			init.body = body.value;
+		}
+		var qs = query.toString();
+		return {url: path + (qs ? "?" + qs : ""), init: init};
+	}
+
+	function pretty(text) {
+		try {
+			return JSON.stringify(JSON.parse(text), null, 2);
+		} catch (e) {
+			return text;
+		}
+	}
+
+	document.querySelectorAll("form.endpoint").forEach(function (form) {
+		var out = form.querySelector(".response");
+		form.addEventListener("submit", function (event) {
+			event.preventDefault();
+			var req = request(form);
+			var started = performance.now();
+			out.hidden = false;
+			out.classList.remove("failed");
+			out.querySelector(".meta").textContent = req.init.method + " " + req.url + " …";
+			out.querySelector("pre").textContent = "";
+			fetch(req.url, req.init).then(function (res) {
+				return res.text().then(function (text) {
+					var ms = Math.round(performance.now() - started);
+					out.classList.toggle("failed", !res.ok);
+					out.querySelector(".meta").textContent = req.init.method + " " + req.url + " → " + res.status + " " + res.statusText +
+						" · " + ms + " ms · request " + (res.headers.get("X-Request-ID") || "unknown");
+					out.querySelector("pre").textContent = pretty(text);
+				});
+			}).catch(function (err) {
+				out.classList.add("failed");
+				out.querySelector(".meta").textContent = req.init.method + " " + req.url + " failed: " + err;
+			});
+		});
+	});
+})();
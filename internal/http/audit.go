@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// AuditEntry is one record in the audit_logs collection
+type AuditEntry struct {
+	Action   string                 `json:"action" firestore:"action"`
+	TargetID string                 `json:"targetId" firestore:"targetId"`
+	Actor    string                 `json:"actor" firestore:"actor"`
+	ClientIP string                 `json:"clientIp,omitempty" firestore:"clientIp,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"`
+	At       time.Time              `json:"at" firestore:"at"`
+}
+
+func newAuditEntry(action, targetID, actor string, details map[string]interface{}) AuditEntry {
+	return AuditEntry{
+		Action:   action,
+		TargetID: targetID,
+		Actor:    actor,
+		Details:  redactDetails(details),
+		At:       time.Now().UTC(),
+	}
+}
+
+// Who to attribute a change to: the request's principal, or fallback when anonymous
+func actorFromRequest(r *http.Request, fallback string) string {
+	if p := principalFromRequest(r); p != "" {
+		return p
+	}
+	return fallback
+}
+
+// Write an audit entry as part of a transaction so it commits with the change it describes
+func (s *Server) recordAuditTx(tx *firestore.Transaction, entry AuditEntry) error {
+	return tx.Create(s.client.Collection("audit_logs").NewDoc(), entry)
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// AVATAR_PROVIDER is gravatar (default), initials, or none.
+// AVATAR_DEFAULT_STYLE is passed to Gravatar as the d= fallback style.
+var (
+	avatarProvider     = config.String("AVATAR_PROVIDER", "gravatar")
+	avatarDefaultStyle = config.String("AVATAR_DEFAULT_STYLE", "identicon")
+)
+
+// Compute the avatar URL for a user ("" when avatars are disabled)
+func avatarURL(id string, user model.User) string {
+	switch avatarProvider {
+	case "none":
+		return ""
+	case "gravatar":
+		email := strings.ToLower(strings.TrimSpace(user.Email))
+		if email != "" {
+			sum := md5.Sum([]byte(email))
+			return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) +
+				"?d=" + url.QueryEscape(avatarDefaultStyle)
+		}
+	}
+	return "/users/" + url.PathEscape(id) + "/avatar.svg"
+}
+
+// Initials for a display name: first letters of the first and last words
+func avatarInitials(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return "?"
+	}
+	first := []rune(words[0])[0]
+	if len(words) == 1 {
+		return string(unicode.ToUpper(first))
+	}
+	last := []rune(words[len(words)-1])[0]
+	return string(unicode.ToUpper(first)) + string(unicode.ToUpper(last))
+}
+
+// Deterministic background color derived from the document ID
+func avatarColor(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("hsl(%d, 55%%, 45%%)", h.Sum32()%360)
+}
+
+func initialsAvatarSVG(id, name string) string {
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128" viewBox="0 0 128 128">`+
+		`<rect width="128" height="128" fill="%s"/>`+
+		`<text x="50%%" y="50%%" dy=".35em" text-anchor="middle" fill="#ffffff" font-family="Arial, sans-serif" font-size="52">%s</text>`+
+		`</svg>`, avatarColor(id), html.EscapeString(avatarInitials(name)))
+}
+
+// Serve a generated initials avatar (GET /users/{id}/avatar.svg)
+func (s *Server) avatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	doc, err := getDocument(requestContext(r), s.users.Users().Doc(userID))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	user := userFromDoc(doc)
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fmt.Fprint(w, initialsAvatarSVG(userID, user.Name))
+}
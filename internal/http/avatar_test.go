@@ -0,0 +1,88 @@
+package http
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Swap avatarProvider for the duration of a test
+func withAvatarProvider(t *testing.T, provider string) {
+	saved := avatarProvider
+	avatarProvider = provider
+	t.Cleanup(func() { avatarProvider = saved })
+}
+
+func TestAvatarInitials(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "?"},
+		{"   ", "?"},
+		{"Ada", "A"},
+		{"ada", "A"},
+		{"Ada Lovelace", "AL"},
+		{"Ada Augusta King Lovelace", "AL"},
+		{"  ada\tlovelace  ", "AL"},
+		{"élodie durand", "ÉD"},
+		{"Ωmega ψ", "ΩΨ"},
+		{"张伟", "张"},
+		{"محمد علي", "مع"},
+		{"Иван Петров", "ИП"},
+	}
+	for _, tt := range tests {
+		if got := avatarInitials(tt.name); got != tt.want {
+			t.Errorf("avatarInitials(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAvatarURL(t *testing.T) {
+	ada := model.User{Name: "Ada", Email: " Ada@Example.com "}
+	tests := []struct {
+		provider string
+		user     model.User
+		want     string
+	}{
+		// md5("ada@example.com"): the email is trimmed and lowercased first
+		{"gravatar", ada, "https://www.gravatar.com/avatar/3e3417d7ef77d5932a6734b916515ed5?d=identicon"},
+		{"gravatar", model.User{Name: "Ada"}, "/users/abc%2Fdef/avatar.svg"},
+		{"initials", ada, "/users/abc%2Fdef/avatar.svg"},
+		{"none", ada, ""},
+	}
+	for _, tt := range tests {
+		withAvatarProvider(t, tt.provider)
+		if got := avatarURL("abc/def", tt.user); got != tt.want {
+			t.Errorf("%s: avatarURL = %q, want %q", tt.provider, got, tt.want)
+		}
+	}
+}
+
+func TestAvatarURLOmittedWhenDisabled(t *testing.T) {
+	withAvatarProvider(t, "none")
+	user := model.User{Name: "Ada", Email: "ada@example.com"}
+	user.AvatarURL = avatarURL("abc", user)
+	raw, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "avatarUrl") {
+		t.Errorf("AVATAR_PROVIDER=none still sends avatarUrl: %s", raw)
+	}
+}
+
+func TestInitialsAvatarSVG(t *testing.T) {
+	svg := initialsAvatarSVG("abc", "<script> &")
+	if !strings.Contains(svg, ">&lt;&amp;</text>") {
+		t.Errorf("initials aren't escaped: %s", svg)
+	}
+	if color := avatarColor("abc"); !strings.Contains(svg, `fill="`+color+`"`) || color != avatarColor("abc") {
+		t.Errorf("background %s isn't the ID's color: %s", color, svg)
+	}
+	if avatarColor("abc") == avatarColor("abd") {
+		t.Errorf("different IDs share the color %s", avatarColor("abc"))
+	}
+}
@@ -0,0 +1,454 @@
+//go:build bench
+
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Benchmarks against Firestore, kept out of the normal run:
+//
+//	go test -tags bench -run '^$' -bench . -benchtime 20x
+//
+// They run on the in-memory Firestore unless FIRESTORE_EMULATOR_HOST
+// points them at an emulator. Besides ns/op each one reports docs/s
+// where it moves documents and its p95 latency. Once all have run, the
+// p95 of each one's final (largest) run is checked against the baseline,
+// failing the run when one is more than -bench.regression above it or
+// has no baseline at all; -bench.update writes this run into the
+// baseline instead. testdata/bench_baseline.json is measured on the
+// in-memory Firestore; keep a baseline of your own with -bench.baseline
+// to gate emulator runs.
+var (
+	benchBaseline   = flag.String("bench.baseline", "testdata/bench_baseline.json", "p95 baseline to check against or update")
+	benchUpdate     = flag.Bool("bench.update", false, "write this run's p95s into the -bench.baseline file")
+	benchRegression = flag.Float64("bench.regression", 0.25, "allowed p95 increase over the baseline, as a fraction")
+)
+
+var benchSizes = []int{1000, 10000, 50000}
+
+// Benchmark name -> p95 latency of its latest run
+var benchMeasured = struct {
+	sync.Mutex
+	p95 map[string]time.Duration
+}{p95: map[string]time.Duration{}}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if code == 0 {
+		if err := checkBenchBaseline(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// Compare the measured p95s with the baseline, or with -bench.update
+// merge them into it
+func checkBenchBaseline() error {
+	baseline := map[string]string{}
+	raw, err := os.ReadFile(*benchBaseline)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, &baseline); err != nil {
+			return fmt.Errorf("%s: %v", *benchBaseline, err)
+		}
+	}
+	benchMeasured.Lock()
+	defer benchMeasured.Unlock()
+	if *benchUpdate {
+		for name, p95 := range benchMeasured.p95 {
+			baseline[name] = p95.String()
+		}
+		raw, err := json.MarshalIndent(baseline, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*benchBaseline, append(raw, '\n'), 0o644)
+	}
+	var regressed, missing []string
+	for name, p95 := range benchMeasured.p95 {
+		s, ok := baseline[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		base, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", *benchBaseline, name, err)
+		}
+		if limit := time.Duration(float64(base) * (1 + *benchRegression)); p95 > limit {
+			regressed = append(regressed, fmt.Sprintf("%s: p95 %s, baseline %s, limit %s", name, p95, base, limit))
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("%s has no p95 for these benchmarks; run them with -bench.update:\n  %s", *benchBaseline, strings.Join(missing, "\n  "))
+	}
+	if len(regressed) > 0 {
+		slices.Sort(regressed)
+		return fmt.Errorf("p95 latency regressed beyond -bench.regression %.2f:\n  %s", *benchRegression, strings.Join(regressed, "\n  "))
+	}
+	return nil
+}
+
+// Latencies of the timed operations of one benchmark run
+type latencies []time.Duration
+
+// Time f as one operation
+func (l *latencies) time(f func()) {
+	start := time.Now()
+	f()
+	*l = append(*l, time.Since(start))
+}
+
+// Report the p95 and record it for the baseline check; each run of a
+// benchmark replaces the last, so the largest b.N counts
+func (l latencies) report(b *testing.B) {
+	if len(l) == 0 {
+		return
+	}
+	sorted := slices.Clone(l)
+	slices.Sort(sorted)
+	p95 := sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	b.ReportMetric(float64(p95.Microseconds())/1000, "p95-ms")
+	benchMeasured.Lock()
+	benchMeasured.p95[b.Name()] = p95
+	benchMeasured.Unlock()
+}
+
+func reportDocsPerSecond(b *testing.B, docs int) {
+	if s := b.Elapsed().Seconds(); s > 0 {
+		b.ReportMetric(float64(docs)/s, "docs/s")
+	}
+}
+
+// Serve r with h, failing the benchmark unless it answers 200
+func benchServe(b *testing.B, h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	if rec.Code != http.StatusOK {
+		b.Fatalf("%s %s = %d %s", r.Method, r.URL, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+// Fill the benchmark's project with n generated users, for the
+// benchmarks under it to read
+func seedBenchUsers(b *testing.B, s *Server, ctx context.Context, n int) {
+	b.Helper()
+	until := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i += generateBatchSize {
+		if err := s.writeGeneratedUsers(ctx, 1, until, i, min(i+generateBatchSize, n)); err != nil {
+			b.Fatalf("seeding %d users: %v", n, err)
+		}
+	}
+}
+
+// Numbers the emails of added users, unique across runs sharing a project
+var benchSeq atomic.Int64
+
+func BenchmarkListUsers(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			s, ctx := useEmulator(b)
+			seedBenchUsers(b, s, ctx, n)
+			b.Run("unpaged", func(b *testing.B) {
+				var l latencies
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					l.time(func() { benchServe(b, s.listUsersHandler, httptest.NewRequest(http.MethodGet, "/listUsers", nil)) })
+				}
+				reportDocsPerSecond(b, n*b.N)
+				l.report(b)
+			})
+			b.Run("paged", func(b *testing.B) {
+				var l latencies
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					l.time(func() {
+						token := ""
+						for {
+							r := httptest.NewRequest(http.MethodGet, "/v1/users?pageSize=100&pageToken="+token, nil)
+							var page UserListResponse
+							if err := json.Unmarshal(benchServe(b, s.v1ListUsersHandler, r).Body.Bytes(), &page); err != nil {
+								b.Fatal(err)
+							}
+							if token = page.NextPageToken; token == "" {
+								break
+							}
+						}
+					})
+				}
+				reportDocsPerSecond(b, n*b.N)
+				l.report(b)
+			})
+		})
+	}
+}
+
+func BenchmarkAddUser(b *testing.B) {
+	// Through the store: the email claim, history and outbox in one transaction
+	b.Run("unique", func(b *testing.B) {
+		s, ctx := useEmulator(b)
+		var l latencies
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			user := model.User{Name: "Bench User", Email: fmt.Sprintf("bench.%d@example.com", benchSeq.Add(1))}
+			l.time(func() { mustCreateUser(b, s, ctx, user) })
+		}
+		reportDocsPerSecond(b, b.N)
+		l.report(b)
+	})
+	// A bare document write, the floor the transaction adds to
+	b.Run("plain", func(b *testing.B) {
+		s, ctx := useEmulator(b)
+		var l latencies
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			data := userToData(model.User{Name: "Bench User", Email: fmt.Sprintf("bench.%d@example.com", benchSeq.Add(1)), Plan: model.PlanFree})
+			l.time(func() {
+				if _, err := s.users.Users().NewDoc().Create(ctx, data); err != nil {
+					b.Fatal(err)
+				}
+			})
+		}
+		reportDocsPerSecond(b, b.N)
+		l.report(b)
+	})
+}
+
+func BenchmarkExportUsers(b *testing.B) {
+	s, ctx := useEmulator(b)
+	const n = 10000
+	seedBenchUsers(b, s, ctx, n)
+	for _, format := range []string{"ndjson", "csv"} {
+		b.Run(format, func(b *testing.B) {
+			var l latencies
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.time(func() {
+					benchServe(b, s.exportUsersHandler, httptest.NewRequest(http.MethodGet, "/admin/users:export?format="+format, nil))
+				})
+			}
+			reportDocsPerSecond(b, n*b.N)
+			l.report(b)
+		})
+	}
+}
+
+// Fetch pages the way prefetchPages does, but one after the other: the
+// export before pipelining, for BenchmarkExportPipeline to compare with
+func serialPages(ctx context.Context, query firestore.Query, pageSize int, consume func([]*firestore.DocumentSnapshot) error) error {
+	ordered := query.OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+	page := ordered
+	for {
+		docs, err := page.Documents(ctx).GetAll()
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+		if err := consume(docs); err != nil {
+			return err
+		}
+		if len(docs) < pageSize {
+			return nil
+		}
+		page = ordered.StartAfter(docs[len(docs)-1])
+	}
+}
+
+// Wall clock of the export pipeline alone, fetching and CSV-encoding
+// every user, serially (lookahead 0) and with growing lookahead
+func BenchmarkExportPipeline(b *testing.B) {
+	const n = 50000
+	encode := func(docs []*firestore.DocumentSnapshot) error {
+		w := csv.NewWriter(io.Discard)
+		for _, doc := range docs {
+			w.Write(exportRow(doc))
+		}
+		w.Flush()
+		return w.Error()
+	}
+	s, ctx := useEmulator(b)
+	seedBenchUsers(b, s, ctx, n)
+	for _, lookahead := range []int{0, 1, 2, 3} {
+		name := fmt.Sprintf("lookahead=%d", lookahead)
+		if lookahead == 0 {
+			name = "serial"
+		}
+		b.Run(name, func(b *testing.B) {
+			var l latencies
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.time(func() {
+					var err error
+					if lookahead == 0 {
+						err = serialPages(ctx, s.users.Users().Query, exportPageSize, encode)
+					} else {
+						err = prefetchPages(ctx, s.users.Users().Query, exportPageSize, lookahead, encode)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				})
+			}
+			reportDocsPerSecond(b, n*b.N)
+			l.report(b)
+		})
+	}
+}
+
+func BenchmarkSearchWords(b *testing.B) {
+	inputs := []string{"Ada Lovelace", "ada.lovelace+newsletter@analytical-engines.example.com", "José María García-Pérez", "北京 用户 42"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, s := range inputs {
+			searchWords(s)
+		}
+	}
+}
+
+func BenchmarkScoreUser(b *testing.B) {
+	user := model.User{Name: "Ada Lovelace", Email: "ada.lovelace@analytical-engines.example.com"}
+	tokens := searchWords("ada love engines")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scoreUser(user, tokens)
+	}
+}
+
+// The response serialization path alone: writeJSON of a 100-user list,
+// in both naming conventions
+func BenchmarkWriteUserList(b *testing.B) {
+	until := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := make([]UserListEntry, 100)
+	for i := range users {
+		id, data := generatedUser(1, until, i)
+		user := userFromData(data)
+		user.AvatarURL = avatarURL(id, user)
+		users[i] = UserListEntry{ID: id, User: user}
+	}
+	for _, naming := range []string{"camel", "snake"} {
+		b.Run(naming, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/listUsers?case="+naming, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				writeJSON(w, r, http.StatusOK, users)
+				io.Copy(io.Discard, w.Body)
+			}
+		})
+	}
+}
+
+// The /listUsers path before and after typed responses: building the
+// entries of a 100-user page and encoding them, as UserListEntry values
+// and as the map literals they replaced
+func BenchmarkUserListEntries(b *testing.B) {
+	until := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids, users := make([]string, 100), make([]model.User, 100)
+	for i := range users {
+		id, data := generatedUser(1, until, i)
+		ids[i], users[i] = id, userFromData(data)
+	}
+	entries := map[string]func() interface{}{
+		"typed": func() interface{} {
+			out := []UserListEntry{}
+			for i, user := range users {
+				out = append(out, UserListEntry{ID: ids[i], User: user})
+			}
+			return out
+		},
+		"map": func() interface{} {
+			out := []map[string]interface{}{}
+			for i, user := range users {
+				out = append(out, map[string]interface{}{"id": ids[i], "user": user})
+			}
+			return out
+		},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+	encode := func(v interface{}) string {
+		w := httptest.NewRecorder()
+		writeJSON(w, r, http.StatusOK, v)
+		return w.Body.String()
+	}
+	if encode(entries["typed"]()) != encode(entries["map"]()) {
+		b.Fatal("typed and map entries encode differently")
+	}
+	for _, name := range []string{"typed", "map"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encode(entries[name]())
+			}
+		})
+	}
+}
+
+// Hydrating 50 cross-references: the batched helper against a Get per reference
+func BenchmarkFetchDocuments50(b *testing.B) {
+	const n = 50
+	refs := func(b *testing.B, s *Server, ctx context.Context) []*firestore.DocumentRef {
+		refs := make([]*firestore.DocumentRef, n)
+		for i := range refs {
+			user := model.User{Name: "Bench User", Email: fmt.Sprintf("bench.%d@example.com", benchSeq.Add(1))}
+			refs[i] = s.users.Users().Doc(mustCreateUser(b, s, ctx, user))
+		}
+		return refs
+	}
+	b.Run("batched", func(b *testing.B) {
+		s, ctx := useEmulator(b)
+		refs := refs(b, s, ctx)
+		var l latencies
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.time(func() {
+				if _, errs := s.fetchDocuments(ctx, refs); errs[0] != nil {
+					b.Fatal(errs[0])
+				}
+			})
+		}
+		reportDocsPerSecond(b, n*b.N)
+		l.report(b)
+	})
+	b.Run("sequential", func(b *testing.B) {
+		s, ctx := useEmulator(b)
+		refs := refs(b, s, ctx)
+		var l latencies
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.time(func() {
+				for _, ref := range refs {
+					if _, err := ref.Get(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+		reportDocsPerSecond(b, n*b.N)
+		l.report(b)
+	})
+}
@@ -0,0 +1,442 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Users and the audit log are exported to BigQuery for analytics as a
+// bigquery_export job, on demand (POST /admin/export/bigquery) or on
+// BIGQUERY_EXPORT_SCHEDULE. Rows go in through load jobs, which land
+// atomically: a load either adds all of its rows or none. Each table's
+// sync state in sync_state/bigquery holds its watermark and the load in
+// flight, recorded before the upload starts. A retried export first
+// settles that load by its job ID, so a failure at any point is resumed
+// from the watermark without duplicating rows.
+//
+// Incremental runs add the users whose Firestore update time is past the
+// watermark (every write path bumps it; finding them takes a full scan)
+// and the audit entries after it, so the users table is a change log:
+// the latest updateTime per id is the current row. Full runs replace
+// both tables. Tables are created with bigquerySchemas on first load.
+var (
+	bigqueryProject        = config.String("BIGQUERY_PROJECT", readCredentials().ProjectID)
+	bigqueryDataset        = config.String("BIGQUERY_DATASET", "")
+	bigqueryLocation       = config.String("BIGQUERY_LOCATION", "US")
+	bigqueryUsersTable     = config.String("BIGQUERY_USERS_TABLE", "users")
+	bigqueryAuditTable     = config.String("BIGQUERY_AUDIT_TABLE", "audit_logs")
+	bigqueryExportSchedule = config.String("BIGQUERY_EXPORT_SCHEDULE", "")
+	bigqueryPollInterval   = 5 * time.Second
+)
+
+var errBigQueryNotConfigured = errors.New("BIGQUERY_DATASET is not set")
+
+// bigqueryColumn maps one BigQuery column to a stored field
+type bigqueryColumn struct {
+	name, kind string // kind is the BigQuery type
+	value      func(doc *firestore.DocumentSnapshot) interface{}
+}
+
+// Columns per exported collection. Values go through bigqueryValue, the
+// single place Firestore types are mapped.
+var bigquerySchemas = map[string][]bigqueryColumn{
+	"users": {
+		{"id", "STRING", func(doc *firestore.DocumentSnapshot) interface{} { return doc.Ref.ID }},
+		{"name", "STRING", docField("name")},
+		{"email", "STRING", docField("email")},
+		{"attributes", "JSON", docField("attributes")},
+		{"referredBy", "STRING", docField("referredBy")},
+		{"createdAt", "TIMESTAMP", docField("createdAt")},
+		{"deleted", "BOOL", func(doc *firestore.DocumentSnapshot) interface{} { return isSoftDeleted(doc) }},
+		{"anonymized", "BOOL", func(doc *firestore.DocumentSnapshot) interface{} { return isAnonymized(doc) }},
+		{"updateTime", "TIMESTAMP", func(doc *firestore.DocumentSnapshot) interface{} { return doc.UpdateTime }},
+	},
+	"audit_logs": {
+		{"id", "STRING", func(doc *firestore.DocumentSnapshot) interface{} { return doc.Ref.ID }},
+		{"action", "STRING", docField("action")},
+		{"targetId", "STRING", docField("targetId")},
+		{"actor", "STRING", docField("actor")},
+		{"clientIp", "STRING", docField("clientIp")},
+		{"details", "JSON", docField("details")},
+		{"at", "TIMESTAMP", docField("at")},
+	},
+}
+
+func docField(name string) func(doc *firestore.DocumentSnapshot) interface{} {
+	return func(doc *firestore.DocumentSnapshot) interface{} { return doc.Data()[name] }
+}
+
+// Encode a Firestore value for a column of kind in a JSON load: timestamps
+// as RFC 3339, maps and arrays as JSON text for JSON columns, mismatched
+// types as null. A STRING column takes any value, as text: references as
+// their path, geopoints as "lat,lng", bytes as base64 and the rest as JSON.
+func bigqueryValue(kind string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch kind {
+	case "TIMESTAMP":
+		if t, ok := v.(time.Time); ok && !t.IsZero() {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+		return nil
+	case "JSON":
+		raw, err := json.Marshal(jsonSafe(v))
+		if err != nil {
+			return nil
+		}
+		return string(raw)
+	case "BOOL":
+		if b, ok := v.(bool); ok {
+			return b
+		}
+		return nil
+	default:
+		safe := jsonSafe(v)
+		if s, ok := safe.(string); ok {
+			return s
+		}
+		raw, err := json.Marshal(safe)
+		if err != nil {
+			return nil
+		}
+		return string(raw)
+	}
+}
+
+// Firestore values JSON can't encode as-is: timestamps, references and
+// geopoints as schemaExample shows them, and bytes as base64
+func jsonSafe(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *firestore.DocumentRef:
+		return v.Path
+	case *latlng.LatLng:
+		return fmt.Sprintf("%g,%g", v.Latitude, v.Longitude)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = jsonSafe(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = jsonSafe(item)
+		}
+		return out
+	}
+	return v
+}
+
+func bigqueryRow(collection string, doc *firestore.DocumentSnapshot) map[string]interface{} {
+	row := map[string]interface{}{}
+	for _, col := range bigquerySchemas[collection] {
+		row[col.name] = bigqueryValue(col.kind, col.value(doc))
+	}
+	return row
+}
+
+func bigquerySchema(collection string) *bigquery.TableSchema {
+	schema := &bigquery.TableSchema{}
+	for _, col := range bigquerySchemas[collection] {
+		mode := "NULLABLE"
+		if col.name == "id" {
+			mode = "REQUIRED"
+		}
+		schema.Fields = append(schema.Fields, &bigquery.TableFieldSchema{Name: col.name, Type: col.kind, Mode: mode})
+	}
+	return schema
+}
+
+// bigquerySync is one table's entry in sync_state/bigquery
+type bigquerySync struct {
+	Watermark  time.Time `firestore:"watermark"`  // rows up to here are loaded
+	PendingJob string    `firestore:"pendingJob"` // load in flight, "" when none
+	PendingTo  time.Time `firestore:"pendingTo"`  // its rows go up to here
+	Full       bool      `firestore:"full"`       // it replaces the table
+	LoadedAt   time.Time `firestore:"loadedAt"`
+	Rows       int       `firestore:"rows"` // in the last load
+}
+
+func (s *Server) bigquerySyncRef() *firestore.DocumentRef {
+	return s.client.Collection("sync_state").Doc("bigquery")
+}
+
+func (s *Server) readBigQuerySync(ctx context.Context, collection string) (bigquerySync, error) {
+	doc, err := s.bigquerySyncRef().Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return bigquerySync{}, nil
+	}
+	if err != nil {
+		return bigquerySync{}, err
+	}
+	var all map[string]bigquerySync
+	if err := doc.DataTo(&all); err != nil {
+		return bigquerySync{}, err
+	}
+	return all[collection], nil
+}
+
+func (s *Server) writeBigQuerySync(ctx context.Context, collection string, state bigquerySync) error {
+	_, err := s.bigquerySyncRef().Set(ctx, map[string]interface{}{collection: state}, firestore.MergeAll)
+	return err
+}
+
+func newBigQueryService(ctx context.Context) (*bigquery.Service, error) {
+	return bigquery.NewService(ctx, option.WithCredentialsFile(credentialsFile), option.WithScopes(bigquery.BigqueryScope))
+}
+
+// Start a bigquery_export job (POST /admin/export/bigquery, admin). The
+// optional body is {"mode": "incremental"|"full"}, incremental by default.
+func (s *Server) bigqueryExportHandler(w http.ResponseWriter, r *http.Request) {
+	if bigqueryDataset == "" {
+		writeError(w, r, http.StatusNotImplemented, "bigquery_not_configured", "No BigQuery dataset configured")
+		return
+	}
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+			unsupportedMediaType(w, r, "application/json")
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = "incremental"
+	}
+	if req.Mode != "incremental" && req.Mode != "full" {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "mode must be incremental or full")
+		return
+	}
+	id, err := s.startJob(requestContext(r), "bigquery_export", map[string]interface{}{"mode": req.Mode})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting export")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+func (s *Server) runBigQueryExportJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	if bigqueryDataset == "" {
+		return nil, errBigQueryNotConfigured
+	}
+	full := run.job.Params["mode"] == "full"
+	srv, err := newBigQueryService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureBigQueryDataset(ctx, srv); err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"mode": run.job.Params["mode"]}
+	rows := 0
+	for _, collection := range []string{"users", "audit_logs"} {
+		n, err := s.exportToBigQuery(ctx, srv, collection, full, run.job.ID)
+		result[collection] = n
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", collection, err)
+		}
+		rows += n
+		run.progress(rows, 0, "")
+	}
+	return result, nil
+}
+
+func ensureBigQueryDataset(ctx context.Context, srv *bigquery.Service) error {
+	_, err := srv.Datasets.Get(bigqueryProject, bigqueryDataset).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	_, err = srv.Datasets.Insert(bigqueryProject, &bigquery.Dataset{
+		DatasetReference: &bigquery.DatasetReference{ProjectId: bigqueryProject, DatasetId: bigqueryDataset},
+		Location:         bigqueryLocation,
+	}).Context(ctx).Do()
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return nil // created concurrently
+	}
+	if err == nil {
+		log.Printf("📦 Created BigQuery dataset %s.%s", bigqueryProject, bigqueryDataset)
+	}
+	return err
+}
+
+// Load collection's new rows (all rows when full) into its table and
+// advance the watermark; returns the rows loaded
+func (s *Server) exportToBigQuery(ctx context.Context, srv *bigquery.Service, collection string, full bool, jobID string) (int, error) {
+	state, err := s.readBigQuerySync(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	// Settle the load an earlier attempt left in flight
+	if state.PendingJob != "" {
+		done, err := waitBigQueryLoad(ctx, srv, state.PendingJob)
+		if err != nil {
+			return 0, err
+		}
+		if done && state.Full == full {
+			// It was this run's load
+			state.Watermark, state.LoadedAt = state.PendingTo, time.Now().UTC()
+			state.PendingJob, state.PendingTo, state.Full = "", time.Time{}, false
+			return state.Rows, s.writeBigQuerySync(ctx, collection, state)
+		}
+		if done {
+			state.Watermark = state.PendingTo
+		}
+		state.PendingJob, state.PendingTo, state.Full = "", time.Time{}, false
+	}
+
+	since := state.Watermark
+	if full {
+		since = time.Time{}
+	}
+	// Don't load up to "now": a write committed just before it may not be readable yet
+	to := time.Now().UTC().Add(-5 * time.Second)
+	loadID := fmt.Sprintf("gofirestoreapp_%s_%s_%d", collection, jobID, time.Now().UnixNano())
+	state.PendingJob, state.PendingTo, state.Full = loadID, to, full
+	if err := s.writeBigQuerySync(ctx, collection, state); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		pw.CloseWithError(s.eachBigQueryDoc(ctx, collection, since, to, func(doc *firestore.DocumentSnapshot) error {
+			rows++
+			return enc.Encode(bigqueryRow(collection, doc))
+		}))
+	}()
+	disposition := "WRITE_APPEND"
+	if full {
+		disposition = "WRITE_TRUNCATE"
+	}
+	table := bigqueryUsersTable
+	if collection == "audit_logs" {
+		table = bigqueryAuditTable
+	}
+	_, err = srv.Jobs.Insert(bigqueryProject, &bigquery.Job{
+		JobReference: &bigquery.JobReference{ProjectId: bigqueryProject, JobId: loadID, Location: bigqueryLocation},
+		Configuration: &bigquery.JobConfiguration{Load: &bigquery.JobConfigurationLoad{
+			DestinationTable:  &bigquery.TableReference{ProjectId: bigqueryProject, DatasetId: bigqueryDataset, TableId: table},
+			Schema:            bigquerySchema(collection),
+			SourceFormat:      "NEWLINE_DELIMITED_JSON",
+			CreateDisposition: "CREATE_IF_NEEDED",
+			WriteDisposition:  disposition,
+		}},
+	}).Media(pr, googleapi.ContentType("application/octet-stream")).Context(ctx).Do()
+	pr.Close()
+	var apiErr *googleapi.Error
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict) {
+		// Conflict means the insert went through on an earlier try
+		return 0, err
+	}
+	state.Rows = rows
+	if err := s.writeBigQuerySync(ctx, collection, state); err != nil {
+		return 0, err
+	}
+	done, err := waitBigQueryLoad(ctx, srv, loadID)
+	if err != nil {
+		return 0, err
+	}
+	if !done {
+		return 0, fmt.Errorf("load %s wasn't found after insert", loadID)
+	}
+	state.Watermark, state.LoadedAt = to, time.Now().UTC()
+	state.PendingJob, state.PendingTo, state.Full = "", time.Time{}, false
+	log.Printf("📦 Loaded %d %s rows into BigQuery (%s)", rows, collection, table)
+	return rows, s.writeBigQuerySync(ctx, collection, state)
+}
+
+// Wait for load jobID to finish: true when it loaded, false when it never
+// started (the upload failed), an error when it failed
+func waitBigQueryLoad(ctx context.Context, srv *bigquery.Service, jobID string) (bool, error) {
+	for {
+		job, err := srv.Jobs.Get(bigqueryProject, jobID).Location(bigqueryLocation).Context(ctx).Do()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if job.Status.State == "DONE" {
+			if job.Status.ErrorResult != nil {
+				log.Printf("⚠️ BigQuery load %s failed: %s", jobID, job.Status.ErrorResult.Message)
+				return false, nil
+			}
+			return true, nil
+		}
+		select {
+		case <-time.After(bigqueryPollInterval):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Visit the documents of collection changed in (since, to]: audit entries
+// by their at timestamp, users by Firestore update time over a full scan
+func (s *Server) eachBigQueryDoc(ctx context.Context, collection string, since, to time.Time, f func(*firestore.DocumentSnapshot) error) error {
+	if collection == "users" {
+		return prefetchPages(ctx, s.users.Users().Query, exportPageSize, exportLookahead, func(docs []*firestore.DocumentSnapshot) error {
+			for _, doc := range docs {
+				if doc.UpdateTime.After(since) && !doc.UpdateTime.After(to) {
+					if err := f(doc); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+	base := s.client.Collection("audit_logs").Where("at", "<=", to)
+	if !since.IsZero() {
+		base = base.Where("at", ">", since)
+	}
+	base = base.OrderBy("at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Limit(exportPageSize)
+	var last *firestore.DocumentSnapshot
+	for {
+		query := base
+		if last != nil {
+			query = query.StartAfter(last)
+		}
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if err := f(doc); err != nil {
+				return err
+			}
+		}
+		if len(docs) < exportPageSize {
+			return nil
+		}
+		last = docs[len(docs)-1]
+	}
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Every Firestore value type into every column kind the schemas use
+func TestBigQueryValue(t *testing.T) {
+	at := time.Date(2024, 1, 2, 5, 4, 5, 500000000, time.FixedZone("", 2*3600))
+	ref := &firestore.DocumentRef{ID: "u1", Path: "projects/p/databases/(default)/documents/users/u1"}
+	geo := &latlng.LatLng{Latitude: 52.5, Longitude: 13.25}
+	tests := []struct {
+		name                       string
+		v                          interface{}
+		str, timestamp, json, bool interface{} // per column kind
+	}{
+		{"null", nil, nil, nil, nil, nil},
+		{"string", "Ada", "Ada", nil, `"Ada"`, nil},
+		{"integer", int64(42), "42", nil, `42`, nil},
+		{"double", 1.5, "1.5", nil, `1.5`, nil},
+		{"boolean", true, "true", nil, `true`, true},
+		{"false", false, "false", nil, `false`, false},
+		{"timestamp", at, "2024-01-02T03:04:05.5Z", "2024-01-02T03:04:05.5Z", `"2024-01-02T03:04:05.5Z"`, nil},
+		{"zero timestamp", time.Time{}, "0001-01-01T00:00:00Z", nil, `"0001-01-01T00:00:00Z"`, nil},
+		{"bytes", []byte{0xde, 0xad, 0xbe, 0xef}, "3q2+7w==", nil, `"3q2+7w=="`, nil},
+		{"reference", ref, ref.Path, nil, `"` + ref.Path + `"`, nil},
+		{"geopoint", geo, "52.5,13.25", nil, `"52.5,13.25"`, nil},
+		{
+			"array", []interface{}{"a", int64(1), at, nil},
+			`["a",1,"2024-01-02T03:04:05.5Z",null]`, nil, `["a",1,"2024-01-02T03:04:05.5Z",null]`, nil,
+		},
+		{
+			"map", map[string]interface{}{"team": "core", "since": at, "manager": ref, "tags": []interface{}{"x"}},
+			`{"manager":"` + ref.Path + `","since":"2024-01-02T03:04:05.5Z","tags":["x"],"team":"core"}`, nil,
+			`{"manager":"` + ref.Path + `","since":"2024-01-02T03:04:05.5Z","tags":["x"],"team":"core"}`, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for kind, want := range map[string]interface{}{"STRING": tt.str, "TIMESTAMP": tt.timestamp, "JSON": tt.json, "BOOL": tt.bool} {
+				if got := bigqueryValue(kind, tt.v); got != want {
+					t.Errorf("%s column = %#v, want %#v", kind, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Every column of every schema has a kind bigqueryValue maps
+func TestBigQuerySchemaKinds(t *testing.T) {
+	for collection, columns := range bigquerySchemas {
+		schema := bigquerySchema(collection)
+		for i, col := range columns {
+			switch col.kind {
+			case "STRING", "TIMESTAMP", "JSON", "BOOL":
+			default:
+				t.Errorf("%s.%s: kind %s has no mapping", collection, col.name, col.kind)
+			}
+			if f := schema.Fields[i]; f.Name != col.name || f.Type != col.kind || (f.Mode == "REQUIRED") != (col.name == "id") {
+				t.Errorf("%s field %d = %s %s %s, want %s %s", collection, i, f.Name, f.Type, f.Mode, col.name, col.kind)
+			}
+		}
+	}
+}
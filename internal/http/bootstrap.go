@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bootstrapArtifact is a document the app expects to exist in a new
+// environment. Bootstrap creates it with data when missing and never
+// touches an existing one.
+type bootstrapArtifact struct {
+	description string
+	ref         func(s *Server) *firestore.DocumentRef
+	data        func() map[string]interface{}
+}
+
+// Documents a fresh environment needs
+var bootstrapArtifacts = []bootstrapArtifact{
+	{
+		description: "warm-up sentinel read at startup",
+		ref:         func(s *Server) *firestore.DocumentRef { return s.client.Collection("_warmup").Doc("ping") },
+		data:        func() map[string]interface{} { return map[string]interface{}{"createdAt": time.Now().UTC()} },
+	},
+	{
+		description: "default quota plan (QUOTA_DEFAULT_PLAN)",
+		ref:         func(s *Server) *firestore.DocumentRef { return s.client.Collection("plans").Doc(quotaDefaultPlan) },
+		data: func() map[string]interface{} {
+			return map[string]interface{}{"dailyWriteLimit": quotaDefaultLimit, "createdAt": time.Now().UTC()}
+		},
+	},
+}
+
+// gofirestoreapp bootstrap [--check] [--emit indexes,iam]
+//
+// Creates the documents in bootstrapArtifacts that don't exist yet. With
+// --check nothing is written and the exit status is 1 when any is missing.
+// --emit prints the composite index definitions and/or a minimal custom IAM
+// role as one JSON object on stdout, the report then going to stderr.
+func (s *Server) bootstrapCommand(args []string) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	check := fs.Bool("check", false, "report missing artifacts without creating them")
+	emit := fs.String("emit", "", "also print these as JSON on stdout: indexes, iam (comma separated)")
+	fs.Parse(args)
+
+	emitted := map[string]interface{}{}
+	for _, name := range strings.Split(*emit, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "indexes":
+			emitted["firestoreIndexes"] = indexDefinitions()
+		case "iam":
+			emitted["iamRole"] = iamRoleDefinition()
+		default:
+			fmt.Fprintf(os.Stderr, "unknown --emit value %q (want indexes or iam)\n", name)
+			return 2
+		}
+	}
+	var report io.Writer = os.Stdout
+	if len(emitted) > 0 {
+		report = os.Stderr
+	}
+
+	s.ensureFirestore()
+	defer s.client.Close()
+	ctx := withEndpoint(context.Background(), "bootstrap")
+	missing, failed := 0, 0
+	refs := make([]*firestore.DocumentRef, len(bootstrapArtifacts))
+	for i, a := range bootstrapArtifacts {
+		refs[i] = a.ref(s)
+	}
+	var checked []error
+	if *check {
+		_, checked = s.fetchDocuments(ctx, refs)
+	}
+	for i, a := range bootstrapArtifacts {
+		ref := refs[i]
+		var err error
+		if *check {
+			err = checked[i]
+		} else {
+			_, err = ref.Create(ctx, a.data())
+		}
+		switch {
+		case *check && err == errDocumentMissing:
+			missing++
+			fmt.Fprintf(report, "❌ missing  %s (%s)\n", artifactPath(ref), a.description)
+		case !*check && status.Code(err) == codes.AlreadyExists, *check && err == nil:
+			fmt.Fprintf(report, "⏭️ exists   %s\n", artifactPath(ref))
+		case err == nil:
+			fmt.Fprintf(report, "✅ created  %s (%s)\n", artifactPath(ref), a.description)
+		default:
+			failed++
+			fmt.Fprintf(report, "⚠️ error    %s: %v\n", artifactPath(ref), err)
+		}
+	}
+
+	if len(emitted) > 0 {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(emitted); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if failed > 0 || missing > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Path of ref within the database, e.g. plans/free
+func artifactPath(ref *firestore.DocumentRef) string {
+	_, path, _ := strings.Cut(ref.Path, "/documents/")
+	return path
+}
+
+// A custom role with the permissions the app uses, in the shape of
+// gcloud iam roles create --file and Terraform's
+// google_project_iam_custom_role. Sheets access is granted by sharing the
+// spreadsheet, not by IAM.
+func iamRoleDefinition() map[string]interface{} {
+	permissions := []string{
+		"datastore.databases.getMetadata",
+		"datastore.entities.create",
+		"datastore.entities.delete",
+		"datastore.entities.get",
+		"datastore.entities.list",
+		"datastore.entities.update",
+	}
+	if bigqueryDataset != "" {
+		permissions = append(permissions,
+			"bigquery.datasets.create",
+			"bigquery.datasets.get",
+			"bigquery.jobs.create",
+			"bigquery.tables.create",
+			"bigquery.tables.get",
+			"bigquery.tables.update",
+			"bigquery.tables.updateData",
+		)
+	}
+	sort.Strings(permissions)
+	return map[string]interface{}{
+		"roleId":              "gofirestoreapp",
+		"title":               "GoFirestoreApp service",
+		"description":         "Minimal permissions for the GoFirestoreApp server and its jobs",
+		"stage":               "GA",
+		"includedPermissions": permissions,
+	}
+}
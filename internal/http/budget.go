@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Request deadline budgets. With REQUEST_BUDGET set, each request gets
+// that long overall, and the store bounds every Firestore call by a share
+// of what is left (BUDGET_WEIGHTS, op=fraction): a slow first call then
+// leaves time for the rest instead of taking all of it, and one that runs
+// out fails as budget_exhausted (504) rather than a bare deadline error
+// from whichever call came next. requestContext drops cancellation, so
+// the budget is a context value each call turns into its own timeout.
+//
+// Side effects don't draw on it: audit entries and outbox events commit
+// in the write's transaction, and search sync, enrichment, cache
+// invalidation and referral cleanup are queued to run after the
+// response.
+var (
+	requestBudget = config.Duration("REQUEST_BUDGET", 0)
+	budgetWeights = parseBudgetWeights(config.String("BUDGET_WEIGHTS", "read=0.5,transaction=0.8"))
+)
+
+type budgetKey struct{}
+
+// budget is a request's deadline, and whether a call has timed out on it
+type budget struct {
+	deadline time.Time
+	exceeded atomic.Bool
+}
+
+func parseBudgetWeights(spec string) map[string]float64 {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(spec, ",") {
+		op, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w <= 0 || w > 1 {
+			log.Fatalf("❌ Invalid BUDGET_WEIGHTS entry %q: want op=fraction with 0 < fraction <= 1", part)
+		}
+		weights[op] = w
+	}
+	return weights
+}
+
+// Start each request's budget
+func budgetMiddleware(next http.Handler) http.Handler {
+	if requestBudget <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), budgetKey{}, &budget{deadline: time.Now().Add(requestBudget)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// What is left of ctx's request budget; ok is false without one
+func budgetRemaining(ctx context.Context) (time.Duration, bool) {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(b.deadline), true
+}
+
+// ctx bounded by op's share of the remaining budget (all of it for an op
+// without a weight); unchanged outside a budgeted request. Cancelling it
+// after the call records whether the call ran out of time.
+func withBudget(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return ctx, func() {}
+	}
+	weight, ok := budgetWeights[op]
+	if !ok {
+		weight = 1
+	}
+	opCtx, cancel := context.WithTimeout(ctx, time.Duration(float64(time.Until(b.deadline))*weight))
+	return opCtx, func() {
+		if opCtx.Err() == context.DeadlineExceeded {
+			b.exceeded.Store(true)
+		}
+		cancel()
+	}
+}
+
+// Whether a call of r's timed out on its budget, so a failure is its doing
+func budgetExhausted(r *http.Request) bool {
+	b, ok := r.Context().Value(budgetKey{}).(*budget)
+	return ok && b.exceeded.Load()
+}
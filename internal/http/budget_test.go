@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Give requests a budget for the duration of a test
+func withRequestBudget(t *testing.T, d time.Duration) {
+	t.Helper()
+	saved := requestBudget
+	requestBudget = d
+	t.Cleanup(func() { requestBudget = saved })
+}
+
+// A read of a hung store times out on its share of the budget, and the
+// request fails as budget_exhausted rather than a bare 500
+func TestBudgetSlowStoreTimesOut(t *testing.T) {
+	withRequestBudget(t, 200*time.Millisecond)
+	release, done := make(chan struct{}), make(chan struct{})
+	withFakeGet(t, func(ctx context.Context) (*firestore.DocumentSnapshot, error) {
+		defer close(done)
+		<-release
+		return nil, ctx.Err()
+	})
+	defer func() {
+		close(release) // the shared Get outlives the caller that gave up
+		<-done
+	}()
+
+	var took time.Duration
+	var readErr error
+	h := budgetMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, readErr = getDocument(requestContext(r), fakeDocumentRef("hung"))
+		took = time.Since(start)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error getting user")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/getUser?id=hung", nil))
+
+	if readErr != context.DeadlineExceeded {
+		t.Errorf("read error = %v, want DeadlineExceeded", readErr)
+	}
+	// read's weight is 0.5 of the 200ms
+	if took < 90*time.Millisecond || took > 180*time.Millisecond {
+		t.Errorf("read gave up after %v, want about 100ms", took)
+	}
+	if rec.Code != http.StatusGatewayTimeout || rec.Header().Get("X-Error-Code") != "budget_exhausted" {
+		t.Errorf("response = %d %s, want 504 budget_exhausted", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+}
+
+// A slow first call shrinks what the next may take, so the request ends
+// within its budget; calls that finish in time don't mark it exhausted
+func TestBudgetSharesWhatIsLeft(t *testing.T) {
+	withRequestBudget(t, 400*time.Millisecond)
+	want := &firestore.DocumentSnapshot{}
+	withFakeGet(t, func(ctx context.Context) (*firestore.DocumentSnapshot, error) {
+		time.Sleep(100 * time.Millisecond)
+		return want, nil
+	})
+
+	var first, second time.Duration
+	var exhaustedAfterFirst bool
+	h := budgetMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(r)
+		start := time.Now()
+		if doc, err := getDocument(ctx, fakeDocumentRef("slow")); err != nil || doc != want {
+			t.Errorf("first read = %v, %v", doc, err)
+		}
+		first = time.Since(start)
+		exhaustedAfterFirst = budgetExhausted(r)
+
+		// The second call's share is half of the ~300ms left
+		remaining, _ := budgetRemaining(ctx)
+		ctx, cancel := withBudget(ctx, "read")
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		second = time.Until(deadline)
+		if second > remaining/2+10*time.Millisecond {
+			t.Errorf("second call may take %v of %v left, want at most half", second, remaining)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/getUser?id=slow", nil))
+
+	if exhaustedAfterFirst {
+		t.Error("a read that finished in its share marked the budget exhausted")
+	}
+	if first < 100*time.Millisecond || second > 160*time.Millisecond || second < 100*time.Millisecond {
+		t.Errorf("first read took %v, second may take %v; want >= 100ms and about 150ms", first, second)
+	}
+}
+
+// Without REQUEST_BUDGET nothing is bounded
+func TestBudgetOff(t *testing.T) {
+	withRequestBudget(t, 0)
+	h := budgetMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := budgetRemaining(r.Context()); ok {
+			t.Error("request has a budget with REQUEST_BUDGET unset")
+		}
+		ctx, cancel := withBudget(r.Context(), "read")
+		defer cancel()
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("withBudget set a deadline without a budget")
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
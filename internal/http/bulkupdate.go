@@ -0,0 +1,393 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Bulk updates by filter (POST /users:updateWhere). Up to
+// BULK_UPDATE_MAX matching users run as a bulk_update job; larger ones
+// must use `gofirestoreapp bulk-update`. Only BULK_UPDATE_FIELDS may be
+// changed ("attributes.*" allows any attribute), and never email or plan,
+// whose changes go through the email index and POST /users/{id}:changePlan.
+var (
+	bulkUpdateMax    = config.Int("BULK_UPDATE_MAX", 10000)
+	bulkUpdateFields = strings.Split(config.String("BULK_UPDATE_FIELDS", "name,attributes.*"), ",")
+)
+
+// Users read and written per round
+const bulkUpdateBatchSize = 500
+
+// bulkUpdateSpec is the request body: a filter and the changes to make to
+// every user it matches, e.g.
+//
+//	{"filter": [{"field": "email", "op": "endsWith", "value": "@example.com"}],
+//	 "addToArray": {"attributes.tags": ["example-customer"]}}
+type bulkUpdateSpec struct {
+	Filter          userFilter               `json:"filter"`
+	Set             map[string]interface{}   `json:"set,omitempty"`
+	AddToArray      map[string][]interface{} `json:"addToArray,omitempty"`
+	RemoveFromArray map[string][]interface{} `json:"removeFromArray,omitempty"`
+}
+
+func bulkUpdateFieldAllowed(field string) bool {
+	for _, allowed := range bulkUpdateFields {
+		allowed = strings.TrimSpace(allowed)
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(field, prefix) && field != prefix {
+			return true
+		}
+		if field == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *bulkUpdateSpec) validate() error {
+	if err := s.Filter.validate(); err != nil {
+		return err
+	}
+	if len(s.Set)+len(s.AddToArray)+len(s.RemoveFromArray) == 0 {
+		return fmt.Errorf("nothing to update: give set, addToArray or removeFromArray")
+	}
+	seen := map[string]bool{}
+	check := func(field string) error {
+		switch field {
+		case "email":
+			return fmt.Errorf("email can't be bulk updated: each user's email index entry moves with it")
+		case "plan":
+			return fmt.Errorf("plan can't be bulk updated: use POST /users/{id}:changePlan")
+		}
+		if _, ok := filterFieldPath(field); !ok || !bulkUpdateFieldAllowed(field) {
+			return fmt.Errorf("field %q can't be bulk updated", field)
+		}
+		if seen[field] {
+			return fmt.Errorf("field %q is updated twice", field)
+		}
+		seen[field] = true
+		return nil
+	}
+	for field, v := range s.Set {
+		if err := check(field); err != nil {
+			return err
+		}
+		// The same typing a normal update enforces through User
+		if _, ok := v.(string); field == "name" && !ok {
+			return fmt.Errorf("%s must be a string", field)
+		}
+	}
+	for field := range s.AddToArray {
+		if err := check(field); err != nil {
+			return err
+		}
+	}
+	for field := range s.RemoveFromArray {
+		if err := check(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The Firestore updates for one user, nil if it already has the changes
+// (so a job that is taken over doesn't redo or re-audit them). Arrays are
+// written whole, computed from doc, so they are only right while doc is
+// current.
+func (s *bulkUpdateSpec) updatesFor(doc *firestore.DocumentSnapshot) []firestore.Update {
+	current := func(field string) interface{} {
+		path, _ := filterFieldPath(field)
+		v, _ := doc.DataAtPath(path)
+		return v
+	}
+	var updates []firestore.Update
+	for field, v := range s.Set {
+		if !sameJSON(current(field), v) {
+			path, _ := filterFieldPath(field)
+			updates = append(updates, firestore.Update{FieldPath: path, Value: v})
+		}
+	}
+	for field, values := range s.AddToArray {
+		existing, _ := current(field).([]interface{})
+		next := slices.Clone(existing)
+		for _, v := range values {
+			if !containsJSON(next, v) {
+				next = append(next, v)
+			}
+		}
+		if len(next) > len(existing) {
+			path, _ := filterFieldPath(field)
+			updates = append(updates, firestore.Update{FieldPath: path, Value: next})
+		}
+	}
+	for field, values := range s.RemoveFromArray {
+		existing, _ := current(field).([]interface{})
+		next := slices.DeleteFunc(slices.Clone(existing), func(item interface{}) bool { return containsJSON(values, item) })
+		if len(next) < len(existing) {
+			path, _ := filterFieldPath(field)
+			updates = append(updates, firestore.Update{FieldPath: path, Value: next})
+		}
+	}
+	return updates
+}
+
+// Compare values through their JSON form, so int64 from Firestore equals
+// float64 from a request body
+func sameJSON(a, b interface{}) bool {
+	ra, _ := json.Marshal(a)
+	rb, _ := json.Marshal(b)
+	return string(ra) == string(rb)
+}
+
+func containsJSON(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if sameJSON(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bulk update users matching a filter (POST /users:updateWhere?confirm=true, admin)
+//
+// ?dryRun=true only reports how many users match.
+func (s *Server) updateWhereHandler(w http.ResponseWriter, r *http.Request) {
+	var spec bulkUpdateSpec
+	if err := decodeJSON(r, &spec); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if err := spec.validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	dryRun := dryRunRequested(r)
+	if !dryRun && r.URL.Query().Get("confirm") != "true" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Bulk updates require confirm=true")
+		return
+	}
+	if !dryRun && !confirmProduction(w, r, "bulk update users") {
+		return
+	}
+
+	ctx := requestContext(r)
+	matched, err := s.countFilterMatches(ctx, spec.Filter, bulkUpdateMax)
+	if status.Code(err) == codes.FailedPrecondition {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "This filter needs a Firestore index that doesn't exist: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error counting matching users")
+		return
+	}
+	if matched > bulkUpdateMax {
+		writeError(w, r, http.StatusUnprocessableEntity, "too_many_matches",
+			fmt.Sprintf("Filter matches more than %d users; run it with gofirestoreapp bulk-update", bulkUpdateMax))
+		return
+	}
+	if dryRun {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"matched": matched})
+		return
+	}
+
+	raw, _ := json.Marshal(spec)
+	id, err := s.startJob(ctx, "bulk_update", map[string]interface{}{
+		"spec":  string(raw),
+		"actor": actorFromRequest(r, "admin"),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting bulk update")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+func (s *Server) runBulkUpdateJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	var spec bulkUpdateSpec
+	raw, _ := run.job.Params["spec"].(string)
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return nil, err
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	actor, _ := run.job.Params["actor"].(string)
+	return s.bulkUpdateUsers(ctx, &spec, actor, run.job.ID, run.dryRun(), func(matched, updated int) {
+		run.progress(matched, 0, "")
+	})
+}
+
+// Apply spec to every matching user, bulkUpdateBatchSize at a time. Each
+// user is updated in its own transaction through modifyUserFields, so it
+// gets a history entry, outbox event and search sync like any other
+// update, on the condition that it is unchanged since it was read; users
+// modified meanwhile are counted as conflicts and left alone. The audit entries of
+// the users actually updated are written after each batch. Protected
+// users are counted and skipped.
+func (s *Server) bulkUpdateUsers(ctx context.Context, spec *bulkUpdateSpec, actor, jobID string, dryRun bool, progress func(matched, updated int)) (map[string]interface{}, error) {
+	queries, err := spec.Filter.queries(s.users.Users())
+	if err != nil {
+		return nil, err
+	}
+	// Every audit entry attributes the change to the job and records the spec
+	details := map[string]interface{}{"jobId": jobID}
+	if len(spec.Set) > 0 {
+		details["set"] = spec.Set
+	}
+	if len(spec.AddToArray) > 0 {
+		details["addToArray"] = spec.AddToArray
+	}
+	if len(spec.RemoveFromArray) > 0 {
+		details["removeFromArray"] = spec.RemoveFromArray
+	}
+	matched, updated, conflicts, protected := 0, 0, 0, 0
+	result := func() map[string]interface{} {
+		return map[string]interface{}{"dryRun": dryRun, "matched": matched, "updated": updated, "conflicts": conflicts, "protected": protected}
+	}
+	for _, base := range queries {
+		var last *firestore.DocumentSnapshot
+		for {
+			query := base.Limit(bulkUpdateBatchSize)
+			if last != nil {
+				query = query.StartAfter(last)
+			}
+			docs, err := query.Documents(ctx).GetAll()
+			if err != nil {
+				return result(), err
+			}
+			if len(docs) == 0 {
+				break
+			}
+			last = docs[len(docs)-1]
+
+			var done []string
+			for _, doc := range docs {
+				if !spec.Filter.matches(doc) {
+					continue
+				}
+				matched++
+				if isProtected(doc) {
+					protected++
+					continue
+				}
+				updates := spec.updatesFor(doc)
+				if updates == nil || dryRun {
+					continue
+				}
+				user, err := s.modifyUserFields(withETag(ctx, documentETag(doc)), doc.Ref.ID, actor, false, updates)
+				switch {
+				case err == nil:
+					updated++
+					done = append(done, doc.Ref.ID)
+					s.enqueueSearchUpsert(doc.Ref.ID, user)
+				case err == errPreconditionFailed, err == store.ErrUserNotFound:
+					conflicts++
+				default:
+					return result(), err
+				}
+			}
+			if err := s.recordBulkAudit(ctx, done, actor, details); err != nil {
+				return result(), err
+			}
+			progress(matched, updated)
+			if len(docs) < bulkUpdateBatchSize {
+				break
+			}
+		}
+	}
+	return result(), nil
+}
+
+// Audit the users a batch updated
+func (s *Server) recordBulkAudit(ctx context.Context, ids []string, actor string, details map[string]interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	bw := s.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(ids))
+	for _, id := range ids {
+		job, err := bw.Create(s.client.Collection("audit_logs").NewDoc(), newAuditEntry("user.bulk_update", id, actor, details))
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gofirestoreapp bulk-update --file spec.json [--dry-run] [--confirm [--i-know-what-i-am-doing]]
+//
+// The same spec as POST /users:updateWhere, run in-process without the
+// match cap. A protected production project also needs
+// --i-know-what-i-am-doing to write.
+func (s *Server) bulkUpdateCommand(args []string) int {
+	fs := flag.NewFlagSet("bulk-update", flag.ExitOnError)
+	file := fs.String("file", "", "JSON spec (filter and changes); - for stdin")
+	dryRun := fs.Bool("dry-run", false, "count matches without writing")
+	confirm := fs.Bool("confirm", false, "required to write")
+	confirmed := fs.Bool("i-know-what-i-am-doing", false, "with --confirm, allow writing to a protected production project")
+	fs.Parse(args)
+
+	in := os.Stdin
+	if *file != "" && *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	var spec bulkUpdateSpec
+	if err := json.NewDecoder(in).Decode(&spec); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid spec:", err)
+		return 1
+	}
+	if err := spec.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid spec:", err)
+		return 1
+	}
+	if !*dryRun && !*confirm {
+		fmt.Fprintln(os.Stderr, "pass --confirm to write, or --dry-run")
+		return 2
+	}
+
+	s.ensureFirestore()
+	defer s.client.Close()
+	if !*dryRun && currentEnvironment.Protected && !*confirmed {
+		fmt.Fprintf(os.Stderr, "refusing to bulk update production project %s; pass --i-know-what-i-am-doing as well\n", currentEnvironment.Project)
+		return 2
+	}
+	ctx := withEndpoint(context.Background(), "cli bulk-update")
+	result, err := s.bulkUpdateUsers(ctx, &spec, "cli", "", *dryRun, func(matched, updated int) {
+		fmt.Printf("… %d matched, %d updated\n", matched, updated)
+	})
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Bulk update failed:", err)
+		return 1
+	}
+	return 0
+}
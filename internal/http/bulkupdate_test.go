@@ -0,0 +1,114 @@
+package http
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestBulkUpdateSpecValidate(t *testing.T) {
+	filter := userFilter{{Field: "name", Op: "==", Value: "Ada"}}
+	tests := []struct {
+		name    string
+		spec    bulkUpdateSpec
+		wantErr string // "" for valid
+	}{
+		{"set name", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"name": "Ada King"}}, ""},
+		{"set attribute", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"attributes.tier": "legacy"}}, ""},
+		{"add to array", bulkUpdateSpec{Filter: filter, AddToArray: map[string][]interface{}{"attributes.tags": {"x"}}}, ""},
+		{"nothing", bulkUpdateSpec{Filter: filter}, "nothing to update"},
+		{"name not a string", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"name": 1.0}}, "must be a string"},
+		{"email", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"email": "a@example.com"}}, "email index"},
+		{"plan", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"plan": "pro"}}, "changePlan"},
+		{"plan as array", bulkUpdateSpec{Filter: filter, AddToArray: map[string][]interface{}{"plan": {"pro"}}}, "changePlan"},
+		{"not allowed", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"referredBy": "x"}}, "can't be bulk updated"},
+		{"twice", bulkUpdateSpec{Filter: filter, Set: map[string]interface{}{"attributes.tags": []interface{}{}}, AddToArray: map[string][]interface{}{"attributes.tags": {"x"}}}, "updated twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBulkUpdateRecordsHistoryAndAudit(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com", Attributes: map[string]interface{}{"tags": []interface{}{"a", "b"}}})
+	other := mustCreateUser(t, s, ctx, model.User{Name: "Grace", Email: "grace@example.com"})
+	spec := &bulkUpdateSpec{
+		Filter:     userFilter{{Field: "name", Op: "==", Value: "Ada"}},
+		Set:        map[string]interface{}{"attributes.tier": "legacy"},
+		AddToArray: map[string][]interface{}{"attributes.tags": {"c", "a"}},
+	}
+	if _, err := s.bulkUpdateUsers(ctx, spec, "test", "job", false, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.users.Users().Doc(id).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, _ := doc.Data()["attributes"].(map[string]interface{})
+	if want := []interface{}{"a", "b", "c"}; !reflect.DeepEqual(attrs["tags"], want) || attrs["tier"] != "legacy" {
+		t.Errorf("attributes = %v, want tags %v and tier legacy", attrs, want)
+	}
+	if ops := historyOps(t, s, ctx, id); !reflect.DeepEqual(ops, []string{"create", "update"}) {
+		t.Errorf("history = %v, want create then update", ops)
+	}
+	if ops := historyOps(t, s, ctx, other); !reflect.DeepEqual(ops, []string{"create"}) {
+		t.Errorf("unmatched user's history = %v, want create only", ops)
+	}
+
+	// Nothing left to change: no second update and no second audit entry
+	if _, err := s.bulkUpdateUsers(ctx, spec, "test", "job", false, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	audits, err := s.client.Collection("audit_logs").Where("action", "==", "user.bulk_update").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].Data()["targetId"] != id {
+		t.Errorf("got %d bulk update audit entries, want 1 for %s", len(audits), id)
+	}
+
+	spec = &bulkUpdateSpec{
+		Filter:          spec.Filter,
+		RemoveFromArray: map[string][]interface{}{"attributes.tags": {"b"}},
+	}
+	if _, err := s.bulkUpdateUsers(ctx, spec, "test", "job", false, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = s.users.Users().Doc(id).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := doc.DataAtPath(firestore.FieldPath{"attributes", "tags"}); !reflect.DeepEqual(tags, []interface{}{"a", "c"}) {
+		t.Errorf("tags = %v, want [a c]", tags)
+	}
+}
+
+// Users a bulk update renames are found by their new name
+func TestBulkUpdateSyncsSearch(t *testing.T) {
+	s, ctx := useEmulator(t)
+	withMemoryIndexer(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	spec := &bulkUpdateSpec{
+		Filter: userFilter{{Field: "name", Op: "==", Value: "Ada"}},
+		Set:    map[string]interface{}{"name": "Countess Lovelace"},
+	}
+	if _, err := s.bulkUpdateUsers(ctx, spec, "test", "job", false, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	drainSearchQueue(t)
+	if ids := externalSearchIDs(t, s, "countess"); !reflect.DeepEqual(ids, []string{id}) {
+		t.Errorf("search for the new name = %v, want %s", ids, id)
+	}
+}
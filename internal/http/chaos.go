@@ -0,0 +1,257 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fault injection for resilience testing, off unless CHAOS_ENABLED=true.
+// Faults are injected by a gRPC interceptor on the Firestore client, below
+// the client's own retries, so a retried Unavailable behaves as it would
+// against a flaky backend. They come either from CHAOS_RATE, the chance
+// that any call to CHAOS_METHODS (all timed RPCs when empty) fails with
+// CHAOS_FAULT, or per request from the X-Chaos header, e.g.
+//
+//	X-Chaos: unavailable            first matching call fails with Unavailable
+//	X-Chaos: unavailable:3@Commit   first three Commits fail
+//	X-Chaos: slow:2s@RunQuery       every RunQuery is delayed 2s
+//	X-Chaos: notfound@GetDocument   first GetDocument returns NotFound
+//
+// When chaos is off the header is stripped and the interceptor isn't
+// installed at all. The chaos feature flag pauses injection at runtime.
+var (
+	chaosEnabled = config.Bool("CHAOS_ENABLED", false)
+	chaosRate    = config.Float("CHAOS_RATE", 0)
+	chaosFault   = config.String("CHAOS_FAULT", "unavailable")
+	chaosMethods = config.String("CHAOS_METHODS", "")
+	chaosFaults  = &chaosCounters{counts: map[chaosCountKey]int64{}}
+)
+
+type chaosKey struct{}
+
+// chaosRule is one fault from X-Chaos or the CHAOS_* settings
+type chaosRule struct {
+	kind      string        // unavailable, notfound, slow
+	delay     time.Duration // for slow
+	method    string        // RPC name; "" matches any
+	remaining atomic.Int64  // error faults left to inject; slow has no limit
+}
+
+func parseChaosRules(spec string) ([]*chaosRule, error) {
+	var rules []*chaosRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rule := &chaosRule{}
+		part, rule.method, _ = strings.Cut(part, "@")
+		kind, arg, hasArg := strings.Cut(part, ":")
+		rule.kind = strings.ToLower(kind)
+		switch rule.kind {
+		case "unavailable", "notfound":
+			n := int64(1)
+			if hasArg {
+				var err error
+				if n, err = strconv.ParseInt(arg, 10, 64); err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid fault count %q", arg)
+				}
+			}
+			rule.remaining.Store(n)
+		case "slow":
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 || d > time.Minute {
+				return nil, fmt.Errorf("invalid delay %q", arg)
+			}
+			rule.delay = d
+		default:
+			return nil, fmt.Errorf("unknown fault %q", kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// The fault for a call to method under the request's rules; rate-based
+// faults apply only to calls the header doesn't cover. Each call to
+// chaosFaultFor consumes one error fault.
+func chaosFaultFor(ctx context.Context, method string) *chaosRule {
+	if !flags.enabled(flagChaos) {
+		return nil
+	}
+	if rules, ok := ctx.Value(chaosKey{}).([]*chaosRule); ok {
+		var slow *chaosRule
+		for _, rule := range rules {
+			if rule.method != "" && rule.method != method {
+				continue
+			}
+			if rule.kind == "slow" {
+				slow = rule
+			} else if rule.remaining.Add(-1) >= 0 {
+				return rule
+			}
+		}
+		if slow != nil {
+			return slow
+		}
+	}
+	if chaosRate > 0 && chaosMethodSelected(method) && rand.Float64() < chaosRate {
+		return chaosRateRule
+	}
+	return nil
+}
+
+var chaosRateRule = func() *chaosRule {
+	if !chaosEnabled {
+		return nil
+	}
+	rules, err := parseChaosRules(chaosFault)
+	if err != nil || len(rules) != 1 {
+		log.Fatalf("❌ Invalid CHAOS_FAULT %q: %v", chaosFault, err)
+	}
+	return rules[0]
+}()
+
+func chaosMethodSelected(method string) bool {
+	if chaosMethods == "" {
+		return true
+	}
+	for _, m := range strings.Split(chaosMethods, ",") {
+		if strings.TrimSpace(m) == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply rule to a call: sleep for slow, otherwise return the injected error
+func injectChaos(ctx context.Context, rule *chaosRule, method string) error {
+	chaosFaults.add(rule.kind, method)
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	logCtx(ctx, "🐒 Chaos: injected %s into %s (request %q)", rule.kind, method, requestID)
+	switch rule.kind {
+	case "slow":
+		timer := time.NewTimer(rule.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	case "notfound":
+		return status.Error(codes.NotFound, "chaos: injected not found")
+	default:
+		return status.Error(codes.Unavailable, "chaos: injected unavailable")
+	}
+}
+
+// Client options installing the fault interceptors, none when chaos is off
+func chaosOptions() []grpc.DialOption {
+	if !chaosEnabled {
+		return nil
+	}
+	log.Printf("🐒 Chaos fault injection enabled (rate %.3f, fault %s)", chaosRate, chaosFault)
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(chaosUnaryOp),
+		grpc.WithChainStreamInterceptor(chaosStreamOp),
+	}
+}
+
+func chaosUnaryOp(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if rule := chaosFaultFor(ctx, path.Base(method)); rule != nil {
+		if err := injectChaos(ctx, rule, path.Base(method)); err != nil {
+			return err
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func chaosStreamOp(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if !untimedMethods[path.Base(method)] {
+		if rule := chaosFaultFor(ctx, path.Base(method)); rule != nil {
+			if err := injectChaos(ctx, rule, path.Base(method)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// Attach X-Chaos rules to the request context, or strip the header when
+// chaos is off so nothing downstream can act on it
+func chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec := r.Header.Get("X-Chaos")
+		r.Header.Del("X-Chaos")
+		if !chaosEnabled || !flags.enabled(flagChaos) || spec == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rules, err := parseChaosRules(spec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "Invalid X-Chaos header: "+err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chaosKey{}, rules)))
+	})
+}
+
+type chaosCountKey struct {
+	fault  string
+	method string
+}
+
+// chaosCounters counts injected faults since startup
+type chaosCounters struct {
+	mu     sync.Mutex
+	counts map[chaosCountKey]int64
+}
+
+func (c *chaosCounters) add(fault, method string) {
+	c.mu.Lock()
+	c.counts[chaosCountKey{fault, method}]++
+	c.mu.Unlock()
+}
+
+// Prometheus counters of injected faults, for metricsHandler
+func writeChaosMetrics(w io.Writer) {
+	if !chaosEnabled {
+		return
+	}
+	chaosFaults.mu.Lock()
+	keys := make([]chaosCountKey, 0, len(chaosFaults.counts))
+	counts := make(map[chaosCountKey]int64, len(chaosFaults.counts))
+	for k, n := range chaosFaults.counts {
+		keys = append(keys, k)
+		counts[k] = n
+	}
+	chaosFaults.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].fault != keys[j].fault {
+			return keys[i].fault < keys[j].fault
+		}
+		return keys[i].method < keys[j].method
+	})
+	fmt.Fprintln(w, "# HELP chaos_faults_injected_total Faults injected into Firestore calls, by fault and RPC.")
+	fmt.Fprintln(w, "# TYPE chaos_faults_injected_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "chaos_faults_injected_total{fault=%s,method=%s} %d\n", promLabel(k.fault), promLabel(k.method), counts[k])
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func enableChaos(t *testing.T, enabled bool) {
+	saved := chaosEnabled
+	chaosEnabled = enabled
+	t.Cleanup(func() { chaosEnabled = saved })
+}
+
+// When chaos is off the header never reaches the handlers, and no rules
+// are attached
+func TestChaosHeaderStrippedWhenOff(t *testing.T) {
+	enableChaos(t, false)
+	var header string
+	var rules interface{}
+	h := chaosMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, rules = r.Header.Get("X-Chaos"), r.Context().Value(chaosKey{})
+	}))
+	for _, spec := range []string{"unavailable@Commit", "not a rule"} {
+		r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+		r.Header.Set("X-Chaos", spec)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK || header != "" || rules != nil {
+			t.Errorf("X-Chaos: %s with chaos off = %d, header %q, rules %v; want 200 with neither", spec, rec.Code, header, rules)
+		}
+	}
+}
+
+// Header rules apply to their method only, and each error fault is used
+// up by one call
+func TestChaosFaultForConsumesRules(t *testing.T) {
+	rules, err := parseChaosRules("unavailable:2@Commit, slow:1s@RunQuery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), chaosKey{}, rules)
+	for i, tt := range []struct {
+		method, want string
+	}{
+		{"BatchGetDocuments", ""},
+		{"Commit", "unavailable"},
+		{"RunQuery", "slow"},
+		{"Commit", "unavailable"},
+		{"Commit", ""},
+		{"RunQuery", "slow"},
+	} {
+		got := ""
+		if rule := chaosFaultFor(ctx, tt.method); rule != nil {
+			got = rule.kind
+		}
+		if got != tt.want {
+			t.Errorf("call %d to %s: fault %q, want %q", i, tt.method, got, tt.want)
+		}
+	}
+}
+
+// An Unavailable Commit is retried by the client below the handler, so
+// the request succeeds and the fault is counted
+func TestChaosUnavailableCommitRetried(t *testing.T) {
+	enableChaos(t, true)
+	var opts []option.ClientOption
+	for _, opt := range chaosOptions() {
+		opts = append(opts, option.WithGRPCDialOption(opt))
+	}
+	s, ctx := useEmulator(t, opts...)
+
+	key := chaosCountKey{"unavailable", "Commit"}
+	chaosFaults.mu.Lock()
+	before := chaosFaults.counts[key]
+	chaosFaults.mu.Unlock()
+
+	r := jsonRequest(http.MethodPost, "/addUser", `{"name":"Ada","email":"ada@example.com"}`)
+	r.Header.Set("X-Chaos", "unavailable@Commit")
+	body := serveJSON(t, chaosMiddleware(http.HandlerFunc(s.addUserHandler)).ServeHTTP, r, http.StatusOK)
+
+	chaosFaults.mu.Lock()
+	injected := chaosFaults.counts[key] - before
+	chaosFaults.mu.Unlock()
+	if injected != 1 {
+		t.Errorf("injected %d Unavailable Commits, want 1", injected)
+	}
+	id, _ := body["id"].(string)
+	if doc, err := s.users.Users().Doc(id).Get(ctx); err != nil || !doc.Exists() {
+		t.Errorf("user %q after the retried Commit: %v", id, err)
+	}
+}
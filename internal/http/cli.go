@@ -0,0 +1,40 @@
+package http
+
+import (
+	"fmt"
+	"os"
+)
+
+// Subcommands (gofirestoreapp <command>); with none the server starts.
+// Those using Firestore are connected up front unless LAZY_INIT=true, and
+// call ensureFirestore where they first need it either way. doctor makes
+// its own client, to report a failing connection instead of dying on it.
+func (s *Server) commands() map[string]command {
+	return map[string]command{
+		"bootstrap":      {run: s.bootstrapCommand, firestore: true},
+		"bulk-update":    {run: s.bulkUpdateCommand, firestore: true},
+		"derived-fields": {run: s.derivedFieldsCommand, firestore: true},
+		"doctor":         {run: s.doctorCommand},
+		"indexes":        {run: indexesCommand},
+		"schema":         {run: s.schemaCommand, firestore: true},
+		"seed":           {run: s.seedCommand, firestore: true},
+	}
+}
+
+type command struct {
+	run       func(args []string) int
+	firestore bool // uses the Server's client
+}
+
+// RunCommand runs the subcommand args[0] with the rest of args as its
+// arguments, returning its exit status
+func (s *Server) RunCommand(args []string) int {
+	if cmd, ok := s.commands()[args[0]]; ok {
+		if cmd.firestore && !lazyInit {
+			s.ensureFirestore()
+		}
+		return cmd.run(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\nusage: gofirestoreapp [bootstrap|bulk-update|derived-fields|doctor|indexes|schema|seed]\n", args[0])
+	return 2
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	userclient "github.com/Altair-05/GoFirestoreApp/client"
+	"golang.org/x/oauth2"
+)
+
+// The client's errors carry what the server said about them
+func TestClientErrors(t *testing.T) {
+	ctx, users := emulatorUsers(t)
+
+	_, err := users.CreateUser(ctx, api.User{Name: "Ada", Plan: "platinum"})
+	var apiErr *userclient.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("CreateUser with an unknown plan = %v, want *client.Error", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Code != api.CodeInvalidField || apiErr.RequestID == "" ||
+		len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "plan" {
+		t.Errorf("error = %+v, want 422 %s on plan with a request ID", apiErr, api.CodeInvalidField)
+	}
+
+	if _, err := users.GetUser(ctx, "nobody"); !userclient.IsNotFound(err) {
+		t.Errorf("GetUser(nobody) = %v, want not found", err)
+	}
+	id := mustCreate(t, ctx, users, api.User{Name: "Ada", Email: "ada@example.com"})
+	if err := users.UpdateUser(ctx, "nobody", api.User{Name: "Ada"}); !userclient.IsNotFound(err) {
+		t.Errorf("UpdateUser(nobody) = %v, want not found", err)
+	}
+	if err := users.UpdateUser(ctx, id, api.User{Name: "Ada King", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := users.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Ada King" || u.Email != "ada@example.com" || u.Plan != api.PlanFree {
+		t.Errorf("GetUser = %+v", u)
+	}
+}
+
+// ListUsers follows page tokens through a createdAt range, newest first
+func TestClientListUsersRange(t *testing.T) {
+	ctx, users := emulatorUsers(t)
+	mustCreate(t, ctx, users, api.User{Name: "Before"})
+	after := time.Now()
+	for _, name := range []string{"First", "Second", "Third", "Fourth", "Fifth"} {
+		mustCreate(t, ctx, users, api.User{Name: name})
+	}
+	before := time.Now()
+	mustCreate(t, ctx, users, api.User{Name: "After"})
+
+	got, err := listNames(users.ListUsers(ctx, &userclient.ListUsersOptions{PageSize: 2, OrderBy: "createdAt", CreatedAfter: after, CreatedBefore: before}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Fifth,Fourth,Third,Second,First" {
+		t.Errorf("users created in the range = %s", got)
+	}
+	if got, err := listNames(users.ListUsers(ctx, &userclient.ListUsersOptions{PageSize: 3, OrderBy: "name"})); err != nil || got != "After,Before,Fifth,First,Fourth,Second,Third" {
+		t.Errorf("users by name = %s, %v", got, err)
+	}
+}
+
+// Behind a proxy that answers 503 now and then, the client retries
+// through to the server, sending its credentials every time
+func TestClientRetriesAndAuth(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]int{} // requests by method and path
+	var auth []string        // credentials sent, in order
+	requests := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[key]
+	}
+	credentials := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), auth...)
+	}
+	ctx, url := emulatorServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			key := r.Method + " " + r.URL.Path
+			seen[key]++
+			n := seen[key]
+			auth = append(auth, r.Header.Get("X-API-Key")+r.Header.Get("Authorization"))
+			mu.Unlock()
+			switch {
+			case r.URL.Query().Get("id") == "unavailable":
+				w.Header().Set("Retry-After", "3600")
+				http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			case n <= 2:
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	})
+
+	c, err := userclient.New(url, userclient.WithAPIKey("k1"), userclient.WithRetries(3, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := mustCreate(t, ctx, c, api.User{Name: "Ada", Email: "ada@example.com"})
+	if u, err := c.GetUser(ctx, id); err != nil || u.Name != "Ada" {
+		t.Fatalf("GetUser = %+v, %v", u, err)
+	}
+	if requests("POST /addUser") != 3 || requests("GET /v1/users/"+id) != 3 {
+		t.Errorf("%d creates and %d gets sent, want 3 of each", requests("POST /addUser"), requests("GET /v1/users/"+id))
+	}
+	for _, got := range credentials() {
+		if got != "k1" {
+			t.Errorf("a request was sent with credentials %q, want the API key", got)
+		}
+	}
+
+	// A Retry-After past the longest wait fails straight away
+	start := time.Now()
+	err = c.DeleteUser(ctx, "unavailable")
+	var apiErr *userclient.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || !strings.Contains(apiErr.Detail, "maintenance") {
+		t.Errorf("DeleteUser while down = %v, want the 503", err)
+	}
+	if n := requests("DELETE /deleteUser"); n != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("DeleteUser while down sent %d requests in %v, want 1 at once", n, time.Since(start))
+	}
+
+	// Out of retries, the last answer is the error
+	tokens, err := userclient.New(url, userclient.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "t0k3n"})), userclient.WithRetries(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := len(credentials())
+	if err := tokens.UpdateUser(ctx, id, api.User{Name: "Ada King"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("UpdateUser with one retry = %v, want the 503", err)
+	}
+	if got := credentials()[sent:]; len(got) != 2 || got[0] != "Bearer t0k3n" || got[1] != "Bearer t0k3n" {
+		t.Errorf("credentials sent = %q, want the bearer token twice", got)
+	}
+}
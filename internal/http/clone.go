@@ -0,0 +1,256 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/iterator"
+)
+
+// Max documents copied per subcollection (CLONE_MAX_DOCS)
+var cloneMaxDocs = config.Int("CLONE_MAX_DOCS", 1000)
+
+// Subcollections that belong to the original user: its history (the clone
+// starts its own with a create entry) and the consents it gave
+var cloneSkippedSubcollections = map[string]bool{"history": true, "consents": true}
+
+// Bookkeeping about the original user the clone doesn't inherit: its
+// protection, anonymization, referrals, merges, enrichment and the consent
+// state summarizing the consents left behind
+var cloneSkippedFields = []string{
+	"protected", "protectedChangedAt", "anonymizedAt",
+	"referredBy", "referralCount", "mergedInto", "deletedAt",
+	"currentConsents", "enrichmentStatus",
+	historyVersionField,
+}
+
+// Copy a user and its subcollections under a new ID (POST /users/{id}:clone?targetCollection=)
+//
+// The optional body overrides user fields, e.g. {"email": "qa+copy@example.com"},
+// and is checked like the body of POST /addUser.
+func (s *Server) cloneUserHandler(w http.ResponseWriter, r *http.Request) {
+	sourceID := r.PathValue("id")
+	target := r.URL.Query().Get("targetCollection")
+	if target == "" {
+		target = "users"
+	}
+	if !collectionAllowed(target) {
+		writeError(w, r, http.StatusForbidden, "collection_not_allowed", "Target collection not allowed: "+target)
+		return
+	}
+
+	overrides := map[string]interface{}{}
+	body, err := readJSONBody(r)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &overrides); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+	}
+
+	ctx := requestContext(r)
+	source, err := s.users.Users().Doc(sourceID).Get(ctx)
+	if err != nil || isSoftDeleted(source) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+
+	// The clone as a User, decoded and normalized the way addUser does it
+	clone := userFromDoc(source)
+	if _, ok := overrides["attributes"]; ok {
+		clone.Attributes = nil // replaced, not merged
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &clone); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+	}
+	if fe := normalizeUserPlan(&clone); fe != nil {
+		writeInvalidEnum(w, r, *fe)
+		return
+	}
+
+	data := source.Data()
+	normalizeUserData(data)
+	typed := userToData(clone)
+	for key := range overrides {
+		field, ok := firestoreFieldName(key)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "unknown_field", "Unknown field: "+key)
+			return
+		}
+		if v, ok := typed[field]; ok {
+			data[field] = v
+		} else {
+			delete(data, field)
+		}
+	}
+	data["plan"] = string(clone.Plan)
+	for _, field := range cloneSkippedFields {
+		delete(data, field)
+	}
+	data["clonedFrom"] = sourceID
+	now := time.Now().UTC()
+	data["createdAt"], data["updatedAt"] = now, now
+	deriveUserFields(data)
+
+	if target == "users" && !s.admitNewUser(w, r) {
+		return
+	}
+	dryRun := dryRunRequested(r)
+	newRef := s.client.Collection(target).NewDoc()
+	err = s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		// Only the users collection is covered by the email index
+		if target == "users" && model.NormalizeEmail(clone.Email) != "" {
+			if err := s.users.ClaimEmail(tx, clone.Email, newRef.ID); err != nil {
+				return err
+			}
+		}
+		if target != "users" {
+			return tx.Create(newRef, data)
+		}
+		if err := tx.Create(newRef, withHistoryVersion(data, nil)); err != nil {
+			return err
+		}
+		actor := actorFromRequest(r, "anonymous")
+		if err := s.recordOutboxTx(tx, "user.created", newRef.ID, actor, data); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, newRef, nil, HistoryEntry{
+			Op:      "create",
+			Data:    data,
+			Actor:   actor,
+			Details: map[string]interface{}{"clonedFrom": sourceID},
+		})
+	})
+	if err == store.ErrEmailTaken {
+		writeError(w, r, http.StatusConflict, "email_taken", "Email already in use; override \"email\" in the request body")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error cloning user")
+		return
+	}
+
+	copied, truncated, err := s.cloneSubcollections(ctx, source.Ref, newRef, dryRun)
+	if err != nil {
+		logCtx(ctx, "⚠️ Clone %s of %s copied only %v of its subcollections: %v", newRef.ID, sourceID, copied, err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error copying subcollections")
+		return
+	}
+	id := newRef.ID
+	if dryRun {
+		id = dryRunID
+	} else if target == "users" {
+		userCap.created()
+		s.enqueueSearchUpsert(newRef.ID, clone)
+	}
+
+	response := CloneResponse{
+		Message:          "User cloned successfully",
+		ID:               id,
+		SourceID:         sourceID,
+		TargetCollection: target,
+		Copied:           copied,
+		Truncated:        truncated,
+	}
+	if target == "users" && !dryRun {
+		response.Links = userLinks(r, id)
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// Copy the subcollections of src under dst through a BulkWriter, up to
+// cloneMaxDocs documents each, leaving out cloneSkippedSubcollections. A
+// dry run only counts what it would copy. Only writes that succeeded are
+// counted; the first failed one is returned with how many failed.
+func (s *Server) cloneSubcollections(ctx context.Context, src, dst *firestore.DocumentRef, dryRun bool) (map[string]int, []string, error) {
+	copied := map[string]int{}
+	truncated := []string{}
+
+	type cloneJob struct {
+		col string
+		job *firestore.BulkWriterJob
+	}
+	var jobs []cloneJob
+	bw := s.client.BulkWriter(ctx)
+	defer bw.End()
+
+	cols := src.Collections(ctx)
+	for {
+		col, err := cols.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return copied, truncated, err
+		}
+		if cloneSkippedSubcollections[col.ID] {
+			continue
+		}
+
+		copied[col.ID] = 0
+		iter := trackIterator("clone", col.Limit(cloneMaxDocs+1).Documents(ctx))
+		n := 0
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return copied, truncated, err
+			}
+			if n == cloneMaxDocs {
+				truncated = append(truncated, col.ID)
+				break
+			}
+			if dryRun {
+				copied[col.ID]++
+			} else {
+				job, err := bw.Create(dst.Collection(col.ID).Doc(doc.Ref.ID), doc.Data())
+				if err != nil {
+					iter.Stop()
+					return copied, truncated, err
+				}
+				jobs = append(jobs, cloneJob{col.ID, job})
+			}
+			n++
+		}
+		iter.Stop()
+	}
+	bw.End()
+
+	var firstErr error
+	failed := 0
+	for _, j := range jobs {
+		if _, err := j.job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		copied[j.col]++
+	}
+	if firstErr != nil {
+		return copied, truncated, fmt.Errorf("%d of %d subcollection documents not copied: %w", failed, len(jobs), firstErr)
+	}
+	return copied, truncated, nil
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestCloneStartsItsOwnHistory(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	src := s.users.Users().Doc(id)
+	if _, err := src.Collection("consents").Doc("marketing").Set(ctx, map[string]interface{}{"accepted": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Collection("notes").Doc("n1").Set(ctx, map[string]interface{}{"text": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := jsonRequest(http.MethodPost, "/users/"+id+":clone", `{"email": "ada+copy@example.com"}`)
+	r.SetPathValue("id", id)
+	body := serveJSON(t, s.cloneUserHandler, r, http.StatusCreated)
+	cloneID, _ := body["id"].(string)
+
+	if got := body["copied"]; !reflect.DeepEqual(got, map[string]interface{}{"notes": float64(1)}) {
+		t.Errorf("copied = %v, want only notes", got)
+	}
+	if ops := historyOps(t, s, ctx, cloneID); !reflect.DeepEqual(ops, []string{"create"}) {
+		t.Errorf("clone history = %v, want [create]", ops)
+	}
+	consents, err := s.users.Users().Doc(cloneID).Collection("consents").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consents) != 0 {
+		t.Errorf("clone has %d consents, want none", len(consents))
+	}
+
+	// The original's history didn't come along, so there is nothing to undo
+	u := jsonRequest(http.MethodPost, "/users/"+cloneID+":undo", "")
+	u.SetPathValue("id", cloneID)
+	serveError(t, s.undoUserHandler, u, http.StatusConflict, "nothing_to_undo")
+}
+
+func TestCloneChecksOverridesLikeAddUser(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	for _, tt := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"name": 5}`, http.StatusBadRequest, "invalid_body"},
+		{`{"attributes": "x"}`, http.StatusBadRequest, "invalid_body"},
+		{`{"plan": "platinum"}`, http.StatusUnprocessableEntity, "invalid_field"},
+		{`{"referralCount": 100}`, http.StatusBadRequest, "unknown_field"},
+	} {
+		r := jsonRequest(http.MethodPost, "/users/"+id+":clone", tt.body)
+		r.SetPathValue("id", id)
+		serveError(t, s.cloneUserHandler, r, tt.status, tt.code)
+	}
+
+	r := jsonRequest(http.MethodPost, "/users/"+id+":clone", `{"email": "ada+pro@example.com", "plan": "PRO"}`)
+	r.SetPathValue("id", id)
+	cloneID, _ := serveJSON(t, s.cloneUserHandler, r, http.StatusCreated)["id"].(string)
+	doc, err := s.users.Users().Doc(cloneID).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := doc.Data()["plan"]; plan != "pro" {
+		t.Errorf("plan = %v, want it normalized to pro", plan)
+	}
+}
+
+func TestCloneLeavesBookkeepingBehind(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if _, err := s.users.Users().Doc(id).Set(ctx, map[string]interface{}{
+		"protected": true, "anonymizedAt": time.Now().UTC(), "referralCount": 3,
+	}, firestore.MergeAll); err != nil {
+		t.Fatal(err)
+	}
+	r := jsonRequest(http.MethodPost, "/users/"+id+":clone", `{"email": "ada+copy@example.com"}`)
+	r.SetPathValue("id", id)
+	cloneID, _ := serveJSON(t, s.cloneUserHandler, r, http.StatusCreated)["id"].(string)
+	doc, err := s.users.Users().Doc(cloneID).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range cloneSkippedFields {
+		if v, ok := doc.Data()[field]; ok && field != historyVersionField {
+			t.Errorf("clone has %s = %v", field, v)
+		}
+	}
+	// The clone starts a history of its own
+	if v := doc.Data()[historyVersionField]; v != int64(1) {
+		t.Errorf("clone has %s = %v, want 1", historyVersionField, v)
+	}
+	if doc.Data()["clonedFrom"] != id {
+		t.Errorf("clonedFrom = %v, want %s", doc.Data()["clonedFrom"], id)
+	}
+}
+
+func TestCloneHasNoConsentState(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	consent := Consent{Key: "marketing", Version: "v2", Accepted: true, Source: "api", Actor: "test", At: time.Now().UTC()}
+	if err := s.recordConsent(ctx, id, consent, false); err != nil {
+		t.Fatal(err)
+	}
+
+	r := jsonRequest(http.MethodPost, "/users/"+id+":clone", `{"email": "ada+copy@example.com"}`)
+	r.SetPathValue("id", id)
+	cloneID, _ := serveJSON(t, s.cloneUserHandler, r, http.StatusCreated)["id"].(string)
+	doc, err := s.users.Users().Doc(cloneID).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := doc.Data()["currentConsents"]; ok {
+		t.Errorf("clone has currentConsents = %v", v)
+	}
+
+	list := httptest.NewRequest(http.MethodGet, "/listUsers?consent=marketing:v2", nil)
+	rec := httptest.NewRecorder()
+	s.listUsersHandler(rec, list)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listUsers = %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), cloneID) || !strings.Contains(rec.Body.String(), id) {
+		t.Errorf("listUsers?consent=marketing:v2 = %s, want %s but not the clone %s", rec.Body, id, cloneID)
+	}
+}
+
+// A subcollection write that fails is reported and not counted as copied
+func TestCloneSubcollectionsCountsOnlySucceededWrites(t *testing.T) {
+	s, ctx := useEmulator(t)
+	src := s.users.Users().Doc(mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"}))
+	dst := s.users.Users().Doc(mustCreateUser(t, s, ctx, model.User{Name: "Grace", Email: "grace@example.com"}))
+	for _, aid := range []string{"home", "work"} {
+		if _, err := src.Collection("addresses").Doc(aid).Set(ctx, map[string]interface{}{"city": "London"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Already there, so creating it again fails
+	if _, err := dst.Collection("addresses").Doc("home").Set(ctx, map[string]interface{}{"city": "Paris"}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, _, err := s.cloneSubcollections(ctx, src, dst, false)
+	if err == nil {
+		t.Error("no error for the failed write")
+	}
+	if copied["addresses"] != 1 {
+		t.Errorf("copied = %v, want 1 address", copied)
+	}
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// Concurrent reads of the same document share one Get. The shared Get
+// runs detached from any one caller, so a caller that gives up doesn't
+// fail the others, and its result or error goes to everyone waiting. It
+// is bounded by FIRESTORE_TIMEOUT instead, so a hung Get can't hold the
+// document's key and fail every later reader. Writes through the store
+// forget the document's in-flight Get, so a read that starts after a
+// write never gets the value from before it.
+var (
+	firestoreTimeout = config.Duration("FIRESTORE_TIMEOUT", 10*time.Second)
+	documentReads    singleflight.Group
+	coalescedReads   = &coalesceCounters{counts: map[string]int64{}}
+	getDocumentRef   = (*firestore.DocumentRef).Get // replaced by tests
+)
+
+// coalesceCounters counts reads answered by another caller's Get, by collection
+type coalesceCounters struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Get ref, sharing the call with any identical Get already in flight
+func getDocument(ctx context.Context, ref *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	ctx, cancel := withBudget(ctx, "read")
+	defer cancel()
+	ch := documentReads.DoChan(ref.Path, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), firestoreTimeout)
+		defer cancel()
+		return getDocumentRef(ref, ctx)
+	})
+	select {
+	case res := <-ch:
+		if res.Shared {
+			coalescedReads.mu.Lock()
+			coalescedReads.counts[ref.Parent.ID]++
+			coalescedReads.mu.Unlock()
+		}
+		doc, _ := res.Val.(*firestore.DocumentSnapshot)
+		return doc, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Make the next getDocument of ref start a fresh Get
+func forgetDocumentRead(ref *firestore.DocumentRef) {
+	documentReads.Forget(ref.Path)
+}
+
+// Prometheus counter of coalesced reads, for metricsHandler
+func writeCoalesceMetrics(w io.Writer) {
+	coalescedReads.mu.Lock()
+	counts := make(map[string]int64, len(coalescedReads.counts))
+	for k, n := range coalescedReads.counts {
+		counts[k] = n
+	}
+	coalescedReads.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	collections := make([]string, 0, len(counts))
+	for k := range counts {
+		collections = append(collections, k)
+	}
+	sort.Strings(collections)
+	fmt.Fprintln(w, "# HELP firestore_reads_coalesced_total Document reads answered by a concurrent identical Get, by collection.")
+	fmt.Fprintln(w, "# TYPE firestore_reads_coalesced_total counter")
+	for _, collection := range collections {
+		fmt.Fprintf(w, "firestore_reads_coalesced_total{collection=%s} %d\n", promLabel(collection), counts[collection])
+	}
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// A document reference for the fake Get; nothing reads through it
+func fakeDocumentRef(id string) *firestore.DocumentRef {
+	return &firestore.DocumentRef{
+		Parent: &firestore.CollectionRef{ID: "users"},
+		ID:     id,
+		Path:   "projects/test/databases/(default)/documents/users/" + id,
+	}
+}
+
+// Replace the Firestore Get with get for the duration of a test, counting calls
+func withFakeGet(t *testing.T, get func(ctx context.Context) (*firestore.DocumentSnapshot, error)) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	saved := getDocumentRef
+	getDocumentRef = func(_ *firestore.DocumentRef, ctx context.Context) (*firestore.DocumentSnapshot, error) {
+		calls.Add(1)
+		return get(ctx)
+	}
+	t.Cleanup(func() { getDocumentRef = saved })
+	return &calls
+}
+
+func TestGetDocumentSharesSlowReads(t *testing.T) {
+	release := make(chan struct{})
+	want := &firestore.DocumentSnapshot{}
+	calls := withFakeGet(t, func(ctx context.Context) (*firestore.DocumentSnapshot, error) {
+		select {
+		case <-release:
+			return want, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	ref := fakeDocumentRef("shared")
+
+	const readers = 20
+	var wg, started sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			doc, err := getDocument(context.Background(), ref)
+			if err != nil || doc != want {
+				t.Errorf("getDocument = %v, %v, want the shared snapshot", doc, err)
+			}
+		}()
+	}
+	started.Wait()
+	// A reader that gives up doesn't fail the others
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := getDocument(ctx, ref); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("impatient reader: err = %v, want its own deadline", err)
+	}
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d Gets for %d concurrent readers, want 1", n, readers)
+	}
+}
+
+func TestGetDocumentBoundsHungReads(t *testing.T) {
+	saved := firestoreTimeout
+	firestoreTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { firestoreTimeout = saved })
+	var hang atomic.Bool
+	hang.Store(true)
+	want := &firestore.DocumentSnapshot{}
+	calls := withFakeGet(t, func(ctx context.Context) (*firestore.DocumentSnapshot, error) {
+		if hang.Load() {
+			<-ctx.Done() // a Get that never answers on its own
+			return nil, ctx.Err()
+		}
+		return want, nil
+	})
+	ref := fakeDocumentRef("hung")
+
+	start := time.Now()
+	if _, err := getDocument(context.Background(), ref); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("hung Get: err = %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("hung Get took %s, want about FIRESTORE_TIMEOUT", d)
+	}
+
+	// The key is free again: the next reader starts its own Get
+	hang.Store(false)
+	if doc, err := getDocument(context.Background(), ref); err != nil || doc != want {
+		t.Errorf("read after the hung one = %v, %v, want the snapshot", doc, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d Gets, want 2", n)
+	}
+}
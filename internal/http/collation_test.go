@@ -0,0 +1,63 @@
+package http
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Names sort by their keys in the locale's order, not byte-wise
+func TestNameSortKeyOrder(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string // in the locale's order
+	}{
+		// German sorts umlauts with their base letter
+		{"de", []string{"Adam", "Ärla", "Ola", "Ölke", "Zoe"}},
+		// Swedish puts å, ä and ö after z, in that order
+		{"sv", []string{"Adam", "Ola", "Zoe", "Åsa", "Ärla", "Östen"}},
+		// Turkish has dotless ı before i, and I is its capital
+		{"tr", []string{"hasan", "ılık", "Irmak", "ilk", "İpek", "jale"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			saved := nameCollator
+			nameCollator = newNameCollator(tt.locale)
+			t.Cleanup(func() { nameCollator = saved })
+
+			names := append([]string(nil), tt.want...)
+			sort.Strings(names) // byte-wise, as Firestore orders name
+			if reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("%q is already in byte order, so the case proves nothing", tt.want)
+			}
+			sort.SliceStable(names, func(i, j int) bool {
+				return bytes.Compare(nameSortKey(names[i]), nameSortKey(names[j])) < 0
+			})
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("sorted by key = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameSortKeyOff(t *testing.T) {
+	saved := nameCollator
+	t.Cleanup(func() { nameCollator = saved })
+
+	nameCollator = nil
+	if key := nameSortKey("Ärla"); key != nil {
+		t.Errorf("collation off: key = %x, want nil", key)
+	}
+	if got := nameSortField(); got != "name" {
+		t.Errorf("collation off: sort field = %q, want name", got)
+	}
+
+	nameCollator = newNameCollator("de")
+	if key := nameSortKey("  "); key != nil {
+		t.Errorf("blank name: key = %x, want nil", key)
+	}
+	if !bytes.Equal(nameSortKey(" Ärla "), nameSortKey("Ärla")) {
+		t.Error("surrounding spaces changed the key")
+	}
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// READ_CACHE_MAX_AGE is the max-age sent on unauthenticated reads;
+// authenticated responses are always no-store
+var readCacheMaxAge = config.Duration("READ_CACHE_MAX_AGE", 0)
+
+// Strong ETag for a single document, derived from its UpdateTime
+func documentETag(doc *firestore.DocumentSnapshot) string {
+	return `"` + strconv.FormatInt(doc.UpdateTime.UnixNano(), 36) + `"`
+}
+
+// Writes honor If-Match: a write to a document whose ETag isn't listed
+// fails with 412 instead of overwriting a change the client hasn't seen.
+// Transactional writes compare the ETag with what the transaction read.
+// The transaction then fails if the document changes before commit.
+// Plain writes send the ETag to Firestore as a LastUpdateTime
+// precondition. Without If-Match, deletes still carry an Exists
+// precondition, so a missing document is a NotFound from the delete
+// itself rather than from a separate Get.
+var errPreconditionFailed = errors.New("precondition failed")
+
+type ifMatchKey struct{}
+
+// ctx carrying r's If-Match for checkIfMatch
+func withIfMatch(ctx context.Context, r *http.Request) context.Context {
+	if h := strings.TrimSpace(r.Header.Get("If-Match")); h != "" {
+		ctx = context.WithValue(ctx, ifMatchKey{}, h)
+	}
+	return ctx
+}
+
+// ctx requiring the document a transaction reads to still be at etag
+func withETag(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// errPreconditionFailed unless doc matches the If-Match carried by ctx
+func checkIfMatch(ctx context.Context, doc *firestore.DocumentSnapshot) error {
+	h, _ := ctx.Value(ifMatchKey{}).(string)
+	if h == "" || h == "*" {
+		return nil
+	}
+	etag := documentETag(doc)
+	for _, candidate := range strings.Split(h, ",") {
+		if strings.TrimSpace(candidate) == etag { // strong comparison: weak tags never match
+			return nil
+		}
+	}
+	return errPreconditionFailed
+}
+
+// Precondition for a plain write of an existing document from r's
+// If-Match: the document's update time for a single ETag, otherwise
+// that it exists. Fails with errPreconditionFailed for an If-Match that
+// can't name one of our documents.
+func writePrecondition(r *http.Request) (firestore.Precondition, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return firestore.Exists, nil
+	}
+	t, ok := etagTime(h)
+	if !ok {
+		return nil, errPreconditionFailed
+	}
+	return firestore.LastUpdateTime(t), nil
+}
+
+// The UpdateTime a documentETag was made from
+func etagTime(etag string) (time.Time, bool) {
+	opaque, prefixed := strings.CutPrefix(etag, `"`)
+	opaque, suffixed := strings.CutSuffix(opaque, `"`)
+	if !prefixed || !suffixed || opaque == "" {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(opaque, 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusPreconditionFailed, "precondition_failed",
+		"The resource changed since the ETag in If-Match was issued; fetch it again")
+}
+
+// Weak ETag for a page of documents: a hash of their IDs and UpdateTimes
+func pageETag(docs []*firestore.DocumentSnapshot) string {
+	h := sha256.New()
+	for _, doc := range docs {
+		fmt.Fprintf(h, "%s@%d\n", doc.Ref.ID, doc.UpdateTime.UnixNano())
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// Whether the request carries credentials, so its response must not be cached
+func authenticatedRequest(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != "" || r.Header.Get("X-User-ID") != ""
+}
+
+// Set validators and Cache-Control for a read, then answer 304 (with no
+// body) when the client's copy is current. Returns true if it did.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	h := w.Header()
+	if authenticatedRequest(r) {
+		h.Set("Cache-Control", "no-store")
+	} else {
+		h.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(readCacheMaxAge.Seconds())))
+	}
+	h.Add("Vary", "Accept")
+	h.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil ||
+		lastModified.IsZero() || lastModified.Truncate(time.Second).After(ims) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Weak comparison of an If-None-Match list against etag
+func etagMatches(header, etag string) bool {
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+)
+
+// Run writes concurrently from a common start, returning their errors in order
+func raceWrites(writes ...func() error) []error {
+	errs := make([]error, len(writes))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, write := range writes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = write()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// An update renaming user id under ctx's If-Match
+func renameUser(s *Server, ctx context.Context, id, name string) func() error {
+	return func() error {
+		return s.updateUser(ctx, id, model.User{Name: name, Email: "ada@example.com"}, "test", false)
+	}
+}
+
+// The ETag of a user as a client read it
+func userETag(t *testing.T, s *Server, ctx context.Context, id string) string {
+	t.Helper()
+	doc, err := s.users.Users().Doc(id).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return documentETag(doc)
+}
+
+func TestIfMatchRacingUpdates(t *testing.T) {
+	s, ctx := useEmulator(t)
+	for round := 0; round < 5; round++ {
+		id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+		tagged := withETag(ctx, userETag(t, s, ctx, id))
+		errs := raceWrites(renameUser(s, tagged, id, "First"), renameUser(s, tagged, id, "Second"))
+		won, lost := 0, 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				won++
+			case errors.Is(err, errPreconditionFailed):
+				lost++
+			default:
+				t.Fatalf("round %d: unexpected error %v", round, err)
+			}
+		}
+		if won != 1 || lost != 1 {
+			t.Fatalf("round %d: %d writers won and %d got 412, want one each (%v)", round, won, lost, errs)
+		}
+
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		winner := "First"
+		if errs[0] != nil {
+			winner = "Second"
+		}
+		if name := userFromDoc(doc).Name; name != winner {
+			t.Errorf("round %d: name = %q, want the winner's %q", round, name, winner)
+		}
+		s.users.Users().Doc(id).Delete(ctx)
+		s.users.EmailIndex("ada@example.com").Delete(ctx)
+	}
+}
+
+func TestIfMatchRacingUpdateAndDelete(t *testing.T) {
+	s, ctx := useEmulator(t)
+	for round := 0; round < 5; round++ {
+		id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+		tagged := withETag(ctx, userETag(t, s, ctx, id))
+		errs := raceWrites(
+			renameUser(s, tagged, id, "Renamed"),
+			func() error { return s.deleteUser(tagged, id, "test", false) },
+		)
+		update, del := errs[0], errs[1]
+		_, getErr := s.users.Users().Doc(id).Get(ctx)
+		switch {
+		case update == nil && errors.Is(del, errPreconditionFailed):
+			if getErr != nil {
+				t.Errorf("round %d: update won but the user is gone: %v", round, getErr)
+			}
+		case del == nil && (errors.Is(update, errPreconditionFailed) || errors.Is(update, store.ErrUserNotFound)):
+			if getErr == nil {
+				t.Errorf("round %d: delete won but the user is still there", round)
+			}
+		default:
+			t.Fatalf("round %d: update %v, delete %v; want exactly one to succeed", round, update, del)
+		}
+		s.users.Users().Doc(id).Delete(ctx)
+		s.users.EmailIndex("ada@example.com").Delete(ctx)
+	}
+}
@@ -0,0 +1,18 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Collections the API may read or write besides the default users collection
+// (ALLOWED_COLLECTIONS, comma separated)
+func collectionAllowed(name string) bool {
+	for _, c := range strings.Split(config.String("ALLOWED_COLLECTIONS", "users"), ",") {
+		if strings.TrimSpace(c) == name {
+			return true
+		}
+	}
+	return false
+}
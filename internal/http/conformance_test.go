@@ -0,0 +1,203 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	userclient "github.com/Altair-05/GoFirestoreApp/client"
+	"github.com/Altair-05/GoFirestoreApp/store/memstore"
+	"google.golang.org/api/iterator"
+)
+
+// Serve the app's routes against the emulator, behind wrap when not nil,
+// and return the server's URL, skipping the test when
+// FIRESTORE_EMULATOR_HOST is unset
+func emulatorServer(t *testing.T, wrap func(http.Handler) http.Handler) (context.Context, string) {
+	t.Helper()
+	s, ctx := useEmulator(t)
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	h := s.newHandler(mux)
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return ctx, srv.URL
+}
+
+// A client for the app's routes served against the emulator
+func emulatorUsers(t *testing.T) (context.Context, *userclient.Client) {
+	t.Helper()
+	ctx, url := emulatorServer(t, nil)
+	c, err := userclient.New(url)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	return ctx, c
+}
+
+// An API error as "status code", any other error as its text, so the
+// two stores' results compare as strings
+func outcome(v string, err error) string {
+	var apiErr *userclient.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("%d %s", apiErr.StatusCode, apiErr.Code)
+	case err != nil:
+		return err.Error()
+	}
+	return v
+}
+
+func mustCreate(t *testing.T, ctx context.Context, users userclient.Users, user api.User) string {
+	t.Helper()
+	id, err := users.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatalf("CreateUser(%+v): %v", user, err)
+	}
+	return id
+}
+
+// The names it lists, in order
+func listNames(it *userclient.UserIterator) (string, error) {
+	var names []string
+	for {
+		u, err := it.Next()
+		if err == iterator.Done {
+			return strings.Join(names, ","), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		names = append(names, u.Name)
+	}
+}
+
+// The memory store keeps the server's rules: every case runs against
+// memstore, and against the server on the emulator when there is one,
+// and both must give the same result
+func TestStoreConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, users userclient.Users) (string, error)
+		want string
+	}{
+		{
+			name: "create then get",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				id := mustCreate(t, ctx, users, api.User{Name: "Ada", Email: "ada@example.com"})
+				u, err := users.GetUser(ctx, id)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%s %s %s %t", u.Name, u.Email, u.Plan, u.ID == id), nil
+			},
+			want: "Ada ada@example.com free true",
+		},
+		{
+			name: "email taken once normalized",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				mustCreate(t, ctx, users, api.User{Name: "Ada", Email: "ada@example.com"})
+				_, err := users.CreateUser(ctx, api.User{Name: "Imposter", Email: " ADA@example.com "})
+				return "created", err
+			},
+			want: "409 " + api.CodeEmailTaken,
+		},
+		{
+			name: "unknown plan",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				_, err := users.CreateUser(ctx, api.User{Name: "Ada", Plan: "platinum"})
+				return "created", err
+			},
+			want: "422 " + api.CodeInvalidField,
+		},
+		{
+			name: "get missing",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				_, err := users.GetUser(ctx, "nobody")
+				return "found", err
+			},
+			want: "404 " + api.CodeUserNotFound,
+		},
+		{
+			name: "update releases the old email",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				id := mustCreate(t, ctx, users, api.User{Name: "Ada", Email: "ada@example.com"})
+				if err := users.UpdateUser(ctx, id, api.User{Name: "Ada", Email: "lovelace@example.com"}); err != nil {
+					return "", err
+				}
+				_, err := users.CreateUser(ctx, api.User{Name: "Another Ada", Email: "ada@example.com"})
+				return "reused", err
+			},
+			want: "reused",
+		},
+		{
+			name: "update can't change plan",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				id := mustCreate(t, ctx, users, api.User{Name: "Ada"})
+				return "updated", users.UpdateUser(ctx, id, api.User{Name: "Ada", Plan: api.PlanPro})
+			},
+			want: "422 " + api.CodeInvalidField,
+		},
+		{
+			name: "delete then get",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				id := mustCreate(t, ctx, users, api.User{Name: "Ada"})
+				if err := users.DeleteUser(ctx, id); err != nil {
+					return "", err
+				}
+				_, err := users.GetUser(ctx, id)
+				return "found", err
+			},
+			want: "404 " + api.CodeUserNotFound,
+		},
+		{
+			name: "delete missing",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				return "deleted", users.DeleteUser(ctx, "nobody")
+			},
+			want: "404 " + api.CodeUserNotFound,
+		},
+		{
+			name: "list by name across pages",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				for _, name := range []string{"Carol", "Alice", "Eve", "Bob", "Dave"} {
+					mustCreate(t, ctx, users, api.User{Name: name})
+				}
+				return listNames(users.ListUsers(ctx, &userclient.ListUsersOptions{PageSize: 2, OrderBy: "name"}))
+			},
+			want: "Alice,Bob,Carol,Dave,Eve",
+		},
+		{
+			name: "range needs createdAt order",
+			run: func(t *testing.T, ctx context.Context, users userclient.Users) (string, error) {
+				mustCreate(t, ctx, users, api.User{Name: "Ada"})
+				return listNames(users.ListUsers(ctx, &userclient.ListUsersOptions{OrderBy: "id", CreatedAfter: time.Now().Add(-time.Hour)}))
+			},
+			want: "400 " + api.CodeInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("memstore", func(t *testing.T) {
+				if got := outcome(tt.run(t, context.Background(), memstore.New())); got != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+			t.Run("firestore", func(t *testing.T) {
+				ctx, users := emulatorUsers(t)
+				if got := outcome(tt.run(t, ctx, users)); got != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+		})
+	}
+}
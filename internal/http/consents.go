@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Consent is one acceptance or withdrawal in users/{id}/consents. Records
+// are only ever appended, so a withdrawal never erases the acceptance it
+// follows. The latest record per key is mirrored into the user's
+// currentConsents map for /listUsers?consent= queries.
+type Consent struct {
+	Key      string    `json:"key" firestore:"key"`
+	Version  string    `json:"version" firestore:"version"`
+	Accepted bool      `json:"accepted" firestore:"accepted"`
+	Source   string    `json:"source" firestore:"source"`
+	Actor    string    `json:"actor,omitempty" firestore:"actor,omitempty"`
+	At       time.Time `json:"at" firestore:"at"`
+}
+
+// Where a consent was collected
+var consentSources = map[string]bool{"api": true, "import": true, "admin": true}
+
+// Consent keys become field paths in currentConsents
+var consentKeyPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+func (s *Server) consentsCollection(userID string) *firestore.CollectionRef {
+	return s.users.Users().Doc(userID).Collection("consents")
+}
+
+// Check a consent, returning the offending field and why
+func (c Consent) validate() (field, msg string, ok bool) {
+	if !consentKeyPattern.MatchString(c.Key) {
+		return "key", "must be a letter followed by letters, digits, _ or -", false
+	}
+	if c.Version == "" || len(c.Version) > 64 {
+		return "version", "must be 1 to 64 characters", false
+	}
+	if !consentSources[c.Source] {
+		return "source", "must be api, import or admin", false
+	}
+	return "", "", true
+}
+
+// Append a consent record and update currentConsents in one transaction
+func (s *Server) recordConsent(ctx context.Context, userID string, c Consent, dryRun bool) error {
+	ref := s.users.Users().Doc(userID)
+	return s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(s.consentsCollection(userID).NewDoc(), c); err != nil {
+			return err
+		}
+		err = tx.Update(ref, withUpdatedAt([]firestore.Update{{
+			FieldPath: firestore.FieldPath{"currentConsents", c.Key},
+			Value: map[string]interface{}{
+				"version":  c.Version,
+				"accepted": c.Accepted,
+				"source":   c.Source,
+				"at":       c.At,
+			},
+		}}))
+		if err != nil {
+			return err
+		}
+		details := map[string]interface{}{"key": c.Key, "version": c.Version, "accepted": c.Accepted, "source": c.Source}
+		return s.recordAuditTx(tx, newAuditEntry("user.consent", userID, c.Actor, details))
+	})
+}
+
+// Record an acceptance or withdrawal (POST /users/{id}/consents)
+// {"key": "marketing", "version": "v2", "accepted": true, "source": "api"}
+func (s *Server) postConsentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	var req struct {
+		Key      string `json:"key"`
+		Version  string `json:"version"`
+		Accepted *bool  `json:"accepted"`
+		Source   string `json:"source"`
+	}
+	err := decodeJSON(r, &req)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if req.Accepted == nil {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "accepted required")
+		return
+	}
+	if req.Source == "" {
+		req.Source = "api"
+	}
+	c := Consent{
+		Key:      req.Key,
+		Version:  req.Version,
+		Accepted: *req.Accepted,
+		Source:   req.Source,
+		Actor:    actorFromRequest(r, "anonymous"),
+		At:       time.Now().UTC(),
+	}
+	if field, msg, ok := c.validate(); !ok {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", field+" "+msg, FieldError{Field: field, Message: msg})
+		return
+	}
+
+	err = s.recordConsent(requestContext(r), userID, c, dryRunRequested(r))
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error recording consent")
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{"id": userID, "consent": c})
+}
+
+// Current consent state plus the full history, newest first
+// (GET /users/{id}/consents)
+func (s *Server) getConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	ctx := requestContext(r)
+	doc, err := s.users.Users().Doc(userID).Get(ctx)
+	if err != nil || isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	current, _ := doc.Data()["currentConsents"].(map[string]interface{})
+	if current == nil {
+		current = map[string]interface{}{}
+	}
+
+	docs, err := s.consentsCollection(userID).OrderBy("at", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading consents")
+		return
+	}
+	history := []Consent{}
+	for _, doc := range docs {
+		var c Consent
+		doc.DataTo(&c)
+		history = append(history, c)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"id":      userID,
+		"current": current,
+		"history": history,
+	})
+}
+
+var errInvalidConsentFilter = errors.New("consent must be key:version, e.g. marketing:v2")
+
+// consentFilter matches users whose current consent for key is an
+// acceptance of version, parsed from ?consent=key:version
+type consentFilter struct {
+	key, version string
+}
+
+func parseConsentFilter(r *http.Request) (*consentFilter, error) {
+	raw := r.URL.Query().Get("consent")
+	if raw == "" {
+		return nil, nil
+	}
+	key, version, ok := strings.Cut(raw, ":")
+	if !ok || !consentKeyPattern.MatchString(key) || version == "" {
+		return nil, errInvalidConsentFilter
+	}
+	return &consentFilter{key: key, version: version}, nil
+}
+
+func (f *consentFilter) apply(q firestore.Query) firestore.Query {
+	return q.WherePath(firestore.FieldPath{"currentConsents", f.key, "version"}, "==", f.version).
+		WherePath(firestore.FieldPath{"currentConsents", f.key, "accepted"}, "==", true)
+}
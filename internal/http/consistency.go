@@ -0,0 +1,86 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Read-your-writes across replicas. A write invalidates this replica's
+// list cache at once but others only when the invalidation reaches them,
+// so a list read right after a write can come from a cache filled before
+// it. Every successful write answers with X-Sync-Token, a signed token of
+// when its response began (after the write committed); a read sending it
+// back gets no cached list filled before then. Tokens last as long as the
+// oldest entry the cache can serve (LIST_CACHE_TTL + LIST_CACHE_STALE),
+// so an expired one is already satisfied. SYNC_TOKEN_SKEW allows for
+// replicas' clocks disagreeing.
+var (
+	syncTokenSkew      = config.Duration("SYNC_TOKEN_SKEW", time.Second)
+	consistencyTokens  = cursor.New(cursorKey, listCacheTTL+listCacheStale+syncTokenSkew)
+	consistencyPurpose = cursor.FilterHash("X-Sync-Token", nil)
+)
+
+// syncTokenWriter stamps X-Sync-Token on a write's successful response
+type syncTokenWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *syncTokenWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code < 400 {
+			now := strconv.FormatInt(time.Now().UnixNano(), 10)
+			w.Header().Set("X-Sync-Token", consistencyTokens.Encode(cursor.Cursor{Values: []string{now}, FilterHash: consistencyPurpose}))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *syncTokenWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *syncTokenWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Issue X-Sync-Token on the responses of writes that aren't dry runs
+func syncTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyRequest(r) || dryRunRequested(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&syncTokenWriter{ResponseWriter: w}, r)
+	})
+}
+
+// The write time r's X-Sync-Token asks cached reads to be newer than,
+// zero without one (or with an expired one, which every cached entry
+// already satisfies). ok is false for a token this server didn't sign.
+func requestSyncToken(r *http.Request) (writtenAt time.Time, ok bool) {
+	token := r.Header.Get("X-Sync-Token")
+	if token == "" {
+		return time.Time{}, true
+	}
+	cur, err := consistencyTokens.Decode(token, consistencyPurpose)
+	if err == cursor.ErrExpired {
+		return time.Time{}, true
+	}
+	if err != nil || len(cur.Values) != 1 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(cur.Values[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos).Add(syncTokenSkew), true
+}
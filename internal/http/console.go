@@ -0,0 +1,29 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// The API console (GET /console) is for support: a form for each entry in
+// apiEndpoints that calls the API from the browser and shows the response
+// pretty-printed, with its status, X-Request-ID and how long it took. The
+// page itself holds no data; the calls carry the admin token or API key
+// entered on it, which is kept in sessionStorage for the tab's session and
+// never sent anywhere else. It needs ADMIN_TOKEN set, and CONSOLE=false
+// takes it off the server entirely.
+var consoleEnabled = config.Bool("CONSOLE", true)
+
+type consoleData struct {
+	Endpoints []apiEndpoint
+}
+
+func consoleHandler(w http.ResponseWriter, r *http.Request) {
+	if config.String("ADMIN_TOKEN", "") == "" {
+		writeError(w, r, http.StatusForbidden, "admin_disabled", "Admin endpoints are disabled")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, r, "console.html", consoleData{Endpoints: apiEndpoints})
+}
@@ -0,0 +1,211 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+)
+
+// Contract tests: each scenario's full response (status, the headers
+// below and the body) is compared with testdata/contract/<name>.golden.
+// The scenarios go through the server's own routes and middleware to the
+// real handlers on the emulator, so a change to a response shape shows up
+// as a golden file diff. -update rewrites the golden files from this run.
+var updateGolden = flag.Bool("update", false, "rewrite testdata/contract/*.golden from this run")
+
+// Headers the contract covers; the rest (Date, Vary, ...) may change freely
+var contractHeaders = []string{"Content-Type", "Content-Language", "X-Content-Type-Options", "X-Error-Code"}
+
+// Values that differ from run to run, which contractResponse replaces
+var (
+	contractTimestamp = regexp.MustCompile(`"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?(Z|[+-]\d\d:\d\d)"`)
+	contractRequestID = regexp.MustCompile(`"requestId":"[^"]*"`)
+)
+
+// The server's routes on the emulator, holding the fixture users, named
+// by their keys in ids. Users get IDs from a sequence rather than random
+// ones, so listings come back in the same order every run.
+func newContractServer(t *testing.T) (http.Handler, map[string]string) {
+	t.Helper()
+	s, ctx := useEmulator(t)
+	s.users = &sequentialUsers{UserStore: s.users}
+
+	ids := map[string]string{}
+	for _, fixture := range []struct {
+		key  string
+		user model.User
+	}{
+		{"alice", model.User{Name: "Alice Liddell", Email: "alice@example.com", Plan: model.PlanPro, Attributes: map[string]interface{}{"team": "wonderland", "tags": []interface{}{"a", "b"}}}},
+		{"bob", model.User{Name: "Bob Marley", Email: "bob@example.com"}},
+		{"carol", model.User{Name: "Carol Danvers", Email: "carol@example.com", Plan: model.PlanEnterprise}},
+	} {
+		ids[fixture.key] = mustCreateUser(t, s, ctx, fixture.user)
+	}
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	return s.newHandler(mux), ids
+}
+
+// A UserStore creating users at user0000000000000001, user0000000000000002, ...
+type sequentialUsers struct {
+	store.UserStore
+	n int
+}
+
+func (u *sequentialUsers) NewUser() *firestore.DocumentRef {
+	u.n++
+	return u.Users().Doc(fmt.Sprintf("user%016d", u.n))
+}
+
+// A request, with {alice}-style fixture keys in its target and body
+type contractScenario struct {
+	name   string
+	method string
+	target string
+	body   string
+	accept string
+	// Request the listing's next page, as a client following its token
+	// would, and record that instead
+	nextPage bool
+}
+
+var contractScenarios = []contractScenario{
+	// Legacy routes
+	{name: "legacy_add_user", method: http.MethodPost, target: "/addUser", body: `{"name": "Dan Brown", "email": "dan@example.com", "plan": "PRO"}`},
+	{name: "legacy_add_user_invalid_body", method: http.MethodPost, target: "/addUser", body: `{"name": 5}`},
+	{name: "legacy_add_user_invalid_plan", method: http.MethodPost, target: "/addUser", body: `{"name": "Dan", "plan": "platinum"}`, accept: "application/problem+json"},
+	{name: "legacy_add_user_email_taken", method: http.MethodPost, target: "/addUser", body: `{"name": "Alice", "email": " Alice@Example.com"}`},
+	{name: "legacy_get_user", method: http.MethodGet, target: "/getUser?id={alice}"},
+	{name: "legacy_get_user_by_path", method: http.MethodGet, target: "/users/{bob}"},
+	{name: "legacy_get_user_fields", method: http.MethodGet, target: "/getUser?id={alice}&fields=name,email"},
+	{name: "legacy_get_user_snake_case", method: http.MethodGet, target: "/getUser?id={alice}&case=snake"},
+	{name: "legacy_get_user_not_found", method: http.MethodGet, target: "/getUser?id=missing"},
+	{name: "legacy_get_user_not_found_problem", method: http.MethodGet, target: "/getUser?id=missing", accept: "application/problem+json"},
+	{name: "legacy_get_user_missing_id", method: http.MethodGet, target: "/getUser"},
+	{name: "legacy_get_user_unknown_field", method: http.MethodGet, target: "/getUser?id={alice}&fields=name,shoeSize", accept: "application/problem+json"},
+	{name: "legacy_update_user", method: http.MethodPut, target: "/updateUser?id={bob}", body: `{"name": "Robert Marley", "email": "robert@example.com"}`},
+	{name: "legacy_update_user_not_found", method: http.MethodPut, target: "/updateUser?id=missing", body: `{"name": "Nobody"}`},
+	{name: "legacy_update_user_plan_change", method: http.MethodPut, target: "/updateUser?id={bob}", body: `{"name": "Bob", "plan": "pro"}`, accept: "application/problem+json"},
+	{name: "legacy_delete_user", method: http.MethodDelete, target: "/deleteUser?id={carol}"},
+	{name: "legacy_delete_user_not_found", method: http.MethodDelete, target: "/deleteUser?id=missing"},
+	{name: "legacy_list_users", method: http.MethodGet, target: "/listUsers"},
+	{name: "legacy_list_users_fields", method: http.MethodGet, target: "/listUsers?fields=name"},
+
+	// v1 routes
+	{name: "v1_get_user", method: http.MethodGet, target: "/v1/users/{alice}"},
+	{name: "v1_get_user_not_found", method: http.MethodGet, target: "/v1/users/missing", accept: "application/problem+json"},
+	{name: "v1_list_users", method: http.MethodGet, target: "/v1/users"},
+	{name: "v1_list_users_first_page", method: http.MethodGet, target: "/v1/users?pageSize=2"},
+	{name: "v1_list_users_last_page", method: http.MethodGet, target: "/v1/users?pageSize=2", nextPage: true},
+	{name: "v1_list_users_invalid_page_token", method: http.MethodGet, target: "/v1/users?pageToken=forged", accept: "application/problem+json"},
+	{name: "v1_list_users_invalid_order_by", method: http.MethodGet, target: "/v1/users?orderBy=email", accept: "application/problem+json"},
+}
+
+func TestResponseContracts(t *testing.T) {
+	for _, sc := range contractScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			h, ids := newContractServer(t)
+			expand := func(str string) string {
+				for key, id := range ids {
+					str = strings.ReplaceAll(str, "{"+key+"}", id)
+				}
+				return str
+			}
+			serve := func(target string) *httptest.ResponseRecorder {
+				r := jsonRequest(sc.method, target, expand(sc.body))
+				if sc.accept != "" {
+					r.Header.Set("Accept", sc.accept)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				return rec
+			}
+
+			rec := serve(expand(sc.target))
+			token := ""
+			if sc.nextPage {
+				var page UserListResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || page.NextPageToken == "" {
+					t.Fatalf("no next page in %d %s", rec.Code, rec.Body)
+				}
+				token = page.NextPageToken
+				rec = serve(expand(sc.target) + "&pageToken=" + url.QueryEscape(token))
+			}
+			checkGolden(t, filepath.Join("testdata", "contract", sc.name+".golden"), contractResponse(t, rec, token))
+		})
+	}
+}
+
+// The recorded response as stable text: status line, the contract's
+// headers and the body, indented when it is JSON. Timestamps, request
+// IDs and page tokens, the response's and the request's, which carry
+// their expiry, are replaced by placeholders.
+func contractResponse(t *testing.T, rec *httptest.ResponseRecorder, pageToken string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%d %s\n", rec.Code, http.StatusText(rec.Code))
+	for _, name := range contractHeaders {
+		for _, value := range rec.Header().Values(name) {
+			fmt.Fprintf(&out, "%s: %s\n", name, value)
+		}
+	}
+	out.WriteString("\n")
+
+	body := contractTimestamp.ReplaceAll(rec.Body.Bytes(), []byte(`"<timestamp>"`))
+	body = contractRequestID.ReplaceAll(body, []byte(`"requestId":"<requestId>"`))
+	var page struct {
+		NextPageToken string `json:"nextPageToken"`
+		PrevPageToken string `json:"prevPageToken"`
+	}
+	if json.Unmarshal(body, &page) == nil {
+		tokens := map[string]string{"<nextPageToken>": page.NextPageToken, "<prevPageToken>": page.PrevPageToken, "<pageToken>": pageToken}
+		for placeholder, token := range tokens {
+			if token != "" {
+				body = bytes.ReplaceAll(body, []byte(url.QueryEscape(token)), []byte(placeholder))
+				body = bytes.ReplaceAll(body, []byte(token), []byte(placeholder))
+			}
+		}
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = append(indented.Bytes(), '\n')
+	}
+	out.Write(body)
+	return out.Bytes()
+}
+
+// Compare got with the golden file at path, or with -update write it
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -run TestResponseContracts -update to create it)", err)
+	}
+	// Golden files may be checked out with CRLF line endings
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("response differs from %s (rerun with -update if the change is intended)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
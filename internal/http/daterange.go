@@ -0,0 +1,59 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// createdRange is the ?createdAfter=&createdBefore= filter on user
+// createdAt (both RFC 3339, both exclusive). Users written before
+// createdAt was stamped have no such field and never match a range.
+type createdRange struct {
+	after, before time.Time
+}
+
+// Parse the range parameters; on error, the parameter at fault
+func parseCreatedRange(r *http.Request) (createdRange, string, error) {
+	var rng createdRange
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"createdAfter", &rng.after}, {"createdBefore", &rng.before}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			t, err := parseTimestamp(v)
+			if err != nil {
+				return rng, p.name, err
+			}
+			*p.dst = t
+		}
+	}
+	return rng, "", nil
+}
+
+func (rng createdRange) active() bool {
+	return !rng.after.IsZero() || !rng.before.IsZero()
+}
+
+func (rng createdRange) apply(q firestore.Query) firestore.Query {
+	if !rng.after.IsZero() {
+		q = q.Where("createdAt", ">", rng.after)
+	}
+	if !rng.before.IsZero() {
+		q = q.Where("createdAt", "<", rng.before)
+	}
+	return q
+}
+
+// The range as page-token filters, so a token only resumes the same range
+func (rng createdRange) filters() map[string]string {
+	f := map[string]string{}
+	if !rng.after.IsZero() {
+		f["createdAfter"] = rng.after.UTC().Format(time.RFC3339Nano)
+	}
+	if !rng.before.IsZero() {
+		f["createdBefore"] = rng.before.UTC().Format(time.RFC3339Nano)
+	}
+	return f
+}
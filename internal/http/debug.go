@@ -0,0 +1,194 @@
+package http
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc"
+)
+
+// The debug surface (pprof and /debug/vars) is served only on the admin
+// listener at ADMIN_ADDR, behind the admin token. DEBUG_ENDPOINTS=false
+// turns it off entirely for hardened deployments.
+var (
+	debugEndpoints = config.Bool("DEBUG_ENDPOINTS", true)
+	adminAddr      = config.String("ADMIN_ADDR", "localhost:9090")
+)
+
+// Open Firestore Listen streams (snapshot listeners)
+var openWatchers atomic.Int64
+
+// Open document iterators by the code that opened them; one that never
+// goes back to zero is a missing Stop
+var openIterators = &iteratorRegistry{open: map[string]int64{}, opened: map[string]int64{}}
+
+type iteratorRegistry struct {
+	mu     sync.Mutex
+	open   map[string]int64
+	opened map[string]int64
+}
+
+// Per-site counts, copied
+func (reg *iteratorRegistry) snapshot() (open, opened map[string]int64) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	open, opened = make(map[string]int64, len(reg.open)), make(map[string]int64, len(reg.opened))
+	for site, n := range reg.open {
+		open[site] = n
+	}
+	for site, n := range reg.opened {
+		opened[site] = n
+	}
+	return open, opened
+}
+
+// trackedIterator counts a document iterator as open until Stop. Use it
+// with Next loops only: GetAll stops the inner iterator, not this one.
+type trackedIterator struct {
+	*firestore.DocumentIterator
+	site string
+	once sync.Once
+}
+
+func trackIterator(site string, iter *firestore.DocumentIterator) *trackedIterator {
+	openIterators.mu.Lock()
+	openIterators.open[site]++
+	openIterators.opened[site]++
+	openIterators.mu.Unlock()
+	return &trackedIterator{DocumentIterator: iter, site: site}
+}
+
+func (it *trackedIterator) Stop() {
+	it.once.Do(func() {
+		openIterators.mu.Lock()
+		openIterators.open[it.site]--
+		openIterators.mu.Unlock()
+	})
+	it.DocumentIterator.Stop()
+}
+
+// Prometheus gauges of open watchers and iterators, for metricsHandler
+func writeIteratorMetrics(w io.Writer) {
+	open, opened := openIterators.snapshot()
+	sites := make([]string, 0, len(opened))
+	for site := range opened {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+	fmt.Fprintln(w, "# HELP firestore_watchers_open Open Firestore Listen streams.")
+	fmt.Fprintln(w, "# TYPE firestore_watchers_open gauge")
+	fmt.Fprintf(w, "firestore_watchers_open %d\n", openWatchers.Load())
+	if len(sites) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP firestore_iterators_open Open document iterators, by the code that opened them.")
+	fmt.Fprintln(w, "# TYPE firestore_iterators_open gauge")
+	for _, site := range sites {
+		fmt.Fprintf(w, "firestore_iterators_open{site=%s} %d\n", promLabel(site), open[site])
+	}
+	fmt.Fprintln(w, "# HELP firestore_iterators_opened_total Document iterators opened, by the code that opened them.")
+	fmt.Fprintln(w, "# TYPE firestore_iterators_opened_total counter")
+	for _, site := range sites {
+		fmt.Fprintf(w, "firestore_iterators_opened_total{site=%s} %d\n", promLabel(site), opened[site])
+	}
+}
+
+// expvar.Publish panics on a second call, so an App started again reuses it
+var publishRuntimeStats sync.Once
+
+// Start the admin listener with the pprof and runtime handlers
+func startDebugListener(bg *background) {
+	if !debugEndpoints {
+		return
+	}
+	publishRuntimeStats.Do(func() { expvar.Publish("runtime", expvar.Func(runtimeStats)) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", requireAdmin(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", requireAdmin(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", requireAdmin(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", requireAdmin(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", requireAdmin(pprof.Trace))
+	mux.HandleFunc("/debug/vars", requireAdmin(expvar.Handler().ServeHTTP))
+	server := &http.Server{Addr: adminAddr, Handler: requestIDMiddleware(mux)}
+	bg.Go(func(ctx context.Context) {
+		stop := context.AfterFunc(ctx, func() { server.Close() })
+		defer stop()
+		fmt.Println("🩺 Debug endpoints on http://" + adminAddr + "/debug/")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("⚠️ Debug listener stopped: %v", err)
+		}
+	})
+}
+
+// Keep the /debug/ handlers that net/http/pprof and expvar register on
+// http.DefaultServeMux off the public listener
+func hideDebugPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug" || strings.HasPrefix(r.URL.Path, "/debug/") {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func runtimeStats() interface{} {
+	iterators, _ := openIterators.snapshot()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	// PauseNs is a ring buffer; report the most recent pauses, newest first
+	var pauses []string
+	for i := 0; i < 10 && i < int(m.NumGC); i++ {
+		pauses = append(pauses, time.Duration(m.PauseNs[(int(m.NumGC)-1-i)%256]).String())
+	}
+	return map[string]interface{}{
+		"goroutines":         runtime.NumGoroutine(),
+		"heapAllocBytes":     m.HeapAlloc,
+		"heapInuseBytes":     m.HeapInuse,
+		"heapObjects":        m.HeapObjects,
+		"numGC":              m.NumGC,
+		"gcPauseTotal":       time.Duration(m.PauseTotalNs).String(),
+		"recentGCPauses":     pauses,
+		"firestoreWatchers":  openWatchers.Load(),
+		"firestoreIterators": iterators,
+		"writeThrottle":      writeThrottle.state(),
+	}
+}
+
+// watchedStream counts a Listen stream as open until it ends, and each
+// document change it delivers as a read
+type watchedStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	once sync.Once
+}
+
+func newWatchedStream(ctx context.Context, stream grpc.ClientStream) grpc.ClientStream {
+	openWatchers.Add(1)
+	return &watchedStream{ClientStream: stream, ctx: ctx}
+}
+
+func (s *watchedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() { openWatchers.Add(-1) })
+	} else if resp, ok := m.(*firestorepb.ListenResponse); ok && resp.GetDocumentChange() != nil {
+		usage.add(s.ctx, "read", 1)
+	}
+	return err
+}
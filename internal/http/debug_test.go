@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// pprof and expvar register on http.DefaultServeMux; neither they nor the
+// public mux's catch-all home page may answer /debug/ on the public listener,
+// even for an admin
+func TestPublicListenerHidesDebugPaths(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	public := NewApp(config.App{}, s).server.Handler
+	for _, path := range []string{
+		"/debug",
+		"/debug/",
+		"/debug/pprof/",
+		"/debug/pprof/goroutine?debug=2",
+		"/debug/pprof/cmdline",
+		"/debug/pprof/profile?seconds=1",
+		"/debug/pprof/trace?seconds=1",
+		"/debug/vars",
+	} {
+		for _, token := range []string{"", "secret"} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			public.ServeHTTP(rec, r)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("GET %s (token %q) = %d, want 404", path, token, rec.Code)
+			}
+		}
+	}
+}
+
+// Hundreds of list requests and watches leave no goroutine, Listen
+// stream or document iterator behind
+func TestListAndWatchDoNotLeak(t *testing.T) {
+	s, ctx := useEmulator(t, slowOpOptions()...)
+	for i := range 30 {
+		mustCreateUser(t, s, ctx, model.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	requests := []struct {
+		h      http.HandlerFunc
+		target string
+	}{
+		{s.listUsersHandler, "/listUsers"},
+		{s.listUsersHandler, "/listUsers?limit=5"},
+		{s.v1ListUsersHandler, "/v1/users?pageSize=10"},
+		{s.syncUsersHandler, "/users/sync?pageSize=10"},
+	}
+	// Watch until the first snapshot arrives, then stop
+	watch := func() {
+		bus := newInvalidationBus()
+		watchCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			bus.listenOnce(watchCtx, s.client)
+		}()
+		for deadline := time.Now().Add(5 * time.Second); !bus.connected.Load(); time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("watch never connected")
+			}
+		}
+		cancel()
+		<-done
+	}
+	round := func() {
+		for _, req := range requests {
+			rec := httptest.NewRecorder()
+			req.h(rec, httptest.NewRequest(http.MethodGet, req.target, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d %s", req.target, rec.Code, rec.Body)
+			}
+		}
+		watch()
+	}
+
+	round() // connections and pools the client keeps
+	goroutines, watchers := runtime.NumGoroutine(), openWatchers.Load()
+	iterators, _ := openIterators.snapshot()
+	for range 75 {
+		round()
+	}
+
+	// Streams wind down asynchronously after Stop
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines+2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+2 {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines after 300 requests and 75 watches, %d before:\n%s", n, goroutines, buf[:runtime.Stack(buf, true)])
+	}
+	if n := openWatchers.Load(); n != watchers {
+		t.Errorf("%d open watchers, %d before", n, watchers)
+	}
+	open, _ := openIterators.snapshot()
+	for site, n := range open {
+		if n != iterators[site] {
+			t.Errorf("%d iterators open at %s, %d before", n, site, iterators[site])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Derived user fields are stored copies computed from other stored
+// fields, for queries Firestore can't run on the source (case-insensitive
+// matches, word lookups with array-contains). userToData adds them to
+// every encoded user, so createUser, modifyUser and everything built on
+// them (imports, JSON Patch, actions) stay in sync; writes of raw data
+// or field updates (bulk updates, merges, undo, unarchive, zip imports,
+// anonymization) go through deriveUserFields or withDerivedUpdates.
+// Adding a field is one entry below; migration 0003 and `gofirestoreapp
+// derived-fields` bring existing documents up to date.
+type derivedField struct {
+	name    string                                        // stored field
+	sources []string                                      // stored fields it is computed from
+	derive  func(data map[string]interface{}) interface{} // nil to leave the field out
+}
+
+var derivedFields = []derivedField{
+	{name: "emailLower", sources: []string{"email"}, derive: func(data map[string]interface{}) interface{} {
+		return nonEmpty(model.NormalizeEmail(storedString(data, "email")))
+	}},
+	{name: "nameLower", sources: []string{"name"}, derive: func(data map[string]interface{}) interface{} {
+		return nonEmpty(strings.ToLower(strings.TrimSpace(storedString(data, "name"))))
+	}},
+	{name: "nameSortKey", sources: []string{"name"}, derive: func(data map[string]interface{}) interface{} {
+		if key := nameSortKey(storedString(data, "name")); key != nil {
+			return key
+		}
+		return nil
+	}},
+	{name: "searchTokens", sources: []string{"name", "email"}, derive: func(data map[string]interface{}) interface{} {
+		words := append(searchWords(storedString(data, "name")), searchWords(storedString(data, "email"))...)
+		if len(words) == 0 {
+			return nil
+		}
+		slices.Sort(words)
+		return slices.Compact(words)
+	}},
+}
+
+func storedString(data map[string]interface{}, field string) string {
+	s, _ := data[field].(string)
+	return s
+}
+
+func nonEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Set data's derived fields from its sources, in place
+func deriveUserFields(data map[string]interface{}) {
+	for _, f := range derivedFields {
+		if v := f.derive(data); v != nil {
+			data[f.name] = v
+		} else {
+			delete(data, f.name)
+		}
+	}
+}
+
+// updates to a document holding data, plus updates of the derived fields
+// whose sources they touch
+func withDerivedUpdates(data map[string]interface{}, updates []firestore.Update) []firestore.Update {
+	if len(updates) == 0 {
+		return updates
+	}
+	next := maps.Clone(data)
+	touched := map[string]bool{}
+	for _, u := range updates {
+		field, nested := u.Path, false
+		if len(u.FieldPath) > 0 {
+			field, nested = u.FieldPath[0], len(u.FieldPath) > 1
+		} else {
+			field, _, nested = strings.Cut(field, ".")
+		}
+		touched[field] = true
+		switch {
+		case nested:
+		case u.Value == firestore.Delete:
+			delete(next, field)
+		default:
+			next[field] = u.Value
+		}
+	}
+	for _, f := range derivedFields {
+		if !slices.ContainsFunc(f.sources, func(s string) bool { return touched[s] }) {
+			continue
+		}
+		v := f.derive(next)
+		if v == nil {
+			v = firestore.Delete
+		}
+		updates = append(updates, firestore.Update{Path: f.name, Value: v})
+	}
+	return updates
+}
+
+// Updates repairing data's derived fields, none when they all match
+func staleDerivedFields(data map[string]interface{}) []firestore.Update {
+	var updates []firestore.Update
+	for _, f := range derivedFields {
+		want, have := f.derive(data), data[f.name]
+		switch {
+		case want == nil && have != nil:
+			updates = append(updates, firestore.Update{Path: f.name, Value: firestore.Delete})
+		case want != nil && !sameJSON(want, have):
+			updates = append(updates, firestore.Update{Path: f.name, Value: want})
+		}
+	}
+	return updates
+}
+
+// Recompute derived fields on users stored before they existed, or
+// written by a path that missed one
+func (s *Server) migrateUserDerivedFields(ctx context.Context, dryRun bool) (int, error) {
+	return s.rewriteUsers(ctx, dryRun, staleDerivedFields)
+}
+
+// gofirestoreapp derived-fields [--repair]: count users whose derived
+// fields don't match their sources, and with --repair rewrite them the
+// way migration 0003 does
+func (s *Server) derivedFieldsCommand(args []string) int {
+	fs := flag.NewFlagSet("derived-fields", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "rewrite mismatched derived fields")
+	fs.Parse(args)
+
+	s.ensureFirestore()
+	defer s.client.Close()
+	ctx := withEndpoint(context.Background(), "cli derived-fields")
+	n, err := s.rewriteUsers(ctx, !*repair, staleDerivedFields)
+	out, _ := json.Marshal(map[string]interface{}{"mismatched": n, "repaired": *repair})
+	fmt.Println(string(out))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Derived field check failed:", err)
+		return 1
+	}
+	if n > 0 && !*repair {
+		fmt.Fprintf(os.Stderr, "⚠️ %d users have stale derived fields; rerun with --repair\n", n)
+		return 1
+	}
+	return 0
+}
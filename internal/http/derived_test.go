@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestDeriveUserFields(t *testing.T) {
+	data := map[string]interface{}{"name": "  Ada LOVELACE ", "email": "Ada@Example.com", "nameLower": "stale", "searchTokens": []string{"old"}}
+	deriveUserFields(data)
+	if data["nameLower"] != "ada lovelace" || data["emailLower"] != "ada@example.com" {
+		t.Errorf("nameLower, emailLower = %v, %v", data["nameLower"], data["emailLower"])
+	}
+	if len(staleDerivedFields(data)) != 0 {
+		t.Errorf("stale after deriving: %v", staleDerivedFields(data))
+	}
+
+	data = map[string]interface{}{"nameLower": "stale", "emailLower": "stale"}
+	deriveUserFields(data)
+	if len(data) != 0 {
+		t.Errorf("derived fields of a user without sources = %v, want none", data)
+	}
+}
+
+// Updates gain the derived fields of the sources they touch, and only those
+func TestWithDerivedUpdates(t *testing.T) {
+	data := map[string]interface{}{"name": "Ada", "email": "ada@example.com"}
+	fields := func(updates []firestore.Update) map[string]interface{} {
+		out := map[string]interface{}{}
+		for _, u := range updates {
+			out[u.Path] = u.Value
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		updates []firestore.Update
+		want    []string // derived fields updated
+	}{
+		{"no updates", nil, nil},
+		{"unrelated field", []firestore.Update{{Path: "plan", Value: "pro"}}, nil},
+		{"nested attribute", []firestore.Update{{Path: "attributes.team", Value: "core"}}, nil},
+		{"name", []firestore.Update{{Path: "name", Value: "Grace"}}, []string{"nameLower", "nameSortKey", "searchTokens"}},
+		{"email by field path", []firestore.Update{{FieldPath: []string{"email"}, Value: "g@example.com"}}, []string{"emailLower", "searchTokens"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fields(withDerivedUpdates(data, tt.updates))
+			for _, f := range derivedFields {
+				if _, updated := got[f.name]; updated != slices.Contains(tt.want, f.name) {
+					t.Errorf("%s updated = %v, want %v", f.name, updated, !updated)
+				}
+			}
+		})
+	}
+
+	got := fields(withDerivedUpdates(data, []firestore.Update{{Path: "email", Value: firestore.Delete}}))
+	if got["emailLower"] != firestore.Delete {
+		t.Errorf("deleting email: emailLower = %v, want deleted", got["emailLower"])
+	}
+	if want := []string{"ada"}; !reflect.DeepEqual(got["searchTokens"], want) {
+		t.Errorf("deleting email: searchTokens = %v, want %v", got["searchTokens"], want)
+	}
+	if _, ok := data["emailLower"]; ok || data["email"] != "ada@example.com" {
+		t.Errorf("withDerivedUpdates changed its input: %v", data)
+	}
+}
+
+// Every write handler leaves the stored user's derived fields matching
+// its sources
+func TestWriteHandlersKeepDerivedFields(t *testing.T) {
+	withID := func(r *http.Request, id string) *http.Request {
+		r.SetPathValue("id", id)
+		return r
+	}
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, s *Server, ctx context.Context) string // the user written to, if any
+		serve    func(t *testing.T, s *Server, id string) map[string]interface{}
+		wantName string // nameLower, "" to only check nothing is stale
+	}{
+		{
+			name:  "addUser",
+			setup: func(*testing.T, *Server, context.Context) string { return "" },
+			serve: func(t *testing.T, s *Server, _ string) map[string]interface{} {
+				return serveJSON(t, s.addUserHandler, jsonRequest(http.MethodPost, "/addUser", `{"name":"Ada Lovelace","email":"Ada@Example.com"}`), http.StatusOK)
+			},
+			wantName: "ada lovelace",
+		},
+		{
+			name: "updateUser",
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				return serveJSON(t, s.updateUserHandler, jsonRequest(http.MethodPut, "/updateUser?id="+id, `{"name":"Grace Hopper","email":"grace@example.com"}`), http.StatusOK)
+			},
+			wantName: "grace hopper",
+		},
+		{
+			name: "updateUser with updateMask",
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				return serveJSON(t, s.updateUserHandler, jsonRequest(http.MethodPut, "/updateUser?id="+id+"&updateMask=name", `{"name":"Grace Hopper"}`), http.StatusOK)
+			},
+			wantName: "grace hopper",
+		},
+		{
+			name: "updateUser with JSON Patch",
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				r := jsonRequest(http.MethodPatch, "/updateUser?id="+id, `[{"op":"replace","path":"/name","value":"Grace Hopper"}]`)
+				r.Header.Set("Content-Type", "application/json-patch+json")
+				return serveJSON(t, s.updateUserHandler, r, http.StatusOK)
+			},
+			wantName: "grace hopper",
+		},
+		{
+			name: "clone with overrides",
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				r := jsonRequest(http.MethodPost, "/users/"+id+":clone", `{"name":"Ada Copy","email":"ada.copy@example.com"}`)
+				return serveJSON(t, s.cloneUserHandler, withID(r, id), http.StatusCreated)
+			},
+			wantName: "ada copy",
+		},
+		{
+			name: "undo",
+			setup: func(t *testing.T, s *Server, ctx context.Context) string {
+				id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+				if _, err := s.modifyUser(ctx, id, "test", false, func(u model.User) (model.User, error) {
+					u.Name = "Grace"
+					return u, nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				return id
+			},
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				return serveJSON(t, s.undoUserHandler, withID(jsonRequest(http.MethodPost, "/users/"+id+":undo", ""), id), http.StatusOK)
+			},
+			wantName: "ada",
+		},
+		{
+			name: "unarchive",
+			setup: func(t *testing.T, s *Server, ctx context.Context) string {
+				id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+				if err := s.archiveUser(ctx, id, "test", false); err != nil {
+					t.Fatal(err)
+				}
+				return id
+			},
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				return serveJSON(t, s.unarchiveUserHandler, withID(jsonRequest(http.MethodPost, "/users/"+id+":unarchive", ""), id), http.StatusOK)
+			},
+			wantName: "ada",
+		},
+		{
+			name: "anonymize",
+			serve: func(t *testing.T, s *Server, id string) map[string]interface{} {
+				return serveJSON(t, s.anonymizeUserHandler, withID(jsonRequest(http.MethodPost, "/users/"+id+":anonymize", ""), id), http.StatusOK)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ctx := useEmulator(t)
+			setup := tt.setup
+			if setup == nil {
+				setup = func(t *testing.T, s *Server, ctx context.Context) string {
+					return mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+				}
+			}
+			id := setup(t, s, ctx)
+			if written, ok := tt.serve(t, s, id)["id"].(string); ok && written != "" {
+				id = written
+			}
+			doc, err := s.users.Users().Doc(id).Get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := doc.Data()
+			if stale := staleDerivedFields(data); len(stale) != 0 {
+				t.Errorf("stale derived fields: %v", stale)
+			}
+			if tt.wantName != "" && data["nameLower"] != tt.wantName {
+				t.Errorf("nameLower = %v, want %q", data["nameLower"], tt.wantName)
+			}
+		})
+	}
+}
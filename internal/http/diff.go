@@ -0,0 +1,110 @@
+package http
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// FieldChange is one difference between two documents.
+// Path uses dots for nested maps and [i] for array elements, e.g. "attributes.tags[2]".
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff groups the differences between two document states
+type Diff struct {
+	Added   []FieldChange `json:"added"`
+	Removed []FieldChange `json:"removed"`
+	Changed []FieldChange `json:"changed"`
+}
+
+// Empty reports whether the two states were identical
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare two documents (as returned by DocumentSnapshot.Data). Output is
+// sorted by path so identical inputs always produce identical diffs.
+func diffDocuments(before, after map[string]interface{}) Diff {
+	d := Diff{Added: []FieldChange{}, Removed: []FieldChange{}, Changed: []FieldChange{}}
+	diffMaps("", before, after, &d)
+	return d
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func diffMaps(prefix string, before, after map[string]interface{}, d *Diff) {
+	keys := make([]string, 0, len(before)+len(after))
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		path := joinPath(prefix, k)
+		switch {
+		case !inBefore:
+			d.Added = append(d.Added, FieldChange{Path: path, After: a})
+		case !inAfter:
+			d.Removed = append(d.Removed, FieldChange{Path: path, Before: b})
+		default:
+			diffValue(path, b, a, d)
+		}
+	}
+}
+
+func diffValue(path string, before, after interface{}, d *Diff) {
+	bm, bIsMap := before.(map[string]interface{})
+	am, aIsMap := after.(map[string]interface{})
+	if bIsMap && aIsMap {
+		diffMaps(path, bm, am, d)
+		return
+	}
+
+	ba, bIsArr := before.([]interface{})
+	aa, aIsArr := after.([]interface{})
+	if bIsArr && aIsArr {
+		for i := 0; i < len(ba) || i < len(aa); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(ba):
+				d.Added = append(d.Added, FieldChange{Path: p, After: aa[i]})
+			case i >= len(aa):
+				d.Removed = append(d.Removed, FieldChange{Path: p, Before: ba[i]})
+			default:
+				diffValue(p, ba[i], aa[i], d)
+			}
+		}
+		return
+	}
+
+	if !valuesEqual(before, after) {
+		d.Changed = append(d.Changed, FieldChange{Path: path, Before: before, After: after})
+	}
+}
+
+// Leaf comparison; timestamps compare by instant rather than location
+func valuesEqual(a, b interface{}) bool {
+	at, aIsTime := a.(time.Time)
+	bt, bIsTime := b.(time.Time)
+	if aIsTime && bIsTime {
+		return at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
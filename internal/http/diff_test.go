@@ -0,0 +1,90 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	before := map[string]interface{}{
+		"name":      "Ada",
+		"email":     "ada@example.com",
+		"plan":      "free",
+		"updatedAt": at,
+		"attributes": map[string]interface{}{
+			"tags":    []interface{}{"a", "b", "c"},
+			"address": map[string]interface{}{"city": "London", "zip": "N1"},
+			"team":    "core",
+		},
+	}
+	after := map[string]interface{}{
+		"name":      "Ada King",
+		"email":     "ada@example.com",
+		"plan":      "free",
+		"updatedAt": at.In(time.FixedZone("CET", 3600)), // the same instant
+		"nickname":  "Countess",
+		"attributes": map[string]interface{}{
+			"tags":    []interface{}{"a", "x"},
+			"address": map[string]interface{}{"city": "London", "country": "UK"},
+			"team":    []interface{}{"core"},
+		},
+	}
+	want := Diff{
+		Added: []FieldChange{
+			{Path: "attributes.address.country", After: "UK"},
+			{Path: "nickname", After: "Countess"},
+		},
+		Removed: []FieldChange{
+			{Path: "attributes.address.zip", Before: "N1"},
+			{Path: "attributes.tags[2]", Before: "c"},
+		},
+		Changed: []FieldChange{
+			{Path: "attributes.tags[1]", Before: "b", After: "x"},
+			{Path: "attributes.team", Before: "core", After: []interface{}{"core"}},
+			{Path: "name", Before: "Ada", After: "Ada King"},
+		},
+	}
+	if got := diffDocuments(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffDocuments =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDiffDocumentsArrays(t *testing.T) {
+	before := map[string]interface{}{"rows": []interface{}{
+		map[string]interface{}{"n": int64(1)},
+		[]interface{}{"x"},
+	}}
+	after := map[string]interface{}{"rows": []interface{}{
+		map[string]interface{}{"n": int64(2)},
+		[]interface{}{"x", "y"},
+		"new",
+	}}
+	want := Diff{
+		Added: []FieldChange{
+			{Path: "rows[1][1]", After: "y"},
+			{Path: "rows[2]", After: "new"},
+		},
+		Removed: []FieldChange{},
+		Changed: []FieldChange{{Path: "rows[0].n", Before: int64(1), After: int64(2)}},
+	}
+	if got := diffDocuments(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffDocuments =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDiffDocumentsEmpty(t *testing.T) {
+	doc := map[string]interface{}{"name": "Ada", "attributes": map[string]interface{}{"tags": []interface{}{"a"}}}
+	d := diffDocuments(doc, doc)
+	if !d.Empty() {
+		t.Errorf("identical documents differ: %+v", d)
+	}
+	// Empty lists rather than nulls in the JSON
+	if d.Added == nil || d.Removed == nil || d.Changed == nil {
+		t.Errorf("nil change lists: %+v", d)
+	}
+	if d := diffDocuments(nil, map[string]interface{}{"name": "Ada"}); len(d.Added) != 1 || d.Empty() {
+		t.Errorf("diff from nothing = %+v, want name added", d)
+	}
+}
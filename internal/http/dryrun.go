@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ID reported for documents a dry run would have created
+const dryRunID = "dry-run"
+
+// errDryRun aborts a transaction once all of its reads and checks have run,
+// so Firestore rolls back the writes it buffered
+var errDryRun = errors.New("dry run")
+
+// Whether a write request only simulates its effects (?dryRun=true or
+// X-Dry-Run: true). Reads ignore both.
+func dryRunRequested(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+	return r.URL.Query().Get("dryRun") == "true" || r.Header.Get("X-Dry-Run") == "true"
+}
+
+// Run f in a transaction. Under dryRun, f runs in full (reads, uniqueness
+// and state checks included) but the transaction is rolled back instead of
+// committed; f's own errors are returned either way.
+func (s *Server) runTransaction(ctx context.Context, dryRun bool, f func(context.Context, *firestore.Transaction) error) error {
+	ctx, cancel := withBudget(ctx, "transaction")
+	defer cancel()
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := f(ctx, tx); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return err
+}
+
+// Flag a dry-run response so it can't be mistaken for a real write: the
+// X-Dry-Run header always, plus "dryRun": true on JSON object bodies
+func markDryRun(w http.ResponseWriter, r *http.Request, v interface{}) interface{} {
+	if !dryRunRequested(r) {
+		return v
+	}
+	w.Header().Set("X-Dry-Run", "true")
+	if v == nil {
+		return v
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if dec.Decode(&obj) != nil || obj == nil {
+		return v
+	}
+	obj["dryRun"] = true
+	return obj
+}
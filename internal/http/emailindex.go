@@ -0,0 +1,215 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Start a job scanning users and email_index for drift and repairing it
+// (POST /admin/emailIndex:check?dryRun=true to only report)
+func (s *Server) emailIndexCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+	id, err := s.startJob(requestContext(r), "email_index_check", map[string]interface{}{"dryRun": dryRunRequested(r)})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting email index check")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// The drift report needs both collections in full, so a takeover rescans.
+// Soft-deleted users own no email. Every repair re-reads the entry and the
+// user in a transaction first, so an entry claimed or a user changed since
+// the scan is left alone.
+func (s *Server) runEmailIndexCheckJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	dryRun := run.dryRun()
+	scanned := 0
+
+	// Index ID -> user ID that should own it, built from the users
+	// collection, then from the archive when archived users keep their email
+	expected := map[string]string{}
+	emails := map[string]string{}
+	conflicts := []map[string]interface{}{}
+	owners := []*firestore.CollectionRef{s.users.Users()}
+	if archiveKeepsEmail {
+		owners = append(owners, s.archiveCollection())
+	}
+	for _, col := range owners {
+		users := trackIterator("emailIndexCheck", col.Documents(ctx))
+		for {
+			doc, err := users.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				users.Stop()
+				return nil, err
+			}
+			scanned++
+			run.progress(scanned, 0, "")
+			// Anonymized placeholders gave their email up; indexing them would
+			// undo the release anonymizeUser made
+			if isSoftDeleted(doc) || isAnonymized(doc) {
+				continue
+			}
+			user := userFromDoc(doc)
+			email := model.NormalizeEmail(user.Email)
+			if email == "" {
+				continue
+			}
+			key := s.users.EmailIndex(email).ID
+			if owner, dup := expected[key]; dup {
+				// Duplicates predate the index; leave them for the merge tooling
+				conflicts = append(conflicts, map[string]interface{}{
+					"email":   email,
+					"userIds": []string{owner, doc.Ref.ID},
+				})
+				continue
+			}
+			expected[key] = doc.Ref.ID
+			emails[key] = email
+		}
+		users.Stop()
+	}
+
+	actual := map[string]string{}
+	entries := trackIterator("emailIndexCheck", s.client.Collection("email_index").Documents(ctx))
+	defer entries.Stop()
+	for {
+		doc, err := entries.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanned++
+		run.progress(scanned, 0, "")
+		owner, _ := doc.Data()["userId"].(string)
+		actual[doc.Ref.ID] = owner
+	}
+
+	missing := []map[string]interface{}{}
+	repointed := []map[string]interface{}{}
+	orphaned := []string{}
+	for key, userID := range expected {
+		owner, ok := actual[key]
+		switch {
+		case !ok:
+			missing = append(missing, map[string]interface{}{"email": emails[key], "userId": userID})
+		case owner != userID:
+			repointed = append(repointed, map[string]interface{}{"email": emails[key], "from": owner, "to": userID})
+		default:
+			continue
+		}
+		if !dryRun {
+			if err := s.repairEmailIndexEntry(ctx, key, owner, ok, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for key, owner := range actual {
+		if _, ok := expected[key]; ok {
+			continue
+		}
+		orphaned = append(orphaned, key)
+		if !dryRun {
+			if err := s.deleteOrphanedEmail(ctx, key, owner); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"dryRun":    dryRun,
+		"missing":   missing,
+		"repointed": repointed,
+		"orphaned":  orphaned,
+		"conflicts": conflicts,
+	}, nil
+}
+
+// Point the email index entry key at userID, found missing (existed
+// false) or pointing at owner during the scan, unless the entry has
+// changed since or userID no longer has that email
+func (s *Server) repairEmailIndexEntry(ctx context.Context, key, owner string, existed bool, userID string) error {
+	ref := s.client.Collection("email_index").Doc(key)
+	return s.runTransaction(ctx, false, func(ctx context.Context, tx *firestore.Transaction) error {
+		entry, err := tx.Get(ref)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			if existed {
+				return nil
+			}
+		case err != nil:
+			return err
+		default:
+			if current, _ := entry.Data()["userId"].(string); !existed || current != owner {
+				return nil
+			}
+		}
+		owns, err := s.ownsEmailTx(tx, userID, key)
+		if err != nil || !owns {
+			return err
+		}
+		return tx.Set(ref, map[string]interface{}{"userId": userID})
+	})
+}
+
+// Delete the email index entry key, found pointing at owner without owner
+// having that email, unless it has been claimed or owner has taken the
+// email since
+func (s *Server) deleteOrphanedEmail(ctx context.Context, key, owner string) error {
+	ref := s.client.Collection("email_index").Doc(key)
+	return s.runTransaction(ctx, false, func(ctx context.Context, tx *firestore.Transaction) error {
+		entry, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current, _ := entry.Data()["userId"].(string); current != owner {
+			return nil
+		}
+		if owner == "" {
+			return tx.Delete(ref)
+		}
+		owns, err := s.ownsEmailTx(tx, owner, key)
+		if err != nil || owns {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Whether userID, live or (when archived users keep their email) archived,
+// has the email of index entry key
+func (s *Server) ownsEmailTx(tx *firestore.Transaction, userID, key string) (bool, error) {
+	cols := []*firestore.CollectionRef{s.users.Users()}
+	if archiveKeepsEmail {
+		cols = append(cols, s.archiveCollection())
+	}
+	for _, col := range cols {
+		doc, err := tx.Get(col.Doc(userID))
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if email := model.NormalizeEmail(userFromDoc(doc).Email); !isSoftDeleted(doc) && !isAnonymized(doc) && email != "" && s.users.EmailIndex(email).ID == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestEmailIndexCheckRepairsDrift(t *testing.T) {
+	s, ctx := useEmulator(t)
+	live := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	deleted := mustCreateUser(t, s, ctx, model.User{Name: "Grace", Email: "grace@example.com"})
+	if _, err := s.users.Users().Doc(deleted).Update(ctx, []firestore.Update{{Path: "deletedAt", Value: time.Now().UTC()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.EmailIndex("ada@example.com").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.EmailIndex("ghost@example.com").Set(ctx, map[string]interface{}{"userId": "ghost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.runEmailIndexCheckJob(ctx, &jobRun{job: Job{Params: map[string]interface{}{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing, _ := result["missing"].([]map[string]interface{}); len(missing) != 1 || missing[0]["userId"] != live {
+		t.Errorf("missing = %v, want only %s", result["missing"], live)
+	}
+	if orphaned, _ := result["orphaned"].([]string); len(orphaned) != 2 {
+		t.Errorf("orphaned = %v, want the soft-deleted user's and ghost's entries", result["orphaned"])
+	}
+	for email, want := range map[string]bool{"ada@example.com": true, "grace@example.com": false, "ghost@example.com": false} {
+		_, err := s.users.EmailIndex(email).Get(ctx)
+		if got := err == nil; got != want {
+			t.Errorf("%s indexed = %v, want %v", email, got, want)
+		}
+	}
+}
+
+func TestDeleteOrphanedEmailKeepsClaimedEntries(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	key := s.users.EmailIndex("ada@example.com").ID
+
+	// Seen as orphaned by a scan that missed the user
+	if err := s.deleteOrphanedEmail(ctx, key, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.EmailIndex("ada@example.com").Get(ctx); err != nil {
+		t.Errorf("entry owned by %s was deleted: %v", id, err)
+	}
+
+	// Claimed by someone else since the scan
+	if err := s.deleteOrphanedEmail(ctx, key, "ghost"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.EmailIndex("ada@example.com").Get(ctx); err != nil {
+		t.Errorf("entry repointed since the scan was deleted: %v", err)
+	}
+}
+
+func TestRepairEmailIndexEntryRechecks(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	ref := s.users.EmailIndex("ada@example.com")
+	owner := func() string {
+		t.Helper()
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			return ""
+		}
+		userID, _ := doc.Data()["userId"].(string)
+		return userID
+	}
+
+	// Seen missing, but claimed by a concurrent createUser since the scan
+	if _, err := ref.Set(ctx, map[string]interface{}{"userId": "newcomer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repairEmailIndexEntry(ctx, ref.ID, "", false, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := owner(); got != "newcomer" {
+		t.Errorf("entry claimed since the scan now points at %q, want newcomer", got)
+	}
+
+	// Seen pointing at a ghost, but the user has changed email since
+	if _, err := ref.Set(ctx, map[string]interface{}{"userId": "ghost"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.Users().Doc(id).Update(ctx, []firestore.Update{{Path: "email", Value: "ada@elsewhere.example"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repairEmailIndexEntry(ctx, ref.ID, "ghost", true, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := owner(); got != "ghost" {
+		t.Errorf("entry repointed to a user without the email: owner %q, want ghost", got)
+	}
+
+	// Unchanged since the scan: repointed
+	if _, err := s.users.Users().Doc(id).Update(ctx, []firestore.Update{{Path: "email", Value: "ada@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repairEmailIndexEntry(ctx, ref.ID, "ghost", true, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := owner(); got != id {
+		t.Errorf("owner = %q, want %s", got, id)
+	}
+}
+
+// The check job leaves anonymized users' placeholder emails unindexed,
+// as anonymizeUser released them
+func TestEmailIndexCheckSkipsAnonymized(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if _, err := s.anonymizeUser(ctx, id, "admin", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	placeholder := anonymizedEmail("ada@example.com")
+
+	result, err := s.runEmailIndexCheckJob(ctx, &jobRun{job: Job{Params: map[string]interface{}{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing, _ := result["missing"].([]map[string]interface{}); len(missing) != 0 {
+		t.Errorf("missing = %v, want none", missing)
+	}
+	for _, email := range []string{"ada@example.com", placeholder} {
+		if _, err := s.users.EmailIndex(email).Get(ctx); err == nil {
+			t.Errorf("%s indexed after the check", email)
+		}
+	}
+}
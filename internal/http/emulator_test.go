@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/firestoretest"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/option"
+)
+
+// The in-memory Firestore tests share when no emulator is running
+var (
+	memFirestoreOnce sync.Once
+	memFirestore     *firestoretest.Server
+	memFirestoreErr  error
+)
+
+// A Server for tests that don't touch Firestore: dialing it fails
+func newTestServer(t testing.TB) *Server {
+	t.Helper()
+	return NewServer(func(context.Context) (*firestore.Client, error) {
+		return nil, fmt.Errorf("%s has no Firestore; use useEmulator", t.Name())
+	})
+}
+
+// A Server on the Firestore emulator for one test, or on an in-memory
+// Firestore when FIRESTORE_EMULATOR_HOST is unset. Every test gets a
+// project of its own, so tests never see each other's documents. opts
+// are passed on to the client, e.g. to install interceptors.
+func useEmulator(t testing.TB, opts ...option.ClientOption) (*Server, context.Context) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(t.Name()))
+	project := "demo-" + hex.EncodeToString(sum[:8])
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		memFirestoreOnce.Do(func() { memFirestore, memFirestoreErr = firestoretest.New() })
+		if memFirestoreErr != nil {
+			t.Fatalf("in-memory firestore: %v", memFirestoreErr)
+		}
+		memFirestore.Reset(project)
+		t.Cleanup(func() { memFirestore.Reset(project) })
+		opts = append(memFirestore.ClientOptions(), opts...)
+	}
+	ctx := context.Background()
+	c, err := firestore.NewClient(ctx, project, opts...)
+	if err != nil {
+		t.Fatalf("emulator client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	s := newTestServer(t)
+	s.useClient(c)
+	return s, ctx
+}
+
+// Create a user through the store, failing the test on error
+func mustCreateUser(t testing.TB, s *Server, ctx context.Context, user model.User) string {
+	t.Helper()
+	id, err := s.createUser(ctx, user, "", "test", false)
+	if err != nil {
+		t.Fatalf("createUser(%+v): %v", user, err)
+	}
+	return id
+}
+
+// Serve r with h and decode the JSON response, failing unless it has status want
+func serveJSON(t *testing.T, h http.HandlerFunc, r *http.Request, want int) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	if rec.Code != want {
+		t.Fatalf("%s %s = %d %s, want %d", r.Method, r.URL, rec.Code, rec.Body, want)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decoding %s: %v", r.Method, r.URL, rec.Body, err)
+	}
+	return body
+}
+
+// Serve r with h, failing unless it is refused with status and code
+func serveError(t *testing.T, h http.HandlerFunc, r *http.Request, status int, code string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	if rec.Code != status || rec.Header().Get("X-Error-Code") != code {
+		t.Fatalf("%s %s = %d %s (%s), want %d %s", r.Method, r.URL, rec.Code, rec.Header().Get("X-Error-Code"), rec.Body, status, code)
+	}
+}
+
+// A JSON request as a client sends it
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// The ops of a user's history, oldest first
+func historyOps(t *testing.T, s *Server, ctx context.Context, id string) []string {
+	t.Helper()
+	docs, err := historyCollection(s.users.Users().Doc(id)).OrderBy("version", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, d := range docs {
+		op, _ := d.Data()["op"].(string)
+		ops = append(ops, op)
+	}
+	return ops
+}
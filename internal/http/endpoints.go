@@ -0,0 +1,95 @@
+package http
+
+import "strings"
+
+// What the docs pages know about the API: the home page lists the public
+// endpoints and /console renders a form for every one, so an endpoint
+// added here shows up on both.
+var apiEndpoints = []apiEndpoint{
+	{
+		Method: "POST", Path: "/addUser", Summary: "Add a user (use Postman, curl or the console)",
+		Body: `{"name": "Ada Lovelace", "email": "ada@example.com", "plan": "free"}`,
+	},
+	{
+		Method: "GET", Path: "/listUsers", Summary: "List all users (?fields=name,email narrows the response)",
+		Params: []apiParam{
+			{Name: "fields", In: "query", Example: "name,email"},
+			{Name: "plan", In: "query", Example: "pro"},
+			{Name: "createdAfter", In: "query", Example: "2024-01-01T00:00:00Z"},
+			{Name: "createdBefore", In: "query"},
+			{Name: "consent", In: "query", Example: "marketing:v2"},
+		},
+	},
+	{
+		Method: "GET", Path: "/getUser", Summary: "Get user by ID (also GET /users/{id}; honors If-None-Match)",
+		Params: []apiParam{{Name: "id", In: "query", Required: true, Example: "yourUserID"}, {Name: "fields", In: "query"}},
+	},
+	{
+		Method: "GET", Path: "/getUserByEmail", Summary: "Get user by email",
+		Params: []apiParam{{Name: "email", In: "query", Required: true, Example: "you@example.com"}},
+	},
+	{
+		Method: "PUT", Path: "/updateUser", Summary: "Update a user (PATCH for JSON Patch)",
+		Params: []apiParam{{Name: "id", In: "query", Required: true, Example: "yourUserID"}, {Name: "updateMask", In: "query"}},
+		Body:   `{"name": "Ada King"}`,
+	},
+	{
+		Method: "DELETE", Path: "/deleteUser", Summary: "Delete a user",
+		Params: []apiParam{{Name: "id", In: "query", Required: true, Example: "yourUserID"}},
+	},
+	{
+		Method: "GET", Path: "/v1/users", Summary: "List users (v1: flat objects, paginated)",
+		Params: []apiParam{
+			{Name: "pageSize", In: "query", Example: "50"},
+			{Name: "pageToken", In: "query"},
+			{Name: "orderBy", In: "query", Example: "createdAt"},
+			{Name: "createdAfter", In: "query"},
+			{Name: "createdBefore", In: "query"},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/users/{id}", Summary: "Get a user (v1: flat object)",
+		Params: []apiParam{{Name: "id", In: "path", Required: true, Example: "yourUserID"}},
+	},
+	{
+		Method: "POST", Path: "/users:updateWhere", Summary: "Update every user matching a filter (dryRun=true counts them)", Admin: true,
+		Params: []apiParam{{Name: "dryRun", In: "query", Example: "true"}, {Name: "confirm", In: "query"}},
+		Body:   `{"filter": [{"field": "plan", "op": "==", "value": "free"}], "set": {"attributes.tier": "legacy"}}`,
+	},
+}
+
+// apiEndpoint is one route as documented
+type apiEndpoint struct {
+	Method  string
+	Path    string // {name} segments are path parameters
+	Summary string
+	Params  []apiParam
+	Body    string // an example JSON body, "" for none
+	Admin   bool   // needs ADMIN_TOKEN
+}
+
+type apiParam struct {
+	Name     string
+	In       string // query or path
+	Required bool
+	Example  string
+}
+
+// The example request the home page shows, e.g. /getUser?id=yourUserID
+func (e apiEndpoint) ExampleURL() string {
+	var query []string
+	for _, p := range e.Params {
+		if p.In == "query" && p.Required {
+			query = append(query, p.Name+"="+p.Example)
+		}
+	}
+	if len(query) == 0 {
+		return e.Path
+	}
+	return e.Path + "?" + strings.Join(query, "&")
+}
+
+// Whether the home page can link the example: a GET without path parameters
+func (e apiEndpoint) Linkable() bool {
+	return e.Method == "GET" && !strings.Contains(e.Path, "{")
+}
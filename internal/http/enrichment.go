@@ -0,0 +1,282 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Enricher looks up extra profile data for a user, such as the company
+// behind their email domain
+type Enricher interface {
+	Enrich(ctx context.Context, id string, user model.User) (map[string]interface{}, error)
+}
+
+// EnricherFunc adapts a function to Enricher, for tests and other
+// in-process enrichers
+type EnricherFunc func(ctx context.Context, id string, user model.User) (map[string]interface{}, error)
+
+func (f EnricherFunc) Enrich(ctx context.Context, id string, user model.User) (map[string]interface{}, error) {
+	return f(ctx, id, user)
+}
+
+// Configured enricher, nil when ENRICHMENT_URL is unset. Tests can swap
+// in their own.
+var enricher Enricher
+
+// With ENRICHMENT_URL set, every created user is POSTed there (signed with
+// ENRICHMENT_SECRET) after the create commits, and the ENRICHMENT_FIELDS of
+// the JSON reply are merged into its attributes. The user's
+// enrichmentStatus field tracks pending -> succeeded or failed; a failure
+// never affects the create, and POST /users/{id}:reenrich tries again.
+var (
+	enrichmentURL     = config.String("ENRICHMENT_URL", "")
+	enrichmentSecret  = config.String("ENRICHMENT_SECRET", "")
+	enrichmentTimeout = config.Duration("ENRICHMENT_TIMEOUT", 5*time.Second)
+	enrichmentRetries = config.Int("ENRICHMENT_RETRIES", 3)
+	enrichmentFields  = strings.Split(config.String("ENRICHMENT_FIELDS", "company,companySize"), ",")
+	enrichmentQueue   = make(chan enrichmentJob, config.Int("ENRICHMENT_QUEUE", 1000))
+	enrichmentBackoff = 500 * time.Millisecond // before the first retry, doubling
+)
+
+const (
+	enrichmentPending   = "pending"
+	enrichmentSucceeded = "succeeded"
+	enrichmentFailed    = "failed"
+)
+
+// The largest enrichment reply read
+const enrichmentMaxResponse = 1 << 20
+
+type enrichmentJob struct {
+	id   string
+	user model.User
+}
+
+// Build the HTTP enricher from config and start its worker
+func (s *Server) initEnrichment(bg *background) {
+	if enrichmentURL != "" {
+		enricher = &httpEnricher{url: enrichmentURL, secret: enrichmentSecret, client: &http.Client{Timeout: enrichmentTimeout}}
+	}
+	if enricher == nil {
+		return
+	}
+	bg.Go(s.runEnrichment)
+	fmt.Println("🏢 Enrichment hook enabled:", enrichmentURL)
+}
+
+// Queue a created user for enrichment; a no-op without an enricher. A
+// full queue fails the enrichment rather than blocking the caller.
+func (s *Server) enqueueEnrichment(id string, user model.User) {
+	if enricher == nil {
+		return
+	}
+	select {
+	case enrichmentQueue <- enrichmentJob{id: id, user: user}:
+	default:
+		log.Printf("⚠️ Enrichment queue full, not enriching user %s", id)
+		go s.recordEnrichment(withEndpoint(context.Background(), "enrichment"), id, nil, errors.New("enrichment queue full"))
+	}
+}
+
+func (s *Server) runEnrichment(ctx context.Context) {
+	ctx = withEndpoint(ctx, "enrichment")
+	for {
+		var job enrichmentJob
+		select {
+		case job = <-enrichmentQueue:
+		case <-ctx.Done():
+			return
+		}
+		fields, err := enricher.Enrich(ctx, job.id, job.user)
+		if err != nil {
+			log.Printf("⚠️ Enrichment of user %s failed: %v", job.id, err)
+		}
+		if err := s.recordEnrichment(ctx, job.id, fields, err); err != nil {
+			log.Printf("⚠️ Failed to record enrichment of user %s: %v", job.id, err)
+		}
+	}
+}
+
+// Store an enrichment outcome: the allowlisted fields merged into the
+// user's attributes (with a history version) on success, the error on
+// failure. A user deleted in the meantime is left alone.
+func (s *Server) recordEnrichment(ctx context.Context, id string, fields map[string]interface{}, enrichErr error) error {
+	ref := s.users.Users().Doc(id)
+	defer forgetDocumentRead(ref)
+	var enriched *model.User
+	err := s.runTransaction(ctx, false, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		updates := []firestore.Update{{Path: "enrichedAt", Value: now}, {Path: "updatedAt", Value: now}}
+		if enrichErr != nil {
+			return tx.Update(ref, append(updates,
+				firestore.Update{Path: "enrichmentStatus", Value: enrichmentFailed},
+				firestore.Update{Path: "enrichmentError", Value: enrichErr.Error()}))
+		}
+		updates = append(updates,
+			firestore.Update{Path: "enrichmentStatus", Value: enrichmentSucceeded},
+			firestore.Update{Path: "enrichmentError", Value: firestore.Delete})
+		allowed := allowedEnrichment(fields)
+		if len(allowed) == 0 {
+			return tx.Update(ref, updates)
+		}
+
+		latest, err := latestHistoryTx(tx, ref)
+		if err != nil {
+			return err
+		}
+		data := doc.Data()
+		attrs, _ := data["attributes"].(map[string]interface{})
+		attrs = maps.Clone(attrs)
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+		}
+		for k, v := range allowed {
+			attrs[k] = v
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"attributes", k}, Value: v})
+		}
+		data["attributes"] = attrs
+		data["enrichmentStatus"] = enrichmentSucceeded
+		if err := tx.Update(ref, append(updates, historyVersionUpdate(latest))); err != nil {
+			return err
+		}
+		if err := s.recordOutboxChangeTx(tx, "user.updated", id, "enrichment", data, doc.Data()); err != nil {
+			return err
+		}
+		user := userFromData(data)
+		enriched = &user
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{Op: "enrich", Data: data, Previous: doc.Data(), Actor: "enrichment"})
+	})
+	if err == nil && enriched != nil {
+		s.enqueueSearchUpsert(id, *enriched)
+	}
+	return err
+}
+
+// The ENRICHMENT_FIELDS of an enrichment reply
+func allowedEnrichment(fields map[string]interface{}) map[string]interface{} {
+	allowed := map[string]interface{}{}
+	for _, name := range enrichmentFields {
+		if v, ok := fields[strings.TrimSpace(name)]; ok && v != nil {
+			allowed[strings.TrimSpace(name)] = v
+		}
+	}
+	return allowed
+}
+
+// Retry a user's enrichment (POST /users/{id}:reenrich). Its status goes
+// back to pending, and the outcome lands asynchronously as on create.
+func (s *Server) reenrichUserHandler(w http.ResponseWriter, r *http.Request) {
+	if enricher == nil {
+		writeError(w, r, http.StatusNotImplemented, "enrichment_not_configured", "No enrichment hook configured (ENRICHMENT_URL)")
+		return
+	}
+	id := r.PathValue("id")
+	ref := s.users.Users().Doc(id)
+	dryRun := dryRunRequested(r)
+	var user model.User
+	err := s.runTransaction(requestContext(r), dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		user = userFromDoc(doc)
+		return tx.Update(ref, withUpdatedAt([]firestore.Update{{Path: "enrichmentStatus", Value: enrichmentPending}}))
+	})
+	forgetDocumentRead(ref)
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting enrichment")
+		return
+	}
+	if !dryRun {
+		s.enqueueEnrichment(id, user)
+	}
+	writeJSON(w, r, http.StatusAccepted, map[string]interface{}{"id": id, "enrichmentStatus": enrichmentPending})
+}
+
+// httpEnricher is the ENRICHMENT_URL hook. The body is {"id", "user"},
+// signed like webhooks in X-Signature; the reply is a JSON object.
+type httpEnricher struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// POST the user, retrying network errors, 429s and 5xxs with jittered
+// backoff up to ENRICHMENT_RETRIES times
+func (e *httpEnricher) Enrich(ctx context.Context, id string, user model.User) (map[string]interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{"id": id, "user": user})
+	if err != nil {
+		return nil, err
+	}
+	backoff := enrichmentBackoff
+	for attempt := 0; ; attempt++ {
+		fields, retry, err := e.post(ctx, body)
+		if err == nil || !retry || attempt >= enrichmentRetries {
+			return fields, err
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// One enrichment call; retry says whether the error is worth another
+func (e *httpEnricher) post(ctx context.Context, body []byte) (map[string]interface{}, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.secret != "" {
+		req.Header.Set("X-Signature", hmacSignature(e.secret, body))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+			fmt.Errorf("enrichment returned %s", resp.Status)
+	}
+	var fields map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, enrichmentMaxResponse)).Decode(&fields); err != nil {
+		return nil, false, fmt.Errorf("invalid enrichment reply: %w", err)
+	}
+	return fields, false, nil
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Enrich created users with e, through the enrichment worker
+func withEnricher(t *testing.T, s *Server, e Enricher) {
+	t.Helper()
+	saved := enricher
+	enricher = e
+	bg := newBackground()
+	bg.Go(s.runEnrichment)
+	t.Cleanup(func() {
+		bg.stop(context.Background())
+		enricher = saved
+	})
+}
+
+// The user's data once enrichment is no longer pending
+func awaitEnrichment(t *testing.T, s *Server, ctx context.Context, id string) map[string]interface{} {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Data()["enrichmentStatus"] != enrichmentPending {
+			return doc.Data()
+		}
+	}
+	t.Fatalf("user %s still pending enrichment", id)
+	return nil
+}
+
+func TestHTTPEnricher(t *testing.T) {
+	savedBackoff, savedRetries := enrichmentBackoff, enrichmentRetries
+	enrichmentBackoff, enrichmentRetries = time.Millisecond, 2
+	t.Cleanup(func() { enrichmentBackoff, enrichmentRetries = savedBackoff, savedRetries })
+
+	tests := []struct {
+		name       string
+		replies    []int // status of each call; the last repeats
+		body       string
+		wantFields map[string]interface{}
+		wantErr    bool
+		wantCalls  int32
+	}{
+		{"ok", []int{200}, `{"company": "Analytical Engines", "companySize": 12}`, map[string]interface{}{"company": "Analytical Engines", "companySize": 12.0}, false, 1},
+		{"retried 5xx and 429", []int{503, 429, 200}, `{"company": "Analytical Engines"}`, map[string]interface{}{"company": "Analytical Engines"}, false, 3},
+		{"out of retries", []int{502}, ``, nil, true, 3},
+		{"4xx not retried", []int{400}, ``, nil, true, 1},
+		{"not JSON", []int{200}, `<html>`, nil, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if got, want := r.Header.Get("X-Signature"), hmacSignature("s3cret", body); got != want {
+					t.Errorf("X-Signature = %q, want %q", got, want)
+				}
+				var req struct {
+					ID   string     `json:"id"`
+					User model.User `json:"user"`
+				}
+				if err := json.Unmarshal(body, &req); err != nil || req.ID != "u1" || req.User.Email != "ada@analytical.example" {
+					t.Errorf("body = %s (%v)", body, err)
+				}
+				n := int(calls.Add(1))
+				w.WriteHeader(tt.replies[min(n, len(tt.replies))-1])
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			e := &httpEnricher{url: srv.URL, secret: "s3cret", client: srv.Client()}
+			fields, err := e.Enrich(context.Background(), "u1", model.User{Name: "Ada", Email: "ada@analytical.example"})
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("Enrich = %v, %v; want %v, error %v", fields, err, tt.wantFields, tt.wantErr)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAllowedEnrichment(t *testing.T) {
+	saved := enrichmentFields
+	enrichmentFields = []string{"company", " companySize "}
+	t.Cleanup(func() { enrichmentFields = saved })
+	got := allowedEnrichment(map[string]interface{}{"company": "Analytical Engines", "companySize": nil, "plan": "enterprise", "email": "x@example.com"})
+	if want := map[string]interface{}{"company": "Analytical Engines"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("allowedEnrichment = %v, want %v", got, want)
+	}
+}
+
+// Created users are enriched in the background through the injected
+// enricher; a failure leaves the user created and marked failed, and
+// :reenrich tries again
+func TestEnrichmentHook(t *testing.T) {
+	s, ctx := useEmulator(t)
+	var fail atomic.Bool
+	var calls atomic.Int32
+	withEnricher(t, s, EnricherFunc(func(_ context.Context, id string, user model.User) (map[string]interface{}, error) {
+		calls.Add(1)
+		if fail.Load() {
+			return nil, errors.New("lookup service down")
+		}
+		return map[string]interface{}{"company": "Analytical Engines", "companySize": int64(12), "plan": "enterprise"}, nil
+	}))
+
+	body := serveJSON(t, s.addUserHandler, jsonRequest(http.MethodPost, "/addUser", `{"name": "Ada", "email": "ada@analytical.example"}`), http.StatusOK)
+	id := body["id"].(string)
+	data := awaitEnrichment(t, s, ctx, id)
+	attrs, _ := data["attributes"].(map[string]interface{})
+	if data["enrichmentStatus"] != enrichmentSucceeded || attrs["company"] != "Analytical Engines" || attrs["companySize"] != int64(12) {
+		t.Errorf("enriched user = %v", data)
+	}
+	if data["plan"] != string(model.PlanFree) || attrs["plan"] != nil {
+		t.Errorf("enrichment wrote a field outside ENRICHMENT_FIELDS: %v", data)
+	}
+	if ops := historyOps(t, s, ctx, id); !reflect.DeepEqual(ops, []string{"create", "enrich"}) {
+		t.Errorf("history = %v, want [create enrich]", ops)
+	}
+
+	fail.Store(true)
+	body = serveJSON(t, s.addUserHandler, jsonRequest(http.MethodPost, "/addUser", `{"name": "Grace", "email": "grace@navy.example"}`), http.StatusOK)
+	failed := body["id"].(string)
+	data = awaitEnrichment(t, s, ctx, failed)
+	if data["enrichmentStatus"] != enrichmentFailed || data["enrichmentError"] != "lookup service down" || data["name"] != "Grace" {
+		t.Errorf("user after a failed enrichment = %v", data)
+	}
+
+	fail.Store(false)
+	r := httptest.NewRequest(http.MethodPost, "/users/"+failed+":reenrich", nil)
+	r.SetPathValue("id", failed)
+	serveJSON(t, s.reenrichUserHandler, r, http.StatusAccepted)
+	data = awaitEnrichment(t, s, ctx, failed)
+	if attrs, _ := data["attributes"].(map[string]interface{}); data["enrichmentStatus"] != enrichmentSucceeded || data["enrichmentError"] != nil || attrs["company"] != "Analytical Engines" {
+		t.Errorf("user after :reenrich = %v", data)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("enricher called %d times, want 3", calls.Load())
+	}
+}
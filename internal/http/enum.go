@@ -0,0 +1,46 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+)
+
+// enum is the closed set of values a string field may hold. Input is
+// matched ignoring case and surrounding space and stored as the
+// canonical value, so "Pro " is stored as "pro".
+type enum[T ~string] struct {
+	field  string
+	values []T
+}
+
+func newEnum[T ~string](field string, values ...T) enum[T] {
+	return enum[T]{field: field, values: values}
+}
+
+// The canonical value s names
+func (e enum[T]) parse(s string) (T, bool) {
+	s = strings.TrimSpace(s)
+	for _, v := range e.values {
+		if strings.EqualFold(s, string(v)) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// The values as English, e.g. "free, pro or enterprise"
+func (e enum[T]) allowed() string {
+	names := make([]string, len(e.values))
+	for i, v := range e.values {
+		names[i] = string(v)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// The 422 detail for a value outside the set
+func (e enum[T]) fieldError(got string) FieldError {
+	return FieldError{Field: e.field, Message: fmt.Sprintf("must be %s, not %q", e.allowed(), got)}
+}
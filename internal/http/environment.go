@@ -0,0 +1,114 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Whether the server talks to the Firestore emulator or a real project,
+// made explicit everywhere so a load test never reaches production by
+// mistake: a startup banner, "environment" on /version and /healthz, and
+// ENVIRONMENT_HEADER (X-Environment: emulator|production; empty for none)
+// on every response. FIRESTORE_EMULATOR_HOST means the emulator; any other
+// project counts as production, and one whose ID matches
+// PRODUCTION_PROJECT_PATTERN is protected: destructive admin operations
+// (generating users, importing with onConflict=overwrite, bulk updates and
+// bulk anonymization, seeding) are refused there unless confirmed with
+// X-Confirm-Production: <project ID> or, on the CLI,
+// --i-know-what-i-am-doing. Dry runs, and the single-user operations whose
+// history makes them traceable (merge, undo, clone), need no confirming.
+// ENVIRONMENT=emulator|production overrides the detected mode; production
+// is then protected whatever the ID.
+var (
+	environmentOverride    = config.String("ENVIRONMENT", "")
+	environmentHeader      = config.String("ENVIRONMENT_HEADER", "X-Environment")
+	productionProjectRegex = regexp.MustCompile(config.String("PRODUCTION_PROJECT_PATTERN", `(^|[-_])prod(uction)?([-_]|$)`))
+)
+
+const (
+	envEmulator   = "emulator"
+	envProduction = "production"
+)
+
+const confirmProductionHeader = "X-Confirm-Production"
+
+// runtimeEnvironment is what detectEnvironment decided, and why
+type runtimeEnvironment struct {
+	Mode      string `json:"mode"` // emulator or production
+	Project   string `json:"project"`
+	Protected bool   `json:"protected"` // destructive operations need confirming
+	Source    string `json:"source"`    // override, emulator_host or project
+}
+
+// Set once Firestore is connected, before the listener starts
+var currentEnvironment = runtimeEnvironment{Mode: envProduction, Source: "project"}
+
+func detectEnvironment(emulatorHost, project, override string, pattern *regexp.Regexp) (runtimeEnvironment, error) {
+	env := runtimeEnvironment{Project: project}
+	switch {
+	case override == envProduction:
+		env.Mode, env.Protected, env.Source = envProduction, true, "override"
+	case override == envEmulator:
+		// A label only: a matching project not behind the emulator stays protected
+		env.Mode, env.Source = envEmulator, "override"
+		env.Protected = emulatorHost == "" && pattern.MatchString(project)
+	case override != "":
+		return env, fmt.Errorf("ENVIRONMENT must be %s or %s, not %q", envEmulator, envProduction, override)
+	case emulatorHost != "":
+		env.Mode, env.Source = envEmulator, "emulator_host"
+	default:
+		env.Mode, env.Source = envProduction, "project"
+		env.Protected = pattern.MatchString(project)
+	}
+	return env, nil
+}
+
+// Detect the environment of the connected client and announce it
+func (s *Server) setEnvironment() {
+	project, _ := s.firestoreDatabase()
+	emulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	env, err := detectEnvironment(emulatorHost, project, environmentOverride, productionProjectRegex)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	currentEnvironment = env
+	switch {
+	case env.Mode == envEmulator && emulatorHost != "":
+		fmt.Printf("🧪 EMULATOR: Firestore emulator at %s, project %s\n", emulatorHost, project)
+	case env.Mode == envEmulator:
+		log.Printf("⚠️ EMULATOR by ENVIRONMENT override, but FIRESTORE_EMULATOR_HOST is unset: project %s is a real one", project)
+	case env.Protected:
+		fmt.Printf("🚨 PRODUCTION: Firestore project %s; destructive admin operations need %s: %s\n", project, confirmProductionHeader, project)
+	default:
+		fmt.Printf("☁️ PRODUCTION: Firestore project %s (not matching PRODUCTION_PROJECT_PATTERN)\n", project)
+	}
+}
+
+// Label every response with the environment
+func environmentMiddleware(next http.Handler) http.Handler {
+	if environmentHeader == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(environmentHeader, currentEnvironment.Mode)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Let a destructive operation through unless the project is protected and
+// r doesn't confirm it by naming the project, writing the refusal when it
+// doesn't
+func confirmProduction(w http.ResponseWriter, r *http.Request, operation string) bool {
+	env := currentEnvironment
+	if !env.Protected || r.Header.Get(confirmProductionHeader) == env.Project {
+		return true
+	}
+	writeError(w, r, http.StatusForbidden, "production_unconfirmed",
+		fmt.Sprintf("Refusing to %s in production project %s without %s: %s", operation, env.Project, confirmProductionHeader, env.Project))
+	return false
+}
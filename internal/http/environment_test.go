@@ -0,0 +1,99 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestDetectEnvironment(t *testing.T) {
+	pattern := regexp.MustCompile(`(^|[-_])prod(uction)?([-_]|$)`)
+	tests := []struct {
+		name          string
+		emulatorHost  string
+		project       string
+		override      string
+		wantMode      string
+		wantProtected bool
+		wantSource    string
+	}{
+		{"emulator host", "localhost:8080", "acme-prod", "", envEmulator, false, "emulator_host"},
+		{"prod suffix", "", "acme-prod", "", envProduction, true, "project"},
+		{"production infix", "", "acme-production-eu", "", envProduction, true, "project"},
+		{"prod_ underscore", "", "prod_acme", "", envProduction, true, "project"},
+		{"staging", "", "acme-staging", "", envProduction, false, "project"},
+		{"prod as part of a word", "", "product-catalog", "", envProduction, false, "project"},
+		{"override production", "", "acme-staging", envProduction, envProduction, true, "override"},
+		{"override production on the emulator", "localhost:8080", "demo", envProduction, envProduction, true, "override"},
+		{"override emulator on a real prod project", "", "acme-prod", envEmulator, envEmulator, true, "override"},
+		{"override emulator on a real other project", "", "acme-staging", envEmulator, envEmulator, false, "override"},
+		{"override emulator behind the emulator", "localhost:8080", "acme-prod", envEmulator, envEmulator, false, "override"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := detectEnvironment(tt.emulatorHost, tt.project, tt.override, pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Mode != tt.wantMode || env.Protected != tt.wantProtected || env.Source != tt.wantSource {
+				t.Errorf("got %+v, want mode %s, protected %v, source %s", env, tt.wantMode, tt.wantProtected, tt.wantSource)
+			}
+			if env.Project != tt.project {
+				t.Errorf("project = %q, want %q", env.Project, tt.project)
+			}
+		})
+	}
+}
+
+func TestDetectEnvironmentRejectsUnknownOverride(t *testing.T) {
+	for _, override := range []string{"qa", "Production", "prod"} {
+		if _, err := detectEnvironment("", "acme-prod", override, productionProjectRegex); err == nil {
+			t.Errorf("ENVIRONMENT=%s accepted", override)
+		}
+	}
+}
+
+func TestDetectEnvironmentCustomPattern(t *testing.T) {
+	pattern := regexp.MustCompile(`^acme-live$`)
+	if env, _ := detectEnvironment("", "acme-live", "", pattern); !env.Protected {
+		t.Error("acme-live matches the pattern but isn't protected")
+	}
+	if env, _ := detectEnvironment("", "acme-prod", "", pattern); env.Protected {
+		t.Error("acme-prod doesn't match the pattern but is protected")
+	}
+}
+
+func TestConfirmProduction(t *testing.T) {
+	saved := currentEnvironment
+	t.Cleanup(func() { currentEnvironment = saved })
+
+	tests := []struct {
+		name    string
+		env     runtimeEnvironment
+		confirm string
+		want    bool
+	}{
+		{"emulator", runtimeEnvironment{Mode: envEmulator, Project: "demo"}, "", true},
+		{"unprotected production", runtimeEnvironment{Mode: envProduction, Project: "acme-staging"}, "", true},
+		{"protected, unconfirmed", runtimeEnvironment{Mode: envProduction, Project: "acme-prod", Protected: true}, "", false},
+		{"protected, wrong project", runtimeEnvironment{Mode: envProduction, Project: "acme-prod", Protected: true}, "acme-staging", false},
+		{"protected, confirmed", runtimeEnvironment{Mode: envProduction, Project: "acme-prod", Protected: true}, "acme-prod", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentEnvironment = tt.env
+			r := httptest.NewRequest(http.MethodPost, "/admin/generateUsers", nil)
+			if tt.confirm != "" {
+				r.Header.Set(confirmProductionHeader, tt.confirm)
+			}
+			rec := httptest.NewRecorder()
+			if got := confirmProduction(rec, r, "generate users"); got != tt.want {
+				t.Fatalf("confirmProduction = %v, want %v", got, tt.want)
+			}
+			if !tt.want && (rec.Code != http.StatusForbidden || rec.Header().Get("X-Error-Code") != "production_unconfirmed") {
+				t.Errorf("refusal = %d %s, want 403 production_unconfirmed", rec.Code, rec.Header().Get("X-Error-Code"))
+			}
+		})
+	}
+}
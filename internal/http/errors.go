@@ -0,0 +1,76 @@
+package http
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// FieldError is a field-level validation problem attached to an error response
+type FieldError = api.FieldError
+
+// ERROR_FORMAT=problem makes RFC 7807 the default instead of plain text.
+// PROBLEM_TYPE_BASE prefixes the error code to form the problem "type" URI.
+var (
+	errorFormat     = config.String("ERROR_FORMAT", "text")
+	problemTypeBase = config.String("PROBLEM_TYPE_BASE", "urn:gofirestoreapp:error:")
+)
+
+// Whether the client asked for (or the server defaults to) application/problem+json
+func wantsProblemJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/problem+json" && params["q"] != "0" {
+			return true
+		}
+	}
+	return errorFormat == "problem"
+}
+
+// Write an error response with a machine-readable code.
+//
+// By default this is the plain-text body the API has always returned, with
+// the code in X-Error-Code. Clients sending Accept: application/problem+json
+// get an RFC 7807 document carrying the code, request ID and field errors.
+// The detail is localized per Accept-Language; the code never is.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string, fields ...FieldError) {
+	writeErrorWith(w, r, status, code, detail, nil, fields...)
+}
+
+// writeError with extra members in the problem document (plain-text
+// responses carry only the detail)
+func writeErrorWith(w http.ResponseWriter, r *http.Request, status int, code, detail string, extra map[string]interface{}, fields ...FieldError) {
+	if status == http.StatusInternalServerError && budgetExhausted(r) {
+		status, code, detail = http.StatusGatewayTimeout, "budget_exhausted", "The request ran out of its "+requestBudget.String()+" budget"
+	}
+	detail, lang := localizedDetail(r, code, detail)
+	w.Header().Set("X-Error-Code", code)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	if !wantsProblemJSON(r) {
+		http.Error(w, detail, status)
+		return
+	}
+
+	problem := ErrorResponse{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestID(r),
+		Errors:    fields,
+		Extra:     extra,
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := encodeJSON(w, r, problem); err != nil {
+		logCtx(r.Context(), "❌ Failed to encode problem document for %s: %v", code, err)
+	}
+}
@@ -0,0 +1,226 @@
+package http
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Swap errorFormat for the duration of a test
+func withErrorFormat(t *testing.T, format string) {
+	saved := errorFormat
+	errorFormat = format
+	t.Cleanup(func() { errorFormat = saved })
+}
+
+// An error response read back in whichever format it came in
+type servedError struct {
+	status  int
+	code    string // X-Error-Code
+	problem *ErrorResponse
+	text    string
+}
+
+func serveErrorFormat(t *testing.T, h http.Handler, r *http.Request) servedError {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	got := servedError{status: rec.Code, code: rec.Header().Get("X-Error-Code")}
+	switch ct := rec.Header().Get("Content-Type"); ct {
+	case "application/problem+json":
+		got.problem = &ErrorResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), got.problem); err != nil {
+			t.Fatalf("problem document %s: %v", rec.Body, err)
+		}
+	case "text/plain; charset=utf-8":
+		got.text = strings.TrimSuffix(rec.Body.String(), "\n")
+	default:
+		t.Fatalf("%d with Content-Type %q: %s", rec.Code, ct, rec.Body)
+	}
+	return got
+}
+
+func TestWriteErrorFormats(t *testing.T) {
+	h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", "email is invalid", FieldError{Field: "email", Message: "is invalid"})
+	}))
+	request := func(accept string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/addUser?dryRun=true", nil)
+		r.Header.Set("X-Request-ID", "req-123")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return r
+	}
+
+	for _, accept := range []string{"", "application/json", "application/problem+json;q=0"} {
+		got := serveErrorFormat(t, h, request(accept))
+		if got.problem != nil || got.text != "email is invalid" || got.code != "invalid_field" || got.status != http.StatusUnprocessableEntity {
+			t.Errorf("Accept %q: got %+v, want the plain-text error", accept, got)
+		}
+	}
+
+	want := ErrorResponse{
+		Type:      problemTypeBase + "invalid_field",
+		Title:     "Unprocessable Entity",
+		Status:    http.StatusUnprocessableEntity,
+		Detail:    "email is invalid",
+		Instance:  "/addUser",
+		Code:      "invalid_field",
+		RequestID: "req-123",
+		Errors:    []FieldError{{Field: "email", Message: "is invalid"}},
+	}
+	for _, accept := range []string{"application/problem+json", "application/json, application/problem+json;q=0.5"} {
+		got := serveErrorFormat(t, h, request(accept))
+		if got.problem == nil || got.code != "invalid_field" || got.status != want.Status {
+			t.Fatalf("Accept %q: got %+v, want a problem document", accept, got)
+		}
+		if p := *got.problem; p.Type != want.Type || p.Title != want.Title || p.Status != want.Status || p.Detail != want.Detail ||
+			p.Instance != want.Instance || p.Code != want.Code || p.RequestID != want.RequestID || len(p.Errors) != 1 || p.Errors[0] != want.Errors[0] {
+			t.Errorf("Accept %q: problem = %+v, want %+v", accept, p, want)
+		}
+	}
+
+	withErrorFormat(t, "problem")
+	if got := serveErrorFormat(t, h, request("")); got.problem == nil {
+		t.Errorf("ERROR_FORMAT=problem: got %+v, want a problem document", got)
+	}
+}
+
+// Every handler's error paths produce both formats: each request below
+// fails before reaching Firestore and is served once per format
+func TestHandlerErrorsInBothFormats(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "")
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	withBody := func(method, target, contentType, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		request func() *http.Request
+		status  int
+		code    string
+	}{
+		// Routes behind quotaMiddleware are served directly: the quota check reads Firestore
+		{"addUser method", http.HandlerFunc(s.addUserHandler), func() *http.Request { return jsonRequest(http.MethodGet, "/addUser", "") }, 405, "method_not_allowed"},
+		{"addUser body", http.HandlerFunc(s.addUserHandler), func() *http.Request { return jsonRequest(http.MethodPost, "/addUser", `{"name": 5}`) }, 400, "invalid_body"},
+		{"addUser plan", http.HandlerFunc(s.addUserHandler), func() *http.Request { return jsonRequest(http.MethodPost, "/addUser", `{"plan": "platinum"}`) }, 422, "invalid_field"},
+		{"addUser media type", http.HandlerFunc(s.addUserHandler), func() *http.Request { return withBody(http.MethodPost, "/addUser", "text/csv", "a,b") }, 415, "unsupported_media_type"},
+		{"updateUser id", http.HandlerFunc(s.updateUserHandler), func() *http.Request { return jsonRequest(http.MethodPut, "/updateUser", `{}`) }, 400, "missing_parameter"},
+		{"updateUser PATCH media type", http.HandlerFunc(s.updateUserHandler), func() *http.Request { return jsonRequest(http.MethodPatch, "/updateUser?id=a", `[]`) }, 415, "unsupported_media_type"},
+		{"updateUser patch", http.HandlerFunc(s.updateUserHandler), func() *http.Request {
+			return withBody(http.MethodPatch, "/updateUser?id=a", "application/json-patch+json", `[{"op": "add", "path": "/createdAt", "value": 1}]`)
+		}, 422, "invalid_patch"},
+		{"deleteUser id", http.HandlerFunc(s.deleteUserHandler), func() *http.Request { return jsonRequest(http.MethodDelete, "/deleteUser", "") }, 400, "missing_parameter"},
+		{"consents body", http.HandlerFunc(s.postConsentHandler), func() *http.Request { return jsonRequest(http.MethodPost, "/users/a/consents", `[`) }, 400, "invalid_body"},
+		{"preferences body", http.HandlerFunc(s.putPreferencesHandler), func() *http.Request { return jsonRequest(http.MethodPut, "/users/a/preferences", `[`) }, 400, "invalid_body"},
+
+		{"getUser id", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/getUser", "") }, 400, "missing_parameter"},
+		{"getUser fields", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/getUser?id=a&fields=shoeSize", "") }, 400, "unknown_field"},
+		{"getUser onMalformed", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/users/a?onMalformed=ignore", "") }, 400, "invalid_argument"},
+		{"getUserByEmail email", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/getUserByEmail", "") }, 400, "missing_parameter"},
+		{"listUsers plan", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/listUsers?plan=platinum", "") }, 400, "invalid_argument"},
+		{"listUsers createdAfter", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/listUsers?createdAfter=yesterday", "") }, 422, "invalid_field"},
+		{"users:exists body", mux, func() *http.Request { return jsonRequest(http.MethodPost, "/users:exists", `{}`) }, 400, "missing_parameter"},
+		{"v1 users orderBy", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/v1/users?orderBy=email", "") }, 400, "invalid_argument"},
+		{"v1 user onMalformed", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/v1/users/a?onMalformed=ignore", "") }, 400, "invalid_argument"},
+		{"search query", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/users/search", "") }, 400, "missing_parameter"},
+		{"leaderboard limit", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/users/leaderboard?limit=1000", "") }, 400, "invalid_argument"},
+		{"admin disabled", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/admin/jobs", "") }, 403, "admin_disabled"},
+		{"admin export", mux, func() *http.Request { return jsonRequest(http.MethodGet, "/admin/users:export", "") }, 403, "admin_disabled"},
+		{"document ID", documentIDMiddleware(mux), func() *http.Request { return jsonRequest(http.MethodGet, "/users/a%2Fhistory%2F1", "") }, 400, "invalid_argument"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := requestIDMiddleware(tt.handler)
+			text := serveErrorFormat(t, h, tt.request())
+			if text.status != tt.status || text.code != tt.code || text.problem != nil || text.text == "" {
+				t.Errorf("plain text: got %+v, want %d %s", text, tt.status, tt.code)
+			}
+
+			r := tt.request()
+			r.Header.Set("Accept", "application/problem+json")
+			got := serveErrorFormat(t, h, r)
+			if got.status != tt.status || got.code != tt.code || got.problem == nil {
+				t.Fatalf("problem+json: got %+v, want %d %s", got, tt.status, tt.code)
+			}
+			p := got.problem
+			if p.Status != tt.status || p.Code != tt.code || p.Type != problemTypeBase+tt.code || p.Instance != r.URL.Path ||
+				p.Title != http.StatusText(tt.status) || p.Detail != text.text || p.RequestID == "" {
+				t.Errorf("problem = %+v, want status %d, code %s, instance %s and detail %q", p, tt.status, tt.code, r.URL.Path, text.text)
+			}
+		})
+	}
+}
+
+// Handlers send errors only through writeError, so none is stuck in one
+// format: no http.Error, and no WriteHeader with an error status, outside
+// errors.go
+func TestErrorsGoThroughWriteError(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "errors.go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "http" && sel.Sel.Name == "Error" {
+				t.Errorf("%s: http.Error bypasses writeError", fset.Position(call.Pos()))
+			}
+			if sel.Sel.Name == "WriteHeader" && len(call.Args) == 1 && errorStatus(call.Args[0]) {
+				t.Errorf("%s: error status written without writeError", fset.Position(call.Pos()))
+			}
+			return true
+		})
+	}
+}
+
+// Whether expr is a 4xx or 5xx status constant
+func errorStatus(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		status, _ := strconv.Atoi(e.Value)
+		return status >= 400
+	case *ast.SelectorExpr:
+		pkg, ok := e.X.(*ast.Ident)
+		if !ok || pkg.Name != "http" || !strings.HasPrefix(e.Sel.Name, "Status") {
+			return false
+		}
+		if e.Sel.Name == "StatusTeapot" { // "I'm a teapot"
+			return true
+		}
+		for status := 400; status < 600; status++ {
+			if text := http.StatusText(status); text != "" && "Status"+strings.NewReplacer(" ", "", "-", "", "'", "").Replace(text) == e.Sel.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
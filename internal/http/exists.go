@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The most IDs one POST /users:exists checks
+const existsMaxIDs = 500
+
+// Check a user exists (HEAD /users/{id} or /getUser?id=): 200 with its
+// ETag and Last-Modified, 404 otherwise, and never a body. The mux routes
+// HEAD to the GET pattern, so getUserHandler hands it here before doing
+// a GET's work; the server would only drop the body it encoded.
+// If-None-Match and If-Modified-Since give 304 as on GET.
+func (s *Server) headUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if userID == "" {
+		userID = r.URL.Query().Get("id")
+	}
+	if userID == "" || strings.Contains(userID, "/") {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "User ID required")
+		return
+	}
+	doc, err := getDocument(requestContext(r), s.users.Users().Doc(userID))
+	if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading user")
+		return
+	}
+	if notModified(w, r, documentETag(doc), doc.UpdateTime) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Check which of up to 500 user IDs exist (POST /users:exists with
+// {"ids": [...]}), answering {"exists": {id: bool}}. The documents are
+// read in one GetAll; soft-deleted users count as missing, as on GET.
+func (s *Server) usersExistHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "ids required")
+		return
+	}
+	if len(req.IDs) > existsMaxIDs {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "At most "+strconv.Itoa(existsMaxIDs)+" ids per request")
+		return
+	}
+
+	exists := make(map[string]bool, len(req.IDs))
+	var refs []*firestore.DocumentRef
+	for i, id := range req.IDs {
+		if id == "" || strings.Contains(id, "/") {
+			field := "ids[" + strconv.Itoa(i) + "]"
+			writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", field+" is not a user ID",
+				FieldError{Field: field, Message: "is not a user ID"})
+			return
+		}
+		if _, dup := exists[id]; !dup {
+			exists[id] = false
+			refs = append(refs, s.users.Users().Doc(id))
+		}
+	}
+	docs, err := s.client.GetAll(requestContext(r), refs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error checking users")
+		return
+	}
+	for _, doc := range docs {
+		exists[doc.Ref.ID] = doc.Exists() && !isSoftDeleted(doc)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"exists": exists})
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/sync/errgroup"
+)
+
+// Export pages hold EXPORT_PAGE_SIZE documents; EXPORT_LOOKAHEAD pages are
+// fetched ahead of the encoder, which bounds memory to lookahead+2 pages
+var (
+	exportPageSize  = config.Int("EXPORT_PAGE_SIZE", 1000)
+	exportLookahead = config.Int("EXPORT_LOOKAHEAD", 2)
+)
+
+// Fetch query in pages of pageSize on one goroutine while consume handles
+// earlier pages on another. Pages arrive in document ID order; an error on
+// either side cancels the other and is returned.
+func prefetchPages(ctx context.Context, query firestore.Query, pageSize, lookahead int, consume func([]*firestore.DocumentSnapshot) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	pages := make(chan []*firestore.DocumentSnapshot, lookahead)
+
+	g.Go(func() error {
+		defer close(pages)
+		ordered := query.OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+		page := ordered
+		for {
+			docs, err := page.Documents(ctx).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) == 0 {
+				return nil
+			}
+			select {
+			case pages <- docs:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if len(docs) < pageSize {
+				return nil
+			}
+			page = ordered.StartAfter(docs[len(docs)-1])
+		}
+	})
+	g.Go(func() error {
+		for docs := range pages {
+			if err := consume(docs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return g.Wait()
+}
+
+// Columns of the CSV and Sheets exports
+var exportColumns = []string{"id", "name", "email", "attributes"}
+
+// A user as exportColumns, attributes as JSON
+func exportRow(doc *firestore.DocumentSnapshot) []string {
+	user := userFromDoc(doc)
+	attrs := ""
+	if len(user.Attributes) > 0 {
+		raw, _ := json.Marshal(user.Attributes)
+		attrs = string(raw)
+	}
+	return []string{doc.Ref.ID, user.Name, user.Email, attrs}
+}
+
+// Stream every user as NDJSON or CSV (GET /admin/users:export?format=ndjson|csv, admin only).
+// Anonymized users are left out unless ?includeAnonymized=true.
+//
+// Failures after the first byte can't change the status, so they are
+// reported in the X-Export-Error trailer (and a final NDJSON error line).
+func (s *Server) exportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "format must be ndjson or csv")
+		return
+	}
+
+	skip := func(doc *firestore.DocumentSnapshot) bool {
+		return isSoftDeleted(doc) || isAnonymized(doc) && r.URL.Query().Get("includeAnonymized") != "true"
+	}
+
+	w.Header().Set("Trailer", "X-Export-Error")
+	var writeDocs func([]*firestore.DocumentSnapshot) error
+	var finish func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		writeDocs = func(docs []*firestore.DocumentSnapshot) error {
+			for _, doc := range docs {
+				if skip(doc) {
+					continue
+				}
+				if err := cw.Write(exportRow(doc)); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+		finish = func() error { cw.Flush(); return cw.Error() }
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writeDocs = func(docs []*firestore.DocumentSnapshot) error {
+			for _, doc := range docs {
+				if skip(doc) {
+					continue
+				}
+				user := userFromDoc(doc)
+				if err := encodeJSON(w, r, map[string]interface{}{"id": doc.Ref.ID, "user": user}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		finish = func() error { return nil }
+	}
+
+	flusher, _ := w.(http.Flusher)
+	err := prefetchPages(r.Context(), s.users.Users().Query, exportPageSize, exportLookahead, func(docs []*firestore.DocumentSnapshot) error {
+		if err := writeDocs(docs); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		log.Printf("⚠️ User export failed: %v", err)
+		if format == "ndjson" {
+			encodeJSON(w, r, map[string]interface{}{"error": "export failed"})
+		}
+		w.Header().Set("X-Export-Error", err.Error())
+	}
+}
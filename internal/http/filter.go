@@ -0,0 +1,210 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// userFilter selects users for bulk operations. Its clauses are ANDed:
+//
+//	[{"field": "email", "op": "endsWith", "value": "@example.com"},
+//	 {"field": "attributes.plan", "op": "==", "value": "free"}]
+//
+// Fields are name, email, createdAt, attributes.<key> or id (== and in
+// only). Ops are Firestore's: ==, !=, <, <=, >, >=, in, not-in,
+// array-contains, array-contains-any. endsWith is not one Firestore can
+// run, so it filters the other clauses' results in-process.
+type userFilter []filterClause
+
+type filterClause struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+var firestoreFilterOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "not-in": true, "array-contains": true, "array-contains-any": true,
+}
+
+// Firestore caps the values of in, not-in and array-contains-any
+const maxFilterValues = 30
+
+// Stored field path of a filterable user field
+func filterFieldPath(field string) (firestore.FieldPath, bool) {
+	if attr, ok := strings.CutPrefix(field, "attributes."); ok && attr != "" {
+		return firestore.FieldPath{"attributes", attr}, true
+	}
+	if field == "createdAt" {
+		return firestore.FieldPath{"createdAt"}, true
+	}
+	if stored, ok := firestoreFieldName(field); ok && field != "attributes" {
+		return firestore.FieldPath{stored}, true
+	}
+	return nil, false
+}
+
+// Check the filter and normalize its values (createdAt strings become
+// timestamps, id values strings)
+func (f userFilter) validate() error {
+	if len(f) == 0 {
+		return fmt.Errorf("filter needs at least one clause")
+	}
+	for i, c := range f {
+		if c.Field == "id" {
+			if c.Op != "==" && c.Op != "in" {
+				return fmt.Errorf("id supports only == and in")
+			}
+			continue
+		}
+		if _, ok := filterFieldPath(c.Field); !ok {
+			return fmt.Errorf("unknown filter field %q", c.Field)
+		}
+		switch {
+		case c.Op == "endsWith":
+			if _, ok := c.Value.(string); !ok {
+				return fmt.Errorf("endsWith on %s needs a string value", c.Field)
+			}
+		case !firestoreFilterOps[c.Op]:
+			return fmt.Errorf("unknown filter op %q", c.Op)
+		case c.Op == "in" || c.Op == "not-in" || c.Op == "array-contains-any":
+			values, ok := c.Value.([]interface{})
+			if !ok || len(values) == 0 || len(values) > maxFilterValues {
+				return fmt.Errorf("%s on %s needs a list of 1 to %d values", c.Op, c.Field, maxFilterValues)
+			}
+		}
+		if s, ok := c.Value.(string); ok && c.Field == "createdAt" {
+			t, err := parseTimestamp(s)
+			if err != nil {
+				return fmt.Errorf("createdAt: %v", err)
+			}
+			f[i].Value = t
+		}
+	}
+	return nil
+}
+
+// The document IDs an id clause names, nil without one
+func (f userFilter) ids() ([]string, error) {
+	for _, c := range f {
+		if c.Field != "id" {
+			continue
+		}
+		values := []interface{}{c.Value}
+		if c.Op == "in" {
+			list, ok := c.Value.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("id in needs a list of IDs")
+			}
+			values = list
+		}
+		ids := make([]string, 0, len(values))
+		for _, v := range values {
+			id, ok := v.(string)
+			if !ok || id == "" || strings.Contains(id, "/") {
+				return nil, fmt.Errorf("invalid id %v", v)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+	return nil, nil
+}
+
+// Whether Firestore can evaluate the whole filter (no in-process clause)
+func (f userFilter) native() bool {
+	for _, c := range f {
+		if c.Op == "endsWith" {
+			return false
+		}
+	}
+	return true
+}
+
+// The queries whose results, passed through matches, are the filter's
+// users. An id list is split into chunks Firestore's "in" accepts.
+func (f userFilter) queries(users *firestore.CollectionRef) ([]firestore.Query, error) {
+	base := users.Query
+	for _, c := range f {
+		if c.Field == "id" || c.Op == "endsWith" {
+			continue
+		}
+		path, _ := filterFieldPath(c.Field)
+		base = base.WherePath(path, c.Op, c.Value)
+	}
+	ids, err := f.ids()
+	if err != nil || ids == nil {
+		return []firestore.Query{base}, err
+	}
+	var queries []firestore.Query
+	for start := 0; start < len(ids); start += maxFilterValues {
+		var refs []*firestore.DocumentRef
+		for _, id := range ids[start:min(start+maxFilterValues, len(ids))] {
+			refs = append(refs, users.Doc(id))
+		}
+		queries = append(queries, base.Where(firestore.DocumentID, "in", refs))
+	}
+	return queries, nil
+}
+
+// Apply the in-process clauses to a query result; soft-deleted users never match
+func (f userFilter) matches(doc *firestore.DocumentSnapshot) bool {
+	if isSoftDeleted(doc) {
+		return false
+	}
+	for _, c := range f {
+		if c.Op != "endsWith" {
+			continue
+		}
+		path, _ := filterFieldPath(c.Field)
+		v, err := doc.DataAtPath(path)
+		s, ok := v.(string)
+		if err != nil || !ok || !strings.HasSuffix(strings.ToLower(s), strings.ToLower(c.Value.(string))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Count matching users, stopping once limit is passed. A filter Firestore
+// can run whole is counted with an aggregation; soft-deleted users are
+// included in that count.
+func (s *Server) countFilterMatches(ctx context.Context, f userFilter, limit int) (int, error) {
+	queries, err := f.queries(s.users.Users())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, q := range queries {
+		if f.native() {
+			res, err := q.NewAggregationQuery().WithCount("all").Get(ctx)
+			if err != nil {
+				return 0, err
+			}
+			if v, ok := res["all"].(interface{ GetIntegerValue() int64 }); ok {
+				n += int(v.GetIntegerValue())
+			}
+			continue
+		}
+		iter := trackIterator("filterCount", q.Documents(ctx))
+		for n <= limit {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return 0, err
+			}
+			if f.matches(doc) {
+				n++
+			}
+		}
+		iter.Stop()
+	}
+	return n, nil
+}
@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Feature flags switch subsystems on and off without a redeploy. Each
+// flag has a default in code. FEATURE_<NAME> (FEATURE_LIST_CACHE for
+// listCache) overrides it at startup, and a boolean field of the same
+// name in the config/flags document overrides both at runtime. The
+// document is watched, and each change is applied as a whole and logged.
+// Fields that aren't booleans or don't name a flag are ignored and
+// reported by GET /admin/flags. When the document can't be read, the last
+// values read stay in effect. FLAGS_WATCH=false skips the document.
+//
+// Flags only gate what is configured: listCache does nothing without
+// LIST_CACHE_TTL, and chaos can't inject faults unless CHAOS_ENABLED is
+// set.
+type flagName string
+
+const (
+	flagEvents            flagName = "events"
+	flagListCache         flagName = "listCache"
+	flagSearchIndexing    flagName = "searchIndexing"
+	flagChaos             flagName = "chaos"
+	flagAccessLog         flagName = "accessLog"
+	flagAccessLogSampling flagName = "accessLogSampling"
+	flagSIEMForwarding    flagName = "siemForwarding"
+)
+
+type flagDefinition struct {
+	name        flagName
+	def         bool
+	description string
+}
+
+var flagDefinitions = []flagDefinition{
+	{flagEvents, true, "Deliver outbox events to OUTBOX_SINKS; while off, events wait in the outbox"},
+	{flagListCache, true, "Serve /listUsers from the list cache (LIST_CACHE_TTL)"},
+	{flagSearchIndexing, true, "Send user changes to SEARCH_INDEXER; changes made while off aren't indexed"},
+	{flagChaos, true, "Inject faults (CHAOS_ENABLED deployments only)"},
+	{flagAccessLog, false, "Write an access log entry per request"},
+	{flagAccessLogSampling, true, "Log fast successful requests at ACCESS_LOG_SAMPLE_RATE; while off, every request is logged"},
+	{flagSIEMForwarding, true, "Forward audit entries to SIEM_FORWARD_URL; while off, the forwarder pauses at its watermark"},
+}
+
+var (
+	flagsWatch = config.Bool("FLAGS_WATCH", true)
+	flagsDoc   = "config/flags"
+	flags      = newFlagSet()
+)
+
+// Flag sources, in increasing precedence
+const (
+	flagSourceDefault = "default"
+	flagSourceEnv     = "env"
+	flagSourceRuntime = "runtime"
+)
+
+// flagSet holds the effective flags. Readers load an immutable snapshot,
+// so an update is never seen half applied.
+type flagSet struct {
+	current  atomic.Pointer[flagSnapshot]
+	startup  map[flagName]flagValue // defaults with env overrides
+	mu       sync.Mutex             // serializes updates
+	watching atomic.Bool
+}
+
+type flagSnapshot struct {
+	values    map[flagName]flagValue
+	updatedAt time.Time // of the config/flags document; zero when it doesn't exist
+	problems  []string  // fields of the document that were ignored
+}
+
+type flagValue struct {
+	value  bool
+	source string
+}
+
+func newFlagSet() *flagSet {
+	s := &flagSet{startup: map[flagName]flagValue{}}
+	for _, def := range flagDefinitions {
+		v := flagValue{value: def.def, source: flagSourceDefault}
+		if b, err := strconv.ParseBool(os.Getenv(flagEnv(def.name))); err == nil {
+			v = flagValue{value: b, source: flagSourceEnv}
+		}
+		s.startup[def.name] = v
+	}
+	s.current.Store(&flagSnapshot{values: s.startup})
+	return s
+}
+
+// FEATURE_ and the flag's name in upper snake case
+func flagEnv(name flagName) string {
+	var b strings.Builder
+	b.WriteString("FEATURE_")
+	for i, c := range string(name) {
+		if c >= 'A' && c <= 'Z' && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(c)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// Whether flag is on
+func (s *flagSet) enabled(name flagName) bool {
+	return s.current.Load().values[name].value
+}
+
+// Start watching config/flags
+func (s *Server) initFlags(bg *background) {
+	if !flagsWatch {
+		return
+	}
+	bg.Go(func(ctx context.Context) {
+		ref := s.client.Doc(flagsDoc)
+		keepListening(ctx, "Feature flag listener failed, keeping the flags last read", &flags.watching, func(ctx context.Context) (bool, error) {
+			return flags.watchOnce(ctx, ref)
+		})
+	})
+}
+
+// Apply ref (config/flags) until the stream fails, reporting whether it
+// ever delivered
+func (s *flagSet) watchOnce(ctx context.Context, ref *firestore.DocumentRef) (bool, error) {
+	snapshots := ref.Snapshots(ctx)
+	defer snapshots.Stop()
+	delivered := false
+	for {
+		doc, err := snapshots.Next()
+		if err != nil {
+			return delivered, err
+		}
+		if !delivered {
+			delivered = true
+			s.watching.Store(true)
+		}
+		if doc.Exists() {
+			s.apply(doc.Data(), doc.UpdateTime)
+		} else {
+			s.apply(nil, time.Time{})
+		}
+	}
+}
+
+// Replace the runtime overrides with those in data and log what changed
+func (s *flagSet) apply(data map[string]interface{}, updatedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := &flagSnapshot{values: make(map[flagName]flagValue, len(s.startup)), updatedAt: updatedAt}
+	for name, v := range s.startup {
+		next.values[name] = v
+	}
+	for field, raw := range data {
+		if _, known := s.startup[flagName(field)]; !known {
+			next.problems = append(next.problems, fmt.Sprintf("%s: unknown flag", field))
+			continue
+		}
+		value, ok := raw.(bool)
+		if !ok {
+			next.problems = append(next.problems, fmt.Sprintf("%s: %T is not a boolean", field, raw))
+			continue
+		}
+		next.values[flagName(field)] = flagValue{value: value, source: flagSourceRuntime}
+	}
+	sort.Strings(next.problems)
+
+	prev := s.current.Swap(next)
+	for _, def := range flagDefinitions {
+		before, after := prev.values[def.name], next.values[def.name]
+		if before.value != after.value {
+			log.Printf("🚩 Feature flag %s turned %s (%s)", def.name, onOff(after.value), after.source)
+		}
+	}
+	if len(next.problems) > 0 && strings.Join(next.problems, "\n") != strings.Join(prev.problems, "\n") {
+		log.Printf("⚠️ Ignoring fields of %s: %s", flagsDoc, strings.Join(next.problems, "; "))
+	}
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+// Effective flags with their sources (GET /admin/flags)
+func listFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	snap := flags.current.Load()
+	list := []map[string]interface{}{}
+	for _, def := range flagDefinitions {
+		v := snap.values[def.name]
+		list = append(list, map[string]interface{}{
+			"name":        def.name,
+			"description": def.description,
+			"enabled":     v.value,
+			"source":      v.source,
+			"default":     def.def,
+			"env":         flagEnv(def.name),
+		})
+	}
+	problems := snap.problems
+	if problems == nil {
+		problems = []string{}
+	}
+	runtime := map[string]interface{}{"document": flagsDoc, "watching": flags.watching.Load(), "problems": problems}
+	if !snap.updatedAt.IsZero() {
+		runtime["updatedAt"] = snap.updatedAt
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"flags": list, "runtime": runtime})
+}
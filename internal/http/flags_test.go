@@ -0,0 +1,86 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A document field beats FEATURE_*, which beats the default in code, and
+// removing the field falls back to the env value
+func TestFlagPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string // FEATURE_ACCESS_LOG, unset when ""
+		doc        map[string]interface{}
+		want       bool
+		wantSource string
+	}{
+		{name: "default", want: false, wantSource: flagSourceDefault},
+		{name: "env over default", env: "true", want: true, wantSource: flagSourceEnv},
+		{name: "unparsable env keeps default", env: "maybe", want: false, wantSource: flagSourceDefault},
+		{name: "document over default", doc: map[string]interface{}{"accessLog": true}, want: true, wantSource: flagSourceRuntime},
+		{name: "document over env", env: "true", doc: map[string]interface{}{"accessLog": false}, want: false, wantSource: flagSourceRuntime},
+		{name: "document without the flag keeps env", env: "true", doc: map[string]interface{}{"events": false}, want: true, wantSource: flagSourceEnv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("FEATURE_ACCESS_LOG", tt.env)
+			}
+			s := newFlagSet()
+			if tt.doc != nil {
+				s.apply(tt.doc, time.Now())
+			}
+			if got := s.current.Load().values[flagAccessLog]; got.value != tt.want || got.source != tt.wantSource {
+				t.Errorf("accessLog = %v (%s), want %v (%s)", got.value, got.source, tt.want, tt.wantSource)
+			}
+		})
+	}
+
+	t.Run("document removed", func(t *testing.T) {
+		t.Setenv("FEATURE_ACCESS_LOG", "true")
+		s := newFlagSet()
+		s.apply(map[string]interface{}{"accessLog": false}, time.Now())
+		s.apply(nil, time.Time{})
+		if got := s.current.Load().values[flagAccessLog]; !got.value || got.source != flagSourceEnv {
+			t.Errorf("accessLog = %v (%s), want true (env)", got.value, got.source)
+		}
+	})
+}
+
+// Fields that aren't booleans or don't name a flag are reported and
+// ignored, and the rest of the document still applies
+func TestFlagMalformedDocument(t *testing.T) {
+	s := newFlagSet()
+	s.apply(map[string]interface{}{"events": false, "listCache": false}, time.Now())
+	s.apply(map[string]interface{}{
+		"events":      "false",
+		"listCache":   int64(0),
+		"chaos":       false,
+		"noSuchFlag":  true,
+		"searchIndex": nil,
+	}, time.Now())
+
+	snap := s.current.Load()
+	want := []string{
+		"events: string is not a boolean",
+		"listCache: int64 is not a boolean",
+		"noSuchFlag: unknown flag",
+		"searchIndex: unknown flag",
+	}
+	if !reflect.DeepEqual(snap.problems, want) {
+		t.Errorf("problems = %q, want %q", snap.problems, want)
+	}
+	// A malformed field doesn't keep its earlier runtime value: the whole
+	// document replaces the last, so it falls back to the startup value
+	for name, want := range map[flagName]flagValue{
+		flagEvents:    {true, flagSourceDefault},
+		flagListCache: {true, flagSourceDefault},
+		flagChaos:     {false, flagSourceRuntime},
+	} {
+		if got := snap.values[name]; got != want {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+)
+
+// Inputs past this are skipped: MAX_BODY_BYTES refuses them well before
+// a handler would parse them
+const maxFuzzInput = 64 << 10
+
+// A JSON object nested depth levels deep under attributes
+func deepAttributes(depth int) string {
+	return `{"name": "Deep", "attributes": ` + strings.Repeat(`{"a": `, depth) + `1` + strings.Repeat(`}`, depth) + `}`
+}
+
+func FuzzAddUserBody(f *testing.F) {
+	for _, seed := range []string{
+		`{"name": "Ada Lovelace", "email": "ada@example.com", "plan": "pro"}`,
+		`{"name": "Ada", "email": "ada@example.com", "referredBy": "abc", "attributes": {"tags": ["a", "b"]}}`,
+		`{"first_name": "Ada", "email_address": "ada@example.com"}`,
+		`{"name": "Ada", "attributes": {"n": 1e308}}`,
+		`{"name": "Ada", "attributes": {"n": 1e309}}`,
+		`{"name": "Ada", "attributes": {"n": 123456789012345678901234567890}}`,
+		`{"name": "Ada", "plan": "PRO"}`,
+		`{"name": 5}`,
+		`{"name": "Ada", "attributes": {}}`,
+		deepAttributes(100),
+		deepAttributes(5000),
+		`{"name": "Ada"`,
+		`[]`,
+		`null`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		if len(body) > maxFuzzInput {
+			return
+		}
+		var user model.User
+		if _, err := decodeNewUserBody(jsonRequest(http.MethodPost, "/addUser", body), &user, false); err != nil {
+			return
+		}
+		if normalizeUserPlan(&user) != nil {
+			return
+		}
+		// The accepted user, sent back as it would be returned, decodes to the same user
+		raw, err := json.Marshal(user)
+		if err != nil {
+			t.Fatalf("encoding %+v: %v", user, err)
+		}
+		var again model.User
+		if _, err := decodeNewUserBody(jsonRequest(http.MethodPost, "/addUser", string(raw)), &again, false); err != nil {
+			t.Fatalf("decoding %s again: %v", raw, err)
+		}
+		if fe := normalizeUserPlan(&again); fe != nil {
+			t.Fatalf("plan of %s: %v", raw, fe)
+		}
+		if raw2, _ := json.Marshal(again); !bytes.Equal(raw, raw2) {
+			t.Fatalf("round trip changed the user:\n%s\n%s", raw, raw2)
+		}
+	})
+}
+
+func FuzzDocID(f *testing.F) {
+	for _, seed := range []string{
+		"abc", "fixture-alice", "a/b", "abc/history/1", "", ".", "..", "...",
+		"__id__", "__", "___", "a%2Fb", "abc:clone", "ünïcødé", "\xff", strings.Repeat("x", 1501),
+	} {
+		f.Add(seed)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.PathValue("id")) })
+	mux.HandleFunc("/getUser", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.URL.Query().Get("id")) })
+	h := documentIDMiddleware(mux)
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	f.Fuzz(func(t *testing.T, id string) {
+		if len(id) > 2*1500 {
+			return
+		}
+		valid := store.ValidDocID(id)
+		if valid && (strings.Contains(id, "/") || !utf8.ValidString(id) || len(id) > 1500) {
+			t.Fatalf("store.ValidDocID(%q) = true", id)
+		}
+
+		// A valid ID reaches the handler unchanged, in the path or the query
+		rec := serve("/users/" + url.PathEscape(id))
+		switch {
+		case valid && (rec.Code != http.StatusOK || rec.Body.String() != id):
+			t.Fatalf("/users/{%q}: %d %q", id, rec.Code, rec.Body)
+		case strings.Contains(id, "/") && rec.Code != http.StatusBadRequest:
+			t.Fatalf("/users/{%q}: %d, want 400", id, rec.Code)
+		}
+		rec = serve("/getUser?id=" + url.QueryEscape(id))
+		switch {
+		case (valid || id == "") && (rec.Code != http.StatusOK || rec.Body.String() != id):
+			t.Fatalf("/getUser?id=%q: %d %q", id, rec.Code, rec.Body)
+		case !valid && id != "" && rec.Code != http.StatusBadRequest:
+			t.Fatalf("/getUser?id=%q: %d, want 400", id, rec.Code)
+		}
+	})
+}
+
+func FuzzJSONPatch(f *testing.F) {
+	for _, seed := range []string{
+		`[{"op": "replace", "path": "/name", "value": "Ada King"}]`,
+		`[{"op": "add", "path": "/attributes/tags/-", "value": "c"}]`,
+		`[{"op": "add", "path": "/attributes/tags/0", "value": "z"}, {"op": "remove", "path": "/attributes/tags/1"}]`,
+		`[{"op": "test", "path": "/attributes/address/city", "value": "London"}]`,
+		`[{"op": "add", "path": "/attributes/a~1b~0c", "value": 1}]`,
+		`[{"op": "remove", "path": "/attributes/tags/99"}]`,
+		`[{"op": "add", "path": "/attributes/tags/-1", "value": 1}]`,
+		`[{"op": "add", "path": "/attributes/n", "value": 1e400}]`,
+		`[{"op": "replace", "path": "/", "value": 1}]`,
+		`[{"op": "replace", "path": "", "value": 1}]`,
+		`[{"op": "add", "path": "/attributes/x/y/z", "value": 1}]`,
+		`[{"op": "move", "from": "/name", "path": "/email"}]`,
+		`[{"op": "add", "path": "/first_name", "value": "Ada"}]`,
+		`[{"op": "add", "path": "/attributes/~", "value": 1}]`,
+		`[]`,
+	} {
+		f.Add(seed)
+	}
+	current := model.User{
+		Name:  "Ada",
+		Email: "ada@example.com",
+		Attributes: map[string]interface{}{
+			"tags":    []interface{}{"a", "b"},
+			"address": map[string]interface{}{"city": "London"},
+		},
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		if len(body) > maxFuzzInput {
+			return
+		}
+		ops, err := parsePatch([]byte(body))
+		if err != nil {
+			return
+		}
+		// A valid patch, encoded again, is the same patch
+		raw, err := json.Marshal(ops)
+		if err != nil {
+			t.Fatalf("encoding %+v: %v", ops, err)
+		}
+		again, err := parsePatch(raw)
+		if err != nil {
+			t.Fatalf("parsing %s again: %v", raw, err)
+		}
+		if raw2, _ := json.Marshal(again); !bytes.Equal(raw, raw2) {
+			t.Fatalf("round trip changed the patch:\n%s\n%s", raw, raw2)
+		}
+		if patched, err := applyUserPatch(current, ops); err == nil {
+			if _, err := json.Marshal(patched); err != nil {
+				t.Fatalf("patched user %+v doesn't encode: %v", patched, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Synthetic users for load testing (POST /admin/generateUsers). Off unless
+// GENERATE_USERS_ENABLED=true, and against a protected production project
+// only when confirmed (see environment.go). Each user is derived from (seed, index)
+// alone, so the same seed always produces the same documents and a job
+// taken over after a crash resumes without replaying.
+var (
+	generateUsersEnabled = config.Bool("GENERATE_USERS_ENABLED", false)
+	generateUsersMax     = config.Int("GENERATE_USERS_MAX", 100000)
+	generateUsersDomains = strings.Split(config.String("GENERATE_USERS_DOMAINS", "example.com,example.org,example.net"), ",")
+)
+
+// Users written per BulkWriter round, and between progress checkpoints
+const generateBatchSize = 500
+
+var (
+	fakeTags      = []string{"beta", "vip", "churn-risk", "newsletter", "trial", "enterprise", "mobile", "support"}
+	fakePlans     = []string{"free", "free", "free", "pro", "pro", "team"}
+	fakeCountries = []string{"US", "GB", "DE", "FR", "ES", "IN", "BR", "JP", "NG", "CA"}
+)
+
+// Start a generate_users job (POST /admin/generateUsers)
+//
+// Body: {"count": 10000, "seed": 42, "until": "2026-01-01T00:00:00Z"}. createdAt
+// values spread over the year before until (default: today, 00:00 UTC);
+// pass it explicitly to reproduce a run from another day.
+func (s *Server) generateUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !generateUsersEnabled {
+		writeError(w, r, http.StatusForbidden, "generator_disabled", "User generation is disabled (GENERATE_USERS_ENABLED)")
+		return
+	}
+	if !confirmProduction(w, r, "generate users") {
+		return
+	}
+
+	var req struct {
+		Count int        `json:"count"`
+		Seed  int64      `json:"seed"`
+		Until *Timestamp `json:"until"`
+	}
+	body, err := readJSONBody(r)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	var tsErr *timestampError
+	if errors.As(err, &tsErr) {
+		invalidTimestamp(w, r, "until")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if req.Count <= 0 || req.Count > generateUsersMax {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", fmt.Sprintf("count must be between 1 and %d", generateUsersMax))
+		return
+	}
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	if req.Until != nil {
+		until = req.Until.UTC()
+	}
+
+	id, err := s.startJob(requestContext(r), "generate_users", map[string]interface{}{
+		"count":  req.Count,
+		"seed":   req.Seed,
+		"until":  until,
+		"dryRun": dryRunRequested(r),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting user generation")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// The checkpoint is the number of users written so far
+func (s *Server) runGenerateUsersJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	count := int(intParam(run.job.Params, "count"))
+	seed := intParam(run.job.Params, "seed")
+	until, _ := run.job.Params["until"].(time.Time)
+	start, _ := strconv.Atoi(run.checkpoint())
+
+	for i := start; i < count; i += generateBatchSize {
+		if err := ctx.Err(); err != nil {
+			return map[string]interface{}{"processed": i}, err
+		}
+		end := min(i+generateBatchSize, count)
+		if !run.dryRun() {
+			if err := s.writeGeneratedUsers(ctx, seed, until, i, end); err != nil {
+				return map[string]interface{}{"processed": i}, err
+			}
+		}
+		run.progress(end, count, strconv.Itoa(end))
+	}
+	return map[string]interface{}{"dryRun": run.dryRun(), "processed": count, "seed": seed}, nil
+}
+
+// Write users [from, to) and their email index entries
+func (s *Server) writeGeneratedUsers(ctx context.Context, seed int64, until time.Time, from, to int) error {
+	bw := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for i := from; i < to; i++ {
+		id, data := generatedUser(seed, until, i)
+		job, err := bw.Set(s.users.Users().Doc(id), data)
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+		if job, err = bw.Set(s.users.EmailIndex(data["email"].(string)), map[string]interface{}{"userId": id}); err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The i-th user for seed, as its document ID and data
+func generatedUser(seed int64, until time.Time, i int) (string, map[string]interface{}) {
+	rng := rand.New(rand.NewPCG(uint64(seed), uint64(i)))
+	first := fakeFirstNames[rng.IntN(len(fakeFirstNames))]
+	last := fakeLastNames[rng.IntN(len(fakeLastNames))]
+	domain := strings.TrimSpace(generateUsersDomains[rng.IntN(len(generateUsersDomains))])
+
+	var tags []interface{}
+	for _, tag := range fakeTags {
+		if rng.IntN(4) == 0 {
+			tags = append(tags, tag)
+		}
+	}
+	attributes := map[string]interface{}{
+		"generated": true,
+		"plan":      fakePlans[rng.IntN(len(fakePlans))],
+		"country":   fakeCountries[rng.IntN(len(fakeCountries))],
+		"age":       18 + rng.IntN(60),
+		"tags":      tags,
+	}
+	if rng.IntN(3) == 0 {
+		attributes["newsletter"] = rng.IntN(2) == 0
+	}
+
+	data := userToData(model.User{
+		Name:       first + " " + last,
+		Email:      fmt.Sprintf("%s.%s.%d.%d@%s", strings.ToLower(first), strings.ToLower(last), seed, i+1, domain),
+		Plan:       []model.Plan{model.PlanFree, model.PlanFree, model.PlanFree, model.PlanPro, model.PlanPro, model.PlanEnterprise}[rng.IntN(6)],
+		Attributes: attributes,
+	})
+	data["createdAt"] = until.Add(-time.Duration(rng.Int64N(int64(365 * 24 * time.Hour))))
+	data["updatedAt"] = data["createdAt"]
+	return fmt.Sprintf("gen-%d-%06d", seed, i+1), data
+}
+
+// A numeric job parameter; Firestore hands integers back as int64
+func intParam(params map[string]interface{}, key string) int64 {
+	switch v := params[key].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGeneratedUserDeterministic(t *testing.T) {
+	until := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The same seed gives the same users, whatever order they are made in
+	first := map[string]map[string]interface{}{}
+	for i := 0; i < 1000; i++ {
+		id, data := generatedUser(42, until, i)
+		first[id] = data
+	}
+	if len(first) != 1000 {
+		t.Fatalf("1000 users have %d distinct IDs", len(first))
+	}
+	emails := map[interface{}]bool{}
+	for i := 999; i >= 0; i-- {
+		id, data := generatedUser(42, until, i)
+		if !reflect.DeepEqual(data, first[id]) {
+			t.Fatalf("user %d differs on the second run:\n%v\nwant\n%v", i, data, first[id])
+		}
+		if emails[data["email"]] {
+			t.Errorf("email %v generated twice", data["email"])
+		}
+		emails[data["email"]] = true
+		created := data["createdAt"].(time.Time)
+		if !created.Before(until) || created.Before(until.AddDate(-1, 0, 0)) {
+			t.Errorf("user %d created at %v, want within the year before %v", i, created, until)
+		}
+	}
+
+	// Pinned, so a change to the generator that would make an existing
+	// seed produce other users fails here
+	id, data := generatedUser(42, until, 0)
+	created := time.Date(2025, 7, 1, 21, 28, 53, 751701965, time.UTC)
+	if id != "gen-42-000001" || data["name"] != "Rosa Weber" || data["email"] != "rosa.weber.42.1@example.com" ||
+		data["plan"] != "pro" || !data["createdAt"].(time.Time).Equal(created) {
+		t.Errorf("seed 42 user 0 = %s %v, want gen-42-000001 Rosa Weber <rosa.weber.42.1@example.com>, pro, created %v", id, data, created)
+	}
+
+	// Another seed gives other users
+	same := 0
+	for i := 0; i < 100; i++ {
+		_, a := generatedUser(42, until, i)
+		_, b := generatedUser(43, until, i)
+		if a["name"] == b["name"] && a["createdAt"] == b["createdAt"] {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Errorf("%d of 100 users are the same for seeds 42 and 43", same)
+	}
+}
@@ -0,0 +1,431 @@
+package http
+
+import (
+	"mime"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"github.com/Altair-05/GoFirestoreApp/userpb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Add a user to Firestore (POST /addUser)
+func (s *Server) addUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	var user model.User
+	referredBy, err := decodeNewUserBody(r, &user, acceptFormPosts)
+	if err == errUnsupportedMediaType {
+		supported := []string{"application/json", protobufContentType}
+		if acceptFormPosts {
+			supported = append(supported, formContentType)
+		}
+		unsupportedMediaType(w, r, supported...)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if fe := normalizeUserPlan(&user); fe != nil {
+		writeInvalidEnum(w, r, *fe)
+		return
+	}
+
+	if !s.admitNewUser(w, r) {
+		return
+	}
+	ctx := requestContext(r)
+	dryRun := dryRunRequested(r)
+	id, err := s.createUser(ctx, user, referredBy, actorFromRequest(r, "anonymous"), dryRun) // Firestore stores it with auto ID
+	if err == store.ErrEmailTaken {
+		writeError(w, r, http.StatusConflict, "email_taken", "Email already in use")
+		return
+	}
+	if err == errUnknownReferrer || err == errSelfReferral {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", err.Error(), FieldError{Field: "referredBy", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error adding user")
+		return
+	}
+	if !dryRun {
+		userCap.created()
+		s.enqueueSearchUpsert(id, user)
+	}
+	user.AvatarURL = avatarURL(id, user)
+
+	writeUserResponse(w, r, "User added successfully", id, &user)
+}
+
+// Replace a user's fields (PUT /updateUser?id=docID), only those listed in
+// ?updateMask= (see updatemask.go), or apply an RFC 6902 patch (PATCH
+// with Content-Type: application/json-patch+json)
+func (s *Server) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "User ID required")
+		return
+	}
+
+	var mutate func(model.User) (model.User, error)
+	var masked []firestore.Update
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.URL.Query().Has("updateMask") {
+		if r.Method != http.MethodPut {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "updateMask only applies to PUT")
+			return
+		}
+		updates, ok := readUpdateMask(w, r)
+		if !ok {
+			return
+		}
+		masked = updates
+	} else if mediaType == "application/json-patch+json" {
+		body, err := readJSONBody(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+		ops, err := parsePatch(body)
+		if err != nil {
+			pe := err.(*patchError)
+			writeError(w, r, pe.status, pe.code, pe.msg)
+			return
+		}
+		mutate = func(current model.User) (model.User, error) { return applyUserPatch(current, ops) }
+	} else if r.Method == http.MethodPatch {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "PATCH requires Content-Type: application/json-patch+json")
+		return
+	} else {
+		var user model.User
+		if err := decodeUserBody(r, &user, false); err == errUnsupportedMediaType {
+			unsupportedMediaType(w, r, "application/json", protobufContentType, "application/json-patch+json")
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+		if user.Plan != "" {
+			if fe := normalizeUserPlan(&user); fe != nil {
+				writeInvalidEnum(w, r, *fe)
+				return
+			}
+		}
+		mutate = func(current model.User) (model.User, error) {
+			replacement := user
+			if replacement.Plan == "" {
+				replacement.Plan = current.Plan
+			} else if replacement.Plan != current.Plan {
+				return model.User{}, &patchError{status: http.StatusUnprocessableEntity, code: "invalid_field",
+					msg: "plan can only be changed with POST /users/{id}:changePlan"}
+			}
+			return replacement, nil
+		}
+	}
+
+	ctx := withIfMatch(requestContext(r), r)
+	dryRun := dryRunRequested(r)
+	var user model.User
+	var err error
+	if mutate != nil {
+		user, err = s.modifyUser(ctx, userID, actorFromRequest(r, "anonymous"), dryRun, mutate)
+	} else {
+		user, err = s.modifyUserFields(ctx, userID, actorFromRequest(r, "anonymous"), dryRun, masked)
+	}
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err == errPreconditionFailed {
+		writePreconditionFailed(w, r)
+		return
+	}
+	if err == store.ErrEmailTaken {
+		writeError(w, r, http.StatusConflict, "email_taken", "Email already in use")
+		return
+	}
+	if pe, ok := err.(*patchError); ok {
+		writeError(w, r, pe.status, pe.code, pe.msg)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error updating user")
+		return
+	}
+	if !dryRun {
+		s.enqueueSearchUpsert(userID, user)
+	}
+	user.AvatarURL = avatarURL(userID, user)
+
+	writeUserResponse(w, r, "User updated successfully", userID, &user)
+}
+
+// Delete a user (DELETE /deleteUser?id=docID)
+func (s *Server) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "User ID required")
+		return
+	}
+
+	ctx := withIfMatch(protectionContext(r), r)
+	dryRun := dryRunRequested(r)
+	err := s.deleteUser(ctx, userID, actorFromRequest(r, "anonymous"), dryRun)
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err == errPreconditionFailed {
+		writePreconditionFailed(w, r)
+		return
+	}
+	if err == errUserProtected {
+		writeUserProtected(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error deleting user")
+		return
+	}
+	if !dryRun {
+		s.enqueueSearchDelete(userID)
+		s.clearReferralsAfterDelete(userID)
+	}
+
+	writeUserResponse(w, r, "User deleted successfully", userID, nil)
+}
+
+// Get a user by Firestore document ID (GET /getUser?id=docID or GET /users/{id}).
+// ?includeArchived=true falls back to users_archive when no live user exists.
+func (s *Server) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		s.headUserHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		userID = r.URL.Query().Get("id")
+	}
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "User ID required")
+		return
+	}
+	if _, err := parseFieldSelection(r.URL.Query().Get("fields")); err != nil {
+		writeError(w, r, http.StatusBadRequest, "unknown_field", "Invalid fields: "+err.Error())
+		return
+	}
+	policy, ok := malformedPolicy(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onMalformed must be skip, include or fail")
+		return
+	}
+
+	ctx := requestContext(r)
+	doc, err := getDocument(ctx, s.users.Users().Doc(userID))
+	archived := false
+	if status.Code(err) == codes.NotFound && r.URL.Query().Get("includeArchived") == "true" {
+		doc, err = getDocument(ctx, s.archiveCollection().Doc(userID))
+		archived = true
+	}
+	if err != nil || isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if notModified(w, r, documentETag(doc), doc.UpdateTime) {
+		return
+	}
+
+	user, malformed := decodeUserDoc(doc)
+	if malformed != nil {
+		writeMalformedUser(w, r, policy, malformedEntry(doc, malformed), malformed)
+		return
+	}
+	user.AvatarURL = avatarURL(userID, user)
+	writeUserResponseWith(w, r, "", userID, &user, archived)
+}
+
+// Get a user by email through the email index (GET /getUserByEmail?email=...)
+func (s *Server) getUserByEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Email required")
+		return
+	}
+	if _, err := parseFieldSelection(r.URL.Query().Get("fields")); err != nil {
+		writeError(w, r, http.StatusBadRequest, "unknown_field", "Invalid fields: "+err.Error())
+		return
+	}
+	policy, ok := malformedPolicy(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onMalformed must be skip, include or fail")
+		return
+	}
+
+	ctx := requestContext(r)
+	doc, err := s.users.UserByEmail(ctx, email)
+	if err == store.ErrUserNotFound || err == nil && isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading user")
+		return
+	}
+	if notModified(w, r, documentETag(doc), doc.UpdateTime) {
+		return
+	}
+
+	user, malformed := decodeUserDoc(doc)
+	if malformed != nil {
+		writeMalformedUser(w, r, policy, malformedEntry(doc, malformed), malformed)
+		return
+	}
+	user.AvatarURL = avatarURL(doc.Ref.ID, user)
+	writeUserResponse(w, r, "", doc.Ref.ID, &user)
+}
+
+// List all users from Firestore (GET /listUsers?fields=name,email&onMalformed=skip|include|fail)
+//
+// ?createdAfter=&createdBefore= (RFC 3339) narrow it to a signup window,
+// newest first. ?consent=marketing:v2 keeps users whose current marketing
+// consent is an acceptance of v2; combined with a window it needs a
+// composite index per consent key. ?plan=pro keeps users on that plan.
+func (s *Server) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+	sel, err := parseFieldSelection(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "unknown_field", "Invalid fields: "+err.Error())
+		return
+	}
+	policy, ok := malformedPolicy(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onMalformed must be skip, include or fail")
+		return
+	}
+	rng, field, err := parseCreatedRange(r)
+	if err != nil {
+		invalidTimestamp(w, r, field)
+		return
+	}
+	consent, err := parseConsentFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	var plan model.Plan
+	if raw := r.URL.Query().Get("plan"); raw != "" {
+		if plan, ok = plans.parse(raw); !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "plan must be "+plans.allowed())
+			return
+		}
+	}
+
+	ctx := requestContext(r)
+	users := []UserListEntry{}
+	list := &userpb.ListUsersResponse{}
+
+	query := s.client.Collection("users").Query
+	if consent != nil {
+		query = consent.apply(query)
+	}
+	if plan != "" {
+		query = query.Where("plan", "==", string(plan))
+	}
+	if rng.active() {
+		query = rng.apply(query).OrderBy("createdAt", firestore.Desc)
+	}
+	if sel != nil {
+		query = query.SelectPaths(sel.firestorePaths()...) // only transfer the selected fields
+	}
+
+	var visible []*firestore.DocumentSnapshot
+	var lastModified time.Time
+	iter := trackIterator("listUsers", query.Documents(ctx))
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error listing users")
+			return
+		}
+		if isSoftDeleted(doc) {
+			continue
+		}
+		visible = append(visible, doc)
+		if doc.UpdateTime.After(lastModified) {
+			lastModified = doc.UpdateTime
+		}
+	}
+	if notModified(w, r, pageETag(visible), lastModified) {
+		return
+	}
+
+	for _, doc := range visible {
+		user, malformed := decodeUserDoc(doc)
+		if malformed != nil {
+			if policy == onMalformedFail {
+				writeError(w, r, http.StatusInternalServerError, "malformed_document", malformed.Error())
+				return
+			}
+			// Protobuf can't carry raw data, so include only applies to JSON
+			if policy == onMalformedInclude {
+				users = append(users, malformedEntry(doc, malformed))
+			}
+			continue
+		}
+		user.AvatarURL = avatarURL(doc.Ref.ID, user)
+		var projected interface{} = user
+		if sel != nil {
+			projected = sel.project(user)
+			user = sel.apply(user)
+		}
+		users = append(users, UserListEntry{ID: doc.Ref.ID, User: projected})
+		list.Users = append(list.Users, &userpb.UserResponse{Id: doc.Ref.ID, User: userToProto(user)})
+	}
+
+	if wantsProtobuf(r) {
+		writeProto(w, r, list)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, users)
+}
+
+// Home page handler (GET /)
+func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "home.html", s.homePage(r))
+}
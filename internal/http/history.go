@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HistoryEntry is one version in users/{id}/history/{version}.
+// Data is the document as of this version (nil after a delete) and
+// Previous the document before it (nil for the create).
+type HistoryEntry struct {
+	Version   int64                  `json:"version" firestore:"version"`
+	Op        string                 `json:"op" firestore:"op"`
+	Data      map[string]interface{} `json:"data" firestore:"data"`
+	Previous  map[string]interface{} `json:"previous" firestore:"previous"`
+	Actor     string                 `json:"actor" firestore:"actor"`
+	ChangedAt time.Time              `json:"changedAt" firestore:"changedAt"`
+	Undone    bool                   `json:"undone,omitempty" firestore:"undone,omitempty"`
+	UndoOf    int64                  `json:"undoOf,omitempty" firestore:"undoOf,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"` // e.g. a plan change's oldPlan, newPlan, effectiveAt
+}
+
+func historyCollection(userRef *firestore.DocumentRef) *firestore.CollectionRef {
+	return userRef.Collection("history")
+}
+
+func historyDoc(userRef *firestore.DocumentRef, version int64) *firestore.DocumentRef {
+	return historyCollection(userRef).Doc(strconv.FormatInt(version, 10))
+}
+
+// Most recent history entry, or nil when the user has none.
+// Must run before any write in the transaction.
+func latestHistoryTx(tx *firestore.Transaction, userRef *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	docs, err := tx.Documents(historyCollection(userRef).OrderBy("version", firestore.Desc).Limit(1)).GetAll()
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+// Stored on a user: the version of the history entry written together
+// with its last tracked change, which undo checks it is undoing. Writes
+// that keep no history and leave the user fields alone (referral counts,
+// consents, protection, enrichment status, migrations) don't touch it;
+// anonymization, which changes them without an entry, removes it.
+const historyVersionField = "historyVersion"
+
+// The version recordHistoryTx gives the entry after latest
+func nextHistoryVersion(latest *firestore.DocumentSnapshot) int64 {
+	if latest != nil {
+		if v, ok := latest.Data()["version"].(int64); ok {
+			return v + 1
+		}
+	}
+	return 1
+}
+
+// A copy of data, a user document, marked as written with the entry after latest
+func withHistoryVersion(data map[string]interface{}, latest *firestore.DocumentSnapshot) map[string]interface{} {
+	out := maps.Clone(data)
+	out[historyVersionField] = nextHistoryVersion(latest)
+	return out
+}
+
+// The update marking a user as written with the entry after latest
+func historyVersionUpdate(latest *firestore.DocumentSnapshot) firestore.Update {
+	return firestore.Update{Path: historyVersionField, Value: nextHistoryVersion(latest)}
+}
+
+// Append the next version after latest within the transaction
+func recordHistoryTx(tx *firestore.Transaction, userRef *firestore.DocumentRef, latest *firestore.DocumentSnapshot, entry HistoryEntry) error {
+	entry.Version = nextHistoryVersion(latest)
+	entry.ChangedAt = time.Now().UTC()
+	return tx.Create(historyDoc(userRef, entry.Version), entry)
+}
+
+// List a user's versions newest-first (GET /users/{id}/history?pageSize=&pageToken=)
+//
+// pageToken takes either nextPageToken or prevPageToken from a previous page.
+func (s *Server) listHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userRef := s.users.Users().Doc(r.PathValue("id"))
+	pageSize := pageSizeParam(r, 20, 100)
+
+	query := historyCollection(userRef).OrderBy("version", firestore.Desc).Limit(pageSize)
+	cur, err := pageCursor(r, nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+	if cur != nil {
+		var v int64
+		if len(cur.Values) == 1 {
+			v, err = strconv.ParseInt(cur.Values[0], 10, 64)
+		}
+		if len(cur.Values) != 1 || err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+			return
+		}
+		if cur.Backward {
+			query = query.EndBefore(v).LimitToLast(pageSize)
+		} else {
+			query = query.StartAfter(v)
+		}
+	}
+
+	ctx := requestContext(r)
+	entries := []HistoryEntry{}
+	// LimitToLast queries can't be streamed, so pages are read with GetAll
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing history")
+		return
+	}
+	for _, doc := range docs {
+		var entry HistoryEntry
+		doc.DataTo(&entry)
+		entries = append(entries, entry)
+	}
+
+	response := HistoryPage{History: entries}
+	var first, last cursor.Cursor
+	if len(entries) > 0 {
+		first.Values = []string{strconv.FormatInt(entries[0].Version, 10)}
+		last.Values = []string{strconv.FormatInt(entries[len(entries)-1].Version, 10)}
+	}
+	response.NextPageToken, response.PrevPageToken = pageTokens(r, cur, nil, first, last, len(entries), pageSize)
+	response.Links = pageLinks(r, response.NextPageToken, response.PrevPageToken)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+var errVersionNotFound = errors.New("version not found")
+
+// Load a document state: a history version number or "current" for the
+// live document. A bad version number or a missing document is
+// errVersionNotFound; other failures are returned as they are.
+func loadVersionState(ctx context.Context, userRef *firestore.DocumentRef, version string) (map[string]interface{}, error) {
+	if version == "current" {
+		doc, err := userRef.Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, errVersionNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return doc.Data(), nil
+	}
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return nil, errVersionNotFound
+	}
+	doc, err := historyDoc(userRef, v).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry HistoryEntry
+	if err := doc.DataTo(&entry); err != nil {
+		return nil, err
+	}
+	if entry.Data == nil {
+		return map[string]interface{}{}, nil // state after a delete
+	}
+	return entry.Data, nil
+}
+
+// Compare two versions of a user (GET /users/{id}/diff?from=<version>&to=<version|current>)
+func (s *Server) diffHandler(w http.ResponseWriter, r *http.Request) {
+	userRef := s.users.Users().Doc(r.PathValue("id"))
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = "current"
+	}
+	if from == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "from version required")
+		return
+	}
+
+	ctx := requestContext(r)
+	before, err := loadVersionState(ctx, userRef, from)
+	if err == errVersionNotFound {
+		writeError(w, r, http.StatusNotFound, "version_not_found", "from version not found: "+from)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading version")
+		return
+	}
+	after, err := loadVersionState(ctx, userRef, to)
+	if err == errVersionNotFound {
+		writeError(w, r, http.StatusNotFound, "version_not_found", "to version not found: "+to)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading version")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, VersionDiffResponse{ID: userRef.ID, From: from, To: to, Diff: diffDocuments(before, after)})
+}
@@ -0,0 +1,31 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Only a missing version or a bad version number is a 404; other
+// failures loading a version are a 500
+func TestDiffVersionErrors(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	diff := func(query string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/users/"+id+"/diff?"+query, nil)
+		r.SetPathValue("id", id)
+		return r
+	}
+
+	serveError(t, s.diffHandler, diff("from=abc"), http.StatusNotFound, "version_not_found")
+	serveError(t, s.diffHandler, diff("from=99"), http.StatusNotFound, "version_not_found")
+	serveError(t, s.diffHandler, diff("from=1&to=99"), http.StatusNotFound, "version_not_found")
+
+	// A version that exists but can't be decoded
+	if _, err := historyDoc(s.users.Users().Doc(id), 2).Set(ctx, map[string]interface{}{"version": "two"}); err != nil {
+		t.Fatal(err)
+	}
+	serveError(t, s.diffHandler, diff("from=2"), http.StatusInternalServerError, "internal")
+}
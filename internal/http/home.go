@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/iterator"
+)
+
+// The home page shows live state: the project, build and uptime, plus a
+// user count and the newest users read from Firestore at most every
+// HOME_CACHE_TTL. ?plain=true skips Firestore entirely.
+var homeCacheTTL = config.Duration("HOME_CACHE_TTL", 30*time.Second)
+
+const homeRecentUsers = 5
+
+// homeStats is the Firestore-backed part of the page; a nil field
+// renders as "unavailable"
+type homeStats struct {
+	UserCount *int64
+	Recent    []homeUser
+}
+
+type homeUser struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+type homeData struct {
+	Project     string
+	Database    string
+	Environment string
+	Revision    string
+	Uptime      time.Duration
+	Plain       bool
+	Stats       homeStats
+	Endpoints   []apiEndpoint
+	Console     bool
+}
+
+var (
+	homeMu      sync.Mutex
+	homeCached  homeStats
+	homeFetched time.Time
+)
+
+func (s *Server) homePage(r *http.Request) homeData {
+	project, database := s.firestoreDatabase()
+	data := homeData{
+		Project:     project,
+		Database:    database,
+		Environment: currentEnvironment.Mode,
+		Revision:    buildSetting("vcs.revision"),
+		Uptime:      time.Since(startedAt).Round(time.Second),
+		Plain:       r.URL.Query().Get("plain") == "true",
+		Endpoints:   apiEndpoints,
+		Console:     consoleEnabled,
+	}
+	if !data.Plain {
+		data.Stats = s.cachedHomeStats(requestContext(r))
+	}
+	return data
+}
+
+// Failed lookups are cached too, so an outage doesn't turn every page
+// view into a slow Firestore call
+func (s *Server) cachedHomeStats(ctx context.Context) homeStats {
+	homeMu.Lock()
+	defer homeMu.Unlock()
+	if time.Since(homeFetched) < homeCacheTTL {
+		return homeCached
+	}
+	homeCached, homeFetched = s.loadHomeStats(ctx), time.Now()
+	return homeCached
+}
+
+func (s *Server) loadHomeStats(ctx context.Context) homeStats {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var stats homeStats
+	if res, err := s.users.Users().NewAggregationQuery().WithCount("all").Get(ctx); err == nil {
+		if v, ok := res["all"].(interface{ GetIntegerValue() int64 }); ok {
+			n := v.GetIntegerValue()
+			stats.UserCount = &n
+		}
+	}
+
+	// Users created before createdAt was stamped don't appear here.
+	// Over-fetch so soft-deleted users can be skipped.
+	iter := trackIterator("home", s.users.Users().OrderBy("createdAt", firestore.Desc).Limit(homeRecentUsers*2).Documents(ctx))
+	defer iter.Stop()
+	recent := []homeUser{}
+	for len(recent) < homeRecentUsers {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return stats
+		}
+		if isSoftDeleted(doc) {
+			continue
+		}
+		createdAt, _ := doc.Data()["createdAt"].(time.Time)
+		recent = append(recent, homeUser{ID: doc.Ref.ID, Name: userFromDoc(doc).Name, CreatedAt: createdAt})
+	}
+	stats.Recent = recent
+	return stats
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/text/language"
+)
+
+// Error message catalogs, one i18n/<lang>.json per language, keyed by error code
+//
+//go:embed i18n/*.json
+var catalogFS embed.FS
+
+// I18N_DEFAULT_LANGUAGE is used when Accept-Language is absent or matches
+// no catalog
+var defaultLanguage = config.String("I18N_DEFAULT_LANGUAGE", "en")
+
+var (
+	catalogs     = loadCatalogs()
+	langMatcher  language.Matcher
+	catalogLangs []string
+)
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := catalogFS.ReadDir("i18n")
+	if err != nil {
+		log.Fatalf("❌ Failed to read message catalogs: %v", err)
+	}
+	loaded := map[string]map[string]string{}
+	var tags []language.Tag
+	for _, e := range entries {
+		lang := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		raw, err := catalogFS.ReadFile("i18n/" + e.Name())
+		if err != nil {
+			log.Fatalf("❌ Failed to read catalog %s: %v", e.Name(), err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			log.Fatalf("❌ Invalid catalog %s: %v", e.Name(), err)
+		}
+		loaded[lang] = messages
+		catalogLangs = append(catalogLangs, lang)
+		tags = append(tags, language.Make(lang))
+	}
+	langMatcher = language.NewMatcher(tags)
+	return loaded
+}
+
+// Catalog language for the request's Accept-Language
+func negotiateLanguage(r *http.Request) string {
+	header := r.Header.Get("Accept-Language")
+	if header == "" {
+		return defaultLanguage
+	}
+	prefs, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(prefs) == 0 {
+		return defaultLanguage
+	}
+	_, index, confidence := langMatcher.Match(prefs...)
+	if confidence == language.No {
+		return defaultLanguage
+	}
+	return catalogLangs[index]
+}
+
+// Localize an error detail by its code. English keeps the handler's own,
+// more specific message; other languages fall back to the English catalog
+// and then to that message, so a bare code is never shown to users.
+func localizedDetail(r *http.Request, code, detail string) (string, string) {
+	lang := negotiateLanguage(r)
+	if lang == "en" {
+		return detail, lang
+	}
+	if msg, ok := catalogs[lang][code]; ok && msg != "" {
+		return msg, lang
+	}
+	if msg, ok := catalogs["en"][code]; ok && msg != "" {
+		return msg, "en"
+	}
+	return detail, "en"
+}
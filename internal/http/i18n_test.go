@@ -0,0 +1,142 @@
+package http
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func requestWithLanguage(accept string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/getUser", nil)
+	if accept != "" {
+		r.Header.Set("Accept-Language", accept)
+	}
+	return r
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                         "en",
+		"es":                       "es",
+		"es-MX":                    "es",
+		"de-AT,de;q=0.9":           "de",
+		"fr-FR,de;q=0.5":           "de",
+		"en-GB,es;q=0.5":           "en",
+		"ja":                       "en",
+		"*":                        "en",
+		"not a language ;;; q=bad": "en",
+	} {
+		if got := negotiateLanguage(requestWithLanguage(accept)); got != want {
+			t.Errorf("Accept-Language %q: %s, want %s", accept, got, want)
+		}
+	}
+
+	saved := defaultLanguage
+	defaultLanguage = "de"
+	t.Cleanup(func() { defaultLanguage = saved })
+	if got := negotiateLanguage(requestWithLanguage("")); got != "de" {
+		t.Errorf("I18N_DEFAULT_LANGUAGE=de without Accept-Language: %s", got)
+	}
+}
+
+func TestLocalizedDetail(t *testing.T) {
+	tests := []struct {
+		accept, code, detail string
+		want, wantLang       string
+	}{
+		// English keeps the handler's more specific message
+		{"en", "user_not_found", "User abc not found", "User abc not found", "en"},
+		{"es", "user_not_found", "User not found", "Usuario no encontrado", "es"},
+		{"de", "user_not_found", "User not found", "Benutzer nicht gefunden", "de"},
+		// A code no catalog has: the handler's message, never the code
+		{"es", "no_such_code", "Something specific failed", "Something specific failed", "en"},
+		{"de", "", "Something specific failed", "Something specific failed", "en"},
+	}
+	for _, tt := range tests {
+		got, lang := localizedDetail(requestWithLanguage(tt.accept), tt.code, tt.detail)
+		if got != tt.want || lang != tt.wantLang {
+			t.Errorf("%s %q: %q (%s), want %q (%s)", tt.accept, tt.code, got, lang, tt.want, tt.wantLang)
+		}
+	}
+}
+
+func TestWriteErrorLocalizesDetailNotCode(t *testing.T) {
+	for _, accept := range []string{"es", "de", "en"} {
+		r := requestWithLanguage(accept)
+		r.Header.Set("Accept", "application/problem+json")
+		got := serveErrorFormat(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		}), r)
+		if got.code != "user_not_found" || got.problem.Code != "user_not_found" {
+			t.Errorf("%s: code %q / %q, want user_not_found in every language", accept, got.code, got.problem.Code)
+		}
+		if want := catalogs[accept]["user_not_found"]; got.problem.Detail != want {
+			t.Errorf("%s: detail %q, want %q", accept, got.problem.Detail, want)
+		}
+	}
+}
+
+// Every catalog has the same keys, with non-empty messages
+func TestCatalogsMatch(t *testing.T) {
+	for _, lang := range []string{"en", "es", "de"} {
+		if catalogs[lang] == nil {
+			t.Fatalf("no %s catalog", lang)
+		}
+	}
+	for lang, messages := range catalogs {
+		for code, msg := range messages {
+			if strings.TrimSpace(msg) == "" {
+				t.Errorf("%s: empty message for %s", lang, code)
+			}
+			if _, ok := catalogs["en"][code]; !ok {
+				t.Errorf("%s: %s isn't in the English catalog", lang, code)
+			}
+		}
+		for code := range catalogs["en"] {
+			if _, ok := messages[code]; !ok {
+				t.Errorf("%s: missing %s", lang, code)
+			}
+		}
+	}
+}
+
+// Every code a handler passes to writeError literally has a message
+func TestCatalogsCoverErrorCodes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 4 {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.Ident); !ok || fn.Name != "writeError" && fn.Name != "writeErrorWith" {
+				return true
+			}
+			lit, ok := call.Args[3].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			if code, _ := strconv.Unquote(lit.Value); catalogs["en"][code] == "" {
+				t.Errorf("%s: no message for %s", fset.Position(lit.Pos()), code)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Indexes the queries in this repo need beyond Firestore's automatic
+// single-field ones. `gofirestoreapp indexes export` turns them into a
+// firestore.indexes.json for `firebase deploy --only firestore:indexes`;
+// at startup (INDEX_CHECK) each query is probed and a missing index marks
+// the endpoints using it degraded on /healthz.
+var indexCheck = config.Bool("INDEX_CHECK", true)
+
+type indexField struct {
+	path  string
+	order string // ASCENDING or DESCENDING
+}
+
+// requiredIndex is one index and a query that needs it, run with Limit(1)
+// so a missing index shows up as FailedPrecondition with the link that
+// creates it
+type requiredIndex struct {
+	name       string
+	collection string // collection ID (the collection group for group queries)
+	group      bool   // collection group query
+	fields     []indexField
+	endpoints  []string // what degrades without it
+	query      func(s *Server) firestore.Query
+}
+
+var (
+	asc  = func(path string) indexField { return indexField{path, "ASCENDING"} }
+	desc = func(path string) indexField { return indexField{path, "DESCENDING"} }
+)
+
+var requiredIndexes = []requiredIndex{
+	{
+		name: "outbox due events", collection: "outbox",
+		fields:    []indexField{asc("state"), asc("nextAttemptAt")},
+		endpoints: []string{"outbox dispatcher"},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("outbox").Where("state", "==", outboxPending).
+				Where("nextAttemptAt", "<=", time.Now()).OrderBy("nextAttemptAt", firestore.Asc)
+		},
+	},
+	{
+		name: "runnable jobs", collection: "jobs",
+		fields:    []indexField{asc("state"), asc("leaseUntil")},
+		endpoints: []string{"job workers"},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("jobs").Where("state", "in", []string{jobQueued, jobRunning}).
+				Where("leaseUntil", "<=", time.Now())
+		},
+	},
+	{
+		name: "active jobs by type", collection: "jobs",
+		fields:    []indexField{asc("type"), asc("state")},
+		endpoints: []string{"POST /admin/migrations"},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("jobs").Where("type", "==", "migrations").
+				Where("state", "in", []string{jobQueued, jobRunning})
+		},
+	},
+	{
+		name: "jobs by state", collection: "jobs",
+		fields:    []indexField{asc("state"), desc("createdAt")},
+		endpoints: []string{"GET /admin/jobs?state="},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("jobs").Where("state", "==", jobFailed).OrderBy("createdAt", firestore.Desc)
+		},
+	},
+	{
+		name: "jobs by type", collection: "jobs",
+		fields:    []indexField{asc("type"), desc("createdAt")},
+		endpoints: []string{"GET /admin/jobs?type="},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("jobs").Where("type", "==", "reindex").OrderBy("createdAt", firestore.Desc)
+		},
+	},
+	{
+		name: "jobs by state and type", collection: "jobs",
+		fields:    []indexField{asc("state"), asc("type"), desc("createdAt")},
+		endpoints: []string{"GET /admin/jobs?state=&type="},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("jobs").Where("state", "==", jobFailed).Where("type", "==", "reindex").
+				OrderBy("createdAt", firestore.Desc)
+		},
+	},
+	{
+		name: "referrals by referrer", collection: "users",
+		fields:    []indexField{asc("referredBy"), desc("createdAt")},
+		endpoints: []string{"GET /users/{id}/referrals"},
+		query: func(s *Server) firestore.Query {
+			return s.users.Users().Where("referredBy", "==", "selftest").OrderBy("createdAt", firestore.Desc)
+		},
+	},
+	{
+		name: "audit entries by target", collection: "audit_logs",
+		fields:    []indexField{asc("targetId"), desc("at")},
+		endpoints: []string{"GET /users/{id}/timeline"},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("audit_logs").Where("targetId", "==", "selftest").OrderBy("at", firestore.Desc)
+		},
+	},
+	{
+		name: "recordings by principal", collection: "request_recordings",
+		fields:    []indexField{asc("principal"), desc("createdAt")},
+		endpoints: []string{"GET /admin/recordings"},
+		query: func(s *Server) firestore.Query {
+			return s.client.Collection("request_recordings").Where("principal", "==", "selftest").
+				OrderBy("createdAt", firestore.Desc)
+		},
+	},
+	{
+		// A single field, but collection group scope isn't automatic
+		name: "read notifications", collection: "notifications", group: true,
+		fields:    []indexField{asc("readAt")},
+		endpoints: []string{"notification pruner"},
+		query: func(s *Server) firestore.Query {
+			return s.client.CollectionGroup("notifications").Where("readAt", "<", time.Now().Add(-notificationRetention))
+		},
+	},
+}
+
+// The console link Firestore puts in a missing-index error
+var indexLinkPattern = regexp.MustCompile(`https://console\.firebase\.google\.com/\S+`)
+
+// missingIndex is a required index the last probe found missing
+type missingIndex struct {
+	Index     string   `json:"index"`
+	Endpoints []string `json:"endpoints"`
+	CreateURL string   `json:"createUrl,omitempty"`
+}
+
+var (
+	missingIndexesMu sync.Mutex
+	missingIndexes   []missingIndex
+)
+
+// Run an index's query. A missing index is reported, not returned as an error.
+func (s *Server) probeIndex(ctx context.Context, idx requiredIndex) (*missingIndex, error) {
+	_, err := idx.query(s).Limit(1).Documents(ctx).GetAll()
+	return classifyIndexProbe(idx, err)
+}
+
+// What the error of idx's probe query says about the index
+func classifyIndexProbe(idx requiredIndex, err error) (*missingIndex, error) {
+	if status.Code(err) == codes.FailedPrecondition {
+		return &missingIndex{Index: idx.name, Endpoints: idx.endpoints, CreateURL: indexLinkPattern.FindString(err.Error())}, nil
+	}
+	return nil, err
+}
+
+// Probe every required index in the background and report what's missing
+func (s *Server) startIndexCheck(bg *background) {
+	if !indexCheck {
+		return
+	}
+	bg.Go(func(ctx context.Context) {
+		ctx = withEndpoint(ctx, "index_check")
+		var missing []missingIndex
+		for _, idx := range requiredIndexes {
+			probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			m, err := s.probeIndex(probeCtx, idx)
+			cancel()
+			if err != nil {
+				log.Printf("⚠️ Could not check index %q: %v", idx.name, err)
+			}
+			if m != nil {
+				missing = append(missing, *m)
+			}
+		}
+		missingIndexesMu.Lock()
+		missingIndexes = missing
+		missingIndexesMu.Unlock()
+		if len(missing) == 0 {
+			fmt.Println("🗂️ All required Firestore indexes are present")
+			return
+		}
+		log.Printf("🚨 %d Firestore indexes are missing; these features fail until they are built:", len(missing))
+		for _, m := range missing {
+			log.Printf("🚨   %s (%s): %s", m.Index, strings.Join(m.Endpoints, ", "), m.CreateURL)
+		}
+		log.Printf("🚨 Or deploy them all: gofirestoreapp indexes export > firestore.indexes.json")
+	})
+}
+
+// Liveness plus degraded features (GET /healthz). Missing indexes and an
+// engaged write throttle don't make the server unhealthy, so the status
+// code stays 200.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	missingIndexesMu.Lock()
+	missing := append([]missingIndex{}, missingIndexes...)
+	missingIndexesMu.Unlock()
+	resp := map[string]interface{}{"status": "ok", "environment": currentEnvironment.Mode}
+	if len(missing) > 0 {
+		resp["status"] = "degraded"
+		resp["missingIndexes"] = missing
+	}
+	if throttle := writeThrottle.state(); throttle["engaged"].(bool) {
+		resp["status"] = "degraded"
+		resp["writeThrottle"] = throttle
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// gofirestoreapp indexes export [--out firestore.indexes.json]
+func indexesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprintln(os.Stderr, "usage: gofirestoreapp indexes export [--out file]")
+		return 2
+	}
+	fs := flag.NewFlagSet("indexes export", flag.ExitOnError)
+	out := fs.String("out", "", "write to this file instead of stdout")
+	fs.Parse(args[1:])
+
+	raw, err := json.MarshalIndent(indexDefinitions(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	raw = append(raw, '\n')
+	if *out == "" {
+		os.Stdout.Write(raw)
+		return 0
+	}
+	if err := os.WriteFile(*out, raw, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// The required indexes in firestore.indexes.json form. Collection group
+// single-field indexes are field overrides, which replace the defaults,
+// so those are listed again.
+func indexDefinitions() map[string]interface{} {
+	indexes := []map[string]interface{}{}
+	overrides := []map[string]interface{}{}
+	for _, idx := range requiredIndexes {
+		scope := "COLLECTION"
+		if idx.group {
+			scope = "COLLECTION_GROUP"
+		}
+		if len(idx.fields) == 1 {
+			overrides = append(overrides, map[string]interface{}{
+				"collectionGroup": idx.collection,
+				"fieldPath":       idx.fields[0].path,
+				"indexes": []map[string]string{
+					{"order": "ASCENDING", "queryScope": "COLLECTION"},
+					{"order": "DESCENDING", "queryScope": "COLLECTION"},
+					{"arrayConfig": "CONTAINS", "queryScope": "COLLECTION"},
+					{"order": idx.fields[0].order, "queryScope": scope},
+				},
+			})
+			continue
+		}
+		fields := []map[string]string{}
+		for _, f := range idx.fields {
+			fields = append(fields, map[string]string{"fieldPath": f.path, "order": f.order})
+		}
+		indexes = append(indexes, map[string]interface{}{
+			"collectionGroup": idx.collection,
+			"queryScope":      scope,
+			"fields":          fields,
+		})
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return indexes[i]["collectionGroup"].(string) < indexes[j]["collectionGroup"].(string)
+	})
+	return map[string]interface{}{"indexes": indexes, "fieldOverrides": overrides}
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyIndexProbe(t *testing.T) {
+	idx := requiredIndex{name: "jobs by state", endpoints: []string{"GET /admin/jobs?state="}}
+	const link = "https://console.firebase.google.com/v1/r/project/demo/firestore/indexes?create_composite=Ck9wcm9qZWN0cy9kZW1v"
+	tests := []struct {
+		name        string
+		err         error
+		wantMissing *missingIndex
+		wantErr     bool
+	}{
+		{name: "index present", err: nil},
+		{
+			name:        "missing, with the link",
+			err:         status.Error(codes.FailedPrecondition, "The query requires an index. You can create it here: "+link),
+			wantMissing: &missingIndex{Index: "jobs by state", Endpoints: []string{"GET /admin/jobs?state="}, CreateURL: link},
+		},
+		{
+			name:        "missing, link ends the sentence",
+			err:         status.Error(codes.FailedPrecondition, "The query requires an index, create it at "+link+" then retry"),
+			wantMissing: &missingIndex{Index: "jobs by state", Endpoints: []string{"GET /admin/jobs?state="}, CreateURL: link},
+		},
+		{
+			name:        "missing, no link",
+			err:         status.Error(codes.FailedPrecondition, "The query requires an index"),
+			wantMissing: &missingIndex{Index: "jobs by state", Endpoints: []string{"GET /admin/jobs?state="}},
+		},
+		{
+			name:        "missing, wrapped",
+			err:         fmt.Errorf("probe: %w", status.Error(codes.FailedPrecondition, "The query requires an index. "+link)),
+			wantMissing: &missingIndex{Index: "jobs by state", Endpoints: []string{"GET /admin/jobs?state="}, CreateURL: link},
+		},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), wantErr: true},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "missing permission"), wantErr: true},
+		{name: "timed out", err: context.DeadlineExceeded, wantErr: true},
+		{name: "not a status", err: errors.New("boom " + link), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing, err := classifyIndexProbe(idx, tt.err)
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %+v, want %+v", missing, tt.wantMissing)
+			}
+			if (err != nil) != tt.wantErr || tt.wantErr && err != tt.err {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Missing indexes degrade /healthz without failing it
+func TestHealthzMissingIndexes(t *testing.T) {
+	saved := missingIndexes
+	t.Cleanup(func() { missingIndexes = saved })
+
+	missingIndexes = nil
+	body := serveJSON(t, healthzHandler, httptest.NewRequest(http.MethodGet, "/healthz", nil), http.StatusOK)
+	if body["status"] != "ok" || body["missingIndexes"] != nil {
+		t.Errorf("healthz = %v, want ok", body)
+	}
+
+	missingIndexes = []missingIndex{{Index: "runnable jobs", Endpoints: []string{"job workers"}, CreateURL: "https://console.firebase.google.com/x"}}
+	body = serveJSON(t, healthzHandler, httptest.NewRequest(http.MethodGet, "/healthz", nil), http.StatusOK)
+	want := []interface{}{map[string]interface{}{"index": "runnable jobs", "endpoints": []interface{}{"job workers"}, "createUrl": "https://console.firebase.google.com/x"}}
+	if body["status"] != "degraded" || !reflect.DeepEqual(body["missingIndexes"], want) {
+		t.Errorf("healthz = %v, want degraded with %v", body, want)
+	}
+}
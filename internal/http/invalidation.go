@@ -0,0 +1,220 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// With CACHE_INVALIDATION=true, replicas tell each other when to drop
+// cached data. An eviction on one replica also bumps the seq of
+// invalidations/{cache} and records the evicted keys, and every replica
+// listens to the invalidations collection and evicts the same keys.
+//
+// A seq that follows the last one seen evicts just its keys. A gap
+// (updates coalesced by the listener, or missed during a disconnect)
+// clears the whole cache. A seq already seen is ignored, so replays
+// are harmless. The listener reconnects with backoff. While it is
+// down, caches fall back to their TTLs.
+var (
+	cacheInvalidation = config.Bool("CACHE_INVALIDATION", false)
+	invalidations     = newInvalidationBus()
+)
+
+// invalidationBus connects local caches to the invalidations collection
+type invalidationBus struct {
+	replica   string // skips our own publications, already applied locally
+	mu        sync.Mutex
+	seen      map[string]int64 // last seq applied, by cache
+	baselined bool             // the first snapshot set seen
+	handlers  map[string]func(keys []string)
+	connected atomic.Bool
+	received  atomic.Int64
+	published atomic.Int64
+}
+
+func newInvalidationBus() *invalidationBus {
+	return &invalidationBus{replica: newLeaseOwner(), seen: map[string]int64{}, handlers: map[string]func(keys []string){}}
+}
+
+// invalidationRecord is the document invalidations/{cache}
+type invalidationRecord struct {
+	Seq       int64     `firestore:"seq"`
+	Keys      []string  `firestore:"keys"` // keys evicted by seq; empty means everything
+	Replica   string    `firestore:"replica"`
+	UpdatedAt time.Time `firestore:"updatedAt"`
+}
+
+// Register the caches that take remote invalidations and start listening
+func (s *Server) initCacheInvalidation(bg *background) {
+	invalidations.handlers["users"] = func([]string) {
+		for collection := range listCaches {
+			invalidateListCache(collection)
+		}
+	}
+	invalidations.handlers["webhooks"] = func([]string) { webhookSubscriptions.invalidate() }
+	invalidations.handlers["recording_targets"] = func([]string) { recordingTargets.invalidate() }
+	invalidations.handlers["quotas"] = func(keys []string) {
+		if len(keys) == 0 {
+			s.quotas.clear()
+		}
+		for _, principal := range keys {
+			s.quotas.invalidate(principal)
+		}
+	}
+	if !cacheInvalidation {
+		return
+	}
+	bg.Go(func(ctx context.Context) {
+		keepListening(ctx, "Cache invalidation listener failed, caches fall back to their TTLs until it reconnects", &invalidations.connected, func(ctx context.Context) (bool, error) {
+			return invalidations.listenOnce(ctx, s.client)
+		})
+	})
+	fmt.Println("📡 Cross-replica cache invalidation enabled")
+}
+
+// Tell other replicas to evict keys, or everything, from cache. Runs in
+// the background; a failure leaves them to their TTLs.
+func (s *Server) publishInvalidation(cache string, keys ...string) {
+	if !cacheInvalidation {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := invalidations.publish(ctx, s.client, cache, keys); err != nil {
+			log.Printf("⚠️ Failed to publish %s cache invalidation: %v", cache, err)
+		}
+	}()
+}
+
+// Bump the seq of invalidations/{cache}, recording keys
+func (b *invalidationBus) publish(ctx context.Context, client *firestore.Client, cache string, keys []string) error {
+	ref := client.Collection("invalidations").Doc(cache)
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var record invalidationRecord
+		if doc, err := tx.Get(ref); err == nil {
+			doc.DataTo(&record)
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		return tx.Set(ref, invalidationRecord{Seq: record.Seq + 1, Keys: keys, Replica: b.replica, UpdatedAt: time.Now().UTC()})
+	})
+	if err == nil {
+		b.published.Add(1)
+	}
+	return err
+}
+
+// Run a snapshot listener until ctx ends, reconnecting with backoff.
+// listenOnce reports whether its stream delivered anything before
+// failing and sets connected once it does. The first failure after a
+// connection is logged as warning.
+func keepListening(ctx context.Context, warning string, connected *atomic.Bool, listenOnce func(context.Context) (bool, error)) {
+	failures := 0
+	for ctx.Err() == nil {
+		delivered, err := listenOnce(ctx)
+		connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if delivered {
+			failures = 0
+		}
+		failures++
+		if failures == 1 {
+			log.Printf("⚠️ %s: %v", warning, err)
+		}
+		select {
+		case <-time.After(outboxBackoff(min(failures, 6))): // at most 32s
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Listen until the stream fails, reporting whether it ever delivered
+func (b *invalidationBus) listenOnce(ctx context.Context, client *firestore.Client) (bool, error) {
+	snapshots := client.Collection("invalidations").Snapshots(ctx)
+	defer snapshots.Stop()
+	connected := false
+	for {
+		snap, err := snapshots.Next()
+		if err != nil {
+			return connected, err
+		}
+		if !connected {
+			connected = true
+			b.connected.Store(true)
+			log.Println("📡 Cache invalidation listener connected")
+		}
+		for _, change := range snap.Changes {
+			if change.Kind == firestore.DocumentRemoved {
+				continue
+			}
+			var record invalidationRecord
+			if err := change.Doc.DataTo(&record); err != nil {
+				continue
+			}
+			b.apply(change.Doc.Ref.ID, record)
+		}
+		b.mu.Lock()
+		b.baselined = true
+		b.mu.Unlock()
+	}
+}
+
+// Apply one record. The first snapshot after starting only sets the
+// baseline: the caches are empty then anyway.
+func (b *invalidationBus) apply(cache string, record invalidationRecord) {
+	b.mu.Lock()
+	last := b.seen[cache]
+	if record.Seq <= last {
+		b.mu.Unlock()
+		return
+	}
+	b.seen[cache] = record.Seq
+	handler, baselined := b.handlers[cache], b.baselined
+	b.mu.Unlock()
+	if handler == nil || !baselined {
+		return
+	}
+	switch {
+	case record.Seq != last+1:
+		handler(nil)
+	case record.Replica == b.replica:
+		return
+	default:
+		handler(record.Keys)
+	}
+	b.received.Add(1)
+}
+
+// Prometheus series for the invalidation listener, for metricsHandler
+func writeInvalidationMetrics(w io.Writer) {
+	if !cacheInvalidation {
+		return
+	}
+	connected := 0
+	if invalidations.connected.Load() {
+		connected = 1
+	}
+	fmt.Fprintln(w, "# HELP cache_invalidation_listener_connected Whether the cross-replica invalidation listener is connected.")
+	fmt.Fprintln(w, "# TYPE cache_invalidation_listener_connected gauge")
+	fmt.Fprintf(w, "cache_invalidation_listener_connected %d\n", connected)
+	fmt.Fprintln(w, "# HELP cache_invalidations_published_total Invalidations this replica sent to the others.")
+	fmt.Fprintln(w, "# TYPE cache_invalidations_published_total counter")
+	fmt.Fprintf(w, "cache_invalidations_published_total %d\n", invalidations.published.Load())
+	fmt.Fprintln(w, "# HELP cache_invalidations_received_total Invalidations applied from other replicas.")
+	fmt.Fprintln(w, "# TYPE cache_invalidations_received_total counter")
+	fmt.Fprintf(w, "cache_invalidations_received_total %d\n", invalidations.received.Load())
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A replica for the invalidation tests: a bus of its own whose users
+// cache records what it is told to evict
+type testReplica struct {
+	bus     *invalidationBus
+	evicted chan []string
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+func newTestReplica() *testReplica {
+	r := &testReplica{bus: newInvalidationBus(), evicted: make(chan []string, 10)}
+	r.bus.handlers["users"] = func(keys []string) { r.evicted <- keys }
+	return r
+}
+
+// Start listening, returning once the first snapshot set the baseline
+func (r *testReplica) listen(t *testing.T, s *Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stop, r.done = cancel, make(chan struct{})
+	go func() {
+		defer close(r.done)
+		keepListening(ctx, "test listener failed", &r.bus.connected, func(ctx context.Context) (bool, error) {
+			return r.bus.listenOnce(ctx, s.client)
+		})
+	}()
+	t.Cleanup(r.close)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		r.bus.mu.Lock()
+		baselined := r.bus.baselined
+		r.bus.mu.Unlock()
+		if baselined && r.bus.connected.Load() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener never connected")
+		}
+	}
+}
+
+func (r *testReplica) close() {
+	if r.stop != nil {
+		r.stop()
+		<-r.done
+		r.stop = nil
+	}
+}
+
+// The keys of the next eviction, failing if none comes
+func (r *testReplica) nextEviction(t *testing.T) []string {
+	t.Helper()
+	select {
+	case keys := <-r.evicted:
+		return keys
+	case <-time.After(5 * time.Second):
+		t.Fatal("no eviction")
+		return nil
+	}
+}
+
+// Nothing evicted for a while
+func (r *testReplica) noEviction(t *testing.T) {
+	t.Helper()
+	select {
+	case keys := <-r.evicted:
+		t.Fatalf("evicted %v, want nothing", keys)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// Two replicas sharing the emulator: what one evicts the other evicts
+// too, once, even across a reconnect, and a gap clears everything
+func TestInvalidationAcrossReplicas(t *testing.T) {
+	s, ctx := useEmulator(t)
+	a, b := newTestReplica(), newTestReplica()
+	a.listen(t, s)
+	b.listen(t, s)
+
+	if err := a.bus.publish(ctx, s.client, "users", []string{"u1", "u2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.nextEviction(t); !reflect.DeepEqual(got, []string{"u1", "u2"}) {
+		t.Errorf("b evicted %v, want [u1 u2]", got)
+	}
+	a.noEviction(t) // its own publication, already applied locally
+
+	if err := b.bus.publish(ctx, s.client, "users", []string{"u3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.nextEviction(t); !reflect.DeepEqual(got, []string{"u3"}) {
+		t.Errorf("a evicted %v, want [u3]", got)
+	}
+	b.noEviction(t)
+
+	// A replayed record is ignored
+	b.bus.apply("users", invalidationRecord{Seq: 2, Keys: []string{"u3"}, Replica: a.bus.replica})
+	b.noEviction(t)
+
+	// Published while b is disconnected, applied when it reconnects
+	b.close()
+	if err := a.bus.publish(ctx, s.client, "users", []string{"u4"}); err != nil {
+		t.Fatal(err)
+	}
+	b.listen(t, s)
+	if got := b.nextEviction(t); !reflect.DeepEqual(got, []string{"u4"}) {
+		t.Errorf("b evicted %v after reconnecting, want [u4]", got)
+	}
+
+	// Two publications b didn't see one by one clear its whole cache
+	b.close()
+	for _, key := range []string{"u5", "u6"} {
+		if err := a.bus.publish(ctx, s.client, "users", []string{key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.listen(t, s)
+	if got := b.nextEviction(t); got != nil {
+		t.Errorf("b evicted %v after a gap, want everything (nil)", got)
+	}
+	b.noEviction(t)
+
+	if a.bus.published.Load() != 4 || b.bus.published.Load() != 1 {
+		t.Errorf("published a=%d b=%d, want 4 and 1", a.bus.published.Load(), b.bus.published.Load())
+	}
+}
@@ -0,0 +1,526 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Long-running admin operations run as jobs: startJob records one in the
+// jobs collection and a worker on any replica leases and runs it. Workers
+// renew their lease every JOBS_HEARTBEAT, writing progress as they go; a
+// job whose lease expires (its worker died) is taken over by another
+// worker and resumes from its last checkpoint, up to JOBS_MAX_ATTEMPTS.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var jobStates = newEnum("state", jobQueued, jobRunning, jobSucceeded, jobFailed, jobCancelled)
+
+var (
+	jobsPollInterval = config.Duration("JOBS_POLL_INTERVAL", 5*time.Second)
+	jobsConcurrency  = config.Int("JOBS_CONCURRENCY", 2)
+	jobsLease        = config.Duration("JOBS_LEASE", time.Minute)
+	jobsHeartbeat    = config.Duration("JOBS_HEARTBEAT", 5*time.Second)
+	jobsMaxAttempts  = config.Int("JOBS_MAX_ATTEMPTS", 3)
+	jobOwner         = newLeaseOwner()
+	jobSlots         = make(chan struct{}, max(jobsConcurrency, 1))
+	jobWake          = make(chan struct{}, 1)
+)
+
+var (
+	errJobLeaseLost   = errors.New("job lease held by another worker")
+	errJobFinished    = errors.New("job already finished")
+	errUnknownJobType = errors.New("unknown job type")
+)
+
+// A jobRunner does the work of one job type. It must stop when ctx is
+// cancelled, and should record a checkpoint it can resume from when the
+// job is taken over after a crash.
+type jobRunner func(s *Server, ctx context.Context, run *jobRun) (map[string]interface{}, error)
+
+// Registered job types
+var jobRunners = map[string]jobRunner{
+	"reindex":             (*Server).runReindexJob,
+	"migrations":          (*Server).runMigrationsJob,
+	"email_index_check":   (*Server).runEmailIndexCheckJob,
+	"generate_users":      (*Server).runGenerateUsersJob,
+	"bulk_update":         (*Server).runBulkUpdateJob,
+	"prune_notifications": (*Server).runPruneNotificationsJob,
+	"archive_inactive":    (*Server).runArchiveInactiveJob,
+	"anonymize_users":     (*Server).runAnonymizeUsersJob,
+	"sheets_export":       (*Server).runSheetsExportJob,
+	"bigquery_export":     (*Server).runBigQueryExportJob,
+	"workspace_import":    (*Server).runWorkspaceImportJob,
+	"siem_forward":        (*Server).runSIEMForwardJob,
+	"prune_deleted_users": (*Server).runPruneDeletedUsersJob,
+	"ttl_sweep":           (*Server).runTTLSweepJob,
+	"schema_infer":        (*Server).runSchemaInferJob,
+	"retention":           (*Server).runRetentionJob,
+}
+
+// Job is one record in the jobs collection
+type Job struct {
+	ID              string                 `json:"id" firestore:"-"`
+	Type            string                 `json:"type" firestore:"type"`
+	Params          map[string]interface{} `json:"params,omitempty" firestore:"params,omitempty"`
+	State           string                 `json:"state" firestore:"state"`
+	Processed       int                    `json:"processed" firestore:"processed"`
+	Total           int                    `json:"total,omitempty" firestore:"total"` // 0 when unknown
+	LastError       string                 `json:"lastError,omitempty" firestore:"lastError,omitempty"`
+	Error           string                 `json:"error,omitempty" firestore:"error,omitempty"` // why the job failed
+	Result          map[string]interface{} `json:"result,omitempty" firestore:"result,omitempty"`
+	Checkpoint      string                 `json:"-" firestore:"checkpoint"`
+	CancelRequested bool                   `json:"cancelRequested,omitempty" firestore:"cancelRequested"`
+	Attempts        int                    `json:"attempts" firestore:"attempts"`
+	LeaseOwner      string                 `json:"-" firestore:"leaseOwner"`
+	LeaseUntil      time.Time              `json:"-" firestore:"leaseUntil"`
+	CreatedAt       time.Time              `json:"createdAt" firestore:"createdAt"`
+	StartedAt       *time.Time             `json:"startedAt,omitempty" firestore:"startedAt"`
+	FinishedAt      *time.Time             `json:"finishedAt,omitempty" firestore:"finishedAt"`
+	UpdatedAt       time.Time              `json:"updatedAt" firestore:"updatedAt"`
+}
+
+func finishedJobState(state string) bool {
+	return state == jobSucceeded || state == jobFailed || state == jobCancelled
+}
+
+func jobFromDoc(doc *firestore.DocumentSnapshot) Job {
+	var job Job
+	doc.DataTo(&job)
+	job.ID = doc.Ref.ID
+	return job
+}
+
+// jobRun is a leased job as seen by its runner; progress is written on
+// the next heartbeat
+type jobRun struct {
+	mu        sync.Mutex
+	job       Job
+	cancelled bool // cancellation requested through the API
+	leaseLost bool
+}
+
+func (j *jobRun) dryRun() bool {
+	dryRun, _ := j.job.Params["dryRun"].(bool)
+	return dryRun
+}
+
+// Where a previous attempt got to, "" on the first attempt
+func (j *jobRun) checkpoint() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job.Checkpoint
+}
+
+// Items processed so far, carried over from earlier attempts
+func (j *jobRun) processed() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job.Processed
+}
+
+// Record progress; total is 0 when unknown. checkpoint is what the runner
+// needs to resume just after the items counted in processed.
+func (j *jobRun) progress(processed, total int, checkpoint string) {
+	j.mu.Lock()
+	j.job.Processed, j.job.Total, j.job.Checkpoint = processed, total, checkpoint
+	j.mu.Unlock()
+}
+
+// Record a non-fatal error (the job carries on)
+func (j *jobRun) noteError(err error) {
+	j.mu.Lock()
+	j.job.LastError = err.Error()
+	j.mu.Unlock()
+}
+
+func (j *jobRun) progressUpdates() []firestore.Update {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return []firestore.Update{
+		{Path: "processed", Value: j.job.Processed},
+		{Path: "total", Value: j.job.Total},
+		{Path: "checkpoint", Value: j.job.Checkpoint},
+		{Path: "lastError", Value: j.job.LastError},
+		{Path: "updatedAt", Value: time.Now().UTC()},
+	}
+}
+
+// Record a job and wake a worker to run it
+func (s *Server) startJob(ctx context.Context, jobType string, params map[string]interface{}) (string, error) {
+	if _, ok := jobRunners[jobType]; !ok {
+		return "", errUnknownJobType
+	}
+	now := time.Now().UTC()
+	ref := s.client.Collection("jobs").NewDoc()
+	if _, err := ref.Create(ctx, Job{Type: jobType, Params: params, State: jobQueued, CreatedAt: now, UpdatedAt: now}); err != nil {
+		return "", err
+	}
+	wakeJobWorkers()
+	return ref.ID, nil
+}
+
+// Record a job at ref as part of a transaction; wake the workers once it commits
+func queueJobTx(tx *firestore.Transaction, ref *firestore.DocumentRef, jobType string, params map[string]interface{}) error {
+	if _, ok := jobRunners[jobType]; !ok {
+		return errUnknownJobType
+	}
+	now := time.Now().UTC()
+	return tx.Create(ref, Job{Type: jobType, Params: params, State: jobQueued, CreatedAt: now, UpdatedAt: now})
+}
+
+func wakeJobWorkers() {
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// Respond to a request that started a job with where to follow it
+func writeJobStarted(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Location", "/admin/jobs/"+id)
+	writeJSON(w, r, http.StatusAccepted, map[string]interface{}{"message": "Job started", "id": id, "state": jobQueued})
+}
+
+func (s *Server) startJobWorkers(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(jobsPollInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.claimJobs(withEndpoint(ctx, "jobs")); err != nil && ctx.Err() == nil {
+				log.Printf("⚠️ Job polling failed: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-jobWake:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// Lease runnable jobs while there are free slots: queued ones, and running
+// ones whose lease expired. Needs a composite index on state + leaseUntil.
+func (s *Server) claimJobs(ctx context.Context) error {
+	free := cap(jobSlots) - len(jobSlots)
+	if free == 0 {
+		return nil
+	}
+	docs, err := s.client.Collection("jobs").
+		Where("state", "in", []string{jobQueued, jobRunning}).
+		Where("leaseUntil", "<=", time.Now()).
+		Limit(free).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		select {
+		case jobSlots <- struct{}{}:
+		default:
+			return nil
+		}
+		job, err := s.leaseJob(ctx, doc.Ref)
+		if err != nil {
+			<-jobSlots
+			if err != errJobLeaseLost {
+				log.Printf("⚠️ Failed to lease job %s: %v", doc.Ref.ID, err)
+			}
+			continue
+		}
+		go func() {
+			defer func() { <-jobSlots }()
+			s.executeJob(doc.Ref, job)
+		}()
+	}
+	return nil
+}
+
+// Claim ref for this worker unless it's finished or another worker holds a
+// live lease. A job that has used up its attempts or was cancelled while
+// its worker was gone is finished here instead.
+func (s *Server) leaseJob(ctx context.Context, ref *firestore.DocumentRef) (Job, error) {
+	var job Job
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		job = jobFromDoc(doc)
+		now := time.Now().UTC()
+		if finishedJobState(job.State) || job.LeaseUntil.After(now) {
+			return errJobLeaseLost
+		}
+		final, reason := "", ""
+		switch {
+		case job.CancelRequested:
+			final = jobCancelled
+		case job.Attempts >= jobsMaxAttempts:
+			final, reason = jobFailed, fmt.Sprintf("abandoned after %d attempts", job.Attempts)
+		case jobRunners[job.Type] == nil:
+			final, reason = jobFailed, "unknown job type "+job.Type
+		}
+		if final != "" {
+			job.State = final
+			return tx.Update(ref, []firestore.Update{
+				{Path: "state", Value: final},
+				{Path: "error", Value: reason},
+				{Path: "leaseOwner", Value: ""},
+				{Path: "finishedAt", Value: now},
+				{Path: "updatedAt", Value: now},
+			})
+		}
+		if job.Attempts > 0 {
+			log.Printf("🔁 Taking over job %s (%s) from an expired lease, attempt %d", ref.ID, job.Type, job.Attempts+1)
+		}
+		updates := []firestore.Update{
+			{Path: "state", Value: jobRunning},
+			{Path: "attempts", Value: job.Attempts + 1},
+			{Path: "leaseOwner", Value: jobOwner},
+			{Path: "leaseUntil", Value: now.Add(jobsLease)},
+			{Path: "updatedAt", Value: now},
+		}
+		if job.StartedAt == nil {
+			updates = append(updates, firestore.Update{Path: "startedAt", Value: now})
+		}
+		job.State = jobRunning
+		return tx.Update(ref, updates)
+	})
+	if err == nil && job.State != jobRunning {
+		return job, errJobLeaseLost
+	}
+	return job, err
+}
+
+// Run a leased job, heartbeating until the runner returns
+func (s *Server) executeJob(ref *firestore.DocumentRef, job Job) {
+	ctx, cancel := context.WithCancel(withEndpoint(context.Background(), "job:"+job.Type))
+	defer cancel()
+	run := &jobRun{job: job}
+	done := make(chan struct{})
+	heartbeat := jobsHeartbeat
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			cancelRequested, err := s.renewJobLease(ref, run)
+			if err == errJobLeaseLost {
+				log.Printf("⚠️ Lost the lease on job %s, stopping", ref.ID)
+				run.mu.Lock()
+				run.leaseLost = true
+				run.mu.Unlock()
+				cancel()
+				return
+			}
+			if err != nil {
+				log.Printf("⚠️ Failed to renew the lease on job %s: %v", ref.ID, err)
+				continue
+			}
+			if cancelRequested {
+				run.mu.Lock()
+				run.cancelled = true
+				run.mu.Unlock()
+				cancel()
+			}
+		}
+	}()
+
+	log.Printf("🛠️ Running job %s (%s)", ref.ID, job.Type)
+	result, err := jobRunners[job.Type](s, ctx, run)
+	close(done)
+	s.finishJob(ref, run, result, err)
+}
+
+// Extend our lease and write progress; reports whether cancellation was requested
+func (s *Server) renewJobLease(ref *firestore.DocumentRef, run *jobRun) (bool, error) {
+	cancelRequested := false
+	err := s.client.RunTransaction(withEndpoint(context.Background(), "jobs"), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		job := jobFromDoc(doc)
+		if job.LeaseOwner != jobOwner || finishedJobState(job.State) {
+			return errJobLeaseLost
+		}
+		cancelRequested = job.CancelRequested
+		updates := append(run.progressUpdates(), firestore.Update{Path: "leaseUntil", Value: time.Now().Add(jobsLease)})
+		return tx.Update(ref, updates)
+	})
+	return cancelRequested, err
+}
+
+// Record the job's outcome under our lease
+func (s *Server) finishJob(ref *firestore.DocumentRef, run *jobRun, result map[string]interface{}, runErr error) {
+	run.mu.Lock()
+	cancelled, leaseLost := run.cancelled, run.leaseLost
+	run.mu.Unlock()
+	if leaseLost {
+		return
+	}
+
+	state := jobSucceeded
+	now := time.Now().UTC()
+	updates := append(run.progressUpdates(),
+		firestore.Update{Path: "leaseOwner", Value: ""},
+		firestore.Update{Path: "leaseUntil", Value: time.Time{}},
+		firestore.Update{Path: "finishedAt", Value: now},
+	)
+	switch {
+	case cancelled:
+		state = jobCancelled
+	case runErr != nil:
+		state = jobFailed
+		updates = append(updates, firestore.Update{Path: "error", Value: runErr.Error()})
+	}
+	updates = append(updates, firestore.Update{Path: "state", Value: state})
+	if result != nil {
+		updates = append(updates, firestore.Update{Path: "result", Value: result})
+	}
+	err := s.client.RunTransaction(withEndpoint(context.Background(), "jobs"), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if owner, _ := doc.Data()["leaseOwner"].(string); owner != jobOwner {
+			return errJobLeaseLost
+		}
+		if state == jobFailed {
+			data := map[string]interface{}{"jobId": ref.ID, "jobType": run.job.Type, "error": runErr.Error()}
+			if err := s.recordOutboxTx(tx, "job.failed", "", "", data); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to record the outcome of job %s: %v", ref.ID, err)
+		return
+	}
+	if runErr != nil && !cancelled {
+		log.Printf("⚠️ Job %s (%s) failed: %v", ref.ID, run.job.Type, runErr)
+	} else {
+		log.Printf("✅ Job %s (%s) %s", ref.ID, run.job.Type, state)
+	}
+	if task, _ := run.job.Params["scheduledTask"].(string); task != "" {
+		var duration time.Duration
+		if run.job.StartedAt != nil {
+			duration = now.Sub(*run.job.StartedAt)
+		}
+		log.Printf("⏰ task=%s event=end job=%s outcome=%s duration=%s", task, ref.ID, state, duration.Round(time.Millisecond))
+	}
+}
+
+// List jobs, newest first (GET /admin/jobs?state=&type=). Filtering needs
+// composite indexes on state/type + createdAt desc.
+func (s *Server) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	query := s.client.Collection("jobs").Query
+	if raw := r.URL.Query().Get("state"); raw != "" {
+		state, ok := jobStates.parse(raw)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "state must be "+jobStates.allowed())
+			return
+		}
+		query = query.Where("state", "==", state)
+	}
+	if jobType := r.URL.Query().Get("type"); jobType != "" {
+		query = query.Where("type", "==", jobType)
+	}
+	docs, err := query.OrderBy("createdAt", firestore.Desc).
+		Limit(pageSizeParam(r, 50, 200)).Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing jobs")
+		return
+	}
+	jobs := []Job{}
+	for _, doc := range docs {
+		jobs = append(jobs, jobFromDoc(doc))
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+// Get one job with its progress (GET /admin/jobs/{id})
+func (s *Server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.client.Collection("jobs").Doc(r.PathValue("id")).Get(requestContext(r))
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading job")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, jobFromDoc(doc))
+}
+
+// Cancel a job (POST /admin/jobs/{id}:cancel). A queued job is cancelled
+// at once; a running one is asked to stop and is cancelled by its worker
+// on the next heartbeat.
+func (s *Server) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(r.PathValue("id"), ":cancel")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	ref := s.client.Collection("jobs").Doc(id)
+	var job Job
+	err := s.runTransaction(requestContext(r), dryRunRequested(r), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		job = jobFromDoc(doc)
+		if finishedJobState(job.State) {
+			return errJobFinished
+		}
+		now := time.Now().UTC()
+		if job.State == jobQueued {
+			job.State = jobCancelled
+			job.FinishedAt = &now
+			return tx.Update(ref, []firestore.Update{
+				{Path: "state", Value: jobCancelled},
+				{Path: "finishedAt", Value: now},
+				{Path: "updatedAt", Value: now},
+			})
+		}
+		job.CancelRequested = true
+		return tx.Update(ref, []firestore.Update{
+			{Path: "cancelRequested", Value: true},
+			{Path: "updatedAt", Value: now},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	if err == errJobFinished {
+		writeError(w, r, http.StatusConflict, "conflict", "Job has already finished")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error cancelling job")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, job)
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Run the job machinery as worker owner, with leases of lease that
+// nothing renews unless the test does
+func asJobWorker(t *testing.T, owner string, lease time.Duration) {
+	t.Helper()
+	savedOwner, savedLease, savedHeartbeat := jobOwner, jobsLease, jobsHeartbeat
+	jobOwner, jobsLease, jobsHeartbeat = owner, lease, time.Hour
+	t.Cleanup(func() { jobOwner, jobsLease, jobsHeartbeat = savedOwner, savedLease, savedHeartbeat })
+}
+
+// A job whose worker dies is taken over once its lease expires, resumes
+// from the dead worker's checkpoint and keeps the outcome from being
+// overwritten if the first worker comes back
+func TestJobLeaseTakeover(t *testing.T) {
+	s, ctx := useEmulator(t)
+	jobRunners["test_resume"] = func(_ *Server, ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+		return map[string]interface{}{"resumedFrom": run.checkpoint(), "processedBefore": run.processed()}, nil
+	}
+	t.Cleanup(func() { delete(jobRunners, "test_resume") })
+	id, err := s.startJob(ctx, "test_resume", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := s.client.Collection("jobs").Doc(id)
+
+	// Worker a leases the job, gets half way and dies
+	asJobWorker(t, "worker-a", 300*time.Millisecond)
+	job, err := s.leaseJob(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadRun := &jobRun{job: job}
+	deadRun.progress(5, 10, "item-5")
+	if _, err := s.renewJobLease(ref, deadRun); err != nil {
+		t.Fatal(err)
+	}
+
+	// Worker b can't lease it while a's lease is live, but can once it expires
+	jobOwner = "worker-b"
+	if _, err := s.leaseJob(ctx, ref); !errors.Is(err, errJobLeaseLost) {
+		t.Fatalf("leaseJob under a live lease = %v, want %v", err, errJobLeaseLost)
+	}
+	time.Sleep(jobsLease + 50*time.Millisecond)
+	job, err = s.leaseJob(ctx, ref)
+	if err != nil {
+		t.Fatalf("leaseJob after the lease expired: %v", err)
+	}
+	if job.Attempts != 1 || job.Checkpoint != "item-5" || job.Processed != 5 {
+		t.Errorf("taken over job = attempts %d, checkpoint %q, processed %d; want 1, item-5, 5", job.Attempts, job.Checkpoint, job.Processed)
+	}
+	s.executeJob(ref, job)
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := jobFromDoc(doc)
+	if got.State != jobSucceeded || got.Attempts != 2 || got.LeaseOwner != "" {
+		t.Errorf("job = state %s, attempts %d, owner %q; want succeeded, 2, none", got.State, got.Attempts, got.LeaseOwner)
+	}
+	if got.Result["resumedFrom"] != "item-5" || got.Result["processedBefore"] != int64(5) {
+		t.Errorf("result = %v, want resumedFrom item-5 and processedBefore 5", got.Result)
+	}
+
+	// Worker a comes back: it can neither renew nor record an outcome
+	jobOwner = "worker-a"
+	if _, err := s.renewJobLease(ref, deadRun); !errors.Is(err, errJobLeaseLost) {
+		t.Errorf("renewJobLease by the dead worker = %v, want %v", err, errJobLeaseLost)
+	}
+	s.finishJob(ref, deadRun, map[string]interface{}{"stale": true}, errors.New("stale"))
+	doc, err = ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := jobFromDoc(doc); after.State != jobSucceeded || after.Result["stale"] != nil {
+		t.Errorf("after the dead worker finished: state %s, result %v; want b's outcome kept", after.State, after.Result)
+	}
+}
+
+// An expired job is finished rather than leased when it is cancelled or
+// out of attempts
+func TestJobLeaseExpiredFinished(t *testing.T) {
+	s, ctx := useEmulator(t)
+	asJobWorker(t, "worker-b", time.Minute)
+	expired := time.Now().Add(-time.Second)
+	tests := []struct {
+		name      string
+		job       Job
+		wantState string
+		wantError string
+	}{
+		{"cancelled", Job{Type: "reindex", CancelRequested: true, Attempts: 1}, jobCancelled, ""},
+		{"out of attempts", Job{Type: "reindex", Attempts: jobsMaxAttempts}, jobFailed, fmt.Sprintf("abandoned after %d attempts", jobsMaxAttempts)},
+		{"unknown type", Job{Type: "retired", Attempts: 1}, jobFailed, "unknown job type retired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := tt.job
+			job.State, job.LeaseOwner, job.LeaseUntil = jobRunning, "worker-a", expired
+			job.CreatedAt, job.UpdatedAt = expired, expired
+			ref := s.client.Collection("jobs").NewDoc()
+			if _, err := ref.Create(ctx, job); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.leaseJob(ctx, ref); !errors.Is(err, errJobLeaseLost) {
+				t.Fatalf("leaseJob = %v, want %v", err, errJobLeaseLost)
+			}
+			doc, err := ref.Get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := jobFromDoc(doc)
+			if got.State != tt.wantState || got.Error != tt.wantError || got.LeaseOwner != "" || got.Attempts != job.Attempts {
+				t.Errorf("job = state %s, error %q, owner %q, attempts %d; want %s, %q, none, %d", got.State, got.Error, got.LeaseOwner, got.Attempts, tt.wantState, tt.wantError, job.Attempts)
+			}
+		})
+	}
+}
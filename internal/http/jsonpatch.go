@@ -0,0 +1,294 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// JSON Patch (RFC 6902) support for user updates. Patches are applied to
+// the user's JSON representation, so paths use the API field names.
+
+// One RFC 6902 operation
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Fields a patch may never touch
+var readOnlyPatchFields = map[string]bool{
+	"id":              true,
+	"passwordHash":    true,
+	"createdAt":       true,
+	"avatarUrl":       true,
+	"currentConsents": true,
+	"plan":            true, // POST /users/{id}:changePlan
+}
+
+// API names of the User fields, true for those holding a map
+var userJSONFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = t.Field(i).Type.Kind() == reflect.Map
+	}
+	return fields
+}()
+
+// Why a JSON Patch or update mask can't write the user field at tokens
+// (API names), "" when it can
+func userPathProblem(tokens []string) string {
+	isMap, known := userJSONFields[tokens[0]]
+	switch {
+	case readOnlyPatchFields[tokens[0]]:
+		return "read-only"
+	case !known:
+		return "unknown field"
+	case len(tokens) > 1 && !isMap:
+		return tokens[0] + " has no members"
+	}
+	return ""
+}
+
+// patchError carries the HTTP status and error code a failed patch should produce
+type patchError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *patchError) Error() string { return e.msg }
+
+func patchFailed(status int, format string, args ...interface{}) error {
+	code := "invalid_patch"
+	if status == http.StatusConflict {
+		code = "patch_test_failed"
+	}
+	return &patchError{status: status, code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// Split a JSON Pointer (RFC 6901) into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" || pointer[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		for j := 0; j < len(t); j++ {
+			if t[j] == '~' {
+				if j+1 == len(t) || (t[j+1] != '0' && t[j+1] != '1') {
+					return nil, fmt.Errorf("path %q has an invalid ~ escape", pointer)
+				}
+				j++
+			}
+		}
+		// ~1 is decoded before ~0 so "~01" becomes "~1", not "/"
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	// A snake_case field name addresses the same field as its camelCase
+	// one; deeper tokens are attribute keys and match exactly
+	tokens[0] = camelCase(tokens[0])
+	return tokens, nil
+}
+
+// Decode and check a patch document, rejecting read-only paths up front
+func parsePatch(body []byte) ([]patchOp, error) {
+	var ops []patchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, patchFailed(http.StatusBadRequest, "Invalid JSON Patch document")
+	}
+	if len(ops) == 0 {
+		return nil, patchFailed(http.StatusBadRequest, "JSON Patch document is empty")
+	}
+	var forbidden []string
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "remove", "replace", "test":
+		default:
+			return nil, patchFailed(http.StatusUnprocessableEntity, "Operation %d: unsupported op %q (supported: add, remove, replace, test)", i, op.Op)
+		}
+		tokens, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, patchFailed(http.StatusUnprocessableEntity, "Operation %d: %v", i, err)
+		}
+		if problem := userPathProblem(tokens); problem != "" {
+			forbidden = append(forbidden, op.Path+" ("+problem+")")
+		}
+		if op.Op != "remove" && len(op.Value) == 0 {
+			return nil, patchFailed(http.StatusUnprocessableEntity, "Operation %d: %s requires a value", i, op.Op)
+		}
+	}
+	if len(forbidden) > 0 {
+		return nil, patchFailed(http.StatusUnprocessableEntity, "Patch targets fields it can't write: %s", strings.Join(forbidden, ", "))
+	}
+	return ops, nil
+}
+
+// Apply ops in order to a JSON document; a failed op leaves doc unusable
+func applyPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	for i, op := range ops {
+		tokens, _ := parsePointer(op.Path)
+		var value interface{}
+		if op.Op != "remove" {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, patchFailed(http.StatusBadRequest, "Operation %d: invalid value", i)
+			}
+		}
+		var err error
+		doc, err = patchNode(doc, tokens, op.Op, value)
+		if err != nil {
+			if pe, ok := err.(*patchError); ok {
+				return nil, pe
+			}
+			return nil, patchFailed(http.StatusUnprocessableEntity, "Operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// Apply one op at tokens below node and return the updated node
+func patchNode(node interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	key := tokens[0]
+	if len(tokens) > 1 {
+		child, err := childOf(node, key)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := patchNode(child, tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		return setChild(node, key, updated)
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		current, exists := n[key]
+		switch op {
+		case "add":
+			n[key] = value
+		case "replace":
+			if !exists {
+				return nil, fmt.Errorf("member %q does not exist", key)
+			}
+			n[key] = value
+		case "remove":
+			if !exists {
+				return nil, fmt.Errorf("member %q does not exist", key)
+			}
+			delete(n, key)
+		case "test":
+			if !exists || !reflect.DeepEqual(current, value) {
+				return nil, patchFailed(http.StatusConflict, "Test failed at %q", key)
+			}
+		}
+		return n, nil
+
+	case []interface{}:
+		if key == "-" && op == "add" {
+			return append(n, value), nil
+		}
+		idx, err := arrayIndex(key, len(n), op == "add")
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add":
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+		case "replace":
+			n[idx] = value
+		case "remove":
+			n = append(n[:idx], n[idx+1:]...)
+		case "test":
+			if !reflect.DeepEqual(n[idx], value) {
+				return nil, patchFailed(http.StatusConflict, "Test failed at index %d", idx)
+			}
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("cannot address %q inside a scalar value", key)
+}
+
+func childOf(node interface{}, key string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[key]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", key)
+		}
+		return child, nil
+	case []interface{}:
+		idx, err := arrayIndex(key, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		return n[idx], nil
+	}
+	return nil, fmt.Errorf("cannot address %q inside a scalar value", key)
+}
+
+func setChild(node interface{}, key string, child interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[key] = child
+		return n, nil
+	case []interface{}:
+		idx, err := arrayIndex(key, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	}
+	return nil, fmt.Errorf("cannot address %q inside a scalar value", key)
+}
+
+// Parse an array index token; insert allows the position just past the end
+func arrayIndex(token string, length int, insert bool) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.Trim(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if idx > length || (!insert && idx == length) {
+		return 0, fmt.Errorf("array index %d out of range", idx)
+	}
+	return idx, nil
+}
+
+// Patch a user through its JSON representation and validate the result
+// against the User schema
+func applyUserPatch(current model.User, ops []patchOp) (model.User, error) {
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return model.User{}, err
+	}
+	var doc interface{}
+	json.Unmarshal(raw, &doc)
+
+	patched, err := applyPatch(doc, ops)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	raw, _ = json.Marshal(patched)
+	var user model.User
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&user); err != nil {
+		return model.User{}, patchFailed(http.StatusUnprocessableEntity, "Patched user is invalid: %v", err)
+	}
+	return user, nil
+}
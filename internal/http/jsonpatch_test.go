@@ -0,0 +1,180 @@
+package http
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+	}{
+		{"/name", []string{"name"}},
+		{"/first_name", []string{"firstName"}},
+		{"/attributes/tags/0", []string{"attributes", "tags", "0"}},
+		{"/attributes/a~1b", []string{"attributes", "a/b"}},
+		{"/attributes/a~0b", []string{"attributes", "a~b"}},
+		{"/attributes/~01", []string{"attributes", "~1"}},
+		{"/attributes/~10", []string{"attributes", "/0"}},
+		{"/attributes/~0~1~0", []string{"attributes", "~/~"}},
+		{"/attributes/snake_key", []string{"attributes", "snake_key"}},
+		{"/attributes/", []string{"attributes", ""}},
+		{"/", []string{""}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePointer(%q) = %q, %v, want %q", tt.pointer, got, err, tt.want)
+		}
+	}
+	for _, pointer := range []string{"", "name", "/attributes/~", "/attributes/~2", "/attributes/a~b"} {
+		if got, err := parsePointer(pointer); err == nil {
+			t.Errorf("parsePointer(%q) = %q, want an error", pointer, got)
+		}
+	}
+}
+
+// The status and message of a patch failure, failing the test on any other error
+func patchFailure(t *testing.T, err error) (int, string) {
+	t.Helper()
+	pe, ok := err.(*patchError)
+	if !ok {
+		t.Fatalf("error %v (%T), want a *patchError", err, err)
+	}
+	return pe.status, pe.msg
+}
+
+func TestParsePatchRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		msg    string
+	}{
+		{"not JSON", `{"op": "add"`, http.StatusBadRequest, "Invalid JSON Patch document"},
+		{"empty", `[]`, http.StatusBadRequest, "empty"},
+		{"unsupported op", `[{"op": "move", "from": "/name", "path": "/email"}]`, http.StatusUnprocessableEntity, `unsupported op "move"`},
+		{"bad pointer", `[{"op": "add", "path": "name", "value": 1}]`, http.StatusUnprocessableEntity, "must start with /"},
+		{"bad escape", `[{"op": "add", "path": "/attributes/~2", "value": 1}]`, http.StatusUnprocessableEntity, "invalid ~ escape"},
+		{"missing value", `[{"op": "replace", "path": "/name"}]`, http.StatusUnprocessableEntity, "replace requires a value"},
+		{"unknown field", `[{"op": "add", "path": "/shoeSize", "value": 9}]`, http.StatusUnprocessableEntity, "/shoeSize (unknown field)"},
+		{"member of a scalar", `[{"op": "add", "path": "/name/first", "value": "Ada"}]`, http.StatusUnprocessableEntity, "/name/first (name has no members)"},
+		{
+			"read-only fields",
+			`[{"op": "replace", "path": "/name", "value": "Ada"}, {"op": "add", "path": "/passwordHash", "value": "x"}, {"op": "remove", "path": "/created_at"}]`,
+			http.StatusUnprocessableEntity,
+			"/passwordHash (read-only), /created_at (read-only)",
+		},
+		{"plan", `[{"op": "replace", "path": "/plan", "value": "pro"}]`, http.StatusUnprocessableEntity, "/plan (read-only)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := parsePatch([]byte(tt.body))
+			if err == nil {
+				t.Fatalf("parsePatch accepted it: %+v", ops)
+			}
+			status, msg := patchFailure(t, err)
+			if status != tt.status || !strings.Contains(msg, tt.msg) {
+				t.Errorf("got %d %q, want %d containing %q", status, msg, tt.status, tt.msg)
+			}
+		})
+	}
+}
+
+func TestApplyUserPatch(t *testing.T) {
+	current := func() model.User {
+		return model.User{
+			Name:  "Ada",
+			Email: "ada@example.com",
+			Plan:  model.PlanFree,
+			Attributes: map[string]interface{}{
+				"tags":    []interface{}{"a", "b"},
+				"address": map[string]interface{}{"city": "London"},
+				"a/b":     "slash",
+				"m~n":     "tilde",
+			},
+		}
+	}
+	patch := func(t *testing.T, body string) (model.User, error) {
+		t.Helper()
+		ops, err := parsePatch([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return applyUserPatch(current(), ops)
+	}
+
+	user, err := patch(t, `[
+		{"op": "test", "path": "/attributes/address/city", "value": "London"},
+		{"op": "replace", "path": "/name", "value": "Ada King"},
+		{"op": "add", "path": "/attributes/tags/0", "value": "z"},
+		{"op": "add", "path": "/attributes/tags/-", "value": "c"},
+		{"op": "remove", "path": "/attributes/tags/2"},
+		{"op": "replace", "path": "/attributes/a~1b", "value": "replaced"},
+		{"op": "remove", "path": "/attributes/m~0n"},
+		{"op": "add", "path": "/attributes/address/zip", "value": "N1"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.User{
+		Name:  "Ada King",
+		Email: "ada@example.com",
+		Plan:  model.PlanFree,
+		Attributes: map[string]interface{}{
+			"tags":    []interface{}{"z", "a", "c"},
+			"address": map[string]interface{}{"city": "London", "zip": "N1"},
+			"a/b":     "replaced",
+		},
+	}
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("patched user = %+v, want %+v", user, want)
+	}
+
+	failures := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"test mismatch", `[{"op": "test", "path": "/name", "value": "Grace"}]`, http.StatusConflict},
+		{"test of an array element", `[{"op": "test", "path": "/attributes/tags/1", "value": "a"}]`, http.StatusConflict},
+		{"replace a missing member", `[{"op": "replace", "path": "/attributes/zip", "value": 1}]`, http.StatusUnprocessableEntity},
+		{"remove a missing member", `[{"op": "remove", "path": "/attributes/address/zip"}]`, http.StatusUnprocessableEntity},
+		{"index past the end", `[{"op": "replace", "path": "/attributes/tags/2", "value": 1}]`, http.StatusUnprocessableEntity},
+		{"leading zero index", `[{"op": "remove", "path": "/attributes/tags/01"}]`, http.StatusUnprocessableEntity},
+		{"negative index", `[{"op": "add", "path": "/attributes/tags/-1", "value": 1}]`, http.StatusUnprocessableEntity},
+		{"through a scalar", `[{"op": "add", "path": "/attributes/a~1b/c", "value": 1}]`, http.StatusUnprocessableEntity},
+		{"wrong type for the schema", `[{"op": "replace", "path": "/name", "value": 5}]`, http.StatusUnprocessableEntity},
+		{"attributes not an object", `[{"op": "replace", "path": "/attributes", "value": [1]}]`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := patch(t, tt.body)
+			if err == nil {
+				t.Fatalf("patch applied: %+v", user)
+			}
+			if status, msg := patchFailure(t, err); status != tt.status {
+				t.Errorf("got %d %q, want %d", status, msg, tt.status)
+			}
+		})
+	}
+}
+
+func TestApplyUserPatchLeavesCurrentAlone(t *testing.T) {
+	current := model.User{Name: "Ada", Attributes: map[string]interface{}{"tags": []interface{}{"a"}}}
+	ops, err := parsePatch([]byte(`[{"op": "add", "path": "/attributes/tags/-", "value": "b"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyUserPatch(current, ops); err != nil {
+		t.Fatal(err)
+	}
+	if tags := current.Attributes["tags"].([]interface{}); len(tags) != 1 {
+		t.Errorf("current user's tags = %v, want them untouched", tags)
+	}
+}
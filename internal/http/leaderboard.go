@@ -0,0 +1,190 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Leaderboards rank users by a numeric field from LEADERBOARD_FIELDS.
+// Results are cached for LEADERBOARD_CACHE_TTL, and responses say when
+// they were computed.
+var (
+	leaderboardFields   = strings.Split(config.String("LEADERBOARD_FIELDS", "referralCount"), ",")
+	leaderboardCacheTTL = config.Duration("LEADERBOARD_CACHE_TTL", time.Minute)
+)
+
+// Cached entries beyond this are pruned of expired ones
+const leaderboardCacheSize = 1000
+
+type leaderboardCacheEntry struct {
+	value     map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	leaderboardMu    sync.Mutex
+	leaderboardCache = map[string]leaderboardCacheEntry{}
+)
+
+func cachedLeaderboard(key string) (map[string]interface{}, time.Time, bool) {
+	leaderboardMu.Lock()
+	defer leaderboardMu.Unlock()
+	e, ok := leaderboardCache[key]
+	if !ok || time.Since(e.fetchedAt) >= leaderboardCacheTTL {
+		return nil, time.Time{}, false
+	}
+	return e.value, e.fetchedAt, true
+}
+
+func cacheLeaderboard(key string, value map[string]interface{}, fetchedAt time.Time) {
+	leaderboardMu.Lock()
+	defer leaderboardMu.Unlock()
+	if len(leaderboardCache) >= leaderboardCacheSize {
+		for k, e := range leaderboardCache {
+			if time.Since(e.fetchedAt) >= leaderboardCacheTTL {
+				delete(leaderboardCache, k)
+			}
+		}
+	}
+	leaderboardCache[key] = leaderboardCacheEntry{value: value, fetchedAt: fetchedAt}
+}
+
+// ?by=, which must be one of LEADERBOARD_FIELDS
+func leaderboardField(w http.ResponseWriter, r *http.Request) (string, bool) {
+	by := r.URL.Query().Get("by")
+	for _, f := range leaderboardFields {
+		if f = strings.TrimSpace(f); f != "" && f == by {
+			return by, true
+		}
+	}
+	writeError(w, r, http.StatusBadRequest, "invalid_argument", "by must be one of: "+strings.Join(leaderboardFields, ", "))
+	return "", false
+}
+
+// A stored numeric field as float64, false when missing or not a number
+func numericField(doc *firestore.DocumentSnapshot, field string) (float64, bool) {
+	switch v := doc.Data()[field].(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Top users by a numeric field, highest first
+// (GET /users/leaderboard?by=referralCount&limit=25). Users with equal
+// values share a rank and the next rank skips ahead (1, 2, 2, 4). Users
+// without the field aren't ranked.
+func (s *Server) leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	by, ok := leaderboardField(w, r)
+	if !ok {
+		return
+	}
+	limit := 25
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	key := "top:" + by + ":" + strconv.Itoa(limit)
+	response, fetchedAt, hit := cachedLeaderboard(key)
+	if !hit {
+		// Over-fetch so soft-deleted users can be skipped
+		docs, err := s.users.Users().OrderBy(by, firestore.Desc).Limit(limit * 2).Documents(requestContext(r)).GetAll()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading leaderboard")
+			return
+		}
+		entries := []map[string]interface{}{}
+		var prev float64
+		rank := 0
+		for _, doc := range docs {
+			value, ok := numericField(doc, by)
+			if !ok || isSoftDeleted(doc) {
+				continue
+			}
+			if len(entries) == limit {
+				break
+			}
+			if len(entries) == 0 || value != prev {
+				rank = len(entries) + 1
+			}
+			prev = value
+			user := userFromDoc(doc)
+			user.AvatarURL = avatarURL(doc.Ref.ID, user)
+			entries = append(entries, map[string]interface{}{
+				"rank":  rank,
+				"id":    doc.Ref.ID,
+				"value": value,
+				"user":  user,
+			})
+		}
+		response, fetchedAt = map[string]interface{}{"by": by, "entries": entries}, time.Now().UTC()
+		cacheLeaderboard(key, response, fetchedAt)
+	}
+	writeLeaderboardResponse(w, r, response, fetchedAt)
+}
+
+// One user's position (GET /users/{id}/rank?by=referralCount): one more
+// than the number of users with a greater value, so ties share a rank.
+// The count is a Firestore aggregation and includes soft-deleted users.
+func (s *Server) userRankHandler(w http.ResponseWriter, r *http.Request) {
+	by, ok := leaderboardField(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	key := "rank:" + by + ":" + id
+	response, fetchedAt, hit := cachedLeaderboard(key)
+	if !hit {
+		ctx := requestContext(r)
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading user")
+			return
+		}
+		response = map[string]interface{}{"id": id, "by": by, "ranked": false}
+		if value, ok := numericField(doc, by); ok {
+			above := s.users.Users().Where(by, ">", doc.Data()[by])
+			res, err := above.NewAggregationQuery().WithCount("all").Get(ctx)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "internal", "Error computing rank")
+				return
+			}
+			var greater int64
+			if v, ok := res["all"].(interface{ GetIntegerValue() int64 }); ok {
+				greater = v.GetIntegerValue()
+			}
+			response["ranked"], response["value"], response["rank"] = true, value, greater+1
+		}
+		fetchedAt = time.Now().UTC()
+		cacheLeaderboard(key, response, fetchedAt)
+	}
+	writeLeaderboardResponse(w, r, response, fetchedAt)
+}
+
+func writeLeaderboardResponse(w http.ResponseWriter, r *http.Request, cached map[string]interface{}, fetchedAt time.Time) {
+	response := make(map[string]interface{}, len(cached)+1)
+	for k, v := range cached {
+		response[k] = v
+	}
+	response["cachedAt"] = fetchedAt
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// In-flight caps for reads (GET/HEAD/OPTIONS) and writes. A request over
+// its cap waits up to LIMIT_QUEUE_WAIT in a queue of LIMIT_QUEUE_SIZE;
+// when the queue is full or the wait runs out it's shed with a 503.
+var (
+	readLimiter  = newConcurrencyLimiter("reads", config.Int("LIMIT_READS", 256))
+	writeLimiter = newConcurrencyLimiter("writes", config.Int("LIMIT_WRITES", 64))
+)
+
+// Health and metrics probes must answer even while shedding
+var unlimitedPaths = map[string]bool{"/healthz": true, "/readyz": true, "/version": true, "/admin/metrics": true}
+
+// concurrencyLimiter is a semaphore with a bounded, time-limited wait queue
+type concurrencyLimiter struct {
+	slots chan struct{}
+	queue chan struct{}
+	wait  time.Duration
+}
+
+func newConcurrencyLimiter(name string, limit int) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		slots: make(chan struct{}, max(limit, 1)),
+		queue: make(chan struct{}, max(config.Int("LIMIT_QUEUE_SIZE", 32), 0)),
+		wait:  config.Duration("LIMIT_QUEUE_WAIT", 250*time.Millisecond),
+	}
+	expvar.Publish("limiter_"+name, expvar.Func(func() interface{} {
+		return map[string]int{"inFlight": len(l.slots), "queued": len(l.queue), "limit": cap(l.slots)}
+	}))
+	return l
+}
+
+// Take a slot, waiting in the queue if there is room; false means shed
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// Shed requests beyond the concurrency caps instead of letting them pile
+// up on Firestore, and writes the adaptive throttle doesn't admit
+func loadSheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limiter := writeLimiter
+		if readOnlyRequest(r) {
+			limiter = readLimiter
+		} else if ok, wait := writeThrottle.admit(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(wait.Seconds(), 1)))))
+			writeError(w, r, http.StatusTooManyRequests, "write_throttled", "Firestore write quota is exhausted; retry later")
+			return
+		}
+		if !limiter.acquire(r) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusServiceUnavailable, "overloaded", "Server is overloaded, retry shortly")
+			return
+		}
+		defer limiter.release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A limiter of limit slots and queue waiting places, unpublished
+func testLimiter(limit, queue int, wait time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, limit), queue: make(chan struct{}, queue), wait: wait}
+}
+
+// Swap readLimiter for the duration of a test
+func withReadLimiter(t *testing.T, l *concurrencyLimiter) {
+	saved := readLimiter
+	readLimiter = l
+	t.Cleanup(func() { readLimiter = saved })
+}
+
+func TestLoadSheddingShedsExcessQuickly(t *testing.T) {
+	const limit, queue, callers = 4, 2, 40
+	withReadLimiter(t, testLimiter(limit, queue, 50*time.Millisecond))
+
+	// A slow store: every admitted request holds its slot until released
+	release := make(chan struct{})
+	var admitted atomic.Int32
+	h := loadSheddingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admitted.Add(1)
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	var shed atomic.Int32
+	var slowest atomic.Int64
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/listUsers", nil))
+			if rec.Code == http.StatusServiceUnavailable {
+				shed.Add(1)
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("shed response without Retry-After")
+				}
+				if d := int64(time.Since(start)); d > slowest.Load() {
+					slowest.Store(d)
+				}
+			}
+		}()
+	}
+
+	// Everything over the cap is shed while the admitted requests still hold
+	// their slots, none of it waiting longer than the queue allows
+	deadline := time.Now().Add(5 * time.Second)
+	for shed.Load() < callers-limit && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := shed.Load(); got != callers-limit {
+		t.Fatalf("shed %d of %d requests, want %d", got, callers, callers-limit)
+	}
+	if got := admitted.Load(); got != limit {
+		t.Errorf("admitted %d requests, want %d", got, limit)
+	}
+	if d := time.Duration(slowest.Load()); d > time.Second {
+		t.Errorf("slowest shed request took %v, want about the 50ms queue wait", d)
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestLoadSheddingQueuedRequestGetsFreedSlot(t *testing.T) {
+	l := testLimiter(1, 1, 2*time.Second)
+	r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+	if !l.acquire(r) {
+		t.Fatal("first acquire failed")
+	}
+	done := make(chan bool)
+	go func() { done <- l.acquire(r) }()
+	time.Sleep(20 * time.Millisecond)
+	l.release()
+	if !<-done {
+		t.Fatal("queued request was shed although a slot was freed")
+	}
+}
+
+func TestLoadSheddingSkipsProbes(t *testing.T) {
+	full := testLimiter(1, 0, 0)
+	full.slots <- struct{}{}
+	withReadLimiter(t, full)
+	h := loadSheddingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for path := range unlimitedPaths {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s while shedding = %d, want 200", path, rec.Code)
+		}
+	}
+	for _, path := range []string{"/healthz", "/readyz", "/version", "/admin/metrics"} {
+		if !unlimitedPaths[path] {
+			t.Errorf("%s is subject to load shedding", path)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/listUsers", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /listUsers while shedding = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// LINKS_ENABLED=false drops the links object from responses
+var linksEnabled = config.Bool("LINKS_ENABLED", true)
+
+// Scheme and host the client used to reach us
+func baseURL(r *http.Request) string {
+	scheme, host := requestOrigin(r)
+	return scheme + "://" + host
+}
+
+// Absolute URL for path with an optional query
+func absoluteURL(r *http.Request, path string, query url.Values) string {
+	u := baseURL(r) + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Links for a single user resource, or nil when links are disabled
+func userLinks(r *http.Request, id string) map[string]string {
+	if !linksEnabled {
+		return nil
+	}
+	byID := url.Values{"id": {id}}
+	userPath := "/users/" + url.PathEscape(id)
+	return map[string]string{
+		"self":    absoluteURL(r, "/getUser", byID),
+		"update":  absoluteURL(r, "/updateUser", byID),
+		"delete":  absoluteURL(r, "/deleteUser", byID),
+		"avatar":  absoluteURL(r, userPath+"/avatar.svg", nil),
+		"history": absoluteURL(r, userPath+"/history", nil),
+	}
+}
+
+// Links for a page of a list: self plus next/prev for the non-empty
+// tokens. Returns nil when links are disabled.
+func pageLinks(r *http.Request, nextToken, prevToken string) map[string]string {
+	if !linksEnabled {
+		return nil
+	}
+	withToken := func(token string) string {
+		q := r.URL.Query()
+		q.Set("pageToken", token)
+		return absoluteURL(r, r.URL.Path, q)
+	}
+	links := map[string]string{
+		"self": absoluteURL(r, r.URL.Path, r.URL.Query()),
+	}
+	if nextToken != "" {
+		links["next"] = withToken(nextToken)
+	}
+	if prevToken != "" {
+		links["prev"] = withToken(prevToken)
+	}
+	return links
+}
@@ -0,0 +1,238 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// Server-side cache of list responses, off unless LIST_CACHE_TTL is set.
+// Entries are keyed by route, normalized query, principal and the headers
+// the response varies on. A fresh entry is served outright (X-Cache: HIT);
+// for LIST_CACHE_STALE past its TTL it is still served (X-Cache: STALE)
+// while one background request per key refreshes it. Any successful
+// write request invalidates the collection's entries. Clients can skip
+// the cache with Cache-Control: no-cache, or send a write's X-Sync-Token
+// to refill an entry filled before it (see consistency.go).
+var (
+	listCacheTTL   = config.Duration("LIST_CACHE_TTL", 0)
+	listCacheStale = config.Duration("LIST_CACHE_STALE", time.Minute)
+	listCacheSize  = config.Int("LIST_CACHE_SIZE", 256)
+	listCaches     = map[string]*listCache{} // by collection
+)
+
+// listCache holds one collection's cached responses
+type listCache struct {
+	mu         sync.Mutex
+	entries    map[string]*cachedResponse
+	generation int // bumped by invalidate so fills started before a write are dropped
+	fills      singleflight.Group
+}
+
+// cachedResponse is a captured response; only 200s are kept
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	fetched time.Time
+}
+
+// responseBuffer captures a response for the cache
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// Cache next's GET responses for collection
+func cacheListResponses(collection string, next http.HandlerFunc) http.HandlerFunc {
+	if listCacheTTL <= 0 {
+		return next
+	}
+	cache := &listCache{entries: map[string]*cachedResponse{}}
+	listCaches[collection] = cache
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || strings.Contains(r.Header.Get("Cache-Control"), "no-cache") || !flags.enabled(flagListCache) {
+			w.Header().Set("X-Cache", "BYPASS")
+			next(w, r)
+			return
+		}
+		writtenAt, ok := requestSyncToken(r)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "X-Sync-Token is not a token from this server")
+			return
+		}
+		if time.Now().Before(writtenAt) {
+			// Within the skew allowance no fill could be new enough
+			w.Header().Set("X-Cache", "BYPASS")
+			next(w, r)
+			return
+		}
+		key := listCacheKey(r)
+		cache.mu.Lock()
+		entry := cache.entries[key]
+		cache.mu.Unlock()
+
+		state := "HIT"
+		switch age := time.Since(entryFetched(entry)); {
+		case entry == nil || age >= listCacheTTL+listCacheStale || entry.fetched.Before(writtenAt):
+			state = "MISS"
+			entry = cache.fill(key, r, next)
+		case age >= listCacheTTL:
+			state = "STALE"
+			go cache.fill(key, r, next)
+		}
+		// A fill begun before the write may have been shared
+		if entry.fetched.Before(writtenAt) {
+			w.Header().Set("X-Cache", "BYPASS")
+			next(w, r)
+			return
+		}
+		serveCachedResponse(w, r, entry, state)
+	}
+}
+
+func entryFetched(entry *cachedResponse) time.Time {
+	if entry == nil {
+		return time.Time{}
+	}
+	return entry.fetched
+}
+
+// Run next for key once however many requests ask at the same time, and
+// store its response when it is a 200. Validators are dropped from the
+// request so the cache always holds a full body. An entry is dated from
+// when its fill began, the latest its reads can have started.
+func (c *listCache) fill(key string, r *http.Request, next http.HandlerFunc) *cachedResponse {
+	v, _, _ := c.fills.Do(key, func() (interface{}, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		started := time.Now()
+		req := r.Clone(context.WithoutCancel(r.Context()))
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+		buf := &responseBuffer{header: http.Header{}}
+		next(buf, req)
+		entry := &cachedResponse{status: buf.status, header: buf.header, body: buf.body.Bytes(), fetched: started}
+		if entry.status == 0 {
+			entry.status = http.StatusOK
+		}
+		if entry.status != http.StatusOK {
+			return entry, nil
+		}
+		c.mu.Lock()
+		if c.generation == generation {
+			if len(c.entries) >= listCacheSize {
+				c.evictOldest()
+			}
+			c.entries[key] = entry
+		}
+		c.mu.Unlock()
+		return entry, nil
+	})
+	return v.(*cachedResponse)
+}
+
+// Drop the least recently filled entry (c.mu held)
+func (c *listCache) evictOldest() {
+	var oldest string
+	for key, entry := range c.entries {
+		if oldest == "" || entry.fetched.Before(c.entries[oldest].fetched) {
+			oldest = key
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// Route, sorted query and everything else the response depends on
+func listCacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	return strings.Join([]string{
+		r.URL.Path,
+		query.Encode(), // Encode sorts by key
+		principalFromRequest(r),
+		strconv.FormatBool(authenticatedRequest(r)),
+		r.Header.Get("Accept"),
+		r.Header.Get("Accept-Language"),
+	}, "\x00")
+}
+
+// Write entry with Age and X-Cache, answering 304 when the client's
+// validators match it. Errors from a fill are replayed to the requests
+// that shared it but never stored.
+func serveCachedResponse(w http.ResponseWriter, r *http.Request, entry *cachedResponse, state string) {
+	h := w.Header()
+	for k, v := range entry.header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(entry.fetched).Seconds())))
+	h.Set("X-Cache", state)
+	etag := entry.header.Get("ETag")
+	if inm := r.Header.Get("If-None-Match"); entry.status == http.StatusOK && inm != "" && etag != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// Forget collection's cached responses
+func invalidateListCache(collection string) {
+	cache := listCaches[collection]
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	cache.generation++
+	cache.entries = map[string]*cachedResponse{}
+	cache.mu.Unlock()
+}
+
+// Invalidate the cached lists after every successful write request. Nearly
+// every write endpoint changes users, and jobs they start are covered by
+// the TTL.
+func (s *Server) listCacheInvalidation(next http.Handler) http.Handler {
+	if listCacheTTL <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < 400 && !dryRunRequested(r) {
+			for collection := range listCaches {
+				invalidateListCache(collection)
+			}
+			s.publishInvalidation("users")
+		}
+	})
+}
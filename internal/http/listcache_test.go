@@ -0,0 +1,126 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Cache next's responses for the duration of a test, as cacheListResponses
+// does with LIST_CACHE_TTL set; calls counts the requests next serves
+func testListCache(t *testing.T, next http.HandlerFunc) (h http.HandlerFunc, calls *atomic.Int32) {
+	t.Helper()
+	savedTTL, savedCaches := listCacheTTL, listCaches
+	listCacheTTL, listCaches = time.Minute, map[string]*listCache{}
+	t.Cleanup(func() { listCacheTTL, listCaches = savedTTL, savedCaches })
+	calls = &atomic.Int32{}
+	return cacheListResponses("users", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		next(w, r)
+	}), calls
+}
+
+func TestListCacheCoalescesConcurrentMisses(t *testing.T) {
+	release := make(chan struct{})
+	h, calls := testListCache(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release // a slow Firestore query
+		w.Write([]byte(`[{"id":"u1"}]`))
+	})
+
+	const clients = 50
+	var wg, started sync.WaitGroup
+	states := make([]string, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, "/listUsers", nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != `[{"id":"u1"}]` {
+				t.Errorf("response = %d %s", rec.Code, rec.Body)
+			}
+			states[i] = rec.Header().Get("X-Cache")
+		}()
+	}
+	started.Wait()
+	time.Sleep(20 * time.Millisecond) // let the requests reach the fill
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d queries for %d concurrent requests, want 1", n, clients)
+	}
+	for i, state := range states {
+		if state != "MISS" && state != "HIT" {
+			t.Errorf("request %d: X-Cache = %q", i, state)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/listUsers", nil))
+	if rec.Header().Get("X-Cache") != "HIT" || calls.Load() != 1 {
+		t.Errorf("later request: X-Cache %q after %d queries, want a HIT from the one", rec.Header().Get("X-Cache"), calls.Load())
+	}
+}
+
+// A write's X-Sync-Token makes the next read skip a cache entry filled
+// before it; reads without one, or with an older one, keep hitting
+func TestListCacheConsistencyToken(t *testing.T) {
+	savedSkew := syncTokenSkew
+	syncTokenSkew = 0
+	t.Cleanup(func() { syncTokenSkew = savedSkew })
+
+	var version atomic.Int32
+	h, calls := testListCache(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"version":%d}`, version.Load())
+	})
+	write := func() string {
+		version.Add(1)
+		rec := httptest.NewRecorder()
+		syncTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updateUser?id=u1", nil))
+		time.Sleep(time.Millisecond) // keep fills strictly after the write
+		return rec.Header().Get("X-Sync-Token")
+	}
+	list := func(token, wantState, wantBody string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+		if token != "" {
+			r.Header.Set("X-Sync-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if state := rec.Header().Get("X-Cache"); state != wantState || rec.Body.String() != wantBody {
+			t.Errorf("list with token %t: X-Cache %q, body %s; want %q, %s", token != "", state, rec.Body, wantState, wantBody)
+		}
+	}
+
+	early := write()
+	list("", "MISS", `{"version":1}`)
+	list("", "HIT", `{"version":1}`)
+	list(early, "HIT", `{"version":1}`) // the entry is newer than the token
+
+	late := write()
+	list("", "HIT", `{"version":1}`) // without the token, the cached list is fine
+	list(late, "MISS", `{"version":2}`)
+	list(late, "HIT", `{"version":2}`)
+	list("", "HIT", `{"version":2}`)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d queries, want 2", n)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+	r.Header.Set("X-Sync-Token", "forged")
+	serveError(t, h, r, http.StatusBadRequest, "invalid_argument")
+
+	// Within the skew allowance no entry can be new enough
+	syncTokenSkew = time.Minute
+	list(write(), "BYPASS", `{"version":3}`)
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+)
+
+// ?onMalformed= policies for user documents whose fields have the wrong
+// type (e.g. written by another tool): omit them, include them raw, or
+// fail the request
+const (
+	onMalformedSkip    = "skip"
+	onMalformedInclude = "include"
+	onMalformedFail    = "fail"
+)
+
+// MALFORMED_LOG_INTERVAL is how often the same malformed document may be logged
+var malformedLogInterval = config.Duration("MALFORMED_LOG_INTERVAL", 10*time.Minute)
+
+// Malformed user documents seen by read handlers (GET /debug/vars on the admin listener)
+var malformedUserDocs = expvar.NewInt("malformed_user_documents")
+
+var (
+	malformedLogMu sync.Mutex
+	malformedLast  = map[string]time.Time{} // document ID -> last logged
+)
+
+// malformedUserError describes a user document that didn't decode
+type malformedUserError struct {
+	id     string
+	fields []string // stored field names
+}
+
+func (e *malformedUserError) Error() string {
+	return fmt.Sprintf("user %s has malformed fields: %s", e.id, strings.Join(e.fields, ", "))
+}
+
+// The request's ?onMalformed= policy, skip by default; false if unknown
+func malformedPolicy(r *http.Request) (string, bool) {
+	switch p := r.URL.Query().Get("onMalformed"); p {
+	case "":
+		return onMalformedSkip, true
+	case onMalformedSkip, onMalformedInclude, onMalformedFail:
+		return p, true
+	}
+	return "", false
+}
+
+// Decode a user document for a read handler, counting and logging it if
+// any field has the wrong type
+func decodeUserDoc(doc *firestore.DocumentSnapshot) (model.User, *malformedUserError) {
+	user, fields := decodeUserData(doc.Data())
+	if len(fields) == 0 {
+		return user, nil
+	}
+	malformedUserDocs.Add(1)
+	err := &malformedUserError{id: doc.Ref.ID, fields: fields}
+	logMalformed(err)
+	return user, err
+}
+
+// Log err unless its document was already logged within the interval
+func logMalformed(err *malformedUserError) {
+	now := time.Now()
+	malformedLogMu.Lock()
+	defer malformedLogMu.Unlock()
+	if last, ok := malformedLast[err.id]; ok && now.Sub(last) < malformedLogInterval {
+		return
+	}
+	if len(malformedLast) >= 10000 {
+		for id, last := range malformedLast {
+			if now.Sub(last) >= malformedLogInterval {
+				delete(malformedLast, id)
+			}
+		}
+	}
+	malformedLast[err.id] = now
+	log.Printf("⚠️ Malformed user document: %v", err)
+}
+
+// Stand-in for a malformed user under ?onMalformed=include: the raw stored data, flagged
+func malformedEntry(doc *firestore.DocumentSnapshot, err *malformedUserError) UserListEntry {
+	return UserListEntry{ID: doc.Ref.ID, Malformed: true, MalformedFields: err.fields, Data: doc.Data()}
+}
+
+// Answer a single-user read of a malformed document according to policy,
+// with entry as the body under include. Protobuf can't carry the raw data,
+// so include fails there like fail.
+func writeMalformedUser(w http.ResponseWriter, r *http.Request, policy string, entry interface{}, err *malformedUserError) {
+	switch {
+	case policy == onMalformedSkip:
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+	case policy == onMalformedInclude && !wantsProtobuf(r):
+		writeJSON(w, r, http.StatusOK, entry)
+	default:
+		writeError(w, r, http.StatusInternalServerError, "malformed_document", err.Error())
+	}
+}
+
+// List user documents, soft-deleted ones included, with fields of the
+// wrong type (GET /admin/users:malformed)
+func (s *Server) malformedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+	found := []map[string]interface{}{}
+	iter := trackIterator("malformedUsers", s.users.Users().Documents(requestContext(r)))
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error scanning users")
+			return
+		}
+		if _, fields := decodeUserData(doc.Data()); len(fields) > 0 {
+			found = append(found, map[string]interface{}{"id": doc.Ref.ID, "fields": fields})
+		}
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"malformed": found})
+}
@@ -0,0 +1,470 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fields that record merge state and are never copied between users
+var mergeStateFields = map[string]bool{"deletedAt": true, "mergedInto": true}
+
+// Firestore caps a transaction at 500 writes
+const maxMergeWrites = 500
+
+// Soft-deleted users (e.g. merged duplicates) are hidden from reads
+func isSoftDeleted(doc *firestore.DocumentSnapshot) bool {
+	v, ok := doc.Data()["deletedAt"]
+	return ok && v != nil
+}
+
+func emailHash(email string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(email))
+	return h.Sum64()
+}
+
+// Report users sharing a normalized email (GET /admin/duplicates)
+//
+// The first pass keeps only a 64-bit hash per email, the second pass keeps
+// IDs for emails whose hash was seen more than once, so memory stays small
+// even for large collections.
+func (s *Server) duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	ctx := requestContext(r)
+	scan := func(visit func(id, email string)) error {
+		iter := trackIterator("duplicates", s.users.Users().Documents(ctx))
+		defer iter.Stop()
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if isSoftDeleted(doc) {
+				continue
+			}
+			user := userFromDoc(doc)
+			if email := model.NormalizeEmail(user.Email); email != "" {
+				visit(doc.Ref.ID, email)
+			}
+		}
+	}
+
+	counts := map[uint64]int{}
+	if err := scan(func(id, email string) { counts[emailHash(email)]++ }); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error scanning users")
+		return
+	}
+	groups := map[string][]string{}
+	err := scan(func(id, email string) {
+		if counts[emailHash(email)] > 1 {
+			groups[email] = append(groups[email], id)
+		}
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error scanning users")
+		return
+	}
+
+	result := []DuplicateGroup{}
+	for email, ids := range groups {
+		if len(ids) < 2 {
+			continue // hash collision, not a real duplicate
+		}
+		result = append(result, DuplicateGroup{Email: email, UserIDs: ids})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Email < result[j].Email
+	})
+
+	writeJSON(w, r, http.StatusOK, DuplicatesResponse{Groups: result})
+}
+
+type mergeRequest struct {
+	PrimaryID    string   `json:"primaryId"`
+	DuplicateIDs []string `json:"duplicateIds"`
+	DryRun       bool     `json:"dryRun"`
+}
+
+// Everything a merge will write, computed from reads before any write
+type mergePlan struct {
+	primary      *firestore.DocumentRef
+	primaryData  map[string]interface{}
+	previousData map[string]interface{} // primaryData before the merge
+	copiedFields map[string]string      // field -> duplicate it came from
+	moves        []subdocMove
+	skipped      []string
+	duplicates   []*firestore.DocumentRef
+	overridden   []string // protected duplicates merged under X-Override-Protection
+	override     string
+	email        string
+	history      *firestore.DocumentSnapshot // the primary's latest history entry
+	released     []*firestore.DocumentRef    // duplicates' index entries for other emails
+}
+
+type subdocMove struct {
+	from, to *firestore.DocumentRef
+	data     map[string]interface{}
+}
+
+func (p *mergePlan) writes() int {
+	// primary + its history entry + index entry + audit entries + soft deletes +
+	// released index entries + copy/delete per moved doc + outbox events
+	n := 4 + len(p.overridden) + len(p.duplicates) + len(p.released) + 2*len(p.moves)
+	if len(eventSinks) > 0 {
+		n += 1 + len(p.duplicates)
+	}
+	return n
+}
+
+// A duplicate's history describes the duplicate and stays with it; the
+// merge itself is recorded in the primary's history
+func mergeMovesSubcollection(col *firestore.CollectionRef) bool {
+	return col.ID != "history"
+}
+
+var errMergeConflict = errors.New("merge conflict")
+
+// Build a merge plan using get/list, which are transactional or plain reads
+func (s *Server) planMerge(ctx context.Context, req mergeRequest,
+	get func(*firestore.DocumentRef) (*firestore.DocumentSnapshot, error),
+	list func(*firestore.CollectionRef) ([]*firestore.DocumentSnapshot, error)) (*mergePlan, error) {
+
+	primaryRef := s.users.Users().Doc(req.PrimaryID)
+	primaryDoc, err := get(primaryRef)
+	if err != nil {
+		return nil, fmt.Errorf("primary %s: %w", req.PrimaryID, err)
+	}
+	if isSoftDeleted(primaryDoc) {
+		return nil, fmt.Errorf("%w: primary %s is deleted", errMergeConflict, req.PrimaryID)
+	}
+
+	plan := &mergePlan{
+		primary:      primaryRef,
+		primaryData:  primaryDoc.Data(),
+		previousData: primaryDoc.Data(),
+		copiedFields: map[string]string{},
+	}
+	normalizeUserData(plan.primaryData)
+	normalizeUserData(plan.previousData)
+	primaryUser := userFromDoc(primaryDoc)
+	plan.email = model.NormalizeEmail(primaryUser.Email)
+
+	// Existing subcollection doc IDs on the primary, so moves never overwrite
+	taken := map[string]bool{}
+	primaryCols := primaryRef.Collections(ctx)
+	for {
+		col, err := primaryCols.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !mergeMovesSubcollection(col) {
+			continue
+		}
+		docs, err := list(col)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			taken[col.ID+"/"+d.Ref.ID] = true
+		}
+	}
+
+	var dupEmails []string
+	for _, dupID := range req.DuplicateIDs {
+		dupRef := s.users.Users().Doc(dupID)
+		dupDoc, err := get(dupRef)
+		if err != nil {
+			return nil, fmt.Errorf("duplicate %s: %w", dupID, err)
+		}
+		if isSoftDeleted(dupDoc) {
+			return nil, fmt.Errorf("%w: duplicate %s is already deleted", errMergeConflict, dupID)
+		}
+		if isProtected(dupDoc) {
+			if plan.override = protectionOverride(ctx); plan.override == "" {
+				return nil, fmt.Errorf("duplicate %s: %w", dupID, errUserProtected)
+			}
+			plan.overridden = append(plan.overridden, dupID)
+		}
+		dupEmail := model.NormalizeEmail(userFromDoc(dupDoc).Email)
+		if plan.email == "" {
+			plan.email = dupEmail
+		}
+		dupData := dupDoc.Data()
+		normalizeUserData(dupData)
+		for field, value := range dupData {
+			if mergeStateFields[field] {
+				continue
+			}
+			if current, ok := plan.primaryData[field]; !ok || current == nil || current == "" {
+				plan.primaryData[field] = value
+				plan.copiedFields[field] = dupID
+			}
+		}
+
+		cols := dupRef.Collections(ctx)
+		for {
+			col, err := cols.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !mergeMovesSubcollection(col) {
+				continue
+			}
+			docs, err := list(col)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range docs {
+				key := col.ID + "/" + d.Ref.ID
+				if taken[key] {
+					plan.skipped = append(plan.skipped, dupID+"/"+key)
+					continue
+				}
+				taken[key] = true
+				plan.moves = append(plan.moves, subdocMove{
+					from: d.Ref,
+					to:   primaryRef.Collection(col.ID).Doc(d.Ref.ID),
+					data: d.Data(),
+				})
+			}
+		}
+		plan.duplicates = append(plan.duplicates, dupRef)
+		dupEmails = append(dupEmails, dupEmail)
+	}
+
+	// A duplicate under another email must not keep it taken once deleted
+	for i, dupRef := range plan.duplicates {
+		dupEmail := dupEmails[i]
+		if dupEmail == "" || dupEmail == plan.email {
+			continue
+		}
+		idx, err := get(s.users.EmailIndex(dupEmail))
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if owner, _ := idx.Data()["userId"].(string); owner == dupRef.ID {
+			plan.released = append(plan.released, idx.Ref)
+		}
+	}
+
+	if plan.writes() > maxMergeWrites {
+		return nil, fmt.Errorf("%w: merge needs %d writes, more than one transaction allows (%d)",
+			errMergeConflict, plan.writes(), maxMergeWrites)
+	}
+	return plan, nil
+}
+
+func (p *mergePlan) report(dryRun bool) MergeReport {
+	moved := []string{}
+	for _, m := range p.moves {
+		moved = append(moved, m.from.Path[len(p.primary.Parent.Path)+1:])
+	}
+	dups := []string{}
+	for _, d := range p.duplicates {
+		dups = append(dups, d.ID)
+	}
+	return MergeReport{
+		DryRun:           dryRun,
+		PrimaryID:        p.primary.ID,
+		DuplicateIDs:     dups,
+		CopiedFields:     p.copiedFields,
+		MovedDocuments:   moved,
+		SkippedDocuments: p.skipped,
+	}
+}
+
+// The report as audit entry details, under the same keys as the response
+func (m MergeReport) auditDetails() map[string]interface{} {
+	return map[string]interface{}{
+		"dryRun":           m.DryRun,
+		"primaryId":        m.PrimaryID,
+		"duplicateIds":     m.DuplicateIDs,
+		"copiedFields":     m.CopiedFields,
+		"movedDocuments":   m.MovedDocuments,
+		"skippedDocuments": m.SkippedDocuments,
+	}
+}
+
+// Merge duplicate users into a primary (POST /admin/users:merge)
+func (s *Server) mergeUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+
+	var req mergeRequest
+	if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if err := validateMergeRequest(req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	dryRun := req.DryRun || dryRunRequested(r)
+
+	ctx := protectionContext(r)
+	var plan *mergePlan
+	var err error
+	if dryRun {
+		plan, err = s.planMerge(ctx, req,
+			func(ref *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) { return ref.Get(ctx) },
+			func(col *firestore.CollectionRef) ([]*firestore.DocumentSnapshot, error) {
+				return col.Documents(ctx).GetAll()
+			})
+	} else {
+		actor, ip := actorFromRequest(r, "admin"), clientIP(r)
+		err = s.runTransaction(ctx, false, func(ctx context.Context, tx *firestore.Transaction) error {
+			plan, err = s.planMerge(ctx, req, tx.Get,
+				func(col *firestore.CollectionRef) ([]*firestore.DocumentSnapshot, error) {
+					return tx.Documents(col).GetAll()
+				})
+			if err != nil {
+				return err
+			}
+			if plan.history, err = latestHistoryTx(tx, plan.primary); err != nil {
+				return err
+			}
+			return s.applyMerge(tx, plan, actor, ip)
+		})
+	}
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found: "+err.Error())
+		return
+	}
+	if errors.Is(err, errUserProtected) {
+		writeUserProtected(w, r)
+		return
+	}
+	if errors.Is(err, errMergeConflict) {
+		writeError(w, r, http.StatusConflict, "conflict", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error merging users")
+		return
+	}
+	if !dryRun {
+		forgetDocumentRead(plan.primary)
+		s.enqueueSearchUpsert(plan.primary.ID, userFromData(plan.primaryData))
+		for _, dup := range plan.duplicates {
+			forgetDocumentRead(dup)
+			s.enqueueSearchDelete(dup.ID)
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, plan.report(dryRun))
+}
+
+func validateMergeRequest(req mergeRequest) error {
+	if req.PrimaryID == "" || len(req.DuplicateIDs) == 0 {
+		return errors.New("primaryId and duplicateIds required")
+	}
+	seen := map[string]bool{req.PrimaryID: true}
+	for _, id := range req.DuplicateIDs {
+		if id == "" || seen[id] {
+			return fmt.Errorf("duplicateIds must be unique and differ from primaryId (got %q)", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// Write a merge plan inside the transaction that produced it
+func (s *Server) applyMerge(tx *firestore.Transaction, plan *mergePlan, actor, clientIP string) error {
+	deriveUserFields(plan.primaryData)
+	stampUpdatedAt(plan.primaryData)
+	if err := tx.Set(plan.primary, withHistoryVersion(plan.primaryData, plan.history)); err != nil {
+		return err
+	}
+	if err := s.recordOutboxChangeTx(tx, "user.updated", plan.primary.ID, actor, plan.primaryData, plan.previousData); err != nil {
+		return err
+	}
+	dups := make([]string, len(plan.duplicates))
+	for i, d := range plan.duplicates {
+		dups[i] = d.ID
+	}
+	err := recordHistoryTx(tx, plan.primary, plan.history, HistoryEntry{
+		Op:       "merge",
+		Data:     plan.primaryData,
+		Previous: plan.previousData,
+		Actor:    actor,
+		Details:  map[string]interface{}{"duplicateIds": dups},
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range plan.moves {
+		if err := tx.Create(m.to, m.data); err != nil {
+			return err
+		}
+		if err := tx.Delete(m.from); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	for _, id := range plan.overridden {
+		err := s.recordAuditTx(tx, newAuditEntry("user.protection_override", id, actor,
+			map[string]interface{}{"action": "merge", "reason": plan.override}))
+		if err != nil {
+			return err
+		}
+	}
+	for _, dup := range plan.duplicates {
+		err := tx.Update(dup, []firestore.Update{
+			{Path: "deletedAt", Value: now},
+			{Path: "mergedInto", Value: plan.primary.ID},
+			{Path: "updatedAt", Value: now},
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.recordOutboxTx(tx, "user.merged", dup.ID, actor, map[string]interface{}{"mergedInto": plan.primary.ID}); err != nil {
+			return err
+		}
+	}
+	for _, idx := range plan.released {
+		if err := tx.Delete(idx); err != nil {
+			return err
+		}
+	}
+	// The primary's email (or the first duplicate's) now belongs to the primary
+	if plan.email != "" {
+		if err := tx.Set(s.users.EmailIndex(plan.email), map[string]interface{}{"userId": plan.primary.ID}); err != nil {
+			return err
+		}
+	}
+	entry := newAuditEntry("user.merge", plan.primary.ID, actor, plan.report(false).auditDetails())
+	entry.ClientIP = clientIP
+	return s.recordAuditTx(tx, entry)
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+)
+
+// Two users with the same email as a legacy import left them: the second
+// one's email was set behind the index's back
+func legacyDuplicates(t *testing.T, s *Server, ctx context.Context) (primary, duplicate string) {
+	primary = mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	duplicate = mustCreateUser(t, s, ctx, model.User{Email: "temp@example.com", Attributes: map[string]interface{}{"team": "core"}})
+	_, err := s.users.Users().Doc(duplicate).Update(ctx, []firestore.Update{{Path: "email", Value: "Ada@Example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return primary, duplicate
+}
+
+func TestMergeRecordsHistoryAndKeepsDuplicatesOwn(t *testing.T) {
+	s, ctx := useEmulator(t)
+	primary, duplicate := legacyDuplicates(t, s, ctx)
+
+	r := jsonRequest(http.MethodPost, "/admin/users:merge", `{"primaryId": "`+primary+`", "duplicateIds": ["`+duplicate+`"]}`)
+	report := serveJSON(t, s.mergeUsersHandler, r, http.StatusOK)
+	if moved := report["movedDocuments"]; !reflect.DeepEqual(moved, []interface{}{}) {
+		t.Errorf("movedDocuments = %v, want none (history stays with the duplicate)", moved)
+	}
+	if ops := historyOps(t, s, ctx, primary); !reflect.DeepEqual(ops, []string{"create", "merge"}) {
+		t.Errorf("primary history = %v, want [create merge]", ops)
+	}
+	if ops := historyOps(t, s, ctx, duplicate); !reflect.DeepEqual(ops, []string{"create"}) {
+		t.Errorf("duplicate history = %v, want its own [create]", ops)
+	}
+
+	// Undo reverts the merge's field copies
+	u := jsonRequest(http.MethodPost, "/users/"+primary+":undo", "")
+	u.SetPathValue("id", primary)
+	serveJSON(t, s.undoUserHandler, u, http.StatusOK)
+	doc, err := s.users.Users().Doc(primary).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs := userFromDoc(doc).Attributes; attrs["team"] != nil {
+		t.Errorf("attributes after undoing the merge = %v, want the copied team gone", attrs)
+	}
+}
+
+func TestMergeReleasesDuplicateEmails(t *testing.T) {
+	s, ctx := useEmulator(t)
+	primary := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	duplicate := mustCreateUser(t, s, ctx, model.User{Name: "Ada L", Email: "ada.l@example.com"})
+
+	r := jsonRequest(http.MethodPost, "/admin/users:merge", `{"primaryId": "`+primary+`", "duplicateIds": ["`+duplicate+`"]}`)
+	serveJSON(t, s.mergeUsersHandler, r, http.StatusOK)
+
+	if _, err := s.users.UserByEmail(ctx, "ada.l@example.com"); err != store.ErrUserNotFound {
+		t.Errorf("duplicate's email after the merge: err = %v, want store.ErrUserNotFound", err)
+	}
+	mustCreateUser(t, s, ctx, model.User{Name: "Someone else", Email: "ada.l@example.com"})
+	if doc, err := s.users.UserByEmail(ctx, "ada@example.com"); err != nil || doc.Ref.ID != primary {
+		t.Errorf("primary's email after the merge: %v, %v; want user %s", doc, err, primary)
+	}
+}
+
+func TestGetUserByEmailHidesSoftDeletedUsers(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Gone", Email: "gone@example.com"})
+	if _, err := s.users.Users().Doc(id).Update(ctx, []firestore.Update{{Path: "deletedAt", Value: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/getUserByEmail?email=gone@example.com", nil)
+	serveError(t, s.getUserByEmailHandler, r, http.StatusNotFound, "user_not_found")
+}
+
+func TestMergePlanWritesCountsEveryWrite(t *testing.T) {
+	saved := eventSinks
+	t.Cleanup(func() { eventSinks = saved })
+
+	plan := &mergePlan{
+		duplicates: make([]*firestore.DocumentRef, 3),
+		overridden: []string{"d1"},
+		released:   make([]*firestore.DocumentRef, 2),
+		moves:      make([]subdocMove, 5),
+	}
+	eventSinks = nil
+	// primary, history, index, audit, 1 override audit, 3 soft deletes, 2 releases, 10 move writes
+	if got := plan.writes(); got != 20 {
+		t.Errorf("writes without sinks = %d, want 20", got)
+	}
+	eventSinks = []EventSink{nil}
+	// plus an outbox event for the primary and each duplicate
+	if got := plan.writes(); got != 24 {
+		t.Errorf("writes with a sink = %d, want 24", got)
+	}
+}
+
+func TestValidateMergeRequest(t *testing.T) {
+	for _, tt := range []struct {
+		req mergeRequest
+		ok  bool
+	}{
+		{mergeRequest{PrimaryID: "a", DuplicateIDs: []string{"b", "c"}}, true},
+		{mergeRequest{PrimaryID: "a"}, false},
+		{mergeRequest{DuplicateIDs: []string{"b"}}, false},
+		{mergeRequest{PrimaryID: "a", DuplicateIDs: []string{"a"}}, false},
+		{mergeRequest{PrimaryID: "a", DuplicateIDs: []string{"b", "b"}}, false},
+		{mergeRequest{PrimaryID: "a", DuplicateIDs: []string{""}}, false},
+	} {
+		if err := validateMergeRequest(tt.req); (err == nil) != tt.ok {
+			t.Errorf("validateMergeRequest(%+v) = %v, want ok %v", tt.req, err, tt.ok)
+		}
+	}
+}
+
+func TestMergeRemovesDuplicatesFromSearch(t *testing.T) {
+	s, ctx := useEmulator(t)
+	index := withMemoryIndexer(t)
+	primary := mustCreateUser(t, s, ctx, model.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	duplicate := mustCreateUser(t, s, ctx, model.User{Name: "Ada L", Email: "ada.l@example.com"})
+	for _, id := range []string{primary, duplicate} {
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.Upsert(ctx, id, userFromDoc(doc))
+	}
+
+	r := jsonRequest(http.MethodPost, "/admin/users:merge", `{"primaryId": "`+primary+`", "duplicateIds": ["`+duplicate+`"]}`)
+	serveJSON(t, s.mergeUsersHandler, r, http.StatusOK)
+	drainSearchQueue(t)
+
+	if index.has(duplicate) {
+		t.Error("the merged duplicate is still indexed")
+	}
+	if !index.has(primary) {
+		t.Error("the primary was dropped from the index")
+	}
+	if ids := externalSearchIDs(t, s, "ada"); !reflect.DeepEqual(ids, []string{primary}) {
+		t.Errorf("search after the merge = %v, want only the primary %s", ids, primary)
+	}
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+)
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Keep streaming responses (exports) flushing through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Protect admin endpoints with the ADMIN_TOKEN bearer token
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config.String("ADMIN_TOKEN", "")
+		if token == "" {
+			writeError(w, r, http.StatusForbidden, "admin_disabled", "Admin endpoints are disabled")
+			return
+		}
+		if !adminAuthorized(r) {
+			writeError(w, r, http.StatusUnauthorized, "unauthenticated", "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Whether r carries the admin token (never when ADMIN_TOKEN is unset)
+func adminAuthorized(r *http.Request) bool {
+	token := config.String("ADMIN_TOKEN", "")
+	supplied := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) == 1
+}
+
+type requestIDKey struct{}
+
+// Attach a request ID (the client's X-Request-ID when sane, otherwise a
+// random one) to the request context and the response headers
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Context for Firestore calls made on behalf of r. It carries the
+// request's values (request ID, endpoint) but not its cancellation, so a
+// client hanging up doesn't cut a write short.
+func requestContext(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
+
+// POST routes that only read, which the write limiter, throttle and list
+// cache invalidation leave alone
+var readOnlyPosts = map[string]bool{"/users:exists": true}
+
+// Whether r only reads: a GET, HEAD or OPTIONS, or a readOnlyPosts route
+func readOnlyRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return r.Method == http.MethodPost && readOnlyPosts[r.URL.Path]
+}
+
+// Request ID assigned by requestIDMiddleware ("" outside of it)
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Largest request body accepted (MAX_BODY_BYTES). Bodies that declare a
+// larger Content-Length are refused up front; chunked ones fail to read
+// once they pass the limit.
+var maxBodyBytes = config.Int("MAX_BODY_BYTES", 1<<20)
+
+// Routes allowed larger bodies than MAX_BODY_BYTES
+var bodyLimitOverrides = map[string]int64{
+	"/admin/import/zip": zipImportMaxBytes,
+}
+
+// Refuse document IDs a handler could pass on to Firestore as a longer
+// path: an escaped slash in a path segment (which ServeMux hands to
+// handlers unescaped, so /users/a%2Fhistory%2F1 would read a history
+// entry) or an id query parameter that isn't a valid document ID
+func documentIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		valid := !strings.Contains(strings.ToLower(r.URL.EscapedPath()), "%2f")
+		for _, id := range r.URL.Query()["id"] {
+			// A missing ID is the handler's to report
+			valid = valid && (id == "" || store.ValidDocID(id))
+		}
+		if !valid {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "Invalid document ID")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := bodyLimitOverrides[r.URL.Path]
+		if !ok {
+			limit = int64(maxBodyBytes)
+		}
+		if r.ContentLength > limit {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
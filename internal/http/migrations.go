@@ -0,0 +1,276 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A one-off data migration. run reports how many documents it changed (or
+// would change, when dryRun) and must be safe to re-run after a failure.
+type migration struct {
+	id          string
+	description string
+	run         func(s *Server, ctx context.Context, dryRun bool) (int, error)
+}
+
+// Registered migrations, applied in order; never reorder or remove entries
+var migrations = []migration{
+	{
+		id:          "0001_user_field_case",
+		description: "Rename pre-tag user fields (Name, Email, Attributes) to their stored names",
+		run:         (*Server).migrateUserFieldCase,
+	},
+	{
+		id:          "0002_user_plan_default",
+		description: "Store plan: free on users created before plans existed",
+		run:         (*Server).migrateUserPlanDefault,
+	},
+	{
+		id:          "0003_user_derived_fields",
+		description: "Compute derived user fields (emailLower, nameLower, searchTokens)",
+		run:         (*Server).migrateUserDerivedFields,
+	},
+	{
+		id:          "0004_user_created_at_backfill",
+		description: "Store createdAt, from the document's create time, on users without it so orderBy=createdAt lists them",
+		run:         (*Server).migrateUserCreatedAt,
+	},
+	{
+		id:          "0005_user_updated_at_backfill",
+		description: "Store updatedAt, from the document's update time, on users without it so /users/sync returns them",
+		run:         (*Server).migrateUserUpdatedAt,
+	},
+	{
+		id:          nameSortKeyMigrationID(),
+		description: "Recompute nameSortKey for NAME_COLLATION, so orderBy=name follows the locale",
+		run:         (*Server).migrateUserNameSortKey,
+	},
+}
+
+var errMigrationRunning = errors.New("migration already running")
+
+// Applied migrations are recorded in schema_migrations/{id}
+func (s *Server) migrationRef(id string) *firestore.DocumentRef {
+	return s.client.Collection("schema_migrations").Doc(id)
+}
+
+// List migration status (GET /admin/migrations) or start a job applying
+// pending ones in order (POST /admin/migrations?dryRun=true to only count
+// affected documents)
+func (s *Server) migrationsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	switch r.Method {
+	case http.MethodGet:
+		refs := make([]*firestore.DocumentRef, len(migrations))
+		for i, m := range migrations {
+			refs[i] = s.migrationRef(m.id)
+		}
+		docs, errs := s.fetchDocuments(ctx, refs)
+		result := []map[string]interface{}{}
+		for i, m := range migrations {
+			entry := map[string]interface{}{"id": m.id, "description": m.description, "state": "pending"}
+			if errs[i] != nil && errs[i] != errDocumentMissing {
+				writeError(w, r, http.StatusInternalServerError, "internal", "Error reading migrations")
+				return
+			}
+			if errs[i] == nil {
+				for k, v := range docs[i].Data() {
+					entry[k] = v
+				}
+			}
+			result = append(result, entry)
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"migrations": result})
+	case http.MethodPost:
+		active, err := s.client.Collection("jobs").Where("type", "==", "migrations").
+			Where("state", "in", []string{jobQueued, jobRunning}).Limit(1).Documents(ctx).GetAll()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error reading jobs")
+			return
+		}
+		if len(active) > 0 {
+			writeError(w, r, http.StatusConflict, "migration_running", "Migrations are already running in job "+active[0].Ref.ID)
+			return
+		}
+		id, err := s.startJob(ctx, "migrations", map[string]interface{}{"dryRun": dryRunRequested(r)})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error starting migrations")
+			return
+		}
+		writeJobStarted(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+	}
+}
+
+// Apply pending migrations in order, stopping at the first failure.
+// Applied ones are skipped, so a takeover simply starts over.
+func (s *Server) runMigrationsJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	results := []map[string]interface{}{}
+	for i, m := range migrations {
+		n, applied, err := s.applyMigration(ctx, m, run.dryRun())
+		if err == errMigrationRunning {
+			return map[string]interface{}{"ran": results}, fmt.Errorf("migration %s is already running", m.id)
+		}
+		if err != nil {
+			return map[string]interface{}{"ran": results}, fmt.Errorf("migration %s failed: %w", m.id, err)
+		}
+		if !applied {
+			results = append(results, map[string]interface{}{"id": m.id, "documents": n})
+		}
+		run.progress(i+1, len(migrations), m.id)
+	}
+	return map[string]interface{}{"dryRun": run.dryRun(), "ran": results}, nil
+}
+
+// Run m unless it's already recorded as applied. The record is claimed
+// first so two concurrent runs don't both apply it; a failed run leaves a
+// "failed" record that the next run retries. Dry runs record nothing.
+func (s *Server) applyMigration(ctx context.Context, m migration, dryRun bool) (n int, alreadyApplied bool, err error) {
+	ref := s.migrationRef(m.id)
+	doc, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return 0, false, err
+	}
+	if err == nil && doc.Data()["state"] == "applied" {
+		return 0, true, nil
+	}
+	if dryRun {
+		n, err = m.run(s, ctx, true)
+		return n, false, err
+	}
+
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			switch doc.Data()["state"] {
+			case "applied":
+				alreadyApplied = true
+				return nil
+			case "running":
+				return errMigrationRunning
+			}
+		}
+		return tx.Set(ref, map[string]interface{}{"state": "running", "startedAt": time.Now()})
+	})
+	if err != nil || alreadyApplied {
+		return 0, alreadyApplied, err
+	}
+
+	log.Printf("🚚 Running migration %s", m.id)
+	n, runErr := m.run(s, ctx, false)
+	record := map[string]interface{}{"state": "applied", "appliedAt": time.Now(), "documents": n}
+	if runErr != nil {
+		record = map[string]interface{}{"state": "failed", "error": runErr.Error(), "documents": n}
+	}
+	if _, err := ref.Set(ctx, record, firestore.MergeAll); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr == nil {
+		log.Printf("✅ Migration %s applied to %d documents", m.id, n)
+	}
+	return n, false, runErr
+}
+
+// Rewrite user documents (soft-deleted ones included) that still use the
+// Go field names from before User had firestore tags
+func (s *Server) migrateUserFieldCase(ctx context.Context, dryRun bool) (int, error) {
+	return s.rewriteUsers(ctx, dryRun, func(before map[string]interface{}) []firestore.Update {
+		after := maps.Clone(before)
+		if !normalizeUserData(after) {
+			return nil
+		}
+		var updates []firestore.Update
+		for field := range before {
+			if _, ok := after[field]; !ok {
+				updates = append(updates, firestore.Update{Path: field, Value: firestore.Delete})
+			}
+		}
+		for field, value := range after {
+			if _, ok := before[field]; !ok {
+				updates = append(updates, firestore.Update{Path: field, Value: value})
+			}
+		}
+		return updates
+	})
+}
+
+// Store plan: free on users created before plans existed, so ?plan=free
+// finds them
+func (s *Server) migrateUserPlanDefault(ctx context.Context, dryRun bool) (int, error) {
+	return s.rewriteUsers(ctx, dryRun, func(data map[string]interface{}) []firestore.Update {
+		if data["plan"] != nil {
+			return nil
+		}
+		return []firestore.Update{{Path: "plan", Value: string(model.PlanFree)}}
+	})
+}
+
+// Apply the updates change returns for each user document (none to leave
+// it alone). Each update is conditioned on the document's UpdateTime, so
+// a concurrent write - which already stores the current schema - is left
+// alone.
+func (s *Server) rewriteUsers(ctx context.Context, dryRun bool, change func(data map[string]interface{}) []firestore.Update) (int, error) {
+	return s.rewriteUserDocs(ctx, dryRun, func(doc *firestore.DocumentSnapshot) []firestore.Update {
+		return change(doc.Data())
+	})
+}
+
+// rewriteUsers for changes that need the snapshot (e.g. its CreateTime)
+func (s *Server) rewriteUserDocs(ctx context.Context, dryRun bool, change func(doc *firestore.DocumentSnapshot) []firestore.Update) (int, error) {
+	iter := trackIterator("migrations", s.users.Users().Documents(ctx))
+	defer iter.Stop()
+	bw := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	changed := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return changed, err
+		}
+		updates := change(doc)
+		if len(updates) == 0 {
+			continue
+		}
+		changed++
+		if dryRun {
+			continue
+		}
+		job, err := bw.Update(doc.Ref, updates, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			bw.End()
+			return changed, err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	failed := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil && status.Code(err) != codes.FailedPrecondition {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return changed - failed, fmt.Errorf("%d of %d user updates failed", failed, len(jobs))
+	}
+	return changed, nil
+}
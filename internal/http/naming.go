@@ -0,0 +1,187 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// JSON_NAMING picks the key convention of JSON bodies: camel (the struct
+// tags, and the default) or snake. ?case=snake|camel overrides it per request.
+// Only field names are converted: the keys inside attributes are the
+// client's own data and stay exactly as written.
+var jsonNaming = config.String("JSON_NAMING", "camel")
+
+// Objects whose keys are data rather than field names
+var freeFormObjects = map[string]bool{"attributes": true}
+
+// Naming convention for this request's response body
+func responseNaming(r *http.Request) string {
+	switch c := r.URL.Query().Get("case"); c {
+	case "snake", "camel":
+		return c
+	}
+	return jsonNaming
+}
+
+// avatarUrl -> avatar_url, requestID -> request_id
+func snakeCase(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, c := range runes {
+		if unicode.IsUpper(c) {
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) && runes[i-1] != '_' {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// avatar_url -> avatarUrl; keys without underscores are returned as-is
+func camelCase(key string) string {
+	if !strings.Contains(key, "_") || strings.HasPrefix(key, "_") {
+		return key
+	}
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			r := []rune(parts[i])
+			r[0] = unicode.ToUpper(r[0])
+			parts[i] = string(r)
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// Rewrite the object keys of a decoded JSON value: at every depth when
+// deep, otherwise only those of a top-level object. The contents of
+// free-form objects are never rewritten.
+func transformKeys(v interface{}, convert func(string) string, deep bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			name := convert(k)
+			if deep && !freeFormObjects[name] {
+				child = transformKeys(child, convert, deep)
+			}
+			out[name] = child
+		}
+		return out
+	case []interface{}:
+		if deep {
+			for i, child := range t {
+				t[i] = transformKeys(child, convert, deep)
+			}
+		}
+		return t
+	}
+	return v
+}
+
+// Re-encode a JSON document with its keys converted
+func convertJSONKeys(raw []byte, convert func(string) string, deep bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // keep int64 values exact
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(transformKeys(doc, convert, deep))
+}
+
+// ?pretty=true indents a JSON response for reading in a terminal
+func prettyRequested(r *http.Request) bool {
+	return r.URL.Query().Get("pretty") == "true"
+}
+
+// Encode v to w in the request's naming convention and ?tz= zone,
+// indented under ?pretty=true
+func encodeJSON(w io.Writer, r *http.Request, v interface{}) error {
+	snake, loc, pretty := responseNaming(r) == "snake", displayLocation(r), prettyRequested(r)
+	if !snake && loc == nil && !pretty {
+		return json.NewEncoder(w).Encode(v)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if loc != nil {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return err
+		}
+		if raw, err = json.Marshal(convertTimestamps(doc, loc)); err != nil {
+			return err
+		}
+	}
+	if snake {
+		// Responses nest field names (users in lists, history data), so every level is converted
+		if raw, err = convertJSONKeys(raw, snakeCase, true); err != nil {
+			return err
+		}
+	}
+	if pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return err
+		}
+		raw = indented.Bytes()
+	}
+	_, err = w.Write(append(raw, '\n'))
+	return err
+}
+
+// Write a JSON response in the request's naming convention. The body is
+// encoded before the status is sent, so a value that can't be encoded
+// becomes a logged 500 rather than a truncated 200.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	v = markDryRun(w, r, v)
+	var body bytes.Buffer
+	if err := encodeJSON(&body, r, v); err != nil {
+		logCtx(r.Context(), "❌ Failed to encode %s response: %v", r.URL.Path, err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error encoding response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// Read a JSON request body written in either convention, with its
+// top-level keys (the field names) normalized to the camelCase names the
+// struct tags use; nested keys are left alone. An empty body
+// is returned as-is; a non-empty one must be sent as JSON (application/json
+// or a +json type, parameters like charset allowed) or
+// errUnsupportedMediaType is returned.
+func readJSONBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return body, err
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil, errUnsupportedMediaType
+	}
+	return convertJSONKeys(body, camelCase, false)
+}
+
+// Decode a JSON request body written in either convention into v
+func decodeJSON(r *http.Request, v interface{}) error {
+	body, err := readJSONBody(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
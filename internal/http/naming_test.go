@@ -0,0 +1,194 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestCaseConversion(t *testing.T) {
+	tests := []struct{ camel, snake string }{
+		{"name", "name"},
+		{"avatarUrl", "avatar_url"},
+		{"createdAt", "created_at"},
+		{"requestID", "request_id"},
+		{"nextPageToken", "next_page_token"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.camel); got != tt.snake {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.camel, got, tt.snake)
+		}
+	}
+	for _, tt := range tests {
+		if tt.camel == "requestID" {
+			continue // initialisms don't survive the round trip
+		}
+		if got := camelCase(tt.snake); got != tt.camel {
+			t.Errorf("camelCase(%q) = %q, want %q", tt.snake, got, tt.camel)
+		}
+	}
+	for _, key := range []string{"plain", "_private", "alreadyCamel"} {
+		if got := camelCase(key); got != key {
+			t.Errorf("camelCase(%q) = %q, want it unchanged", key, got)
+		}
+	}
+}
+
+// Decode a converted document for comparison
+func convertedJSON(t *testing.T, in string, convert func(string) string, deep bool) interface{} {
+	t.Helper()
+	raw, err := convertJSONKeys([]byte(in), convert, deep)
+	if err != nil {
+		t.Fatalf("convertJSONKeys(%s): %v", in, err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func decodedJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRequestKeysConvertTopLevelOnly(t *testing.T) {
+	tests := []struct{ name, in, want string }{
+		{
+			"field names",
+			`{"name": "Ada", "referred_by": "u1"}`,
+			`{"name": "Ada", "referredBy": "u1"}`,
+		},
+		{
+			"nested attributes keep their keys",
+			`{"attributes": {"cost_center": "x", "team_lead": {"first_name": "Grace"}}}`,
+			`{"attributes": {"cost_center": "x", "team_lead": {"first_name": "Grace"}}}`,
+		},
+		{
+			"arrays inside attributes",
+			`{"attributes": {"past_teams": [{"team_name": "a"}, "b_c"]}}`,
+			`{"attributes": {"past_teams": [{"team_name": "a"}, "b_c"]}}`,
+		},
+		{
+			"nested request objects",
+			`{"add_to_array": {"attributes.tags": ["a_b"]}, "filter": [{"field": "plan", "op": "==", "value": "free"}]}`,
+			`{"addToArray": {"attributes.tags": ["a_b"]}, "filter": [{"field": "plan", "op": "==", "value": "free"}]}`,
+		},
+		{
+			"top-level array",
+			`[{"op": "add", "path": "/attributes/cost_center", "value": {"sub_key": 1}}]`,
+			`[{"op": "add", "path": "/attributes/cost_center", "value": {"sub_key": 1}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertedJSON(t, tt.in, camelCase, false)
+			if want := decodedJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestResponseKeysConvertAtEveryLevelButAttributes(t *testing.T) {
+	in := `{"users": [{"id": "u1", "createdAt": "t", "attributes": {"costCenter": "x", "nestedMap": {"innerKey": 1}, "listOf": [{"itemKey": 2}]}}], "nextPageToken": "p"}`
+	want := `{"users": [{"id": "u1", "created_at": "t", "attributes": {"costCenter": "x", "nestedMap": {"innerKey": 1}, "listOf": [{"itemKey": 2}]}}], "next_page_token": "p"}`
+	got := convertedJSON(t, in, snakeCase, true)
+	if w := decodedJSON(t, want); !reflect.DeepEqual(got, w) {
+		t.Errorf("got %v, want %v", got, w)
+	}
+}
+
+func TestConvertJSONKeysKeepsLargeIntegers(t *testing.T) {
+	raw, err := convertJSONKeys([]byte(`{"login_count": 9007199254740993}`), camelCase, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(raw, []byte(`"loginCount":9007199254740993`)) {
+		t.Errorf("got %s, want the integer unchanged", raw)
+	}
+}
+
+func TestDecodeJSONSnakeCaseUser(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/addUser", strings.NewReader(
+		`{"name": "Ada", "email": "ada@example.com", "attributes": {"cost_center": "x", "costCenter": "y"}}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	var user model.User
+	if err := decodeJSON(r, &user); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"cost_center": "x", "costCenter": "y"}
+	if !reflect.DeepEqual(user.Attributes, want) {
+		t.Errorf("attributes = %v, want %v", user.Attributes, want)
+	}
+}
+
+func TestReadJSONBodyContentType(t *testing.T) {
+	for _, tt := range []struct {
+		contentType string
+		ok          bool
+	}{
+		{"application/json", true},
+		{"application/merge-patch+json", true},
+		{"text/plain", false},
+		{"", false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/addUser", strings.NewReader(`{"name": "Ada"}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		_, err := readJSONBody(r)
+		if got := err == nil; got != tt.ok {
+			t.Errorf("Content-Type %q: err = %v, want ok %v", tt.contentType, err, tt.ok)
+		}
+		if !tt.ok && err != errUnsupportedMediaType {
+			t.Errorf("Content-Type %q: err = %v, want errUnsupportedMediaType", tt.contentType, err)
+		}
+	}
+}
+
+func TestWriteJSONSnakeCase(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/u1?case=snake", nil)
+	rec := httptest.NewRecorder()
+	writeJSON(rec, r, http.StatusOK, map[string]interface{}{
+		"nextPageToken": "p",
+		"attributes":    map[string]interface{}{"costCenter": "x"},
+	})
+	got := decodedJSON(t, rec.Body.String())
+	want := decodedJSON(t, `{"next_page_token": "p", "attributes": {"costCenter": "x"}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPathsCamelCaseOnlyTheFieldName(t *testing.T) {
+	tokens, err := parsePointer("/attributes/cost_center")
+	if err != nil || !reflect.DeepEqual(tokens, []string{"attributes", "cost_center"}) {
+		t.Errorf("parsePointer = %v, %v; want [attributes cost_center]", tokens, err)
+	}
+	tokens, err = parsePointer("/referred_by")
+	if err != nil || !reflect.DeepEqual(tokens, []string{"referredBy"}) {
+		t.Errorf("parsePointer = %v, %v; want [referredBy]", tokens, err)
+	}
+
+	sel, err := parseFieldSelection("name,attributes.cost_center")
+	if err != nil || !reflect.DeepEqual(sel, fieldSelection{{"name"}, {"attributes", "cost_center"}}) {
+		t.Errorf("parseFieldSelection = %v, %v", sel, err)
+	}
+
+	paths, problems := parseUpdateMask("attributes.cost_center.floor_no,name")
+	if len(problems) > 0 || !reflect.DeepEqual(paths, [][]string{{"attributes", "cost_center", "floor_no"}, {"name"}}) {
+		t.Errorf("parseUpdateMask = %v, %v", paths, problems)
+	}
+}
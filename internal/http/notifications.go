@@ -0,0 +1,331 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Notification stored under users/{id}/notifications
+type Notification struct {
+	Title     string     `json:"title" firestore:"title"`
+	Body      string     `json:"body" firestore:"body"`
+	CreatedAt time.Time  `json:"createdAt" firestore:"createdAt"`
+	ReadAt    *time.Time `json:"readAt" firestore:"readAt"`
+}
+
+var notificationRetention = config.Duration("NOTIFICATION_RETENTION", 30*24*time.Hour)
+
+func (s *Server) notificationsCollection(userID string) *firestore.CollectionRef {
+	return s.client.Collection("users").Doc(userID).Collection("notifications")
+}
+
+// Parse ?pageSize= with a default and an upper bound
+func pageSizeParam(r *http.Request, def, max int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || n <= 0 {
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// Create a notification (POST /users/{id}/notifications, admin only)
+func (s *Server) createNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	var n Notification
+	if err := decodeJSON(r, &n); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(n.Title) == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Notification title required")
+		return
+	}
+	n.CreatedAt = time.Now().UTC()
+	n.ReadAt = nil
+
+	ctx := requestContext(r)
+	_, err := s.client.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error reading user")
+		return
+	}
+	id := dryRunID
+	if !dryRunRequested(r) {
+		docRef, _, err := s.notificationsCollection(userID).Add(ctx, n)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error creating notification")
+			return
+		}
+		id = docRef.ID
+	}
+
+	writeJSON(w, r, http.StatusCreated, NotificationCreated{Message: "Notification created", NotificationEntry: NotificationEntry{ID: id, Notification: n}})
+}
+
+// List a user's notifications newest-first (GET /users/{id}/notifications?pageSize=&pageToken=)
+//
+// pageToken takes either nextPageToken or prevPageToken from a previous page.
+func (s *Server) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	pageSize := pageSizeParam(r, 20, 100)
+
+	ctx := requestContext(r)
+	col := s.notificationsCollection(userID)
+	// The document ID breaks createdAt ties so cursors are exact
+	query := col.OrderBy("createdAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(pageSize)
+	cur, err := pageCursor(r, nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+	if cur != nil {
+		var createdAt time.Time
+		if len(cur.Values) == 1 {
+			createdAt, err = time.Parse(time.RFC3339Nano, cur.Values[0])
+		}
+		if len(cur.Values) != 1 || err != nil || cur.LastID == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+			return
+		}
+		if cur.Backward {
+			query = query.EndBefore(createdAt, cur.LastID).LimitToLast(pageSize)
+		} else {
+			query = query.StartAfter(createdAt, cur.LastID)
+		}
+	}
+
+	notifications := []NotificationEntry{}
+	// LimitToLast queries can't be streamed, so pages are read with GetAll
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing notifications")
+		return
+	}
+	var first, last cursor.Cursor
+	for i, doc := range docs {
+		var n Notification
+		doc.DataTo(&n)
+		notifications = append(notifications, NotificationEntry{ID: doc.Ref.ID, Notification: n})
+		boundary := cursor.Cursor{LastID: doc.Ref.ID, Values: []string{n.CreatedAt.UTC().Format(time.RFC3339Nano)}}
+		if i == 0 {
+			first = boundary
+		}
+		last = boundary
+	}
+
+	response := NotificationPage{Notifications: notifications}
+	response.NextPageToken, response.PrevPageToken = pageTokens(r, cur, nil, first, last, len(notifications), pageSize)
+	response.Links = pageLinks(r, response.NextPageToken, response.PrevPageToken)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// Mark one notification as read (POST /users/{id}/notifications/{nid}:markRead)
+func (s *Server) markNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	notificationID, ok := strings.CutSuffix(r.PathValue("nid"), ":markRead")
+	if !ok || notificationID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := requestContext(r)
+	ref := s.notificationsCollection(userID).Doc(notificationID)
+	var err error
+	if dryRunRequested(r) {
+		_, err = ref.Get(ctx) // Update would fail the same way on a missing notification
+	} else {
+		_, err = ref.Update(ctx, []firestore.Update{{Path: "readAt", Value: time.Now().UTC()}})
+	}
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "notification_not_found", "Notification not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error updating notification")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, MessageResponse{Message: "Notification marked as read", ID: notificationID})
+}
+
+// Mark every unread notification as read (POST /users/{id}/notifications:markAllRead)
+func (s *Server) markAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	ctx := requestContext(r)
+	dryRun := dryRunRequested(r)
+	now := time.Now().UTC()
+	iter := trackIterator("markAllRead", s.notificationsCollection(userID).Where("readAt", "==", nil).Documents(ctx))
+	defer iter.Stop()
+
+	updated := 0
+	batch := s.client.Batch()
+	pending := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error updating notifications")
+			return
+		}
+		if dryRun {
+			updated++
+			continue
+		}
+		batch.Update(doc.Ref, []firestore.Update{{Path: "readAt", Value: now}})
+		pending++
+		// Firestore caps a batch at 500 writes
+		if pending == 500 {
+			if _, err := batch.Commit(ctx); err != nil {
+				writeError(w, r, http.StatusInternalServerError, "internal", "Error updating notifications")
+				return
+			}
+			updated += pending
+			batch = s.client.Batch()
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error updating notifications")
+			return
+		}
+		updated += pending
+	}
+
+	writeJSON(w, r, http.StatusOK, NotificationsMarkedRead{Message: "Notifications marked as read", Updated: updated})
+}
+
+// Count unread notifications with an aggregation query (GET /users/{id}/notifications/unreadCount)
+func (s *Server) unreadNotificationCountHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	ctx := requestContext(r)
+	query := s.notificationsCollection(userID).Where("readAt", "==", nil)
+	result, err := query.NewAggregationQuery().WithCount("unread").Get(ctx)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error counting notifications")
+		return
+	}
+
+	var unread int64
+	if v, ok := result["unread"].(interface{ GetIntegerValue() int64 }); ok {
+		unread = v.GetIntegerValue()
+	}
+	writeJSON(w, r, http.StatusOK, UnreadCountResponse{ID: userID, Unread: unread})
+}
+
+// Stream a user's notification changes as server-sent events
+// (GET /users/{id}/notifications/stream) until the client goes away. Each
+// event is "created", "updated" or "deleted" with {"id", "notification"}
+// as its data; notifications that exist when the response starts aren't
+// sent.
+func (s *Server) streamNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Streaming unsupported")
+		return
+	}
+
+	// Not requestContext: the stream ends with the request
+	ctx := r.Context()
+	_, err := s.client.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error reading user")
+		return
+	}
+
+	// The response starts once the listener has the current state, so
+	// anything created after it arrives is sent
+	snapshots := s.notificationsCollection(userID).Snapshots(ctx)
+	defer snapshots.Stop()
+	if _, err := snapshots.Next(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error watching notifications")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		snap, err := snapshots.Next()
+		if err != nil {
+			if ctx.Err() == nil {
+				logCtx(ctx, "⚠️ Notification stream for %s failed: %v", userID, err)
+			}
+			return
+		}
+		for _, change := range snap.Changes {
+			event := "updated"
+			switch change.Kind {
+			case firestore.DocumentAdded:
+				event = "created"
+			case firestore.DocumentRemoved:
+				event = "deleted"
+			}
+			var n Notification
+			change.Doc.DataTo(&n)
+			data, err := json.Marshal(NotificationEntry{ID: change.Doc.Ref.ID, Notification: n})
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Delete read notifications older than NOTIFICATION_RETENTION; scheduled
+// by NOTIFICATION_PRUNE_SCHEDULE (see scheduler.go). The collection-group
+// query needs the readAt single-field index enabled for collection group
+// scope.
+func (s *Server) runPruneNotificationsJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	if run.dryRun() {
+		return map[string]interface{}{"dryRun": true}, nil
+	}
+	n, err := s.pruneReadNotifications(ctx)
+	if n > 0 {
+		log.Printf("🧹 Pruned %d read notifications", n)
+	}
+	return map[string]interface{}{"deleted": n}, err
+}
+
+func (s *Server) pruneReadNotifications(ctx context.Context) (int, error) {
+	p := retentionPolicy{collection: "notifications", retentionTarget: retentionTargets["notifications"], maxAge: notificationRetention}
+	_, deleted, err := s.enforceRetention(ctx, p, false, nil)
+	return deleted, err
+}
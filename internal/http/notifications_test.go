@@ -0,0 +1,63 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// The stream sends each notification created after it opens as an event
+// and ends when the client goes away
+func TestStreamNotifications(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if _, _, err := s.notificationsCollection(id).Add(ctx, Notification{Title: "Before", CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}/notifications/stream", s.streamNotificationsHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/users/missing/notifications/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing user = %d, want 404", resp.StatusCode)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(streamCtx, http.MethodGet, srv.URL+"/users/"+id+"/notifications/stream", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("stream = %d %s", resp.StatusCode, ct)
+	}
+
+	if _, _, err := s.notificationsCollection(id).Add(ctx, Notification{Title: "After", CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	if len(event) != 2 || event[0] != "event: created" || !strings.Contains(event[1], `"title":"After"`) {
+		t.Errorf("event = %q, want the created notification", event)
+	}
+
+	// srv.Close waits for the handler, so it must return once cancelled
+	cancel()
+}
@@ -0,0 +1,365 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EventSink delivers outbox events to an external system
+type EventSink interface {
+	Name() string
+	Deliver(ctx context.Context, event OutboxEvent) error
+}
+
+// Configured sinks (OUTBOX_SINKS); events are only written when there is one
+var eventSinks []EventSink
+
+// Outbox states: pending until every sink accepted the event, failed
+// (dead-lettered) after OUTBOX_MAX_ATTEMPTS
+const (
+	outboxPending   = "pending"
+	outboxDelivered = "delivered"
+	outboxFailed    = "failed"
+)
+
+var (
+	outboxPollInterval = config.Duration("OUTBOX_POLL_INTERVAL", 2*time.Second)
+	outboxConcurrency  = config.Int("OUTBOX_CONCURRENCY", 4)
+	outboxBatchSize    = config.Int("OUTBOX_BATCH_SIZE", 50)
+	outboxMaxAttempts  = config.Int("OUTBOX_MAX_ATTEMPTS", 8)
+	outboxLease        = config.Duration("OUTBOX_LEASE", 30*time.Second)
+	outboxOwner        = newLeaseOwner() // identifies this replica in leases
+)
+
+var (
+	errLeaseLost       = errors.New("outbox lease held by another dispatcher")
+	errOutboxNotFailed = errors.New("outbox event is not failed")
+)
+
+var outboxHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// OutboxEvent is one record in the outbox collection
+type OutboxEvent struct {
+	ID            string                 `json:"id" firestore:"-"`
+	Type          string                 `json:"type" firestore:"type"` // user.created, user.updated, user.deleted, job.failed
+	UserID        string                 `json:"userId" firestore:"userId"`
+	Data          map[string]interface{} `json:"data,omitempty" firestore:"data,omitempty"`
+	Previous      map[string]interface{} `json:"-" firestore:"previous,omitempty"` // the user before an update, for field subscriptions
+	Actor         string                 `json:"actor,omitempty" firestore:"actor,omitempty"`
+	State         string                 `json:"state" firestore:"state"`
+	Attempts      int                    `json:"attempts" firestore:"attempts"`
+	LastError     string                 `json:"lastError,omitempty" firestore:"lastError,omitempty"`
+	NextAttemptAt time.Time              `json:"nextAttemptAt" firestore:"nextAttemptAt"`
+	LeaseOwner    string                 `json:"-" firestore:"leaseOwner"`
+	LeaseUntil    time.Time              `json:"-" firestore:"leaseUntil"`
+	CreatedAt     time.Time              `json:"createdAt" firestore:"createdAt"`
+	DeliveredAt   *time.Time             `json:"deliveredAt,omitempty" firestore:"deliveredAt"`
+}
+
+func newLeaseOwner() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Build the sinks from config and start the dispatcher
+func (s *Server) initOutbox(bg *background) {
+	for _, name := range strings.Split(config.String("OUTBOX_SINKS", ""), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "webhook":
+			eventSinks = append(eventSinks, &webhookSink{url: config.String("OUTBOX_WEBHOOK_URL", ""), secret: config.String("OUTBOX_WEBHOOK_SECRET", "")})
+		case "slack":
+			eventSinks = append(eventSinks, newSlackSink())
+		case "subscriptions":
+			eventSinks = append(eventSinks, &subscriptionSink{webhooks: s.client.Collection("webhooks"), delivered: map[string]time.Time{}})
+		default:
+			log.Fatalf("Unknown outbox sink %q", name)
+		}
+	}
+	if len(eventSinks) == 0 {
+		return
+	}
+	bg.Go(s.runOutboxDispatcher)
+	fmt.Println("📮 Outbox dispatcher enabled:", config.String("OUTBOX_SINKS", ""))
+}
+
+// Write an event as part of a transaction so it commits with the change it
+// describes; a no-op when no sink is configured
+func (s *Server) recordOutboxTx(tx *firestore.Transaction, eventType, userID, actor string, data map[string]interface{}) error {
+	return s.recordOutboxChangeTx(tx, eventType, userID, actor, data, nil)
+}
+
+// recordOutboxTx for an update, keeping the user as it was before so
+// subscriptions can tell which fields changed
+func (s *Server) recordOutboxChangeTx(tx *firestore.Transaction, eventType, userID, actor string, data, previous map[string]interface{}) error {
+	if len(eventSinks) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	return tx.Create(s.client.Collection("outbox").NewDoc(), OutboxEvent{
+		Type:          eventType,
+		UserID:        userID,
+		Data:          data,
+		Previous:      previous,
+		Actor:         actor,
+		State:         outboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+}
+
+func (s *Server) runOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !flags.enabled(flagEvents) {
+			continue
+		}
+		if err := s.dispatchOutbox(withEndpoint(ctx, "outbox")); err != nil {
+			log.Printf("⚠️ Outbox dispatch failed: %v", err)
+		}
+	}
+}
+
+// Deliver one batch of due events. Each event is leased before delivery so
+// replicas polling the same outbox don't send it twice; a lease that
+// expires (its holder died) makes the event due again. Needs a composite
+// index on state + nextAttemptAt.
+func (s *Server) dispatchOutbox(ctx context.Context) error {
+	due, err := s.client.Collection("outbox").
+		Where("state", "==", outboxPending).
+		Where("nextAttemptAt", "<=", time.Now()).
+		OrderBy("nextAttemptAt", firestore.Asc).
+		Limit(outboxBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(outboxConcurrency)
+	for _, doc := range due {
+		ref := doc.Ref
+		g.Go(func() error {
+			event, err := s.leaseOutboxEvent(ctx, ref)
+			if err == errLeaseLost {
+				return nil
+			}
+			if err != nil {
+				log.Printf("⚠️ Failed to lease outbox event %s: %v", ref.ID, err)
+				return nil
+			}
+			s.deliverOutboxEvent(ctx, ref, event)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// Claim ref for this replica unless another holds an unexpired lease
+func (s *Server) leaseOutboxEvent(ctx context.Context, ref *firestore.DocumentRef) (OutboxEvent, error) {
+	var event OutboxEvent
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&event); err != nil {
+			return err
+		}
+		now := time.Now()
+		if event.State != outboxPending || event.NextAttemptAt.After(now) || event.LeaseUntil.After(now) {
+			return errLeaseLost
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "leaseOwner", Value: outboxOwner},
+			{Path: "leaseUntil", Value: now.Add(outboxLease)},
+		})
+	})
+	event.ID = ref.ID
+	return event, err
+}
+
+// Send event to every sink and record the outcome under our lease
+func (s *Server) deliverOutboxEvent(ctx context.Context, ref *firestore.DocumentRef, event OutboxEvent) {
+	var failures []string
+	for _, sink := range eventSinks {
+		sendCtx, cancel := context.WithTimeout(ctx, outboxLease/2)
+		if err := sink.Deliver(sendCtx, event); err != nil {
+			failures = append(failures, sink.Name()+": "+err.Error())
+		}
+		cancel()
+	}
+
+	now := time.Now().UTC()
+	updates := []firestore.Update{
+		{Path: "leaseOwner", Value: ""},
+		{Path: "leaseUntil", Value: time.Time{}},
+		{Path: "attempts", Value: event.Attempts + 1},
+	}
+	if len(failures) == 0 {
+		updates = append(updates, firestore.Update{Path: "state", Value: outboxDelivered}, firestore.Update{Path: "deliveredAt", Value: now})
+	} else {
+		lastError := strings.Join(failures, "; ")
+		updates = append(updates, firestore.Update{Path: "lastError", Value: lastError})
+		if event.Attempts+1 >= outboxMaxAttempts {
+			updates = append(updates, firestore.Update{Path: "state", Value: outboxFailed})
+			log.Printf("⚠️ Outbox event %s dead-lettered after %d attempts: %s", ref.ID, event.Attempts+1, lastError)
+		} else {
+			updates = append(updates, firestore.Update{Path: "nextAttemptAt", Value: now.Add(outboxBackoff(event.Attempts + 1))})
+		}
+	}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if owner, _ := doc.Data()["leaseOwner"].(string); owner != outboxOwner {
+			return errLeaseLost
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to record outbox delivery of %s: %v", ref.ID, err)
+	}
+}
+
+// 1s, 2s, 4s, ... capped at 10m
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second << min(attempts-1, 10)
+	if d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
+
+// webhookSink POSTs each event as JSON, signed with an HMAC-SHA256 of the
+// body in X-Signature when a secret is set
+type webhookSink struct {
+	url    string
+	secret string
+}
+
+func (s *webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Deliver(ctx context.Context, event OutboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postWebhook(ctx, s.url, s.secret, event.ID, body)
+}
+
+// X-Signature value for body: "sha256=" and its hex HMAC-SHA256 under secret
+func hmacSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// POST body to url, signed with secret when set
+func postWebhook(ctx context.Context, url, secret, eventID string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", eventID) // lets receivers drop redeliveries
+	if secret != "" {
+		req.Header.Set("X-Signature", hmacSignature(secret, body))
+	}
+	resp, err := outboxHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// List outbox events in a state, failed by default (GET /admin/outbox?state=)
+func (s *Server) listOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = outboxFailed
+	}
+	if state != outboxPending && state != outboxDelivered && state != outboxFailed {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "state must be pending, delivered or failed")
+		return
+	}
+	docs, err := s.client.Collection("outbox").Where("state", "==", state).
+		Limit(pageSizeParam(r, 50, 500)).Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing outbox")
+		return
+	}
+	events := []OutboxEvent{}
+	for _, doc := range docs {
+		var event OutboxEvent
+		doc.DataTo(&event)
+		event.ID = doc.Ref.ID
+		events = append(events, event)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"state": state, "events": events})
+}
+
+// Requeue a dead-lettered event with a fresh attempt count (POST /admin/outbox/{id}:retry)
+func (s *Server) retryOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(r.PathValue("id"), ":retry")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	ref := s.client.Collection("outbox").Doc(id)
+	err := s.runTransaction(requestContext(r), dryRunRequested(r), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if doc.Data()["state"] != outboxFailed {
+			return errOutboxNotFailed
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "state", Value: outboxPending},
+			{Path: "attempts", Value: 0},
+			{Path: "nextAttemptAt", Value: time.Now().UTC()},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "event_not_found", "Outbox event not found")
+		return
+	}
+	if err == errOutboxNotFailed {
+		writeError(w, r, http.StatusConflict, "conflict", "Only failed events can be retried")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error retrying event")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, MessageResponse{Message: "Event requeued", ID: id})
+}
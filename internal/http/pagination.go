@@ -0,0 +1,64 @@
+package http
+
+import (
+	"crypto/rand"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// CURSOR_SECRET signs page tokens. Without it a random key is generated at
+// startup, so tokens stop working across restarts and between replicas.
+// CURSOR_TTL bounds how long a token stays valid.
+var (
+	cursorKey   = cursorSecret()
+	pageCursors = cursor.New(cursorKey, config.Duration("CURSOR_TTL", 24*time.Hour))
+)
+
+func cursorSecret() []byte {
+	if s := config.String("CURSOR_SECRET", ""); s != "" {
+		return []byte(s)
+	}
+	log.Printf("⚠️ CURSOR_SECRET not set; page tokens will not survive a restart")
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// Decode ?pageToken= for a list whose result set is fixed by the request
+// path and filters. A nil cursor means the first page.
+func pageCursor(r *http.Request, filters map[string]string) (*cursor.Cursor, error) {
+	token := r.URL.Query().Get("pageToken")
+	if token == "" {
+		return nil, nil
+	}
+	cur, err := pageCursors.Decode(token, cursor.FilterHash(r.URL.Path, filters))
+	if err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// Signed tokens for the pages around one that returned count items
+// bounded by first and last. A full page means more may follow in the
+// direction of travel; having moved at all means the other side exists.
+func pageTokens(r *http.Request, cur *cursor.Cursor, filters map[string]string, first, last cursor.Cursor, count, pageSize int) (next, prev string) {
+	if count == 0 {
+		return "", ""
+	}
+	hash := cursor.FilterHash(r.URL.Path, filters)
+	backward := cur != nil && cur.Backward
+	full := count == pageSize
+	if full || backward {
+		last.FilterHash = hash
+		next = pageCursors.Encode(last)
+	}
+	if backward && full || !backward && cur != nil {
+		first.FilterHash, first.Backward = hash, true
+		prev = pageCursors.Encode(first)
+	}
+	return next, prev
+}
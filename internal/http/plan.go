@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var plans = newEnum("plan", model.PlanFree, model.PlanPro, model.PlanEnterprise)
+
+// Canonicalize user.Plan from input, defaulting it to free when unset.
+// Returns the field error for a value outside the set.
+func normalizeUserPlan(user *model.User) *FieldError {
+	if user.Plan == "" {
+		user.Plan = model.PlanFree
+		return nil
+	}
+	plan, ok := plans.parse(string(user.Plan))
+	if !ok {
+		fe := plans.fieldError(string(user.Plan))
+		return &fe
+	}
+	user.Plan = plan
+	return nil
+}
+
+// normalizeUserPlan for a user document's data, e.g. an imported one
+func canonicalizePlanData(data map[string]interface{}) error {
+	raw, present := data["plan"]
+	s, isString := raw.(string)
+	if present && !isString {
+		return fmt.Errorf("plan must be %s", plans.allowed())
+	}
+	user := model.User{Plan: model.Plan(s)}
+	if fe := normalizeUserPlan(&user); fe != nil {
+		return fmt.Errorf("%s %s", fe.Field, fe.Message)
+	}
+	data["plan"] = string(user.Plan)
+	return nil
+}
+
+func writeInvalidEnum(w http.ResponseWriter, r *http.Request, fe FieldError) {
+	writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", fe.Field+" "+fe.Message, fe)
+}
+
+// changePlanRequest is the body of POST /users/{id}:changePlan
+type changePlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// Move a user to another plan (POST /users/{id}:changePlan). The history
+// entry's details hold the old and new plan and when the change took
+// effect; changing to the current plan records nothing.
+func (s *Server) changePlanHandler(w http.ResponseWriter, r *http.Request) {
+	var req changePlanRequest
+	if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	plan, ok := plans.parse(req.Plan)
+	if !ok {
+		writeInvalidEnum(w, r, plans.fieldError(req.Plan))
+		return
+	}
+
+	id := r.PathValue("id")
+	ref := s.users.Users().Doc(id)
+	actor := actorFromRequest(r, "anonymous")
+	effectiveAt := time.Now().UTC()
+	var previous model.Plan
+	err := s.runTransaction(withIfMatch(requestContext(r), r), dryRunRequested(r), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(ctx, doc); err != nil {
+			return err
+		}
+		if previous = userFromDoc(doc).Plan; previous == plan {
+			return nil
+		}
+		latest, err := latestHistoryTx(tx, ref)
+		if err != nil {
+			return err
+		}
+		if err := tx.Update(ref, withUpdatedAt([]firestore.Update{{Path: "plan", Value: string(plan)}, historyVersionUpdate(latest)})); err != nil {
+			return err
+		}
+		data := doc.Data()
+		data["plan"] = string(plan)
+		if err := s.recordOutboxChangeTx(tx, "user.updated", id, actor, data, doc.Data()); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{Op: "changePlan", Data: data, Previous: doc.Data(), Actor: actor,
+			Details: map[string]interface{}{"oldPlan": string(previous), "newPlan": string(plan), "effectiveAt": effectiveAt}})
+	})
+	forgetDocumentRead(ref)
+	if err == store.ErrUserNotFound {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if err == errPreconditionFailed {
+		writePreconditionFailed(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error changing plan")
+		return
+	}
+	response := map[string]interface{}{"id": id, "plan": plan, "previousPlan": previous, "changed": previous != plan}
+	if previous != plan {
+		response["effectiveAt"] = effectiveAt
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,229 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Preferences stored at users/{id}/private/preferences
+type Preferences struct {
+	Locale             string `json:"locale" firestore:"locale"`
+	Timezone           string `json:"timezone" firestore:"timezone"`
+	EmailOptIn         bool   `json:"emailOptIn" firestore:"emailOptIn"`
+	EmailNotifications bool   `json:"emailNotifications" firestore:"emailNotifications"`
+	PushNotifications  bool   `json:"pushNotifications" firestore:"pushNotifications"`
+}
+
+// Preferences returned before a user has saved any
+func defaultPreferences() Preferences {
+	return Preferences{
+		Locale:             "en-US",
+		Timezone:           "UTC",
+		EmailOptIn:         false,
+		EmailNotifications: true,
+		PushNotifications:  true,
+	}
+}
+
+func (s *Server) preferencesDoc(userID string) *firestore.DocumentRef {
+	return s.users.Users().Doc(userID).Collection("private").Doc("preferences")
+}
+
+// The JSON names of the Preferences fields, the only keys a PATCH may set
+var preferenceFields = map[string]bool{
+	"locale":             true,
+	"timezone":           true,
+	"emailOptIn":         true,
+	"emailNotifications": true,
+	"pushNotifications":  true,
+}
+
+// Answer 404 unless the user exists and isn't soft-deleted
+func (s *Server) requirePreferencesUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	doc, err := getDocument(requestContext(r), s.users.Users().Doc(userID))
+	if status.Code(err) == codes.NotFound || (err == nil && isSoftDeleted(doc)) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading user")
+		return false
+	}
+	return true
+}
+
+// Check locale and timezone, returning the offending field and a valid example
+func (p Preferences) validate() (field, example string, ok bool) {
+	if _, err := language.Parse(p.Locale); err != nil || p.Locale == "" {
+		return "locale", "en-US", false
+	}
+	if p.Timezone == "" || p.Timezone == "Local" {
+		return "timezone", "Europe/Berlin", false
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return "timezone", "Europe/Berlin", false
+	}
+	return "", "", true
+}
+
+// Load saved preferences layered over the defaults
+func (s *Server) loadPreferences(ctx context.Context, userID string) (Preferences, bool, error) {
+	prefs := defaultPreferences()
+	doc, err := s.preferencesDoc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return prefs, false, nil
+	}
+	if err != nil {
+		return prefs, false, err
+	}
+	if err := doc.DataTo(&prefs); err != nil {
+		return prefs, false, err
+	}
+	return prefs, true, nil
+}
+
+func writePreferencesResponse(w http.ResponseWriter, r *http.Request, userID string, prefs Preferences, saved bool) {
+	writeJSON(w, r, http.StatusOK, PreferencesResponse{ID: userID, Preferences: prefs, Default: !saved})
+}
+
+func invalidPreferenceError(w http.ResponseWriter, r *http.Request, field, example string) {
+	msg := fmt.Sprintf("Invalid value for field %q (example of a valid value: %q)", field, example)
+	writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", msg,
+		FieldError{Field: field, Message: fmt.Sprintf("invalid value; example of a valid value: %q", example)})
+}
+
+// Get a user's preferences (GET /users/{id}/preferences)
+func (s *Server) getPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if !s.requirePreferencesUser(w, r, userID) {
+		return
+	}
+
+	prefs, saved, err := s.loadPreferences(requestContext(r), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading preferences")
+		return
+	}
+	writePreferencesResponse(w, r, userID, prefs, saved)
+}
+
+// Replace a user's preferences (PUT /users/{id}/preferences); omitted keys reset to defaults
+func (s *Server) putPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	prefs := defaultPreferences()
+	body, err := readJSONBody(r)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&prefs); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if field, example, ok := prefs.validate(); !ok {
+		invalidPreferenceError(w, r, field, example)
+		return
+	}
+	if !s.requirePreferencesUser(w, r, userID) {
+		return
+	}
+
+	if dryRunRequested(r) {
+		writePreferencesResponse(w, r, userID, prefs, true)
+		return
+	}
+	if _, err := s.preferencesDoc(userID).Set(requestContext(r), prefs); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error saving preferences")
+		return
+	}
+	writePreferencesResponse(w, r, userID, prefs, true)
+}
+
+// Merge only the provided keys into a user's preferences (PATCH /users/{id}/preferences)
+func (s *Server) patchPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	body, err := readJSONBody(r)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || len(patch) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	// The decoder below matches keys case-insensitively; the merge writes
+	// them as sent, so anything but the exact field name is refused
+	for key := range patch {
+		if !preferenceFields[key] {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Unknown preference %q", key))
+			return
+		}
+	}
+	if !s.requirePreferencesUser(w, r, userID) {
+		return
+	}
+
+	ctx := requestContext(r)
+	prefs, _, err := s.loadPreferences(ctx, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading preferences")
+		return
+	}
+	// Unmarshalling over the current values only touches the provided keys
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&prefs); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if field, example, ok := prefs.validate(); !ok {
+		invalidPreferenceError(w, r, field, example)
+		return
+	}
+
+	// Only the provided keys are written (MergeAll), and each is validated
+	// on its own, so a concurrent PATCH of other keys can't be lost or
+	// combine into an invalid whole; no transaction needed
+	merged := map[string]interface{}{}
+	all := map[string]interface{}{
+		"locale":             prefs.Locale,
+		"timezone":           prefs.Timezone,
+		"emailOptIn":         prefs.EmailOptIn,
+		"emailNotifications": prefs.EmailNotifications,
+		"pushNotifications":  prefs.PushNotifications,
+	}
+	for key := range patch {
+		merged[key] = all[key]
+	}
+	if dryRunRequested(r) {
+		writePreferencesResponse(w, r, userID, prefs, true)
+		return
+	}
+	if _, err := s.preferencesDoc(userID).Set(ctx, merged, firestore.MergeAll); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error saving preferences")
+		return
+	}
+	writePreferencesResponse(w, r, userID, prefs, true)
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestPatchPreferencesRefusesMiscasedKeys(t *testing.T) {
+	s := newTestServer(t)
+	for _, body := range []string{`{"Locale": "de-DE"}`, `{"locale": "de-DE", "TIMEZONE": "Europe/Berlin"}`, `{"theme": "dark"}`} {
+		r := jsonRequest(http.MethodPatch, "/users/u1/preferences", body)
+		r.SetPathValue("id", "u1")
+		serveError(t, s.patchPreferencesHandler, r, http.StatusBadRequest, "invalid_body")
+	}
+}
+
+func TestPatchPreferencesWritesOnlyExactKeys(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+
+	r := jsonRequest(http.MethodPatch, "/users/"+id+"/preferences", `{"locale": "de-DE"}`)
+	r.SetPathValue("id", id)
+	serveJSON(t, s.patchPreferencesHandler, r, http.StatusOK)
+
+	doc, err := s.preferencesDoc(id).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Data(); len(got) != 1 || got["locale"] != "de-DE" {
+		t.Errorf("stored preferences = %v, want only locale de-DE", got)
+	}
+}
+
+func TestPreferencesOfMissingUser(t *testing.T) {
+	s, _ := useEmulator(t)
+	for _, tt := range []struct {
+		h      http.HandlerFunc
+		method string
+		body   string
+	}{
+		{s.getPreferencesHandler, http.MethodGet, ""},
+		{s.putPreferencesHandler, http.MethodPut, `{"locale": "de-DE", "timezone": "Europe/Berlin"}`},
+		{s.patchPreferencesHandler, http.MethodPatch, `{"locale": "de-DE"}`},
+	} {
+		r := jsonRequest(tt.method, "/users/nobody/preferences", tt.body)
+		r.SetPathValue("id", "nobody")
+		serveError(t, tt.h, r, http.StatusNotFound, "user_not_found")
+	}
+}
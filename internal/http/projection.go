@@ -0,0 +1,158 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// fieldSelection is a parsed ?fields= list of User JSON paths such as
+// "name" or "attributes.department". nil selects every field; the id is
+// part of the envelope and always returned.
+type fieldSelection [][]string
+
+// Parse and validate ?fields= against the User schema
+func parseFieldSelection(raw string) (fieldSelection, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	sel := fieldSelection{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "id" {
+			continue
+		}
+		path := strings.Split(item, ".")
+		if slices.Contains(path, "") {
+			return nil, fmt.Errorf("invalid field path %q", item)
+		}
+		path[0] = camelCase(path[0]) // accept snake_case field names like request bodies
+		kind, ok := userFieldKind(path[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", item)
+		}
+		if len(path) > 1 && kind != reflect.Map {
+			return nil, fmt.Errorf("field %q has no nested fields", path[0])
+		}
+		sel = append(sel, path)
+	}
+	return sel, nil
+}
+
+// Kind of the User field with the given JSON name
+func userFieldKind(jsonName string) (reflect.Kind, bool) {
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == jsonName {
+			return t.Field(i).Type.Kind(), true
+		}
+	}
+	return 0, false
+}
+
+// Selection from the request; read handlers validate it up front, so an
+// invalid one here just selects everything
+func requestedFields(r *http.Request) fieldSelection {
+	sel, err := parseFieldSelection(r.URL.Query().Get("fields"))
+	if err != nil {
+		return nil
+	}
+	return sel
+}
+
+// Stored field paths to pass to Query.Select. Includes what the handlers
+// need besides the selection: the email behind avatarUrl and the
+// soft-delete marker.
+func (s fieldSelection) firestorePaths() []firestore.FieldPath {
+	var paths []firestore.FieldPath
+	seen := map[string]bool{}
+	add := func(fp firestore.FieldPath) {
+		key := strings.Join(fp, "\x00")
+		if !seen[key] {
+			seen[key] = true
+			paths = append(paths, fp)
+		}
+	}
+	add(firestore.FieldPath{"deletedAt"})
+	for _, path := range s {
+		if path[0] == "avatarUrl" {
+			stored, _ := firestoreFieldName("email")
+			add(firestore.FieldPath{stored})
+			continue
+		}
+		stored, _ := firestoreFieldName(path[0])
+		add(append(firestore.FieldPath{stored}, path[1:]...))
+	}
+
+	// A selected map already covers its nested paths
+	var covered []firestore.FieldPath
+	for _, fp := range paths {
+		redundant := false
+		for i := 1; i < len(fp); i++ {
+			if seen[strings.Join(fp[:i], "\x00")] {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			covered = append(covered, fp)
+		}
+	}
+	return covered
+}
+
+// The selected subset of user's JSON representation
+func (s fieldSelection) project(user model.User) map[string]interface{} {
+	raw, _ := json.Marshal(user)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var full map[string]interface{}
+	dec.Decode(&full)
+
+	out := map[string]interface{}{}
+	for _, path := range s {
+		var value interface{} = full
+		found := true
+		for _, segment := range path {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, ok = m[segment]; !ok {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		node := out
+		for _, segment := range path[:len(path)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[segment] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+	return out
+}
+
+// user with every unselected field cleared, for typed encodings (protobuf)
+func (s fieldSelection) apply(user model.User) model.User {
+	raw, _ := json.Marshal(s.project(user))
+	var projected model.User
+	json.Unmarshal(raw, &projected)
+	return projected
+}
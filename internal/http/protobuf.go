@@ -0,0 +1,152 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/userpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// ACCEPT_FORM_POSTS=true lets /addUser take an HTML form post, mapping
+// the name and email fields
+var acceptFormPosts = config.Bool("ACCEPT_FORM_POSTS", false)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// Convert between the stored User struct and its wire message
+func userToProto(user model.User) *userpb.User {
+	msg := &userpb.User{
+		Name:      user.Name,
+		Email:     user.Email,
+		AvatarUrl: user.AvatarURL,
+	}
+	if len(user.Attributes) > 0 {
+		// Round-trip through JSON so stored types like timestamps become
+		// values a Struct can hold
+		raw, _ := json.Marshal(user.Attributes)
+		var plain map[string]interface{}
+		json.Unmarshal(raw, &plain)
+		if attrs, err := structpb.NewStruct(plain); err == nil {
+			msg.Attributes = attrs
+		}
+	}
+	return msg
+}
+
+func userFromProto(msg *userpb.User) model.User {
+	user := model.User{
+		Name:  msg.GetName(),
+		Email: msg.GetEmail(),
+	}
+	if msg.GetAttributes() != nil {
+		user.Attributes = msg.GetAttributes().AsMap()
+	}
+	return user
+}
+
+// Decode a User body as JSON or protobuf by Content-Type, or as a form
+// post when allowForm
+func decodeUserBody(r *http.Request, user *model.User, allowForm bool) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case formContentType:
+		if !allowForm {
+			return errUnsupportedMediaType
+		}
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		*user = model.User{Name: r.PostForm.Get("name"), Email: r.PostForm.Get("email")}
+		return nil
+	case protobufContentType:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var msg userpb.User
+		if err := proto.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		*user = userFromProto(&msg)
+		return nil
+	}
+	return decodeJSON(r, user)
+}
+
+// 415 naming the Content-Type received and the types the endpoint accepts
+func unsupportedMediaType(w http.ResponseWriter, r *http.Request, supported ...string) {
+	received := "Missing Content-Type"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		received = fmt.Sprintf("Unsupported Content-Type %q", ct)
+	}
+	writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+		received+"; supported: "+strings.Join(supported, ", "))
+}
+
+// Whether the client's Accept header asks for protobuf
+func wantsProtobuf(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == protobufContentType && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a single-user envelope; message and user are omitted when empty.
+// The user is narrowed to ?fields= when present.
+func writeUserResponse(w http.ResponseWriter, r *http.Request, message, id string, user *model.User) {
+	writeUserResponseWith(w, r, message, id, user, false)
+}
+
+// writeUserResponse for a user read from the archive, flagged "archived".
+// The protobuf envelope has no place for the flag and leaves it out.
+func writeUserResponseWith(w http.ResponseWriter, r *http.Request, message, id string, user *model.User, archived bool) {
+	sel := requestedFields(r)
+	if wantsProtobuf(r) {
+		resp := &userpb.UserResponse{Message: message, Id: id, DryRun: dryRunRequested(r)}
+		if user != nil && sel != nil {
+			resp.User = userToProto(sel.apply(*user))
+		} else if user != nil {
+			resp.User = userToProto(*user)
+		}
+		writeProto(w, r, resp)
+		return
+	}
+
+	response := UserEnvelope{ID: id, Message: message, Archived: archived}
+	if user != nil && sel != nil {
+		response.User = sel.project(*user)
+	} else if user != nil {
+		response.User = user
+	}
+	if user != nil {
+		response.Links = userLinks(r, id)
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+func writeProto(w http.ResponseWriter, r *http.Request, msg proto.Message) {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error encoding response")
+		return
+	}
+	markDryRun(w, r, nil)
+	w.Header().Set("Content-Type", protobufContentType)
+	w.Write(body)
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/userpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUserProtoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		user model.User
+		want model.User // after userFromProto(userToProto(user))
+	}{
+		{"empty", model.User{}, model.User{}},
+		{"no attributes", model.User{Name: "Ada", Email: "ada@example.com"}, model.User{Name: "Ada", Email: "ada@example.com"}},
+		{
+			"nested attributes",
+			model.User{Name: "Ada", Attributes: map[string]interface{}{
+				"tags":    []interface{}{"a", "b"},
+				"address": map[string]interface{}{"city": "London"},
+				"active":  true,
+				"score":   1.5,
+				"none":    nil,
+			}},
+			model.User{Name: "Ada", Attributes: map[string]interface{}{
+				"tags":    []interface{}{"a", "b"},
+				"address": map[string]interface{}{"city": "London"},
+				"active":  true,
+				"score":   1.5,
+				"none":    nil,
+			}},
+		},
+		{
+			// A Struct holds JSON values: stored integers and timestamps
+			// come back as their JSON forms
+			"stored types",
+			model.User{Name: "Ada", Attributes: map[string]interface{}{
+				"logins":   int64(3),
+				"lastSeen": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			}},
+			model.User{Name: "Ada", Attributes: map[string]interface{}{
+				"logins":   float64(3),
+				"lastSeen": "2024-01-02T03:04:05Z",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := proto.Marshal(userToProto(tt.user))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg userpb.User
+			if err := proto.Unmarshal(raw, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if got := userFromProto(&msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserFromProtoIgnoresAvatarURL(t *testing.T) {
+	user := userFromProto(&userpb.User{Name: "Ada", AvatarUrl: "https://example.com/a.png"})
+	if user.AvatarURL != "" {
+		t.Errorf("AvatarURL = %q, want it computed rather than taken from a write", user.AvatarURL)
+	}
+}
+
+func TestDecodeUserBody(t *testing.T) {
+	raw, err := proto.Marshal(&userpb.User{Name: "Ada", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(contentType string, body []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/addUser", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+	want := model.User{Name: "Ada", Email: "ada@example.com"}
+
+	for _, r := range []*http.Request{
+		request(protobufContentType, raw),
+		request("application/json; charset=utf-8", []byte(`{"name": "Ada", "email": "ada@example.com"}`)),
+	} {
+		var user model.User
+		if err := decodeUserBody(r, &user, false); err != nil || !reflect.DeepEqual(user, want) {
+			t.Errorf("%s: decoded %+v, %v, want %+v", r.Header.Get("Content-Type"), user, err, want)
+		}
+	}
+
+	var user model.User
+	if err := decodeUserBody(request(protobufContentType, []byte{0xff, 0xff}), &user, false); err == nil {
+		t.Error("a malformed protobuf body decoded")
+	}
+	form := request(formContentType, []byte("name=Ada&email=ada%40example.com"))
+	if err := decodeUserBody(form, &user, false); err != errUnsupportedMediaType {
+		t.Errorf("form post without allowForm: %v, want errUnsupportedMediaType", err)
+	}
+	form = request(formContentType, []byte("name=Ada&email=ada%40example.com"))
+	if err := decodeUserBody(form, &user, true); err != nil || !reflect.DeepEqual(user, want) {
+		t.Errorf("form post: decoded %+v, %v, want %+v", user, err, want)
+	}
+	if err := decodeUserBody(request("text/csv", []byte("Ada")), &user, false); err != errUnsupportedMediaType {
+		t.Errorf("text/csv: %v, want errUnsupportedMediaType", err)
+	}
+}
+
+func TestWantsProtobuf(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                       false,
+		"application/json":       false,
+		"application/x-protobuf": true,
+		"application/json, application/x-protobuf;q=0.5": true,
+		"application/x-protobuf;q=0":                     false,
+		"*/*":                                            false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+		r.Header.Set("Accept", accept)
+		if got := wantsProtobuf(r); got != want {
+			t.Errorf("Accept %q: wantsProtobuf = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestWriteUserResponseProtobuf(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/addUser?dryRun=true", nil)
+	r.Header.Set("Accept", protobufContentType)
+	rec := httptest.NewRecorder()
+	user := model.User{Name: "Ada", Email: "ada@example.com", AvatarURL: "/users/abc/avatar.svg"}
+	writeUserResponse(rec, r, "User added successfully", "abc", &user)
+
+	if ct := rec.Header().Get("Content-Type"); ct != protobufContentType {
+		t.Fatalf("Content-Type = %q, want %s", ct, protobufContentType)
+	}
+	var resp userpb.UserResponse
+	if err := proto.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := &userpb.UserResponse{
+		Message: "User added successfully",
+		Id:      "abc",
+		User:    &userpb.User{Name: "Ada", Email: "ada@example.com", AvatarUrl: "/users/abc/avatar.svg"},
+		DryRun:  true,
+	}
+	if !proto.Equal(&resp, want) {
+		t.Errorf("response = %v, want %v", &resp, want)
+	}
+}
+
+func TestAddUserRejectsUnsupportedMediaType(t *testing.T) {
+	s := newTestServer(t)
+	r := httptest.NewRequest(http.MethodPost, "/addUser", strings.NewReader("name: Ada"))
+	r.Header.Set("Content-Type", "application/yaml")
+	rec := httptest.NewRecorder()
+	s.addUserHandler(rec, r)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, want 415", rec.Code)
+	}
+	for _, supported := range []string{"application/json", protobufContentType} {
+		if !strings.Contains(rec.Body.String(), supported) {
+			t.Errorf("415 %q doesn't list %s", rec.Body, supported)
+		}
+	}
+}
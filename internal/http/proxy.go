@@ -0,0 +1,117 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// TRUSTED_PROXIES lists the proxy IPs/CIDRs (our load balancers) whose
+// X-Forwarded-* headers are believed. Requests arriving from any other
+// peer have those headers ignored, so clients can't spoof their IP,
+// scheme or host.
+var trustedProxies = parseTrustedProxies(config.String("TRUSTED_PROXIES", ""))
+
+func parseTrustedProxies(list string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP of the direct peer
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func fromTrustedProxy(r *http.Request) bool {
+	return trustedProxy(peerIP(r))
+}
+
+// Effective client IP. Behind trusted proxies X-Forwarded-For is walked
+// from the right, skipping our own proxies; the first address that isn't
+// one is the client (anything left of it could have been sent by the
+// client). Falls back to the direct peer.
+func clientIP(r *http.Request) string {
+	peer := peerIP(r)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !trustedProxy(peer) {
+		return peer.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := peer.String()
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage in the chain; trust nothing beyond it
+		}
+		client = ip.String()
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// Scheme and host the client used to reach us. Behind trusted proxies the
+// last X-Forwarded-Proto/-Host value wins: it was set by the proxy
+// nearest us, where earlier values may have come from the client.
+func requestOrigin(r *http.Request) (scheme, host string) {
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r) {
+		if p := lastHeaderValue(r, "X-Forwarded-Proto"); p == "http" || p == "https" {
+			scheme = p
+		}
+		if h := lastHeaderValue(r, "X-Forwarded-Host"); h != "" {
+			host = h
+		}
+	}
+	return scheme, host
+}
+
+// Last entry of a comma-separated header added by a proxy chain
+func lastHeaderValue(r *http.Request, name string) string {
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	v := values[len(values)-1]
+	if i := strings.LastIndex(v, ","); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Trust list for one test, as TRUSTED_PROXIES would set it
+func withTrustedProxies(t *testing.T, list string) {
+	saved := trustedProxies
+	trustedProxies = parseTrustedProxies(list)
+	t.Cleanup(func() { trustedProxies = saved })
+}
+
+// A request from peer with the given X-Forwarded-* headers, one value per
+// header line
+func forwardedRequest(peer string, headers map[string][]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/getUser?id=a", nil)
+	r.RemoteAddr = peer
+	for name, values := range headers {
+		for _, v := range values {
+			r.Header.Add(name, v)
+		}
+	}
+	return r
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets := parseTrustedProxies(" 10.0.0.0/8, 192.0.2.7,, ::1, not-an-ip, 300.1.1.1/8 ")
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "::1/128"}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parsed %v, want %v", got, want)
+		}
+	}
+	if parseTrustedProxies("") != nil {
+		t.Error("an empty TRUSTED_PROXIES trusts something")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8, 2001:db8::/32")
+	tests := []struct {
+		name string
+		peer string
+		xff  []string
+		want string
+	}{
+		{"no proxy", "203.0.113.5:4711", nil, "203.0.113.5"},
+		{"spoofed XFF from an untrusted peer", "203.0.113.5:4711", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"spoofed chain from an untrusted peer", "203.0.113.5:4711", []string{"10.0.0.1, 198.51.100.1"}, "203.0.113.5"},
+		{"trusted peer without XFF", "10.0.0.2:80", nil, "10.0.0.2"},
+		{"one hop", "10.0.0.2:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"multi-hop through our proxies", "10.0.0.2:80", []string{"198.51.100.1, 10.1.1.1, 10.2.2.2"}, "198.51.100.1"},
+		// The client prepended a fake address; the rightmost untrusted hop is
+		// the one our proxy saw
+		{"client-supplied prefix", "10.0.0.2:80", []string{"1.2.3.4, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"untrusted proxy in the chain", "10.0.0.2:80", []string{"198.51.100.1, 203.0.113.9"}, "203.0.113.9"},
+		{"chain split across header lines", "10.0.0.2:80", []string{"198.51.100.1", "10.1.1.1"}, "198.51.100.1"},
+		{"every hop trusted", "10.0.0.2:80", []string{"10.3.3.3, 10.1.1.1"}, "10.3.3.3"},
+		{"garbage stops the walk", "10.0.0.2:80", []string{"198.51.100.1, garbage, 10.1.1.1"}, "10.1.1.1"},
+		{"IPv6", "[2001:db8::1]:443", []string{"2001:db8:ffff::1, 2001:db8::2"}, "2001:db8:ffff::1"},
+		{"IPv6 client behind an IPv4 proxy", "10.0.0.2:80", []string{"2001:db9::5"}, "2001:db9::5"},
+		{"peer without a port", "10.0.0.2", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := forwardedRequest(tt.peer, map[string][]string{"X-Forwarded-For": tt.xff})
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestOrigin(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	tests := []struct {
+		name       string
+		peer       string
+		headers    map[string][]string
+		tls        bool
+		wantScheme string
+		wantHost   string
+	}{
+		{"direct", "203.0.113.5:4711", nil, false, "http", "api.example.com"},
+		{"direct TLS", "203.0.113.5:4711", nil, true, "https", "api.example.com"},
+		{
+			"spoofed from an untrusted peer", "203.0.113.5:4711",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.example"}},
+			false, "http", "api.example.com",
+		},
+		{
+			"trusted proxy", "10.0.0.2:80",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"users.example.com"}},
+			false, "https", "users.example.com",
+		},
+		{
+			"nearest proxy wins", "10.0.0.2:80",
+			map[string][]string{"X-Forwarded-Proto": {"http, https"}, "X-Forwarded-Host": {"evil.example", "users.example.com"}},
+			false, "https", "users.example.com",
+		},
+		{
+			"unknown scheme ignored", "10.0.0.2:80",
+			map[string][]string{"X-Forwarded-Proto": {"javascript"}},
+			true, "https", "api.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := forwardedRequest(tt.peer, tt.headers)
+			r.Host = "api.example.com"
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			scheme, host := requestOrigin(r)
+			if scheme != tt.wantScheme || host != tt.wantHost {
+				t.Errorf("requestOrigin = %s, %s, want %s, %s", scheme, host, tt.wantScheme, tt.wantHost)
+			}
+		})
+	}
+}
+
+// The rate limiter and the link builder see the resolved client, not the
+// load balancer or a spoofed header
+func TestProxyConsumers(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	proxied := forwardedRequest("10.0.0.2:80", map[string][]string{
+		"X-Forwarded-For":   {"198.51.100.1"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"users.example.com"},
+	})
+	if got := ipPrincipal(proxied); got != "ip_198.51.100.1" {
+		t.Errorf("ipPrincipal behind the proxy = %s", got)
+	}
+	if got := baseURL(proxied); got != "https://users.example.com" {
+		t.Errorf("baseURL behind the proxy = %s", got)
+	}
+
+	spoofed := forwardedRequest("203.0.113.5:4711", map[string][]string{
+		"X-Forwarded-For":   {"198.51.100.1"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"evil.example"},
+	})
+	spoofed.Host = "api.example.com"
+	if got := ipPrincipal(spoofed); got != "ip_203.0.113.5" {
+		t.Errorf("ipPrincipal with spoofed XFF = %s", got)
+	}
+	if got := baseURL(spoofed); got != "http://api.example.com" {
+		t.Errorf("baseURL with spoofed headers = %s", got)
+	}
+}
@@ -0,0 +1,383 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Per-principal daily write quotas.
+//
+// Usage is tracked in sharded counters at quota_usage/{principal}_{day}/shards/{n}
+// and limits come from the plans/{plan} document referenced by principals/{principal}.
+// Quota state is cached per principal so the check costs no Firestore reads
+// on the request path until the cached entry expires. An API key that isn't
+// registered is cached as such for QUOTA_NEGATIVE_TTL without reading its
+// plan or usage, and the cache holds at most QUOTA_CACHE_SIZE principals,
+// so made-up keys cost one read each and can't grow it without bound.
+//
+// Principals come from what the caller can't fake: an API key registered in
+// principals/, or X-User-ID on a call carrying the admin token (a backend
+// acting for a user). Everyone else, including an unregistered key or a
+// bare X-User-ID, is billed to their client IP, so dropping or rotating the
+// headers doesn't buy a fresh quota.
+var (
+	quotaShards       = config.DefaultQuotaShards // QUOTA_SHARDS, set by NewApp
+	quotaCacheTTL     = config.Duration("QUOTA_CACHE_TTL", 30*time.Second)
+	quotaDefaultPlan  = config.String("QUOTA_DEFAULT_PLAN", "free")
+	quotaDefaultLimit = int64(config.Int("QUOTA_DEFAULT_DAILY_WRITES", 1000))
+	quotaNegativeTTL  = config.Duration("QUOTA_NEGATIVE_TTL", 5*time.Minute)
+	quotaCacheSize    = config.Int("QUOTA_CACHE_SIZE", 10000)
+)
+
+// Cached quota state for one principal
+type quotaState struct {
+	limit      int64
+	used       int64
+	day        string
+	fetched    time.Time
+	registered bool // principals/{principal} exists
+}
+
+type quotaCache struct {
+	mu      sync.Mutex
+	entries map[string]*quotaState
+	size    int
+	fills   singleflight.Group
+	load    func(ctx context.Context, principal, day string) (quotaState, error)
+	usage   func(principal, day string) *firestore.DocumentRef // where record counts writes
+}
+
+func (s *Server) newQuotaCache(size int) *quotaCache {
+	return &quotaCache{entries: map[string]*quotaState{}, size: max(size, 1), load: s.loadQuotaState, usage: s.quotaUsageDoc}
+}
+
+// Resolve the principal a request comes from ("" for anonymous requests).
+// API keys are hashed so raw keys never end up in document IDs; whether the
+// key is registered is only known once its quota state is loaded.
+func principalFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key_" + hex.EncodeToString(sum[:16])
+	}
+	if uid := r.Header.Get("X-User-ID"); uid != "" && !strings.Contains(uid, "/") && adminAuthorized(r) {
+		return "user_" + uid
+	}
+	return ""
+}
+
+// The principal an anonymous caller is billed to
+func ipPrincipal(r *http.Request) string {
+	return "ip_" + strings.ReplaceAll(clientIP(r), "/", "_")
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+// Quotas reset at midnight UTC
+func quotaResetTime(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *Server) quotaUsageDoc(principal, day string) *firestore.DocumentRef {
+	return s.client.Collection("quota_usage").Doc(principal + "_" + day)
+}
+
+// Look up the daily write limit from the principal's plan, and whether the
+// principal is registered at all
+func (s *Server) loadQuotaLimit(ctx context.Context, principal string) (limit int64, registered bool, err error) {
+	plan, registered, err := s.loadPrincipalPlan(ctx, principal)
+	if err != nil {
+		return 0, false, err
+	}
+	limit, err = s.loadPlanLimit(ctx, plan)
+	return limit, registered, err
+}
+
+// The plan of principals/{principal}, the default plan when it isn't registered
+func (s *Server) loadPrincipalPlan(ctx context.Context, principal string) (plan string, registered bool, err error) {
+	plan = quotaDefaultPlan
+	doc, err := s.client.Collection("principals").Doc(principal).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return plan, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if p, ok := doc.Data()["plan"].(string); ok && p != "" {
+		plan = p
+	}
+	return plan, true, nil
+}
+
+func (s *Server) loadPlanLimit(ctx context.Context, plan string) (int64, error) {
+	planDoc, err := s.client.Collection("plans").Doc(plan).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return quotaDefaultLimit, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch v := planDoc.Data()["dailyWriteLimit"].(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	}
+	return quotaDefaultLimit, nil
+}
+
+// Load a principal's quota state for day. An unregistered key stops at
+// the principals read: its requests are billed to the caller's IP.
+func (s *Server) loadQuotaState(ctx context.Context, principal, day string) (quotaState, error) {
+	plan, registered, err := s.loadPrincipalPlan(ctx, principal)
+	if err != nil {
+		return quotaState{}, err
+	}
+	if !registered && strings.HasPrefix(principal, "key_") {
+		return quotaState{day: day, fetched: time.Now()}, nil
+	}
+	limit, err := s.loadPlanLimit(ctx, plan)
+	if err != nil {
+		return quotaState{}, err
+	}
+	used, err := s.readQuotaUsage(ctx, principal, day)
+	if err != nil {
+		return quotaState{}, err
+	}
+	return quotaState{limit: limit, used: used, day: day, fetched: time.Now(), registered: registered}, nil
+}
+
+// Sum the counter shards for a principal's day
+func (s *Server) readQuotaUsage(ctx context.Context, principal, day string) (int64, error) {
+	var total int64
+	iter := trackIterator("quota", s.quotaUsageDoc(principal, day).Collection("shards").Documents(ctx))
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return total, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n, ok := doc.Data()["count"].(int64); ok {
+			total += n
+		}
+	}
+}
+
+// How long a cached state is good for: unregistered keys are kept longer,
+// since they have no usage to go stale
+func (s *quotaState) ttl(principal string) time.Duration {
+	if !s.registered && strings.HasPrefix(principal, "key_") {
+		return quotaNegativeTTL
+	}
+	return quotaCacheTTL
+}
+
+// Return the cached quota state, refreshing it from Firestore when stale.
+// Concurrent misses for one principal share a single load.
+func (c *quotaCache) get(ctx context.Context, principal string) (quotaState, error) {
+	day := quotaDay(time.Now())
+	c.mu.Lock()
+	if s, ok := c.entries[principal]; ok && s.day == day && time.Since(s.fetched) < s.ttl(principal) {
+		state := *s
+		c.mu.Unlock()
+		return state, nil
+	}
+	c.mu.Unlock()
+
+	v, err, _ := c.fills.Do(principal, func() (interface{}, error) {
+		state, err := c.load(ctx, principal, day)
+		if err != nil {
+			return quotaState{}, err
+		}
+		c.mu.Lock()
+		if _, ok := c.entries[principal]; !ok && len(c.entries) >= c.size {
+			c.evict(day)
+		}
+		c.entries[principal] = &state
+		c.mu.Unlock()
+		return state, nil
+	})
+	return v.(quotaState), err
+}
+
+// Make room for one entry (c.mu held): drop the entries of past days and
+// the expired ones, and failing that the least recently fetched
+func (c *quotaCache) evict(day string) {
+	for principal, s := range c.entries {
+		if s.day != day || time.Since(s.fetched) >= s.ttl(principal) {
+			delete(c.entries, principal)
+		}
+	}
+	if len(c.entries) < c.size {
+		return
+	}
+	var oldest string
+	for principal, s := range c.entries {
+		if oldest == "" || s.fetched.Before(c.entries[oldest].fetched) {
+			oldest = principal
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// Count one billed operation locally and in Firestore (asynchronously)
+func (c *quotaCache) record(principal string) {
+	day := quotaDay(time.Now())
+	c.mu.Lock()
+	if s, ok := c.entries[principal]; ok && s.day == day {
+		s.used++
+	}
+	c.mu.Unlock()
+
+	shard := c.usage(principal, day).Collection("shards").Doc(strconv.Itoa(rand.Intn(quotaShards)))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := shard.Set(ctx, map[string]interface{}{
+			"count": firestore.Increment(1),
+		}, firestore.MergeAll)
+		if err != nil {
+			log.Printf("⚠️ Failed to record quota usage for %s: %v", principal, err)
+		}
+	}()
+}
+
+func (c *quotaCache) invalidate(principal string) {
+	c.mu.Lock()
+	delete(c.entries, principal)
+	c.mu.Unlock()
+}
+
+func (c *quotaCache) clear() {
+	c.mu.Lock()
+	clear(c.entries)
+	c.mu.Unlock()
+}
+
+// Enforce daily write quotas on mutating requests
+func (s *Server) quotaMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		principal := principalFromRequest(r)
+		if principal == "" {
+			principal = ipPrincipal(r)
+		}
+		state, err := s.quotas.get(requestContext(r), principal)
+		if err == nil && strings.HasPrefix(principal, "key_") && !state.registered {
+			// A made-up key is as anonymous as no key
+			principal = ipPrincipal(r)
+			state, err = s.quotas.get(requestContext(r), principal)
+		}
+		if err != nil {
+			// Fail open: a quota lookup problem shouldn't take writes down
+			logCtx(r.Context(), "⚠️ Quota check failed for %s: %v", principal, err)
+			next(w, r)
+			return
+		}
+
+		now := time.Now()
+		reset := quotaResetTime(now)
+		remaining := state.limit - state.used
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(state.limit, 10))
+		w.Header().Set("X-Quota-Reset", reset.Format(time.RFC3339))
+		if remaining <= 0 {
+			w.Header().Set("X-Quota-Remaining", "0")
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			writeError(w, r, http.StatusTooManyRequests, "quota_exceeded", "Daily write quota exceeded")
+			return
+		}
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining-1, 10))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		// Dry runs are checked against the quota but don't consume it
+		if rec.status < http.StatusBadRequest && !dryRunRequested(r) {
+			s.quotas.record(principal)
+		}
+	}
+}
+
+// Read or reset a principal's usage (GET|DELETE /admin/quota?principal=...&day=YYYYMMDD)
+func (s *Server) adminQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	principal := r.URL.Query().Get("principal")
+	if principal == "" || strings.Contains(principal, "/") {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Principal required")
+		return
+	}
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = quotaDay(time.Now())
+	}
+
+	ctx := requestContext(r)
+	switch r.Method {
+	case http.MethodGet:
+		limit, _, err := s.loadQuotaLimit(ctx, principal)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading quota")
+			return
+		}
+		used, err := s.readQuotaUsage(ctx, principal, day)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading quota")
+			return
+		}
+		remaining := limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		writeJSON(w, r, http.StatusOK, QuotaResponse{Principal: principal, Day: day, Limit: limit, Used: used, Remaining: remaining})
+
+	case http.MethodDelete:
+		dryRun := dryRunRequested(r)
+		iter := s.quotaUsageDoc(principal, day).Collection("shards").DocumentRefs(ctx)
+		for {
+			ref, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "internal", "Error resetting quota")
+				return
+			}
+			if dryRun {
+				continue
+			}
+			if _, err := ref.Delete(ctx); err != nil {
+				writeError(w, r, http.StatusInternalServerError, "internal", "Error resetting quota")
+				return
+			}
+		}
+		if !dryRun {
+			s.quotas.invalidate(principal)
+			s.publishInvalidation("quotas", principal)
+		}
+		writeJSON(w, r, http.StatusOK, QuotaResetResponse{Message: "Quota usage reset", Principal: principal, Day: day})
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+	}
+}
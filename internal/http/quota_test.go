@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrincipalFromRequest(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"anonymous", nil, ""},
+		{"bare user ID", map[string]string{"X-User-ID": "alice"}, ""},
+		{"user ID with wrong token", map[string]string{"X-User-ID": "alice", "Authorization": "Bearer guess"}, ""},
+		{"user ID with admin token", map[string]string{"X-User-ID": "alice", "Authorization": "Bearer s3cret"}, "user_alice"},
+		{"user ID with a slash", map[string]string{"X-User-ID": "a/b", "Authorization": "Bearer s3cret"}, ""},
+		{"API key", map[string]string{"X-API-Key": "k1", "X-User-ID": "alice"}, "key_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			got := principalFromRequest(r)
+			if tt.want == "key_" {
+				if !strings.HasPrefix(got, "key_") || strings.Contains(got, "k1") || len(got) != len("key_")+32 {
+					t.Errorf("principal = %q, want key_ and a 32-digit hash", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("principal = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalFromRequestHashesKeysStably(t *testing.T) {
+	principal := func(key string) string {
+		r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+		r.Header.Set("X-API-Key", key)
+		return principalFromRequest(r)
+	}
+	if principal("k1") != principal("k1") {
+		t.Error("the same key maps to different principals")
+	}
+	if principal("k1") == principal("k2") {
+		t.Error("different keys map to the same principal")
+	}
+}
+
+func TestIPPrincipalIgnoresSpoofedForwarding(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	trustedProxies = parseTrustedProxies("10.0.0.1")
+
+	r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+	r.RemoteAddr = "203.0.113.9:4711"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := ipPrincipal(r); got != "ip_203.0.113.9" {
+		t.Errorf("untrusted peer: principal = %q, want ip_203.0.113.9", got)
+	}
+
+	r.RemoteAddr = "10.0.0.1:4711"
+	if got := ipPrincipal(r); got != "ip_198.51.100.1" {
+		t.Errorf("behind our proxy: principal = %q, want ip_198.51.100.1", got)
+	}
+
+	r.RemoteAddr = "[2001:db8::1]:4711"
+	if got := ipPrincipal(r); got != "ip_2001:db8::1" {
+		t.Errorf("IPv6 peer: principal = %q, want ip_2001:db8::1", got)
+	}
+}
+
+// A quota cache of size entries whose loads are counted by principal.
+// Every key is unregistered; everyone else gets a limit of 100.
+func countingQuotaCache(s *Server, size int) (*quotaCache, func(string) int64) {
+	var mu sync.Mutex
+	loads := map[string]int64{}
+	c := s.newQuotaCache(size)
+	c.load = func(_ context.Context, principal, day string) (quotaState, error) {
+		mu.Lock()
+		loads[principal]++
+		mu.Unlock()
+		time.Sleep(time.Millisecond) // long enough for concurrent misses to overlap
+		if strings.HasPrefix(principal, "key_") {
+			return quotaState{day: day, fetched: time.Now()}, nil
+		}
+		return quotaState{limit: 100, day: day, fetched: time.Now(), registered: true}, nil
+	}
+	return c, func(principal string) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return loads[principal]
+	}
+}
+
+func TestQuotaCacheUnknownKeySpray(t *testing.T) {
+	s := newTestServer(t)
+	c, loads := countingQuotaCache(s, 100)
+	const principal = "key_0123456789abcdef0123456789abcdef"
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, err := c.get(context.Background(), principal)
+			if err != nil || state.registered {
+				t.Errorf("get = %+v, %v, want an unregistered state", state, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 50; i++ {
+		c.get(context.Background(), principal)
+	}
+	if n := loads(principal); n != 1 {
+		t.Errorf("%d loads for one unknown key, want 1", n)
+	}
+}
+
+func TestQuotaMiddlewareBillsUnknownKeysToIP(t *testing.T) {
+	s := newTestServer(t)
+	c, loads := countingQuotaCache(s, 1000)
+	s.quotas = c
+
+	h := s.quotaMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict) // not billed, so nothing is written
+	})
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("made-up-%d", i))
+	}
+	for round := 0; round < 5; round++ {
+		for _, key := range keys {
+			r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+			r.RemoteAddr = "203.0.113.9:4711"
+			r.Header.Set("X-API-Key", key)
+			rec := httptest.NewRecorder()
+			h(rec, r)
+			if rec.Header().Get("X-Quota-Limit") != "100" {
+				t.Fatalf("X-Quota-Limit = %q, want the IP's 100", rec.Header().Get("X-Quota-Limit"))
+			}
+		}
+	}
+	for _, key := range keys {
+		r := httptest.NewRequest(http.MethodPost, "/addUser", nil)
+		r.Header.Set("X-API-Key", key)
+		if n := loads(principalFromRequest(r)); n != 1 {
+			t.Errorf("key %s loaded %d times, want once", key, n)
+		}
+	}
+	if n := loads("ip_203.0.113.9"); n != 1 {
+		t.Errorf("IP loaded %d times, want once", n)
+	}
+}
+
+func TestQuotaCacheIsBounded(t *testing.T) {
+	s := newTestServer(t)
+	c, _ := countingQuotaCache(s, 100)
+	for i := 0; i < 1000; i++ {
+		c.get(context.Background(), fmt.Sprintf("key_%032x", i))
+	}
+	if n := len(c.entries); n > 100 {
+		t.Errorf("%d entries, want at most 100", n)
+	}
+}
+
+func TestQuotaCacheDropsPastDays(t *testing.T) {
+	s := newTestServer(t)
+	c, _ := countingQuotaCache(s, 3)
+	c.entries["ip_old1"] = &quotaState{day: "20000101", fetched: time.Now()}
+	c.entries["ip_old2"] = &quotaState{day: "20000102", fetched: time.Now()}
+	c.get(context.Background(), "ip_a")
+	c.get(context.Background(), "ip_b")
+	for _, principal := range []string{"ip_old1", "ip_old2"} {
+		if _, ok := c.entries[principal]; ok {
+			t.Errorf("%s from a past day is still cached", principal)
+		}
+	}
+	for _, principal := range []string{"ip_a", "ip_b"} {
+		if _, ok := c.entries[principal]; !ok {
+			t.Errorf("%s was evicted", principal)
+		}
+	}
+}
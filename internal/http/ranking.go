@@ -0,0 +1,195 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"golang.org/x/sync/errgroup"
+)
+
+// Weights for in-process ranking: a token matching a whole word scores
+// SEARCH_WEIGHT_EXACT, one matching only a word prefix SEARCH_WEIGHT_PREFIX.
+// At most SEARCH_CANDIDATE_LIMIT documents are ranked per query, and a
+// query may have at most SEARCH_MAX_TOKENS distinct words, since each
+// costs three Firestore queries.
+var (
+	searchWeightExact    = config.Float("SEARCH_WEIGHT_EXACT", 3)
+	searchWeightPrefix   = config.Float("SEARCH_WEIGHT_PREFIX", 1)
+	searchCandidateLimit = config.Int("SEARCH_CANDIDATE_LIMIT", 500)
+	searchMaxTokens      = config.Int("SEARCH_MAX_TOKENS", 8)
+)
+
+type rankedUser struct {
+	id        string
+	user      model.User
+	createdAt time.Time
+	score     float64
+	matched   int
+}
+
+// Lowercased words of s, split on anything that isn't a letter or digit
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// Score a user against the query tokens; each token counts once, at its best match
+func scoreUser(user model.User, tokens []string) (score float64, matched int) {
+	words := append(searchWords(user.Name), searchWords(user.Email)...)
+	for _, token := range tokens {
+		best := 0.0
+		for _, word := range words {
+			if word == token {
+				best = searchWeightExact
+				break
+			}
+			if strings.HasPrefix(word, token) && searchWeightPrefix > best {
+				best = searchWeightPrefix
+			}
+		}
+		if best > 0 {
+			score += best
+			matched++
+		}
+	}
+	return score, matched
+}
+
+// Order by score, then newest first, then document ID, so identical
+// inputs always rank identically and offset pages stay stable
+func sortRanked(results []rankedUser) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if !a.createdAt.Equal(b.createdAt) {
+			return a.createdAt.After(b.createdAt)
+		}
+		return a.id < b.id
+	})
+}
+
+// The distinct words of a query, in order of first appearance
+func searchTokens(q string) []string {
+	var tokens []string
+	seen := map[string]bool{}
+	for _, word := range searchWords(q) {
+		if !seen[word] {
+			seen[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// Candidates whose name or email starts with one of the tokens, up to
+// searchCandidateLimit documents in total. The prefix queries run
+// concurrently; their results are merged in query order, so the same
+// tokens always yield the same candidates.
+func (s *Server) searchCandidates(ctx context.Context, tokens []string) ([]*firestore.DocumentSnapshot, error) {
+	nameField, _ := firestoreFieldName("name")
+	emailField, _ := firestoreFieldName("email")
+	type prefixQuery struct{ field, prefix string }
+	var queries []prefixQuery
+	for _, token := range tokens {
+		runes := []rune(token)
+		title := string(unicode.ToUpper(runes[0])) + string(runes[1:])
+		queries = append(queries,
+			prefixQuery{nameField, token}, prefixQuery{nameField, title}, prefixQuery{emailField, token})
+	}
+
+	perQuery := searchCandidateLimit / len(queries)
+	if perQuery < 1 {
+		perQuery = 1
+	}
+	results := make([][]*firestore.DocumentSnapshot, len(queries))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, pq := range queries {
+		g.Go(func() error {
+			docs, err := s.users.Users().
+				Where(pq.field, ">=", pq.prefix).
+				Where(pq.field, "<", pq.prefix+"\uf8ff").
+				Limit(perQuery).Documents(ctx).GetAll()
+			results[i] = docs
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var candidates []*firestore.DocumentSnapshot
+	for _, docs := range results {
+		for _, doc := range docs {
+			if seen[doc.Ref.ID] || isSoftDeleted(doc) || isAnonymized(doc) || len(candidates) >= searchCandidateLimit {
+				continue
+			}
+			seen[doc.Ref.ID] = true
+			candidates = append(candidates, doc)
+		}
+	}
+	return candidates, nil
+}
+
+// Rank Firestore candidates in-process (GET /users/search?q=...&engine=firestore&offset=&limit=&debug=true);
+// debug adds each hit's score for callers with the admin token
+func (s *Server) firestoreSearchHandler(w http.ResponseWriter, r *http.Request, q string, limit int) {
+	tokens := searchTokens(q)
+	if len(tokens) == 0 {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query required")
+		return
+	}
+	if len(tokens) > searchMaxTokens {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", fmt.Sprintf("Query has more than %d distinct words", searchMaxTokens))
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	// Scores tell how ranking works; only admins get them
+	debug := r.URL.Query().Get("debug") == "true" && adminAuthorized(r)
+
+	candidates, err := s.searchCandidates(requestContext(r), tokens)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error searching users")
+		return
+	}
+	var results []rankedUser
+	for _, doc := range candidates {
+		user := userFromDoc(doc)
+		score, matched := scoreUser(user, tokens)
+		if matched == 0 {
+			continue
+		}
+		results = append(results, rankedUser{id: doc.Ref.ID, user: user, createdAt: userCreatedAt(doc), score: score, matched: matched})
+	}
+	sortRanked(results)
+
+	users := []map[string]interface{}{}
+	for i := offset; i < len(results) && i < offset+limit; i++ {
+		res := results[i]
+		res.user.AvatarURL = avatarURL(res.id, res.user)
+		entry := map[string]interface{}{
+			"id":   res.id,
+			"user": res.user,
+		}
+		if debug {
+			entry["score"] = res.score
+			entry["matchedTokens"] = res.matched
+		}
+		users = append(users, entry)
+	}
+	writeJSON(w, r, http.StatusOK, users)
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestSearchTokensDropsRepeats(t *testing.T) {
+	got := searchTokens("Ada ada LOVELACE ada.lovelace@example.com")
+	if want := []string{"ada", "lovelace", "example", "com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searchTokens = %v, want %v", got, want)
+	}
+}
+
+func TestSearchRefusesTooManyWords(t *testing.T) {
+	s := newTestServer(t)
+	q := strings.Repeat("a b c d e f g h i ", 2)
+	r := httptest.NewRequest(http.MethodGet, "/users/search?q="+strings.ReplaceAll(q, " ", "+"), nil)
+	serveError(t, s.searchUsersHandler, r, http.StatusBadRequest, "invalid_argument")
+
+}
+
+func TestSearchDebugScoresNeedAdmin(t *testing.T) {
+	s, ctx := useEmulator(t)
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	mustCreateUser(t, s, ctx, model.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+
+	anonymous := httptest.NewRequest(http.MethodGet, "/users/search?q=ada&debug=true", nil)
+	rec := httptest.NewRecorder()
+	s.searchUsersHandler(rec, anonymous)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "score") {
+		t.Errorf("anonymous debug search = %d %s, want hits without scores", rec.Code, rec.Body)
+	}
+
+	admin := httptest.NewRequest(http.MethodGet, "/users/search?q=ada&debug=true", nil)
+	admin.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	s.searchUsersHandler(rec, admin)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"score"`) {
+		t.Errorf("admin debug search = %d %s, want scores", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Request recording for debugging reports like "my request didn't do what
+// I expected". Only principals listed in RECORD_PRINCIPALS or switched on
+// through PUT /admin/recordings/targets/{principal} are recorded; nothing
+// is recorded by default. Recordings expire after RECORDING_TTL and are
+// purged by a TTL policy on request_recordings.expiresAt, or the ttl_sweep
+// job without one (see ttl.go); bodies are capped at RECORDING_MAX_BODY
+// bytes.
+var (
+	recordingTTL     = config.Duration("RECORDING_TTL", time.Hour)
+	recordingMaxBody = config.Int("RECORDING_MAX_BODY", 16<<10)
+	recordingMaxTTL  = 24 * time.Hour // longest an admin toggle may last
+	recordingTargets = &recordingTargetCache{refresh: 30 * time.Second}
+)
+
+// recordingTargetCache holds the principals switched on by admins, reloaded
+// from recording_targets at most every refresh
+type recordingTargetCache struct {
+	mu      sync.Mutex
+	refresh time.Duration
+	loaded  time.Time
+	until   map[string]time.Time
+}
+
+func (c *recordingTargetCache) enabled(principal string, targets *firestore.CollectionRef) bool {
+	for _, p := range strings.Split(config.String("RECORD_PRINCIPALS", ""), ",") {
+		if strings.TrimSpace(p) == principal {
+			return true
+		}
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.loaded) > c.refresh {
+		c.loaded = now // a failed load keeps the previous set until the next refresh
+		if until, err := loadRecordingTargets(targets); err != nil {
+			log.Printf("⚠️ Failed to load recording targets: %v", err)
+		} else {
+			c.until = until
+		}
+	}
+	return now.Before(c.until[principal])
+}
+
+func (c *recordingTargetCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = time.Time{}
+	c.mu.Unlock()
+}
+
+func loadRecordingTargets(targets *firestore.CollectionRef) (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	docs, err := targets.Where("until", ">", time.Now()).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	until := map[string]time.Time{}
+	for _, doc := range docs {
+		if t, ok := doc.Data()["until"].(time.Time); ok {
+			until[doc.Ref.ID] = t
+		}
+	}
+	return until, nil
+}
+
+// bodyCapture tees up to max bytes of what passes through it
+type bodyCapture struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (c *bodyCapture) capture(b []byte) {
+	if room := c.max - c.buf.Len(); room < len(b) {
+		c.truncated = true
+		if room < 0 {
+			room = 0
+		}
+		b = b[:room]
+	}
+	c.buf.Write(b)
+}
+
+// recordingWriter captures the status and the start of the response body
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bodyCapture
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Keep streaming handlers (export, reindex) streaming
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Record requests from targeted principals, together with their responses
+func (s *Server) recordingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFromRequest(r)
+		if principal == "" || strings.HasPrefix(r.URL.Path, "/admin/") || !recordingTargets.enabled(principal, s.client.Collection("recording_targets")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqBody := bodyCapture{max: recordingMaxBody}
+		if r.Body != nil {
+			head, _ := io.ReadAll(io.LimitReader(r.Body, int64(recordingMaxBody)+1))
+			reqBody.capture(head)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+		}
+		rec := &recordingWriter{ResponseWriter: w, body: bodyCapture{max: recordingMaxBody}}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		now := time.Now()
+		entry := map[string]interface{}{
+			"principal":             principal,
+			"clientIp":              clientIP(r),
+			"requestId":             requestID(r),
+			"method":                r.Method,
+			"path":                  r.URL.Path,
+			"query":                 redactQuery(r),
+			"headers":               redactHeaders(r.Header),
+			"body":                  sanitizeBody(reqBody.buf.Bytes()),
+			"bodyTruncated":         reqBody.truncated,
+			"status":                rec.status,
+			"responseHeaders":       redactHeaders(w.Header()),
+			"responseBody":          sanitizeBody(rec.body.buf.Bytes()),
+			"responseBodyTruncated": rec.body.truncated,
+			"durationMs":            now.Sub(start).Milliseconds(),
+			"createdAt":             now,
+			"expiresAt":             now.Add(recordingTTL),
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, _, err := s.client.Collection("request_recordings").Add(ctx, entry); err != nil {
+				log.Printf("⚠️ Failed to store request recording for %s: %v", principal, err)
+			}
+		}()
+	})
+}
+
+// JSON bodies are stored with REDACT_FIELDS redacted, other text
+// as-is, and binary bodies (protobuf) only by size
+func sanitizeBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) == nil {
+		return redactPayload(doc)
+	}
+	if !utf8.Valid(body) {
+		return map[string]interface{}{"binary": true, "bytes": len(body)}
+	}
+	return string(body)
+}
+
+// List a principal's unexpired recordings, newest first and without bodies
+// (GET /admin/recordings?principal=...). Needs a composite index on
+// principal + createdAt desc.
+func (s *Server) listRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	principal := r.URL.Query().Get("principal")
+	if principal == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Principal required")
+		return
+	}
+	docs, err := s.client.Collection("request_recordings").
+		Where("principal", "==", principal).
+		OrderBy("createdAt", firestore.Desc).
+		Limit(pageSizeParam(r, 50, 200)).
+		Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing recordings")
+		return
+	}
+	recordings := []map[string]interface{}{}
+	now := time.Now()
+	for _, doc := range docs {
+		data := doc.Data()
+		if expires, _ := data["expiresAt"].(time.Time); !expires.After(now) {
+			continue // TTL deletion lags behind expiry
+		}
+		summary := map[string]interface{}{"id": doc.Ref.ID}
+		for _, k := range []string{"requestId", "method", "path", "status", "durationMs", "createdAt", "expiresAt"} {
+			summary[k] = data[k]
+		}
+		recordings = append(recordings, summary)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"principal": principal, "recordings": recordings})
+}
+
+// Get one recorded request/response pair (GET /admin/recordings/{id})
+func (s *Server) getRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.client.Collection("request_recordings").Doc(r.PathValue("id")).Get(requestContext(r))
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "recording_not_found", "Recording not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading recording")
+		return
+	}
+	data := doc.Data()
+	if expires, _ := data["expiresAt"].(time.Time); !expires.After(time.Now()) {
+		writeError(w, r, http.StatusNotFound, "recording_not_found", "Recording not found")
+		return
+	}
+	data["id"] = doc.Ref.ID
+	writeJSON(w, r, http.StatusOK, data)
+}
+
+// Switch recording on for a principal for ?ttl= (default 15m, max 24h), or
+// off again (PUT|DELETE /admin/recordings/targets/{principal})
+func (s *Server) recordingTargetHandler(w http.ResponseWriter, r *http.Request) {
+	principal := r.PathValue("principal")
+	if strings.Contains(principal, "/") {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "Invalid principal")
+		return
+	}
+	ref := s.client.Collection("recording_targets").Doc(principal)
+	ctx := requestContext(r)
+	if r.Method == http.MethodDelete {
+		if _, err := ref.Delete(ctx); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error disabling recording")
+			return
+		}
+		recordingTargets.invalidate()
+		s.publishInvalidation("recording_targets")
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"principal": principal, "recording": false})
+		return
+	}
+
+	ttl := 15 * time.Minute
+	if raw := r.URL.Query().Get("ttl"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > recordingMaxTTL {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", "ttl must be a duration up to 24h")
+			return
+		}
+		ttl = d
+	}
+	until := time.Now().Add(ttl)
+	if _, err := ref.Set(ctx, map[string]interface{}{"until": until}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error enabling recording")
+		return
+	}
+	recordingTargets.invalidate()
+	s.publishInvalidation("recording_targets")
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"principal": principal, "recording": true, "until": until})
+}
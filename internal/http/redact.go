@@ -0,0 +1,179 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Redaction of captured payloads: audit entry details and request
+// recordings pass through redactPayload, redactHeaders and redactQuery
+// before they are stored. A field rule is a dot-separated path matched
+// case-insensitively against the end of a value's key path, so
+// attributes.ssn covers a body's attributes.ssn and an audit detail's
+// previous.attributes.ssn alike; * matches within one segment
+// (attributes.*Token), and arrays are looked through (items.secret covers
+// every element's secret). A match replaces the whole value.
+// REDACT_FIELDS and REDACT_HEADERS (comma separated) add rules to the
+// built-in ones, which can't be turned off. GET /admin/redaction lists
+// what is in effect.
+var (
+	redactedValue = "[REDACTED]"
+
+	builtinRedactFields  = []string{"passwordHash", "*password*", "*secret*", "*token*"}
+	builtinRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+	redactFieldRules  = parseRedactRules("REDACT_FIELDS", builtinRedactFields)
+	redactHeaderRules = parseRedactRules("REDACT_HEADERS", builtinRedactHeaders)
+)
+
+// redactRule is one field path or header name pattern
+type redactRule struct {
+	pattern  string
+	segments []string // lowercased
+	builtin  bool
+}
+
+// The built-in rules followed by those configured in env
+func parseRedactRules(env string, builtin []string) []redactRule {
+	var rules []redactRule
+	add := func(pattern string, builtin bool) {
+		rule := redactRule{pattern: pattern, builtin: builtin}
+		for _, seg := range strings.Split(strings.ToLower(pattern), ".") {
+			if _, err := path.Match(seg, ""); seg == "" || err != nil {
+				log.Fatalf("❌ Invalid %s rule %q", env, pattern)
+			}
+			rule.segments = append(rule.segments, seg)
+		}
+		rules = append(rules, rule)
+	}
+	for _, p := range builtin {
+		add(p, true)
+	}
+	for _, p := range strings.Split(config.String(env, ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			add(p, false)
+		}
+	}
+	return rules
+}
+
+// Whether rule covers the value at keys (lowercased), i.e. its segments
+// match the last of them
+func (rule redactRule) matches(keys []string) bool {
+	if len(keys) < len(rule.segments) {
+		return false
+	}
+	keys = keys[len(keys)-len(rule.segments):]
+	for i, seg := range rule.segments {
+		if ok, _ := path.Match(seg, keys[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func redacted(rules []redactRule, keys []string) bool {
+	for _, rule := range rules {
+		if rule.matches(keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// A copy of v with the values REDACT_FIELDS covers replaced, walking
+// nested maps and arrays; the input is left untouched. JSON numbers
+// decoded with UseNumber become their text.
+func redactPayload(v interface{}) interface{} {
+	return redactAt(v, nil)
+}
+
+func redactAt(v interface{}, keys []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			at := append(keys[:len(keys):len(keys)], strings.ToLower(k))
+			if redacted(redactFieldRules, at) {
+				out[k] = redactedValue
+			} else {
+				out[k] = redactAt(child, at)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = redactAt(child, keys)
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = redactAt(child, keys)
+		}
+		return out
+	case json.Number:
+		return t.String()
+	}
+	return v
+}
+
+// Audit entry details with REDACT_FIELDS applied
+func redactDetails(details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		return nil
+	}
+	return redactPayload(details).(map[string]interface{})
+}
+
+// Headers as stored in a recording, REDACT_HEADERS values replaced
+func redactHeaders(h http.Header) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range h {
+		if redacted(redactHeaderRules, []string{strings.ToLower(k)}) {
+			out[k] = redactedValue
+		} else {
+			out[k] = strings.Join(v, ", ")
+		}
+	}
+	return out
+}
+
+// Query parameters as stored in a recording; each name is a top-level
+// field for REDACT_FIELDS
+func redactQuery(r *http.Request) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range r.URL.Query() {
+		if redacted(redactFieldRules, []string{strings.ToLower(k)}) {
+			out[k] = redactedValue
+		} else {
+			out[k] = strings.Join(v, ",")
+		}
+	}
+	return out
+}
+
+// Show the redaction rules in effect (GET /admin/redaction), so auditors
+// can check what stored payloads leave out
+func redactionRulesHandler(w http.ResponseWriter, r *http.Request) {
+	list := func(rules []redactRule) []map[string]interface{} {
+		out := []map[string]interface{}{}
+		for _, rule := range rules {
+			out = append(out, map[string]interface{}{"pattern": rule.pattern, "builtin": rule.builtin})
+		}
+		return out
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"fields":      list(redactFieldRules),
+		"headers":     list(redactHeaderRules),
+		"replacement": redactedValue,
+		"appliesTo":   []string{"audit_logs.details", "request_recordings"},
+		"matching":    "case-insensitive, against the end of a key path; * within a segment; arrays looked through",
+	})
+}
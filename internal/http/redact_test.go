@@ -0,0 +1,94 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactPayload(t *testing.T) {
+	t.Setenv("REDACT_FIELDS", "attributes.ssn, cards.number, attributes.*Pin")
+	saved := redactFieldRules
+	redactFieldRules = parseRedactRules("REDACT_FIELDS", builtinRedactFields)
+	t.Cleanup(func() { redactFieldRules = saved })
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "top level builtin",
+			in:   `{"name":"Ada","passwordHash":"x","apiToken":"y"}`,
+			want: `{"apiToken":"[REDACTED]","name":"Ada","passwordHash":"[REDACTED]"}`,
+		},
+		{
+			name: "nested path matches the end of the key path",
+			in:   `{"previous":{"attributes":{"ssn":"123","team":"a"}},"ssn":"kept"}`,
+			want: `{"previous":{"attributes":{"ssn":"[REDACTED]","team":"a"}},"ssn":"kept"}`,
+		},
+		{
+			name: "whole value replaced",
+			in:   `{"clientSecret":{"id":1,"value":"s"}}`,
+			want: `{"clientSecret":"[REDACTED]"}`,
+		},
+		{
+			name: "arrays looked through",
+			in:   `{"cards":[{"number":"4111","brand":"visa"},{"number":"5500"}],"tags":["a",{"token":"t"}]}`,
+			want: `{"cards":[{"brand":"visa","number":"[REDACTED]"},{"number":"[REDACTED]"}],"tags":["a",{"token":"[REDACTED]"}]}`,
+		},
+		{
+			name: "nested arrays",
+			in:   `{"batches":[[{"password":"p","n":2}]]}`,
+			want: `{"batches":[[{"n":"2","password":"[REDACTED]"}]]}`,
+		},
+		{
+			name: "case insensitive keys and rules",
+			in:   `{"Attributes":{"SSN":"123","cardPIN":"0000","Pinned":"1"},"PASSWORD":"p","MySecretThing":1}`,
+			want: `{"Attributes":{"Pinned":"1","SSN":"[REDACTED]","cardPIN":"[REDACTED]"},"MySecretThing":"[REDACTED]","PASSWORD":"[REDACTED]"}`,
+		},
+		{
+			name: "a rule longer than the path",
+			in:   `{"ssn":"123","number":"4111"}`,
+			want: `{"number":"4111","ssn":"123"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := json.NewDecoder(strings.NewReader(tt.in))
+			dec.UseNumber()
+			var in map[string]interface{}
+			if err := dec.Decode(&in); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := json.Marshal(in)
+			got, err := json.Marshal(redactPayload(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("redactPayload(%s)\n got %s\nwant %s", tt.in, got, tt.want)
+			}
+			if after, _ := json.Marshal(in); !bytes.Equal(before, after) {
+				t.Errorf("input changed: %s, was %s", after, before)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("authorization", "Bearer x")
+	h["x-api-key"] = []string{"k"} // not canonicalized
+	h.Add("Accept", "text/html")
+	h.Add("Accept", "application/json")
+	want := map[string]interface{}{
+		"Authorization": redactedValue,
+		"x-api-key":     redactedValue,
+		"Accept":        "text/html, application/json",
+	}
+	if got := redactHeaders(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactHeaders = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Referrals: a user created with referredBy names the existing user who
+// invited them. referredBy and the referrer's referralCount live outside
+// the User schema, so updates leave them alone. referralCount counts
+// every referral made, including referred users deleted since.
+var (
+	errUnknownReferrer = errors.New("referredBy is not an existing user")
+	errSelfReferral    = errors.New("a user can't refer themselves")
+)
+
+// Deepest ?depth= for GET /users/{id}/referralChain
+const maxReferralChainDepth = 10
+
+// Decode a create body plus its optional referredBy. Protobuf bodies have
+// no field for it and are read as plain users.
+func decodeNewUserBody(r *http.Request, user *model.User, allowForm bool) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case formContentType, protobufContentType:
+		if err := decodeUserBody(r, user, allowForm); err != nil || mediaType == protobufContentType {
+			return "", err
+		}
+		return r.PostForm.Get("referredBy"), nil
+	}
+	var req struct {
+		model.User
+		ReferredBy string `json:"referredBy"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return "", err
+	}
+	*user = req.User
+	return req.ReferredBy, nil
+}
+
+// Check the referrer exists and count the referral. Reads the referrer,
+// so it must run before the transaction's writes.
+func (s *Server) referTx(tx *firestore.Transaction, referrerID, userID string) (func() error, error) {
+	if referrerID == userID {
+		return nil, errSelfReferral
+	}
+	ref := s.users.Users().Doc(referrerID)
+	doc, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound || err == nil && isSoftDeleted(doc) {
+		return nil, errUnknownReferrer
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return tx.Update(ref, withUpdatedAt([]firestore.Update{{Path: "referralCount", Value: firestore.Increment(1)}}))
+	}, nil
+}
+
+// Null out referredBy on everyone the deleted user referred, so their
+// reads and chains stop at a missing referrer instead of pointing at it
+func clearReferrals(ctx context.Context, c *firestore.Client, referrerID string) error {
+	iter := trackIterator("referrals", c.Collection("users").Where("referredBy", "==", referrerID).Documents(ctx))
+	defer iter.Stop()
+	bw := c.BulkWriter(ctx)
+	defer bw.End()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Update(doc.Ref, withUpdatedAt([]firestore.Update{{Path: "referredBy", Value: nil}})); err != nil {
+			return err
+		}
+	}
+}
+
+// Clear referrals in the background after a delete, with the client of
+// the request that deleted the user
+func (s *Server) clearReferralsAfterDelete(id string) {
+	c := s.client
+	go func() {
+		ctx, cancel := context.WithTimeout(withEndpoint(context.Background(), "referral_cleanup"), time.Minute)
+		defer cancel()
+		if err := clearReferrals(ctx, c, id); err != nil {
+			log.Printf("⚠️ Failed to clear referrals of deleted user %s: %v", id, err)
+		}
+	}()
+}
+
+// Users the user referred directly, newest first
+// (GET /users/{id}/referrals?pageSize=&pageToken=)
+func (s *Server) listReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	pageSize := pageSizeParam(r, 20, 100)
+
+	query := s.users.Users().Where("referredBy", "==", userID).
+		OrderBy("createdAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(pageSize)
+	cur, err := pageCursor(r, nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+	if cur != nil {
+		var createdAt time.Time
+		if len(cur.Values) == 1 {
+			createdAt, err = time.Parse(time.RFC3339Nano, cur.Values[0])
+		}
+		if len(cur.Values) != 1 || err != nil || cur.LastID == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+			return
+		}
+		if cur.Backward {
+			query = query.EndBefore(createdAt, cur.LastID).LimitToLast(pageSize)
+		} else {
+			query = query.StartAfter(createdAt, cur.LastID)
+		}
+	}
+
+	// LimitToLast queries can't be streamed, so pages are read with GetAll
+	docs, err := query.Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing referrals")
+		return
+	}
+	referrals := []map[string]interface{}{}
+	var first, last cursor.Cursor
+	for i, doc := range docs {
+		createdAt, _ := doc.Data()["createdAt"].(time.Time)
+		boundary := cursor.Cursor{LastID: doc.Ref.ID, Values: []string{createdAt.UTC().Format(time.RFC3339Nano)}}
+		if i == 0 {
+			first = boundary
+		}
+		last = boundary
+		if isSoftDeleted(doc) {
+			continue
+		}
+		user := userFromDoc(doc)
+		user.AvatarURL = avatarURL(doc.Ref.ID, user)
+		referrals = append(referrals, map[string]interface{}{
+			"id":        doc.Ref.ID,
+			"user":      user,
+			"createdAt": createdAt,
+		})
+	}
+
+	response := map[string]interface{}{
+		"referrals": referrals,
+	}
+	next, prev := pageTokens(r, cur, nil, first, last, len(docs), pageSize)
+	if next != "" {
+		response["nextPageToken"] = next
+	}
+	if prev != "" {
+		response["prevPageToken"] = prev
+	}
+	if links := pageLinks(r, next, prev); links != nil {
+		response["links"] = links
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// Walk up from a user through referredBy (GET /users/{id}/referralChain?depth=3).
+// The chain lists the referrer first, then theirs, and stops at depth, at
+// a user with no (or a deleted) referrer, or where it loops back on itself.
+func (s *Server) referralChainHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	depth, err := 3, error(nil)
+	if raw := r.URL.Query().Get("depth"); raw != "" {
+		depth, err = strconv.Atoi(raw)
+	}
+	if err != nil || depth < 1 || depth > maxReferralChainDepth {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "depth must be between 1 and 10")
+		return
+	}
+
+	ctx := requestContext(r)
+	doc, err := s.users.Users().Doc(userID).Get(ctx)
+	if err != nil || isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	chain := []map[string]interface{}{}
+	seen := map[string]bool{userID: true}
+	cycle := false
+	for len(chain) < depth {
+		next, _ := doc.Data()["referredBy"].(string)
+		if next == "" {
+			break
+		}
+		if seen[next] {
+			cycle = true
+			break
+		}
+		seen[next] = true
+		doc, err = s.users.Users().Doc(next).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			break
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading referral chain")
+			return
+		}
+		if isSoftDeleted(doc) {
+			break
+		}
+		user := userFromDoc(doc)
+		chain = append(chain, map[string]interface{}{
+			"id":    next,
+			"name":  user.Name,
+			"depth": len(chain) + 1,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"id":    userID,
+		"chain": chain,
+		"cycle": cycle,
+	})
+}
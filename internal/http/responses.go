@@ -0,0 +1,122 @@
+package http
+
+import "github.com/Altair-05/GoFirestoreApp/api"
+
+// Typed bodies for the legacy (unversioned) routes and for errors, so the
+// field set of each response is fixed in one place. They live in the api
+// package, shared with the client; the v1 bodies are in v1.go.
+type (
+	UserEnvelope    = api.UserEnvelope
+	UserListEntry   = api.UserListEntry
+	MessageResponse = api.MessageResponse
+	ErrorResponse   = api.ErrorResponse
+)
+
+// Bodies of the routes the client package doesn't cover. Pagination
+// fields are left out when there's no other page to go to.
+
+// HistoryPage is a page of GET /users/{id}/history
+type HistoryPage struct {
+	History       []HistoryEntry    `json:"history"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+	PrevPageToken string            `json:"prevPageToken,omitempty"`
+	Links         map[string]string `json:"links,omitempty"`
+}
+
+// VersionDiffResponse is GET /users/{id}/diff: the fields added, removed
+// and changed between two versions
+type VersionDiffResponse struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	Diff
+}
+
+// QuotaResponse is GET /admin/quota
+type QuotaResponse struct {
+	Principal string `json:"principal"`
+	Day       string `json:"day"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+}
+
+// QuotaResetResponse is DELETE /admin/quota
+type QuotaResetResponse struct {
+	Message   string `json:"message"`
+	Principal string `json:"principal"`
+	Day       string `json:"day"`
+}
+
+// NotificationEntry is one notification with its ID, as listed and streamed
+type NotificationEntry struct {
+	ID           string       `json:"id"`
+	Notification Notification `json:"notification"`
+}
+
+// NotificationCreated is POST /users/{id}/notifications
+type NotificationCreated struct {
+	Message string `json:"message"`
+	NotificationEntry
+}
+
+// NotificationPage is a page of GET /users/{id}/notifications
+type NotificationPage struct {
+	Notifications []NotificationEntry `json:"notifications"`
+	NextPageToken string              `json:"nextPageToken,omitempty"`
+	PrevPageToken string              `json:"prevPageToken,omitempty"`
+	Links         map[string]string   `json:"links,omitempty"`
+}
+
+// NotificationsMarkedRead is POST /users/{id}/notifications:markAllRead
+type NotificationsMarkedRead struct {
+	Message string `json:"message"`
+	Updated int    `json:"updated"`
+}
+
+// UnreadCountResponse is GET /users/{id}/notifications/unreadCount
+type UnreadCountResponse struct {
+	ID     string `json:"id"`
+	Unread int64  `json:"unread"`
+}
+
+// PreferencesResponse is a user's preferences; Default is set when the
+// user hasn't saved any
+type PreferencesResponse struct {
+	ID          string      `json:"id"`
+	Preferences Preferences `json:"preferences"`
+	Default     bool        `json:"default"`
+}
+
+// CloneResponse is POST /users/{id}:clone: the new user and the
+// subcollection documents copied to it
+type CloneResponse struct {
+	Message          string            `json:"message"`
+	ID               string            `json:"id"`
+	SourceID         string            `json:"sourceId"`
+	TargetCollection string            `json:"targetCollection"`
+	Copied           map[string]int    `json:"copied"`
+	Truncated        []string          `json:"truncated"`
+	Links            map[string]string `json:"links,omitempty"`
+}
+
+// DuplicateGroup is users sharing an email, in GET /admin/duplicates
+type DuplicateGroup struct {
+	Email   string   `json:"email"`
+	UserIDs []string `json:"userIds"`
+}
+
+// DuplicatesResponse is GET /admin/duplicates
+type DuplicatesResponse struct {
+	Groups []DuplicateGroup `json:"groups"`
+}
+
+// MergeReport is POST /admin/users:merge, also kept in its audit entry
+type MergeReport struct {
+	DryRun           bool              `json:"dryRun"`
+	PrimaryID        string            `json:"primaryId"`
+	DuplicateIDs     []string          `json:"duplicateIds"`
+	CopiedFields     map[string]string `json:"copiedFields"`
+	MovedDocuments   []string          `json:"movedDocuments"`
+	SkippedDocuments []string          `json:"skippedDocuments"`
+}
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// A page without others around it has no pagination fields, and a diff
+// response carries the diff's fields at the top level
+func TestResponseShapes(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{HistoryPage{History: []HistoryEntry{}}, `{"history":[]}`},
+		{NotificationPage{Notifications: []NotificationEntry{}, NextPageToken: "n"}, `{"notifications":[],"nextPageToken":"n"}`},
+		{VersionDiffResponse{ID: "u", From: "1", To: "current", Diff: Diff{Added: []FieldChange{}}},
+			`{"id":"u","from":"1","to":"current","added":[],"removed":null,"changed":null}`},
+		{NotificationCreated{Message: "m", NotificationEntry: NotificationEntry{ID: "n"}},
+			`{"message":"m","id":"n","notification":{"title":"","body":"","createdAt":"0001-01-01T00:00:00Z","readAt":null}}`},
+	} {
+		got, err := json.Marshal(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%T = %s, want %s", tc.v, got, tc.want)
+		}
+	}
+}
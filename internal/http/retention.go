@@ -0,0 +1,449 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/iterator"
+)
+
+// Retention for the auxiliary collections. RETENTION_POLICIES declares
+// one policy per collection, ";"-separated, as collection:maxAge=2160h or
+// history:maxCount=50,maxAge=8760h; maxCount is per parent for
+// subcollections (the newest 50 versions of each user) and collection-wide
+// otherwise. The retention job (RETENTION_SCHEDULE, or POST
+// /admin/retention, ?dryRun=true to only count) deletes what the policies
+// expire in batches of RETENTION_BATCH_SIZE, at most RETENTION_RATE
+// documents a second, counting them per policy on GET /admin/metrics.
+//
+// Ages and counts go by the collection's timestamp (retentionTargets);
+// documents without it are left alone and not counted, and outbox events
+// still pending or jobs not yet finished are never deleted. Any other
+// collection needs field=, and users is touched only when listed, its
+// documents deleted as DELETE /users/{id} would, protection included.
+// maxAge on a subcollection queries it as a collection group, which needs
+// the field's single-field index enabled for collection group scope.
+//
+// Tombstones and read notifications keep their own settings
+// (DELETED_USER_RETENTION, NOTIFICATION_RETENTION) and jobs, which run on
+// this engine.
+var (
+	retentionPolicies  = parseRetentionPolicies(config.String("RETENTION_POLICIES", ""))
+	retentionSchedule  = config.String("RETENTION_SCHEDULE", "30 3 * * *")
+	retentionBatchSize = config.Int("RETENTION_BATCH_SIZE", 200)
+	retentionRate      = config.Int("RETENTION_RATE", 500)
+	retentionDeleted   = &retentionCounters{counts: map[string]int64{}}
+)
+
+// retentionTarget is how a policy finds a collection's expired documents
+type retentionTarget struct {
+	group bool                              // a subcollection, with a count per parent
+	field string                            // timestamp ages and counts go by
+	keep  func(map[string]interface{}) bool // documents never deleted, counted all the same
+}
+
+var retentionTargets = map[string]retentionTarget{
+	"audit_logs":         {field: "at"},
+	"history":            {group: true, field: "changedAt"},
+	"request_recordings": {field: "createdAt"},
+	"outbox":             {field: "createdAt", keep: func(data map[string]interface{}) bool { return data["state"] == outboxPending }},
+	"jobs":               {field: "createdAt", keep: func(data map[string]interface{}) bool { return !finishedJobState(storedString(data, "state")) }},
+	"users":              {field: "createdAt"},
+	"deleted_users":      {field: "deletedAt"},
+	"notifications":      {group: true, field: "readAt"},
+}
+
+// Collections whose retention has its own setting
+var retentionOwnSettings = map[string]string{
+	"deleted_users": "DELETED_USER_RETENTION",
+	"notifications": "NOTIFICATION_RETENTION",
+}
+
+type retentionPolicy struct {
+	collection string
+	retentionTarget
+	maxAge   time.Duration // 0 for none
+	maxCount int           // 0 for none
+}
+
+// retentionCounters counts documents deleted, by policy
+type retentionCounters struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *retentionCounters) add(policy string, n int) {
+	c.mu.Lock()
+	c.counts[policy] += int64(n)
+	c.mu.Unlock()
+}
+
+// Parse RETENTION_POLICIES; a bad entry is fatal, as with other startup
+// configuration
+func parseRetentionPolicies(spec string) []retentionPolicy {
+	var policies []retentionPolicy
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		collection, settings, _ := strings.Cut(entry, ":")
+		collection = strings.TrimSpace(collection)
+		if setting, ok := retentionOwnSettings[collection]; ok {
+			log.Fatalf("❌ Invalid RETENTION_POLICIES entry %q: %s retention is set by %s", entry, collection, setting)
+		}
+		if collection == "" || strings.Contains(collection, "/") || seen[collection] {
+			log.Fatalf("❌ Invalid RETENTION_POLICIES entry %q: want one collection:maxAge=...,maxCount=... per collection", entry)
+		}
+		seen[collection] = true
+		p := retentionPolicy{collection: collection, retentionTarget: retentionTargets[collection]}
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(setting), "=")
+			var err error
+			switch key {
+			case "maxAge":
+				p.maxAge, err = time.ParseDuration(value)
+				if err == nil && p.maxAge <= 0 {
+					err = fmt.Errorf("maxAge must be positive")
+				}
+			case "maxCount":
+				p.maxCount, err = strconv.Atoi(value)
+				if err == nil && p.maxCount <= 0 {
+					err = fmt.Errorf("maxCount must be positive")
+				}
+			case "field":
+				p.field = value
+			default:
+				err = fmt.Errorf("unknown setting %q", key)
+			}
+			if err != nil {
+				log.Fatalf("❌ Invalid RETENTION_POLICIES entry %q: %v", entry, err)
+			}
+		}
+		if p.field == "" || p.maxAge == 0 && p.maxCount == 0 {
+			log.Fatalf("❌ Invalid RETENTION_POLICIES entry %q: needs maxAge or maxCount, and field= for a collection without a known timestamp", entry)
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+func (p retentionPolicy) describe() map[string]interface{} {
+	out := map[string]interface{}{"collection": p.collection, "field": p.field, "perParent": p.group}
+	if p.maxAge > 0 {
+		out["maxAge"] = p.maxAge.String()
+	}
+	if p.maxCount > 0 {
+		out["maxCount"] = p.maxCount
+	}
+	return out
+}
+
+func (p retentionPolicy) query(client *firestore.Client) firestore.Query {
+	if p.group {
+		return client.CollectionGroup(p.collection).Query
+	}
+	return client.Collection(p.collection).Query
+}
+
+// retentionSweep deletes one policy's documents in rate-limited batches
+type retentionSweep struct {
+	server   *Server
+	policy   retentionPolicy
+	dryRun   bool
+	scanned  int
+	deleted  int
+	pending  []*firestore.DocumentRef
+	progress func()
+}
+
+// Apply p: documents older than maxAge, then those beyond the newest
+// maxCount. A dry run counts them without deleting.
+func (s *Server) enforceRetention(ctx context.Context, p retentionPolicy, dryRun bool, progress func(scanned int)) (scanned, deleted int, err error) {
+	sweep := &retentionSweep{server: s, policy: p, dryRun: dryRun}
+	sweep.progress = func() {
+		if progress != nil {
+			progress(sweep.scanned)
+		}
+	}
+	if p.maxAge > 0 {
+		err = sweep.sweep(ctx, p.query(s.client).Where(p.field, "<", time.Now().Add(-p.maxAge)))
+	}
+	if err == nil && p.maxCount > 0 && p.group {
+		err = sweep.sweepPerParent(ctx)
+	} else if err == nil && p.maxCount > 0 {
+		err = sweep.sweep(ctx, p.query(s.client).OrderBy(p.field, firestore.Desc).Offset(p.maxCount))
+	}
+	if err == nil {
+		err = sweep.flush(ctx)
+	}
+	return sweep.scanned, sweep.deleted, err
+}
+
+// Delete every document q returns that the target doesn't keep
+func (s *retentionSweep) sweep(ctx context.Context, q firestore.Query) error {
+	iter := trackIterator("retention", q.Documents(ctx))
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.scanned++
+		s.progress()
+		if err := s.expire(ctx, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// Scan the subcollection in document name order, which keeps each parent's
+// documents together, and expire all but the newest maxCount of each
+func (s *retentionSweep) sweepPerParent(ctx context.Context) error {
+	iter := trackIterator("retention", s.policy.query(s.server.client).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx))
+	defer iter.Stop()
+	var parent string
+	var docs []retentionDoc
+	expireExcess := func() error {
+		for _, d := range newestExcess(docs, s.policy.maxCount) {
+			if err := s.expire(ctx, d.doc); err != nil {
+				return err
+			}
+		}
+		docs = docs[:0]
+		return nil
+	}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.scanned++
+		s.progress()
+		if doc.Ref.Parent.Path != parent {
+			if err := expireExcess(); err != nil {
+				return err
+			}
+			parent = doc.Ref.Parent.Path
+		}
+		if at, ok := doc.Data()[s.policy.field].(time.Time); ok {
+			docs = append(docs, retentionDoc{doc: doc, at: at})
+		}
+	}
+	return expireExcess()
+}
+
+// retentionDoc is a document counted by a maxCount policy
+type retentionDoc struct {
+	doc *firestore.DocumentSnapshot
+	at  time.Time
+}
+
+// The documents beyond the newest n, newest first
+func newestExcess(docs []retentionDoc, n int) []retentionDoc {
+	if len(docs) <= n {
+		return nil
+	}
+	sorted := append([]retentionDoc(nil), docs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].at.After(sorted[j].at) })
+	return sorted[n:]
+}
+
+// Queue doc for deletion unless the target keeps it. However a policy
+// names its collection, nothing but a users policy deletes from users.
+func (s *retentionSweep) expire(ctx context.Context, doc *firestore.DocumentSnapshot) error {
+	if s.policy.keep != nil && s.policy.keep(doc.Data()) {
+		return nil
+	}
+	if doc.Ref.Parent.Path == s.server.users.Users().Path && s.policy.collection != "users" {
+		return nil
+	}
+	s.pending = append(s.pending, doc.Ref)
+	if len(s.pending) >= max(retentionBatchSize, 1) {
+		return s.flush(ctx)
+	}
+	return nil
+}
+
+// Delete the pending batch, then wait as long as RETENTION_RATE asks
+func (s *retentionSweep) flush(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	if s.dryRun {
+		s.deleted += len(batch)
+		return nil
+	}
+	started := time.Now()
+	deleted, err := s.delete(ctx, batch)
+	s.deleted += deleted
+	retentionDeleted.add(s.policy.collection, deleted)
+	if err != nil {
+		return err
+	}
+	if retentionRate > 0 {
+		wait := time.Duration(len(batch))*time.Second/time.Duration(retentionRate) - time.Since(started)
+		select {
+		case <-time.After(max(wait, 0)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Users go through deleteUser, so their email and tombstone are handled
+// and protected ones stay; everything else through a BulkWriter
+func (s *retentionSweep) delete(ctx context.Context, refs []*firestore.DocumentRef) (int, error) {
+	if s.policy.collection == "users" {
+		deleted := 0
+		for _, ref := range refs {
+			err := s.server.deleteUser(ctx, ref.ID, "retention", false)
+			switch {
+			case err == nil:
+				deleted++
+			case err == errUserProtected || err == store.ErrUserNotFound:
+			default:
+				return deleted, err
+			}
+		}
+		return deleted, nil
+	}
+	bw := s.server.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	for _, ref := range refs {
+		job, err := bw.Delete(ref)
+		if err != nil {
+			bw.End()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	deleted := 0
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil && firstErr == nil {
+			firstErr = err
+		} else if err == nil {
+			deleted++
+		}
+	}
+	return deleted, firstErr
+}
+
+// Apply every policy, or the one in params.policy. The checkpoint is the
+// policy being applied, so a takeover starts over with it; deletes are
+// idempotent.
+func (s *Server) runRetentionJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	dryRun := run.dryRun()
+	only, _ := run.job.Params["policy"].(string)
+	resume := run.checkpoint()
+	results := []map[string]interface{}{}
+	result := map[string]interface{}{"dryRun": dryRun, "policies": results}
+	scannedBefore := 0
+	for _, p := range retentionPolicies {
+		if only != "" && p.collection != only {
+			continue
+		}
+		if resume != "" && p.collection != resume {
+			continue
+		}
+		resume = ""
+		run.progress(scannedBefore, 0, p.collection)
+		scanned, deleted, err := s.enforceRetention(ctx, p, dryRun, func(n int) {
+			run.progress(scannedBefore+n, 0, p.collection)
+		})
+		scannedBefore += scanned
+		entry := p.describe()
+		entry["scanned"] = scanned
+		if dryRun {
+			entry["wouldDelete"] = deleted
+		} else {
+			entry["deleted"] = deleted
+		}
+		results = append(results, entry)
+		result["policies"] = results
+		if err != nil {
+			return result, fmt.Errorf("retention of %s: %w", p.collection, err)
+		}
+		if deleted > 0 && !dryRun {
+			log.Printf("🧹 Retention deleted %d %s documents", deleted, p.collection)
+		}
+	}
+	return result, nil
+}
+
+// List the policies (GET /admin/retention), or apply them as a retention
+// job (POST /admin/retention?policy=&dryRun=true)
+func (s *Server) retentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		policies := []map[string]interface{}{}
+		for _, p := range retentionPolicies {
+			policies = append(policies, p.describe())
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"policies": policies, "schedule": retentionSchedule})
+		return
+	}
+	params := map[string]interface{}{"dryRun": dryRunRequested(r)}
+	if only := r.URL.Query().Get("policy"); only != "" {
+		found := false
+		for _, p := range retentionPolicies {
+			found = found || p.collection == only
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "not_found", "No retention policy for "+only)
+			return
+		}
+		params["policy"] = only
+	}
+	id, err := s.startJob(requestContext(r), "retention", params)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting retention")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// Prometheus counter of documents deleted by retention, for metricsHandler
+func writeRetentionMetrics(w io.Writer) {
+	retentionDeleted.mu.Lock()
+	counts := make(map[string]int64, len(retentionDeleted.counts))
+	for k, n := range retentionDeleted.counts {
+		counts[k] = n
+	}
+	retentionDeleted.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	policies := make([]string, 0, len(counts))
+	for k := range counts {
+		policies = append(policies, k)
+	}
+	sort.Strings(policies)
+	fmt.Fprintln(w, "# HELP retention_deleted_total Documents deleted by retention, by policy.")
+	fmt.Fprintln(w, "# TYPE retention_deleted_total counter")
+	for _, k := range policies {
+		fmt.Fprintf(w, "retention_deleted_total{policy=%s} %d\n", promLabel(k), counts[k])
+	}
+}
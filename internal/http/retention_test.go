@@ -0,0 +1,66 @@
+package http
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// newestExcess keeps the newest n of a parent's documents. sweepPerParent
+// passes them in document ID order and the sort is stable, so of
+// documents with equal timestamps the lower IDs are kept, run after run.
+func TestNewestExcess(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name string
+		docs map[string]time.Time // by ID
+		n    int
+		want []string // expired, newest first
+	}{
+		{name: "fewer than n", docs: map[string]time.Time{"a": at(1), "b": at(2)}, n: 3},
+		{name: "exactly n", docs: map[string]time.Time{"a": at(1), "b": at(2)}, n: 2},
+		{
+			name: "oldest expire",
+			docs: map[string]time.Time{"a": at(3), "b": at(1), "c": at(4), "d": at(2)},
+			n:    2,
+			want: []string{"d", "b"},
+		},
+		{
+			name: "tie across the cut",
+			docs: map[string]time.Time{"a": at(1), "b": at(5), "c": at(5), "d": at(5), "e": at(0)},
+			n:    2,
+			want: []string{"d", "a", "e"},
+		},
+		{
+			name: "all equal",
+			docs: map[string]time.Time{"a": at(1), "b": at(1), "c": at(1)},
+			n:    1,
+			want: []string{"b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := slices.Sorted(maps.Keys(tt.docs))
+			var docs []retentionDoc
+			for _, id := range ids {
+				docs = append(docs, retentionDoc{doc: &firestore.DocumentSnapshot{Ref: &firestore.DocumentRef{ID: id}}, at: tt.docs[id]})
+			}
+			var got []string
+			for _, d := range newestExcess(docs, tt.n) {
+				got = append(got, d.doc.Ref.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newestExcess(n=%d) = %q, want %q", tt.n, got, tt.want)
+			}
+			for i, d := range docs {
+				if id := ids[i]; d.doc.Ref.ID != id {
+					t.Fatalf("input reordered: [%d] = %s, want %s", i, d.doc.Ref.ID, id)
+				}
+			}
+		})
+	}
+}
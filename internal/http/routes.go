@@ -0,0 +1,96 @@
+package http
+
+import "net/http"
+
+// Every route of the public listener
+func (s *Server) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", s.homeHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.Handle("GET /static/", staticHandler())
+	if consoleEnabled {
+		mux.HandleFunc("GET /console", consoleHandler)
+	}
+	mux.HandleFunc("/addUser", s.quotaMiddleware(s.addUserHandler))
+	mux.HandleFunc("/getUser", s.getUserHandler)
+	mux.HandleFunc("GET /users/{id}", s.getUserHandler)
+	mux.HandleFunc("POST /users:exists", s.usersExistHandler)
+	mux.HandleFunc("/getUserByEmail", s.getUserByEmailHandler)
+	mux.HandleFunc("/updateUser", s.quotaMiddleware(s.updateUserHandler))
+	mux.HandleFunc("/deleteUser", s.quotaMiddleware(s.deleteUserHandler))
+	mux.HandleFunc("/listUsers", cacheListResponses("users", s.listUsersHandler))
+	mux.HandleFunc("/admin/quota", requireAdmin(s.adminQuotaHandler))
+	mux.HandleFunc("/admin/reindex", requireAdmin(s.reindexHandler))
+	mux.HandleFunc("/admin/emailIndex:check", requireAdmin(s.emailIndexCheckHandler))
+	mux.HandleFunc("/admin/duplicates", requireAdmin(s.duplicatesHandler))
+	mux.HandleFunc("/admin/users:merge", requireAdmin(s.mergeUsersHandler))
+	mux.HandleFunc("/admin/users:export", requireAdmin(s.exportUsersHandler))
+	mux.HandleFunc("POST /admin/export/sheets", requireAdmin(s.sheetsExportHandler))
+	mux.HandleFunc("POST /admin/export/bigquery", requireAdmin(s.bigqueryExportHandler))
+	mux.HandleFunc("POST /admin/import/workspace", requireAdmin(s.workspaceImportHandler))
+	mux.HandleFunc("GET /admin/export/zip", requireAdmin(s.zipExportHandler))
+	mux.HandleFunc("GET /admin/auditLogs/export", requireAdmin(s.auditLogExportHandler))
+	mux.HandleFunc("POST /admin/import/zip", requireAdmin(s.zipImportHandler))
+	mux.HandleFunc("/admin/users:malformed", requireAdmin(s.malformedUsersHandler))
+	mux.HandleFunc("GET /admin/schema", requireAdmin(s.schemaHandler))
+	mux.HandleFunc("POST /admin/generateUsers", requireAdmin(s.generateUsersHandler))
+	mux.HandleFunc("POST /users:updateWhere", requireAdmin(s.updateWhereHandler))
+	mux.HandleFunc("POST /users:archiveWhere", requireAdmin(s.archiveWhereHandler))
+	mux.HandleFunc("POST /users:anonymizeWhere", requireAdmin(s.anonymizeWhereHandler))
+	mux.HandleFunc("/admin/migrations", requireAdmin(s.migrationsHandler))
+	mux.HandleFunc("GET /admin/outbox", requireAdmin(s.listOutboxHandler))
+	mux.HandleFunc("POST /admin/outbox/{id}", requireAdmin(s.retryOutboxHandler))
+	mux.HandleFunc("GET /admin/flags", requireAdmin(listFlagsHandler))
+	mux.HandleFunc("GET /admin/webhooks", requireAdmin(s.listWebhooksHandler))
+	mux.HandleFunc("POST /admin/webhooks", requireAdmin(s.saveWebhookHandler))
+	mux.HandleFunc("GET /admin/webhooks/{id}", requireAdmin(s.getWebhookHandler))
+	mux.HandleFunc("PUT /admin/webhooks/{id}", requireAdmin(s.saveWebhookHandler))
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", requireAdmin(s.deleteWebhookHandler))
+	mux.HandleFunc("GET /admin/jobs", requireAdmin(s.listJobsHandler))
+	mux.HandleFunc("GET /admin/jobs/{id}", requireAdmin(s.getJobHandler))
+	mux.HandleFunc("GET /admin/schedule", requireAdmin(s.scheduleHandler))
+	mux.HandleFunc("POST /admin/jobs/{id}", requireAdmin(s.cancelJobHandler))
+	mux.HandleFunc("GET /admin/usage", requireAdmin(s.usageHandler))
+	mux.HandleFunc("GET /admin/metrics", requireAdmin(metricsHandler))
+	mux.HandleFunc("GET /admin/slo", requireAdmin(sloHandler))
+	mux.HandleFunc("GET /admin/selftest", requireAdmin(s.selftestHandler))
+	mux.HandleFunc("GET /admin/slowlog", requireAdmin(slowlogHandler))
+	mux.HandleFunc("GET /admin/redaction", requireAdmin(redactionRulesHandler))
+	mux.HandleFunc("GET /admin/ttlPolicy", requireAdmin(ttlPolicyHandler))
+	mux.HandleFunc("POST /admin/ttlPolicy", requireAdmin(ttlPolicyHandler))
+	mux.HandleFunc("GET /admin/retention", requireAdmin(s.retentionHandler))
+	mux.HandleFunc("POST /admin/retention", requireAdmin(s.retentionHandler))
+	mux.HandleFunc("GET /admin/recordings", requireAdmin(s.listRecordingsHandler))
+	mux.HandleFunc("GET /admin/recordings/{id}", requireAdmin(s.getRecordingHandler))
+	mux.HandleFunc("PUT /admin/recordings/targets/{principal}", requireAdmin(s.recordingTargetHandler))
+	mux.HandleFunc("DELETE /admin/recordings/targets/{principal}", requireAdmin(s.recordingTargetHandler))
+	mux.HandleFunc("GET /users/search", s.searchUsersHandler)
+	mux.HandleFunc("GET /users/leaderboard", s.leaderboardHandler)
+	mux.HandleFunc("GET /users/sync", s.syncUsersHandler)
+	mux.HandleFunc("GET /users/{id}/rank", s.userRankHandler)
+	mux.HandleFunc("GET /v1/users", s.v1ListUsersHandler)
+	mux.HandleFunc("GET /v1/users/{id}", s.v1GetUserHandler)
+	mux.HandleFunc("POST /users/{id}", s.quotaMiddleware(s.userActionHandler))
+	mux.HandleFunc("POST /users/{id}/notifications", requireAdmin(s.createNotificationHandler))
+	mux.HandleFunc("GET /users/{id}/notifications", s.listNotificationsHandler)
+	mux.HandleFunc("GET /users/{id}/notifications/unreadCount", s.unreadNotificationCountHandler)
+	mux.HandleFunc("GET /users/{id}/notifications/stream", s.streamNotificationsHandler)
+	mux.HandleFunc("POST /users/{id}/notifications/{nid}", s.quotaMiddleware(s.markNotificationReadHandler))
+	mux.HandleFunc("POST /users/{id}/notifications:markAllRead", s.quotaMiddleware(s.markAllNotificationsReadHandler))
+	mux.HandleFunc("GET /users/{id}/avatar.svg", s.avatarHandler)
+	mux.HandleFunc("GET /users/{id}/history", s.listHistoryHandler)
+	mux.HandleFunc("GET /users/{id}/diff", s.diffHandler)
+	mux.HandleFunc("GET /users/{id}/timeline", s.timelineHandler)
+	mux.HandleFunc("GET /users/{id}/consents", s.getConsentsHandler)
+	mux.HandleFunc("GET /users/{id}/referrals", s.listReferralsHandler)
+	mux.HandleFunc("GET /users/{id}/referralChain", s.referralChainHandler)
+	mux.HandleFunc("POST /users/{id}/consents", s.quotaMiddleware(s.postConsentHandler))
+	mux.HandleFunc("GET /users/{id}/preferences", s.getPreferencesHandler)
+	mux.HandleFunc("PUT /users/{id}/preferences", s.quotaMiddleware(s.putPreferencesHandler))
+	mux.HandleFunc("PATCH /users/{id}/preferences", s.quotaMiddleware(s.patchPreferencesHandler))
+}
+
+// The public listener's middleware around mux
+func (s *Server) newHandler(mux *http.ServeMux) http.Handler {
+	return requestIDMiddleware(environmentMiddleware(budgetMiddleware(traceMiddleware(accessLogMiddleware(chaosMiddleware(sloMiddleware(securityHeadersMiddleware(endpointMiddleware(mux, loadSheddingMiddleware(bodyLimitMiddleware(documentIDMiddleware(timezoneMiddleware(s.recordingMiddleware(csrfMiddleware(syncTokenMiddleware(s.listCacheInvalidation(hideDebugPaths(mux))))))))))))))))))
+}
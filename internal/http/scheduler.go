@@ -0,0 +1,365 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The scheduler starts recurring work on cron schedules (5 fields,
+// minute hour day-of-month month day-of-week, in UTC). Each firing is
+// claimed in schedules/{task} inside a transaction, so with several
+// replicas exactly one queues it; the run itself is a job, so progress,
+// leases and retries come from the job framework. A firing is skipped
+// while the task's previous job is still queued or running, and firings
+// missed while no replica was up are not caught up.
+//
+// A task with an empty schedule is disabled.
+var scheduledTasks = []ScheduledTask{
+	jobTask{name: "notification_prune", jobType: "prune_notifications", schedule: config.String("NOTIFICATION_PRUNE_SCHEDULE", "0 * * * *")},
+	jobTask{name: "email_index_check", jobType: "email_index_check", schedule: config.String("EMAIL_INDEX_CHECK_SCHEDULE", "")},
+	jobTask{name: "bigquery_export", jobType: "bigquery_export", schedule: bigqueryExportSchedule, params: map[string]interface{}{"mode": "incremental"}},
+	jobTask{name: "siem_forward", jobType: "siem_forward", schedule: siemForwardSchedule},
+	jobTask{name: "deleted_user_prune", jobType: "prune_deleted_users", schedule: config.String("DELETED_USER_PRUNE_SCHEDULE", "0 4 * * *")},
+	jobTask{name: "ttl_sweep", jobType: "ttl_sweep", schedule: ttlSweepSchedule},
+	jobTask{name: "retention", jobType: "retention", schedule: retentionSchedule},
+}
+
+// ScheduledTask is recurring work: at each firing of Schedule a job of
+// JobType is started with Params
+type ScheduledTask interface {
+	Name() string
+	Schedule() string
+	JobType() string
+	Params() map[string]interface{}
+}
+
+// jobTask schedules one registered job type with fixed params
+type jobTask struct {
+	name, jobType, schedule string
+	params                  map[string]interface{}
+}
+
+func (t jobTask) Name() string     { return t.name }
+func (t jobTask) Schedule() string { return t.schedule }
+func (t jobTask) JobType() string  { return t.jobType }
+
+func (t jobTask) Params() map[string]interface{} {
+	params := map[string]interface{}{}
+	for k, v := range t.params {
+		params[k] = v
+	}
+	return params
+}
+
+// A task with its parsed schedule
+type scheduledEntry struct {
+	task ScheduledTask
+	cron *cronSchedule
+	next time.Time
+}
+
+var (
+	schedulerMu      sync.Mutex
+	schedulerEntries []*scheduledEntry
+)
+
+// Parse the schedules and run the scheduler until bg stops. A bad cron
+// expression is fatal, as with other startup configuration.
+func (s *Server) startScheduler(bg *background) {
+	now := time.Now().UTC()
+	var entries []*scheduledEntry
+	for _, task := range scheduledTasks {
+		if task.Schedule() == "" {
+			continue
+		}
+		if _, ok := jobRunners[task.JobType()]; !ok {
+			log.Fatalf("Scheduled task %s runs unknown job type %q", task.Name(), task.JobType())
+		}
+		cron, err := parseCron(task.Schedule())
+		if err != nil {
+			log.Fatalf("Invalid schedule for %s: %v", task.Name(), err)
+		}
+		entries = append(entries, &scheduledEntry{task: task, cron: cron, next: cron.next(now)})
+	}
+	schedulerMu.Lock()
+	schedulerEntries = entries
+	schedulerMu.Unlock()
+	bg.Go(func(ctx context.Context) {
+		for {
+			wait := time.Minute
+			schedulerMu.Lock()
+			for _, e := range schedulerEntries {
+				wait = min(wait, time.Until(e.next))
+			}
+			schedulerMu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(max(wait, 0)):
+			}
+			s.fireDueTasks(ctx)
+		}
+	})
+}
+
+func (s *Server) fireDueTasks(ctx context.Context) {
+	now := time.Now().UTC()
+	schedulerMu.Lock()
+	var due []*scheduledEntry
+	slots := map[*scheduledEntry]time.Time{}
+	for _, e := range schedulerEntries {
+		if !e.next.IsZero() && !e.next.After(now) {
+			due = append(due, e)
+			slots[e] = e.next
+			e.next = e.cron.next(now)
+		}
+	}
+	schedulerMu.Unlock()
+	for _, e := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		s.fireTask(withEndpoint(ctx, "scheduler"), e.task, slots[e])
+	}
+}
+
+// Claim one firing of a task and queue its job. The claim and the job are
+// written together, so a firing is never claimed without its job.
+func (s *Server) fireTask(ctx context.Context, task ScheduledTask, slot time.Time) {
+	ref := s.client.Collection("schedules").Doc(task.Name())
+	jobRef := s.client.Collection("jobs").NewDoc()
+	outcome, running := "started", ""
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		outcome, running = "started", ""
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var lastJobID string
+		if err == nil {
+			if last, _ := doc.Data()["lastSlot"].(time.Time); !last.Before(slot) {
+				outcome = "claimed_elsewhere"
+				return nil
+			}
+			lastJobID, _ = doc.Data()["lastJobId"].(string)
+		}
+		if lastJobID != "" {
+			job, err := tx.Get(s.client.Collection("jobs").Doc(lastJobID))
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil && !finishedJobState(jobFromDoc(job).State) {
+				outcome, running = "skipped", lastJobID
+				return tx.Set(ref, map[string]interface{}{"lastSlot": slot, "skippedAt": time.Now().UTC()}, firestore.MergeAll)
+			}
+		}
+		params := task.Params()
+		params["scheduledTask"] = task.Name()
+		if err := queueJobTx(tx, jobRef, task.JobType(), params); err != nil {
+			return err
+		}
+		return tx.Set(ref, map[string]interface{}{
+			"lastSlot":  slot,
+			"lastJobId": jobRef.ID,
+			"firedBy":   jobOwner,
+			"firedAt":   time.Now().UTC(),
+		}, firestore.MergeAll)
+	})
+	switch {
+	case err != nil:
+		log.Printf("⚠️ task=%s event=fire_failed slot=%s error=%q", task.Name(), slot.Format(time.RFC3339), err.Error())
+	case outcome == "skipped":
+		log.Printf("⏭️ task=%s event=skipped slot=%s reason=previous_run_active job=%s", task.Name(), slot.Format(time.RFC3339), running)
+	case outcome == "started":
+		wakeJobWorkers()
+		log.Printf("⏰ task=%s event=start slot=%s job=%s", task.Name(), slot.Format(time.RFC3339), jobRef.ID)
+	}
+}
+
+// Every task with its schedule, next firing and last run (GET /admin/schedule)
+func (s *Server) scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	schedulerMu.Lock()
+	next := map[string]time.Time{}
+	for _, e := range schedulerEntries {
+		next[e.task.Name()] = e.next
+	}
+	schedulerMu.Unlock()
+
+	// Every task's state in one batch, then the jobs they last started in another
+	stateRefs := make([]*firestore.DocumentRef, len(scheduledTasks))
+	for i, task := range scheduledTasks {
+		stateRefs[i] = s.client.Collection("schedules").Doc(task.Name())
+	}
+	states, errs := s.fetchDocuments(ctx, stateRefs)
+	var jobRefs []*firestore.DocumentRef
+	jobOf := map[int]int{} // task index -> index in jobRefs
+	for i, err := range errs {
+		if err != nil && err != errDocumentMissing {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading schedule state")
+			return
+		}
+		if err == nil {
+			if id, _ := states[i].Data()["lastJobId"].(string); id != "" {
+				jobOf[i] = len(jobRefs)
+				jobRefs = append(jobRefs, s.client.Collection("jobs").Doc(id))
+			}
+		}
+	}
+	jobDocs, jobErrs := s.fetchDocuments(ctx, jobRefs)
+
+	tasks := []map[string]interface{}{}
+	for i, task := range scheduledTasks {
+		entry := map[string]interface{}{
+			"name":     task.Name(),
+			"schedule": task.Schedule(),
+			"jobType":  task.JobType(),
+			"enabled":  task.Schedule() != "",
+		}
+		if t, ok := next[task.Name()]; ok && !t.IsZero() {
+			entry["nextRunAt"] = t
+		}
+		if doc := states[i]; doc != nil {
+			last := map[string]interface{}{}
+			for _, k := range []string{"lastSlot", "lastJobId", "firedAt", "skippedAt"} {
+				if v, ok := doc.Data()[k]; ok {
+					last[k] = v
+				}
+			}
+			if j, ok := jobOf[i]; ok && jobErrs[j] == nil {
+				job := jobFromDoc(jobDocs[j])
+				last["state"] = job.State
+				if job.StartedAt != nil && job.FinishedAt != nil {
+					last["duration"] = job.FinishedAt.Sub(*job.StartedAt).String()
+				}
+			}
+			entry["lastRun"] = last
+		}
+		tasks = append(tasks, entry)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"timezone": "UTC", "tasks": tasks})
+}
+
+// cronSchedule is a parsed 5-field cron expression; each field is a
+// bitset of the values it allows
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// With both day fields restricted, a day matching either one fires
+	domAny, dowAny bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7},
+}
+
+// Parse "m h dom mon dow". Fields take *, values, ranges (a-b), steps
+// (*/n, a-b/n) and comma-separated lists of those; day of week 7 is Sunday.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields, has %d", expr, len(fields))
+	}
+	sets := make([]uint64, 5)
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %s field %q: %v", cronFields[i].name, f, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%s is outside %d-%d", rng, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// The first firing strictly after t, or zero if there is none in the
+// next five years (e.g. "0 0 30 2 *")
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
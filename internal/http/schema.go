@@ -0,0 +1,380 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/iterator"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// The users collection's schema as its documents actually have it, for
+// data imported by other tools. GET /admin/schema samples SCHEMA_SAMPLE_SIZE
+// documents (?size=, ?sample=random or newest) and reports every field
+// path with the types seen, how often it is null or missing, and a few
+// example values (redacted like audit details). A field seen with more
+// than one type is a conflict: those are the documents DataTo can't
+// decode. ?full=true scans the whole collection as a schema_infer job
+// instead. Sampled reports are cached for SCHEMA_CACHE_TTL.
+var (
+	schemaSampleSize = config.Int("SCHEMA_SAMPLE_SIZE", 1000)
+	schemaCacheTTL   = config.Duration("SCHEMA_CACHE_TTL", time.Hour)
+)
+
+const (
+	schemaSampleMax = 10000
+	schemaExamples  = 3  // per field
+	schemaExampleLn = 80 // characters an example string is cut to
+)
+
+var schemaCache struct {
+	mu      sync.Mutex
+	reports map[string]schemaCacheEntry // sample:size -> report
+}
+
+type schemaCacheEntry struct {
+	report  map[string]interface{}
+	expires time.Time
+}
+
+// schemaField is what was seen of one field path
+type schemaField struct {
+	types    map[string]int
+	present  int // documents with the field, null included
+	nulls    int
+	examples []interface{}
+}
+
+// schemaInference accumulates documents into a report
+type schemaInference struct {
+	documents int
+	fields    map[string]*schemaField
+}
+
+func newSchemaInference() *schemaInference {
+	return &schemaInference{fields: map[string]*schemaField{}}
+}
+
+func (s *schemaInference) add(data map[string]interface{}) {
+	s.documents++
+	s.walk(data, "", nil)
+}
+
+func (s *schemaInference) walk(data map[string]interface{}, prefix string, keys []string) {
+	for k, v := range data {
+		path := prefix + k
+		at := append(keys[:len(keys):len(keys)], strings.ToLower(k))
+		f := s.fields[path]
+		if f == nil {
+			f = &schemaField{types: map[string]int{}, examples: []interface{}{}}
+			s.fields[path] = f
+		}
+		f.present++
+		kind := schemaType(v)
+		f.types[kind]++
+		if kind == "null" {
+			f.nulls++
+			continue
+		}
+		hidden := redacted(redactFieldRules, at)
+		if hidden {
+			f.example(redactedValue)
+		} else if ex, ok := schemaExample(v); ok {
+			f.example(ex)
+		}
+		if m, ok := v.(map[string]interface{}); ok && !hidden {
+			s.walk(m, path+".", at)
+		}
+	}
+}
+
+// Keep up to schemaExamples distinct examples
+func (f *schemaField) example(v interface{}) {
+	if len(f.examples) >= schemaExamples {
+		return
+	}
+	for _, seen := range f.examples {
+		if seen == v {
+			return
+		}
+	}
+	f.examples = append(f.examples, v)
+}
+
+// The Firestore type of a decoded value
+func schemaType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "double"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "timestamp"
+	case []byte:
+		return "bytes"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "map"
+	case *firestore.DocumentRef:
+		return "reference"
+	case *latlng.LatLng:
+		return "geopoint"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// A scalar value as an example; maps and arrays have their own fields or
+// none
+func schemaExample(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		if r := []rune(t); len(r) > schemaExampleLn {
+			t = string(r[:schemaExampleLn]) + "…"
+		}
+		return t, true
+	case int64, float64, bool:
+		return t, true
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano), true
+	case *firestore.DocumentRef:
+		return t.Path, true
+	case *latlng.LatLng:
+		return fmt.Sprintf("%g,%g", t.Latitude, t.Longitude), true
+	}
+	return nil, false
+}
+
+// A conflict is two non-null types, integers and doubles counting as one
+func (f *schemaField) conflict() bool {
+	kinds := map[string]bool{}
+	for kind := range f.types {
+		switch kind {
+		case "null":
+		case "double":
+			kinds["integer"] = true
+		default:
+			kinds[kind] = true
+		}
+	}
+	return len(kinds) > 1
+}
+
+func (s *schemaInference) report(source string) map[string]interface{} {
+	paths := make([]string, 0, len(s.fields))
+	for path := range s.fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	rate := func(n int) float64 {
+		if s.documents == 0 {
+			return 0
+		}
+		return math.Round(float64(n)/float64(s.documents)*1e4) / 1e4
+	}
+	fields := []map[string]interface{}{}
+	conflicts := []string{}
+	for _, path := range paths {
+		f := s.fields[path]
+		field := map[string]interface{}{
+			"path":        path,
+			"types":       f.types,
+			"nullRate":    rate(f.nulls),
+			"missingRate": rate(s.documents - f.present),
+			"examples":    f.examples,
+		}
+		if f.conflict() {
+			field["conflict"] = true
+			conflicts = append(conflicts, path)
+		}
+		fields = append(fields, field)
+	}
+	return map[string]interface{}{
+		"collection":  "users",
+		"source":      source,
+		"documents":   s.documents,
+		"fields":      fields,
+		"conflicts":   conflicts,
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// A random auto-ID-like document ID to start a random sample at
+func randomDocID() string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 20)
+	for i := range b {
+		b[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(b)
+}
+
+// Sample size users, the newest by createdAt or a run of consecutive IDs
+// from a random point (wrapping around), which is random enough for
+// auto-generated IDs
+func (s *Server) sampleUsers(ctx context.Context, sample string, size int) ([]*firestore.DocumentSnapshot, error) {
+	if sample == "newest" {
+		return s.users.Users().OrderBy("createdAt", firestore.Desc).Limit(size).Documents(ctx).GetAll()
+	}
+	start := randomDocID()
+	byID := s.users.Users().OrderBy(firestore.DocumentID, firestore.Asc)
+	docs, err := byID.StartAt(start).Limit(size).Documents(ctx).GetAll()
+	if err != nil || len(docs) == size {
+		return docs, err
+	}
+	rest, err := byID.EndBefore(start).Limit(size - len(docs)).Documents(ctx).GetAll()
+	return append(docs, rest...), err
+}
+
+// The sampled report, from the cache when it is younger than SCHEMA_CACHE_TTL
+func (s *Server) sampledSchema(ctx context.Context, sample string, size int) (map[string]interface{}, error) {
+	key := sample + ":" + strconv.Itoa(size)
+	schemaCache.mu.Lock()
+	entry, ok := schemaCache.reports[key]
+	schemaCache.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.report, nil
+	}
+
+	docs, err := s.sampleUsers(ctx, sample, size)
+	if err != nil {
+		return nil, err
+	}
+	inference := newSchemaInference()
+	for _, doc := range docs {
+		inference.add(doc.Data())
+	}
+	report := inference.report(sample)
+
+	schemaCache.mu.Lock()
+	if schemaCache.reports == nil {
+		schemaCache.reports = map[string]schemaCacheEntry{}
+	}
+	schemaCache.reports[key] = schemaCacheEntry{report: report, expires: time.Now().Add(schemaCacheTTL)}
+	schemaCache.mu.Unlock()
+	return report, nil
+}
+
+// Infer the users schema from a sample (GET /admin/schema), or start a
+// schema_infer job over every document (?full=true)
+func (s *Server) schemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	q := r.URL.Query()
+	if q.Get("full") == "true" {
+		id, err := s.startJob(ctx, "schema_infer", nil)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error starting schema inference")
+			return
+		}
+		writeJobStarted(w, r, id)
+		return
+	}
+
+	sample := q.Get("sample")
+	switch sample {
+	case "":
+		sample = "random"
+	case "random", "newest":
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "sample must be random or newest")
+		return
+	}
+	size := schemaSampleSize
+	if raw := q.Get("size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > schemaSampleMax {
+			writeError(w, r, http.StatusBadRequest, "invalid_argument", fmt.Sprintf("size must be between 1 and %d", schemaSampleMax))
+			return
+		}
+		size = n
+	}
+
+	report, err := s.sampledSchema(ctx, sample, size)
+	if err != nil {
+		logCtx(ctx, "⚠️ Schema sample failed: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error sampling users")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// Infer the schema from every user; the report is the job's result
+func (s *Server) runSchemaInferJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	return s.scanSchema(ctx, run.progress)
+}
+
+func (s *Server) scanSchema(ctx context.Context, progress func(processed, total int, checkpoint string)) (map[string]interface{}, error) {
+	iter := trackIterator("schemaInfer", s.users.Users().Documents(ctx))
+	defer iter.Stop()
+	inference := newSchemaInference()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		inference.add(doc.Data())
+		progress(inference.documents, 0, "")
+	}
+	return inference.report("full"), nil
+}
+
+// gofirestoreapp schema infer: print the report, exiting 1 on type conflicts
+func (s *Server) schemaCommand(args []string) int {
+	if len(args) == 0 || args[0] != "infer" {
+		fmt.Fprintln(os.Stderr, "usage: gofirestoreapp schema infer [--sample random|newest] [--size n] [--full]")
+		return 2
+	}
+	fs := flag.NewFlagSet("schema infer", flag.ExitOnError)
+	sample := fs.String("sample", "random", "random or newest")
+	size := fs.Int("size", schemaSampleSize, "documents to sample")
+	full := fs.Bool("full", false, "scan every document instead of sampling")
+	fs.Parse(args[1:])
+	if *sample != "random" && *sample != "newest" || *size <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s.ensureFirestore()
+	defer s.client.Close()
+	ctx := withEndpoint(context.Background(), "cli schema")
+	var report map[string]interface{}
+	var err error
+	if *full {
+		report, err = s.scanSchema(ctx, func(int, int, string) {})
+	} else {
+		report, err = s.sampledSchema(ctx, *sample, *size)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Schema inference failed:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if conflicts := report["conflicts"].([]string); len(conflicts) > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️ %d fields have conflicting types: %s\n", len(conflicts), strings.Join(conflicts, ", "))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,412 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+)
+
+// SearchIndexer mirrors user documents into an external search service
+type SearchIndexer interface {
+	Upsert(ctx context.Context, id string, user model.User) error
+	Delete(ctx context.Context, id string) error
+	Search(ctx context.Context, query string, limit int) ([]string, error)
+}
+
+// Configured indexer, nil when SEARCH_INDEXER is unset
+var searchIndexer SearchIndexer
+
+var (
+	searchSyncRetries = config.Int("SEARCH_SYNC_RETRIES", 3)
+	searchSyncQueue   = make(chan searchSyncJob, config.Int("SEARCH_SYNC_QUEUE", 1000))
+	searchHTTPClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+type searchSyncJob struct {
+	id     string
+	user   *model.User // nil means delete
+	queued time.Time
+}
+
+// Build the indexer from config and start the sync worker
+func (s *Server) initSearchIndexer(bg *background) {
+	index := config.String("SEARCH_INDEX", "users")
+	apiKey := config.String("SEARCH_API_KEY", "")
+	switch config.String("SEARCH_INDEXER", "") {
+	case "":
+		return
+	case "algolia":
+		searchIndexer = &algoliaIndexer{appID: config.String("ALGOLIA_APP_ID", ""), apiKey: apiKey, index: index}
+	case "elasticsearch", "opensearch":
+		searchIndexer = &elasticIndexer{endpoint: config.String("SEARCH_ENDPOINT", ""), apiKey: apiKey, index: index}
+	default:
+		log.Fatalf("Unknown SEARCH_INDEXER %q", config.String("SEARCH_INDEXER", ""))
+	}
+	bg.Go(s.runSearchSync)
+	fmt.Println("🔎 Search indexer enabled:", config.String("SEARCH_INDEXER", ""))
+}
+
+// Queue a document for indexing; a no-op when no indexer is configured
+func (s *Server) enqueueSearchUpsert(id string, user model.User) {
+	s.enqueueSearchJob(searchSyncJob{id: id, user: &user, queued: time.Now()})
+}
+
+func (s *Server) enqueueSearchDelete(id string) {
+	s.enqueueSearchJob(searchSyncJob{id: id, queued: time.Now()})
+}
+
+func (s *Server) enqueueSearchJob(job searchSyncJob) {
+	if searchIndexer == nil || !flags.enabled(flagSearchIndexing) {
+		return
+	}
+	select {
+	case searchSyncQueue <- job:
+	default:
+		s.deadLetterSearchJob(job, fmt.Errorf("sync queue full"))
+	}
+}
+
+func (s *Server) runSearchSync(ctx context.Context) {
+	for {
+		select {
+		case job := <-searchSyncQueue:
+			if err := syncSearchJob(job); err != nil {
+				s.deadLetterSearchJob(job, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Apply one job, retrying with exponential backoff
+func syncSearchJob(job searchSyncJob) error {
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 0; attempt <= searchSyncRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		if job.user != nil {
+			err = searchIndexer.Upsert(ctx, job.id, *job.user)
+		} else {
+			err = searchIndexer.Delete(ctx, job.id)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Record a sync that failed for good in the search_dead_letters collection
+func (s *Server) deadLetterSearchJob(job searchSyncJob, cause error) {
+	op := "upsert"
+	if job.user == nil {
+		op = "delete"
+	}
+	log.Printf("⚠️ Search sync %s for %s failed: %v", op, job.id, cause)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, err := s.client.Collection("search_dead_letters").Add(ctx, map[string]interface{}{
+		"docId":    job.id,
+		"op":       op,
+		"error":    cause.Error(),
+		"queuedAt": job.queued,
+		"failedAt": time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to write search dead letter for %s: %v", job.id, err)
+	}
+}
+
+// Send a JSON request to a search backend and decode the response into out
+func searchRequest(ctx context.Context, method, target string, headers map[string]string, body, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := searchHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodDelete {
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, target, resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func searchDocument(user model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  user.Name,
+		"email": user.Email,
+	}
+}
+
+// Algolia REST API indexer
+type algoliaIndexer struct {
+	appID  string
+	apiKey string
+	index  string
+}
+
+func (a *algoliaIndexer) headers() map[string]string {
+	return map[string]string{
+		"X-Algolia-Application-Id": a.appID,
+		"X-Algolia-API-Key":        a.apiKey,
+	}
+}
+
+func (a *algoliaIndexer) objectURL(id string) string {
+	return fmt.Sprintf("https://%s.algolia.net/1/indexes/%s/%s", a.appID, url.PathEscape(a.index), url.PathEscape(id))
+}
+
+func (a *algoliaIndexer) Upsert(ctx context.Context, id string, user model.User) error {
+	return searchRequest(ctx, http.MethodPut, a.objectURL(id), a.headers(), searchDocument(user), nil)
+}
+
+func (a *algoliaIndexer) Delete(ctx context.Context, id string) error {
+	return searchRequest(ctx, http.MethodDelete, a.objectURL(id), a.headers(), nil, nil)
+}
+
+func (a *algoliaIndexer) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	target := fmt.Sprintf("https://%s-dsn.algolia.net/1/indexes/%s/query", a.appID, url.PathEscape(a.index))
+	params := url.Values{"query": {query}, "hitsPerPage": {strconv.Itoa(limit)}}
+	var result struct {
+		Hits []struct {
+			ObjectID string `json:"objectID"`
+		} `json:"hits"`
+	}
+	if err := searchRequest(ctx, http.MethodPost, target, a.headers(), map[string]string{"params": params.Encode()}, &result); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.ObjectID)
+	}
+	return ids, nil
+}
+
+// Elasticsearch / OpenSearch REST API indexer
+type elasticIndexer struct {
+	endpoint string
+	apiKey   string
+	index    string
+}
+
+func (e *elasticIndexer) headers() map[string]string {
+	if e.apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "ApiKey " + e.apiKey}
+}
+
+func (e *elasticIndexer) docURL(id string) string {
+	return fmt.Sprintf("%s/%s/_doc/%s", e.endpoint, url.PathEscape(e.index), url.PathEscape(id))
+}
+
+func (e *elasticIndexer) Upsert(ctx context.Context, id string, user model.User) error {
+	return searchRequest(ctx, http.MethodPut, e.docURL(id), e.headers(), searchDocument(user), nil)
+}
+
+func (e *elasticIndexer) Delete(ctx context.Context, id string) error {
+	return searchRequest(ctx, http.MethodDelete, e.docURL(id), e.headers(), nil, nil)
+}
+
+func (e *elasticIndexer) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	target := fmt.Sprintf("%s/%s/_search", e.endpoint, url.PathEscape(e.index))
+	body := map[string]interface{}{
+		"size": limit,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     query,
+				"fields":    []string{"name", "email"},
+				"fuzziness": "AUTO",
+			},
+		},
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := searchRequest(ctx, http.MethodPost, target, e.headers(), body, &result); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids, nil
+}
+
+// Replay the whole collection into the index as a job (POST /admin/reindex)
+func (s *Server) reindexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
+		return
+	}
+	if searchIndexer == nil {
+		writeError(w, r, http.StatusNotImplemented, "search_not_configured", "No search indexer configured")
+		return
+	}
+	id, err := s.startJob(requestContext(r), "reindex", map[string]interface{}{"dryRun": dryRunRequested(r)})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting reindex")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// Sync every user to the index in document ID order, checkpointing the
+// last ID synced so a takeover resumes there. A dry run only counts.
+func (s *Server) runReindexJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	if searchIndexer == nil {
+		return nil, fmt.Errorf("no search indexer configured")
+	}
+	total := 0
+	if res, err := s.client.Collection("users").NewAggregationQuery().WithCount("all").Get(ctx); err == nil {
+		if v, ok := res["all"].(interface{ GetIntegerValue() int64 }); ok {
+			total = int(v.GetIntegerValue())
+		}
+	}
+	query := s.client.Collection("users").OrderBy(firestore.DocumentID, firestore.Asc)
+	if last := run.checkpoint(); last != "" {
+		query = query.StartAfter(last)
+	}
+	processed, failed := run.processed(), 0
+	iter := trackIterator("reindex", query.Documents(ctx))
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return map[string]interface{}{"processed": processed, "failed": failed}, err
+		}
+		if !run.dryRun() {
+			user := userFromDoc(doc)
+			job := searchSyncJob{id: doc.Ref.ID, user: &user, queued: time.Now()}
+			if isSoftDeleted(doc) || isAnonymized(doc) {
+				job.user = nil // deleted and anonymized users are removed from the index
+			}
+			if err := syncSearchJob(job); err != nil {
+				s.deadLetterSearchJob(job, err)
+				run.noteError(err)
+				failed++
+			}
+		}
+		processed++
+		run.progress(processed, total, doc.Ref.ID)
+	}
+	return map[string]interface{}{"dryRun": run.dryRun(), "processed": processed, "failed": failed}, nil
+}
+
+// Search users (GET /users/search?q=...&engine=firestore|external). The
+// firestore engine (the default) ranks in-process; external queries the
+// configured index and hydrates from Firestore.
+func (s *Server) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query required")
+		return
+	}
+	limit := 20
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 100 {
+		limit = n
+	}
+	switch r.URL.Query().Get("engine") {
+	case "", "firestore":
+		s.firestoreSearchHandler(w, r, q, limit)
+		return
+	case "external":
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "engine must be firestore or external")
+		return
+	}
+	if searchIndexer == nil {
+		writeError(w, r, http.StatusNotImplemented, "search_not_configured", "No search indexer configured")
+		return
+	}
+
+	ctx := requestContext(r)
+	ids, err := searchIndexer.Search(ctx, q, limit)
+	if err != nil {
+		logCtx(ctx, "⚠️ External search failed: %v", err)
+		writeError(w, r, http.StatusBadGateway, "search_unavailable", "Search service unavailable")
+		return
+	}
+
+	users := []map[string]interface{}{}
+	if len(ids) > 0 {
+		refs := make([]*firestore.DocumentRef, len(ids))
+		for i, id := range ids {
+			refs[i] = s.client.Collection("users").Doc(id)
+		}
+		// fetchDocuments preserves input order, so the index's ranking is kept
+		docs, errs := s.fetchDocuments(ctx, refs)
+		failed := 0
+		for i, doc := range docs {
+			if errs[i] == errDocumentMissing || errs[i] == nil && (isSoftDeleted(doc) || isAnonymized(doc)) {
+				continue // deleted or anonymized since it was indexed
+			}
+			if errs[i] != nil {
+				logCtx(ctx, "⚠️ Failed to load search hit %s: %v", ids[i], errs[i])
+				failed++
+				continue
+			}
+			user := userFromDoc(doc)
+			user.AvatarURL = avatarURL(doc.Ref.ID, user)
+			users = append(users, map[string]interface{}{
+				"id":   doc.Ref.ID,
+				"user": user,
+			})
+		}
+		if failed == len(ids) {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading users")
+			return
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, users)
+}
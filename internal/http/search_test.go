@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// memoryIndexer is a SearchIndexer matching queries against name and email
+type memoryIndexer struct {
+	mu    sync.Mutex
+	users map[string]model.User
+}
+
+func (m *memoryIndexer) Upsert(_ context.Context, id string, user model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[id] = user
+	return nil
+}
+
+func (m *memoryIndexer) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	return nil
+}
+
+func (m *memoryIndexer) Search(_ context.Context, query string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	query = strings.ToLower(query)
+	var ids []string
+	for id, user := range m.users {
+		if strings.Contains(strings.ToLower(user.Name+" "+user.Email), query) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids[:min(len(ids), limit)], nil
+}
+
+func (m *memoryIndexer) has(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.users[id]
+	return ok
+}
+
+// Swap in a memoryIndexer for the duration of a test
+func withMemoryIndexer(t *testing.T) *memoryIndexer {
+	t.Helper()
+	saved := searchIndexer
+	m := &memoryIndexer{users: map[string]model.User{}}
+	searchIndexer = m
+	t.Cleanup(func() {
+		searchIndexer = saved
+		drainSearchQueue(t)
+	})
+	return m
+}
+
+// Apply the queued sync jobs, as runSearchSync would
+func drainSearchQueue(t *testing.T) {
+	t.Helper()
+	for {
+		select {
+		case job := <-searchSyncQueue:
+			if searchIndexer == nil {
+				continue
+			}
+			if err := syncSearchJob(job); err != nil {
+				t.Errorf("syncing %s: %v", job.id, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// The IDs an external search for q returns
+func externalSearchIDs(t *testing.T, s *Server, q string) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.searchUsersHandler(rec, httptest.NewRequest(http.MethodGet, "/users/search?engine=external&q="+q, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search %q = %d %s", q, rec.Code, rec.Body)
+	}
+	var hits []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &hits); err != nil {
+		t.Fatalf("search %q: decoding %s: %v", q, rec.Body, err)
+	}
+	var ids []string
+	for _, hit := range hits {
+		id, _ := hit["id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// A live user and a soft-deleted one, both indexed
+func indexedLiveAndDeleted(t *testing.T, s *Server, ctx context.Context, index *memoryIndexer) (live, deleted string) {
+	t.Helper()
+	live = mustCreateUser(t, s, ctx, model.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	deleted = mustCreateUser(t, s, ctx, model.User{Name: "Ada Byron", Email: "byron@example.com"})
+	if _, err := s.users.Users().Doc(deleted).Update(ctx, []firestore.Update{{Path: "deletedAt", Value: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	drainSearchQueue(t)
+	for _, id := range []string{live, deleted} {
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.Upsert(ctx, id, userFromDoc(doc))
+	}
+	return live, deleted
+}
+
+func TestExternalSearchHidesSoftDeletedUsers(t *testing.T) {
+	s, ctx := useEmulator(t)
+	index := withMemoryIndexer(t)
+	live, _ := indexedLiveAndDeleted(t, s, ctx, index)
+	if ids := externalSearchIDs(t, s, "ada"); !reflect.DeepEqual(ids, []string{live}) {
+		t.Errorf("search = %v, want only the live user %s", ids, live)
+	}
+}
+
+func TestReindexDropsSoftDeletedUsers(t *testing.T) {
+	s, ctx := useEmulator(t)
+	index := withMemoryIndexer(t)
+	live, deleted := indexedLiveAndDeleted(t, s, ctx, index)
+	if _, err := s.runReindexJob(ctx, &jobRun{job: Job{Type: "reindex"}}); err != nil {
+		t.Fatal(err)
+	}
+	if index.has(deleted) {
+		t.Error("the soft-deleted user is still indexed after a reindex")
+	}
+	if !index.has(live) {
+		t.Error("the live user is missing after a reindex")
+	}
+}
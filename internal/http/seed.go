@@ -0,0 +1,244 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+)
+
+// Default fixtures, used when there is no fixtures/ directory on disk
+//
+//go:embed fixtures
+var defaultFixtures embed.FS
+
+// SEED_ON_START=true loads the fixtures when the server starts (emulator only)
+var seedOnStart = config.Bool("SEED_ON_START", false)
+
+var (
+	errNotEmulator    = errors.New("refusing to seed a non-emulator project (FIRESTORE_EMULATOR_HOST is unset); pass --force to override")
+	errProductionSeed = errors.New("refusing to seed a production project matching PRODUCTION_PROJECT_PATTERN; pass --i-know-what-i-am-doing as well")
+)
+
+// A fixture user; id makes the document ID deterministic
+type fixtureUser struct {
+	ID string `json:"id"`
+	model.User
+}
+
+type seedOptions struct {
+	dir       string // fixture directory; embedded defaults when it doesn't exist
+	wipe      bool
+	generate  int
+	force     bool
+	confirmed bool // seed a protected production project anyway
+}
+
+// gofirestoreapp seed [--dir fixtures] [--wipe] [--generate N] [--force [--i-know-what-i-am-doing]]
+func (s *Server) seedCommand(args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	var opts seedOptions
+	fs.StringVar(&opts.dir, "dir", "fixtures", "directory of .json/.ndjson fixture files")
+	fs.BoolVar(&opts.wipe, "wipe", false, "delete every user before loading")
+	fs.IntVar(&opts.generate, "generate", 0, "also generate this many fake users")
+	fs.BoolVar(&opts.force, "force", false, "allow seeding a project that isn't the emulator")
+	fs.BoolVar(&opts.confirmed, "i-know-what-i-am-doing", false, "with --force, allow seeding a protected production project")
+	fs.Parse(args)
+
+	s.ensureFirestore()
+	defer s.client.Close()
+	n, err := s.seedUsers(context.Background(), opts)
+	if err != nil {
+		log.Printf("❌ Seeding failed: %v", err)
+		return 1
+	}
+	fmt.Printf("🌱 Seeded %d users\n", n)
+	return 0
+}
+
+// Load fixtures (and generated users) into the users collection with
+// their email index entries. Documents are overwritten, so seeding twice
+// gives the same result.
+func (s *Server) seedUsers(ctx context.Context, opts seedOptions) (int, error) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" && !opts.force {
+		return 0, errNotEmulator
+	}
+	if currentEnvironment.Protected && !opts.confirmed {
+		return 0, errProductionSeed
+	}
+	users, err := loadFixtures(opts.dir)
+	if err != nil {
+		return 0, err
+	}
+	users = append(users, generateUsers(opts.generate)...)
+
+	if opts.wipe {
+		n, err := s.wipeUsers(ctx)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Printf("🧹 Deleted %d users\n", n)
+	}
+
+	bw := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, u := range users {
+		if fe := normalizeUserPlan(&u.User); fe != nil {
+			bw.End()
+			return 0, fmt.Errorf("fixture user %s: %s %s", u.ID, fe.Field, fe.Message)
+		}
+		data := userToData(u.User)
+		stampUpdatedAt(data)
+		job, err := bw.Set(s.users.Users().Doc(u.ID), data)
+		if err != nil {
+			bw.End()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+		if model.NormalizeEmail(u.Email) != "" {
+			if job, err = bw.Set(s.users.EmailIndex(u.Email), map[string]interface{}{"userId": u.ID}); err != nil {
+				bw.End()
+				return 0, err
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+// Read every .json (an array of users) and .ndjson (one user per line)
+// file in dir, in name order. Users without an id get one derived from
+// the file name and position.
+func loadFixtures(dir string) ([]fixtureUser, error) {
+	var fsys fs.FS
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		fsys = os.DirFS(dir)
+	} else {
+		fsys, _ = fs.Sub(defaultFixtures, "fixtures")
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var users []fixtureUser
+	for _, e := range entries {
+		ext := path.Ext(e.Name())
+		if e.IsDir() || (ext != ".json" && ext != ".ndjson") {
+			continue
+		}
+		raw, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		var batch []fixtureUser
+		if ext == ".json" {
+			err = json.Unmarshal(raw, &batch)
+		} else {
+			scanner := bufio.NewScanner(bytes.NewReader(raw))
+			for line := 1; scanner.Scan() && err == nil; line++ {
+				if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+					continue
+				}
+				var u fixtureUser
+				if err = json.Unmarshal(scanner.Bytes(), &u); err != nil {
+					err = fmt.Errorf("line %d: %v", line, err)
+				}
+				batch = append(batch, u)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Name(), err)
+		}
+		for i := range batch {
+			if batch[i].ID == "" {
+				batch[i].ID = fmt.Sprintf("fixture-%s-%d", strings.TrimSuffix(e.Name(), ext), i+1)
+			}
+		}
+		users = append(users, batch...)
+	}
+	return users, nil
+}
+
+var (
+	fakeFirstNames = []string{"Ada", "Ben", "Chloe", "Diego", "Elena", "Farid", "Grace", "Hiro", "Ines", "Jonas", "Kofi", "Lena", "Mateo", "Nora", "Omar", "Priya", "Quinn", "Rosa", "Sven", "Tara"}
+	fakeLastNames  = []string{"Anderson", "Brown", "Costa", "Dubois", "Eriksen", "Fischer", "Gonzalez", "Haddad", "Ito", "Jensen", "Kowalski", "Lopez", "Moreau", "Nakamura", "Olsen", "Patel", "Rossi", "Silva", "Tanaka", "Weber"}
+)
+
+// n fake users with plausible names and unique emails. The same n always
+// yields the same users (IDs fake-00001, fake-00002, ...).
+func generateUsers(n int) []fixtureUser {
+	users := make([]fixtureUser, 0, n)
+	for i := 0; i < n; i++ {
+		first := fakeFirstNames[i%len(fakeFirstNames)]
+		last := fakeLastNames[(i/len(fakeFirstNames)+i)%len(fakeLastNames)]
+		users = append(users, fixtureUser{
+			ID: fmt.Sprintf("fake-%05d", i+1),
+			User: model.User{
+				Name:       first + " " + last,
+				Email:      fmt.Sprintf("%s.%s.%d@example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+				Plan:       model.PlanFree,
+				Attributes: map[string]interface{}{"generated": true},
+			},
+		})
+	}
+	return users
+}
+
+// Delete every user and email index entry
+func (s *Server) wipeUsers(ctx context.Context) (int, error) {
+	bw := s.client.BulkWriter(ctx)
+	defer bw.End()
+	deleted := 0
+	for _, col := range []*firestore.CollectionRef{s.users.Users(), s.client.Collection("email_index")} {
+		iter := col.DocumentRefs(ctx)
+		for {
+			ref, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return deleted, err
+			}
+			if _, err := bw.Delete(ref); err != nil {
+				return deleted, err
+			}
+			if col == s.users.Users() {
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// Seed the default fixtures at startup when SEED_ON_START is set
+func (s *Server) seedAtStartup(ctx context.Context) {
+	if !seedOnStart {
+		return
+	}
+	n, err := s.seedUsers(ctx, seedOptions{dir: "fixtures"})
+	if err != nil {
+		log.Printf("⚠️ SEED_ON_START: %v", err)
+		return
+	}
+	fmt.Printf("🌱 Seeded %d users\n", n)
+}
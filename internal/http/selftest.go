@@ -0,0 +1,162 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Per-check timeout of the self-test (SELFTEST_TIMEOUT)
+var selftestTimeout = config.Duration("SELFTEST_TIMEOUT", 10*time.Second)
+
+// SelftestCheck is the outcome of one self-test check
+type SelftestCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"` // pass, fail or skip
+	Duration string `json:"duration"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+var errSelftestSkipped = errors.New("skipped")
+
+// Run every check. connectErr is why the client couldn't be created, in
+// which case only the credentials are checked.
+func (s *Server) runSelftest(ctx context.Context, connectErr error) ([]SelftestCheck, bool) {
+	var checks []SelftestCheck
+	ok := true
+	run := func(name string, check func(ctx context.Context) (string, error)) {
+		ctx, cancel := context.WithTimeout(ctx, selftestTimeout)
+		defer cancel()
+		start := time.Now()
+		detail, err := check(ctx)
+		result := SelftestCheck{Name: name, Status: "pass", Detail: detail}
+		switch {
+		case err == errSelftestSkipped:
+			result.Status = "skip"
+		case err != nil:
+			result.Status, result.Detail = "fail", err.Error()
+			ok = false
+		}
+		result.Duration = time.Since(start).Round(time.Millisecond).String()
+		checks = append(checks, result)
+	}
+
+	run("credentials", checkCredentials)
+	run("firestore connection", func(ctx context.Context) (string, error) { return "", connectErr })
+	if connectErr != nil {
+		return checks, false
+	}
+	run("firestore round trip", s.checkRoundTrip)
+	for _, idx := range requiredIndexes {
+		idx := idx
+		run("index: "+idx.name, func(ctx context.Context) (string, error) {
+			missing, err := s.probeIndex(ctx, idx)
+			if missing != nil {
+				return "", fmt.Errorf("missing index, create it at %s", missing.CreateURL)
+			}
+			return "", err
+		})
+	}
+	run("ttl policy: "+ttlCollection+"."+ttlField, checkTTLPolicy)
+	run("search indexer", func(ctx context.Context) (string, error) {
+		if searchIndexer == nil {
+			return "SEARCH_INDEXER not set", errSelftestSkipped
+		}
+		_, err := searchIndexer.Search(ctx, "selftest", 1)
+		return config.String("SEARCH_INDEXER", ""), err
+	})
+	return checks, ok
+}
+
+func checkCredentials(ctx context.Context) (string, error) {
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return "", err
+	}
+	var creds struct {
+		Type      string `json:"type"`
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return "", fmt.Errorf("%s is not a credentials file: %v", credentialsFile, err)
+	}
+	if creds.Type == "" {
+		return "", fmt.Errorf("%s has no credentials type", credentialsFile)
+	}
+	return creds.Type + " for project " + creds.ProjectID, nil
+}
+
+// Write, read back and delete a _selftest document
+func (s *Server) checkRoundTrip(ctx context.Context) (string, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	ref := s.client.Collection("_selftest").Doc(token)
+	if _, err := ref.Set(ctx, map[string]interface{}{"token": token, "at": time.Now()}); err != nil {
+		return "", fmt.Errorf("write: %v", err)
+	}
+	doc, err := ref.Get(ctx)
+	if err == nil && doc.Data()["token"] != token {
+		err = errors.New("read back a different document")
+	}
+	if err != nil {
+		ref.Delete(context.Background()) // best effort
+		return "", fmt.Errorf("read: %v", err)
+	}
+	if _, err := ref.Delete(ctx); err != nil {
+		return "", fmt.Errorf("delete: %v", err)
+	}
+	return "write, read and delete succeeded", nil
+}
+
+// gofirestoreapp doctor [--json]: check the environment before deploying;
+// exits non-zero when any check fails
+func (s *Server) doctorCommand(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	c, connectErr := s.dial(context.Background())
+	if connectErr == nil {
+		s.useClient(c)
+		defer s.client.Close()
+	}
+	bg := newBackground()
+	defer bg.cancel()
+	s.initSearchIndexer(bg)
+	checks, ok := s.runSelftest(context.Background(), connectErr)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"ok": ok, "checks": checks})
+	} else {
+		icons := map[string]string{"pass": "✅", "fail": "❌", "skip": "⏭️"}
+		for _, c := range checks {
+			fmt.Printf("%s %s (%s) %s\n", icons[c.Status], c.Name, c.Duration, c.Detail)
+		}
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// Run the doctor checks against the live service (GET /admin/selftest);
+// 503 when any fails
+func (s *Server) selftestHandler(w http.ResponseWriter, r *http.Request) {
+	checks, ok := s.runSelftest(requestContext(r), nil)
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, r, code, map[string]interface{}{"ok": ok, "checks": checks})
+}
@@ -0,0 +1,46 @@
+// Package http is the user API over HTTP: a Server with its handlers,
+// middleware and routes, the App that runs it, and the subcommands that
+// share its Firestore client.
+package http
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/option"
+)
+
+// Firebase service account credentials
+const credentialsFile = ".json"
+
+// DialFirestore connects to the project of the credentials file, for
+// NewServer
+func DialFirestore(ctx context.Context) (*firestore.Client, error) {
+	sa := option.WithCredentialsFile(credentialsFile)
+	return firestore.NewClient(ctx, "", append([]option.ClientOption{sa}, slowOpOptions()...)...)
+}
+
+// Server is the user API: its handlers, background loops and subcommands,
+// and the Firestore client they work on. The client is dialed when the
+// App starts, or by subcommands through ensureFirestore.
+type Server struct {
+	dial          func(ctx context.Context) (*firestore.Client, error)
+	firestoreOnce sync.Once
+	client        *firestore.Client
+	users         store.UserStore
+	quotas        *quotaCache
+}
+
+// NewServer returns a Server that connects to Firestore with dial
+func NewServer(dial func(ctx context.Context) (*firestore.Client, error)) *Server {
+	s := &Server{dial: dial}
+	s.quotas = s.newQuotaCache(quotaCacheSize)
+	return s
+}
+
+// Work on c from now on, keeping users in its database
+func (s *Server) useClient(c *firestore.Client) {
+	s.client, s.users = c, store.NewFirestore(c)
+}
@@ -0,0 +1,385 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Export users to a Google Sheet (POST /admin/export/sheets). The Sheets
+// client uses the Firestore service account with the spreadsheets scope.
+// The target is the spreadsheet in the body, else
+// SHEETS_EXPORT_SPREADSHEET_ID, else a new one; a new spreadsheet is owned
+// by the service account, so configure a shared one for people to open.
+// Each run clears the SHEETS_EXPORT_SHEET tab and rewrites it, which also
+// makes a retried job safe.
+var (
+	sheetsExportSpreadsheetID = config.String("SHEETS_EXPORT_SPREADSHEET_ID", "")
+	sheetsExportSheet         = config.String("SHEETS_EXPORT_SHEET", "Users")
+	sheetsMaxRetries          = config.Int("SHEETS_MAX_RETRIES", 6)
+)
+
+// Sheets limits: cells per write we allow ourselves, cells per
+// spreadsheet and characters per cell
+const (
+	sheetsChunkCells = 10000
+	sheetsMaxCells   = 10000000
+	sheetsMaxCellLen = 50000
+)
+
+// errSheetsAccess is an actionable Sheets permission problem
+type errSheetsAccess struct{ reason string }
+
+func (e errSheetsAccess) Error() string { return e.reason }
+
+var (
+	errSpreadsheetNotFound = errors.New("spreadsheet not found; check the ID")
+	errSpreadsheetTooLarge = fmt.Errorf("export exceeds the Google Sheets limit of %d cells", sheetsMaxCells)
+)
+
+func newSheetsService(ctx context.Context) (*sheets.Service, error) {
+	return sheets.NewService(ctx, option.WithCredentialsFile(credentialsFile), option.WithScopes(sheets.SpreadsheetsScope))
+}
+
+// serviceAccount is the part of the credentials file we report or default from
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	ProjectID   string `json:"project_id"`
+}
+
+func readCredentials() serviceAccount {
+	var creds serviceAccount
+	if raw, err := os.ReadFile(credentialsFile); err == nil {
+		json.Unmarshal(raw, &creds)
+	}
+	return creds
+}
+
+// Turn Sheets' 403s and 404s into errors that say what to fix
+func sheetsError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.Code == http.StatusNotFound:
+		return errSpreadsheetNotFound
+	case apiErr.Code != http.StatusForbidden:
+		return err
+	case strings.Contains(apiErr.Body, "ACCESS_TOKEN_SCOPE_INSUFFICIENT") || strings.Contains(apiErr.Message, "insufficient authentication scopes"):
+		return errSheetsAccess{"The credentials were not granted the " + sheets.SpreadsheetsScope + " scope; add it to the service account's delegated or workload identity scopes"}
+	case strings.Contains(apiErr.Body, "SERVICE_DISABLED") || strings.Contains(apiErr.Message, "has not been used"):
+		return errSheetsAccess{"The Google Sheets API is not enabled for this project; enable sheets.googleapis.com in the Cloud console"}
+	default:
+		who := readCredentials().ClientEmail
+		if who == "" {
+			who = "the service account"
+		}
+		return errSheetsAccess{"No access to the spreadsheet; share it with " + who + " as an editor"}
+	}
+}
+
+// Call f, backing off exponentially (with jitter) on quota and server
+// errors
+func sheetsRetry(ctx context.Context, f func() error) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := f()
+		var apiErr *googleapi.Error
+		if err == nil || !errors.As(err, &apiErr) || attempt >= sheetsMaxRetries ||
+			apiErr.Code != http.StatusTooManyRequests && apiErr.Code < 500 {
+			return sheetsError(err)
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("⏳ Sheets API returned %d, retrying in %v", apiErr.Code, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, 32*time.Second)
+	}
+}
+
+// A1 reference to a whole tab, quoting its title
+func sheetsTab(sheet string) string {
+	return "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+}
+
+// A1 reference to the first cell of row in sheet
+func sheetsCell(sheet string, row int) string {
+	return fmt.Sprintf("%s!A%d", sheetsTab(sheet), row)
+}
+
+// Start a sheets_export job (POST /admin/export/sheets, admin). The body is
+// optional: {"spreadsheetId", "title", "filter", "includeAnonymized"}, with
+// filter as for /users:updateWhere. The spreadsheet is resolved (or
+// created) before the job starts, so the response carries its URL.
+func (s *Server) sheetsExportHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SpreadsheetID     string     `json:"spreadsheetId"`
+		Title             string     `json:"title"`
+		Filter            userFilter `json:"filter"`
+		IncludeAnonymized bool       `json:"includeAnonymized"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+			unsupportedMediaType(w, r, "application/json")
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+	}
+	if err := req.Filter.validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	if req.SpreadsheetID == "" {
+		req.SpreadsheetID = sheetsExportSpreadsheetID
+	}
+	if req.Title == "" {
+		req.Title = "Users " + time.Now().UTC().Format("2006-01-02")
+	}
+
+	ctx := requestContext(r)
+	srv, err := newSheetsService(ctx)
+	if err != nil {
+		log.Printf("⚠️ Failed to create Sheets client: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error creating Sheets client")
+		return
+	}
+	var spreadsheet *sheets.Spreadsheet
+	err = sheetsRetry(ctx, func() (err error) {
+		if req.SpreadsheetID == "" {
+			spreadsheet, err = srv.Spreadsheets.Create(&sheets.Spreadsheet{
+				Properties: &sheets.SpreadsheetProperties{Title: req.Title},
+				Sheets:     []*sheets.Sheet{{Properties: &sheets.SheetProperties{Title: sheetsExportSheet}}},
+			}).Context(ctx).Do()
+		} else {
+			spreadsheet, err = srv.Spreadsheets.Get(req.SpreadsheetID).Fields("spreadsheetId", "spreadsheetUrl").Context(ctx).Do()
+		}
+		return err
+	})
+	var accessErr errSheetsAccess
+	switch {
+	case errors.As(err, &accessErr):
+		writeError(w, r, http.StatusForbidden, "sheets_access", accessErr.Error())
+		return
+	case err == errSpreadsheetNotFound:
+		writeError(w, r, http.StatusNotFound, "spreadsheet_not_found", err.Error())
+		return
+	case err != nil:
+		log.Printf("⚠️ Failed to open spreadsheet: %v", err)
+		writeError(w, r, http.StatusServiceUnavailable, "sheets_unavailable", "Google Sheets is unavailable, retry shortly")
+		return
+	}
+
+	raw, _ := json.Marshal(req.Filter)
+	id, err := s.startJob(ctx, "sheets_export", map[string]interface{}{
+		"spreadsheetId":     spreadsheet.SpreadsheetId,
+		"spreadsheetUrl":    spreadsheet.SpreadsheetUrl,
+		"filter":            string(raw),
+		"includeAnonymized": req.IncludeAnonymized,
+		"actor":             actorFromRequest(r, "admin"),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting export")
+		return
+	}
+	w.Header().Set("Location", "/admin/jobs/"+id)
+	writeJSON(w, r, http.StatusAccepted, map[string]interface{}{
+		"message":        "Job started",
+		"id":             id,
+		"state":          jobQueued,
+		"spreadsheetId":  spreadsheet.SpreadsheetId,
+		"spreadsheetUrl": spreadsheet.SpreadsheetUrl,
+	})
+}
+
+func (s *Server) runSheetsExportJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	var filter userFilter
+	raw, _ := run.job.Params["filter"].(string)
+	if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+		return nil, err
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	queries, err := filter.queries(s.users.Users())
+	if err != nil {
+		return nil, err
+	}
+	spreadsheetID, _ := run.job.Params["spreadsheetId"].(string)
+	includeAnonymized, _ := run.job.Params["includeAnonymized"].(bool)
+	srv, err := newSheetsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := prepareExportSheet(ctx, srv, spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, written := 0, 0 // users exported; sheet rows written, header included
+	result := func() map[string]interface{} {
+		return map[string]interface{}{"spreadsheetId": spreadsheetID, "spreadsheetUrl": run.job.Params["spreadsheetUrl"], "rows": rows}
+	}
+	chunkRows := sheetsChunkCells / len(exportColumns)
+	pending := [][]interface{}{toSheetRow(exportColumns)}
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		start := written + 1
+		end := written + len(pending)
+		if end*len(exportColumns) > sheetsMaxCells {
+			return errSpreadsheetTooLarge
+		}
+		if err := sheet.grow(ctx, srv, end); err != nil {
+			return err
+		}
+		err := sheetsRetry(ctx, func() error {
+			_, err := srv.Spreadsheets.Values.Update(spreadsheetID, sheetsCell(sheetsExportSheet, start), &sheets.ValueRange{Values: pending}).
+				ValueInputOption("RAW").Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		written = end
+		rows = written - 1 // less the header
+		pending = pending[:0]
+		run.progress(rows, 0, "")
+		return nil
+	}
+	for _, base := range queries {
+		var last *firestore.DocumentSnapshot
+		for {
+			query := base.Limit(bulkUpdateBatchSize)
+			if last != nil {
+				query = query.StartAfter(last)
+			}
+			docs, err := query.Documents(ctx).GetAll()
+			if err != nil {
+				return result(), err
+			}
+			if len(docs) == 0 {
+				break
+			}
+			last = docs[len(docs)-1]
+			for _, doc := range docs {
+				if !filter.matches(doc) || isAnonymized(doc) && !includeAnonymized {
+					continue
+				}
+				pending = append(pending, toSheetRow(exportRow(doc)))
+				if len(pending) == chunkRows {
+					if err := flush(); err != nil {
+						return result(), err
+					}
+				}
+			}
+			if len(docs) < bulkUpdateBatchSize {
+				break
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return result(), err
+	}
+	log.Printf("📊 Exported %d users to spreadsheet %s", rows, spreadsheetID)
+	return result(), nil
+}
+
+// exportSheet is the export tab and its current grid size
+type exportSheet struct {
+	spreadsheetID string
+	id            int64
+	rowCount      int64
+}
+
+// Find or add the export tab, size its columns and clear it
+func prepareExportSheet(ctx context.Context, srv *sheets.Service, spreadsheetID string) (*exportSheet, error) {
+	var spreadsheet *sheets.Spreadsheet
+	err := sheetsRetry(ctx, func() (err error) {
+		spreadsheet, err = srv.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sheet *exportSheet
+	for _, s := range spreadsheet.Sheets {
+		if s.Properties.Title == sheetsExportSheet {
+			sheet = &exportSheet{spreadsheetID: spreadsheetID, id: s.Properties.SheetId, rowCount: s.Properties.GridProperties.RowCount}
+		}
+	}
+	if sheet == nil {
+		var resp *sheets.BatchUpdateSpreadsheetResponse
+		err := sheetsRetry(ctx, func() (err error) {
+			resp, err = srv.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+				Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: sheetsExportSheet}}}},
+			}).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		props := resp.Replies[0].AddSheet.Properties
+		sheet = &exportSheet{spreadsheetID: spreadsheetID, id: props.SheetId, rowCount: props.GridProperties.RowCount}
+	}
+	err = sheetsRetry(ctx, func() error {
+		_, err := srv.Spreadsheets.Values.Clear(spreadsheetID, sheetsTab(sheetsExportSheet), &sheets.ClearValuesRequest{}).Context(ctx).Do()
+		return err
+	})
+	return sheet, err
+}
+
+// Make the tab at least rows tall; writes past the grid are rejected
+func (s *exportSheet) grow(ctx context.Context, srv *sheets.Service, rows int) error {
+	if int64(rows) <= s.rowCount {
+		return nil
+	}
+	// Grow by at least a chunk so a long export isn't a resize per write
+	target := max(int64(rows), s.rowCount+int64(sheetsChunkCells/len(exportColumns)))
+	err := sheetsRetry(ctx, func() error {
+		_, err := srv.Spreadsheets.BatchUpdate(s.spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: []*sheets.Request{{AppendDimension: &sheets.AppendDimensionRequest{
+				SheetId:         s.id,
+				Dimension:       "ROWS",
+				Length:          target - s.rowCount,
+				ForceSendFields: []string{"SheetId"}, // the first tab's ID is 0
+			}}},
+		}).Context(ctx).Do()
+		return err
+	})
+	if err == nil {
+		s.rowCount = target
+	}
+	return err
+}
+
+// Sheets row values, each cell cut to the per-cell character limit
+func toSheetRow(cells []string) []interface{} {
+	row := make([]interface{}, len(cells))
+	for i, cell := range cells {
+		if len(cell) > sheetsMaxCellLen {
+			cell = cell[:sheetsMaxCellLen]
+		}
+		row[i] = cell
+	}
+	return row
+}
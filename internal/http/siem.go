@@ -0,0 +1,512 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Audit entries for a SIEM, as JSON lines or ArcSight CEF. GET
+// /admin/auditLogs/export streams a time range; with SIEM_FORWARD_URL set,
+// the siem_forward job tails audit_logs and POSTs batches to that HTTPS
+// collector (with a client certificate from SIEM_CLIENT_CERT and
+// SIEM_CLIENT_KEY when the collector wants mTLS). Scheduled every minute,
+// each run forwards for up to SIEM_FORWARD_RUN, waiting on a snapshot
+// listener once caught up. Its watermark in sync_state/siem advances only
+// past delivered (or dead-lettered) batches, so a failed run resends from
+// there. The siemForwarding flag pauses it.
+var (
+	siemForwardURL      = config.String("SIEM_FORWARD_URL", "")
+	siemFormat          = config.String("SIEM_FORMAT", siemJSONLines)
+	siemClientCert      = config.String("SIEM_CLIENT_CERT", "")
+	siemClientKey       = config.String("SIEM_CLIENT_KEY", "")
+	siemCAFile          = config.String("SIEM_CA_FILE", "") // system roots when unset
+	siemBatchSize       = config.Int("SIEM_BATCH_SIZE", 100)
+	siemMaxRetries      = config.Int("SIEM_MAX_RETRIES", 5)
+	siemTimeout         = config.Duration("SIEM_TIMEOUT", 10*time.Second)
+	siemForwardRun      = config.Duration("SIEM_FORWARD_RUN", 15*time.Minute)
+	siemForwardLag      = config.Duration("SIEM_FORWARD_LAG", 5*time.Second)
+	siemIdleRecheck     = 30 * time.Second
+	siemWatermarkDoc    = "sync_state/siem"
+	siemDeadLetters     = "siem_dead_letters"
+	siemForwardSchedule = siemSchedule()
+)
+
+// Export formats
+const (
+	siemJSONLines = "jsonl"
+	siemCEF       = "cef"
+)
+
+var (
+	siemForwarded    atomic.Int64
+	siemDeadLettered atomic.Int64
+	siemRetries      atomic.Int64
+)
+
+var errSIEMNotConfigured = errors.New("SIEM_FORWARD_URL is not set")
+
+// The forwarder runs every minute when configured; a firing while a run
+// is still tailing is skipped
+func siemSchedule() string {
+	if siemForwardURL == "" {
+		return ""
+	}
+	return config.String("SIEM_FORWARD_SCHEDULE", "* * * * *")
+}
+
+// siemRecord is an audit entry as exported. These field names are the
+// contract with the SIEM's parsers; add fields, don't rename them.
+type siemRecord struct {
+	ID       string                 `json:"id"`
+	Time     time.Time              `json:"time"`
+	Action   string                 `json:"action"`
+	Actor    string                 `json:"actor"`
+	TargetID string                 `json:"targetId"`
+	ClientIP string                 `json:"clientIp,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+	Source   string                 `json:"source"`
+}
+
+func siemRecordFromDoc(doc *firestore.DocumentSnapshot) siemRecord {
+	var entry AuditEntry
+	doc.DataTo(&entry)
+	return siemRecord{
+		ID:       doc.Ref.ID,
+		Time:     entry.At.UTC(),
+		Action:   entry.Action,
+		Actor:    entry.Actor,
+		TargetID: entry.TargetID,
+		ClientIP: entry.ClientIP,
+		Details:  entry.Details,
+		Source:   "audit_logs",
+	}
+}
+
+// One record as a line of format, newline included
+func formatSIEMRecord(rec siemRecord, format string) ([]byte, error) {
+	if format == siemCEF {
+		return []byte(cefLine(rec) + "\n"), nil
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// CEF severity (0-10) of an audit action
+func cefSeverity(action string) int {
+	switch action {
+	case "protection_override":
+		return 8
+	case "user.anonymize", "merge":
+		return 6
+	case "archive", "unarchive", "bulk_update":
+		return 4
+	}
+	return 3
+}
+
+// Header fields escape backslash and pipe, and can't span lines
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+
+// Extension values escape backslash and equals, and newlines as \n and \r
+var cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\r\n`, "\n", `\n`, "\r", `\r`)
+
+// A record as one CEF event:
+// CEF:0|vendor|product|version|signatureID|name|severity|extension
+func cefLine(rec siemRecord) string {
+	version := buildSetting("vcs.revision")
+	if len(version) > 12 {
+		version = version[:12]
+	}
+	if version == "" {
+		version = "dev"
+	}
+	header := []string{"CEF:0", "Altair-05", "GoFirestoreApp", version, rec.Action, rec.Action, strconv.Itoa(cefSeverity(rec.Action))}
+	for i := 1; i < len(header); i++ {
+		header[i] = cefHeaderEscaper.Replace(header[i])
+	}
+
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	add("rt", strconv.FormatInt(rec.Time.UnixMilli(), 10))
+	add("externalId", rec.ID)
+	add("act", rec.Action)
+	add("suser", rec.Actor)
+	add("duid", rec.TargetID)
+	if ip := net.ParseIP(rec.ClientIP); ip.To4() != nil {
+		add("src", ip.String())
+	} else if ip != nil {
+		add("c6a2Label", "sourceAddress")
+		add("c6a2", ip.String())
+	}
+	if len(rec.Details) > 0 {
+		raw, _ := json.Marshal(rec.Details)
+		add("cs1Label", "details")
+		add("cs1", string(raw))
+	}
+	add("cs2Label", "source")
+	add("cs2", rec.Source)
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
+
+// Audit entries in [since, until) by time, then ID
+func (s *Server) auditLogQuery(since, until time.Time) firestore.Query {
+	query := s.client.Collection("audit_logs").Query
+	if !since.IsZero() {
+		query = query.Where("at", ">=", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("at", "<", until)
+	}
+	return query.OrderBy("at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+}
+
+// Stream audit entries (GET /admin/auditLogs/export, admin only) as
+// ?format=jsonl (the default) or cef, oldest first. ?since= and ?until=
+// bound the range by entry time. As in the user export, a failure after
+// the first byte is reported in the X-Export-Error trailer.
+func (s *Server) auditLogExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = siemJSONLines
+	}
+	if format != siemJSONLines && format != siemCEF {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "format must be jsonl or cef")
+		return
+	}
+	bounds := map[string]time.Time{}
+	for _, field := range []string{"since", "until"} {
+		if str := query.Get(field); str != "" {
+			t, err := parseTimestamp(str)
+			if err != nil {
+				invalidTimestamp(w, r, field)
+				return
+			}
+			bounds[field] = t
+		}
+	}
+
+	w.Header().Set("Trailer", "X-Export-Error")
+	if format == siemCEF {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	flusher, _ := w.(http.Flusher)
+	ordered := s.auditLogQuery(bounds["since"], bounds["until"]).Limit(exportPageSize)
+	page := ordered
+	var err error
+	for {
+		var docs []*firestore.DocumentSnapshot
+		if docs, err = page.Documents(r.Context()).GetAll(); err != nil || len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			var line []byte
+			if line, err = formatSIEMRecord(siemRecordFromDoc(doc), format); err != nil {
+				break
+			}
+			if _, err = w.Write(line); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(docs) < exportPageSize {
+			break
+		}
+		page = ordered.StartAfter(docs[len(docs)-1])
+	}
+	if err != nil {
+		log.Printf("⚠️ Audit log export failed: %v", err)
+		w.Header().Set("X-Export-Error", err.Error())
+	}
+}
+
+// siemWatermark is the forwarder's position in audit_logs: entries up to
+// and including (At, ID) in export order have been handled
+type siemWatermark struct {
+	At        time.Time `firestore:"at"`
+	ID        string    `firestore:"id"`
+	Forwarded int       `firestore:"forwarded"`
+	UpdatedAt time.Time `firestore:"updatedAt"`
+}
+
+func (s *Server) readSIEMWatermark(ctx context.Context) (siemWatermark, error) {
+	var mark siemWatermark
+	doc, err := s.client.Doc(siemWatermarkDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return mark, nil
+	}
+	if err != nil {
+		return mark, err
+	}
+	err = doc.DataTo(&mark)
+	return mark, err
+}
+
+// The next batch after the watermark. Entries newer than SIEM_FORWARD_LAG
+// wait, so one committed late with an earlier timestamp isn't skipped.
+func (s *Server) nextSIEMBatch(ctx context.Context, mark siemWatermark) ([]*firestore.DocumentSnapshot, error) {
+	query := s.auditLogQuery(mark.At, time.Now().Add(-siemForwardLag)).Limit(siemBatchSize)
+	if mark.ID != "" {
+		query = query.StartAfter(mark.At, mark.ID)
+	}
+	return query.Documents(ctx).GetAll()
+}
+
+// Wait until audit_logs has an entry newer than the watermark, or
+// siemIdleRecheck passes so the caller can look at the flag again
+func (s *Server) waitForAuditEntry(ctx context.Context, mark siemWatermark) error {
+	ctx, cancel := context.WithTimeout(ctx, siemIdleRecheck)
+	defer cancel()
+	snaps := s.client.Collection("audit_logs").OrderBy("at", firestore.Desc).Limit(1).Snapshots(ctx)
+	defer snaps.Stop()
+	for {
+		snap, err := snaps.Next()
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return err
+		}
+		docs, err := snap.Documents.GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 && docs[0].Ref.ID != mark.ID && !siemRecordFromDoc(docs[0]).Time.Before(mark.At) {
+			// Give the entry time to leave the SIEM_FORWARD_LAG window
+			select {
+			case <-time.After(siemForwardLag):
+			case <-ctx.Done():
+			}
+			return nil
+		}
+	}
+}
+
+// Forward audit entries to SIEM_FORWARD_URL for up to SIEM_FORWARD_RUN
+// (the siem_forward job, started by the scheduler)
+func (s *Server) runSIEMForwardJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	if siemForwardURL == "" {
+		return nil, errSIEMNotConfigured
+	}
+	httpClient, err := siemHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	mark, err := s.readSIEMWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	runCtx, cancel := context.WithTimeout(ctx, siemForwardRun)
+	defer cancel()
+
+	forwarded, deadLettered := 0, 0
+	result := func(paused bool) map[string]interface{} {
+		return map[string]interface{}{"forwarded": forwarded, "deadLettered": deadLettered, "watermark": mark.At, "paused": paused}
+	}
+	for {
+		if !flags.enabled(flagSIEMForwarding) {
+			log.Printf("⏸️ SIEM forwarding paused at %v", mark.At)
+			return result(true), nil
+		}
+		docs, err := s.nextSIEMBatch(runCtx, mark)
+		if err == nil && len(docs) == 0 {
+			err = s.waitForAuditEntry(runCtx, mark)
+		}
+		if runCtx.Err() != nil && ctx.Err() == nil {
+			return result(false), nil // ran for SIEM_FORWARD_RUN
+		}
+		if err != nil {
+			return result(false), err
+		}
+		if len(docs) == 0 {
+			continue
+		}
+
+		var body bytes.Buffer
+		ids := make([]string, 0, len(docs))
+		for _, doc := range docs {
+			line, err := formatSIEMRecord(siemRecordFromDoc(doc), siemFormat)
+			if err != nil {
+				return result(false), err
+			}
+			body.Write(line)
+			ids = append(ids, doc.Ref.ID)
+		}
+		sendErr := sendSIEMBatch(runCtx, httpClient, body.Bytes())
+		var permanent *siemRejected
+		switch {
+		case sendErr == nil:
+			forwarded += len(docs)
+			siemForwarded.Add(int64(len(docs)))
+		case errors.As(sendErr, &permanent):
+			if err := s.deadLetterSIEMBatch(ctx, ids, body.Bytes(), permanent); err != nil {
+				return result(false), err
+			}
+			deadLettered += len(docs)
+			siemDeadLettered.Add(int64(len(docs)))
+			run.noteError(sendErr)
+			log.Printf("⚠️ SIEM collector rejected %d audit entries (%v), dead-lettered", len(docs), sendErr)
+		case runCtx.Err() != nil && ctx.Err() == nil:
+			return result(false), nil // ran for SIEM_FORWARD_RUN mid-batch; the next run resends it
+		default:
+			return result(false), sendErr // the watermark stays, the next run resends
+		}
+
+		last := siemRecordFromDoc(docs[len(docs)-1])
+		mark = siemWatermark{At: last.Time, ID: last.ID, Forwarded: mark.Forwarded + len(docs), UpdatedAt: time.Now().UTC()}
+		if _, err := s.client.Doc(siemWatermarkDoc).Set(ctx, mark); err != nil {
+			return result(false), err
+		}
+		run.progress(forwarded+deadLettered, 0, "")
+	}
+}
+
+// siemRejected is the collector refusing a batch for good (a 4xx other
+// than 408 and 429); resending it won't help
+type siemRejected struct {
+	status int
+	body   string
+}
+
+func (e *siemRejected) Error() string {
+	return fmt.Sprintf("SIEM collector returned %d %s: %s", e.status, http.StatusText(e.status), e.body)
+}
+
+// POST a batch, retrying network errors, 408s, 429s and 5xxs with jittered
+// backoff up to SIEM_MAX_RETRIES times
+func sendSIEMBatch(ctx context.Context, httpClient *http.Client, body []byte) error {
+	contentType := "application/x-ndjson"
+	if siemFormat == siemCEF {
+		contentType = "text/plain; charset=utf-8"
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, siemForwardURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode < 300:
+				return nil
+			case resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+				return &siemRejected{status: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+			}
+			err = fmt.Errorf("SIEM collector returned %s", resp.Status)
+		}
+		if attempt >= siemMaxRetries {
+			return err
+		}
+		siemRetries.Add(1)
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("⏳ SIEM forward failed (%v), retrying in %v", err, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// Keep a rejected batch in siem_dead_letters for someone to look at and
+// replay. Bodies too big for a document keep only the entry IDs.
+func (s *Server) deadLetterSIEMBatch(ctx context.Context, ids []string, body []byte, rejected *siemRejected) error {
+	letter := map[string]interface{}{
+		"entryIds": ids,
+		"format":   siemFormat,
+		"status":   rejected.status,
+		"error":    rejected.Error(),
+		"at":       time.Now().UTC(),
+	}
+	if len(body) < 512<<10 {
+		letter["body"] = string(body)
+	}
+	_, _, err := s.client.Collection(siemDeadLetters).Add(ctx, letter)
+	return err
+}
+
+// The HTTP client for the collector: HTTPS only, trusting SIEM_CA_FILE
+// when set and presenting SIEM_CLIENT_CERT/SIEM_CLIENT_KEY when set
+func siemHTTPClient() (*http.Client, error) {
+	u, err := url.Parse(siemForwardURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("SIEM_FORWARD_URL must be https, not %q", u.Scheme)
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if siemClientCert != "" || siemClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(siemClientCert, siemClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("SIEM client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if siemCAFile != "" {
+		pem, err := os.ReadFile(siemCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in SIEM_CA_FILE %s", siemCAFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	return &http.Client{Transport: transport, Timeout: siemTimeout}, nil
+}
+
+// Prometheus series for the SIEM forwarder, for metricsHandler
+func writeSIEMMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP siem_forwarded_total Audit entries delivered to the SIEM collector.")
+	fmt.Fprintln(w, "# TYPE siem_forwarded_total counter")
+	fmt.Fprintf(w, "siem_forwarded_total %d\n", siemForwarded.Load())
+	fmt.Fprintln(w, "# HELP siem_dead_lettered_total Audit entries the SIEM collector rejected, kept in siem_dead_letters.")
+	fmt.Fprintln(w, "# TYPE siem_dead_lettered_total counter")
+	fmt.Fprintf(w, "siem_dead_lettered_total %d\n", siemDeadLettered.Load())
+	fmt.Fprintln(w, "# HELP siem_forward_retries_total Retried SIEM batch deliveries.")
+	fmt.Fprintln(w, "# TYPE siem_forward_retries_total counter")
+	fmt.Fprintf(w, "siem_forward_retries_total %d\n", siemRetries.Load())
+}
@@ -0,0 +1,247 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// The Slack sink (OUTBOX_SINKS=slack) posts routed outbox events to the
+// incoming webhook at SLACK_WEBHOOK_URL. SLACK_ROUTES maps event types to
+// message templates as JSON; placeholders are {type}, {id}, {userId},
+// {actor} and {data.<key>}. Events without a route are accepted and
+// dropped. At most SLACK_RATE_LIMIT messages per event type are posted
+// each SLACK_RATE_WINDOW; the rest are collapsed into one "N similar
+// events" message when the window closes.
+var (
+	slackWebhookURL = config.String("SLACK_WEBHOOK_URL", "")
+	slackRoutes     = config.String("SLACK_ROUTES", `{
+		"user.deleted": ":wastebasket: User {userId} was deleted by {actor}",
+		"job.failed": ":rotating_light: Job {data.jobId} ({data.jobType}) failed: {data.error}"
+	}`)
+	slackRateLimit  = config.Int("SLACK_RATE_LIMIT", 5)
+	slackRateWindow = config.Duration("SLACK_RATE_WINDOW", time.Minute)
+	slackStats      = &slackCounters{counts: map[string]int64{}}
+)
+
+var slackPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.]+)\}`)
+
+var errSlackRateLimited = errors.New("slack rate limited the webhook")
+
+// slackSink implements EventSink
+type slackSink struct {
+	url    string
+	routes map[string]string
+
+	mu      sync.Mutex
+	windows map[string]*slackWindow // by event type
+	posted  map[string]time.Time    // event IDs already posted, so a redelivery (another sink failed) isn't posted twice
+}
+
+// slackWindow counts one event type's messages in the current rate window
+type slackWindow struct {
+	start     time.Time
+	sent      int
+	collapsed int
+}
+
+func newSlackSink() *slackSink {
+	if slackWebhookURL == "" {
+		log.Fatal("OUTBOX_SINKS includes slack but SLACK_WEBHOOK_URL is not set")
+	}
+	s := &slackSink{url: slackWebhookURL, windows: map[string]*slackWindow{}, posted: map[string]time.Time{}}
+	if err := json.Unmarshal([]byte(slackRoutes), &s.routes); err != nil {
+		log.Fatalf("Invalid SLACK_ROUTES: %v", err)
+	}
+	go s.closeWindows()
+	return s
+}
+
+func (s *slackSink) Name() string { return "slack" }
+
+func (s *slackSink) Deliver(ctx context.Context, event OutboxEvent) error {
+	template, ok := s.routes[event.Type]
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	if _, done := s.posted[event.ID]; done {
+		s.mu.Unlock()
+		return nil
+	}
+	w := s.windows[event.Type]
+	if w == nil {
+		w = &slackWindow{start: time.Now()}
+		s.windows[event.Type] = w
+	}
+	if w.sent >= slackRateLimit {
+		w.collapsed++
+		s.posted[event.ID] = time.Now()
+		s.mu.Unlock()
+		slackStats.add("collapsed")
+		return nil
+	}
+	w.sent++
+	s.mu.Unlock()
+
+	if err := s.post(ctx, renderSlackTemplate(template, event)); err != nil {
+		s.mu.Lock()
+		w.sent--
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Lock()
+	s.posted[event.ID] = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Post a summary for each window that ended with collapsed events, and
+// forget posted event IDs once redelivery is no longer likely
+func (s *slackSink) closeWindows() {
+	ticker := time.NewTicker(max(slackRateWindow/4, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		summaries := map[string]int{}
+		s.mu.Lock()
+		for eventType, w := range s.windows {
+			if now.Sub(w.start) < slackRateWindow {
+				continue
+			}
+			if w.collapsed > 0 {
+				summaries[eventType] = w.collapsed
+			}
+			delete(s.windows, eventType)
+		}
+		for id, at := range s.posted {
+			if now.Sub(at) > outboxLease*time.Duration(outboxMaxAttempts) {
+				delete(s.posted, id)
+			}
+		}
+		s.mu.Unlock()
+		for eventType, n := range summaries {
+			ctx, cancel := context.WithTimeout(context.Background(), outboxLease/2)
+			text := fmt.Sprintf(":heavy_plus_sign: %d similar %s events in the last %v", n, eventType, slackRateWindow)
+			if err := s.post(ctx, text); err != nil {
+				log.Printf("⚠️ Failed to post Slack summary for %s: %v", eventType, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Send one message, waiting out a 429's Retry-After when it fits in ctx
+func (s *slackSink) post(ctx context.Context, text string) error {
+	body, _ := json.Marshal(map[string]string{"text": text})
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := outboxHTTPClient.Do(req)
+		if err != nil {
+			slackStats.add("failed")
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode < 300:
+			slackStats.add("sent")
+			return nil
+		case resp.StatusCode != http.StatusTooManyRequests:
+			slackStats.add("failed")
+			return fmt.Errorf("slack returned %s", resp.Status)
+		}
+		wait := time.Second
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// Longer than we can hold the outbox lease; the dispatcher backs off instead
+			slackStats.add("failed")
+			return errSlackRateLimited
+		}
+		slackStats.add("retried")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			slackStats.add("failed")
+			return ctx.Err()
+		}
+	}
+}
+
+// Fill a route's placeholders from event; unknown ones are left as written
+func renderSlackTemplate(template string, event OutboxEvent) string {
+	return slackPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		key := m[1 : len(m)-1]
+		switch key {
+		case "type":
+			return event.Type
+		case "id":
+			return event.ID
+		case "userId":
+			return event.UserID
+		case "actor":
+			return event.Actor
+		}
+		if field, ok := strings.CutPrefix(key, "data."); ok {
+			if v, ok := event.Data[field]; ok {
+				return fmt.Sprint(v)
+			}
+		}
+		return m
+	})
+}
+
+// slackCounters counts Slack messages by result: sent, failed, retried
+// (a 429 waited out) and collapsed (rate limited into a summary)
+type slackCounters struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *slackCounters) add(result string) {
+	c.mu.Lock()
+	c.counts[result]++
+	c.mu.Unlock()
+}
+
+// Prometheus counters of Slack deliveries, for metricsHandler
+func writeSlackMetrics(w io.Writer) {
+	slackStats.mu.Lock()
+	counts := make(map[string]int64, len(slackStats.counts))
+	for k, n := range slackStats.counts {
+		counts[k] = n
+	}
+	slackStats.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	results := make([]string, 0, len(counts))
+	for k := range counts {
+		results = append(results, k)
+	}
+	sort.Strings(results)
+	fmt.Fprintln(w, "# HELP slack_messages_total Slack sink messages by result (sent, failed, retried, collapsed).")
+	fmt.Fprintln(w, "# TYPE slack_messages_total counter")
+	for _, result := range results {
+		fmt.Fprintf(w, "slack_messages_total{result=%s} %d\n", promLabel(result), counts[result])
+	}
+}
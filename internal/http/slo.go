@@ -0,0 +1,275 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Availability and latency SLOs: SLO_AVAILABILITY_TARGET of requests are
+// not 5xx, and SLO_LATENCY_PERCENTILE of them finish within
+// SLO_LATENCY_THRESHOLD. Requests are counted in a ring of one-minute
+// buckets covering the longest window, so the 5m/1h/6h totals for
+// burn-rate alerts are sums over the newest buckets. Everything is in
+// memory: counters start from zero after a restart (Prometheus rate()
+// treats that as a counter reset) and the windows refill from empty.
+var (
+	sloTarget     = config.Float("SLO_AVAILABILITY_TARGET", 0.995)
+	sloLatency    = config.Duration("SLO_LATENCY_THRESHOLD", 300*time.Millisecond)
+	sloPercentile = config.Float("SLO_LATENCY_PERCENTILE", 0.95)
+	sloWindows    = []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour}
+	// Latency bucket bounds as multiples of the threshold, which is itself a bound
+	sloBucketBounds = latencyBounds(sloLatency, []float64{0.1, 0.25, 0.5, 1, 2, 4, 10})
+	slo             = newSLORing(6 * 60)
+)
+
+func latencyBounds(threshold time.Duration, factors []float64) []time.Duration {
+	bounds := make([]time.Duration, len(factors))
+	for i, f := range factors {
+		bounds[i] = time.Duration(float64(threshold) * f)
+	}
+	return bounds
+}
+
+// sloCounts are request totals; latency[i] counts requests within
+// sloBucketBounds[i] (not cumulative), with one extra slot for slower ones
+type sloCounts struct {
+	requests, errors int64
+	latency          []int64
+	seconds          float64 // total latency
+}
+
+func newSLOCounts() sloCounts {
+	return sloCounts{latency: make([]int64, len(sloBucketBounds)+1)}
+}
+
+func (c *sloCounts) add(o sloCounts) {
+	c.requests += o.requests
+	c.errors += o.errors
+	c.seconds += o.seconds
+	for i, n := range o.latency {
+		c.latency[i] += n
+	}
+}
+
+// Requests within the threshold
+func (c sloCounts) fast() int64 {
+	var n int64
+	for i, bound := range sloBucketBounds {
+		if bound <= sloLatency {
+			n += c.latency[i]
+		}
+	}
+	return n
+}
+
+// Estimated latency at quantile q, interpolating within its bucket; the
+// open-ended last bucket reports its lower bound
+func (c sloCounts) quantile(q float64) time.Duration {
+	if c.requests == 0 {
+		return 0
+	}
+	rank := q * float64(c.requests)
+	var seen float64
+	lower := time.Duration(0)
+	for i, n := range c.latency {
+		if i == len(sloBucketBounds) {
+			return lower
+		}
+		if seen+float64(n) >= rank && n > 0 {
+			frac := (rank - seen) / float64(n)
+			return lower + time.Duration(frac*float64(sloBucketBounds[i]-lower))
+		}
+		seen += float64(n)
+		lower = sloBucketBounds[i]
+	}
+	return lower
+}
+
+// sloRing holds the lifetime totals and a ring of per-minute counts. A
+// slot belongs to the minute stored with it; one left over from an older
+// lap of the ring is reset when reused and ignored when summing.
+type sloRing struct {
+	mu      sync.Mutex
+	total   sloCounts
+	minutes []int64
+	slots   []sloCounts
+}
+
+func newSLORing(size int) *sloRing {
+	r := &sloRing{total: newSLOCounts(), minutes: make([]int64, size), slots: make([]sloCounts, size)}
+	for i := range r.slots {
+		r.slots[i] = newSLOCounts()
+		r.minutes[i] = -1
+	}
+	return r
+}
+
+func (r *sloRing) record(now time.Time, status int, took time.Duration) {
+	minute := now.Unix() / 60
+	i := int(minute % int64(len(r.slots)))
+	bucket := len(sloBucketBounds)
+	for b, bound := range sloBucketBounds {
+		if took <= bound {
+			bucket = b
+			break
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.minutes[i] != minute {
+		r.minutes[i], r.slots[i] = minute, newSLOCounts()
+	}
+	for _, c := range []*sloCounts{&r.total, &r.slots[i]} {
+		c.requests++
+		if status >= 500 {
+			c.errors++
+		}
+		c.latency[bucket]++
+		c.seconds += took.Seconds()
+	}
+}
+
+// Counts over the last window, including the current minute
+func (r *sloRing) window(now time.Time, window time.Duration) sloCounts {
+	current := now.Unix() / 60
+	oldest := current - int64(window/time.Minute) + 1
+	sum := newSLOCounts()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, minute := range r.minutes {
+		if minute >= oldest && minute <= current {
+			sum.add(r.slots[i])
+		}
+	}
+	return sum
+}
+
+func (r *sloRing) lifetime() sloCounts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sum := newSLOCounts()
+	sum.add(r.total)
+	return sum
+}
+
+// Count every request except the health and version probes
+func sloMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slo.record(time.Now(), rec.status, time.Since(start))
+	})
+}
+
+// How fast the error budget burns over c: 1 spends it exactly over the
+// SLO period, higher spends it sooner
+func burnRate(c sloCounts) float64 {
+	if c.requests == 0 || sloTarget >= 1 {
+		return 0
+	}
+	return float64(c.errors) / float64(c.requests) / (1 - sloTarget)
+}
+
+func windowLabel(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	}
+	return strconv.Itoa(int(d/time.Minute)) + "m"
+}
+
+// Append the SLO series to the Prometheus exposition of GET /admin/metrics
+func writeSLOMetrics(w http.ResponseWriter) {
+	now := time.Now()
+	total := slo.lifetime()
+	fmt.Fprintln(w, "# HELP slo_requests_total Requests counted toward the SLOs.")
+	fmt.Fprintln(w, "# TYPE slo_requests_total counter")
+	fmt.Fprintf(w, "slo_requests_total %d\n", total.requests)
+	fmt.Fprintln(w, "# HELP slo_errors_total Requests that failed with a 5xx status.")
+	fmt.Fprintln(w, "# TYPE slo_errors_total counter")
+	fmt.Fprintf(w, "slo_errors_total %d\n", total.errors)
+	fmt.Fprintln(w, "# HELP slo_request_duration_seconds Request latency; one bucket bound is the latency threshold.")
+	fmt.Fprintln(w, "# TYPE slo_request_duration_seconds histogram")
+	var cumulative int64
+	for i, bound := range sloBucketBounds {
+		cumulative += total.latency[i]
+		fmt.Fprintf(w, "slo_request_duration_seconds_bucket{le=%s} %d\n", promLabel(strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)), cumulative)
+	}
+	fmt.Fprintf(w, "slo_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", total.requests)
+	fmt.Fprintf(w, "slo_request_duration_seconds_sum %g\n", total.seconds)
+	fmt.Fprintf(w, "slo_request_duration_seconds_count %d\n", total.requests)
+
+	windows := make([]sloCounts, len(sloWindows))
+	for i, d := range sloWindows {
+		windows[i] = slo.window(now, d)
+	}
+	gauges := []struct {
+		name, help string
+		value      func(sloCounts) float64
+	}{
+		{"slo_window_requests", "Requests in the trailing window.", func(c sloCounts) float64 { return float64(c.requests) }},
+		{"slo_window_errors", "5xx responses in the trailing window.", func(c sloCounts) float64 { return float64(c.errors) }},
+		{"slo_window_slow_requests", "Requests slower than the latency threshold in the trailing window.", func(c sloCounts) float64 { return float64(c.requests - c.fast()) }},
+		{"slo_window_burn_rate", "Availability error budget burn rate over the trailing window.", burnRate},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		for i, d := range sloWindows {
+			fmt.Fprintf(w, "%s{window=%s} %g\n", g.name, promLabel(windowLabel(d)), g.value(windows[i]))
+		}
+	}
+	fmt.Fprintln(w, "# HELP slo_availability_target Fraction of requests that must not be 5xx.")
+	fmt.Fprintln(w, "# TYPE slo_availability_target gauge")
+	fmt.Fprintf(w, "slo_availability_target %g\n", sloTarget)
+	fmt.Fprintln(w, "# HELP slo_latency_threshold_seconds Latency the SLO percentile must stay under.")
+	fmt.Fprintln(w, "# TYPE slo_latency_threshold_seconds gauge")
+	fmt.Fprintf(w, "slo_latency_threshold_seconds %g\n", sloLatency.Seconds())
+}
+
+// SLO summary per window with error budget consumption (GET /admin/slo).
+// budgetConsumed is the share of the window's error budget spent: 1 means
+// errors reached exactly 1 - target of its requests.
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	windows := map[string]interface{}{}
+	for _, d := range sloWindows {
+		c := slo.window(now, d)
+		entry := map[string]interface{}{
+			"requests":       c.requests,
+			"errors":         c.errors,
+			"burnRate":       burnRate(c),
+			"budgetConsumed": 0.0,
+			"latencyMet":     true,
+		}
+		if c.requests > 0 {
+			availability := 1 - float64(c.errors)/float64(c.requests)
+			fastShare := float64(c.fast()) / float64(c.requests)
+			entry["availability"] = availability
+			entry["budgetConsumed"] = burnRate(c)
+			entry["withinThreshold"] = fastShare
+			entry["latencyMet"] = fastShare >= sloPercentile
+			entry["estimatedPercentile"] = c.quantile(sloPercentile).String()
+		}
+		windows[windowLabel(d)] = entry
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"availabilityTarget": sloTarget,
+		"latencyThreshold":   sloLatency.String(),
+		"latencyPercentile":  sloPercentile,
+		"since":              usage.since,
+		"windows":            windows,
+	})
+}
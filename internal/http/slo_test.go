@@ -0,0 +1,62 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Record past the ring's capacity: each slot holds its newest lap, older
+// laps drop out of every window, and the lifetime totals keep them
+func TestSLORingWraps(t *testing.T) {
+	r := newSLORing(5)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return start.Add(time.Duration(minute)*time.Minute + 30*time.Second) }
+	// Minute m gets m+1 requests, the last of them a 500
+	for m := 0; m < 12; m++ {
+		for i := 0; i <= m; i++ {
+			status := 200
+			if i == m {
+				status = 500
+			}
+			r.record(at(m), status, 10*time.Millisecond)
+		}
+	}
+
+	base := start.Unix() / 60
+	var gotMinutes, gotRequests []int64
+	for i := range r.slots {
+		gotMinutes = append(gotMinutes, r.minutes[i]-base)
+		gotRequests = append(gotRequests, r.slots[i].requests)
+	}
+	// Slot minute%5: minutes 10 and 11 reused the slots of 5 and 6
+	if want := []int64{10, 11, 7, 8, 9}; !reflect.DeepEqual(gotMinutes, want) {
+		t.Errorf("slot minutes = %v, want %v", gotMinutes, want)
+	}
+	if want := []int64{11, 12, 8, 9, 10}; !reflect.DeepEqual(gotRequests, want) {
+		t.Errorf("slot requests = %v, want %v", gotRequests, want)
+	}
+
+	tests := []struct {
+		now              int
+		window           time.Duration
+		requests, errors int64
+	}{
+		{11, 5 * time.Minute, 8 + 9 + 10 + 11 + 12, 5},
+		{11, 3 * time.Minute, 10 + 11 + 12, 3},
+		{11, time.Minute, 12, 1},
+		{11, 10 * time.Minute, 8 + 9 + 10 + 11 + 12, 5}, // longer than the ring holds
+		{14, 5 * time.Minute, 11 + 12, 2},               // minutes 12-14 are empty
+		{17, 5 * time.Minute, 0, 0},                     // every slot is stale
+	}
+	for _, tt := range tests {
+		c := r.window(at(tt.now), tt.window)
+		if c.requests != tt.requests || c.errors != tt.errors {
+			t.Errorf("window(minute %d, %v) = %d requests, %d errors; want %d, %d", tt.now, tt.window, c.requests, c.errors, tt.requests, tt.errors)
+		}
+	}
+
+	if total := r.lifetime(); total.requests != 78 || total.errors != 12 {
+		t.Errorf("lifetime = %d requests, %d errors; want 78, 12", total.requests, total.errors)
+	}
+}
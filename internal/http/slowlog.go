@@ -0,0 +1,253 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Every Firestore RPC is timed by gRPC interceptors installed on the
+// client, so handlers need no instrumentation. Operations slower than
+// SLOW_OP_THRESHOLD are logged; the SLOWLOG_SIZE slowest since startup
+// are kept for GET /admin/slowlog. The request ID is included when the
+// operation's context comes from the request.
+var (
+	slowOpThreshold = config.Duration("SLOW_OP_THRESHOLD", 500*time.Millisecond)
+	slowOps         = &slowLog{size: config.Int("SLOWLOG_SIZE", 50)}
+)
+
+// Long-lived streams (snapshot listeners, BulkWriter's write stream) have
+// no meaningful duration
+var untimedMethods = map[string]bool{"Listen": true, "Write": true}
+
+// SlowOp is one timed Firestore operation
+type SlowOp struct {
+	Op         string    `json:"op"` // RPC name, e.g. RunQuery, Commit
+	Collection string    `json:"collection,omitempty"`
+	Filter     string    `json:"filter,omitempty"` // field paths and operators, never values
+	Documents  int       `json:"documents"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Duration   string    `json:"duration"`
+	At         Timestamp `json:"at"`
+	Error      string    `json:"error,omitempty"`
+	elapsed    time.Duration
+}
+
+// slowLog keeps the size slowest operations seen
+type slowLog struct {
+	mu   sync.Mutex
+	size int
+	ops  []SlowOp // slowest first
+}
+
+func (l *slowLog) add(op SlowOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ops) == l.size && (l.size == 0 || op.elapsed <= l.ops[len(l.ops)-1].elapsed) {
+		return
+	}
+	i := sort.Search(len(l.ops), func(i int) bool { return l.ops[i].elapsed < op.elapsed })
+	l.ops = append(l.ops, SlowOp{})
+	copy(l.ops[i+1:], l.ops[i:])
+	l.ops[i] = op
+	if len(l.ops) > l.size {
+		l.ops = l.ops[:l.size]
+	}
+}
+
+func (l *slowLog) snapshot() []SlowOp {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SlowOp{}, l.ops...)
+}
+
+// Client options installing the timing interceptors, and inside them the
+// chaos interceptors so injected delays are timed too
+func slowOpOptions() []option.ClientOption {
+	opts := []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(timeUnaryOp)),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(timeStreamOp)),
+	}
+	for _, opt := range append(traceOptions(), chaosOptions()...) {
+		opts = append(opts, option.WithGRPCDialOption(opt))
+	}
+	return opts
+}
+
+func timeUnaryOp(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil || status.Code(err) == codes.NotFound {
+		countUnaryUsage(ctx, req, reply)
+	}
+	op := describeOp(ctx, method, req)
+	if get, ok := reply.(*firestorepb.Document); ok && get != nil && err == nil {
+		op.Documents = 1
+	}
+	finishOp(ctx, op, start, err)
+	return err
+}
+
+func timeStreamOp(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if untimedMethods[path.Base(method)] {
+		if err == nil && path.Base(method) == "Listen" {
+			stream = newWatchedStream(ctx, stream)
+		}
+		return stream, err
+	}
+	if err != nil {
+		finishOp(ctx, describeOp(ctx, method, nil), start, err)
+		return stream, err
+	}
+	return &timedStream{ClientStream: stream, ctx: ctx, method: method, start: start}, nil
+}
+
+// timedStream times a server stream from open until its last message
+type timedStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	method string
+	start  time.Time
+	req    interface{}
+	docs   int
+	once   sync.Once
+}
+
+func (s *timedStream) SendMsg(m interface{}) error {
+	if s.req == nil {
+		s.req = m
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *timedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		switch resp := m.(type) {
+		case *firestorepb.RunQueryResponse:
+			if resp.GetDocument() != nil {
+				s.docs++
+			}
+		case *firestorepb.BatchGetDocumentsResponse:
+			if resp.GetFound() != nil {
+				s.docs++
+			}
+		}
+		return nil
+	}
+	s.once.Do(func() {
+		if err == io.EOF {
+			countStreamUsage(s.ctx, path.Base(s.method), s.docs)
+		}
+		op := describeOp(s.ctx, s.method, s.req)
+		op.Documents = s.docs
+		finishOp(s.ctx, op, s.start, err)
+	})
+	return err
+}
+
+// Operation type, collection and filter summary of a Firestore request
+func describeOp(ctx context.Context, method string, req interface{}) SlowOp {
+	op := SlowOp{Op: path.Base(method)}
+	op.RequestID, _ = ctx.Value(requestIDKey{}).(string)
+	var query *firestorepb.StructuredQuery
+	switch r := req.(type) {
+	case *firestorepb.RunQueryRequest:
+		query = r.GetStructuredQuery()
+	case *firestorepb.RunAggregationQueryRequest:
+		query = r.GetStructuredAggregationQuery().GetStructuredQuery()
+	case *firestorepb.GetDocumentRequest:
+		op.Collection = collectionOf(r.GetName())
+	case *firestorepb.BatchGetDocumentsRequest:
+		if docs := r.GetDocuments(); len(docs) > 0 {
+			op.Collection = collectionOf(docs[0])
+		}
+	case *firestorepb.CommitRequest:
+		op.Documents = len(r.GetWrites())
+		if writes := r.GetWrites(); len(writes) > 0 {
+			name := writes[0].GetUpdate().GetName()
+			if name == "" {
+				name = writes[0].GetDelete()
+			}
+			op.Collection = collectionOf(name)
+		}
+	}
+	if query != nil {
+		if from := query.GetFrom(); len(from) > 0 {
+			op.Collection = from[0].GetCollectionId()
+		}
+		op.Filter = summarizeFilter(query.GetWhere())
+	}
+	return op
+}
+
+// Collection ID of a document resource name (.../documents/users/abc -> users)
+func collectionOf(name string) string {
+	_, rest, ok := strings.Cut(name, "/documents/")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+func summarizeFilter(f *firestorepb.StructuredQuery_Filter) string {
+	if f == nil {
+		return ""
+	}
+	if c := f.GetCompositeFilter(); c != nil {
+		var parts []string
+		for _, child := range c.GetFilters() {
+			parts = append(parts, summarizeFilter(child))
+		}
+		return strings.Join(parts, " "+c.GetOp().String()+" ")
+	}
+	if ff := f.GetFieldFilter(); ff != nil {
+		return ff.GetField().GetFieldPath() + " " + ff.GetOp().String()
+	}
+	if uf := f.GetUnaryFilter(); uf != nil {
+		return uf.GetField().GetFieldPath() + " " + uf.GetOp().String()
+	}
+	return ""
+}
+
+func finishOp(ctx context.Context, op SlowOp, start time.Time, err error) {
+	writeThrottle.observe(err)
+	op.elapsed = time.Since(start)
+	op.At = Timestamp{Time: start}
+	op.Duration = op.elapsed.String()
+	if err != nil && err != io.EOF {
+		op.Error = err.Error()
+	}
+	slowOps.add(op)
+	if op.elapsed < slowOpThreshold {
+		return
+	}
+	logCtx(ctx, "⚠️ Slow Firestore operation op=%s collection=%q filter=%q documents=%d duration=%s requestId=%q",
+		op.Op, op.Collection, op.Filter, op.Documents, op.Duration, op.RequestID)
+}
+
+// The slowest Firestore operations since startup (GET /admin/slowlog)
+func slowlogHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"threshold":  slowOpThreshold.String(),
+		"operations": slowOps.snapshot(),
+	})
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Firestore leaves documents without a field out of queries ordered by
+// it, so users stored before createdAt existed never appear in
+// /v1/users?orderBy=createdAt, nor those stored before updatedAt in
+// /users/sync. Those lists compare a count of all users with a count of
+// those having the field (cached for SORT_FIELD_CHECK_TTL, so it costs
+// two aggregation reads per interval) and warn when they differ.
+// Migrations 0004_user_created_at_backfill and 0005_user_updated_at_backfill
+// close the gaps; SORT_FIELD_CHECK=false acknowledges them and skips the
+// counts.
+var (
+	sortFieldCheck    = config.Bool("SORT_FIELD_CHECK", true)
+	sortFieldCheckTTL = config.Duration("SORT_FIELD_CHECK_TTL", 5*time.Minute)
+)
+
+var missingSortFields = struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	checked map[string]time.Time
+}{counts: map[string]int64{}, checked: map[string]time.Time{}}
+
+// How many users lack field, and so drop out of queries ordered by it
+func (s *Server) usersMissingField(ctx context.Context, field string) (int64, error) {
+	missingSortFields.mu.Lock()
+	n, ok := missingSortFields.counts[field]
+	fresh := ok && time.Since(missingSortFields.checked[field]) < sortFieldCheckTTL
+	missingSortFields.mu.Unlock()
+	if fresh {
+		return n, nil
+	}
+
+	count := func(q firestore.Query) (int64, error) {
+		res, err := q.NewAggregationQuery().WithCount("all").Get(ctx)
+		if err != nil {
+			return 0, err
+		}
+		v, _ := res["all"].(interface{ GetIntegerValue() int64 })
+		if v == nil {
+			return 0, fmt.Errorf("count of users missing %s returned no value", field)
+		}
+		return v.GetIntegerValue(), nil
+	}
+	all, err := count(s.users.Users().Query)
+	if err != nil {
+		return 0, err
+	}
+	with, err := count(s.users.Users().OrderBy(field, firestore.Asc))
+	if err != nil {
+		return 0, err
+	}
+	n = max(all-with, 0)
+
+	missingSortFields.mu.Lock()
+	missingSortFields.counts[field], missingSortFields.checked[field] = n, time.Now()
+	missingSortFields.mu.Unlock()
+	return n, nil
+}
+
+// The list envelope's warning for results ordered by field, "" when every
+// user has it (or the check is off or fails)
+func (s *Server) sortFieldWarning(ctx context.Context, field string) string {
+	if !sortFieldCheck {
+		return ""
+	}
+	n, err := s.usersMissingField(ctx, field)
+	if err != nil {
+		logCtx(ctx, "⚠️ Couldn't count users missing %s: %v", field, err)
+		return ""
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d documents lack the sort field %s and are not listed; POST /admin/migrations backfills it", n, field)
+}
+
+// Store createdAt on users that lack it, from the document's create
+// time, so ordering and filtering by createdAt include them
+func (s *Server) migrateUserCreatedAt(ctx context.Context, dryRun bool) (int, error) {
+	return s.backfillUserTime(ctx, dryRun, "createdAt", func(doc *firestore.DocumentSnapshot) time.Time { return doc.CreateTime })
+}
+
+// Store updatedAt on users that lack it, from the document's update
+// time, so /users/sync includes them
+func (s *Server) migrateUserUpdatedAt(ctx context.Context, dryRun bool) (int, error) {
+	return s.backfillUserTime(ctx, dryRun, "updatedAt", func(doc *firestore.DocumentSnapshot) time.Time { return doc.UpdateTime })
+}
+
+// Set field from the document's metadata on users without it
+func (s *Server) backfillUserTime(ctx context.Context, dryRun bool, field string, from func(doc *firestore.DocumentSnapshot) time.Time) (int, error) {
+	n, err := s.rewriteUserDocs(ctx, dryRun, func(doc *firestore.DocumentSnapshot) []firestore.Update {
+		if doc.Data()[field] != nil {
+			return nil
+		}
+		return []firestore.Update{{Path: field, Value: from(doc).UTC()}}
+	})
+	if !dryRun {
+		missingSortFields.mu.Lock()
+		delete(missingSortFields.counts, field)
+		missingSortFields.mu.Unlock()
+	}
+	return n, err
+}
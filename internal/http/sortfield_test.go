@@ -0,0 +1,175 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Start with no cached counts, since other tests' projects leave theirs
+func resetMissingSortFields(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		missingSortFields.mu.Lock()
+		clear(missingSortFields.counts)
+		clear(missingSortFields.checked)
+		missingSortFields.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestUsersMissingField(t *testing.T) {
+	s, ctx := useEmulator(t)
+	resetMissingSortFields(t)
+	mustCreateUser(t, s, ctx, model.User{Name: "Ada"})
+	if _, _, err := s.users.Users().Add(ctx, map[string]interface{}{"name": "Legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.usersMissingField(ctx, "createdAt"); err != nil || n != 1 {
+		t.Fatalf("missing createdAt = %d, %v, want 1", n, err)
+	}
+
+	// The count is cached for SORT_FIELD_CHECK_TTL
+	if _, _, err := s.users.Users().Add(ctx, map[string]interface{}{"name": "Legacy 2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.usersMissingField(ctx, "createdAt"); err != nil || n != 1 {
+		t.Errorf("missing createdAt within the TTL = %d, %v, want the cached 1", n, err)
+	}
+	saved := sortFieldCheckTTL
+	sortFieldCheckTTL = 0
+	t.Cleanup(func() { sortFieldCheckTTL = saved })
+	if n, err := s.usersMissingField(ctx, "createdAt"); err != nil || n != 2 {
+		t.Errorf("missing createdAt once stale = %d, %v, want 2", n, err)
+	}
+	if n, err := s.usersMissingField(ctx, "name"); err != nil || n != 0 {
+		t.Errorf("missing name = %d, %v, want 0", n, err)
+	}
+}
+
+func TestSortFieldWarning(t *testing.T) {
+	s, ctx := useEmulator(t)
+	resetMissingSortFields(t)
+	mustCreateUser(t, s, ctx, model.User{Name: "Ada"})
+	if got := s.sortFieldWarning(ctx, "createdAt"); got != "" {
+		t.Errorf("every user has createdAt: warning %q, want none", got)
+	}
+	resetMissingSortFields(t)
+	if _, _, err := s.users.Users().Add(ctx, map[string]interface{}{"name": "Legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.sortFieldWarning(ctx, "createdAt"); !strings.HasPrefix(got, "1 documents lack the sort field createdAt") {
+		t.Errorf("one user without createdAt: warning %q", got)
+	}
+
+	saved := sortFieldCheck
+	sortFieldCheck = false
+	t.Cleanup(func() { sortFieldCheck = saved })
+	if got := s.sortFieldWarning(ctx, "createdAt"); got != "" {
+		t.Errorf("SORT_FIELD_CHECK=false: warning %q, want none", got)
+	}
+}
+
+// Page through /v1/users?orderBy=createdAt, returning the listed IDs and
+// the first page's warning
+func listByCreatedAt(t *testing.T, s *Server) ([]string, string) {
+	t.Helper()
+	var (
+		ids       []string
+		warning   string
+		pageToken string
+	)
+	for page := 0; ; page++ {
+		q := url.Values{"orderBy": {"createdAt"}, "pageSize": {"2"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		rec := httptest.NewRecorder()
+		s.v1ListUsersHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/users?"+q.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list = %d %s", rec.Code, rec.Body)
+		}
+		var resp api.UserListResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if page == 0 {
+			warning = resp.Warning
+		}
+		for _, u := range resp.Users {
+			ids = append(ids, u.ID)
+		}
+		if resp.NextPageToken == "" {
+			return ids, warning
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+func sameIDs(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
+
+// Users stored before createdAt existed drop out of lists ordered by it
+// until 0004_user_created_at_backfill gives them one
+func TestMigrateUserCreatedAt(t *testing.T) {
+	s, ctx := useEmulator(t)
+	resetMissingSortFields(t)
+	want := []string{
+		mustCreateUser(t, s, ctx, model.User{Name: "Ada"}),
+		mustCreateUser(t, s, ctx, model.User{Name: "Grace"}),
+	}
+	var legacy []string
+	for _, name := range []string{"Alan", "Edsger", "Barbara"} {
+		ref, _, err := s.users.Users().Add(ctx, map[string]interface{}{"name": name, "email": strings.ToLower(name) + "@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacy = append(legacy, ref.ID)
+	}
+
+	ids, warning := listByCreatedAt(t, s)
+	if !sameIDs(ids, want) {
+		t.Errorf("before the backfill listed %v, want only %v", ids, want)
+	}
+	if !strings.HasPrefix(warning, "3 documents lack the sort field createdAt") {
+		t.Errorf("before the backfill: warning %q", warning)
+	}
+
+	if n, err := s.migrateUserCreatedAt(ctx, true); err != nil || n != 3 {
+		t.Fatalf("dry run: %d, %v, want 3 documents", n, err)
+	}
+	if n, err := s.migrateUserCreatedAt(ctx, false); err != nil || n != 3 {
+		t.Fatalf("migration: %d, %v, want 3 documents", n, err)
+	}
+	for _, id := range legacy {
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := doc.Data()["createdAt"].(time.Time); !got.Equal(doc.CreateTime) {
+			t.Errorf("%s: createdAt %v, want its create time %v", id, got, doc.CreateTime)
+		}
+	}
+
+	want = append(want, legacy...)
+	ids, warning = listByCreatedAt(t, s)
+	if !sameIDs(ids, want) {
+		t.Errorf("after the backfill listed %v, want all of %v", ids, want)
+	}
+	if warning != "" {
+		t.Errorf("after the backfill: warning %q, want none", warning)
+	}
+	if n, err := s.migrateUserCreatedAt(ctx, false); err != nil || n != 0 {
+		t.Errorf("second run: %d, %v, want nothing left to backfill", n, err)
+	}
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WARMUP=true makes one lightweight read right after connecting, so the
+// gRPC channel and auth token exist before the first request arrives. A
+// Firestore outage only delays startup by WARMUP_TIMEOUT.
+var (
+	warmupEnabled = config.Bool("WARMUP", false)
+	warmupTimeout = config.Duration("WARMUP_TIMEOUT", 5*time.Second)
+)
+
+// Set once before the listener starts, read by /version
+var (
+	startedAt      = time.Now().UTC()
+	warmupDuration time.Duration
+	warmupError    string
+)
+
+// LAZY_INIT=true leaves subcommands to connect on first use, so one that
+// turns out not to need Firestore (bad flags, --help) runs without
+// credentials. By default a subcommand that uses Firestore connects before
+// it parses its arguments, failing fast on bad credentials. The server
+// always connects at startup: its background loops need the client.
+var lazyInit = config.Bool("LAZY_INIT", false)
+
+// Connect to Firestore if not yet connected. Subcommands call this where
+// they first need the client; it is a no-op once RunCommand connected.
+func (s *Server) ensureFirestore() {
+	s.firestoreOnce.Do(func() {
+		if err := s.connectFirestore(); err != nil {
+			log.Fatalf("Failed to initialize Firestore: %v", err)
+		}
+	})
+}
+
+// Read a document that needn't exist; NotFound still proves the channel works
+func (s *Server) warmUpFirestore() {
+	if !warmupEnabled {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := s.client.Collection("_warmup").Doc("ping").Get(ctx)
+	warmupDuration = time.Since(start)
+	if err != nil && status.Code(err) != codes.NotFound {
+		warmupError = err.Error()
+		log.Printf("⚠️ Firestore warm-up failed after %s, continuing: %v", warmupDuration, err)
+		return
+	}
+	fmt.Printf("🔥 Firestore warm-up took %s\n", warmupDuration)
+}
+
+// Project and database IDs the client talks to, from a document path
+// ("projects/{project}/databases/{database}/documents/...")
+func (s *Server) firestoreDatabase() (project, database string) {
+	parts := strings.Split(s.client.Collection("users").Path, "/")
+	if len(parts) > 3 {
+		return parts[1], parts[3]
+	}
+	return "", ""
+}
+
+// A setting stamped into the binary at build time, e.g. vcs.revision
+func buildSetting(key string) string {
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range build.Settings {
+			if s.Key == key {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Build and startup information (GET /version)
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	info := map[string]interface{}{
+		"goVersion":   runtime.Version(),
+		"startedAt":   startedAt,
+		"environment": currentEnvironment,
+	}
+	if v := buildSetting("vcs.revision"); v != "" {
+		info["revision"] = v
+	}
+	if v := buildSetting("vcs.time"); v != "" {
+		info["revisionTime"] = v
+	}
+	if v := buildSetting("vcs.modified"); v != "" {
+		info["dirty"] = v == "true"
+	}
+	if warmupEnabled {
+		warmup := map[string]interface{}{"duration": warmupDuration.String()}
+		if warmupError != "" {
+			warmup["error"] = warmupError
+		}
+		info["warmup"] = warmup
+	}
+	writeJSON(w, r, http.StatusOK, info)
+}
@@ -0,0 +1,448 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Returned by fetchDocuments for a ref with no document
+var errDocumentMissing = errors.New("document not found")
+
+// Concurrent Gets used by fetchDocuments for refs spanning collections
+const fetchConcurrency = 10
+
+// Create a user together with its email index entry and first history
+// version, crediting referredBy's referralCount when it is set, and queue
+// it for enrichment. A dry run checks the email claim and the referrer and
+// returns dryRunID.
+func (s *Server) createUser(ctx context.Context, user model.User, referredBy, actor string, dryRun bool) (string, error) {
+	ref := s.users.NewUser()
+	if user.Plan == "" {
+		user.Plan = model.PlanFree
+	}
+	data := userToData(user)
+	now := time.Now().UTC()
+	data["createdAt"], data["updatedAt"] = now, now
+	if referredBy != "" {
+		data["referredBy"] = referredBy
+	}
+	if enricher != nil {
+		data["enrichmentStatus"] = enrichmentPending
+	}
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		var countReferral func() error
+		if referredBy != "" {
+			var err error
+			if countReferral, err = s.referTx(tx, referredBy, ref.ID); err != nil {
+				return err
+			}
+		}
+		if model.NormalizeEmail(user.Email) != "" {
+			if err := s.users.ClaimEmail(tx, user.Email, ref.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(ref, withHistoryVersion(data, nil)); err != nil {
+			return err
+		}
+		if countReferral != nil {
+			if err := countReferral(); err != nil {
+				return err
+			}
+		}
+		if err := s.recordOutboxTx(tx, "user.created", ref.ID, actor, data); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, nil, HistoryEntry{Op: "create", Data: data, Actor: actor})
+	})
+	if dryRun {
+		return dryRunID, err
+	}
+	if err == nil {
+		s.enqueueEnrichment(ref.ID, user)
+	}
+	return ref.ID, err
+}
+
+// Replace a user, moving the email index entry when the email changes
+func (s *Server) updateUser(ctx context.Context, id string, user model.User, actor string, dryRun bool) error {
+	_, err := s.modifyUser(ctx, id, actor, dryRun, func(model.User) (model.User, error) { return user, nil })
+	return err
+}
+
+// Read-modify-write a user in one transaction. mutate receives the stored
+// user and returns its replacement; its error aborts the transaction.
+// Fields outside the User schema (e.g. clonedFrom) are left untouched.
+// A dry run returns the replacement without writing it.
+func (s *Server) modifyUser(ctx context.Context, id, actor string, dryRun bool, mutate func(current model.User) (model.User, error)) (model.User, error) {
+	ref := s.users.Users().Doc(id)
+	var updated model.User
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(ctx, doc); err != nil {
+			return err
+		}
+		latest, err := latestHistoryTx(tx, ref)
+		if err != nil {
+			return err
+		}
+		old := userFromDoc(doc)
+		user, err := mutate(old)
+		if err != nil {
+			return err
+		}
+
+		if err := s.users.MoveEmail(tx, id, old.Email, user.Email); err != nil {
+			return err
+		}
+		data := userToData(user)
+		merged := mergeUserData(data)
+		stampUpdatedAt(merged)
+		merged[historyVersionField] = nextHistoryVersion(latest)
+		paths := append(userFieldPaths(), firestore.FieldPath{"updatedAt"}, firestore.FieldPath{historyVersionField})
+		if err := tx.Set(ref, merged, firestore.Merge(paths...)); err != nil {
+			return err
+		}
+		updated = user
+		if err := s.recordOutboxChangeTx(tx, "user.updated", id, actor, data, doc.Data()); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{Op: "update", Data: data, Previous: doc.Data(), Actor: actor})
+	})
+	forgetDocumentRead(ref)
+	return updated, err
+}
+
+// Apply field-path updates (an update mask) to a user in place, keeping
+// the email index, derived fields, history and outbox as modifyUser does
+func (s *Server) modifyUserFields(ctx context.Context, id, actor string, dryRun bool, updates []firestore.Update) (model.User, error) {
+	ref := s.users.Users().Doc(id)
+	var updated model.User
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(ctx, doc); err != nil {
+			return err
+		}
+		latest, err := latestHistoryTx(tx, ref)
+		if err != nil {
+			return err
+		}
+		old := userFromDoc(doc)
+		user := userFromData(applyFieldUpdates(doc.Data(), updates))
+		if err := s.users.MoveEmail(tx, id, old.Email, user.Email); err != nil {
+			return err
+		}
+		if len(updates) > 0 {
+			if err := tx.Update(ref, append(withUpdatedAt(withDerivedUpdates(doc.Data(), updates)), historyVersionUpdate(latest))); err != nil {
+				return err
+			}
+		}
+		updated = user
+		data := userToData(user)
+		if err := s.recordOutboxChangeTx(tx, "user.updated", id, actor, data, doc.Data()); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{Op: "update", Data: data, Previous: doc.Data(), Actor: actor})
+	})
+	forgetDocumentRead(ref)
+	return updated, err
+}
+
+// Delete a user and release its email; a dry run only checks the user
+// exists and isn't protected
+func (s *Server) deleteUser(ctx context.Context, id string, actor string, dryRun bool) error {
+	ref := s.users.Users().Doc(id)
+	defer forgetDocumentRead(ref)
+	return s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return store.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(ctx, doc); err != nil {
+			return err
+		}
+		if err := s.checkProtectionTx(ctx, tx, doc, "delete", actor); err != nil {
+			return err
+		}
+		latest, err := latestHistoryTx(tx, ref)
+		if err != nil {
+			return err
+		}
+		old := userFromDoc(doc)
+
+		if email := model.NormalizeEmail(old.Email); email != "" {
+			idx, err := tx.Get(s.users.EmailIndex(email))
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			// Only release the entry if this user actually owns it
+			if err == nil {
+				if owner, _ := idx.Data()["userId"].(string); owner == id {
+					if err := tx.Delete(idx.Ref); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		if err := s.recordTombstoneTx(tx, id, "deleted"); err != nil {
+			return err
+		}
+		if err := s.recordOutboxTx(tx, "user.deleted", id, actor, nil); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{Op: "delete", Previous: doc.Data(), Actor: actor})
+	})
+}
+
+// Map a JSON field name of User to the field name stored in Firestore
+func firestoreFieldName(jsonName string) (string, bool) {
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != jsonName {
+			continue
+		}
+		stored, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if stored == "-" {
+			return "", false
+		}
+		if stored == "" {
+			stored = f.Name
+		}
+		return stored, true
+	}
+	return "", false
+}
+
+// Encode a User the way Firestore stores it, keyed by stored field names
+// and with its derived fields
+func userToData(user model.User) map[string]interface{} {
+	data := map[string]interface{}{}
+	v := reflect.ValueOf(user)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "omitempty") && v.Field(i).IsZero() {
+			continue
+		}
+		if f.Type.Kind() == reflect.String {
+			data[name] = v.Field(i).String() // enums (Plan) are stored as plain strings
+			continue
+		}
+		data[name] = v.Field(i).Interface()
+	}
+	deriveUserFields(data)
+	return data
+}
+
+// Decode stored user fields (a document or history snapshot) back into a
+// User. Documents written before User had firestore tags keep their fields
+// under the Go names ("Name", "Email"); those are read when the tagged
+// name is absent. Values of the wrong type are left empty.
+func userFromData(data map[string]interface{}) model.User {
+	user, _ := decodeUserData(data)
+	return user
+}
+
+// Like userFromData, but also reports the stored names of fields holding a
+// value of the wrong type (e.g. an email stored as a number)
+func decodeUserData(data map[string]interface{}) (model.User, []string) {
+	var user model.User
+	var malformed []string
+	v := reflect.ValueOf(&user).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		raw, ok := data[name]
+		if !ok {
+			name = f.Name
+			raw = data[name]
+		}
+		val := reflect.ValueOf(raw)
+		if !val.IsValid() {
+			continue // absent or null
+		}
+		if f.Type.Kind() == reflect.String && val.Kind() == reflect.String {
+			val = val.Convert(f.Type)
+		}
+		if !val.Type().AssignableTo(f.Type) {
+			malformed = append(malformed, name)
+			continue
+		}
+		v.Field(i).Set(val)
+	}
+	if user.Plan == "" {
+		user.Plan = model.PlanFree // stored before plans existed
+	} else if _, ok := plans.parse(string(user.Plan)); !ok {
+		malformed = append(malformed, "plan")
+	}
+	return user, malformed
+}
+
+// Decode a user document, tolerating legacy field casing
+func userFromDoc(doc *firestore.DocumentSnapshot) model.User {
+	return userFromData(doc.Data())
+}
+
+// Rename legacy Go-cased user fields in data to their stored names, in
+// place; when both are present the stored name wins. Returns whether
+// anything changed.
+func normalizeUserData(data map[string]interface{}) bool {
+	changed := false
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "" || name == "-" || name == f.Name {
+			continue
+		}
+		legacy, ok := data[f.Name]
+		if !ok {
+			continue
+		}
+		if _, exists := data[name]; !exists {
+			data[name] = legacy
+		}
+		delete(data, f.Name)
+		changed = true
+	}
+	return changed
+}
+
+// Merge payload replacing every schema field: fields left out of data
+// (omitempty) and legacy-cased copies are deleted rather than kept
+func mergeUserData(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for _, fp := range userFieldPaths() {
+		if v, ok := data[fp[0]]; ok {
+			out[fp[0]] = v
+		} else {
+			out[fp[0]] = firestore.Delete
+		}
+	}
+	return out
+}
+
+// Stored field paths of every User field, plus the legacy Go-cased name
+// where it differs and the derived fields, for merges that replace the
+// schema fields only
+func userFieldPaths() []firestore.FieldPath {
+	var paths []firestore.FieldPath
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		paths = append(paths, firestore.FieldPath{name})
+		if name != f.Name {
+			paths = append(paths, firestore.FieldPath{f.Name})
+		}
+	}
+	for _, f := range derivedFields {
+		paths = append(paths, firestore.FieldPath{f.name})
+	}
+	return paths
+}
+
+// Fetch refs, returning snapshots and errors in input order. Refs in a
+// single collection go through one GetAll; otherwise up to
+// fetchConcurrency Gets run at once. A missing document gets a nil
+// snapshot and errDocumentMissing rather than failing the whole batch.
+func (s *Server) fetchDocuments(ctx context.Context, refs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, []error) {
+	docs := make([]*firestore.DocumentSnapshot, len(refs))
+	errs := make([]error, len(refs))
+	if len(refs) == 0 {
+		return docs, errs
+	}
+
+	sameCollection := true
+	for _, ref := range refs[1:] {
+		if ref.Parent.Path != refs[0].Parent.Path {
+			sameCollection = false
+			break
+		}
+	}
+
+	if sameCollection {
+		// GetAll preserves input order
+		snaps, err := s.client.GetAll(ctx, refs)
+		for i := range refs {
+			switch {
+			case err != nil:
+				errs[i] = err
+			case !snaps[i].Exists():
+				errs[i] = errDocumentMissing
+			default:
+				docs[i] = snaps[i]
+			}
+		}
+		return docs, errs
+	}
+
+	var g errgroup.Group
+	g.SetLimit(fetchConcurrency)
+	for i, ref := range refs {
+		g.Go(func() error {
+			doc, err := ref.Get(ctx)
+			switch {
+			case status.Code(err) == codes.NotFound:
+				errs[i] = errDocumentMissing
+			case err != nil:
+				errs[i] = err
+			default:
+				docs[i] = doc
+			}
+			return nil // per-ref errors never cancel the others
+		})
+	}
+	g.Wait()
+	return docs, errs
+}
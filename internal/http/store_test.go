@@ -0,0 +1,171 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestUserFromDataCasing(t *testing.T) {
+	attrs := map[string]interface{}{"team": "core"}
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want model.User
+	}{
+		{
+			"current",
+			map[string]interface{}{"name": "Ada", "email": "ada@example.com", "plan": "pro", "attributes": attrs},
+			model.User{Name: "Ada", Email: "ada@example.com", Plan: model.PlanPro, Attributes: attrs},
+		},
+		{
+			"legacy",
+			map[string]interface{}{"Name": "Ada", "Email": "ada@example.com", "Attributes": attrs},
+			model.User{Name: "Ada", Email: "ada@example.com", Plan: model.PlanFree, Attributes: attrs},
+		},
+		{
+			"mixed",
+			map[string]interface{}{"Name": "Ada", "email": "ada@example.com"},
+			model.User{Name: "Ada", Email: "ada@example.com", Plan: model.PlanFree},
+		},
+		{
+			// Written by current code after a legacy document was read
+			"both, current wins",
+			map[string]interface{}{"Name": "Old", "name": "New", "Email": "old@example.com", "email": "new@example.com"},
+			model.User{Name: "New", Email: "new@example.com", Plan: model.PlanFree},
+		},
+		{
+			"neither",
+			map[string]interface{}{"nameLower": "ada"},
+			model.User{Plan: model.PlanFree},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, malformed := decodeUserData(tt.data)
+			if !reflect.DeepEqual(user, tt.want) || len(malformed) != 0 {
+				t.Errorf("decodeUserData = %+v, malformed %v, want %+v", user, malformed, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeUserDataReportsWrongTypes(t *testing.T) {
+	user, malformed := decodeUserData(map[string]interface{}{"Name": 42, "email": "ada@example.com", "plan": "platinum"})
+	if want := []string{"Name", "plan"}; !reflect.DeepEqual(malformed, want) {
+		t.Errorf("malformed = %v, want %v", malformed, want)
+	}
+	if user.Name != "" || user.Email != "ada@example.com" {
+		t.Errorf("user = %+v, want only the email", user)
+	}
+}
+
+func TestNormalizeUserData(t *testing.T) {
+	tests := []struct {
+		name        string
+		data, want  map[string]interface{}
+		wantChanged bool
+	}{
+		{
+			"legacy",
+			map[string]interface{}{"Name": "Ada", "Email": "ada@example.com", "referralCount": int64(2)},
+			map[string]interface{}{"name": "Ada", "email": "ada@example.com", "referralCount": int64(2)},
+			true,
+		},
+		{
+			"mixed",
+			map[string]interface{}{"Name": "Ada", "email": "ada@example.com"},
+			map[string]interface{}{"name": "Ada", "email": "ada@example.com"},
+			true,
+		},
+		{
+			"both, current wins",
+			map[string]interface{}{"Name": "Old", "name": "New"},
+			map[string]interface{}{"name": "New"},
+			true,
+		},
+		{
+			"current",
+			map[string]interface{}{"name": "Ada", "email": "ada@example.com"},
+			map[string]interface{}{"name": "Ada", "email": "ada@example.com"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := normalizeUserData(tt.data)
+			if changed != tt.wantChanged || !reflect.DeepEqual(tt.data, tt.want) {
+				t.Errorf("normalizeUserData = %v, %v, want %v, %v", changed, tt.data, tt.wantChanged, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserToDataUsesStoredNames(t *testing.T) {
+	data := userToData(model.User{Name: "Ada", Email: "ada@example.com", AvatarURL: "/users/a/avatar.svg"})
+	for _, legacy := range []string{"Name", "Email", "AvatarURL", "avatarUrl"} {
+		if _, ok := data[legacy]; ok {
+			t.Errorf("stored %s: %v", legacy, data)
+		}
+	}
+	if data["name"] != "Ada" || data["email"] != "ada@example.com" {
+		t.Errorf("userToData = %v", data)
+	}
+	if _, ok := data["plan"]; ok {
+		t.Errorf("stored an empty plan: %v", data)
+	}
+	if got := userFromData(data); got.Name != "Ada" || got.Email != "ada@example.com" {
+		t.Errorf("userFromData(userToData) = %+v", got)
+	}
+}
+
+func TestMergeUserDataDeletesLegacyNames(t *testing.T) {
+	merged := mergeUserData(map[string]interface{}{"name": "Ada"})
+	if merged["name"] != "Ada" {
+		t.Errorf("name = %v", merged["name"])
+	}
+	for _, field := range []string{"Name", "Email", "email"} {
+		if _, ok := merged[field]; !ok {
+			t.Errorf("merge leaves %s alone: %v", field, merged)
+		}
+	}
+}
+
+func TestMigrateUserFieldCase(t *testing.T) {
+	s, ctx := useEmulator(t)
+	legacy, _, err := s.users.Users().Add(ctx, map[string]interface{}{"Name": "Ada", "Email": "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed, _, err := s.users.Users().Add(ctx, map[string]interface{}{"Name": "Grace", "email": "grace@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := mustCreateUser(t, s, ctx, model.User{Name: "Alan", Email: "alan@example.com"})
+
+	if n, err := s.migrateUserFieldCase(ctx, true); err != nil || n != 2 {
+		t.Fatalf("dry run: %d, %v, want 2 documents", n, err)
+	}
+	if n, err := s.migrateUserFieldCase(ctx, false); err != nil || n != 2 {
+		t.Fatalf("migration: %d, %v, want 2 documents", n, err)
+	}
+	for id, want := range map[string]map[string]interface{}{
+		legacy.ID: {"name": "Ada", "email": "ada@example.com"},
+		mixed.ID:  {"name": "Grace", "email": "grace@example.com"},
+	} {
+		doc, err := s.users.Users().Doc(id).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.Data(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", id, got, want)
+		}
+	}
+	if doc, err := s.users.Users().Doc(current).Get(ctx); err != nil || doc.Data()["name"] != "Alan" {
+		t.Errorf("current user %s: %v, %v", current, doc.Data(), err)
+	}
+	if n, err := s.migrateUserFieldCase(ctx, false); err != nil || n != 0 {
+		t.Errorf("second run: %d, %v, want nothing left to rewrite", n, err)
+	}
+}
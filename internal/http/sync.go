@@ -0,0 +1,282 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// GET /users/sync lets a downstream cache pull what changed since its
+// last sync instead of the whole collection. Every write to a user stores
+// updatedAt (stampUpdatedAt for documents, withUpdatedAt for field
+// updates), and deleting or archiving one leaves a tombstone in
+// deleted_users/{id}; merged duplicates are soft-deleted in place and
+// come back as deletions from the users stream. Tombstones are pruned
+// after DELETED_USER_RETENTION (DELETED_USER_PRUNE_SCHEDULE), so a sync
+// older than that answers 410 sync_expired and the cache must start over.
+//
+// updatedAt is stamped from the writer's clock before its transaction
+// commits, so a write can land behind a position a concurrent sync has
+// already passed, and replicas' clocks differ. The sync token therefore
+// rewinds to SYNC_OVERLAP before the sync began: changes inside that
+// window are sent again next time. Duplicates are expected; applying the
+// changes in order is idempotent.
+var (
+	syncOverlap          = config.Duration("SYNC_OVERLAP", 5*time.Second)
+	deletedUserRetention = config.Duration("DELETED_USER_RETENTION", 30*24*time.Hour)
+	syncTokens           = cursor.New(cursorKey, deletedUserRetention)
+)
+
+func (s *Server) deletedUsersCollection() *firestore.CollectionRef {
+	return s.client.Collection("deleted_users")
+}
+
+// deletedUser is the tombstone of a user that left the users collection
+type deletedUser struct {
+	DeletedAt time.Time `firestore:"deletedAt"`
+	Reason    string    `firestore:"reason"` // deleted or archived
+}
+
+// Leave a tombstone for a user removed in tx
+func (s *Server) recordTombstoneTx(tx *firestore.Transaction, id, reason string) error {
+	return tx.Set(s.deletedUsersCollection().Doc(id), deletedUser{DeletedAt: time.Now().UTC(), Reason: reason})
+}
+
+// Set updatedAt on user data about to be written whole
+func stampUpdatedAt(data map[string]interface{}) {
+	data["updatedAt"] = time.Now().UTC()
+}
+
+// updates plus one of updatedAt; none stays none
+func withUpdatedAt(updates []firestore.Update) []firestore.Update {
+	if len(updates) == 0 {
+		return updates
+	}
+	return append(updates, firestore.Update{Path: "updatedAt", Value: time.Now().UTC()})
+}
+
+var errInvalidSyncPosition = errors.New("invalid sync position")
+
+// One entry of the merged users and tombstone streams
+type syncEntry struct {
+	at   time.Time
+	id   string
+	doc  *firestore.DocumentSnapshot
+	tomb bool
+}
+
+// Changes since ?since= (an RFC 3339 timestamp or a previous syncToken;
+// omitted for everything), oldest first. Pages of up to ?pageSize=
+// (default 100, at most 500) continue with ?pageToken=; the last page
+// carries the syncToken for next time.
+func (s *Server) syncUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	since, ok := syncSince(w, r)
+	if !ok {
+		return
+	}
+	filters := map[string]string{"since": since.Format(time.RFC3339Nano)}
+	pageSize := pageSizeParam(r, 100, 500)
+
+	// Values: when the sync started, then the last user and tombstone
+	// sent (time and ID, empty before the first)
+	cur, err := pageCursor(r, filters)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+	pos := []string{time.Now().UTC().Format(time.RFC3339Nano), "", "", "", ""}
+	if cur != nil {
+		if len(cur.Values) != len(pos) {
+			writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+			return
+		}
+		pos = cur.Values
+	}
+	started, err := time.Parse(time.RFC3339Nano, pos[0])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+		return
+	}
+
+	stream := func(col *firestore.CollectionRef, field, at, id string) ([]*firestore.DocumentSnapshot, error) {
+		q := col.OrderBy(field, firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+		if !since.IsZero() {
+			q = q.Where(field, ">", since)
+		}
+		if at != "" {
+			t, err := time.Parse(time.RFC3339Nano, at)
+			if err != nil {
+				return nil, errInvalidSyncPosition
+			}
+			q = q.StartAfter(t, id)
+		}
+		return q.Documents(ctx).GetAll()
+	}
+	users, err := stream(s.users.Users(), "updatedAt", pos[1], pos[2])
+	var tombs []*firestore.DocumentSnapshot
+	if err == nil {
+		tombs, err = stream(s.deletedUsersCollection(), "deletedAt", pos[3], pos[4])
+	}
+	if err == errInvalidSyncPosition {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error syncing users")
+		return
+	}
+
+	// Each stream is fetched up to pageSize, so the first pageSize
+	// entries of the merge never run past an unfetched one
+	var entries []syncEntry
+	for _, doc := range users {
+		at, _ := doc.Data()["updatedAt"].(time.Time)
+		entries = append(entries, syncEntry{at: at, id: doc.Ref.ID, doc: doc})
+	}
+	for _, doc := range tombs {
+		var t deletedUser
+		doc.DataTo(&t)
+		entries = append(entries, syncEntry{at: t.DeletedAt, id: doc.Ref.ID, doc: doc, tomb: true})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].at.Equal(entries[j].at) {
+			return entries[i].at.Before(entries[j].at)
+		}
+		if entries[i].id != entries[j].id {
+			return entries[i].id < entries[j].id
+		}
+		return entries[i].tomb && !entries[j].tomb
+	})
+	more := len(entries) > pageSize || len(users) == pageSize || len(tombs) == pageSize
+	entries = entries[:min(len(entries), pageSize)]
+
+	resp := api.UserSyncResponse{Changes: []api.UserChange{}}
+	for _, e := range entries {
+		change := api.UserChange{ID: e.id, At: Timestamp{Time: e.at}}
+		switch {
+		case e.tomb:
+			var t deletedUser
+			e.doc.DataTo(&t)
+			change.Deleted, change.Reason = true, t.Reason
+		case isSoftDeleted(e.doc):
+			change.Deleted, change.Reason = true, "merged"
+		default:
+			var u UserResponse
+			if user, malformed := decodeUserDoc(e.doc); malformed == nil {
+				u = newUserResponse(r, e.doc, user)
+			} else {
+				u = newMalformedUserResponse(r, e.doc, malformed)
+			}
+			change.User = &u
+		}
+		resp.Changes = append(resp.Changes, change)
+
+		p := e.at.UTC().Format(time.RFC3339Nano)
+		if e.tomb {
+			pos[3], pos[4] = p, e.id
+		} else {
+			pos[1], pos[2] = p, e.id
+		}
+	}
+	if cur == nil {
+		resp.Warning = s.sortFieldWarning(requestContext(r), "updatedAt")
+	}
+
+	if more {
+		resp.NextPageToken = pageCursors.Encode(cursor.Cursor{Values: pos, FilterHash: cursor.FilterHash(r.URL.Path, filters)})
+		resp.Links = pageLinks(r, resp.NextPageToken, "")
+	} else {
+		resp.SyncToken = syncToken(r, syncWatermark(since, started, pos))
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// Where the next sync starts: the newest change sent, but no later than
+// SYNC_OVERLAP before this sync began, as writes stamped since then may
+// not have been visible to it. Capping at the window rather than always
+// subtracting it from the newest change keeps a quiet collection from
+// resending its last changes forever.
+func syncWatermark(since, started time.Time, pos []string) time.Time {
+	var last time.Time
+	for _, p := range []string{pos[1], pos[3]} {
+		if t, err := time.Parse(time.RFC3339Nano, p); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	if last.IsZero() {
+		return since
+	}
+	if limit := started.Add(-syncOverlap); last.After(limit) {
+		last = limit
+	}
+	if last.Before(since) {
+		return since
+	}
+	return last
+}
+
+func syncToken(r *http.Request, watermark time.Time) string {
+	return syncTokens.Encode(cursor.Cursor{
+		Values:     []string{watermark.UTC().Format(time.RFC3339Nano)},
+		FilterHash: cursor.FilterHash(r.URL.Path, nil),
+	})
+}
+
+// Parse ?since=, writing the error response when it's neither a timestamp
+// nor a sync token, or older than the tombstones kept
+func syncSince(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	since, err := parseTimestamp(raw)
+	if err != nil {
+		cur, decodeErr := syncTokens.Decode(raw, cursor.FilterHash(r.URL.Path, nil))
+		if decodeErr == cursor.ErrExpired {
+			writeError(w, r, http.StatusGone, "sync_expired", "syncToken has expired; sync again without since")
+			return time.Time{}, false
+		}
+		if decodeErr == nil && len(cur.Values) == 1 {
+			since, err = time.Parse(time.RFC3339Nano, cur.Values[0])
+		}
+		if decodeErr != nil || err != nil {
+			msg := "since must be an RFC 3339 timestamp or a syncToken"
+			writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", msg, FieldError{Field: "since", Message: msg})
+			return time.Time{}, false
+		}
+	}
+	if !since.IsZero() && since.Before(time.Now().Add(-deletedUserRetention)) {
+		writeError(w, r, http.StatusGone, "sync_expired", "since is older than DELETED_USER_RETENTION; sync again without since")
+		return time.Time{}, false
+	}
+	return since.UTC(), true
+}
+
+// Delete tombstones older than DELETED_USER_RETENTION; scheduled by
+// DELETED_USER_PRUNE_SCHEDULE (see scheduler.go)
+func (s *Server) runPruneDeletedUsersJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	if run.dryRun() {
+		return map[string]interface{}{"dryRun": true}, nil
+	}
+	n, err := s.pruneDeletedUsers(ctx)
+	if n > 0 {
+		log.Printf("🧹 Pruned %d deleted user tombstones", n)
+	}
+	return map[string]interface{}{"deleted": n}, err
+}
+
+// A retention policy of its own, tied to the sync token lifetime
+func (s *Server) pruneDeletedUsers(ctx context.Context) (int, error) {
+	p := retentionPolicy{collection: "deleted_users", retentionTarget: retentionTargets["deleted_users"], maxAge: deletedUserRetention}
+	_, deleted, err := s.enforceRetention(ctx, p, false, nil)
+	return deleted, err
+}
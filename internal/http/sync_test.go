@@ -0,0 +1,150 @@
+package http
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestSyncWatermark(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return base.Add(d).Format(time.RFC3339Nano) }
+	saved := syncOverlap
+	syncOverlap = 5 * time.Second
+	t.Cleanup(func() { syncOverlap = saved })
+
+	tests := []struct {
+		name         string
+		since        time.Time
+		users, tombs string // newest sent of each, "" for none
+		want         time.Time
+	}{
+		{"nothing sent keeps since", base.Add(-time.Hour), "", "", base.Add(-time.Hour)},
+		{"newest change", time.Time{}, at(-time.Minute), at(-2 * time.Minute), base.Add(-time.Minute)},
+		{"newest tombstone", time.Time{}, at(-2 * time.Minute), at(-time.Minute), base.Add(-time.Minute)},
+		{"capped at the overlap", time.Time{}, at(-time.Second), "", base.Add(-5 * time.Second)},
+		{"never behind since", base.Add(-2 * time.Second), at(-time.Second), "", base.Add(-2 * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := []string{at(0), tt.users, "a", tt.tombs, "b"}
+			if got := syncWatermark(tt.since, base, pos); !got.Equal(tt.want) {
+				t.Errorf("syncWatermark = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Pull every change since token, page by page, into cache (names by ID),
+// and return the next sync token
+func syncInto(t *testing.T, s *Server, cache map[string]string, token string) string {
+	t.Helper()
+	pageToken := ""
+	for {
+		q := url.Values{"pageSize": {"2"}}
+		if token != "" {
+			q.Set("since", token)
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		rec := httptest.NewRecorder()
+		s.syncUsersHandler(rec, httptest.NewRequest(http.MethodGet, "/users/sync?"+q.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sync = %d %s", rec.Code, rec.Body)
+		}
+		var resp api.UserSyncResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range resp.Changes {
+			if c.Deleted {
+				delete(cache, c.ID)
+			} else {
+				cache[c.ID] = c.User.Name
+			}
+		}
+		if resp.NextPageToken == "" {
+			return resp.SyncToken
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// A cache syncing between rounds of writes ends each round matching the
+// collection. Changes inside SYNC_OVERLAP are sent again, which applying
+// them in order absorbs.
+func TestSyncInterleavedRounds(t *testing.T) {
+	s, ctx := useEmulator(t)
+	want := map[string]string{}
+	create := func(name string) string {
+		id := mustCreateUser(t, s, ctx, model.User{Name: name})
+		want[id] = name
+		return id
+	}
+	rename := func(id, name string) {
+		if _, err := s.modifyUser(ctx, id, "test", false, func(u model.User) (model.User, error) {
+			u.Name = name
+			return u, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want[id] = name
+	}
+
+	cache := map[string]string{}
+	var token string
+	rounds := []func(){
+		func() {
+			create("Ada")
+			create("Grace")
+			create("Edsger")
+		},
+		func() {
+			for id, name := range maps.Clone(want) {
+				switch name {
+				case "Ada":
+					rename(id, "Ada Lovelace")
+				case "Grace":
+					if err := s.deleteUser(ctx, id, "test", false); err != nil {
+						t.Fatal(err)
+					}
+					delete(want, id)
+				}
+			}
+			create("Barbara")
+		},
+		func() {}, // nothing changed
+		func() {
+			for id, name := range maps.Clone(want) {
+				switch name {
+				case "Barbara":
+					if err := s.archiveUser(ctx, id, "test", false); err != nil {
+						t.Fatal(err)
+					}
+					delete(want, id)
+				case "Ada Lovelace":
+					rename(id, "Countess Ada")
+				}
+			}
+			create("Grace")
+		},
+	}
+	for i, round := range rounds {
+		round()
+		token = syncInto(t, s, cache, token)
+		if token == "" {
+			t.Fatalf("round %d: no syncToken", i+1)
+		}
+		if !maps.Equal(cache, want) {
+			t.Fatalf("round %d: cache = %v, want %v", i+1, cache, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Adaptive write throttle for Firestore quota exhaustion. Writes are
+// unthrottled until a Firestore call fails with ResourceExhausted. The
+// throttle then admits writes from a token bucket refilling at
+// THROTTLE_INITIAL_RATE per second, halved on each further
+// ResourceExhausted (at most once per THROTTLE_COOLDOWN, down to
+// THROTTLE_MIN_RATE). Each cooldown without one grows it by
+// THROTTLE_RECOVERY (10%), and the throttle disengages once it is back
+// at the initial rate. Writes the bucket can't admit get a 429 with
+// Retry-After. Reads are never throttled, so whatever quota is left
+// goes to them first.
+var (
+	throttleInitialRate = config.Float("THROTTLE_INITIAL_RATE", 100)
+	throttleMinRate     = config.Float("THROTTLE_MIN_RATE", 1)
+	throttleCooldown    = config.Duration("THROTTLE_COOLDOWN", time.Second)
+	throttleRecovery    = config.Float("THROTTLE_RECOVERY", 0.1)
+	writeThrottle       = newAdaptiveThrottle(time.Now)
+)
+
+// adaptiveThrottle is the control loop and its token bucket. now is the
+// clock, replaceable to step it deterministically.
+type adaptiveThrottle struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	rate      float64 // writes per second; 0 when disengaged
+	tokens    float64
+	refilled  time.Time
+	adjusted  time.Time // last cut or increase
+	exhausted int64     // ResourceExhausted errors seen
+	rejected  int64     // writes turned away
+}
+
+func newAdaptiveThrottle(now func() time.Time) *adaptiveThrottle {
+	return &adaptiveThrottle{now: now}
+}
+
+// Feed the outcome of a Firestore call into the control loop
+func (t *adaptiveThrottle) observe(err error) {
+	exhausted := status.Code(err) == codes.ResourceExhausted
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if exhausted {
+		t.exhausted++
+	}
+	switch {
+	case exhausted && t.rate == 0:
+		t.rate, t.tokens, t.refilled, t.adjusted = throttleInitialRate, 0, now, now
+	case exhausted && now.Sub(t.adjusted) >= throttleCooldown:
+		t.refill(now)
+		t.rate = math.Max(t.rate/2, throttleMinRate)
+		t.tokens = math.Min(t.tokens, t.rate)
+		t.adjusted = now
+	case !exhausted && t.rate > 0 && now.Sub(t.adjusted) >= throttleCooldown:
+		t.refill(now)
+		if t.rate *= 1 + throttleRecovery; t.rate >= throttleInitialRate {
+			t.rate = 0
+		}
+		t.adjusted = now
+	}
+}
+
+// Admit one write, or say how long until the bucket has a token
+func (t *adaptiveThrottle) admit() (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rate == 0 {
+		return true, 0
+	}
+	t.refill(t.now())
+	if t.tokens >= 1 {
+		t.tokens--
+		return true, 0
+	}
+	t.rejected++
+	return false, time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+}
+
+// Add the tokens earned since the last refill; the bucket holds one
+// second's worth
+func (t *adaptiveThrottle) refill(now time.Time) {
+	t.tokens = math.Min(t.tokens+now.Sub(t.refilled).Seconds()*t.rate, math.Max(t.rate, 1))
+	t.refilled = now
+}
+
+// The throttle's state for /healthz and /debug/vars; rate is 0 when disengaged
+func (t *adaptiveThrottle) state() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]interface{}{
+		"engaged":           t.rate > 0,
+		"writesPerSecond":   t.rate,
+		"resourceExhausted": t.exhausted,
+		"rejected":          t.rejected,
+	}
+}
+
+// Prometheus series for the write throttle, for metricsHandler
+func writeThrottleMetrics(w io.Writer) {
+	s := writeThrottle.state()
+	engaged := 0
+	if s["engaged"].(bool) {
+		engaged = 1
+	}
+	fmt.Fprintln(w, "# HELP firestore_write_throttle_engaged Whether writes are throttled after ResourceExhausted.")
+	fmt.Fprintln(w, "# TYPE firestore_write_throttle_engaged gauge")
+	fmt.Fprintf(w, "firestore_write_throttle_engaged %d\n", engaged)
+	fmt.Fprintln(w, "# HELP firestore_write_throttle_rate Writes per second the throttle admits (0 when disengaged).")
+	fmt.Fprintln(w, "# TYPE firestore_write_throttle_rate gauge")
+	fmt.Fprintf(w, "firestore_write_throttle_rate %g\n", s["writesPerSecond"])
+	fmt.Fprintln(w, "# HELP firestore_resource_exhausted_total Firestore calls that failed with ResourceExhausted.")
+	fmt.Fprintln(w, "# TYPE firestore_resource_exhausted_total counter")
+	fmt.Fprintf(w, "firestore_resource_exhausted_total %d\n", s["resourceExhausted"])
+	fmt.Fprintln(w, "# HELP firestore_write_throttle_rejected_total Write requests turned away with 429 by the throttle.")
+	fmt.Fprintln(w, "# TYPE firestore_write_throttle_rejected_total counter")
+	fmt.Fprintf(w, "firestore_write_throttle_rejected_total %d\n", s["rejected"])
+}
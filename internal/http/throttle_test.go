@@ -0,0 +1,94 @@
+package http
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A throttle on a fake clock, with the tuning set for the test
+func testThrottle(t *testing.T, initial, min, recovery float64, cooldown time.Duration) (*adaptiveThrottle, *time.Time) {
+	savedInitial, savedMin, savedRecovery, savedCooldown := throttleInitialRate, throttleMinRate, throttleRecovery, throttleCooldown
+	throttleInitialRate, throttleMinRate, throttleRecovery, throttleCooldown = initial, min, recovery, cooldown
+	t.Cleanup(func() {
+		throttleInitialRate, throttleMinRate, throttleRecovery, throttleCooldown = savedInitial, savedMin, savedRecovery, savedCooldown
+	})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return newAdaptiveThrottle(func() time.Time { return now }), &now
+}
+
+// Step the control loop and check the rate after every observation
+func TestAdaptiveThrottleControlLoop(t *testing.T) {
+	th, now := testThrottle(t, 100, 20, 0.5, time.Second)
+	exhausted := status.Error(codes.ResourceExhausted, "quota exceeded")
+	unavailable := status.Error(codes.Unavailable, "try again")
+	steps := []struct {
+		advance time.Duration
+		err     error
+		want    float64
+	}{
+		{0, nil, 0},                              // success while disengaged
+		{0, exhausted, 100},                      // engage at the initial rate
+		{500 * time.Millisecond, exhausted, 100}, // within the cooldown
+		{500 * time.Millisecond, exhausted, 50},  // halved
+		{time.Second, exhausted, 25},             // halved
+		{time.Second, exhausted, 20},             // floored at the minimum
+		{time.Second, nil, 30},                   // recovering by half
+		{200 * time.Millisecond, nil, 30},        // within the cooldown
+		{800 * time.Millisecond, nil, 45},        // recovering
+		{time.Second, unavailable, 67.5},         // other errors count as success
+		{time.Second, exhausted, 33.75},          // cut again
+		{time.Second, nil, 50.625},
+		{time.Second, nil, 75.9375},
+		{time.Second, nil, 0}, // back past the initial rate: disengaged
+		{time.Second, nil, 0},
+	}
+	for i, step := range steps {
+		*now = now.Add(step.advance)
+		th.observe(step.err)
+		if got := th.state()["writesPerSecond"].(float64); math.Abs(got-step.want) > 1e-9 {
+			t.Fatalf("step %d (+%v, %v): rate = %g, want %g", i, step.advance, step.err, got, step.want)
+		}
+	}
+	if got := th.state()["resourceExhausted"].(int64); got != 6 {
+		t.Errorf("resourceExhausted = %d, want 6", got)
+	}
+}
+
+// The bucket admits rate tokens a second, holds at most a second's worth,
+// and says how long until the next token
+func TestAdaptiveThrottleBucket(t *testing.T) {
+	th, now := testThrottle(t, 100, 1, 0.1, time.Second)
+	if ok, _ := th.admit(); !ok {
+		t.Fatal("disengaged throttle refused a write")
+	}
+	th.observe(status.Error(codes.ResourceExhausted, ""))
+	if ok, wait := th.admit(); ok || wait != 10*time.Millisecond {
+		t.Fatalf("empty bucket: admit = %v, %v, want false, 10ms", ok, wait)
+	}
+
+	*now = now.Add(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if ok, _ := th.admit(); !ok {
+			t.Fatalf("write %d of 5 tokens refused", i+1)
+		}
+	}
+	if ok, _ := th.admit(); ok {
+		t.Fatal("admitted a 6th write from 5 tokens")
+	}
+
+	*now = now.Add(10 * time.Second)
+	admitted := 0
+	for ok, _ := th.admit(); ok; ok, _ = th.admit() {
+		admitted++
+	}
+	if admitted != 100 {
+		t.Errorf("after 10s idle admitted %d writes, want the bucket's 100", admitted)
+	}
+	if got := th.state()["rejected"].(int64); got != 3 {
+		t.Errorf("rejected = %d, want 3", got)
+	}
+}
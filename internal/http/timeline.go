@@ -0,0 +1,255 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"google.golang.org/api/iterator"
+)
+
+// TimelineEvent is one entry in a user's activity timeline, whichever
+// source it came from. ID is the source record's document ID.
+type TimelineEvent struct {
+	Type    string    `json:"type"`
+	ID      string    `json:"id"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor,omitempty"`
+	Summary string    `json:"summary"`
+	Link    string    `json:"link,omitempty"`
+}
+
+// A timelineSource queries one kind of record for a user, newest first
+// with the document ID breaking ties, and turns each into an event
+type timelineSource struct {
+	query func(s *Server, userID string) firestore.Query
+	field string // the timestamp the query orders by
+	event func(r *http.Request, userID string, doc *firestore.DocumentSnapshot) TimelineEvent
+}
+
+// Timeline sources by ?types= name. Status changes show up as history
+// versions; there are no login records in this service yet.
+var timelineSources = map[string]timelineSource{
+	"audit": {
+		query: func(s *Server, userID string) firestore.Query {
+			return s.client.Collection("audit_logs").Where("targetId", "==", userID)
+		},
+		field: "at",
+		event: func(r *http.Request, userID string, doc *firestore.DocumentSnapshot) TimelineEvent {
+			var entry AuditEntry
+			doc.DataTo(&entry)
+			return TimelineEvent{At: entry.At, Actor: entry.Actor, Summary: entry.Action}
+		},
+	},
+	"history": {
+		query: func(s *Server, userID string) firestore.Query {
+			return historyCollection(s.users.Users().Doc(userID)).Query
+		},
+		field: "changedAt",
+		event: func(r *http.Request, userID string, doc *firestore.DocumentSnapshot) TimelineEvent {
+			var entry HistoryEntry
+			doc.DataTo(&entry)
+			userPath := "/users/" + url.PathEscape(userID)
+			link := absoluteURL(r, userPath+"/history", nil)
+			if entry.Version > 1 {
+				link = absoluteURL(r, userPath+"/diff", url.Values{
+					"from": {strconv.FormatInt(entry.Version-1, 10)},
+					"to":   {strconv.FormatInt(entry.Version, 10)},
+				})
+			}
+			return TimelineEvent{
+				At:      entry.ChangedAt,
+				Actor:   entry.Actor,
+				Summary: "Version " + strconv.FormatInt(entry.Version, 10) + ": " + entry.Op,
+				Link:    link,
+			}
+		},
+	},
+	"notification": {
+		query: func(s *Server, userID string) firestore.Query {
+			return s.notificationsCollection(userID).Query
+		},
+		field: "createdAt",
+		event: func(r *http.Request, userID string, doc *firestore.DocumentSnapshot) TimelineEvent {
+			var n Notification
+			doc.DataTo(&n)
+			return TimelineEvent{
+				At:      n.CreatedAt,
+				Summary: "Notification sent: " + n.Title,
+				Link:    absoluteURL(r, "/users/"+url.PathEscape(userID)+"/notifications", nil),
+			}
+		},
+	},
+}
+
+// Parse ?types=, defaulting to every source. Returned sorted, which is
+// also the tie-break order between sources.
+func timelineTypes(r *http.Request) ([]string, string) {
+	raw := r.URL.Query().Get("types")
+	if raw == "" {
+		types := make([]string, 0, len(timelineSources))
+		for t := range timelineSources {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return types, ""
+	}
+	seen := map[string]bool{}
+	types := []string{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if _, ok := timelineSources[t]; !ok {
+			return nil, t
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return types, ""
+}
+
+// timelineStream is one source's query being read during the merge
+type timelineStream struct {
+	source string
+	iter   *trackedIterator
+	head   *firestore.DocumentSnapshot
+	at     time.Time
+}
+
+func (s *timelineStream) advance(field string) error {
+	doc, err := s.iter.Next()
+	if err == iterator.Done {
+		s.head = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.head = doc
+	s.at, _ = doc.Data()[field].(time.Time)
+	return nil
+}
+
+// Whether s's head comes before o's: newer first, then by source name,
+// then by document ID descending as each query orders them
+func (s *timelineStream) before(o *timelineStream) bool {
+	if !s.at.Equal(o.at) {
+		return s.at.After(o.at)
+	}
+	if s.source != o.source {
+		return s.source < o.source
+	}
+	return s.head.Ref.ID > o.head.Ref.ID
+}
+
+// Open each source's query positioned just after the cursor. The cursor
+// is the last event returned: its time, source and document ID.
+func (s *Server) openTimelineStreams(ctx context.Context, userID string, types []string, cur *cursor.Cursor, limit int) []*timelineStream {
+	streams := []*timelineStream{}
+	for _, t := range types {
+		src := timelineSources[t]
+		query := src.query(s, userID).OrderBy(src.field, firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(limit)
+		if cur != nil {
+			at, _ := time.Parse(time.RFC3339Nano, cur.Values[0])
+			switch source := cur.Values[1]; {
+			case t == source:
+				query = query.StartAfter(at, cur.LastID)
+			case t < source:
+				// Events at the cursor's time from earlier sources were all returned
+				query = query.StartAfter(at)
+			default:
+				query = query.StartAt(at)
+			}
+		}
+		streams = append(streams, &timelineStream{source: t, iter: trackIterator("timeline", query.Documents(ctx))})
+	}
+	return streams
+}
+
+// A user's activity across audit entries, history versions and
+// notifications, newest first (GET /users/{id}/timeline?types=&pageSize=&pageToken=).
+// Each source is queried for at most one page and the results merged as
+// they stream in, so a page costs at most pageSize reads per source.
+// Pages only go forward.
+func (s *Server) timelineHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	pageSize := pageSizeParam(r, 20, 100)
+	types, unknown := timelineTypes(r)
+	if unknown != "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "Unknown timeline type: "+unknown)
+		return
+	}
+	filters := map[string]string{"types": strings.Join(types, ",")}
+	cur, err := pageCursor(r, filters)
+	if err == nil && cur != nil {
+		if len(cur.Values) != 2 || cur.LastID == "" || cur.Backward {
+			err = cursor.ErrInvalid
+		} else if _, perr := time.Parse(time.RFC3339Nano, cur.Values[0]); perr != nil {
+			err = cursor.ErrInvalid
+		}
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+
+	ctx := requestContext(r)
+	streams := s.openTimelineStreams(ctx, userID, types, cur, pageSize)
+	defer func() {
+		for _, stream := range streams {
+			stream.iter.Stop()
+		}
+	}()
+	for _, stream := range streams {
+		if err := stream.advance(timelineSources[stream.source].field); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading timeline")
+			return
+		}
+	}
+
+	events := []TimelineEvent{}
+	for len(events) < pageSize {
+		var next *timelineStream
+		for _, stream := range streams {
+			if stream.head != nil && (next == nil || stream.before(next)) {
+				next = stream
+			}
+		}
+		if next == nil {
+			break
+		}
+		event := timelineSources[next.source].event(r, userID, next.head)
+		event.Type, event.ID = next.source, next.head.Ref.ID
+		events = append(events, event)
+		if err := next.advance(timelineSources[next.source].field); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading timeline")
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"events": events,
+	}
+	next := ""
+	if len(events) == pageSize {
+		last := events[len(events)-1]
+		next = pageCursors.Encode(cursor.Cursor{
+			LastID:     last.ID,
+			Values:     []string{last.At.UTC().Format(time.RFC3339Nano), last.Type},
+			FilterHash: cursor.FilterHash(r.URL.Path, filters),
+		})
+		response["nextPageToken"] = next
+	}
+	if links := pageLinks(r, next, ""); links != nil {
+		response["links"] = links
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
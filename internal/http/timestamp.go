@@ -0,0 +1,81 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // ?tz= works without zoneinfo on the host
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+)
+
+// Timestamps travel as RFC 3339 strings and are stored as Firestore
+// timestamps. Responses are in UTC unless ?tz= names an IANA zone (e.g.
+// Europe/Berlin), which converts the timestamps in the response for
+// display only.
+
+// Timestamp is api.Timestamp: RFC 3339 in, UTC out
+type Timestamp = api.Timestamp
+
+// timestampError is a request timestamp that isn't RFC 3339
+type timestampError = api.TimestampError
+
+// Parse an RFC 3339 timestamp (see api.ParseTimestamp)
+func parseTimestamp(s string) (time.Time, error) {
+	return api.ParseTimestamp(s)
+}
+
+// 422 for a request timestamp field that isn't RFC 3339
+func invalidTimestamp(w http.ResponseWriter, r *http.Request, field string) {
+	msg := field + " must be an RFC 3339 timestamp, e.g. 2006-01-02T15:04:05Z"
+	writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", msg, FieldError{Field: field, Message: msg})
+}
+
+// The zone ?tz= asks response timestamps to be shown in, nil for UTC.
+// timezoneMiddleware has already rejected unknown zones.
+func displayLocation(r *http.Request) *time.Location {
+	name := r.URL.Query().Get("tz")
+	if name == "" || name == "UTC" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+func timezoneMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := r.URL.Query().Get("tz"); name != "" {
+			if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+				writeError(w, r, http.StatusBadRequest, "invalid_argument", "Unknown time zone: "+name)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Convert the timestamp values in a JSON value to loc. Only members named
+// like timestamps ("at", or ending in "At") are touched, so free-form
+// strings that happen to parse are left alone.
+func convertTimestamps(v interface{}, loc *time.Location) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if s, ok := child.(string); ok && (k == "at" || strings.HasSuffix(k, "At")) {
+				if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					t[k] = parsed.In(loc).Format(time.RFC3339Nano)
+				}
+				continue
+			}
+			t[k] = convertTimestamps(child, loc)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = convertTimestamps(child, loc)
+		}
+	}
+	return v
+}
@@ -0,0 +1,238 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Requests are traced under the ID from traceparent (W3C) or, failing
+// that, X-Cloud-Trace-Context (set by Google Cloud Load Balancing), or a
+// locally generated one. The trace goes on request log lines and on the
+// Firestore calls made for the request, so their spans join it.
+//
+// LOG_FORMAT=json writes log lines as the JSON Cloud Logging parses, with
+// logging.googleapis.com/trace as projects/<GOOGLE_CLOUD_PROJECT>/traces/<id>
+// (the credentials' project when unset).
+var (
+	logFormat    = config.String("LOG_FORMAT", "text")
+	traceProject = sync.OnceValue(func() string {
+		if p := config.String("GOOGLE_CLOUD_PROJECT", ""); p != "" {
+			return p
+		}
+		return readCredentials().ProjectID
+	})
+)
+
+type traceKey struct{}
+
+// traceContext is a request's trace: a 32 hex digit trace ID and the 16
+// hex digit ID of the span that called us
+type traceContext struct {
+	traceID string
+	spanID  string
+	sampled bool
+}
+
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if !ok {
+			tc, ok = parseCloudTraceContext(r.Header.Get("X-Cloud-Trace-Context"))
+		}
+		if !ok {
+			tc = traceContext{traceID: randomHex(16), spanID: randomHex(8)}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceKey{}, tc)))
+	})
+}
+
+// traceparent: 00-<trace id>-<parent span id>-<flags>
+func parseTraceparent(h string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return traceContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || !validTraceHex(parts[1], 32) || !validTraceHex(parts[2], 16) {
+		return traceContext{}, false
+	}
+	return traceContext{traceID: parts[1], spanID: parts[2], sampled: flags&1 == 1}, true
+}
+
+// X-Cloud-Trace-Context: <trace id>/<decimal span id>;o=<0|1>
+func parseCloudTraceContext(h string) (traceContext, bool) {
+	h, options, _ := strings.Cut(strings.TrimSpace(h), ";")
+	traceID, span, _ := strings.Cut(h, "/")
+	traceID = strings.ToLower(traceID)
+	if !validTraceHex(traceID, 32) {
+		return traceContext{}, false
+	}
+	tc := traceContext{traceID: traceID, sampled: options == "o=1"}
+	if n, err := strconv.ParseUint(span, 10, 64); err == nil && n != 0 {
+		tc.spanID = fmt.Sprintf("%016x", n)
+	} else {
+		tc.spanID = randomHex(8)
+	}
+	return tc, true
+}
+
+// Exactly n lowercase hex digits, not all zero
+func validTraceHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func traceFromContext(ctx context.Context) (traceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(traceContext)
+	return tc, ok
+}
+
+// Send the request's trace with Firestore calls
+func traceOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(withTraceMetadata(ctx), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(withTraceMetadata(ctx), desc, cc, method, opts...)
+		}),
+	}
+}
+
+func withTraceMetadata(ctx context.Context) context.Context {
+	tc, ok := traceFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	sampled, flags := 0, "00"
+	if tc.sampled {
+		sampled, flags = 1, "01"
+	}
+	span, _ := strconv.ParseUint(tc.spanID, 16, 64)
+	return metadata.AppendToOutgoingContext(ctx,
+		"traceparent", "00-"+tc.traceID+"-"+tc.spanID+"-"+flags,
+		"x-cloud-trace-context", fmt.Sprintf("%s/%d;o=%d", tc.traceID, span, sampled))
+}
+
+// Switch the standard logger to JSON lines under LOG_FORMAT=json
+func InitLogging() {
+	if logFormat != "json" {
+		return
+	}
+	log.SetFlags(0)
+	log.SetOutput(structuredLogWriter{})
+}
+
+// structuredLogWriter turns each line from the standard logger into a
+// Cloud Logging entry
+type structuredLogWriter struct{}
+
+func (structuredLogWriter) Write(p []byte) (int, error) {
+	writeLogEntry(logEntry{Message: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+// logEntry is a line in Cloud Logging's structured format
+type logEntry struct {
+	Severity     string    `json:"severity"`
+	Message      string    `json:"message"`
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"requestId,omitempty"`
+	Trace        string    `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID       string    `json:"logging.googleapis.com/spanId,omitempty"`
+	TraceSampled bool      `json:"logging.googleapis.com/trace_sampled,omitempty"`
+
+	// Access log entries only
+	HTTPRequest  *logHTTPRequest `json:"httpRequest,omitempty"`
+	SampleRate   float64         `json:"sampleRate,omitempty"`
+	SampleReason string          `json:"sampleReason,omitempty"`
+}
+
+var (
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr // for JSON log lines
+)
+
+func writeLogEntry(e logEntry) {
+	e.Time = time.Now().UTC()
+	if e.Severity == "" {
+		e.Severity = logSeverity(e.Message)
+	}
+	line, _ := json.Marshal(e)
+	logMu.Lock()
+	logOutput.Write(append(line, '\n'))
+	logMu.Unlock()
+}
+
+// Severity from the emoji our log lines start with
+func logSeverity(message string) string {
+	switch {
+	case strings.HasPrefix(message, "❌"), strings.HasPrefix(message, "🚨"):
+		return "ERROR"
+	case strings.HasPrefix(message, "⚠️"):
+		return "WARNING"
+	}
+	return "INFO"
+}
+
+// Log for the request ctx belongs to, with its trace and request ID
+func logCtx(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if remaining, ok := budgetRemaining(ctx); ok {
+		message += " budget=" + remaining.Round(time.Millisecond).String()
+	}
+	tc, traced := traceFromContext(ctx)
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	if logFormat != "json" {
+		if traced {
+			message += " trace=" + tc.traceID
+		}
+		log.Print(message)
+		return
+	}
+	e := logEntry{Message: message, RequestID: requestID}
+	if traced {
+		if e.Trace = cloudTraceName(tc.traceID); e.Trace != "" {
+			e.SpanID, e.TraceSampled = tc.spanID, tc.sampled
+		}
+	}
+	writeLogEntry(e)
+}
+
+// The trace's resource name, "" when the project isn't known (Cloud
+// Logging drops traces it can't parse, so a bare ID is no better)
+func cloudTraceName(traceID string) string {
+	project := traceProject()
+	if project == "" {
+		return ""
+	}
+	return "projects/" + project + "/traces/" + traceID
+}
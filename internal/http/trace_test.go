@@ -0,0 +1,157 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Log JSON lines to a buffer under project, "" for none
+func captureJSONLogs(t *testing.T, project string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	savedFormat, savedOutput, savedProject := logFormat, logOutput, traceProject
+	logFormat, logOutput, traceProject = "json", &buf, func() string { return project }
+	t.Cleanup(func() { logFormat, logOutput, traceProject = savedFormat, savedOutput, savedProject })
+	return &buf
+}
+
+// The trace context traceMiddleware gives a request with headers
+func tracedContext(t *testing.T, headers map[string]string) context.Context {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/listUsers", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	var ctx context.Context
+	traceMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { ctx = r.Context() })).ServeHTTP(httptest.NewRecorder(), r)
+	return context.WithValue(ctx, requestIDKey{}, "req-1")
+}
+
+// What Cloud Logging accepts; anything else it drops without a word
+var (
+	cloudTraceField = regexp.MustCompile(`^projects/[a-z][-a-z0-9]{4,28}[a-z0-9]/traces/[0-9a-f]{32}$`)
+	cloudSpanField  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+func TestLogTraceFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		wantTrace string // "" for a generated one
+		wantSpan  string
+		sampled   bool
+	}{
+		{
+			name:      "traceparent",
+			headers:   map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:  "00f067aa0ba902b7",
+			sampled:   true,
+		},
+		{
+			name:      "traceparent not sampled",
+			headers:   map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+			wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:  "00f067aa0ba902b7",
+		},
+		{
+			name:      "cloud trace context, decimal span",
+			headers:   map[string]string{"X-Cloud-Trace-Context": "105445AA7843BC8BF206B12000100000/123;o=1"},
+			wantTrace: "105445aa7843bc8bf206b12000100000",
+			wantSpan:  "000000000000007b",
+			sampled:   true,
+		},
+		{
+			name: "traceparent wins",
+			headers: map[string]string{
+				"traceparent":           "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/123;o=1",
+			},
+			wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:  "00f067aa0ba902b7",
+			sampled:   true,
+		},
+		{
+			name: "malformed traceparent falls back to cloud trace context",
+			headers: map[string]string{
+				"traceparent":           "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+				"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/123;o=0",
+			},
+			wantTrace: "105445aa7843bc8bf206b12000100000",
+			wantSpan:  "000000000000007b",
+		},
+		{name: "no headers"},
+		{name: "all-zero trace ID", headers: map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+		{name: "version ff", headers: map[string]string{"traceparent": "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+		{name: "short cloud trace ID", headers: map[string]string{"X-Cloud-Trace-Context": "105445aa/123;o=1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureJSONLogs(t, "demo-project")
+			logCtx(tracedContext(t, tt.headers), "🛠️ listing")
+			var entry map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("log line %q: %v", logs, err)
+			}
+			trace, _ := entry["logging.googleapis.com/trace"].(string)
+			span, _ := entry["logging.googleapis.com/spanId"].(string)
+			if !cloudTraceField.MatchString(trace) || !cloudSpanField.MatchString(span) {
+				t.Fatalf("trace %q, span %q: not in Cloud Logging's format", trace, span)
+			}
+			if tt.wantTrace != "" && trace != "projects/demo-project/traces/"+tt.wantTrace {
+				t.Errorf("trace = %q, want projects/demo-project/traces/%s", trace, tt.wantTrace)
+			}
+			if tt.wantSpan != "" && span != tt.wantSpan {
+				t.Errorf("span = %q, want %s", span, tt.wantSpan)
+			}
+			// A bool, and left out rather than false
+			if sampled, ok := entry["logging.googleapis.com/trace_sampled"]; tt.sampled && sampled != true || !tt.sampled && ok {
+				t.Errorf("trace_sampled = %#v, want %v", sampled, tt.sampled)
+			}
+			if entry["requestId"] != "req-1" || entry["message"] != "🛠️ listing" || entry["severity"] != "INFO" {
+				t.Errorf("entry = %v", entry)
+			}
+		})
+	}
+}
+
+// Without a project the trace fields are left out: a bare trace ID would
+// be dropped anyway
+func TestLogTraceFieldsWithoutProject(t *testing.T) {
+	logs := captureJSONLogs(t, "")
+	logCtx(tracedContext(t, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}), "⚠️ slow")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"logging.googleapis.com/trace", "logging.googleapis.com/spanId", "logging.googleapis.com/trace_sampled"} {
+		if v, ok := entry[field]; ok {
+			t.Errorf("%s = %v, want it left out", field, v)
+		}
+	}
+	if entry["severity"] != "WARNING" {
+		t.Errorf("severity = %v, want WARNING", entry["severity"])
+	}
+}
+
+// Firestore calls carry the request's trace in both header formats
+func TestTraceMetadata(t *testing.T) {
+	ctx := tracedContext(t, map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/123;o=1"})
+	md, _ := metadata.FromOutgoingContext(withTraceMetadata(ctx))
+	if got := md.Get("traceparent"); len(got) != 1 || got[0] != "00-105445aa7843bc8bf206b12000100000-000000000000007b-01" {
+		t.Errorf("traceparent = %q", got)
+	}
+	if got := md.Get("x-cloud-trace-context"); len(got) != 1 || got[0] != "105445aa7843bc8bf206b12000100000/123;o=1" {
+		t.Errorf("x-cloud-trace-context = %q", got)
+	}
+	if md, ok := metadata.FromOutgoingContext(withTraceMetadata(context.Background())); ok {
+		t.Errorf("untraced metadata = %v, want none", md)
+	}
+}
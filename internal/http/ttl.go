@@ -0,0 +1,245 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	admin "cloud.google.com/go/firestore/apiv1/admin"
+	"cloud.google.com/go/firestore/apiv1/admin/adminpb"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// Expiring documents (request recordings by default) carry TTL_FIELD and
+// are hidden once it has passed. Deleting them is Firestore's job when a
+// TTL policy covers TTL_COLLECTION.TTL_FIELD: GET /admin/ttlPolicy reports
+// the policy through the Firestore Admin API and POST
+// /admin/ttlPolicy?confirm=true creates it, which needs
+// roles/datastore.indexAdmin. Until the policy is active the ttl_sweep job
+// (TTL_SWEEP_SCHEDULE) deletes expired documents itself; after that it
+// only checks the state, cached for TTL_POLICY_CHECK_TTL.
+var (
+	ttlCollection     = config.String("TTL_COLLECTION", "request_recordings")
+	ttlField          = config.String("TTL_FIELD", "expiresAt")
+	ttlPolicyCheckTTL = config.Duration("TTL_POLICY_CHECK_TTL", 10*time.Minute)
+	ttlSweepSchedule  = config.String("TTL_SWEEP_SCHEDULE", "*/30 * * * *")
+)
+
+// TTL policy states: Firestore's, lowercased, plus none
+const (
+	ttlPolicyNone        = "none"
+	ttlPolicyCreating    = "creating"
+	ttlPolicyActive      = "active"
+	ttlPolicyNeedsRepair = "needs_repair"
+)
+
+// errTTLAccess is an actionable Admin API permission problem
+type errTTLAccess struct{ reason string }
+
+func (e errTTLAccess) Error() string { return e.reason }
+
+var ttlPolicyCache struct {
+	mu      sync.Mutex
+	state   string
+	checked time.Time
+}
+
+// Admin API resource name of the TTL field
+func ttlFieldName() string {
+	project := readCredentials().ProjectID
+	if project == "" {
+		project = config.String("GOOGLE_CLOUD_PROJECT", "")
+	}
+	return fmt.Sprintf("projects/%s/databases/(default)/collectionGroups/%s/fields/%s", project, ttlCollection, ttlField)
+}
+
+func newFirestoreAdminClient(ctx context.Context) (*admin.FirestoreAdminClient, error) {
+	return admin.NewFirestoreAdminClient(ctx, option.WithCredentialsFile(credentialsFile))
+}
+
+// Turn Admin API refusals into errors that say what to grant or enable
+func ttlAdminError(err error) error {
+	s, _ := status.FromError(err)
+	switch {
+	case s.Code() == codes.PermissionDenied && strings.Contains(s.Message(), "SERVICE_DISABLED"):
+		return errTTLAccess{"The Firestore Admin API is not enabled for this project; enable firestore.googleapis.com in the Cloud console"}
+	case s.Code() == codes.PermissionDenied:
+		who := readCredentials().ClientEmail
+		if who == "" {
+			who = "the service account"
+		}
+		return errTTLAccess{"No permission to manage TTL policies; grant " + who + " roles/datastore.indexAdmin (datastore.indexes.get and datastore.indexes.update)"}
+	}
+	return err
+}
+
+// The TTL policy state of TTL_COLLECTION.TTL_FIELD, read from the Admin API
+func readTTLPolicy(ctx context.Context) (string, error) {
+	ac, err := newFirestoreAdminClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer ac.Close()
+	field, err := ac.GetField(ctx, &adminpb.GetFieldRequest{Name: ttlFieldName()})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", ttlAdminError(err)
+	}
+	state := ttlPolicyState(field) // a nil field has no policy
+	ttlPolicyCache.mu.Lock()
+	ttlPolicyCache.state, ttlPolicyCache.checked = state, time.Now()
+	ttlPolicyCache.mu.Unlock()
+	return state, nil
+}
+
+func ttlPolicyState(field *adminpb.Field) string {
+	if field.GetTtlConfig() == nil {
+		return ttlPolicyNone
+	}
+	switch field.GetTtlConfig().GetState() {
+	case adminpb.Field_TtlConfig_CREATING:
+		return ttlPolicyCreating
+	case adminpb.Field_TtlConfig_ACTIVE:
+		return ttlPolicyActive
+	case adminpb.Field_TtlConfig_NEEDS_REPAIR:
+		return ttlPolicyNeedsRepair
+	}
+	return ttlPolicyNone
+}
+
+// The policy state, re-read at most every TTL_POLICY_CHECK_TTL
+func cachedTTLPolicy(ctx context.Context) (string, error) {
+	ttlPolicyCache.mu.Lock()
+	state, fresh := ttlPolicyCache.state, time.Since(ttlPolicyCache.checked) < ttlPolicyCheckTTL
+	ttlPolicyCache.mu.Unlock()
+	if fresh {
+		return state, nil
+	}
+	return readTTLPolicy(ctx)
+}
+
+// Report the TTL policy (GET /admin/ttlPolicy) or create it (POST
+// /admin/ttlPolicy?confirm=true); creation answers 202 with the Admin API
+// operation while Firestore applies it to existing documents
+func ttlPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	report := func(code int, state string, extra map[string]interface{}) {
+		body := map[string]interface{}{"collection": ttlCollection, "field": ttlField, "state": state}
+		for k, v := range extra {
+			body[k] = v
+		}
+		writeJSON(w, r, code, body)
+	}
+	fail := func(err error) {
+		var accessErr errTTLAccess
+		if errors.As(err, &accessErr) {
+			writeError(w, r, http.StatusForbidden, "ttl_access", accessErr.Error())
+			return
+		}
+		logCtx(ctx, "⚠️ TTL policy request failed: %v", err)
+		writeError(w, r, http.StatusBadGateway, "internal", "Error reaching the Firestore Admin API")
+	}
+
+	state, err := readTTLPolicy(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if r.Method == http.MethodGet || state == ttlPolicyCreating || state == ttlPolicyActive {
+		report(http.StatusOK, state, nil)
+		return
+	}
+	if r.URL.Query().Get("confirm") != "true" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Creating a TTL policy requires confirm=true")
+		return
+	}
+
+	ac, err := newFirestoreAdminClient(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer ac.Close()
+	op, err := ac.UpdateField(ctx, &adminpb.UpdateFieldRequest{
+		Field:      &adminpb.Field{Name: ttlFieldName(), TtlConfig: &adminpb.Field_TtlConfig{}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"ttl_config"}},
+	})
+	if err != nil {
+		fail(ttlAdminError(err))
+		return
+	}
+	ttlPolicyCache.mu.Lock()
+	ttlPolicyCache.state, ttlPolicyCache.checked = ttlPolicyCreating, time.Now()
+	ttlPolicyCache.mu.Unlock()
+	log.Printf("⏳ Creating TTL policy on %s.%s (%s)", ttlCollection, ttlField, op.Name())
+	report(http.StatusAccepted, ttlPolicyCreating, map[string]interface{}{"operation": op.Name()})
+}
+
+// Delete documents whose TTL_FIELD has passed, unless an active TTL
+// policy already does; scheduled by TTL_SWEEP_SCHEDULE. A policy that
+// can't be read (no Admin API access) counts as absent.
+func (s *Server) runTTLSweepJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	state, err := cachedTTLPolicy(ctx)
+	if err != nil {
+		logCtx(ctx, "⚠️ Couldn't read the TTL policy, sweeping: %v", err)
+		state = ttlPolicyNone
+	}
+	if state == ttlPolicyActive {
+		return map[string]interface{}{"policy": state, "deleted": 0}, nil
+	}
+	if run.dryRun() {
+		return map[string]interface{}{"policy": state, "dryRun": true}, nil
+	}
+	iter := trackIterator("ttlSweep", s.client.Collection(ttlCollection).Where(ttlField, "<", time.Now()).Documents(ctx))
+	defer iter.Stop()
+	bw := s.client.BulkWriter(ctx)
+	deleted := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return map[string]interface{}{"policy": state, "deleted": deleted}, err
+		}
+		if _, err := bw.Delete(doc.Ref); err != nil {
+			bw.End()
+			return map[string]interface{}{"policy": state, "deleted": deleted}, err
+		}
+		deleted++
+	}
+	bw.End()
+	if deleted > 0 {
+		log.Printf("🧹 Swept %d expired %s documents", deleted, ttlCollection)
+	}
+	return map[string]interface{}{"policy": state, "deleted": deleted}, nil
+}
+
+// Self-test check of the policy: creating or active pass, needing repair
+// fails, and no policy or no Admin API access is a skip with the reason,
+// as the sweeper still deletes expired documents
+func checkTTLPolicy(ctx context.Context) (string, error) {
+	state, err := readTTLPolicy(ctx)
+	var accessErr errTTLAccess
+	switch {
+	case errors.As(err, &accessErr):
+		return accessErr.Error(), errSelftestSkipped
+	case err != nil:
+		return "", err
+	case state == ttlPolicyNeedsRepair:
+		return "", fmt.Errorf("TTL policy on %s.%s needs repair; delete and recreate it", ttlCollection, ttlField)
+	case state == ttlPolicyNone:
+		return "no policy, the ttl_sweep job deletes expired documents; POST /admin/ttlPolicy?confirm=true creates one", errSelftestSkipped
+	}
+	return state, nil
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errNothingToUndo    = errors.New("nothing to undo")
+	errChangedSinceEdit = errors.New("document changed since the last recorded change")
+)
+
+// Restore the state before the most recent change (POST /users/{id}:undo)
+//
+// Repeated undos walk back one version at a time: the undone entry is
+// marked undone and undo entries themselves are never undo targets. Only
+// the user fields are restored; bookkeeping written since the change
+// (referral counts, consents, protection, enrichment status) stays.
+func (s *Server) undoUserHandler(w http.ResponseWriter, r *http.Request) {
+	ref := s.users.Users().Doc(r.PathValue("id"))
+	actor := actorFromRequest(r, "anonymous")
+
+	ctx := requestContext(r)
+	dryRun := dryRunRequested(r)
+	var undone int64
+	var restored model.User
+	err := s.runTransaction(ctx, dryRun, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		exists := err == nil
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if exists && isSoftDeleted(doc) {
+			return store.ErrUserNotFound
+		}
+
+		iter := trackIterator("undo", tx.Documents(historyCollection(ref).OrderBy("version", firestore.Desc)))
+		defer iter.Stop()
+		var latest, target *firestore.DocumentSnapshot
+		var entry HistoryEntry
+		for {
+			h, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if latest == nil {
+				latest = h
+			}
+			var e HistoryEntry
+			h.DataTo(&e)
+			if e.Op == "undo" || e.Undone {
+				continue
+			}
+			target, entry = h, e
+			break
+		}
+		if target == nil || entry.Op == "create" {
+			if !exists {
+				return store.ErrUserNotFound
+			}
+			return errNothingToUndo
+		}
+
+		if exists && !unchangedSinceHistory(doc, latest) {
+			return errChangedSinceEdit
+		}
+		if !exists && latest.Ref.ID != target.Ref.ID {
+			return errChangedSinceEdit
+		}
+
+		var current map[string]interface{}
+		var currentUser model.User
+		if exists {
+			current = doc.Data()
+			currentUser = userFromDoc(doc)
+		}
+		restored = userFromData(entry.Previous)
+
+		oldEmail, newEmail := model.NormalizeEmail(currentUser.Email), model.NormalizeEmail(restored.Email)
+		if oldEmail != newEmail {
+			if newEmail != "" {
+				if err := s.users.ClaimEmail(tx, newEmail, ref.ID); err != nil {
+					return err
+				}
+			}
+			if oldEmail != "" {
+				if err := tx.Delete(s.users.EmailIndex(oldEmail)); err != nil {
+					return err
+				}
+			}
+		}
+		// Snapshots taken before the field rename restore under the new names
+		normalizeUserData(entry.Previous)
+		data := entry.Previous
+		if exists {
+			data = restoreUserFields(current, entry.Previous)
+		}
+		deriveUserFields(data)
+		stampUpdatedAt(data)
+		if err := tx.Set(ref, withHistoryVersion(data, latest)); err != nil {
+			return err
+		}
+		if err := tx.Update(target.Ref, []firestore.Update{{Path: "undone", Value: true}}); err != nil {
+			return err
+		}
+		undone = entry.Version
+		eventType := "user.updated"
+		if !exists {
+			eventType = "user.created"
+		}
+		if err := s.recordOutboxChangeTx(tx, eventType, ref.ID, actor, data, current); err != nil {
+			return err
+		}
+		return recordHistoryTx(tx, ref, latest, HistoryEntry{
+			Op:       "undo",
+			Data:     data,
+			Previous: current,
+			Actor:    actor,
+			UndoOf:   entry.Version,
+		})
+	})
+	switch {
+	case err == store.ErrUserNotFound:
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	case err == errNothingToUndo:
+		writeError(w, r, http.StatusConflict, "nothing_to_undo", "Nothing to undo")
+		return
+	case err == errChangedSinceEdit:
+		writeError(w, r, http.StatusConflict, "changed_since_last_edit", "User changed since the last recorded change; refusing to undo")
+		return
+	case err == store.ErrEmailTaken:
+		writeError(w, r, http.StatusConflict, "email_taken", "Email already in use by another user")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error undoing change")
+		return
+	}
+
+	user := restored
+	if !dryRun {
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal", "Error loading user")
+			return
+		}
+		user = userFromDoc(doc)
+		s.enqueueSearchUpsert(ref.ID, user)
+	}
+	user.AvatarURL = avatarURL(ref.ID, user)
+
+	response := map[string]interface{}{
+		"message":       "Change undone",
+		"id":            ref.ID,
+		"undoneVersion": undone,
+		"user":          user,
+	}
+	if links := userLinks(r, ref.ID); links != nil {
+		response["links"] = links
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// Whether doc's user fields are as the latest history entry left them.
+// Tracked writes store the entry's version on the user; a user last
+// written before they did has only the timestamps, which any untracked
+// write since breaks.
+func unchangedSinceHistory(doc *firestore.DocumentSnapshot, latest *firestore.DocumentSnapshot) bool {
+	stored, ok := doc.Data()[historyVersionField].(int64)
+	if !ok {
+		return doc.UpdateTime.Equal(latest.CreateTime)
+	}
+	version, _ := latest.Data()["version"].(int64)
+	return stored == version
+}
+
+// current with its user fields replaced by those of previous
+func restoreUserFields(current, previous map[string]interface{}) map[string]interface{} {
+	data := maps.Clone(current)
+	for _, fp := range userFieldPaths() {
+		delete(data, fp[0])
+		if v, ok := previous[fp[0]]; ok {
+			data[fp[0]] = v
+		}
+	}
+	return data
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+func TestRestoreUserFields(t *testing.T) {
+	current := map[string]interface{}{
+		"name": "Ada King", "email": "ada@example.com", "plan": "pro",
+		"attributes":    map[string]interface{}{"tier": "gold"},
+		"referralCount": int64(3), "protected": true,
+	}
+	previous := map[string]interface{}{
+		"name": "Ada", "email": "ada@example.com", "plan": "free",
+		"referralCount": int64(1),
+	}
+	got := restoreUserFields(current, previous)
+	want := map[string]interface{}{
+		"name": "Ada", "email": "ada@example.com", "plan": "free",
+		"referralCount": int64(3), "protected": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreUserFields = %v, want %v", got, want)
+	}
+	if current["name"] != "Ada King" {
+		t.Errorf("current was modified: %v", current)
+	}
+}
+
+// Undo the last change to id through the handler
+func undo(id string) *http.Request {
+	r := jsonRequest(http.MethodPost, "/users/"+id+":undo", "")
+	r.SetPathValue("id", id)
+	return r
+}
+
+func TestUndoSurvivesUntrackedBookkeeping(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if err := s.updateUser(ctx, id, model.User{Name: "Ada King", Email: "ada@example.com"}, "test", false); err != nil {
+		t.Fatal(err)
+	}
+	// As a referral, a consent or protecting the user would
+	if _, err := s.users.Users().Doc(id).Update(ctx, withUpdatedAt([]firestore.Update{{Path: "referralCount", Value: 2}})); err != nil {
+		t.Fatal(err)
+	}
+
+	serveJSON(t, s.undoUserHandler, undo(id), http.StatusOK)
+	doc, err := s.users.Users().Doc(id).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := doc.Data()["name"]; name != "Ada" {
+		t.Errorf("name = %v, want the update undone", name)
+	}
+	if n := doc.Data()["referralCount"]; n != int64(2) {
+		t.Errorf("referralCount = %v, want it kept", n)
+	}
+	if v := doc.Data()[historyVersionField]; v != int64(3) {
+		t.Errorf("%s = %v, want the undo's version 3", historyVersionField, v)
+	}
+}
+
+func TestUndoRefusesAfterAnonymization(t *testing.T) {
+	s, ctx := useEmulator(t)
+	id := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	if err := s.updateUser(ctx, id, model.User{Name: "Ada King", Email: "ada@example.com"}, "test", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.anonymizeUser(ctx, id, "test", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	serveError(t, s.undoUserHandler, undo(id), http.StatusConflict, "changed_since_last_edit")
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// Update masks on PUT /updateUser?updateMask=name,attributes.address.city,
+// as clients of gRPC services send them: only the listed fields are taken
+// from the body (everything else in it is ignored) and written as
+// field-path updates, so the rest of the document, nested attributes
+// included, stays as stored. A listed field that is missing from the body
+// is left alone, or deleted with ?clearMissing=true; one that is null in
+// it is deleted. name and email can be changed but never cleared.
+// Paths are dot-separated: a field name, snake_case becoming camelCase as
+// in bodies, then attribute keys taken as written. They are checked like
+// JSON Patch paths; arrays are written whole, since Firestore can't
+// address their elements.
+
+// Fields a masked update may change but not delete or empty
+var requiredMaskFields = map[string]bool{"name": true, "email": true}
+
+// Split ?updateMask= into paths, reporting each one that can't be written
+func parseUpdateMask(raw string) ([][]string, []FieldError) {
+	var paths [][]string
+	var problems []FieldError
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(p)
+		tokens := strings.Split(p, ".")
+		tokens[0] = camelCase(tokens[0])
+		problem := userPathProblem(tokens)
+		if slices.Contains(tokens, "") {
+			problem = "empty path segment"
+		}
+		for _, other := range paths {
+			if slices.Equal(other, tokens[:min(len(tokens), len(other))]) || slices.Equal(tokens, other[:min(len(tokens), len(other))]) {
+				problem = "overlaps " + strings.Join(other, ".")
+			}
+		}
+		if problem != "" {
+			problems = append(problems, FieldError{Field: p, Message: problem})
+			continue
+		}
+		paths = append(paths, tokens)
+	}
+	return paths, problems
+}
+
+// The field-path updates a masked body makes: each path's value from body,
+// or a delete when it is missing and clearMissing is set. The values are
+// checked against the User schema.
+func updateMaskUpdates(body map[string]interface{}, paths [][]string, clearMissing bool) ([]firestore.Update, []FieldError) {
+	var updates []firestore.Update
+	var problems []FieldError
+	picked := map[string]interface{}{}
+	for _, tokens := range paths {
+		path := strings.Join(tokens, ".")
+		value, found, problem := lookupPath(body, tokens)
+		clears := found && (value == nil || value == "") || !found && clearMissing
+		switch {
+		case problem != "":
+			problems = append(problems, FieldError{Field: path, Message: problem})
+		case requiredMaskFields[path] && clears:
+			problems = append(problems, FieldError{Field: path, Message: "required; it can't be cleared"})
+		case found && value != nil:
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath(tokens), Value: value})
+			setPath(picked, tokens, value)
+		case clearMissing || found:
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath(tokens), Value: firestore.Delete})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	raw, _ := json.Marshal(picked)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var user model.User
+	if err := dec.Decode(&user); err != nil {
+		if te, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, []FieldError{{Field: te.Field, Message: "expected " + te.Type.String() + ", got " + te.Value}}
+		}
+		return nil, []FieldError{{Field: "body", Message: err.Error()}}
+	}
+	return updates, nil
+}
+
+// The value at tokens in a decoded JSON body. Arrays can only be taken
+// whole; a scalar where an object should be is a problem too.
+func lookupPath(body map[string]interface{}, tokens []string) (value interface{}, found bool, problem string) {
+	var node interface{} = body
+	for i, key := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if node, found = n[key]; !found {
+				return nil, false, ""
+			}
+		case []interface{}:
+			return nil, false, strings.Join(tokens[:i], ".") + " is an array; list it whole"
+		default:
+			if n == nil {
+				return nil, false, ""
+			}
+			return nil, false, strings.Join(tokens[:i], ".") + " is not an object in the body"
+		}
+	}
+	return node, true, ""
+}
+
+// Set value at tokens, creating maps on the way
+func setPath(m map[string]interface{}, tokens []string, value interface{}) {
+	for _, key := range tokens[:len(tokens)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+		m = child
+	}
+	m[tokens[len(tokens)-1]] = value
+}
+
+// A copy of stored data with field-path updates applied, as Firestore
+// will store it; maps on the updated paths are copied, not changed
+func applyFieldUpdates(data map[string]interface{}, updates []firestore.Update) map[string]interface{} {
+	out := maps.Clone(data)
+	for _, u := range updates {
+		m := out
+		for _, key := range u.FieldPath[:len(u.FieldPath)-1] {
+			child, _ := m[key].(map[string]interface{})
+			child = maps.Clone(child)
+			if child == nil {
+				child = map[string]interface{}{}
+			}
+			m[key] = child
+			m = child
+		}
+		last := u.FieldPath[len(u.FieldPath)-1]
+		if u.Value == firestore.Delete {
+			delete(m, last)
+		} else {
+			m[last] = u.Value
+		}
+	}
+	return out
+}
+
+// Read a masked update's body and turn it into field-path updates,
+// writing the error response when it can't be: 415 for a body that isn't
+// JSON, 400 when it doesn't parse, 422 listing every path that can't be
+// written
+func readUpdateMask(w http.ResponseWriter, r *http.Request) ([]firestore.Update, bool) {
+	paths, problems := parseUpdateMask(r.URL.Query().Get("updateMask"))
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", "updateMask lists fields that can't be written", problems...)
+		return nil, false
+	}
+	raw, err := readJSONBody(r)
+	if err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return nil, false
+	}
+	body := map[string]interface{}{}
+	if err == nil && len(bytes.TrimSpace(raw)) > 0 {
+		err = json.Unmarshal(raw, &body)
+	}
+	if err != nil || body == nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return nil, false
+	}
+	updates, problems := updateMaskUpdates(body, paths, r.URL.Query().Get("clearMissing") == "true")
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", "The body's values for updateMask can't be written", problems...)
+		return nil, false
+	}
+	return updates, true
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestParseUpdateMask(t *testing.T) {
+	tests := []struct {
+		raw       string
+		want      [][]string
+		wantError []string // fields reported
+	}{
+		{"name", [][]string{{"name"}}, nil},
+		{"name, email", [][]string{{"name"}, {"email"}}, nil},
+		{"referred_by", nil, []string{"referred_by"}}, // read-only once camelCased
+		{"attributes.address.city", [][]string{{"attributes", "address", "city"}}, nil},
+		{"attributes.cost_center", [][]string{{"attributes", "cost_center"}}, nil},
+		{"name.first", nil, []string{"name.first"}},
+		{"nosuch", nil, []string{"nosuch"}},
+		{"attributes..city", nil, []string{"attributes..city"}},
+		{"attributes,attributes.city", [][]string{{"attributes"}}, []string{"attributes.city"}},
+		{"attributes.a.b,attributes.a", [][]string{{"attributes", "a", "b"}}, []string{"attributes.a"}},
+		{"id", nil, []string{"id"}},
+		{"createdAt", nil, []string{"createdAt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			paths, problems := parseUpdateMask(tt.raw)
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("paths = %v, want %v", paths, tt.want)
+			}
+			var fields []string
+			for _, p := range problems {
+				fields = append(fields, p.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantError) {
+				t.Errorf("problems = %v, want errors for %v", problems, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestUpdateMaskUpdates(t *testing.T) {
+	body := map[string]interface{}{
+		"name": "Ada",
+		"attributes": map[string]interface{}{
+			"address": map[string]interface{}{"city": "London"},
+			"tags":    []interface{}{"a", "b"},
+			"gone":    nil,
+		},
+		"ignored": "not in the mask",
+	}
+	paths := [][]string{{"name"}, {"attributes", "address", "city"}, {"attributes", "tags"}, {"attributes", "gone"}, {"attributes", "missing"}}
+
+	updates, problems := updateMaskUpdates(body, paths, false)
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	want := []firestore.Update{
+		{FieldPath: firestore.FieldPath{"name"}, Value: "Ada"},
+		{FieldPath: firestore.FieldPath{"attributes", "address", "city"}, Value: "London"},
+		{FieldPath: firestore.FieldPath{"attributes", "tags"}, Value: []interface{}{"a", "b"}},
+		{FieldPath: firestore.FieldPath{"attributes", "gone"}, Value: firestore.Delete},
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates = %v, want %v", updates, want)
+	}
+
+	updates, _ = updateMaskUpdates(body, paths, true)
+	if last := updates[len(updates)-1]; !reflect.DeepEqual(last.FieldPath, firestore.FieldPath{"attributes", "missing"}) || last.Value != firestore.Delete {
+		t.Errorf("clearMissing: last update = %v, want a delete of attributes.missing", last)
+	}
+}
+
+func TestUpdateMaskRejectsArrayElementsAndBadTypes(t *testing.T) {
+	body := map[string]interface{}{
+		"name":       42.0,
+		"attributes": map[string]interface{}{"tags": []interface{}{"a"}},
+	}
+	if _, problems := updateMaskUpdates(body, [][]string{{"attributes", "tags", "0"}}, false); len(problems) != 1 || problems[0].Field != "attributes.tags.0" {
+		t.Errorf("array element path: problems = %v", problems)
+	}
+	if _, problems := updateMaskUpdates(body, [][]string{{"name"}}, false); len(problems) != 1 {
+		t.Errorf("number for name: problems = %v, want one", problems)
+	}
+}
+
+func TestUpdateMaskKeepsRequiredFields(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         map[string]interface{}
+		path         string
+		clearMissing bool
+	}{
+		{"missing email with clearMissing", map[string]interface{}{}, "email", true},
+		{"null email", map[string]interface{}{"email": nil}, "email", false},
+		{"empty email", map[string]interface{}{"email": ""}, "email", false},
+		{"missing name with clearMissing", map[string]interface{}{}, "name", true},
+		{"null name", map[string]interface{}{"name": nil}, "name", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problems := updateMaskUpdates(tt.body, [][]string{{tt.path}}, tt.clearMissing)
+			if len(problems) != 1 || problems[0].Field != tt.path {
+				t.Errorf("problems = %v, want one for %s", problems, tt.path)
+			}
+		})
+	}
+	// Left out without clearMissing, they are simply left alone
+	if updates, problems := updateMaskUpdates(map[string]interface{}{}, [][]string{{"email"}}, false); len(updates) != 0 || len(problems) != 0 {
+		t.Errorf("missing email: updates %v, problems %v; want neither", updates, problems)
+	}
+}
+
+func TestReadUpdateMaskRefusesClearingEmail(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/updateUser?id=u1&updateMask=email&clearMissing=true", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	if _, ok := readUpdateMask(rec, r); ok {
+		t.Fatal("clearing email was accepted")
+	}
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", rec.Code)
+	}
+}
@@ -0,0 +1,305 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// Firestore usage for billing estimates. Documents read, written and
+// deleted are counted per endpoint by the client's gRPC interceptors (see
+// slowlog.go), one per document the way Firestore bills them, so list,
+// export and listener traffic is attributed exactly. Counts are exposed
+// as Prometheus counters on GET /admin/metrics, summarized with estimated
+// cost on GET /admin/usage, and rolled up every USAGE_FLUSH_INTERVAL into
+// usage_daily/{day} so history survives restarts.
+var (
+	usageFlushInterval = config.Duration("USAGE_FLUSH_INTERVAL", time.Minute)
+	usagePrices        = map[string]float64{ // USD per 100k documents
+		"read":   config.Float("FIRESTORE_PRICE_READS", 0.06),
+		"write":  config.Float("FIRESTORE_PRICE_WRITES", 0.18),
+		"delete": config.Float("FIRESTORE_PRICE_DELETES", 0.02),
+	}
+	usage = &usageCounters{totals: map[usageKey]int64{}, pending: map[usageKey]int64{}}
+)
+
+// Endpoint label for Firestore calls made outside a request
+const backgroundEndpoint = "background"
+
+type endpointKey struct{}
+
+type usageKey struct {
+	endpoint string
+	op       string // read, write, delete
+}
+
+// usageCounters holds totals since startup and the deltas not yet rolled up
+type usageCounters struct {
+	mu      sync.Mutex
+	since   time.Time
+	totals  map[usageKey]int64
+	pending map[usageKey]int64
+}
+
+func (u *usageCounters) add(ctx context.Context, op string, n int) {
+	if n <= 0 {
+		return
+	}
+	key := usageKey{endpoint: endpointFromContext(ctx), op: op}
+	u.mu.Lock()
+	u.totals[key] += int64(n)
+	u.pending[key] += int64(n)
+	u.mu.Unlock()
+}
+
+func (u *usageCounters) snapshot() map[usageKey]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make(map[usageKey]int64, len(u.totals))
+	for k, v := range u.totals {
+		out[k] = v
+	}
+	return out
+}
+
+// Label the Firestore calls made with ctx
+func withEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+func endpointFromContext(ctx context.Context) string {
+	if endpoint, ok := ctx.Value(endpointKey{}).(string); ok {
+		return endpoint
+	}
+	return backgroundEndpoint
+}
+
+// Label each request with the route pattern it matches, e.g. "GET /users/{id}"
+func endpointMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		next.ServeHTTP(w, r.WithContext(withEndpoint(r.Context(), pattern)))
+	})
+}
+
+// Count the documents a unary RPC read, wrote or deleted. Gets and
+// queries are billed one read even when they find nothing.
+func countUnaryUsage(ctx context.Context, req, reply interface{}) {
+	switch r := req.(type) {
+	case *firestorepb.GetDocumentRequest:
+		usage.add(ctx, "read", 1)
+	case *firestorepb.ListDocumentsRequest:
+		if resp, ok := reply.(*firestorepb.ListDocumentsResponse); ok {
+			usage.add(ctx, "read", max(len(resp.GetDocuments()), 1))
+		}
+	case *firestorepb.ListCollectionIdsRequest:
+		usage.add(ctx, "read", 1)
+	case *firestorepb.CommitRequest:
+		countWrites(ctx, r.GetWrites())
+	case *firestorepb.BatchWriteRequest:
+		countWrites(ctx, r.GetWrites())
+	}
+}
+
+func countWrites(ctx context.Context, writes []*firestorepb.Write) {
+	deletes := 0
+	for _, w := range writes {
+		if w.GetDelete() != "" {
+			deletes++
+		}
+	}
+	usage.add(ctx, "delete", deletes)
+	usage.add(ctx, "write", len(writes)-deletes)
+}
+
+// Count the reads of a finished RunQuery, aggregation or BatchGet stream
+func countStreamUsage(ctx context.Context, method string, docs int) {
+	switch method {
+	case "RunQuery", "RunAggregationQuery":
+		usage.add(ctx, "read", max(docs, 1))
+	case "BatchGetDocuments":
+		usage.add(ctx, "read", docs)
+	}
+}
+
+func (s *Server) startUsageRollup(bg *background) {
+	usage.since = time.Now().UTC()
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if err := s.flushUsage(withEndpoint(ctx, "usage_rollup")); err != nil {
+				log.Printf("⚠️ Failed to roll up Firestore usage: %v", err)
+			}
+		}
+	})
+}
+
+// Add the pending counts to today's usage_daily document; on failure they
+// stay pending for the next flush
+func (s *Server) flushUsage(ctx context.Context) error {
+	usage.mu.Lock()
+	pending := usage.pending
+	usage.pending = map[usageKey]int64{}
+	usage.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	endpoints := map[string]interface{}{}
+	totals := map[string]interface{}{}
+	sums := map[string]int64{}
+	for k, n := range pending {
+		ops, _ := endpoints[k.endpoint].(map[string]interface{})
+		if ops == nil {
+			ops = map[string]interface{}{}
+			endpoints[k.endpoint] = ops
+		}
+		ops[k.op] = firestore.Increment(n)
+		sums[k.op] += n
+	}
+	for op, n := range sums {
+		totals[op] = firestore.Increment(n)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := s.client.Collection("usage_daily").Doc(quotaDay(time.Now())).Set(ctx, map[string]interface{}{
+		"totals":    totals,
+		"endpoints": endpoints,
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		usage.mu.Lock()
+		for k, n := range pending {
+			usage.pending[k] += n
+		}
+		usage.mu.Unlock()
+	}
+	return err
+}
+
+// Estimated USD cost of counts per operation
+func usageCost(counts map[string]int64) float64 {
+	cost := 0.0
+	for op, n := range counts {
+		cost += float64(n) / 100000 * usagePrices[op]
+	}
+	return cost
+}
+
+// Usage since startup by endpoint, plus the daily rollups for ?days= (default 7)
+// (GET /admin/usage)
+func (s *Server) usageHandler(w http.ResponseWriter, r *http.Request) {
+	byEndpoint := map[string]map[string]int64{}
+	totals := map[string]int64{}
+	for k, n := range usage.snapshot() {
+		if byEndpoint[k.endpoint] == nil {
+			byEndpoint[k.endpoint] = map[string]int64{}
+		}
+		byEndpoint[k.endpoint][k.op] += n
+		totals[k.op] += n
+	}
+	endpoints := []map[string]interface{}{}
+	for endpoint, counts := range byEndpoint {
+		endpoints = append(endpoints, map[string]interface{}{
+			"endpoint":         endpoint,
+			"documents":        counts,
+			"estimatedCostUsd": usageCost(counts),
+		})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i]["estimatedCostUsd"].(float64) > endpoints[j]["estimatedCostUsd"].(float64)
+	})
+
+	days := 7
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 && n <= 90 {
+		days = n
+	}
+	docs, err := s.client.Collection("usage_daily").
+		OrderBy(firestore.DocumentID, firestore.Desc).
+		Limit(days).Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading usage history")
+		return
+	}
+	daily := []map[string]interface{}{}
+	for _, doc := range docs {
+		counts := map[string]int64{}
+		if t, ok := doc.Data()["totals"].(map[string]interface{}); ok {
+			for op, v := range t {
+				if n, ok := v.(int64); ok {
+					counts[op] = n
+				}
+			}
+		}
+		daily = append(daily, map[string]interface{}{
+			"day":              doc.Ref.ID,
+			"documents":        counts,
+			"estimatedCostUsd": usageCost(counts),
+		})
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"since":            usage.since,
+		"pricesPer100k":    usagePrices,
+		"documents":        totals,
+		"estimatedCostUsd": usageCost(totals),
+		"endpoints":        endpoints,
+		"daily":            daily,
+	})
+}
+
+// Prometheus text exposition of the usage counters, SLO series, chaos faults, Slack deliveries, MAX_USERS, coalesced reads, open iterators, cache invalidation and the write throttle (GET /admin/metrics)
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	counts := usage.snapshot()
+	keys := make([]usageKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].op < keys[j].op
+	})
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP firestore_documents_total Firestore documents read, written or deleted, by endpoint.")
+	fmt.Fprintln(w, "# TYPE firestore_documents_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "firestore_documents_total{endpoint=%s,op=%s} %d\n", promLabel(k.endpoint), promLabel(k.op), counts[k])
+	}
+	writeSLOMetrics(w)
+	writeChaosMetrics(w)
+	writeSlackMetrics(w)
+	writeUserCapMetrics(w)
+	writeCoalesceMetrics(w)
+	writeIteratorMetrics(w)
+	writeInvalidationMetrics(w)
+	writeThrottleMetrics(w)
+	writeAccessLogMetrics(w)
+	writeSIEMMetrics(w)
+	writeRetentionMetrics(w)
+}
+
+// Quote a Prometheus label value
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
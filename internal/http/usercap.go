@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+)
+
+// MAX_USERS is a soft cap on the users collection for deployments that
+// must stay inside a free quota; 0 turns it off. The count it's checked
+// against is a count aggregation refreshed every USER_COUNT_REFRESH plus
+// the creates this replica has made since, so no create pays for a
+// transaction. Creates on other replicas show up at the next refresh,
+// which lets the collection overshoot by roughly the creates all
+// replicas make in one refresh interval. Admins can bypass the cap for
+// corrective work with X-Admin-Override: true.
+var (
+	maxUsers         = int64(config.Int("MAX_USERS", 0))
+	userCountRefresh = config.Duration("USER_COUNT_REFRESH", 30*time.Second)
+	userCapWarnRatio = 0.8
+	userCap          = &userCapState{}
+)
+
+// userCapState is the cached user count and what has been reported about it
+type userCapState struct {
+	mu         sync.Mutex
+	counted    int64 // at the last refresh
+	added      int64 // created here since
+	fetched    time.Time
+	warned     bool // utilization is past userCapWarnRatio and was logged
+	rejections int64
+}
+
+// Estimated number of users, refreshing the count when it's stale. A
+// failed refresh keeps the old estimate, so the cap fails open.
+func (c *userCapState) estimate(ctx context.Context, users *firestore.CollectionRef) int64 {
+	c.mu.Lock()
+	stale := time.Since(c.fetched) >= userCountRefresh
+	c.mu.Unlock()
+	if stale {
+		res, err := users.NewAggregationQuery().WithCount("all").Get(ctx)
+		if err != nil {
+			log.Printf("⚠️ Failed to count users for MAX_USERS: %v", err)
+			c.mu.Lock()
+			c.fetched = time.Now() // retry at the next refresh, not on every create
+			c.mu.Unlock()
+		} else if v, ok := res["all"].(interface{ GetIntegerValue() int64 }); ok {
+			c.mu.Lock()
+			c.counted, c.added, c.fetched = v.GetIntegerValue(), 0, time.Now()
+			c.mu.Unlock()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.counted + c.added
+	c.checkUtilization(n)
+	return n
+}
+
+// Log once each time utilization crosses userCapWarnRatio upwards (c.mu held)
+func (c *userCapState) checkUtilization(n int64) {
+	over := float64(n) >= userCapWarnRatio*float64(maxUsers)
+	if over && !c.warned {
+		log.Printf("⚠️ Users at %d of MAX_USERS %d (%.0f%%)", n, maxUsers, 100*float64(n)/float64(maxUsers))
+	}
+	c.warned = over
+}
+
+func (c *userCapState) created() {
+	c.mu.Lock()
+	c.added++
+	c.mu.Unlock()
+}
+
+// Whether a create may go ahead; when it may not, the 507 has been written.
+// Call userCap.created() once the user exists.
+func (s *Server) admitNewUser(w http.ResponseWriter, r *http.Request) bool {
+	if maxUsers <= 0 {
+		return true
+	}
+	if r.Header.Get("X-Admin-Override") == "true" && adminAuthorized(r) {
+		logCtx(r.Context(), "🔓 MAX_USERS bypassed by admin override (request %s)", requestID(r))
+		return true
+	}
+	n := userCap.estimate(requestContext(r), s.users.Users())
+	if n < maxUsers {
+		return true
+	}
+	userCap.mu.Lock()
+	userCap.rejections++
+	userCap.mu.Unlock()
+	w.Header().Set("X-User-Count", strconv.FormatInt(n, 10))
+	w.Header().Set("X-User-Cap", strconv.FormatInt(maxUsers, 10))
+	writeErrorWith(w, r, http.StatusInsufficientStorage, "user_limit_reached",
+		fmt.Sprintf("This deployment has reached its limit of %d users (%d exist)", maxUsers, n),
+		map[string]interface{}{"count": n, "cap": maxUsers})
+	return false
+}
+
+// Prometheus gauges and counter for MAX_USERS, for metricsHandler
+func writeUserCapMetrics(w io.Writer) {
+	if maxUsers <= 0 {
+		return
+	}
+	userCap.mu.Lock()
+	n, rejections := userCap.counted+userCap.added, userCap.rejections
+	userCap.mu.Unlock()
+	fmt.Fprintln(w, "# HELP user_cap_utilization Estimated users as a fraction of MAX_USERS.")
+	fmt.Fprintln(w, "# TYPE user_cap_utilization gauge")
+	fmt.Fprintf(w, "user_cap_utilization %g\n", float64(n)/float64(maxUsers))
+	fmt.Fprintln(w, "# HELP user_cap_rejections_total Creates rejected because MAX_USERS was reached.")
+	fmt.Fprintln(w, "# TYPE user_cap_rejections_total counter")
+	fmt.Fprintf(w, "user_cap_rejections_total %d\n", rejections)
+}
@@ -0,0 +1,226 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/cursor"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+)
+
+// The v1 user representation and its pagination envelope (api package)
+type (
+	UserResponse     = api.UserResponse
+	UserListResponse = api.UserListResponse
+)
+
+func newUserResponse(r *http.Request, doc *firestore.DocumentSnapshot, user model.User) UserResponse {
+	resp := userResponse(r, doc.Ref.ID, user, userCreatedAt(doc), doc.UpdateTime)
+	if v, err := doc.DataAt("enrichmentStatus"); err == nil {
+		resp.EnrichmentStatus, _ = v.(string)
+	}
+	return resp
+}
+
+// The v1 representation of the user id, without the document's extras
+func userResponse(r *http.Request, id string, user model.User, createdAt, updatedAt time.Time) UserResponse {
+	resp := UserResponse{
+		ID:         id,
+		Name:       user.Name,
+		Email:      user.Email,
+		Plan:       user.Plan,
+		AvatarURL:  avatarURL(id, user),
+		Attributes: user.Attributes,
+		CreatedAt:  Timestamp{Time: createdAt},
+		UpdatedAt:  Timestamp{Time: updatedAt},
+		Links:      userLinks(r, id),
+	}
+	if resp.Links != nil {
+		resp.Links["self"] = absoluteURL(r, "/v1/users/"+url.PathEscape(id), nil)
+	}
+	return resp
+}
+
+// When a user was created: the stored createdAt, which survives archiving
+// and restores, or the document's create time before the backfill
+// migration has stored it
+func userCreatedAt(doc *firestore.DocumentSnapshot) time.Time {
+	if createdAt, ok := doc.Data()["createdAt"].(time.Time); ok {
+		return createdAt
+	}
+	return doc.CreateTime
+}
+
+// Raw stand-in for a document that didn't decode
+func newMalformedUserResponse(r *http.Request, doc *firestore.DocumentSnapshot, err *malformedUserError) UserResponse {
+	resp := newUserResponse(r, doc, model.User{})
+	resp.Malformed, resp.MalformedFields, resp.Data = true, err.fields, doc.Data()
+	return resp
+}
+
+// Get a user (GET /v1/users/{id}?onMalformed=skip|include|fail)
+func (s *Server) v1GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	policy, ok := malformedPolicy(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onMalformed must be skip, include or fail")
+		return
+	}
+	doc, err := getDocument(requestContext(r), s.users.Users().Doc(r.PathValue("id")))
+	if err != nil || isSoftDeleted(doc) {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+	if notModified(w, r, documentETag(doc), doc.UpdateTime) {
+		return
+	}
+	user, malformed := decodeUserDoc(doc)
+	if malformed != nil {
+		writeMalformedUser(w, r, policy, newMalformedUserResponse(r, doc, malformed), malformed)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newUserResponse(r, doc, user))
+}
+
+// List users (GET /v1/users?pageSize=&pageToken=&onMalformed=&orderBy=id|createdAt|name)
+//
+// Users are ordered by document ID, newest first with orderBy=createdAt,
+// or by name with orderBy=name (collated per NAME_COLLATION, see
+// collation.go).
+// ?createdAfter=&createdBefore= (RFC 3339) filter on createdAt and imply
+// orderBy=createdAt; the page token carries the createdAt boundary.
+// Unfiltered createdAt listings carry a warning while users without
+// createdAt exist, since Firestore leaves them out.
+func (s *Server) v1ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	policy, ok := malformedPolicy(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onMalformed must be skip, include or fail")
+		return
+	}
+	rng, field, err := parseCreatedRange(r)
+	if err != nil {
+		invalidTimestamp(w, r, field)
+		return
+	}
+	orderBy := r.URL.Query().Get("orderBy")
+	switch {
+	case orderBy == "":
+		orderBy = "id"
+		if rng.active() {
+			orderBy = "createdAt"
+		}
+	case orderBy != "id" && orderBy != "createdAt" && orderBy != "name":
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "orderBy must be id, createdAt or name")
+		return
+	case orderBy != "createdAt" && rng.active():
+		// Firestore requires a range filter's field to be the first orderBy
+		writeError(w, r, http.StatusBadRequest, "invalid_argument",
+			"createdAfter/createdBefore filter on createdAt, so results must be ordered by it first: use orderBy=createdAt")
+		return
+	}
+	byCreated := orderBy == "createdAt"
+	filters := rng.filters()
+	filters["orderBy"] = orderBy
+	if orderBy == "name" {
+		filters["collation"] = nameCollation // a token doesn't survive a change of keys
+	}
+
+	pageSize := pageSizeParam(r, 50, 500)
+	query := s.users.Users().OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+	switch orderBy {
+	case "createdAt":
+		// The document ID breaks createdAt ties so cursors are exact
+		query = rng.apply(s.users.Users().Query).
+			OrderBy("createdAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(pageSize)
+	case "name":
+		query = s.users.Users().OrderBy(nameSortField(), firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+	}
+	cur, err := pageCursor(r, filters)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token: "+err.Error())
+		return
+	}
+	if cur != nil {
+		if cur.LastID == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+			return
+		}
+		boundary := []interface{}{cur.LastID}
+		if orderBy != "id" {
+			var value interface{}
+			if len(cur.Values) == 1 && byCreated {
+				value, err = time.Parse(time.RFC3339Nano, cur.Values[0])
+			} else if len(cur.Values) == 1 {
+				value, err = decodeNameSortValue(cur.Values[0])
+			}
+			if len(cur.Values) != 1 || err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid_page_token", "Invalid page token")
+				return
+			}
+			boundary = []interface{}{value, cur.LastID}
+		}
+		if cur.Backward {
+			query = query.EndBefore(boundary...).LimitToLast(pageSize)
+		} else {
+			query = query.StartAfter(boundary...)
+		}
+	}
+
+	docs, err := query.Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing users")
+		return
+	}
+	if notModified(w, r, pageETag(docs), time.Time{}) {
+		return
+	}
+
+	resp := UserListResponse{Users: []UserResponse{}}
+	for _, doc := range docs {
+		if isSoftDeleted(doc) {
+			continue
+		}
+		user, malformed := decodeUserDoc(doc)
+		if malformed == nil {
+			resp.Users = append(resp.Users, newUserResponse(r, doc, user))
+			continue
+		}
+		switch policy {
+		case onMalformedFail:
+			writeError(w, r, http.StatusInternalServerError, "malformed_document", malformed.Error())
+			return
+		case onMalformedInclude:
+			resp.Users = append(resp.Users, newMalformedUserResponse(r, doc, malformed))
+		}
+	}
+	// Page boundaries come from the raw page, so soft-deleted users hidden
+	// from it don't end pagination early
+	var first, last cursor.Cursor
+	if len(docs) > 0 {
+		first, last = userPageBoundary(docs[0], orderBy), userPageBoundary(docs[len(docs)-1], orderBy)
+	}
+	resp.NextPageToken, resp.PrevPageToken = pageTokens(r, cur, filters, first, last, len(docs), pageSize)
+	resp.Links = pageLinks(r, resp.NextPageToken, resp.PrevPageToken)
+	if byCreated && !rng.active() {
+		resp.Warning = s.sortFieldWarning(requestContext(r), "createdAt")
+	}
+	if orderBy == "name" {
+		resp.Warning = s.sortFieldWarning(requestContext(r), nameSortField())
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// Cursor position of a listed user document
+func userPageBoundary(doc *firestore.DocumentSnapshot, orderBy string) cursor.Cursor {
+	c := cursor.Cursor{LastID: doc.Ref.ID}
+	switch orderBy {
+	case "createdAt":
+		createdAt, _ := doc.Data()["createdAt"].(time.Time)
+		c.Values = []string{createdAt.UTC().Format(time.RFC3339Nano)}
+	case "name":
+		c.Values = []string{encodeNameSortValue(doc.Data()[nameSortField()])}
+	}
+	return c
+}
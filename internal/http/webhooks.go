@@ -0,0 +1,384 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Webhook subscriptions (OUTBOX_SINKS=subscriptions) are documents in
+// webhooks managed under /admin/webhooks. Each names an event type and,
+// optionally, the user fields it cares about, e.g.
+//
+//	{"event": "user.updated", "fieldsChanged": ["email"], "url": "https://billing.example.com/hook"}
+//
+// An event is delivered to a subscription with fieldsChanged only when
+// one of those fields differs between the event's before and after
+// snapshots, and the payload's changes holds the old and new values of
+// the watched fields that differ. A field that is absent counts as null;
+// a value whose type changes counts as changed. Events without snapshots
+// (user.deleted, user.archived, ...) only match subscriptions without
+// fieldsChanged. Subscriptions are reloaded every
+// WEBHOOK_SUBSCRIPTIONS_REFRESH.
+var (
+	webhookSubscriptionsRefresh = config.Duration("WEBHOOK_SUBSCRIPTIONS_REFRESH", 30*time.Second)
+	webhookSubscriptions        = &webhookSubscriptionCache{}
+)
+
+// Event types a subscription can name
+var webhookEventTypes = map[string]bool{
+	"user.created": true, "user.updated": true, "user.deleted": true, "user.merged": true,
+	"user.anonymized": true, "user.archived": true, "user.unarchived": true, "job.failed": true,
+}
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// WebhookSubscription is one record in the webhooks collection
+type WebhookSubscription struct {
+	ID            string    `json:"id" firestore:"-"`
+	Event         string    `json:"event" firestore:"event"`
+	FieldsChanged []string  `json:"fieldsChanged,omitempty" firestore:"fieldsChanged,omitempty"`
+	URL           string    `json:"url" firestore:"url"`
+	Secret        string    `json:"-" firestore:"secret,omitempty"`
+	HasSecret     bool      `json:"hasSecret" firestore:"-"`
+	CreatedAt     time.Time `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// fieldChange is one watched field's values before and after an event
+type fieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Whether event satisfies sub's field predicate, and the watched fields
+// that changed. Field names are the API's (attributes.plan); they are
+// looked up under their stored names.
+func (sub *WebhookSubscription) matches(event OutboxEvent) (map[string]fieldChange, bool) {
+	if sub.Event != event.Type {
+		return nil, false
+	}
+	if len(sub.FieldsChanged) == 0 {
+		return nil, true
+	}
+	if event.Data == nil && event.Previous == nil {
+		return nil, false
+	}
+	changes := map[string]fieldChange{}
+	for _, field := range sub.FieldsChanged {
+		path, ok := webhookFieldPath(field)
+		if !ok {
+			continue
+		}
+		before, after := valueAtPath(event.Previous, path), valueAtPath(event.Data, path)
+		if !sameFieldValue(before, after) {
+			changes[field] = fieldChange{Old: before, New: after}
+		}
+	}
+	return changes, len(changes) > 0
+}
+
+// Stored path of a field a subscription may watch: any user field,
+// attributes or one attribute, but not createdAt, which never changes
+func webhookFieldPath(field string) (firestore.FieldPath, bool) {
+	if field == "attributes" {
+		return firestore.FieldPath{"attributes"}, true
+	}
+	if field == "createdAt" {
+		return nil, false
+	}
+	return filterFieldPath(field)
+}
+
+// The value at path in nested maps, nil when any part is missing
+func valueAtPath(data map[string]interface{}, path firestore.FieldPath) interface{} {
+	var v interface{} = data
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// Equal values of the same type; timestamps compare as instants
+func sameFieldValue(a, b interface{}) bool {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// subscriptionSink implements EventSink by posting each event to the
+// subscriptions it matches
+type subscriptionSink struct {
+	webhooks  *firestore.CollectionRef
+	mu        sync.Mutex
+	delivered map[string]time.Time // event/subscription pairs already posted, so a redelivery skips them
+}
+
+func (s *subscriptionSink) Name() string { return "subscriptions" }
+
+func (s *subscriptionSink) Deliver(ctx context.Context, event OutboxEvent) error {
+	subs, err := webhookSubscriptions.load(ctx, s.webhooks)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for key, at := range s.delivered {
+		if time.Since(at) > outboxLease*time.Duration(outboxMaxAttempts) {
+			delete(s.delivered, key)
+		}
+	}
+	s.mu.Unlock()
+
+	var failures []string
+	for _, sub := range subs {
+		changes, ok := sub.matches(event)
+		if !ok {
+			continue
+		}
+		key := event.ID + "/" + sub.ID
+		s.mu.Lock()
+		_, done := s.delivered[key]
+		s.mu.Unlock()
+		if done {
+			continue
+		}
+		body, err := json.Marshal(struct {
+			OutboxEvent
+			SubscriptionID string                 `json:"subscriptionId"`
+			Changes        map[string]fieldChange `json:"changes,omitempty"`
+		}{event, sub.ID, changes})
+		if err != nil {
+			return err
+		}
+		if err := postWebhook(ctx, sub.URL, sub.Secret, event.ID, body); err != nil {
+			failures = append(failures, sub.ID+": "+err.Error())
+			continue
+		}
+		s.mu.Lock()
+		s.delivered[key] = time.Now()
+		s.mu.Unlock()
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// webhookSubscriptionCache holds the webhooks collection, reloaded at most
+// every WEBHOOK_SUBSCRIPTIONS_REFRESH
+type webhookSubscriptionCache struct {
+	mu     sync.Mutex
+	loaded time.Time
+	subs   []*WebhookSubscription
+}
+
+func (c *webhookSubscriptionCache) load(ctx context.Context, webhooks *firestore.CollectionRef) ([]*WebhookSubscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.loaded) < webhookSubscriptionsRefresh {
+		return c.subs, nil
+	}
+	docs, err := webhooks.Documents(ctx).GetAll()
+	if err != nil {
+		if c.subs != nil {
+			log.Printf("⚠️ Failed to reload webhook subscriptions, using the previous set: %v", err)
+			return c.subs, nil
+		}
+		return nil, err
+	}
+	subs := make([]*WebhookSubscription, 0, len(docs))
+	for _, doc := range docs {
+		subs = append(subs, webhookFromDoc(doc))
+	}
+	c.subs, c.loaded = subs, time.Now()
+	return subs, nil
+}
+
+func (c *webhookSubscriptionCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = time.Time{}
+	c.mu.Unlock()
+}
+
+func webhookFromDoc(doc *firestore.DocumentSnapshot) *WebhookSubscription {
+	var sub WebhookSubscription
+	doc.DataTo(&sub)
+	sub.ID = doc.Ref.ID
+	sub.HasSecret = sub.Secret != ""
+	return &sub
+}
+
+// webhookRequest is the body of POST and PUT /admin/webhooks
+type webhookRequest struct {
+	Event         string   `json:"event"`
+	FieldsChanged []string `json:"fieldsChanged"`
+	URL           string   `json:"url"`
+	Secret        *string  `json:"secret"` // PUT keeps the stored secret when omitted
+}
+
+// The first invalid field and what is wrong with it
+func (req *webhookRequest) validate() *FieldError {
+	if !webhookEventTypes[req.Event] {
+		return &FieldError{Field: "event", Message: fmt.Sprintf("unknown event type %q", req.Event)}
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return &FieldError{Field: "url", Message: "must be an absolute http(s) URL"}
+	}
+	seen := map[string]bool{}
+	for _, field := range req.FieldsChanged {
+		if _, ok := webhookFieldPath(field); !ok || seen[field] {
+			return &FieldError{Field: "fieldsChanged", Message: fmt.Sprintf("%q is not a user field or is listed twice", field)}
+		}
+		seen[field] = true
+	}
+	if len(req.FieldsChanged) > 0 && !strings.HasPrefix(req.Event, "user.") {
+		return &FieldError{Field: "fieldsChanged", Message: "only user events carry field changes"}
+	}
+	return nil
+}
+
+// List webhook subscriptions (GET /admin/webhooks)
+func (s *Server) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.client.Collection("webhooks").OrderBy("createdAt", firestore.Asc).Documents(requestContext(r)).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error listing webhooks")
+		return
+	}
+	subs := []*WebhookSubscription{}
+	for _, doc := range docs {
+		subs = append(subs, webhookFromDoc(doc))
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"webhooks": subs})
+}
+
+// Get a webhook subscription (GET /admin/webhooks/{id})
+func (s *Server) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.client.Collection("webhooks").Doc(r.PathValue("id")).Get(requestContext(r))
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error loading webhook")
+		return
+	}
+	if notModified(w, r, documentETag(doc), doc.UpdateTime) {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, webhookFromDoc(doc))
+}
+
+// Create (POST /admin/webhooks) or replace (PUT /admin/webhooks/{id}) a subscription
+func (s *Server) saveWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+		unsupportedMediaType(w, r, "application/json")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if fe := req.validate(); fe != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_field", fe.Field+" "+fe.Message, *fe)
+		return
+	}
+
+	col := s.client.Collection("webhooks")
+	ref, created := col.NewDoc(), true
+	if id := r.PathValue("id"); id != "" {
+		ref, created = col.Doc(id), false
+	}
+	var saved *WebhookSubscription
+	err := s.runTransaction(withIfMatch(requestContext(r), r), dryRunRequested(r), func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now().UTC()
+		sub := WebhookSubscription{Event: req.Event, FieldsChanged: req.FieldsChanged, URL: req.URL, CreatedAt: now, UpdatedAt: now}
+		if !created {
+			doc, err := tx.Get(ref)
+			if status.Code(err) == codes.NotFound {
+				return errWebhookNotFound
+			}
+			if err != nil {
+				return err
+			}
+			if err := checkIfMatch(ctx, doc); err != nil {
+				return err
+			}
+			current := webhookFromDoc(doc)
+			sub.CreatedAt, sub.Secret = current.CreatedAt, current.Secret
+		}
+		if req.Secret != nil {
+			sub.Secret = *req.Secret
+		}
+		if err := tx.Set(ref, sub); err != nil {
+			return err
+		}
+		sub.ID, sub.HasSecret = ref.ID, sub.Secret != ""
+		saved = &sub
+		return nil
+	})
+	if err == errWebhookNotFound {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+		return
+	}
+	if err == errPreconditionFailed {
+		writePreconditionFailed(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error saving webhook")
+		return
+	}
+	webhookSubscriptions.invalidate()
+	s.publishInvalidation("webhooks")
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeJSON(w, r, code, saved)
+}
+
+// Delete a webhook subscription (DELETE /admin/webhooks/{id})
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ref := s.client.Collection("webhooks").Doc(r.PathValue("id"))
+	precondition, err := writePrecondition(r)
+	if err != nil {
+		writePreconditionFailed(w, r)
+		return
+	}
+	if dryRunRequested(r) {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"id": ref.ID, "deleted": false, "dryRun": true})
+		return
+	}
+	if _, err := ref.Delete(requestContext(r), precondition); status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+		return
+	} else if status.Code(err) == codes.FailedPrecondition {
+		writePreconditionFailed(w, r)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error deleting webhook")
+		return
+	}
+	webhookSubscriptions.invalidate()
+	s.publishInvalidation("webhooks")
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"id": ref.ID, "deleted": true})
+}
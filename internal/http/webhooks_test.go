@@ -0,0 +1,280 @@
+package http
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestWebhookFieldPath(t *testing.T) {
+	tests := []struct {
+		field string
+		want  firestore.FieldPath
+		ok    bool
+	}{
+		{"email", firestore.FieldPath{"email"}, true},
+		{"name", firestore.FieldPath{"name"}, true},
+		{"plan", firestore.FieldPath{"plan"}, true},
+		{"attributes", firestore.FieldPath{"attributes"}, true},
+		{"attributes.plan", firestore.FieldPath{"attributes", "plan"}, true},
+		{"attributes.", nil, false},
+		{"createdAt", nil, false},
+		{"avatarUrl", nil, false}, // computed, never stored
+		{"nickname", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := webhookFieldPath(tt.field)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("webhookFieldPath(%q) = %v, %v, want %v, %v", tt.field, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValueAtPath(t *testing.T) {
+	data := map[string]interface{}{
+		"email": "ada@example.com",
+		"attributes": map[string]interface{}{
+			"address": map[string]interface{}{"city": "London"},
+			"tags":    []interface{}{"a"},
+		},
+	}
+	tests := []struct {
+		path firestore.FieldPath
+		want interface{}
+	}{
+		{firestore.FieldPath{"email"}, "ada@example.com"},
+		{firestore.FieldPath{"attributes", "address", "city"}, "London"},
+		{firestore.FieldPath{"attributes", "address"}, map[string]interface{}{"city": "London"}},
+		{firestore.FieldPath{"attributes", "missing"}, nil},
+		{firestore.FieldPath{"email", "deeper"}, nil},         // not a map
+		{firestore.FieldPath{"attributes", "tags", "0"}, nil}, // arrays aren't indexed
+		{firestore.FieldPath{"missing", "deeper"}, nil},
+	}
+	for _, tt := range tests {
+		if got := valueAtPath(data, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("valueAtPath(%v) = %#v, want %#v", tt.path, got, tt.want)
+		}
+	}
+	if got := valueAtPath(nil, firestore.FieldPath{"email"}); got != nil {
+		t.Errorf("valueAtPath(nil) = %#v, want nil", got)
+	}
+}
+
+func TestSameFieldValue(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		a, b interface{}
+		want bool
+	}{
+		{"ada", "ada", true},
+		{"ada", "Ada", false},
+		{nil, nil, true},
+		{nil, "", false},
+		{int64(1), int64(1), true},
+		{int64(1), 1.0, false}, // the type changed
+		{"1", int64(1), false},
+		{true, "true", false},
+		{at, at.In(time.FixedZone("CET", 3600)), true}, // the same instant
+		{at, at.Add(time.Nanosecond), false},
+		{at, at.Format(time.RFC3339), false},
+		{[]interface{}{"a", "b"}, []interface{}{"a", "b"}, true},
+		{[]interface{}{"a", "b"}, []interface{}{"b", "a"}, false},
+		{map[string]interface{}{"city": "London"}, map[string]interface{}{"city": "London"}, true},
+		{map[string]interface{}{"city": "London"}, map[string]interface{}{"city": "Paris"}, false},
+		{map[string]interface{}{}, nil, false},
+	}
+	for _, tt := range tests {
+		if got := sameFieldValue(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameFieldValue(%#v, %#v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := sameFieldValue(tt.b, tt.a); got != tt.want {
+			t.Errorf("sameFieldValue(%#v, %#v) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookSubscriptionMatches(t *testing.T) {
+	before := map[string]interface{}{
+		"name":  "Ada",
+		"email": "ada@example.com",
+		"plan":  "free",
+		"attributes": map[string]interface{}{
+			"team":    "core",
+			"seats":   int64(3),
+			"address": map[string]interface{}{"city": "London", "zip": "N1"},
+		},
+	}
+	// before with changes made, by field; nil removes the field
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		out := map[string]interface{}{}
+		for k, v := range before {
+			out[k] = v
+		}
+		attrs := map[string]interface{}{}
+		for k, v := range before["attributes"].(map[string]interface{}) {
+			attrs[k] = v
+		}
+		out["attributes"] = attrs
+		for k, v := range changes {
+			m := out
+			if attr, ok := strings.CutPrefix(k, "attributes."); ok {
+				k, m = attr, attrs
+			}
+			if v == nil {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+		return out
+	}
+	updated := func(changes map[string]interface{}) OutboxEvent {
+		return OutboxEvent{Type: "user.updated", Previous: before, Data: with(changes)}
+	}
+
+	tests := []struct {
+		name   string
+		sub    WebhookSubscription
+		event  OutboxEvent
+		want   map[string]fieldChange
+		wantOK bool
+	}{
+		{
+			name:   "other event type",
+			sub:    WebhookSubscription{Event: "user.created"},
+			event:  updated(map[string]interface{}{"email": "ada@new.example"}),
+			wantOK: false,
+		},
+		{
+			name:   "no fields matches every event of the type",
+			sub:    WebhookSubscription{Event: "user.updated"},
+			event:  updated(nil),
+			wantOK: true,
+		},
+		{
+			name:   "no fields matches an event without snapshots",
+			sub:    WebhookSubscription{Event: "user.deleted"},
+			event:  OutboxEvent{Type: "user.deleted"},
+			wantOK: true,
+		},
+		{
+			name:   "fields never match an event without snapshots",
+			sub:    WebhookSubscription{Event: "user.deleted", FieldsChanged: []string{"email"}},
+			event:  OutboxEvent{Type: "user.deleted"},
+			wantOK: false,
+		},
+		{
+			name:   "watched field changed",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"email"}},
+			event:  updated(map[string]interface{}{"email": "ada@new.example", "name": "Ada King"}),
+			want:   map[string]fieldChange{"email": {Old: "ada@example.com", New: "ada@new.example"}},
+			wantOK: true,
+		},
+		{
+			name:   "only other fields changed",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"email"}},
+			event:  updated(map[string]interface{}{"name": "Ada King"}),
+			want:   map[string]fieldChange{},
+			wantOK: false,
+		},
+		{
+			name:   "only the watched fields that changed are reported",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"email", "plan", "name"}},
+			event:  updated(map[string]interface{}{"plan": "pro", "name": "Ada King"}),
+			want:   map[string]fieldChange{"plan": {Old: "free", New: "pro"}, "name": {Old: "Ada", New: "Ada King"}},
+			wantOK: true,
+		},
+		{
+			name:   "attribute changed",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.team"}},
+			event:  updated(map[string]interface{}{"attributes.team": "infra"}),
+			want:   map[string]fieldChange{"attributes.team": {Old: "core", New: "infra"}},
+			wantOK: true,
+		},
+		{
+			name:   "attribute added",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.vip"}},
+			event:  updated(map[string]interface{}{"attributes.vip": true}),
+			want:   map[string]fieldChange{"attributes.vip": {Old: nil, New: true}},
+			wantOK: true,
+		},
+		{
+			name:   "attribute removed",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.team"}},
+			event:  updated(map[string]interface{}{"attributes.team": nil}),
+			want:   map[string]fieldChange{"attributes.team": {Old: "core", New: nil}},
+			wantOK: true,
+		},
+		{
+			name:   "attribute type changed",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.seats"}},
+			event:  updated(map[string]interface{}{"attributes.seats": 3.0}),
+			want:   map[string]fieldChange{"attributes.seats": {Old: int64(3), New: 3.0}},
+			wantOK: true,
+		},
+		{
+			name:   "attribute became a map",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.team"}},
+			event:  updated(map[string]interface{}{"attributes.team": map[string]interface{}{"name": "core"}}),
+			want:   map[string]fieldChange{"attributes.team": {Old: "core", New: map[string]interface{}{"name": "core"}}},
+			wantOK: true,
+		},
+		{
+			name: "nested map attribute changed deep down",
+			sub:  WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.address"}},
+			event: updated(map[string]interface{}{
+				"attributes.address": map[string]interface{}{"city": "London", "zip": "N7"},
+			}),
+			want: map[string]fieldChange{"attributes.address": {
+				Old: map[string]interface{}{"city": "London", "zip": "N1"},
+				New: map[string]interface{}{"city": "London", "zip": "N7"},
+			}},
+			wantOK: true,
+		},
+		{
+			name: "nested map attribute rewritten unchanged",
+			sub:  WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes.address"}},
+			event: updated(map[string]interface{}{
+				"attributes.address": map[string]interface{}{"zip": "N1", "city": "London"},
+			}),
+			want:   map[string]fieldChange{},
+			wantOK: false,
+		},
+		{
+			name:  "whole attributes watched",
+			sub:   WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"attributes"}},
+			event: updated(map[string]interface{}{"attributes.seats": int64(4)}),
+			want: map[string]fieldChange{"attributes": {
+				Old: before["attributes"],
+				New: with(map[string]interface{}{"attributes.seats": int64(4)})["attributes"],
+			}},
+			wantOK: true,
+		},
+		{
+			name:   "created: everything watched that is set changed from null",
+			sub:    WebhookSubscription{Event: "user.created", FieldsChanged: []string{"email", "attributes.vip"}},
+			event:  OutboxEvent{Type: "user.created", Data: before},
+			want:   map[string]fieldChange{"email": {Old: nil, New: "ada@example.com"}},
+			wantOK: true,
+		},
+		{
+			name:   "unknown and unwatchable fields are ignored",
+			sub:    WebhookSubscription{Event: "user.updated", FieldsChanged: []string{"nickname", "createdAt", "avatarUrl"}},
+			event:  updated(map[string]interface{}{"nickname": "Countess", "createdAt": time.Now()}),
+			want:   map[string]fieldChange{},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.sub.matches(tt.event)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %#v, %v, want %#v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"github.com/Altair-05/GoFirestoreApp/internal/store"
+	"golang.org/x/oauth2/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Import users from a Google Workspace directory (POST
+// /admin/import/workspace). The Directory API is called with the Firestore
+// service account impersonating WORKSPACE_ADMIN_SUBJECT, a Workspace admin;
+// the service account needs domain-wide delegation of the read-only
+// directory scope, granted in the Workspace admin console. Directory users
+// of WORKSPACE_CUSTOMER (or one WORKSPACE_DOMAIN) are upserted by email.
+var (
+	workspaceAdminSubject = config.String("WORKSPACE_ADMIN_SUBJECT", "")
+	workspaceCustomer     = config.String("WORKSPACE_CUSTOMER", "my_customer")
+	workspaceDomain       = config.String("WORKSPACE_DOMAIN", "")
+	workspaceMaxRetries   = config.Int("WORKSPACE_MAX_RETRIES", 6)
+)
+
+// Attribute set on local users the directory no longer has, under flagMissing
+const missingInDirectoryAttr = "missingInDirectory"
+
+// Directory users fetched per page (the API maximum)
+const workspacePageSize = 500
+
+// What importing one directory user did
+const (
+	workspaceCreated   = "created"
+	workspaceUpdated   = "updated"
+	workspaceUnchanged = "unchanged"
+	workspaceSkipped   = "skipped" // soft-deleted locally
+)
+
+func newDirectoryService(ctx context.Context) (*admin.Service, error) {
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := google.JWTConfigFromJSON(raw, admin.AdminDirectoryUserReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+	conf.Subject = workspaceAdminSubject
+	return admin.NewService(ctx, option.WithTokenSource(conf.TokenSource(ctx)))
+}
+
+// Whether err is the Directory API refusing a call for quota, which it
+// reports as 429 or as 403 with a rate limit reason
+func directoryQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	for _, item := range apiErr.Errors {
+		switch item.Reason {
+		case "quotaExceeded", "rateLimitExceeded", "userRateLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// Call f, backing off exponentially (with jitter). Quota errors are
+// retried for as long as ctx lasts, so they slow a sync down but never
+// fail it; server errors are retried WORKSPACE_MAX_RETRIES times.
+func directoryRetry(ctx context.Context, f func() error) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := f()
+		var apiErr *googleapi.Error
+		if err == nil || !errors.As(err, &apiErr) ||
+			!directoryQuotaError(err) && (apiErr.Code < 500 || attempt >= workspaceMaxRetries) {
+			return err
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("⏳ Directory API returned %d, retrying in %v", apiErr.Code, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, 64*time.Second)
+	}
+}
+
+// Start a workspace_import job (POST /admin/import/workspace, admin). The
+// body is optional: {"domain", "flagMissing"}. flagMissing sets the
+// missingInDirectory attribute on local users of the imported domains the
+// directory doesn't have, instead of deleting them. ?dryRun=true counts
+// what would change without writing.
+func (s *Server) workspaceImportHandler(w http.ResponseWriter, r *http.Request) {
+	if workspaceAdminSubject == "" {
+		writeError(w, r, http.StatusNotImplemented, "workspace_not_configured", "No Workspace admin configured (WORKSPACE_ADMIN_SUBJECT)")
+		return
+	}
+	var req struct {
+		Domain      string `json:"domain"`
+		FlagMissing bool   `json:"flagMissing"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err == errUnsupportedMediaType {
+			unsupportedMediaType(w, r, "application/json")
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+			return
+		}
+	}
+	if req.Domain == "" {
+		req.Domain = workspaceDomain
+	}
+	id, err := s.startJob(requestContext(r), "workspace_import", map[string]interface{}{
+		"domain":      strings.ToLower(req.Domain),
+		"flagMissing": req.FlagMissing,
+		"dryRun":      dryRunRequested(r),
+		"actor":       actorFromRequest(r, "admin"),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error starting Workspace import")
+		return
+	}
+	writeJobStarted(w, r, id)
+}
+
+// Page through the directory, upserting each user. The checkpoint is the
+// next page token. flagMissing needs every directory email, so a takeover
+// of such a job pages from the start again; the upserts are idempotent.
+func (s *Server) runWorkspaceImportJob(ctx context.Context, run *jobRun) (map[string]interface{}, error) {
+	domain, _ := run.job.Params["domain"].(string)
+	flagMissing, _ := run.job.Params["flagMissing"].(bool)
+	actor, _ := run.job.Params["actor"].(string)
+	dryRun := run.dryRun()
+	srv, err := newDirectoryService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	seen := map[string]bool{}    // directory emails, for flagMissing
+	domains := map[string]bool{} // domains imported, for flagMissing
+	if domain != "" {
+		domains[domain] = true
+	}
+	processed, pageToken := run.processed(), run.checkpoint()
+	if flagMissing {
+		processed, pageToken = 0, ""
+	}
+	result := func() map[string]interface{} {
+		res := map[string]interface{}{"dryRun": dryRun, "directoryUsers": processed}
+		for outcome, n := range counts {
+			res[outcome] = n
+		}
+		return res
+	}
+	for {
+		call := srv.Users.List().MaxResults(workspacePageSize).OrderBy("email").
+			Fields("nextPageToken", "users(primaryEmail,name/fullName)")
+		if domain != "" {
+			call = call.Domain(domain)
+		} else {
+			call = call.Customer(workspaceCustomer)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		var page *admin.Users
+		err := directoryRetry(ctx, func() (err error) {
+			page, err = call.Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return result(), err
+		}
+		for _, du := range page.Users {
+			email := model.NormalizeEmail(du.PrimaryEmail)
+			if email == "" {
+				continue
+			}
+			seen[email] = true
+			if at := strings.LastIndex(email, "@"); at >= 0 {
+				domains[email[at+1:]] = true
+			}
+			name := ""
+			if du.Name != nil {
+				name = du.Name.FullName
+			}
+			outcome, err := s.importWorkspaceUser(ctx, email, name, actor, dryRun)
+			if err != nil {
+				counts["failed"]++
+				run.noteError(fmt.Errorf("%s: %w", email, err))
+				continue
+			}
+			counts[outcome]++
+		}
+		processed += len(page.Users)
+		pageToken = page.NextPageToken
+		run.progress(processed, 0, pageToken)
+		if pageToken == "" {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return result(), err
+		}
+	}
+
+	if flagMissing {
+		flagged, err := s.flagMissingInDirectory(ctx, seen, domains, actor, dryRun)
+		counts["flagged"] = flagged
+		if err != nil {
+			return result(), err
+		}
+	}
+	log.Printf("👥 Workspace import: %d directory users, %d created, %d updated, %d flagged, %d failed",
+		processed, counts[workspaceCreated], counts[workspaceUpdated], counts["flagged"], counts["failed"])
+	return result(), nil
+}
+
+// Create or update the local user with email. createUser claims the email
+// index entry, so a user created concurrently is reported as an
+// email_taken failure rather than duplicated.
+func (s *Server) importWorkspaceUser(ctx context.Context, email, name, actor string, dryRun bool) (string, error) {
+	doc, err := s.users.UserByEmail(ctx, email)
+	if err == store.ErrUserNotFound {
+		user := model.User{Name: name, Email: email}
+		id, err := s.createUser(ctx, user, "", actor, dryRun)
+		if err != nil {
+			return "", err
+		}
+		if !dryRun {
+			user.Plan = model.PlanFree
+			s.enqueueSearchUpsert(id, user)
+		}
+		return workspaceCreated, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if isSoftDeleted(doc) {
+		return workspaceSkipped, nil
+	}
+	existing := userFromDoc(doc)
+	_, flagged := existing.Attributes[missingInDirectoryAttr]
+	if (name == "" || existing.Name == name) && !flagged {
+		return workspaceUnchanged, nil
+	}
+	user, err := s.modifyUser(ctx, doc.Ref.ID, actor, dryRun, func(current model.User) (model.User, error) {
+		if name != "" {
+			current.Name = name
+		}
+		if _, ok := current.Attributes[missingInDirectoryAttr]; ok {
+			current.Attributes = maps.Clone(current.Attributes)
+			delete(current.Attributes, missingInDirectoryAttr)
+		}
+		return current, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !dryRun {
+		s.enqueueSearchUpsert(doc.Ref.ID, user)
+	}
+	return workspaceUpdated, nil
+}
+
+// Set missingInDirectory on local users of domains whose email the
+// directory doesn't have; returns how many were (or would be) flagged
+func (s *Server) flagMissingInDirectory(ctx context.Context, seen, domains map[string]bool, actor string, dryRun bool) (int, error) {
+	flagged := 0
+	users := trackIterator("workspaceImport", s.users.Users().Documents(ctx))
+	defer users.Stop()
+	for {
+		doc, err := users.Next()
+		if err == iterator.Done {
+			return flagged, nil
+		}
+		if err != nil {
+			return flagged, err
+		}
+		if isSoftDeleted(doc) || isAnonymized(doc) {
+			continue
+		}
+		user := userFromDoc(doc)
+		email := model.NormalizeEmail(user.Email)
+		at := strings.LastIndex(email, "@")
+		if at < 0 || !domains[email[at+1:]] || seen[email] || user.Attributes[missingInDirectoryAttr] == true {
+			continue
+		}
+		_, err = s.modifyUser(ctx, doc.Ref.ID, actor, dryRun, func(current model.User) (model.User, error) {
+			current.Attributes = maps.Clone(current.Attributes)
+			if current.Attributes == nil {
+				current.Attributes = map[string]interface{}{}
+			}
+			current.Attributes[missingInDirectoryAttr] = true
+			return current, nil
+		})
+		if err != nil && err != store.ErrUserNotFound {
+			return flagged, err
+		}
+		if err == nil {
+			flagged++
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package http
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/config"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Zip exports hold one pretty-printed JSON file per document, named by
+// its path (users/<id>.json, users/<id>/addresses/<aid>.json, ...), and a
+// manifest.json written last with the counts. Fields keep their Firestore
+// types through $-tagged objects: {"$timestamp": RFC 3339}, {"$geopoint":
+// {"latitude", "longitude"}}, {"$bytes": base64}, {"$reference": path},
+// {"$double": n} for whole-number doubles and {"$map": {...}} for a map
+// whose only key starts with "$". Imports take uploads up to
+// ZIP_IMPORT_MAX_BYTES, spooled to a temporary file.
+var zipImportMaxBytes = int64(config.Int("ZIP_IMPORT_MAX_BYTES", 512<<20))
+
+const zipManifestVersion = 1
+
+// zipManifest is manifest.json
+type zipManifest struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Documents  int              `json:"documents"`
+	Counts     map[string]int64 `json:"counts"` // by collection, subcollections as users/*/addresses
+}
+
+// Stream every user and its subcollections as a zip (GET /admin/export/zip, admin).
+// Anonymized users are left out unless ?includeAnonymized=true.
+// Failures after the first byte are reported in the X-Export-Error trailer
+// and leave the archive without its manifest, so it can't be imported.
+func (s *Server) zipExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users-%s.zip"`, time.Now().UTC().Format("20060102-150405")))
+	w.Header().Set("Trailer", "X-Export-Error")
+
+	ctx := r.Context()
+	zw := zip.NewWriter(w)
+	manifest := zipManifest{Version: zipManifestVersion, ExportedAt: time.Now().UTC(), Counts: map[string]int64{}}
+	flusher, _ := w.(http.Flusher)
+	includeAnonymized := r.URL.Query().Get("includeAnonymized") == "true"
+	err := prefetchPages(ctx, s.users.Users().Query, exportPageSize, exportLookahead, func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			if isAnonymized(doc) && !includeAnonymized {
+				continue
+			}
+			if err := writeZipDocument(ctx, zw, doc, &manifest); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			zw.Flush()
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeZipJSON(zw, "manifest.json", manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("⚠️ Zip export failed: %v", err)
+		w.Header().Set("X-Export-Error", err.Error())
+	}
+}
+
+// Write doc and, depth first, the documents of its subcollections
+func writeZipDocument(ctx context.Context, zw *zip.Writer, doc *firestore.DocumentSnapshot, manifest *zipManifest) error {
+	name := artifactPath(doc.Ref)
+	if err := writeZipJSON(zw, name+".json", encodeTypedFields(doc.Data())); err != nil {
+		return err
+	}
+	manifest.Documents++
+	manifest.Counts[zipCollectionKey(name)]++
+
+	cols := doc.Ref.Collections(ctx)
+	for {
+		col, err := cols.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		iter := trackIterator("zipExport", col.Documents(ctx))
+		for {
+			sub, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err == nil {
+				err = writeZipDocument(ctx, zw, sub, manifest)
+			}
+			if err != nil {
+				iter.Stop()
+				return err
+			}
+		}
+		iter.Stop()
+	}
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Manifest key for a document path: users, users/*/addresses, ...
+func zipCollectionKey(docPath string) string {
+	parts := strings.Split(docPath, "/")
+	for i := 1; i < len(parts)-1; i += 2 {
+		parts[i] = "*"
+	}
+	return strings.Join(parts[:len(parts)-1], "/")
+}
+
+func encodeTypedFields(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		out[k] = encodeTypedValue(v)
+	}
+	return out
+}
+
+// A Firestore value as JSON that decodeTypedValue turns back into the same value
+func encodeTypedValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return map[string]interface{}{"$timestamp": v.UTC().Format(time.RFC3339Nano)}
+	case *latlng.LatLng:
+		return map[string]interface{}{"$geopoint": map[string]float64{"latitude": v.GetLatitude(), "longitude": v.GetLongitude()}}
+	case []byte:
+		return map[string]interface{}{"$bytes": base64.StdEncoding.EncodeToString(v)}
+	case *firestore.DocumentRef:
+		return map[string]interface{}{"$reference": artifactPath(v)}
+	case float64:
+		switch {
+		case math.IsNaN(v), math.IsInf(v, 0):
+			return map[string]interface{}{"$double": fmt.Sprint(v)}
+		case v == math.Trunc(v):
+			return map[string]interface{}{"$double": v}
+		}
+		return v
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = encodeTypedValue(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := encodeTypedFields(v)
+		if len(v) == 1 {
+			for k := range v {
+				if strings.HasPrefix(k, "$") {
+					return map[string]interface{}{"$map": out}
+				}
+			}
+		}
+		return out
+	}
+	return v
+}
+
+// The inverse of encodeTypedValue, for JSON decoded with UseNumber
+func (s *Server) decodeTypedValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = s.decodeTypedValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for k, tagged := range v {
+				if strings.HasPrefix(k, "$") {
+					return s.decodeTaggedValue(k, tagged)
+				}
+			}
+		}
+		return s.decodeTypedFields(v)
+	}
+	return v, nil
+}
+
+func (s *Server) decodeTypedFields(data map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		var err error
+		if out[k], err = s.decodeTypedValue(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) decodeTaggedValue(tag string, v interface{}) (interface{}, error) {
+	str, _ := v.(string)
+	switch tag {
+	case "$timestamp":
+		return time.Parse(time.RFC3339Nano, str)
+	case "$bytes":
+		return base64.StdEncoding.DecodeString(str)
+	case "$reference":
+		if str == "" || strings.Count(str, "/")%2 != 1 {
+			return nil, fmt.Errorf("invalid $reference %q", str)
+		}
+		return s.client.Doc(str), nil
+	case "$double":
+		if n, ok := v.(json.Number); ok {
+			return n.Float64()
+		}
+		switch str {
+		case "NaN":
+			return math.NaN(), nil
+		case "+Inf":
+			return math.Inf(1), nil
+		case "-Inf":
+			return math.Inf(-1), nil
+		}
+	case "$geopoint":
+		point, _ := v.(map[string]interface{})
+		lat, latErr := jsonFloat(point["latitude"])
+		lng, lngErr := jsonFloat(point["longitude"])
+		if latErr == nil && lngErr == nil {
+			return &latlng.LatLng{Latitude: lat, Longitude: lng}, nil
+		}
+	case "$map":
+		if m, ok := v.(map[string]interface{}); ok {
+			return s.decodeTypedFields(m)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type tag %s", tag)
+	}
+	return nil, fmt.Errorf("invalid %s value", tag)
+}
+
+func jsonFloat(v interface{}) (float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("not a number")
+	}
+	return n.Float64()
+}
+
+// Conflict modes for zip imports, for documents that already exist
+const (
+	zipImportSkip      = "skip"      // leave the existing document
+	zipImportOverwrite = "overwrite" // replace it
+	zipImportFail      = "fail"      // import nothing if any exists
+)
+
+var (
+	errZipInvalid  = errors.New("invalid archive")
+	errZipConflict = errors.New("document already exists")
+)
+
+// Import an archive made by GET /admin/export/zip (POST /admin/import/zip?onConflict=fail|skip|overwrite, admin).
+// The body is the archive (application/zip) or {"gcsUri": "gs://bucket/object"}.
+// Imported users get email index entries; search and MAX_USERS aren't
+// updated, and after an overwrite POST /admin/emailIndex:check finds
+// entries left behind by replaced emails.
+func (s *Server) zipImportHandler(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("onConflict")
+	if mode == "" {
+		mode = zipImportFail
+	}
+	if mode != zipImportFail && mode != zipImportSkip && mode != zipImportOverwrite {
+		writeError(w, r, http.StatusBadRequest, "invalid_argument", "onConflict must be fail, skip or overwrite")
+		return
+	}
+	if mode == zipImportOverwrite && !dryRunRequested(r) && !confirmProduction(w, r, "import with onConflict=overwrite") {
+		return
+	}
+
+	ctx := requestContext(r)
+	f, err := os.CreateTemp("", "import-*.zip")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error buffering the archive")
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := spoolZipImport(ctx, r, f)
+	var maxErr *http.MaxBytesError
+	switch {
+	case err == errUnsupportedMediaType:
+		unsupportedMediaType(w, r, "application/zip", "application/json")
+		return
+	case errors.As(err, &maxErr):
+		writeError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "Archive too large")
+		return
+	case errors.Is(err, errZipInvalid):
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	case err != nil:
+		log.Printf("⚠️ Failed to read zip import: %v", err)
+		writeError(w, r, http.StatusBadGateway, "internal", "Error reading the archive")
+		return
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Not a zip archive")
+		return
+	}
+	manifest, docs, err := readZipManifest(zr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	result, err := s.importZipDocuments(ctx, docs, mode, dryRunRequested(r))
+	if errors.Is(err, errZipInvalid) {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	if errors.Is(err, errZipConflict) {
+		writeError(w, r, http.StatusConflict, "conflict", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ Zip import failed: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "Error importing the archive")
+		return
+	}
+	result["exportedAt"] = manifest.ExportedAt
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// Copy the uploaded or GCS-hosted archive to f
+func spoolZipImport(ctx context.Context, r *http.Request, f *os.File) (int64, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/zip", "application/octet-stream":
+		n, err := io.Copy(f, r.Body)
+		var maxErr *http.MaxBytesError
+		if err != nil && !errors.As(err, &maxErr) {
+			err = fmt.Errorf("%w: %v", errZipInvalid, err)
+		}
+		return n, err
+	case "application/json":
+	default:
+		return 0, errUnsupportedMediaType
+	}
+	var req struct {
+		GCSURI string `json:"gcsUri"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return 0, fmt.Errorf("%w: %v", errZipInvalid, err)
+	}
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(req.GCSURI, "gs://"), "/")
+	if !strings.HasPrefix(req.GCSURI, "gs://") || !ok || bucket == "" || object == "" {
+		return 0, fmt.Errorf("%w: gcsUri must be gs://bucket/object", errZipInvalid)
+	}
+	srv, err := storage.NewService(ctx, option.WithCredentialsFile(credentialsFile), option.WithScopes(storage.DevstorageReadOnlyScope))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := srv.Objects.Get(bucket, object).Context(ctx).Download()
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(f, io.LimitReader(resp.Body, zipImportMaxBytes+1))
+	if n > zipImportMaxBytes {
+		return n, &http.MaxBytesError{Limit: zipImportMaxBytes}
+	}
+	return n, err
+}
+
+// Check the manifest against the archive's document files, returned by path
+func readZipManifest(zr *zip.Reader) (zipManifest, map[string]*zip.File, error) {
+	var manifest zipManifest
+	docs := map[string]*zip.File{}
+	counts := map[string]int64{}
+	for _, f := range zr.File {
+		if f.Name == "manifest.json" {
+			rc, err := f.Open()
+			if err != nil {
+				return manifest, nil, err
+			}
+			err = json.NewDecoder(rc).Decode(&manifest)
+			rc.Close()
+			if err != nil {
+				return manifest, nil, fmt.Errorf("invalid manifest.json: %v", err)
+			}
+			continue
+		}
+		docPath, ok := strings.CutSuffix(f.Name, ".json")
+		parts := strings.Split(docPath, "/")
+		if !ok || len(parts)%2 != 0 || parts[0] != "users" || path.Clean(docPath) != docPath || strings.Contains(docPath, "//") {
+			return manifest, nil, fmt.Errorf("unexpected file %q in archive", f.Name)
+		}
+		docs[docPath] = f
+		counts[zipCollectionKey(docPath)]++
+	}
+	switch {
+	case manifest.Version == 0:
+		return manifest, nil, errors.New("archive has no manifest.json (the export may have failed part-way)")
+	case manifest.Version != zipManifestVersion:
+		return manifest, nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	case manifest.Documents != len(docs):
+		return manifest, nil, fmt.Errorf("manifest lists %d documents but the archive holds %d", manifest.Documents, len(docs))
+	}
+	for key, n := range manifest.Counts {
+		if counts[key] != n {
+			return manifest, nil, fmt.Errorf("manifest counts %d documents in %s but the archive holds %d", n, key, counts[key])
+		}
+	}
+	return manifest, docs, nil
+}
+
+// Write docs in path order under mode. Fail mode reads every target
+// first and writes nothing if one exists.
+func (s *Server) importZipDocuments(ctx context.Context, docs map[string]*zip.File, mode string, dryRun bool) (map[string]interface{}, error) {
+	paths := make([]string, 0, len(docs))
+	for p := range docs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	if mode == zipImportFail {
+		for start := 0; start < len(paths); start += 100 {
+			refs := make([]*firestore.DocumentRef, 0, 100)
+			for _, p := range paths[start:min(start+100, len(paths))] {
+				refs = append(refs, s.client.Doc(p))
+			}
+			snaps, err := s.client.GetAll(ctx, refs)
+			if err != nil {
+				return nil, err
+			}
+			for _, snap := range snaps {
+				if snap.Exists() {
+					return nil, fmt.Errorf("%s: %w; import with onConflict=skip or overwrite", artifactPath(snap.Ref), errZipConflict)
+				}
+			}
+		}
+	}
+
+	bw := s.client.BulkWriter(ctx)
+	type pending struct {
+		job   *firestore.BulkWriterJob
+		email string
+		ref   *firestore.DocumentRef
+	}
+	var jobs []pending
+	for _, p := range paths {
+		data, err := s.readZipDocument(docs[p])
+		if err == nil && s.client.Doc(p).Parent.Path == s.users.Users().Path {
+			if err = canonicalizePlanData(data); err == nil {
+				deriveUserFields(data)
+				stampUpdatedAt(data)
+				// Written without history, so an undo there is refused
+				delete(data, historyVersionField)
+			}
+		}
+		if err != nil {
+			bw.End()
+			return nil, fmt.Errorf("%w: %s: %v", errZipInvalid, p, err)
+		}
+		if dryRun {
+			continue
+		}
+		ref := s.client.Doc(p)
+		var job *firestore.BulkWriterJob
+		if mode == zipImportOverwrite {
+			job, err = bw.Set(ref, data)
+		} else {
+			job, err = bw.Create(ref, data)
+		}
+		if err != nil {
+			bw.End()
+			return nil, err
+		}
+		email := ""
+		if ref.Parent.Path == s.users.Users().Path {
+			email, _ = data["email"].(string)
+		}
+		jobs = append(jobs, pending{job, email, ref})
+	}
+	bw.End()
+
+	imported, skipped := 0, 0
+	indexes := s.client.BulkWriter(ctx)
+	for _, p := range jobs {
+		_, err := p.job.Results()
+		switch {
+		case err == nil:
+			imported++
+			if model.NormalizeEmail(p.email) != "" {
+				indexes.Set(s.users.EmailIndex(p.email), map[string]interface{}{"userId": p.ref.ID})
+			}
+		case status.Code(err) == codes.AlreadyExists:
+			skipped++
+		default:
+			indexes.End()
+			return nil, err
+		}
+	}
+	indexes.End()
+	return map[string]interface{}{"dryRun": dryRun, "documents": len(paths), "imported": imported, "skipped": skipped}, nil
+}
+
+func (s *Server) readZipDocument(f *zip.File) (map[string]interface{}, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	dec := json.NewDecoder(rc)
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return s.decodeTypedFields(fields)
+}
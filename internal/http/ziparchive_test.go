@@ -0,0 +1,213 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Typed values written to an archive decode to what was written.
+// References need a client, so the emulator test covers them.
+func TestZipTypedValuesRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	want := map[string]interface{}{
+		"string":     "héllo",
+		"bool":       true,
+		"null":       nil,
+		"int":        int64(42),
+		"negative":   int64(-7),
+		"maxInt":     int64(math.MaxInt64),
+		"fraction":   1.5,
+		"whole":      2.0,
+		"zero":       0.0,
+		"inf":        math.Inf(1),
+		"negInf":     math.Inf(-1),
+		"timestamp":  time.Date(2024, 2, 29, 23, 59, 59, 123456789, time.UTC),
+		"geopoint":   &latlng.LatLng{Latitude: 52.52, Longitude: -13.405},
+		"bytes":      []byte{0, 1, 2, 0xff},
+		"emptyBytes": []byte{},
+		"array":      []interface{}{int64(1), 3.0, "x", []byte("y"), map[string]interface{}{"n": 0.25}},
+		"nested":     map[string]interface{}{"deeper": map[string]interface{}{"when": time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		"dollarKey":  map[string]interface{}{"$timestamp": "not a tag"},
+		"dollarKeys": map[string]interface{}{"$a": int64(1), "b": int64(2)},
+		"emptyMap":   map[string]interface{}{},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeZipJSON(zw, "users/u1.json", encodeTypedFields(want)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeZipJSON(zw, "users/u2.json", encodeTypedFields(map[string]interface{}{"nan": math.NaN()})); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.readZipDocument(zr.File[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(got[k], v) {
+			t.Errorf("%s = %#v (%T), want %#v (%T)", k, got[k], got[k], v, v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d fields, want %d", len(got), len(want))
+	}
+
+	got, err = s.readZipDocument(zr.File[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := got["nan"].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("nan = %#v, want NaN", got["nan"])
+	}
+}
+
+// Every document under users, subcollections included, as encoded
+// for an archive, by path
+func zipSnapshot(t *testing.T, s *Server, ctx context.Context) map[string]map[string]interface{} {
+	t.Helper()
+	docs := map[string]map[string]interface{}{}
+	var walk func(col *firestore.CollectionRef)
+	walk = func(col *firestore.CollectionRef) {
+		snaps, err := col.Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, snap := range snaps {
+			data := snap.Data()
+			if snap.Ref.Parent.Path == s.users.Users().Path {
+				// The import stamps these afresh
+				delete(data, "updatedAt")
+				delete(data, historyVersionField)
+			}
+			docs[artifactPath(snap.Ref)] = encodeTypedFields(data)
+			cols := snap.Ref.Collections(ctx)
+			for {
+				sub, err := cols.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				walk(sub)
+			}
+		}
+	}
+	walk(s.users.Users())
+	return docs
+}
+
+// An exported archive imported into an emptied database gives back the
+// same documents, subcollections and typed values included
+func TestZipExportImportRoundTrip(t *testing.T) {
+	s, ctx := useEmulator(t)
+	ada := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	grace := mustCreateUser(t, s, ctx, model.User{Name: "Grace", Email: "grace@example.com"})
+	_, err := s.users.Users().Doc(grace).Update(ctx, []firestore.Update{{Path: "attributes", Value: map[string]interface{}{
+		"manager":  s.users.Users().Doc(ada),
+		"joined":   time.Date(2020, 5, 17, 9, 30, 0, 500, time.UTC),
+		"office":   &latlng.LatLng{Latitude: 40.7, Longitude: -74},
+		"badge":    []byte("\x00badge"),
+		"score":    3.0,
+		"ratio":    0.125,
+		"inf":      math.Inf(1),
+		"tags":     []interface{}{"a", int64(2), map[string]interface{}{"$ref": "literal"}},
+		"settings": map[string]interface{}{"$only": true},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.Users().Doc(ada).Collection("addresses").Doc("home").Set(ctx, map[string]interface{}{"city": "London", "since": time.Date(1840, 1, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+	before := zipSnapshot(t, s, ctx)
+
+	rec := httptest.NewRecorder()
+	s.zipExportHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/export/zip", nil))
+	if rec.Code != http.StatusOK || rec.Result().Trailer.Get("X-Export-Error") != "" {
+		t.Fatalf("export = %d %s", rec.Code, rec.Result().Trailer.Get("X-Export-Error"))
+	}
+	archive := rec.Body.Bytes()
+
+	for p := range before {
+		if _, err := s.client.Doc(p).Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := zipSnapshot(t, s, ctx); len(left) != 0 {
+		t.Fatalf("%d documents left after deleting", len(left))
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/import/zip", bytes.NewReader(archive))
+	r.Header.Set("Content-Type", "application/zip")
+	body := serveJSON(t, s.zipImportHandler, r, http.StatusOK)
+	if body["imported"] != float64(len(before)) || body["skipped"] != 0.0 {
+		t.Errorf("import = %v, want %d imported", body, len(before))
+	}
+
+	after := zipSnapshot(t, s, ctx)
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("after the round trip:\n%v\nwant\n%v", after, before)
+	}
+
+	// Importing again conflicts and writes nothing
+	r = httptest.NewRequest(http.MethodPost, "/admin/import/zip", bytes.NewReader(archive))
+	r.Header.Set("Content-Type", "application/zip")
+	serveError(t, s.zipImportHandler, r, http.StatusConflict, "conflict")
+}
+
+// Anonymized users are left out of the archive unless asked for
+func TestZipExportSkipsAnonymized(t *testing.T) {
+	s, ctx := useEmulator(t)
+	ada := mustCreateUser(t, s, ctx, model.User{Name: "Ada", Email: "ada@example.com"})
+	grace := mustCreateUser(t, s, ctx, model.User{Name: "Grace", Email: "grace@example.com"})
+	if _, err := s.anonymizeUser(ctx, grace, "admin", nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, want := range map[string][]string{
+		"/admin/export/zip":                        {"manifest.json", "users/" + ada + ".json"},
+		"/admin/export/zip?includeAnonymized=true": {"manifest.json", "users/" + ada + ".json", "users/" + grace + ".json"},
+	} {
+		rec := httptest.NewRecorder()
+		s.zipExportHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			if strings.Count(f.Name, "/") < 2 {
+				names = append(names, f.Name)
+			}
+		}
+		sort.Strings(names)
+		sort.Strings(want)
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: files = %v, want %v", target, names, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Package model is what the service stores and serves: users, their
+// plans, and the rules their fields are compared by.
+package model
+
+import (
+	"strings"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+)
+
+// User is the stored user document and request body (api.User)
+type User = api.User
+
+// Plan is a user's billing tier. New users start on free; after that the
+// plan only changes through POST /users/{id}:changePlan, which records
+// the change in the user's history. Users created before the field was
+// added read as free, but ?plan=free only finds them once migration
+// 0002_user_plan_default has stored it.
+type Plan = api.Plan
+
+const (
+	PlanFree       = api.PlanFree
+	PlanPro        = api.PlanPro
+	PlanEnterprise = api.PlanEnterprise
+)
+
+// NormalizeEmail is email as emails are compared and indexed: trimmed and
+// lowercased
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
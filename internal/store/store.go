@@ -0,0 +1,134 @@
+// Package store keeps users in Firestore: the users collection, and the
+// email index that keeps their emails unique.
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Altair-05/GoFirestoreApp/internal/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors returned by a UserStore
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email already in use")
+)
+
+// UserStore is where users are kept. Writes that touch a user's email
+// run inside the caller's transaction, next to its other writes.
+type UserStore interface {
+	// Users is the users collection
+	Users() *firestore.CollectionRef
+	// NewUser is the document a new user is created at
+	NewUser() *firestore.DocumentRef
+	// EmailIndex is the index entry of email, holding its owner's ID
+	EmailIndex(email string) *firestore.DocumentRef
+	// ClaimEmail claims email for userID inside tx, failing with
+	// ErrEmailTaken when another user holds it
+	ClaimEmail(tx *firestore.Transaction, email, userID string) error
+	// MoveEmail moves userID's index entry from oldEmail to newEmail
+	// inside tx
+	MoveEmail(tx *firestore.Transaction, userID, oldEmail, newEmail string) error
+	// UserByEmail looks a user up through the email index
+	UserByEmail(ctx context.Context, email string) (*firestore.DocumentSnapshot, error)
+}
+
+// Firestore is the UserStore on a Firestore database
+type Firestore struct {
+	client *firestore.Client
+}
+
+// NewFirestore keeps users in client's database
+func NewFirestore(client *firestore.Client) *Firestore {
+	return &Firestore{client: client}
+}
+
+func (f *Firestore) Users() *firestore.CollectionRef {
+	return f.client.Collection("users")
+}
+
+// NewUser is users/{random ID}
+func (f *Firestore) NewUser() *firestore.DocumentRef {
+	return f.Users().NewDoc()
+}
+
+// EmailIndex is email_index/{sha256(normalized email)}
+func (f *Firestore) EmailIndex(email string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(model.NormalizeEmail(email)))
+	return f.client.Collection("email_index").Doc(hex.EncodeToString(sum[:]))
+}
+
+func (f *Firestore) ClaimEmail(tx *firestore.Transaction, email, userID string) error {
+	ref := f.EmailIndex(email)
+	doc, err := tx.Get(ref)
+	if err == nil {
+		if owner, _ := doc.Data()["userId"].(string); owner != userID {
+			return ErrEmailTaken
+		}
+		return nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return err
+	}
+	return tx.Create(ref, map[string]interface{}{"userId": userID})
+}
+
+func (f *Firestore) MoveEmail(tx *firestore.Transaction, userID, oldEmail, newEmail string) error {
+	oldEmail, newEmail = model.NormalizeEmail(oldEmail), model.NormalizeEmail(newEmail)
+	if oldEmail == newEmail {
+		return nil
+	}
+	if newEmail != "" {
+		if err := f.ClaimEmail(tx, newEmail, userID); err != nil {
+			return err
+		}
+	}
+	if oldEmail != "" {
+		return tx.Delete(f.EmailIndex(oldEmail))
+	}
+	return nil
+}
+
+// UserByEmail reads the index entry, then the user: two document gets,
+// no query
+func (f *Firestore) UserByEmail(ctx context.Context, email string) (*firestore.DocumentSnapshot, error) {
+	idx, err := f.EmailIndex(email).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := idx.Data()["userId"].(string)
+	if userID == "" {
+		return nil, ErrUserNotFound
+	}
+	doc, err := f.Users().Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrUserNotFound
+	}
+	return doc, err
+}
+
+// ValidDocID reports whether id is usable as a document ID: valid UTF-8
+// of at most 1500 bytes, without a slash (which would address a document
+// further down, e.g. "{id}/history/1"), and not ".", ".." or __reserved__
+func ValidDocID(id string) bool {
+	switch {
+	case id == "" || id == "." || id == "..":
+		return false
+	case len(id) > 1500 || !utf8.ValidString(id) || strings.Contains(id, "/"):
+		return false
+	case len(id) >= 4 && strings.HasPrefix(id, "__") && strings.HasSuffix(id, "__"):
+		return false
+	}
+	return true
+}
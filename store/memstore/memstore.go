@@ -0,0 +1,372 @@
+// Package memstore is an in-memory fake of the GoFirestoreApp user API,
+// for unit testing code written against client.Users without a server or
+// the Firestore emulator.
+//
+//	var users client.Users = memstore.New(memstore.WithSeed(1))
+//	id, err := users.CreateUser(ctx, api.User{Name: "Ada", Email: "ada@example.com"})
+//
+// It follows the server's rules and returns the same *client.Error values
+// (client.IsNotFound works on them):
+//   - emails are unique once trimmed and lowercased (409 email_taken)
+//   - plan defaults to free; other plans must be known, and UpdateUser
+//     keeps the current plan and refuses to change it (422 invalid_field)
+//   - ListUsers orders by ID, newest first by createdAt with ID
+//     breaking ties, or by name; CreatedAfter/CreatedBefore are
+//     exclusive, imply createdAt ordering and, like a Firestore range
+//     filter, can't be combined with another OrderBy (400
+//     invalid_argument)
+//   - page sizes default to 50 and are capped at 500, and a page token
+//     only resumes the listing it came from (400 invalid_page_token)
+//
+// Gaps, where the fake is simpler than the server:
+//   - no transactions: each call is atomic on its own, and nothing spans
+//     calls
+//   - no If-Match preconditions, quotas, rate limits or retries
+//   - no referrals, history, soft deletes, enrichment, avatars or links
+//   - page tokens are plain, not signed, and only go forward
+//   - OrderBy "name" is byte-wise, as the server without NAME_COLLATION
+//   - Attributes are stored as given, without Firestore's type
+//     conversions (an int stays an int rather than becoming int64)
+package memstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altair-05/GoFirestoreApp/api"
+	"github.com/Altair-05/GoFirestoreApp/client"
+)
+
+// Store holds users in memory. It is safe for concurrent use.
+type Store struct {
+	mu     sync.Mutex
+	users  map[string]*stored
+	emails map[string]string // normalized email -> user ID
+	ids    *mathrand.Rand    // nil for random IDs
+	now    func() time.Time
+}
+
+type stored struct {
+	user      api.User
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+var _ client.Users = (*Store)(nil)
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithSeed makes auto-IDs a deterministic sequence for seed, so tests can
+// assert on them
+func WithSeed(seed int64) Option {
+	return func(s *Store) { s.ids = mathrand.New(mathrand.NewSource(seed)) }
+}
+
+// WithClock stamps createdAt and updatedAt from now instead of time.Now
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) { s.now = now }
+}
+
+// New returns an empty store
+func New(opts ...Option) *Store {
+	s := &Store{users: map[string]*stored{}, emails: map[string]string{}, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+const (
+	idAlphabet      = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	idLength        = 20 // as Firestore's auto-IDs
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (s *Store) newID() string {
+	b := make([]byte, idLength)
+	if s.ids != nil {
+		for i := range b {
+			b[i] = idAlphabet[s.ids.Intn(len(idAlphabet))]
+		}
+		return string(b)
+	}
+	rand.Read(b)
+	for i := range b {
+		b[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(b)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func apiError(status int, code, detail string, fields ...api.FieldError) *client.Error {
+	return &client.Error{StatusCode: status, Code: code, Detail: detail, Fields: fields}
+}
+
+func errNotFound() error {
+	return apiError(http.StatusNotFound, api.CodeUserNotFound, "User not found")
+}
+
+func errEmailTaken() error {
+	return apiError(http.StatusConflict, api.CodeEmailTaken, "Email already in use")
+}
+
+func checkPlan(plan api.Plan) error {
+	switch plan {
+	case api.PlanFree, api.PlanPro, api.PlanEnterprise:
+		return nil
+	}
+	msg := fmt.Sprintf("must be one of %s, %s, %s", api.PlanFree, api.PlanPro, api.PlanEnterprise)
+	return apiError(http.StatusUnprocessableEntity, api.CodeInvalidField, "plan "+msg, api.FieldError{Field: "plan", Message: msg})
+}
+
+// Deep enough a copy that callers can't change stored attributes
+func cloneUser(u api.User) api.User {
+	u.Attributes = maps.Clone(u.Attributes)
+	u.AvatarURL = ""
+	return u
+}
+
+// CreateUser adds a user and returns its auto-ID
+func (s *Store) CreateUser(ctx context.Context, user api.User) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if user.Plan == "" {
+		user.Plan = api.PlanFree
+	}
+	if err := checkPlan(user.Plan); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email := normalizeEmail(user.Email)
+	if _, taken := s.emails[email]; taken && email != "" {
+		return "", errEmailTaken()
+	}
+	id := s.newID()
+	for s.users[id] != nil {
+		id = s.newID()
+	}
+	now := s.now().UTC()
+	s.users[id] = &stored{user: cloneUser(user), createdAt: now, updatedAt: now}
+	if email != "" {
+		s.emails[email] = id
+	}
+	return id, nil
+}
+
+func (s *Store) response(id string, u *stored) *api.UserResponse {
+	user := cloneUser(u.user)
+	return &api.UserResponse{
+		ID:         id,
+		Name:       user.Name,
+		Email:      user.Email,
+		Plan:       user.Plan,
+		Attributes: user.Attributes,
+		CreatedAt:  api.Timestamp{Time: u.createdAt},
+		UpdatedAt:  api.Timestamp{Time: u.updatedAt},
+	}
+}
+
+// GetUser reads a user
+func (s *Store) GetUser(ctx context.Context, id string) (*api.UserResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[id]
+	if u == nil {
+		return nil, errNotFound()
+	}
+	return s.response(id, u), nil
+}
+
+// UpdateUser replaces a user's fields. An empty plan keeps the current
+// one; a different one is refused, as plans change through their own
+// endpoint.
+func (s *Store) UpdateUser(ctx context.Context, id string, user api.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[id]
+	if u == nil {
+		return errNotFound()
+	}
+	if user.Plan == "" {
+		user.Plan = u.user.Plan
+	} else if user.Plan != u.user.Plan {
+		return apiError(http.StatusUnprocessableEntity, api.CodeInvalidField, "plan can only be changed with POST /users/{id}:changePlan")
+	}
+	oldEmail, newEmail := normalizeEmail(u.user.Email), normalizeEmail(user.Email)
+	if oldEmail != newEmail {
+		if owner, taken := s.emails[newEmail]; taken && newEmail != "" && owner != id {
+			return errEmailTaken()
+		}
+		delete(s.emails, oldEmail)
+		if newEmail != "" {
+			s.emails[newEmail] = id
+		}
+	}
+	u.user = cloneUser(user)
+	u.updatedAt = s.now().UTC()
+	return nil
+}
+
+// DeleteUser deletes a user and releases its email
+func (s *Store) DeleteUser(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[id]
+	if u == nil {
+		return errNotFound()
+	}
+	if email := normalizeEmail(u.user.Email); s.emails[email] == id {
+		delete(s.emails, email)
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// pageToken is where a listing resumes, with the options it was made for
+type pageToken struct {
+	OrderBy       string    `json:"o"`
+	CreatedAfter  time.Time `json:"a"`
+	CreatedBefore time.Time `json:"b"`
+	LastID        string    `json:"i"`
+	LastCreated   time.Time `json:"c"`
+	LastName      string    `json:"n,omitempty"`
+}
+
+func errInvalidToken() error {
+	return apiError(http.StatusBadRequest, api.CodeInvalidPageToken, "Invalid page token")
+}
+
+// ListUsers iterates over users in the server's order. Option errors
+// surface from the iterator's first Next, as they would from the server.
+func (s *Store) ListUsers(ctx context.Context, opts *client.ListUsersOptions) *client.UserIterator {
+	var o client.ListUsersOptions
+	if opts != nil {
+		o = *opts
+	}
+	ranged := !o.CreatedAfter.IsZero() || !o.CreatedBefore.IsZero()
+	orderBy := o.OrderBy
+	if orderBy == "" {
+		orderBy = "id"
+		if ranged {
+			orderBy = "createdAt"
+		}
+	}
+	pageSize := o.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	return client.NewUserIterator(func(token string) ([]api.UserResponse, string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		switch {
+		case orderBy != "id" && orderBy != "createdAt" && orderBy != "name":
+			return nil, "", apiError(http.StatusBadRequest, api.CodeInvalidArgument, "orderBy must be id, createdAt or name")
+		case orderBy != "createdAt" && ranged:
+			return nil, "", apiError(http.StatusBadRequest, api.CodeInvalidArgument,
+				"createdAfter/createdBefore filter on createdAt, so results must be ordered by it first: use orderBy=createdAt")
+		}
+		want := pageToken{OrderBy: orderBy, CreatedAfter: o.CreatedAfter.UTC(), CreatedBefore: o.CreatedBefore.UTC()}
+		var cur *pageToken
+		if token != "" {
+			raw, err := base64.RawURLEncoding.DecodeString(token)
+			cur = &pageToken{}
+			if err != nil || json.Unmarshal(raw, cur) != nil || cur.LastID == "" ||
+				cur.OrderBy != want.OrderBy || !cur.CreatedAfter.Equal(want.CreatedAfter) || !cur.CreatedBefore.Equal(want.CreatedBefore) {
+				return nil, "", errInvalidToken()
+			}
+		}
+		return s.page(want, cur, pageSize)
+	})
+}
+
+// One page of the listing want, after cur (nil for the first page)
+func (s *Store) page(want pageToken, cur *pageToken, pageSize int) ([]api.UserResponse, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type entry struct {
+		id string
+		u  *stored
+	}
+	var entries []entry
+	for id, u := range s.users {
+		if !want.CreatedAfter.IsZero() && !u.createdAt.After(want.CreatedAfter) ||
+			!want.CreatedBefore.IsZero() && !u.createdAt.Before(want.CreatedBefore) {
+			continue
+		}
+		entries = append(entries, entry{id, u})
+	}
+	// Firestore's orders: ID ascending, or createdAt then ID descending
+	less := func(a, b entry) bool { return a.id < b.id }
+	switch want.OrderBy {
+	case "createdAt":
+		less = func(a, b entry) bool {
+			if !a.u.createdAt.Equal(b.u.createdAt) {
+				return a.u.createdAt.After(b.u.createdAt)
+			}
+			return a.id > b.id
+		}
+	case "name":
+		// Byte-wise, as the server without NAME_COLLATION; users with no
+		// name are left out, as Firestore leaves out a missing field
+		var named []entry
+		for _, e := range entries {
+			if e.u.user.Name != "" {
+				named = append(named, e)
+			}
+		}
+		entries = named
+		less = func(a, b entry) bool {
+			if a.u.user.Name != b.u.user.Name {
+				return a.u.user.Name < b.u.user.Name
+			}
+			return a.id < b.id
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	if cur != nil {
+		boundary := entry{id: cur.LastID, u: &stored{createdAt: cur.LastCreated, user: api.User{Name: cur.LastName}}}
+		entries = entries[sort.Search(len(entries), func(i int) bool { return less(boundary, entries[i]) }):]
+	}
+
+	users := []api.UserResponse{}
+	for _, e := range entries[:min(pageSize, len(entries))] {
+		users = append(users, *s.response(e.id, e.u))
+	}
+	if len(entries) <= pageSize {
+		return users, "", nil
+	}
+	last := entries[pageSize-1]
+	next := want
+	next.LastID, next.LastCreated, next.LastName = last.id, last.u.createdAt, last.u.user.Name
+	raw, _ := json.Marshal(next)
+	return users, base64.RawURLEncoding.EncodeToString(raw), nil
+}